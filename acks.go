@@ -0,0 +1,132 @@
+package wssocket
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// AckStage is a point in a message's lifecycle the server can acknowledge
+type AckStage string
+
+const (
+	AckReceived  AckStage = "received"  // Frame accepted and queued
+	AckProcessed AckStage = "processed" // Handler or default routing finished
+	AckPersisted AckStage = "persisted" // Saved to the server's MessageStore
+)
+
+// ParseAckStages parses a comma-separated list such as "received,persisted"
+func ParseAckStages(list string) ([]AckStage, error) {
+	stages := make([]AckStage, 0)
+	for _, s := range strings.Split(list, ",") {
+		stage := AckStage(strings.TrimSpace(s))
+		switch stage {
+		case "":
+			continue
+		case AckReceived, AckProcessed, AckPersisted:
+			stages = append(stages, stage)
+		default:
+			return nil, fmt.Errorf("unknown ack stage: %s", stage)
+		}
+	}
+	return stages, nil
+}
+
+// SetAckStages chooses which stages are acknowledged for a message type,
+// overriding ServerConfig.AckStages. No stages turns acks off for the type.
+func (s *Server) SetAckStages(msgType MessageType, stages ...AckStage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ackStages[msgType] = stages
+}
+
+// SetMessageStore sets where messages acknowledged as persisted are saved
+func (s *Server) SetMessageStore(store MessageStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = store
+}
+
+// wantsAck reports whether a stage is acknowledged for a message type
+func (s *Server) wantsAck(msgType MessageType, stage AckStage) bool {
+	if msgType == MessageTypeAck {
+		return false
+	}
+
+	s.mu.RLock()
+	stages, overridden := s.ackStages[msgType]
+	s.mu.RUnlock()
+	if !overridden {
+		stages = s.config.AckStages
+	}
+
+	for _, st := range stages {
+		if st == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// sendAck tells the sender a stage was reached. The ack carries the fields
+// the server assigned, so clients can reconcile optimistic copies.
+func (s *Server) sendAck(conn *Connection, msg *Message, stage AckStage, err error) {
+	payload := map[string]interface{}{
+		"stage":      string(stage),
+		"message_id": msg.ID,
+		"type":       string(msg.Type),
+		"timestamp":  msg.Timestamp,
+		"ok":         err == nil,
+	}
+	if seq := MessageSeq(msg); seq > 0 {
+		payload["seq"] = seq
+	}
+	if err != nil {
+		payload["error"] = err.Error()
+	}
+	s.mu.RLock()
+	ids := s.messageIDs
+	remember := s.idempotency != nil
+	s.mu.RUnlock()
+	if remember && ids != nil {
+		ids.recordAck(conn.UserID, msg.ID, payload)
+	}
+
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeAck,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Channel:   msg.Channel,
+		Timestamp: s.now().Unix(),
+		Payload:   payload,
+	})
+}
+
+// ackProcessed sends the processed ack and, if requested, persists the message
+// and sends the persisted ack
+func (s *Server) ackProcessed(conn *Connection, msg *Message, err error) {
+	if s.wantsAck(msg.Type, AckProcessed) {
+		s.sendAck(conn, msg, AckProcessed, err)
+	}
+	if err != nil || !s.wantsAck(msg.Type, AckPersisted) {
+		return
+	}
+
+	s.mu.RLock()
+	store := s.store
+	s.mu.RUnlock()
+	if store == nil {
+		s.sendAck(conn, msg, AckPersisted, fmt.Errorf("no message store configured"))
+		return
+	}
+
+	// Save off the processing loop so slow storage doesn't stall routing
+	go func() {
+		err := store.SaveMessage(msg)
+		if err != nil {
+			log.Printf("persist message %s failed: %v", msg.ID, err)
+		}
+		s.sendAck(conn, msg, AckPersisted, err)
+	}()
+}
@@ -0,0 +1,463 @@
+package wssocket
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Alert message types
+const (
+	MessageTypeAlertAck       MessageType = "alert:ack"       // Client acknowledges an alert, stopping escalation
+	MessageTypeAlertAcked     MessageType = "alert:acked"     // Sent to the alert's audience once acknowledged
+	MessageTypeAlertEscalated MessageType = "alert:escalated" // Sent to webhooks and escalation hooks
+)
+
+// AlertSeverity ranks how urgent an alert is
+type AlertSeverity string
+
+const (
+	AlertInfo     AlertSeverity = "info"
+	AlertWarning  AlertSeverity = "warning"
+	AlertCritical AlertSeverity = "critical"
+)
+
+// alertSeverityRank orders severities from least to most urgent
+var alertSeverityRank = map[AlertSeverity]int{AlertInfo: 1, AlertWarning: 2, AlertCritical: 3}
+
+// ParseAlertSeverity parses a severity; empty means info
+func ParseAlertSeverity(s string) (AlertSeverity, error) {
+	severity := AlertSeverity(strings.ToLower(strings.TrimSpace(s)))
+	if severity == "" {
+		return AlertInfo, nil
+	}
+	if _, ok := alertSeverityRank[severity]; !ok {
+		return "", fmt.Errorf("unknown alert severity: %s", s)
+	}
+	return severity, nil
+}
+
+// AtLeast reports whether the severity is as urgent as other
+func (s AlertSeverity) AtLeast(other AlertSeverity) bool {
+	return alertSeverityRank[s] >= alertSeverityRank[other]
+}
+
+// AlertPolicy controls how alerts in a channel are routed
+type AlertPolicy struct {
+	MinSeverity   AlertSeverity // Alerts below this are dropped; empty delivers all
+	EscalateAt    AlertSeverity // Alerts at or above this escalate unless acknowledged; empty means critical
+	EscalateAfter time.Duration // How long to wait for an acknowledgement; 0 escalates at once
+	DedupWindow   time.Duration // Repeats of an unacknowledged alert within this are folded into it
+}
+
+// DefaultAlertPolicy escalates critical alerts after five minutes and folds repeats within ten
+var DefaultAlertPolicy = AlertPolicy{
+	EscalateAt:    AlertCritical,
+	EscalateAfter: 5 * time.Minute,
+	DedupWindow:   10 * time.Minute,
+}
+
+// alertRetention is how long alerts stay listed after they were last seen
+const alertRetention = 24 * time.Hour
+
+// Alert tracks one raised alert and its repeats
+type Alert struct {
+	ID        string        `json:"id"`
+	Key       string        `json:"key"` // Deduplication key
+	Severity  AlertSeverity `json:"severity"`
+	Channel   string        `json:"channel,omitempty"`
+	Recipient string        `json:"recipient,omitempty"`
+	Sender    string        `json:"sender"`
+	Message   *Message      `json:"message"` // First occurrence
+	Count     int           `json:"count"`
+	FirstSeen time.Time     `json:"first_seen"`
+	LastSeen  time.Time     `json:"last_seen"`
+	AckedBy   string        `json:"acked_by,omitempty"`
+	AckedAt   *time.Time    `json:"acked_at,omitempty"`
+	Escalated bool          `json:"escalated"`
+
+	timer *time.Timer
+}
+
+// alertPolicyRoute applies a policy to a channel or channel pattern
+type alertPolicyRoute struct {
+	channel string
+	policy  AlertPolicy
+}
+
+// AlertManager routes, deduplicates and escalates alert messages
+type AlertManager struct {
+	server *Server
+
+	mu         sync.Mutex
+	policies   []alertPolicyRoute
+	fallback   AlertPolicy
+	alerts     map[string]*Alert // ID -> alert
+	open       map[string]*Alert // Dedup key -> unacknowledged alert
+	onEscalate []func(*Alert)
+}
+
+// globalAlerts handles alert messages (set during init)
+var globalAlerts *AlertManager
+
+// NewAlertManager creates an alert manager delivering through server.
+// fallback applies to channels without their own policy.
+func NewAlertManager(server *Server, fallback AlertPolicy) *AlertManager {
+	return &AlertManager{
+		server:   server,
+		fallback: fallback,
+		alerts:   make(map[string]*Alert),
+		open:     make(map[string]*Alert),
+	}
+}
+
+// SetPolicy sets the alert policy for a channel or channel pattern. An exact
+// channel wins over patterns, and earlier patterns win over later ones.
+func (m *AlertManager) SetPolicy(channel string, policy AlertPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, route := range m.policies {
+		if route.channel == channel {
+			m.policies[i].policy = policy
+			return
+		}
+	}
+	m.policies = append(m.policies, alertPolicyRoute{channel: channel, policy: policy})
+}
+
+// OnEscalate registers a hook run when an alert escalates, e.g. to send a push
+func (m *AlertManager) OnEscalate(fn func(*Alert)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onEscalate = append(m.onEscalate, fn)
+}
+
+// policyFor resolves the alert policy for a channel; m.mu must be held
+func (m *AlertManager) policyFor(channel string) AlertPolicy {
+	var policy AlertPolicy
+	found := false
+	for _, route := range m.policies {
+		if route.channel == channel {
+			return route.policy
+		}
+		if !found && MatchChannel(route.channel, channel) {
+			policy, found = route.policy, true
+		}
+	}
+	if found {
+		return policy
+	}
+	return m.fallback
+}
+
+// alertKey returns the deduplication key for an alert message. Senders can
+// set payload.dedup_key; otherwise repeats must match on audience, severity and content.
+func alertKey(msg *Message, severity AlertSeverity) string {
+	if key, ok := msg.Payload["dedup_key"].(string); ok && key != "" {
+		return msg.Channel + "\x00" + msg.Recipient + "\x00" + key
+	}
+	return msg.Channel + "\x00" + msg.Recipient + "\x00" + string(severity) + "\x00" + messageContent(msg)
+}
+
+// Raise handles an alert message. New alerts are delivered and recorded in
+// the notification center; repeats of an unacknowledged alert only bump its
+// count. It returns the alert, or nil when the alert was below the channel's minimum.
+func (m *AlertManager) Raise(msg *Message) (*Alert, error) {
+	raw, _ := msg.Payload["severity"].(string)
+	severity, err := ParseAlertSeverity(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clients acknowledge by alert ID; set it before the message is shared
+	alertID := "alr_" + uuid.New().String()
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]interface{})
+	}
+	msg.Metadata["alert_id"] = alertID
+	msg.Metadata["severity"] = string(severity)
+
+	now := time.Now()
+	m.mu.Lock()
+	m.pruneLocked(now)
+	policy := m.policyFor(msg.Channel)
+	if policy.MinSeverity != "" && !severity.AtLeast(policy.MinSeverity) {
+		m.mu.Unlock()
+		return nil, nil
+	}
+
+	key := alertKey(msg, severity)
+	if existing, ok := m.open[key]; ok && now.Sub(existing.LastSeen) <= policy.DedupWindow {
+		existing.Count++
+		existing.LastSeen = now
+		snapshot := *existing
+		m.mu.Unlock()
+		return &snapshot, nil
+	}
+
+	alert := &Alert{
+		ID:        alertID,
+		Key:       key,
+		Severity:  severity,
+		Channel:   msg.Channel,
+		Recipient: msg.Recipient,
+		Sender:    msg.Sender,
+		Message:   msg,
+		Count:     1,
+		FirstSeen: now,
+		LastSeen:  now,
+	}
+	m.alerts[alert.ID] = alert
+	m.open[key] = alert
+
+	escalateAt := policy.EscalateAt
+	if escalateAt == "" {
+		escalateAt = AlertCritical
+	}
+	escalate := severity.AtLeast(escalateAt)
+	if escalate && policy.EscalateAfter > 0 {
+		alert.timer = time.AfterFunc(policy.EscalateAfter, func() { m.escalate(alert.ID) })
+	}
+	snapshot := *alert
+	m.mu.Unlock()
+
+	if err := RecordNotification(globalNotifications, m.server.notificationRecipients(msg), msg); err != nil {
+		log.Printf("Error recording alert: %v", err)
+	}
+	m.deliver(msg)
+
+	if escalate && policy.EscalateAfter <= 0 {
+		m.escalate(alert.ID)
+	}
+	return &snapshot, nil
+}
+
+// deliver sends an alert message to its recipient or channel
+func (m *AlertManager) deliver(msg *Message) {
+	if msg.Recipient != "" {
+		m.server.sendToUser(msg.Recipient, msg)
+	} else if msg.Channel != "" {
+		m.server.broadcastToChannel(msg.Channel, msg, &BroadcastOptions{})
+	}
+}
+
+// escalate fires escalation for an alert that is still unacknowledged
+func (m *AlertManager) escalate(alertID string) {
+	m.mu.Lock()
+	alert, exists := m.alerts[alertID]
+	if !exists || alert.AckedAt != nil || alert.Escalated {
+		m.mu.Unlock()
+		return
+	}
+	alert.Escalated = true
+	hooks := append([]func(*Alert){}, m.onEscalate...)
+	escalated := m.alertMessage(alert, MessageTypeAlertEscalated)
+	snapshot := *alert
+	m.mu.Unlock()
+
+	log.Printf("Alert %s escalated (%s, seen %d time(s))", snapshot.ID, snapshot.Severity, snapshot.Count)
+
+	m.server.mu.RLock()
+	webhooks := m.server.webhooks
+	m.server.mu.RUnlock()
+	if webhooks != nil {
+		webhooks.Dispatch(escalated)
+	}
+	for _, hook := range hooks {
+		hook(&snapshot)
+	}
+}
+
+// alertMessage builds a lifecycle message about an alert; m.mu must be held
+func (m *AlertManager) alertMessage(alert *Alert, msgType MessageType) *Message {
+	payload := map[string]interface{}{
+		"alert_id":   alert.ID,
+		"severity":   string(alert.Severity),
+		"count":      alert.Count,
+		"first_seen": alert.FirstSeen.Unix(),
+		"last_seen":  alert.LastSeen.Unix(),
+		"alert":      alert.Message.Payload,
+	}
+	if alert.AckedAt != nil {
+		payload["acked_by"] = alert.AckedBy
+		payload["acked_at"] = alert.AckedAt.Unix()
+	}
+	return &Message{
+		ID:        generateMessageID(),
+		Type:      msgType,
+		Sender:    "system",
+		Recipient: alert.Recipient,
+		Channel:   alert.Channel,
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	}
+}
+
+// Ack acknowledges an alert, stopping any pending escalation, and tells the
+// alert's audience who acknowledged it
+func (m *AlertManager) Ack(alertID, userID string) (*Alert, error) {
+	m.mu.Lock()
+	alert, exists := m.alerts[alertID]
+	if !exists {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("alert not found: %s", alertID)
+	}
+	if alert.AckedAt != nil {
+		snapshot := *alert
+		m.mu.Unlock()
+		return &snapshot, nil
+	}
+
+	now := time.Now()
+	alert.AckedBy, alert.AckedAt = userID, &now
+	if alert.timer != nil {
+		alert.timer.Stop()
+	}
+	if m.open[alert.Key] == alert {
+		delete(m.open, alert.Key)
+	}
+	acked := m.alertMessage(alert, MessageTypeAlertAcked)
+	snapshot := *alert
+	m.mu.Unlock()
+
+	m.deliver(acked)
+	return &snapshot, nil
+}
+
+// Alerts lists alerts, newest first; openOnly hides acknowledged ones
+func (m *AlertManager) Alerts(openOnly bool) []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alerts := make([]Alert, 0, len(m.alerts))
+	for _, alert := range m.alerts {
+		if openOnly && alert.AckedAt != nil {
+			continue
+		}
+		alerts = append(alerts, *alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].LastSeen.After(alerts[j].LastSeen)
+	})
+	return alerts
+}
+
+// get returns a copy of an alert
+func (m *AlertManager) get(alertID string) (Alert, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	alert, exists := m.alerts[alertID]
+	if !exists {
+		return Alert{}, false
+	}
+	return *alert, true
+}
+
+// inAudience reports whether a user is an alert's recipient, or may follow
+// its channel: anyone for a public channel, otherwise its members
+func (m *AlertManager) inAudience(alert Alert, userID string) bool {
+	if alert.Recipient != "" {
+		return alert.Recipient == userID
+	}
+	if alert.Channel == "" {
+		return false
+	}
+	return m.server.ChannelSettings(alert.Channel).Visibility == VisibilityPublic || m.server.isChannelMember(alert.Channel, userID)
+}
+
+// pruneLocked forgets alerts not seen for alertRetention; m.mu must be held
+func (m *AlertManager) pruneLocked(now time.Time) {
+	for id, alert := range m.alerts {
+		if now.Sub(alert.LastSeen) <= alertRetention {
+			continue
+		}
+		if alert.timer != nil {
+			alert.timer.Stop()
+		}
+		if m.open[alert.Key] == alert {
+			delete(m.open, alert.Key)
+		}
+		delete(m.alerts, id)
+	}
+}
+
+// AlertAckHandler acknowledges an alert sent as payload.alert_id
+func AlertAckHandler(conn *Connection, msg *Message) error {
+	if globalAlerts == nil {
+		return fmt.Errorf("alerting is not enabled")
+	}
+	alertID, _ := msg.Payload["alert_id"].(string)
+	if alertID == "" {
+		return fmt.Errorf("alert_id is required")
+	}
+	if alert, ok := globalAlerts.get(alertID); !ok || (!conn.trusted && !globalAlerts.inAudience(alert, conn.UserID)) {
+		return fmt.Errorf("alert not found: %s", alertID)
+	}
+	_, err := globalAlerts.Ack(alertID, conn.UserID)
+	return err
+}
+
+// setupAlertRoutes registers alert listing and acknowledgement endpoints.
+// Users see and acknowledge the alerts sent to them; one of apiKeys lists all.
+func setupAlertRoutes(mux *http.ServeMux, alerts *AlertManager, apiKeys []string) {
+	// GET lists alerts; ?open=true hides acknowledged ones. Without an admin
+	// key, only ?user_id='s alerts are listed.
+	mux.HandleFunc("/api/alerts", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		list := alerts.Alerts(r.URL.Query().Get("open") == "true")
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+			if !ok {
+				return
+			}
+			visible := list[:0]
+			for _, alert := range list {
+				if alerts.inAudience(alert, userID) {
+					visible = append(visible, alert)
+				}
+			}
+			list = visible
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"alerts": list,
+			"count":  len(list),
+		})
+	})
+
+	// POST /api/alerts/{id}/ack?user_id= acknowledges an alert
+	mux.HandleFunc("/api/alerts/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		alertID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/alerts/"), "/ack")
+		if !ok || alertID == "" {
+			http.Error(w, "POST /api/alerts/{id}/ack?user_id= required", http.StatusBadRequest)
+			return
+		}
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+		if existing, ok := alerts.get(alertID); !ok || !alerts.inAudience(existing, userID) {
+			http.Error(w, "alert not found: "+alertID, http.StatusNotFound)
+			return
+		}
+
+		alert, err := alerts.Ack(alertID, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, alert)
+	})
+}
@@ -0,0 +1,51 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAlertRoutesKeepAlertsToTheirAudience(t *testing.T) {
+	alerts := NewAlertManager(NewServer(ServerConfig{}), AlertPolicy{})
+	now := time.Now()
+	alerts.alerts["alr_alice"] = &Alert{ID: "alr_alice", Severity: AlertCritical, Recipient: "alice", FirstSeen: now, LastSeen: now}
+	alerts.alerts["alr_bob"] = &Alert{ID: "alr_bob", Severity: AlertCritical, Recipient: "bob", FirstSeen: now, LastSeen: now}
+	mux := http.NewServeMux()
+	setupAlertRoutes(mux, alerts, []string{"admin-key"})
+
+	list := func(query, key string) (int, int) {
+		req := httptest.NewRequest(http.MethodGet, "/api/alerts"+query, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		var body struct {
+			Count int `json:"count"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body.Count
+	}
+
+	if code, count := list("?user_id=bob", ""); code != http.StatusOK || count != 1 {
+		t.Fatalf("bob's listing = %d with %d alerts, want 200 with 1", code, count)
+	}
+	if code, count := list("", "admin-key"); code != http.StatusOK || count != 2 {
+		t.Fatalf("admin listing = %d with %d alerts, want 200 with 2", code, count)
+	}
+	if code, _ := list("", ""); code != http.StatusBadRequest {
+		t.Fatalf("anonymous listing = %d, want 400", code)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/alerts/alr_alice/ack?user_id=bob", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("bob acking alice's alert = %d, want 404", rec.Code)
+	}
+	if alert, _ := alerts.get("alr_alice"); alert.AckedAt != nil {
+		t.Fatal("alice's alert was acknowledged by bob")
+	}
+}
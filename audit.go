@@ -0,0 +1,202 @@
+package wssocket
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Audit outcomes recorded for sampled messages
+const (
+	AuditOutcomeRejected    = "rejected"     // Refused by the before hook
+	AuditOutcomeHandled     = "handled"      // Processed by a registered handler
+	AuditOutcomeHandlerFail = "handler_fail" // Handler returned an error or timed out
+	AuditOutcomeRouted      = "routed"       // Delivered by default routing
+	AuditOutcomeRouteFail   = "route_fail"   // Default routing could not deliver
+)
+
+// AuditRecord captures one sampled inbound message and what happened to it
+type AuditRecord struct {
+	Message    *Message      `json:"message"`
+	ConnID     string        `json:"conn_id"`
+	UserID     string        `json:"user_id"`
+	Transport  string        `json:"transport"`
+	Outcome    string        `json:"outcome"`
+	Route      string        `json:"route,omitempty"` // handler, direct, channel or broadcast
+	Error      string        `json:"error,omitempty"`
+	QueuedFor  time.Duration `json:"queued_ns"`
+	Duration   time.Duration `json:"duration_ns"`
+	RecordedAt time.Time     `json:"recorded_at"`
+}
+
+// AuditStore persists sampled audit records
+type AuditStore interface {
+	Record(rec *AuditRecord) error
+	Recent(limit int) ([]*AuditRecord, error)
+}
+
+// InMemoryAuditStore keeps the most recent records in a ring buffer
+type InMemoryAuditStore struct {
+	mu      sync.Mutex
+	records []*AuditRecord
+	next    int
+	full    bool
+}
+
+// NewInMemoryAuditStore creates a ring buffer holding up to capacity records
+func NewInMemoryAuditStore(capacity int) *InMemoryAuditStore {
+	if capacity <= 0 {
+		capacity = 1000
+	}
+	return &InMemoryAuditStore{records: make([]*AuditRecord, capacity)}
+}
+
+// Record stores a record, overwriting the oldest once full
+func (a *InMemoryAuditStore) Record(rec *AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records[a.next] = rec
+	a.next = (a.next + 1) % len(a.records)
+	if a.next == 0 {
+		a.full = true
+	}
+	return nil
+}
+
+// Recent returns up to limit records, newest first
+func (a *InMemoryAuditStore) Recent(limit int) ([]*AuditRecord, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	size := a.next
+	if a.full {
+		size = len(a.records)
+	}
+	if limit <= 0 || limit > size {
+		limit = size
+	}
+
+	out := make([]*AuditRecord, 0, limit)
+	for i := 1; i <= limit; i++ {
+		idx := (a.next - i + len(a.records)) % len(a.records)
+		out = append(out, a.records[idx])
+	}
+	return out, nil
+}
+
+// EraseUser drops the records of messages a user sent or received
+func (a *InMemoryAuditStore) EraseUser(userID string, now int64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	size := a.next
+	if a.full {
+		size = len(a.records)
+	}
+	kept := make([]*AuditRecord, 0, size)
+	for i := size; i >= 1; i-- {
+		rec := a.records[(a.next-i+len(a.records))%len(a.records)]
+		if rec.UserID == userID || (rec.Message != nil && (rec.Message.Sender == userID || rec.Message.Recipient == userID)) {
+			continue
+		}
+		kept = append(kept, rec)
+	}
+	clear(a.records)
+	copy(a.records, kept)
+	a.next = len(kept) % len(a.records)
+	a.full = len(kept) == len(a.records)
+	return nil
+}
+
+// AuditSampler records a percentage of inbound messages to an AuditStore
+type AuditSampler struct {
+	rate  float64 // Fraction of messages sampled, 0 to 1
+	store AuditStore
+}
+
+// NewAuditSampler samples percent (0-100) of inbound messages into store
+func NewAuditSampler(percent float64, store AuditStore) *AuditSampler {
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	return &AuditSampler{rate: percent / 100, store: store}
+}
+
+// sample decides whether the next message is recorded
+func (a *AuditSampler) sample() bool {
+	return a.rate > 0 && rand.Float64() < a.rate
+}
+
+// record builds and stores an audit record
+func (a *AuditSampler) record(conn *Connection, msg *Message, outcome, route string, err error, queuedAt, startedAt time.Time) {
+	rec := &AuditRecord{
+		Message:    msg,
+		ConnID:     conn.ID,
+		UserID:     conn.UserID,
+		Transport:  conn.Transport,
+		Outcome:    outcome,
+		Route:      route,
+		QueuedFor:  startedAt.Sub(queuedAt),
+		Duration:   time.Since(startedAt),
+		RecordedAt: time.Now(),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	a.store.Record(rec)
+}
+
+// auditOutcome classifies a processed message
+func auditOutcome(route string, err error) string {
+	switch {
+	case route == "handler" && err != nil:
+		return AuditOutcomeHandlerFail
+	case route == "handler":
+		return AuditOutcomeHandled
+	case err != nil:
+		return AuditOutcomeRouteFail
+	}
+	return AuditOutcomeRouted
+}
+
+// setupAuditRoutes registers the audit sample listing endpoint. Records hold
+// full envelopes, DMs included, so it needs one of apiKeys.
+func setupAuditRoutes(mux *http.ServeMux, sampler *AuditSampler, apiKeys []string) {
+	mux.HandleFunc("/api/audit", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		limit := DefaultPageLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed <= 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(parsed, MaxPageLimit)
+		}
+
+		records, err := sampler.store.Recent(limit)
+		if err != nil {
+			http.Error(w, "Failed to read audit records", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"records":     records,
+			"count":       len(records),
+			"sample_rate": sampler.rate,
+		})
+	})
+}
@@ -0,0 +1,41 @@
+package wssocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuditRequiresAdminKey(t *testing.T) {
+	sampler := NewAuditSampler(100, NewInMemoryAuditStore(10))
+	conn := newConnection("conn_1", "alice", TransportWebSocket)
+	msg := &Message{ID: "m1", Type: MessageTypeChatPrivate, Sender: "alice", Recipient: "bob", Payload: map[string]interface{}{"content": "secret"}}
+	sampler.record(conn, msg, AuditOutcomeRouted, "direct", nil, time.Now(), time.Now())
+
+	mux := http.NewServeMux()
+	setupAuditRoutes(mux, sampler, []string{"admin-key"})
+
+	tests := []struct {
+		name       string
+		key        string
+		wantStatus int
+	}{
+		{"no key", "", http.StatusUnauthorized},
+		{"wrong key", "nope", http.StatusUnauthorized},
+		{"admin key", "admin-key", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/audit", nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package wssocket
+
+import (
+	"context"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BatchSubprotocol is the WebSocket subprotocol clients offer to receive
+// batched frames: a JSON array of one or more messages per frame
+const BatchSubprotocol = "go-ws.batch.v1"
+
+// Batching defaults
+const (
+	DefaultBatchWindow = 5 * time.Millisecond
+	DefaultBatchSize   = 64
+)
+
+// wantsBatches reports whether a WebSocket client negotiated batched frames
+func wantsBatches(ws *websocket.Conn) bool {
+	return ws.Subprotocol() == BatchSubprotocol
+}
+
+// collectBatch gathers messages queued for a connection within the batch
+// window after first, leaving out expired ones. closed is true if the
+// outbound queue was closed.
+func (s *Server) collectBatch(conn *Connection, first *Message) (batch []*Message, closed bool) {
+	batch = make([]*Message, 0, 8)
+	if !s.dropExpired(first) {
+		batch = append(batch, first)
+	}
+	timer := time.NewTimer(s.config.BatchWindow)
+	defer timer.Stop()
+
+	for len(batch) < s.config.BatchSize {
+		select {
+		case msg := <-conn.outChan:
+			if msg == nil {
+				return batch, true
+			}
+			if !s.dropExpired(msg) {
+				batch = append(batch, msg)
+			}
+		case <-timer.C:
+			return batch, false
+		case <-s.done:
+			return batch, false
+		}
+	}
+	return batch, false
+}
+
+// writeBatch writes messages as one JSON array frame and runs the delivery
+// hooks. The write span links to each traced message in the batch.
+func (s *Server) writeBatch(conn *Connection, ws *websocket.Conn, batch []*Message) error {
+	var links []trace.Link
+	for _, msg := range batch {
+		if msg.span.IsValid() {
+			links = append(links, trace.Link{SpanContext: msg.span})
+		}
+	}
+	if len(links) > 0 {
+		_, span := tracer.Start(context.Background(), "ws.write_batch", trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithLinks(links...), trace.WithAttributes(connectionAttributes(conn)...))
+		span.SetAttributes(attribute.Int("ws.batch.size", len(batch)))
+		defer span.End()
+	}
+
+	var frame interface{} = batch
+	if conn.protocol != nil {
+		envelopes := make([]interface{}, len(batch))
+		for i, msg := range batch {
+			envelopes[i] = s.outgoing(conn, msg)
+		}
+		frame = envelopes
+	}
+
+	ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := ws.WriteJSON(frame); err != nil {
+		for _, msg := range batch {
+			s.deliveryFailed(conn, msg, err)
+		}
+		return err
+	}
+	for _, msg := range batch {
+		s.delivered(conn, msg)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package wssocket
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchMessage is a typical group chat message
+func benchMessage(size int) *Message {
+	return &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeChatGroup,
+		Sender:    "user_123",
+		Channel:   "general",
+		Timestamp: time.Now().Unix(),
+		Payload: map[string]interface{}{
+			"content": strings.Repeat("x", size),
+			"round":   float64(7),
+		},
+		Metadata: map[string]interface{}{"client": "bench"},
+	}
+}
+
+var benchCodecs = []string{CodecJSON, CodecBinary}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	for _, name := range benchCodecs {
+		t.Run(name, func(t *testing.T) {
+			codec, err := codecByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := benchMessage(16)
+			data, err := codec.marshal(want)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			got, err := codec.unmarshal(data)
+			if err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("round trip = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func BenchmarkCodecMarshal(b *testing.B) {
+	for _, name := range benchCodecs {
+		for _, size := range []int{16, 1024} {
+			b.Run(fmt.Sprintf("%s/size=%d", name, size), func(b *testing.B) {
+				codec, _ := codecByName(name)
+				msg := benchMessage(size)
+				data, _ := codec.marshal(msg)
+				b.ReportMetric(float64(len(data)), "frame-bytes")
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := codec.marshal(msg); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkCodecUnmarshal(b *testing.B) {
+	for _, name := range benchCodecs {
+		for _, size := range []int{16, 1024} {
+			b.Run(fmt.Sprintf("%s/size=%d", name, size), func(b *testing.B) {
+				codec, _ := codecByName(name)
+				data, _ := codec.marshal(benchMessage(size))
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := codec.unmarshal(data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// ErrBotNotFound is returned for an ID that names no bot
+var ErrBotNotFound = errors.New("bot not found")
+
+// Bot is an integration account. It authenticates with an API key instead
+// of a user token, is rate limited on its own, and may only post where its
+// scope allows.
+type Bot struct {
+	ID             string   `json:"id"` // The bot's user ID
+	Name           string   `json:"name"`
+	Channels       []string `json:"channels"`        // Channels or channel patterns it may post to
+	DirectMessages bool     `json:"direct_messages"` // Whether it may message users directly
+	KeyHash        string   `json:"-"`
+	CreatedAt      int64    `json:"created_at"`
+}
+
+// mayPost reports whether a bot's scope covers a channel
+func (b *Bot) mayPost(channel string) bool {
+	for _, pattern := range b.Channels {
+		if MatchChannel(pattern, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// botEntry is a registered bot and the rate limit allowance shared by all
+// its connections and publishes
+type botEntry struct {
+	bot    *Bot
+	bucket tokenBucket
+}
+
+// botRegistry keeps the bots in memory, so per-message checks never reach
+// the store
+type botRegistry struct {
+	mu    sync.RWMutex
+	byID  map[string]*botEntry
+	byKey map[string]string // key hash -> bot ID
+}
+
+// get returns the registered bot with a user ID, or nil
+func (r *botRegistry) get(userID string) *botEntry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byID[userID]
+}
+
+// put registers or replaces a bot, keeping the allowance it has
+func (r *botRegistry) put(bot *Bot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.byID == nil {
+		r.byID = make(map[string]*botEntry)
+		r.byKey = make(map[string]string)
+	}
+	if entry, exists := r.byID[bot.ID]; exists {
+		delete(r.byKey, entry.bot.KeyHash)
+		entry.bot = bot
+	} else {
+		r.byID[bot.ID] = &botEntry{bot: bot}
+	}
+	r.byKey[bot.KeyHash] = bot.ID
+}
+
+// remove unregisters a bot
+func (r *botRegistry) remove(userID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if entry, exists := r.byID[userID]; exists {
+		delete(r.byKey, entry.bot.KeyHash)
+		delete(r.byID, userID)
+	}
+}
+
+// forKey returns the bot an API key belongs to
+func (r *botRegistry) forKey(key string) (*Bot, bool) {
+	if key == "" {
+		return nil, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, exists := r.byID[r.byKey[hashUserToken(key)]]
+	if !exists {
+		return nil, false
+	}
+	return entry.bot, true
+}
+
+// IsBot reports whether a user ID belongs to a bot
+func (s *Server) IsBot(userID string) bool {
+	return s.bots.get(userID) != nil
+}
+
+// LoadBots registers the stored bots
+func (s *Server) LoadBots() error {
+	if globalUserStore == nil {
+		return nil
+	}
+	bots, err := globalUserStore.ListBots()
+	if err != nil {
+		return fmt.Errorf("load bots: %w", err)
+	}
+	for _, bot := range bots {
+		s.bots.put(bot)
+	}
+	return nil
+}
+
+// SetBotRateLimit changes the per-bot message rate limit; the zero value
+// removes it
+func (s *Server) SetBotRateLimit(limit RateLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.botRateLimit = limit
+}
+
+// normalizeBotChannels trims and deduplicates a bot's channel scope
+func normalizeBotChannels(channels []string) []string {
+	normalized := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		if channel = strings.TrimSpace(channel); channel != "" && !slices.Contains(normalized, channel) {
+			normalized = append(normalized, channel)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// CreateBot registers a bot and returns its API key. The key is only
+// returned here.
+func (s *Server) CreateBot(name string, channels []string, directMessages bool) (*Bot, string, error) {
+	if globalUserStore == nil {
+		return nil, "", fmt.Errorf("user store not available")
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > MaxDisplayNameLength {
+		return nil, "", fmt.Errorf("a bot name of 1 to %d characters is required", MaxDisplayNameLength)
+	}
+
+	key := newResumeToken()
+	bot := &Bot{
+		ID:             "bot_" + uuid.New().String(),
+		Name:           name,
+		Channels:       normalizeBotChannels(channels),
+		DirectMessages: directMessages,
+		KeyHash:        hashUserToken(key),
+		CreatedAt:      s.now().Unix(),
+	}
+	if err := globalUserStore.SaveBot(bot); err != nil {
+		return nil, "", fmt.Errorf("save bot: %w", err)
+	}
+	profile := defaultUserProfile(bot.ID)
+	profile.DisplayName = name
+	profile.UpdatedAt = bot.CreatedAt
+	if err := globalUserStore.SaveUserProfile(profile); err != nil {
+		log.Printf("Error saving profile of bot %s: %v", bot.ID, err)
+	}
+	s.bots.put(bot)
+	log.Printf("Bot %s (%s) created", bot.ID, name)
+	return bot, key, nil
+}
+
+// UpdateBotScope replaces where a bot may post
+func (s *Server) UpdateBotScope(id string, channels []string, directMessages bool) (*Bot, error) {
+	entry := s.bots.get(id)
+	if entry == nil {
+		return nil, ErrBotNotFound
+	}
+	updated := *entry.bot
+	updated.Channels = normalizeBotChannels(channels)
+	updated.DirectMessages = directMessages
+	if err := globalUserStore.SaveBot(&updated); err != nil {
+		return nil, fmt.Errorf("save bot: %w", err)
+	}
+	s.bots.put(&updated)
+	return &updated, nil
+}
+
+// DeleteBot unregisters a bot and disconnects it
+func (s *Server) DeleteBot(id string) error {
+	if s.bots.get(id) == nil {
+		return ErrBotNotFound
+	}
+	if err := globalUserStore.DeleteBot(id); err != nil {
+		return fmt.Errorf("delete bot: %w", err)
+	}
+	s.bots.remove(id)
+
+	s.mu.RLock()
+	connIDs := make([]string, 0)
+	for connID, conn := range s.connections {
+		if conn.UserID == id {
+			connIDs = append(connIDs, connID)
+		}
+	}
+	s.mu.RUnlock()
+	for _, connID := range connIDs {
+		s.closeConnection(connID, CloseAccountRemoved, "bot deleted")
+	}
+	log.Printf("Bot %s deleted", id)
+	return nil
+}
+
+// allowBot takes a token from a bot's own allowance. Bots don't use the
+// per-connection limit, so one bot can't dodge its limit by connecting
+// again.
+func (s *Server) allowBot(entry *botEntry) (RateLimit, bool) {
+	s.mu.RLock()
+	limit := s.botRateLimit
+	s.mu.RUnlock()
+	return limit, !limit.Enabled() || entry.bucket.allow(limit)
+}
+
+// authorizeBot keeps a bot to the channels in its scope, and to direct
+// messages only when it was allowed them. Joining and reading are not
+// posting.
+func (s *Server) authorizeBot(conn *Connection, msg *Message) error {
+	entry := s.bots.get(conn.UserID)
+	if entry == nil || conn.trusted {
+		return nil
+	}
+	bot := entry.bot
+	if msg.Recipient != "" && !bot.DirectMessages {
+		return fmt.Errorf("bot %s may not send direct messages: %w", bot.ID, ErrForbidden)
+	}
+	if msg.Channel != "" && !readOnlyAllowed[msg.Type] && !bot.mayPost(msg.Channel) {
+		return fmt.Errorf("bot %s may not post to %s: %w", bot.ID, msg.Channel, ErrForbidden)
+	}
+	return nil
+}
+
+// PublishAsBot injects a message from a bot over REST. Unlike Publish, the
+// bot's scope and rate limit apply.
+func (s *Server) PublishAsBot(bot *Bot, msg *Message) error {
+	if msg.Type == "" {
+		return fmt.Errorf("message type is required")
+	}
+	msg.Sender = bot.ID
+	conn := newConnection("pub_"+uuid.New().String()[:12], bot.ID, TransportHTTP)
+	return s.acceptMessage(conn, msg)
+}
+
+// botFromRequest returns the bot whose API key a request carries, in
+// X-API-Key, a bearer header or ?token=
+func (s *Server) botFromRequest(r *http.Request) (*Bot, bool) {
+	key := apiKeyFromRequest(r)
+	if key == "" {
+		key = r.URL.Query().Get("token")
+	}
+	return s.bots.forKey(key)
+}
+
+// SaveBot stores or replaces a bot
+func (s *InMemoryUserStore) SaveBot(bot *Bot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *bot
+	stored.Channels = slices.Clone(bot.Channels)
+	s.bots[bot.ID] = &stored
+	return nil
+}
+
+// DeleteBot removes a bot
+func (s *InMemoryUserStore) DeleteBot(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.bots, id)
+	return nil
+}
+
+// ListBots returns every bot, oldest first
+func (s *InMemoryUserStore) ListBots() ([]*Bot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	bots := make([]*Bot, 0, len(s.bots))
+	for _, bot := range s.bots {
+		copied := *bot
+		copied.Channels = slices.Clone(bot.Channels)
+		bots = append(bots, &copied)
+	}
+	sort.Slice(bots, func(i, j int) bool {
+		if bots[i].CreatedAt != bots[j].CreatedAt {
+			return bots[i].CreatedAt < bots[j].CreatedAt
+		}
+		return bots[i].ID < bots[j].ID
+	})
+	return bots, nil
+}
+
+// setupBotAdminRoutes registers bot management, guarded by the admin API keys
+func setupBotAdminRoutes(mux *http.ServeMux, s *Server, apiKeys []string) {
+	// GET lists bots, POST creates one and returns its API key, PUT replaces
+	// ?id='s scope and DELETE removes ?id=
+	mux.HandleFunc("/api/admin/bots", func(w http.ResponseWriter, r *http.Request) {
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if globalUserStore == nil {
+			http.Error(w, "User store not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		var req struct {
+			Name           string   `json:"name"`
+			Channels       []string `json:"channels"`
+			DirectMessages bool     `json:"direct_messages"`
+		}
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			bots, err := globalUserStore.ListBots()
+			if err != nil {
+				log.Printf("Error listing bots: %v", err)
+				http.Error(w, "Failed to list bots", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"bots":  bots,
+				"count": len(bots),
+			})
+
+		case http.MethodPost:
+			bot, key, err := s.CreateBot(req.Name, req.Channels, req.DirectMessages)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, http.StatusCreated, map[string]interface{}{
+				"bot":     bot,
+				"api_key": key,
+			})
+
+		case http.MethodPut:
+			bot, err := s.UpdateBotScope(r.URL.Query().Get("id"), req.Channels, req.DirectMessages)
+			if errors.Is(err, ErrBotNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error updating bot: %v", err)
+				http.Error(w, "Failed to update bot", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, bot)
+
+		case http.MethodDelete:
+			err := s.DeleteBot(r.URL.Query().Get("id"))
+			if errors.Is(err, ErrBotNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error deleting bot: %v", err)
+				http.Error(w, "Failed to delete bot", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
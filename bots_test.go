@@ -0,0 +1,28 @@
+package wssocket
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBotIDCannotBeRegistered(t *testing.T) {
+	server := NewServer(ServerConfig{})
+	t.Cleanup(server.Stop)
+	users := NewInMemoryUserStore()
+	restore := UseHandlerStores(server, HandlerStores{Users: users})
+	t.Cleanup(restore)
+
+	bot, _, err := server.CreateBot("Deploy bot", nil, false)
+	if err != nil {
+		t.Fatalf("create bot: %v", err)
+	}
+	if _, _, err := server.RegisterUser(bot.ID, "deploy"); !errors.Is(err, ErrUserClaimed) {
+		t.Fatalf("register bot ID: err = %v, want ErrUserClaimed", err)
+	}
+	if err := users.ClaimUsername(bot.ID, "deploy", hashUserToken("token")); !errors.Is(err, ErrUserClaimed) {
+		t.Fatalf("claim bot ID in the store: err = %v, want ErrUserClaimed", err)
+	}
+	if _, err := authenticateUser(bot.ID, "token"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("authenticate as the bot with a user token: err = %v, want ErrUnauthorized", err)
+	}
+}
@@ -0,0 +1,307 @@
+package wssocket
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Challenge defaults
+const (
+	DefaultChallengeDifficulty = 18              // Leading zero bits; about 260k hashes
+	DefaultChallengeTTL        = 2 * time.Minute // How long a challenge may be solved and used
+	maxChallengeNonce          = 64              // Longest nonce a client may send
+)
+
+// Challenge errors
+var (
+	ErrChallengeRequired = errors.New("challenge required")
+	ErrChallengeInvalid  = errors.New("challenge solution invalid")
+)
+
+// ChallengeConfig decides when a connect must first prove it is worth
+// serving, by solving a proof-of-work challenge or passing a CAPTCHA. It only
+// applies to IPs over one of the thresholds.
+type ChallengeConfig struct {
+	ConnectRate RateLimit       // Connects per IP beyond which each one must be challenged; none by default
+	Connections int             // Open connections per IP beyond which new ones are challenged; 0 means none
+	Difficulty  int             // Leading zero bits a solution's hash needs; 0 uses DefaultChallengeDifficulty
+	TTL         time.Duration   // How long a challenge stays valid; 0 uses DefaultChallengeTTL
+	Secret      []byte          // Signs challenges; share it across nodes. Random per process when empty.
+	Captcha     CaptchaVerifier // Accepts a CAPTCHA token instead of proof of work when set
+}
+
+// Enabled reports whether any threshold is set
+func (c ChallengeConfig) Enabled() bool {
+	return c.ConnectRate.Enabled() || c.Connections > 0
+}
+
+// withDefaults fills in the difficulty, TTL and a random secret
+func (c ChallengeConfig) withDefaults() ChallengeConfig {
+	if c.Difficulty <= 0 {
+		c.Difficulty = DefaultChallengeDifficulty
+	}
+	if c.TTL <= 0 {
+		c.TTL = DefaultChallengeTTL
+	}
+	if len(c.Secret) == 0 {
+		c.Secret = make([]byte, 32)
+		rand.Read(c.Secret)
+	}
+	return c
+}
+
+// Challenge is a proof-of-work puzzle for one client IP. A solution is a
+// nonce for which SHA-256("<token>:<nonce>") starts with Difficulty zero bits.
+type Challenge struct {
+	Token      string `json:"challenge"`
+	Difficulty int    `json:"difficulty"`
+	ExpiresAt  int64  `json:"expires_at"` // Unix seconds
+	Captcha    bool   `json:"captcha"`    // A captcha_token is accepted instead
+}
+
+// CaptchaVerifier checks a CAPTCHA token a client sent while connecting
+type CaptchaVerifier interface {
+	VerifyCaptcha(ctx context.Context, token, ip string) error
+}
+
+// SiteVerifyCaptcha checks tokens with a siteverify endpoint, as hCaptcha,
+// reCAPTCHA and Cloudflare Turnstile provide
+type SiteVerifyCaptcha struct {
+	URL    string // e.g. https://hcaptcha.com/siteverify
+	Secret string
+	Client *http.Client // http.DefaultClient when nil
+}
+
+// VerifyCaptcha asks the provider whether token is a passed CAPTCHA
+func (v *SiteVerifyCaptcha) VerifyCaptcha(ctx context.Context, token, ip string) error {
+	form := url.Values{"secret": {v.Secret}, "response": {token}, "remoteip": {ip}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("captcha verify: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("captcha verify: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("%w: captcha rejected %v", ErrChallengeInvalid, result.ErrorCodes)
+	}
+	return nil
+}
+
+// challengeState remembers solved challenges so each is used only once
+type challengeState struct {
+	mu      sync.Mutex
+	used    map[string]int64        // Token -> expiry
+	buckets map[string]*tokenBucket // IP -> connects before challenges start
+}
+
+// NewChallenge issues a challenge bound to a client IP. It is signed rather
+// than stored, so any node sharing ChallengeConfig.Secret can check it.
+func (s *Server) NewChallenge(ip string) Challenge {
+	cfg := s.config.Challenge
+	expiresAt := s.now().Add(cfg.TTL).Unix()
+	salt := make([]byte, 8)
+	rand.Read(salt)
+	payload := ip + "|" + strconv.FormatInt(expiresAt, 10) + "|" + strconv.Itoa(cfg.Difficulty) + "|" + hex.EncodeToString(salt)
+	s.metrics.challengesIssued.Add(1)
+	return Challenge{
+		Token:      base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + s.signChallenge(payload),
+		Difficulty: cfg.Difficulty,
+		ExpiresAt:  expiresAt,
+		Captcha:    cfg.Captcha != nil,
+	}
+}
+
+// signChallenge returns the MAC of a challenge payload
+func (s *Server) signChallenge(payload string) string {
+	mac := hmac.New(sha256.New, s.config.Challenge.Secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// challengeRequired reports whether a connect from ip must pass a challenge.
+// Every connect counts against the rate threshold, solved or not.
+func (s *Server) challengeRequired(ip string) bool {
+	cfg := s.config.Challenge
+	required := false
+	if cfg.Connections > 0 {
+		s.ips.mu.Lock()
+		required = s.ips.open[ip] > cfg.Connections // Includes this connection
+		s.ips.mu.Unlock()
+	}
+	if cfg.ConnectRate.Enabled() {
+		c := &s.challenges
+		c.mu.Lock()
+		if c.buckets == nil {
+			c.buckets = make(map[string]*tokenBucket)
+		}
+		bucket, ok := c.buckets[ip]
+		if !ok {
+			bucket = &tokenBucket{}
+			c.buckets[ip] = bucket
+		}
+		c.mu.Unlock()
+		if !bucket.allow(cfg.ConnectRate) {
+			required = true
+		}
+	}
+	return required
+}
+
+// checkChallenge lets a connect through if its IP is under the thresholds,
+// or it carries a solved challenge or a passed CAPTCHA
+func (s *Server) checkChallenge(r *http.Request, ip string) error {
+	if !s.config.Challenge.Enabled() || !s.challengeRequired(ip) {
+		return nil
+	}
+
+	query := r.URL.Query()
+	token, nonce := query.Get("challenge"), query.Get("nonce")
+	if token == "" {
+		token, nonce = r.Header.Get("X-Challenge"), r.Header.Get("X-Challenge-Nonce")
+	}
+	captcha := query.Get("captcha_token")
+	if captcha == "" {
+		captcha = r.Header.Get("X-Captcha-Token")
+	}
+
+	var err error
+	switch {
+	case token != "":
+		err = s.verifySolution(token, nonce, ip)
+	case captcha != "" && s.config.Challenge.Captcha != nil:
+		err = s.config.Challenge.Captcha.VerifyCaptcha(r.Context(), captcha, ip)
+	default:
+		return ErrChallengeRequired
+	}
+	if err != nil {
+		s.metrics.challengesFailed.Add(1)
+		return err
+	}
+	s.metrics.challengesSolved.Add(1)
+	return nil
+}
+
+// verifySolution checks that a challenge was issued to ip, is unexpired and
+// unused, and that nonce solves it
+func (s *Server) verifySolution(token, nonce, ip string) error {
+	encoded, sig, ok := strings.Cut(token, ".")
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if !ok || err != nil || !hmac.Equal([]byte(sig), []byte(s.signChallenge(string(raw)))) {
+		return fmt.Errorf("%w: bad signature", ErrChallengeInvalid)
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 4 || parts[0] != ip {
+		return fmt.Errorf("%w: issued to another address", ErrChallengeInvalid)
+	}
+	expiresAt, _ := strconv.ParseInt(parts[1], 10, 64)
+	if s.now().Unix() >= expiresAt {
+		return fmt.Errorf("%w: expired", ErrChallengeInvalid)
+	}
+	difficulty, _ := strconv.Atoi(parts[2])
+	if nonce == "" || len(nonce) > maxChallengeNonce || leadingZeroBits(sha256.Sum256([]byte(token+":"+nonce))) < difficulty {
+		return fmt.Errorf("%w: wrong nonce", ErrChallengeInvalid)
+	}
+
+	c := &s.challenges
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, used := c.used[token]; used {
+		return fmt.Errorf("%w: already used", ErrChallengeInvalid)
+	}
+	if c.used == nil {
+		c.used = make(map[string]int64)
+	}
+	c.used[token] = expiresAt
+	return nil
+}
+
+// leadingZeroBits counts the zero bits at the start of a hash
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// pruneChallenges forgets used challenges that have expired and the connect
+// rate of IPs that have been quiet
+func (s *Server) pruneChallenges() {
+	now := s.now().Unix()
+	limit := s.config.Challenge.ConnectRate
+	c := &s.challenges
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for token, expiresAt := range c.used {
+		if expiresAt <= now {
+			delete(c.used, token)
+		}
+	}
+	for ip, bucket := range c.buckets {
+		bucket.mu.Lock()
+		idle := !limit.Enabled() || time.Since(bucket.refilledAt).Seconds()*limit.PerSecond >= limit.burst()
+		bucket.mu.Unlock()
+		if idle {
+			delete(c.buckets, ip)
+		}
+	}
+}
+
+// writeChallenge answers a connect that needs a challenge with 428 and a
+// fresh one for the client to solve
+func (s *Server) writeChallenge(w http.ResponseWriter, ip string, err error) {
+	writeJSON(w, http.StatusPreconditionRequired, map[string]interface{}{
+		"error":     err.Error(),
+		"challenge": s.NewChallenge(ip),
+	})
+}
+
+// setupChallengeRoutes registers the challenge endpoint. Browsers can't read
+// the body of a refused WebSocket upgrade, so they fetch a challenge here,
+// solve it and connect with it.
+func setupChallengeRoutes(mux *http.ServeMux, s *Server) {
+	mux.HandleFunc("/api/challenge", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !s.config.Challenge.Enabled() {
+			http.Error(w, "Challenges not enabled", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.NewChallenge(s.clientIP(r)))
+	})
+}
@@ -0,0 +1,161 @@
+package wssocket
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrChannelFull is returned when joining a channel that has reached its
+// member limit
+var ErrChannelFull = errors.New("channel is full")
+
+// ChannelFullError reports a join refused by a channel's member limit
+type ChannelFullError struct {
+	Channel    string `json:"channel"`
+	MaxMembers int    `json:"max_members"`
+	Members    int    `json:"members"`
+	Position   int    `json:"position"` // Place on the waitlist, from 1
+}
+
+func (e *ChannelFullError) Error() string {
+	return fmt.Sprintf("%s is full (%d of %d members)", e.Channel, e.Members, e.MaxMembers)
+}
+
+func (e *ChannelFullError) Unwrap() error {
+	return ErrChannelFull
+}
+
+// memberLimit returns the member limit a connection's join must respect, 0
+// when none applies. Moderators, owners and trusted connections always get in.
+func (s *Server) memberLimit(conn *Connection, channel string) int {
+	if IsChannelPattern(channel) || conn.trusted {
+		return 0
+	}
+	limit := s.ChannelSettings(channel).MaxMembers
+	if limit <= 0 || s.ChannelRole(channel, conn.UserID).AtLeast(RoleModerator) {
+		return 0
+	}
+	return limit
+}
+
+// channelFullLocked checks whether a channel already has limit distinct
+// users subscribed. A user joining from another device isn't counted twice.
+// s.mu must be held.
+func (s *Server) channelFullLocked(channel string, conn *Connection, limit int) *ChannelFullError {
+	if limit <= 0 {
+		return nil
+	}
+	users := make(map[string]bool)
+	for connID := range s.channels[channel] {
+		member, exists := s.connections[connID]
+		if !exists {
+			continue
+		}
+		if member.UserID == conn.UserID {
+			return nil
+		}
+		users[member.UserID] = true
+	}
+	if len(users) < limit {
+		return nil
+	}
+	return &ChannelFullError{Channel: channel, MaxMembers: limit, Members: len(users)}
+}
+
+// waitlistLocked puts a connection on a channel's waitlist, once, and
+// returns its place. Disconnected entries are dropped on the way; s.mu must
+// be held.
+func (s *Server) waitlistLocked(channel, connID string) int {
+	if s.waitlists == nil {
+		s.waitlists = make(map[string][]string)
+	}
+	waiting := s.waitlists[channel][:0]
+	position := 0
+	for _, id := range s.waitlists[channel] {
+		if _, exists := s.connections[id]; !exists {
+			continue
+		}
+		waiting = append(waiting, id)
+		if id == connID {
+			position = len(waiting)
+		}
+	}
+	if position == 0 {
+		waiting = append(waiting, connID)
+		position = len(waiting)
+	}
+	s.waitlists[channel] = waiting
+	return position
+}
+
+// leaveWaitlistLocked takes a connection that got in off a channel's
+// waitlist; s.mu must be held
+func (s *Server) leaveWaitlistLocked(channel, connID string) {
+	waiting := s.waitlists[channel]
+	for i, id := range waiting {
+		if id == connID {
+			s.waitlists[channel] = append(waiting[:i], waiting[i+1:]...)
+			break
+		}
+	}
+	if len(s.waitlists[channel]) == 0 {
+		delete(s.waitlists, channel)
+	}
+}
+
+// nextWaitingLocked takes the first still connected connection off a
+// channel's waitlist; s.mu must be held
+func (s *Server) nextWaitingLocked(channel string) *Connection {
+	waiting := s.waitlists[channel]
+	for len(waiting) > 0 {
+		conn, exists := s.connections[waiting[0]]
+		waiting = waiting[1:]
+		if exists {
+			s.waitlists[channel] = waiting
+			return conn
+		}
+	}
+	delete(s.waitlists, channel)
+	return nil
+}
+
+// sendWaitlist tells a connection it was put on a full channel's waitlist
+func (s *Server) sendWaitlist(conn *Connection, full *ChannelFullError) {
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeChannelWaitlist,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Channel:   full.Channel,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"status":      "waiting",
+			"code":        "channel_full",
+			"max_members": full.MaxMembers,
+			"members":     full.Members,
+			"position":    full.Position,
+		},
+	})
+}
+
+// admitWaiting tells the first connection waiting for a channel that a
+// place opened up, so it can try joining again
+func (s *Server) admitWaiting(channel string) {
+	s.mu.Lock()
+	conn := s.nextWaitingLocked(channel)
+	s.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeChannelWaitlist,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Channel:   channel,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"status": "open",
+		},
+	})
+}
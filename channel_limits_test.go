@@ -0,0 +1,138 @@
+package wssocket
+
+import (
+	"errors"
+	"testing"
+)
+
+// newLimitedChannelServer runs a server whose "room" channel takes one user
+func newLimitedChannelServer(t *testing.T) *Server {
+	t.Helper()
+	server := NewServer(ServerConfig{})
+	t.Cleanup(server.Stop)
+	channels := NewInMemoryChannelStore()
+	restore := UseHandlerStores(server, HandlerStores{Channels: channels})
+	t.Cleanup(restore)
+	if err := channels.SaveChannelSettings(&ChannelSettings{Channel: "room", MaxMembers: 1, Visibility: VisibilityPublic}); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// connectTo registers a connection for a user
+func connectTo(t *testing.T, server *Server, connID, userID string) *Connection {
+	t.Helper()
+	conn := newConnection(connID, userID, TransportWebSocket)
+	if err := server.registerConnection(conn, nil); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// lastWaitlistStatus drains a connection's queue and returns the status of
+// the last waitlist message, "" when there was none
+func lastWaitlistStatus(conn *Connection) string {
+	status := ""
+	for len(conn.outChan) > 0 {
+		msg := <-conn.outChan
+		if msg.Type == MessageTypeChannelWaitlist {
+			status, _ = msg.Payload["status"].(string)
+		}
+	}
+	return status
+}
+
+func TestChannelMemberLimit(t *testing.T) {
+	server := newLimitedChannelServer(t)
+	alice := connectTo(t, server, "conn_alice", "alice")
+	aliceTablet := connectTo(t, server, "conn_alice_tablet", "alice")
+	bob := connectTo(t, server, "conn_bob", "bob")
+	carol := connectTo(t, server, "conn_carol", "carol")
+
+	if err := server.SubscribeToChannel(alice.ID, "room"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SubscribeToChannel(aliceTablet.ID, "room"); err != nil {
+		t.Fatalf("a second device of a member: %v", err)
+	}
+
+	var full *ChannelFullError
+	if err := server.SubscribeToChannel(bob.ID, "room"); !errors.As(err, &full) || full.Position != 1 {
+		t.Fatalf("bob joining a full channel: err = %v, want a waitlist place of 1", err)
+	}
+	if err := server.SubscribeToChannel(carol.ID, "room"); !errors.As(err, &full) || full.Position != 2 {
+		t.Fatalf("carol joining a full channel: err = %v, want a waitlist place of 2", err)
+	}
+	if status := lastWaitlistStatus(bob); status != "waiting" {
+		t.Fatalf("bob's waitlist status = %q, want waiting", status)
+	}
+	lastWaitlistStatus(carol)
+
+	// Alice leaves from both devices: bob, first in line, is told
+	server.UnsubscribeFromChannel(aliceTablet.ID, "room")
+	server.UnsubscribeFromChannel(alice.ID, "room")
+	if status := lastWaitlistStatus(bob); status != "open" {
+		t.Fatalf("bob's waitlist status = %q, want open", status)
+	}
+	if status := lastWaitlistStatus(carol); status != "" {
+		t.Fatalf("carol was told %q before her turn", status)
+	}
+	if err := server.SubscribeToChannel(bob.ID, "room"); err != nil {
+		t.Fatalf("bob joining after a place opened: %v", err)
+	}
+}
+
+func TestChannelWaitlistSkipsDisconnected(t *testing.T) {
+	server := newLimitedChannelServer(t)
+	alice := connectTo(t, server, "conn_alice", "alice")
+	bob := connectTo(t, server, "conn_bob", "bob")
+	carol := connectTo(t, server, "conn_carol", "carol")
+	dave := connectTo(t, server, "conn_dave", "dave")
+
+	server.SubscribeToChannel(alice.ID, "room")
+	server.SubscribeToChannel(bob.ID, "room")
+	server.SubscribeToChannel(carol.ID, "room")
+	server.removeConnection(bob.ID)
+
+	// Joining the waitlist drops bob, so dave comes right after carol
+	var full *ChannelFullError
+	if err := server.SubscribeToChannel(dave.ID, "room"); !errors.As(err, &full) || full.Position != 2 {
+		t.Fatalf("dave's waitlist place: err = %v, want 2", err)
+	}
+	lastWaitlistStatus(carol)
+
+	server.removeConnection(carol.ID)
+	server.UnsubscribeFromChannel(alice.ID, "room")
+	if status := lastWaitlistStatus(dave); status != "open" {
+		t.Fatalf("dave's waitlist status = %q, want open after skipping those who left", status)
+	}
+	if waiting := server.waitlists["room"]; len(waiting) != 0 {
+		t.Fatalf("waitlist = %v, want it empty", waiting)
+	}
+}
+
+func TestResumeRespectsMemberLimit(t *testing.T) {
+	server := newLimitedChannelServer(t)
+	alice := connectTo(t, server, "conn_alice", "alice")
+	bob := connectTo(t, server, "conn_bob", "bob")
+	server.SubscribeToChannel(alice.ID, "room")
+
+	result := server.ResumeChannel(bob, "room", 0)
+	if result.Code != "channel_full" {
+		t.Fatalf("resume into a full channel = %+v, want channel_full", result)
+	}
+	if bob.InChannel("room") {
+		t.Fatal("resume subscribed bob past the member limit")
+	}
+	if status := lastWaitlistStatus(bob); status != "waiting" {
+		t.Fatalf("bob's waitlist status = %q, want waiting", status)
+	}
+
+	server.UnsubscribeFromChannel(alice.ID, "room")
+	if result := server.ResumeChannel(bob, "room", 0); result.Error != "" {
+		t.Fatalf("resume once a place opened: %s", result.Error)
+	}
+	if waiting := server.waitlists["room"]; len(waiting) != 0 {
+		t.Fatalf("waitlist = %v, want bob off it", waiting)
+	}
+}
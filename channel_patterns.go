@@ -0,0 +1,172 @@
+package wssocket
+
+import "strings"
+
+// Channel patterns split names into segments on "." or ":". In a pattern,
+// "*" or a named placeholder like "{id}" matches exactly one segment and "#"
+// matches zero or more segments, so "orders.*" follows "orders.created" and
+// "user:{id}:#" follows "user:42:inbox:unread".
+
+// IsChannelPattern reports whether a channel name contains wildcards
+func IsChannelPattern(channel string) bool {
+	for _, seg := range channelSegments(channel) {
+		if isWildcardSegment(seg) || seg == "#" {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchChannel reports whether a channel matches a pattern; plain names match only themselves
+func MatchChannel(pattern, channel string) bool {
+	if !IsChannelPattern(pattern) {
+		return pattern == channel
+	}
+	return matchSegments(channelSegments(pattern), channelSegments(channel))
+}
+
+// channelSegments splits a channel name on "." and ":"
+func channelSegments(channel string) []string {
+	return strings.FieldsFunc(channel, func(r rune) bool {
+		return r == '.' || r == ':'
+	})
+}
+
+// isWildcardSegment reports whether a pattern segment matches any single segment
+func isWildcardSegment(seg string) bool {
+	return seg == "*" || (len(seg) > 2 && seg[0] == '{' && seg[len(seg)-1] == '}')
+}
+
+// matchSegments matches channel segments against pattern segments
+func matchSegments(pattern, channel []string) bool {
+	if len(pattern) == 0 {
+		return len(channel) == 0
+	}
+	if pattern[0] == "#" {
+		for i := 0; i <= len(channel); i++ {
+			if matchSegments(pattern[1:], channel[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(channel) == 0 {
+		return false
+	}
+	if isWildcardSegment(pattern[0]) || pattern[0] == channel[0] {
+		return matchSegments(pattern[1:], channel[1:])
+	}
+	return false
+}
+
+// patternNode is a node in the subscription trie, keyed by pattern segment
+type patternNode struct {
+	children map[string]*patternNode
+	any      *patternNode    // "*" or "{name}"
+	rest     *patternNode    // "#"
+	subs     map[string]bool // connID -> true for patterns ending here
+}
+
+func newPatternNode() *patternNode {
+	return &patternNode{children: make(map[string]*patternNode), subs: make(map[string]bool)}
+}
+
+// patternTrie indexes pattern subscriptions so a broadcast only walks the
+// branches its channel can match; guarded by Server.mu
+type patternTrie struct {
+	root *patternNode
+}
+
+func newPatternTrie() *patternTrie {
+	return &patternTrie{root: newPatternNode()}
+}
+
+// child returns the node for a segment, creating it if asked
+func (n *patternNode) child(seg string, create bool) *patternNode {
+	var next **patternNode
+	switch {
+	case seg == "#":
+		next = &n.rest
+	case isWildcardSegment(seg):
+		next = &n.any
+	default:
+		child, exists := n.children[seg]
+		if !exists && create {
+			child = newPatternNode()
+			n.children[seg] = child
+		}
+		return child
+	}
+	if *next == nil && create {
+		*next = newPatternNode()
+	}
+	return *next
+}
+
+// empty reports whether a node has no subscribers and no children
+func (n *patternNode) empty() bool {
+	return len(n.subs) == 0 && len(n.children) == 0 && n.any == nil && n.rest == nil
+}
+
+// add subscribes a connection to a pattern
+func (t *patternTrie) add(pattern, connID string) {
+	node := t.root
+	for _, seg := range channelSegments(pattern) {
+		node = node.child(seg, true)
+	}
+	node.subs[connID] = true
+}
+
+// remove unsubscribes a connection from a pattern, pruning empty branches
+func (t *patternTrie) remove(pattern, connID string) {
+	t.removeFrom(t.root, channelSegments(pattern), connID)
+}
+
+func (t *patternTrie) removeFrom(node *patternNode, segs []string, connID string) {
+	if len(segs) == 0 {
+		delete(node.subs, connID)
+		return
+	}
+	next := node.child(segs[0], false)
+	if next == nil {
+		return
+	}
+	t.removeFrom(next, segs[1:], connID)
+	if !next.empty() {
+		return
+	}
+
+	switch {
+	case segs[0] == "#":
+		node.rest = nil
+	case isWildcardSegment(segs[0]):
+		node.any = nil
+	default:
+		delete(node.children, segs[0])
+	}
+}
+
+// match collects the connections whose patterns match a channel
+func (t *patternTrie) match(channel string, out map[string]bool) {
+	t.matchFrom(t.root, channelSegments(channel), out)
+}
+
+func (t *patternTrie) matchFrom(node *patternNode, segs []string, out map[string]bool) {
+	if node.rest != nil {
+		for i := 0; i <= len(segs); i++ {
+			t.matchFrom(node.rest, segs[i:], out)
+		}
+	}
+	if len(segs) == 0 {
+		for connID := range node.subs {
+			out[connID] = true
+		}
+		return
+	}
+	if child, exists := node.children[segs[0]]; exists {
+		t.matchFrom(child, segs[1:], out)
+	}
+	if node.any != nil {
+		t.matchFrom(node.any, segs[1:], out)
+	}
+}
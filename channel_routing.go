@@ -0,0 +1,71 @@
+package wssocket
+
+// channelRoute sends a channel's messages to a handler ahead of type handlers
+type channelRoute struct {
+	channel string               // Channel name or pattern
+	types   map[MessageType]bool // Message types routed; empty routes every type
+	handler Handler
+}
+
+// matches reports whether the route takes a message
+func (r channelRoute) matches(msg *Message) bool {
+	if len(r.types) > 0 && !r.types[msg.Type] {
+		return false
+	}
+	return MatchChannel(r.channel, msg.Channel)
+}
+
+// RegisterChannelHandler routes messages sent to a channel, or to any channel
+// matching a pattern, to handler instead of the handler for their type. Pass
+// types to route only those message types; otherwise every type is routed.
+// An exact channel wins over patterns, and earlier patterns win over later ones.
+// Registering the same channel again replaces its handler.
+func (s *Server) RegisterChannelHandler(channel string, handler Handler, types ...MessageType) {
+	route := channelRoute{channel: channel, types: make(map[MessageType]bool), handler: handler}
+	for _, t := range types {
+		route.types[t] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, existing := range s.channelRoutes {
+		if existing.channel == channel {
+			s.channelRoutes[i] = route
+			return
+		}
+	}
+	s.channelRoutes = append(s.channelRoutes, route)
+}
+
+// UnregisterChannelHandler removes a channel's handler
+func (s *Server) UnregisterChannelHandler(channel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, route := range s.channelRoutes {
+		if route.channel == channel {
+			s.channelRoutes = append(s.channelRoutes[:i], s.channelRoutes[i+1:]...)
+			return
+		}
+	}
+}
+
+// channelHandler returns the channel handler for a message; s.mu must be held
+func (s *Server) channelHandler(msg *Message) (Handler, bool) {
+	if msg.Channel == "" || IsChannelPattern(msg.Channel) {
+		return nil, false
+	}
+
+	var fallback Handler
+	for _, route := range s.channelRoutes {
+		if !route.matches(msg) {
+			continue
+		}
+		if route.channel == msg.Channel {
+			return route.handler, true
+		}
+		if fallback == nil {
+			fallback = route.handler
+		}
+	}
+	return fallback, fallback != nil
+}
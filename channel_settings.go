@@ -0,0 +1,396 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// channelSettingsTTL is how long a node trusts its cached copy of a
+// channel's settings. Changes made on this node apply at once; other nodes
+// pick them up within the TTL.
+const channelSettingsTTL = 5 * time.Second
+
+// Channel settings limits
+const (
+	MaxChannelTopicLength       = 250
+	MaxChannelDescriptionLength = 2000
+	MaxSlowMode                 = 6 * 60 * 60 // Seconds
+)
+
+// ChannelSettings holds the options a channel's owners can change
+type ChannelSettings struct {
+	Channel       string            `json:"channel"`
+	Topic         string            `json:"topic"`
+	Description   string            `json:"description"`
+	BroadcastOnly bool              `json:"broadcast_only"` // Only publishers and above may post
+	SlowMode      int               `json:"slow_mode"`      // Seconds members wait between messages; 0 disables it
+	MaxMembers    int               `json:"max_members"`    // Users subscribed at once; 0 is unlimited
+	Retention     string            `json:"retention"`      // Overrides the retention policy's age, e.g. "7d"; "" keeps it
+	Visibility    ChannelVisibility `json:"visibility"`
+	UpdatedBy     string            `json:"updated_by,omitempty"`
+	UpdatedAt     int64             `json:"updated_at,omitempty"`
+}
+
+// channelOptions are the settings the database keeps in the channels
+// table's settings JSONB column
+type channelOptions struct {
+	BroadcastOnly bool   `json:"broadcast_only,omitempty"`
+	SlowMode      int    `json:"slow_mode,omitempty"`
+	MaxMembers    int    `json:"max_members,omitempty"`
+	Retention     string `json:"retention,omitempty"`
+}
+
+// options returns the settings stored as JSONB
+func (c *ChannelSettings) options() channelOptions {
+	return channelOptions{BroadcastOnly: c.BroadcastOnly, SlowMode: c.SlowMode, MaxMembers: c.MaxMembers, Retention: c.Retention}
+}
+
+// setOptions copies settings loaded from JSONB
+func (c *ChannelSettings) setOptions(opts channelOptions) {
+	c.BroadcastOnly, c.SlowMode, c.MaxMembers, c.Retention = opts.BroadcastOnly, opts.SlowMode, opts.MaxMembers, opts.Retention
+}
+
+// ChannelSettingsUpdate changes some of a channel's settings; nil fields
+// keep their values
+type ChannelSettingsUpdate struct {
+	Topic         *string `json:"topic"`
+	Description   *string `json:"description"`
+	BroadcastOnly *bool   `json:"broadcast_only"`
+	SlowMode      *int    `json:"slow_mode"`
+	MaxMembers    *int    `json:"max_members"`
+	Retention     *string `json:"retention"`
+	Visibility    *string `json:"visibility"`
+}
+
+// role returns the channel role needed to make the update. Moderators may
+// change the topic and description; everything else is up to owners.
+func (u ChannelSettingsUpdate) role() ChannelRole {
+	if u.BroadcastOnly != nil || u.SlowMode != nil || u.MaxMembers != nil || u.Retention != nil || u.Visibility != nil {
+		return RoleOwner
+	}
+	return RoleModerator
+}
+
+// apply validates the update and copies it onto a channel's settings
+func (u ChannelSettingsUpdate) apply(settings *ChannelSettings) error {
+	if u.Topic != nil {
+		topic := strings.TrimSpace(*u.Topic)
+		if utf8.RuneCountInString(topic) > MaxChannelTopicLength {
+			return fmt.Errorf("topic is longer than %d characters", MaxChannelTopicLength)
+		}
+		settings.Topic = topic
+	}
+	if u.Description != nil {
+		if utf8.RuneCountInString(*u.Description) > MaxChannelDescriptionLength {
+			return fmt.Errorf("description is longer than %d characters", MaxChannelDescriptionLength)
+		}
+		settings.Description = *u.Description
+	}
+	if u.BroadcastOnly != nil {
+		settings.BroadcastOnly = *u.BroadcastOnly
+	}
+	if u.SlowMode != nil {
+		if *u.SlowMode < 0 || *u.SlowMode > MaxSlowMode {
+			return fmt.Errorf("slow_mode must be 0 to %d seconds", MaxSlowMode)
+		}
+		settings.SlowMode = *u.SlowMode
+	}
+	if u.MaxMembers != nil {
+		if *u.MaxMembers < 0 {
+			return fmt.Errorf("max_members must not be negative")
+		}
+		settings.MaxMembers = *u.MaxMembers
+	}
+	if u.Retention != nil {
+		if *u.Retention != "" {
+			if age, err := ParseRetentionAge(*u.Retention); err != nil || age < 0 {
+				return fmt.Errorf("invalid retention: %q", *u.Retention)
+			}
+		}
+		settings.Retention = *u.Retention
+	}
+	if u.Visibility != nil {
+		visibility, err := ParseChannelVisibility(*u.Visibility)
+		if err != nil {
+			return err
+		}
+		settings.Visibility = visibility
+	}
+	return nil
+}
+
+// channelSettingsUpdateFromPayload reads the fields a channel:update message sets
+func channelSettingsUpdateFromPayload(payload map[string]interface{}) (ChannelSettingsUpdate, error) {
+	var update ChannelSettingsUpdate
+	for field, dst := range map[string]**string{
+		"topic":       &update.Topic,
+		"description": &update.Description,
+		"retention":   &update.Retention,
+		"visibility":  &update.Visibility,
+	} {
+		raw, present := payload[field]
+		if !present {
+			continue
+		}
+		s, ok := raw.(string)
+		if !ok {
+			return update, fmt.Errorf("%s must be a string", field)
+		}
+		*dst = &s
+	}
+	if raw, present := payload["broadcast_only"]; present {
+		b, ok := raw.(bool)
+		if !ok {
+			return update, fmt.Errorf("broadcast_only must be a boolean")
+		}
+		update.BroadcastOnly = &b
+	}
+	if raw, present := payload["slow_mode"]; present {
+		f, ok := raw.(float64)
+		if !ok || f != math.Trunc(f) || f < 0 || f > MaxSlowMode {
+			return update, fmt.Errorf("slow_mode must be 0 to %d seconds", MaxSlowMode)
+		}
+		seconds := int(f)
+		update.SlowMode = &seconds
+	}
+	if raw, present := payload["max_members"]; present {
+		f, ok := raw.(float64)
+		if !ok || f != math.Trunc(f) || f < 0 || f > math.MaxInt32 {
+			return update, fmt.Errorf("max_members must be a non-negative integer")
+		}
+		members := int(f)
+		update.MaxMembers = &members
+	}
+	return update, nil
+}
+
+// defaultChannelSettings returns the settings of a channel no one has changed
+func defaultChannelSettings(channel string) *ChannelSettings {
+	return &ChannelSettings{Channel: channel, Visibility: VisibilityPublic}
+}
+
+// cachedChannelSettings is a channel's settings as last loaded
+type cachedChannelSettings struct {
+	settings ChannelSettings
+	loadedAt time.Time
+}
+
+// readOnlyAllowed lists the types members of a broadcast-only channel may
+// still send there: joining, reading, acknowledging and moderating
+var readOnlyAllowed = map[MessageType]bool{
+	MessageTypePresence:       true,
+	MessageTypeUserJoined:     true,
+	MessageTypeUserLeft:       true,
+	MessageTypeAck:            true,
+	MessageTypeAlertAck:       true,
+	MessageTypeHistoryRequest: true,
+	MessageTypeSearch:         true,
+	MessageTypeChannelReplay:  true,
+	MessageTypeMessageDelete:  true,
+	MessageTypeChannelRole:    true,
+	MessageTypeChannelKick:    true,
+	MessageTypeChannelUpdate:  true,
+	MessageTypeMessagePin:     true,
+	MessageTypeMessageUnpin:   true,
+	MessageTypeChannelRead:    true,
+	MessageTypeChannelMute:    true,
+
+	// Filters only shape what the sender receives
+	MessageTypeSubscribeFilter: true,
+}
+
+// ChannelSettings returns a channel's settings, the defaults when none are
+// stored or there is no channel store
+func (s *Server) ChannelSettings(channel string) ChannelSettings {
+	s.settingsMu.Lock()
+	cached, exists := s.settingsCache[channel]
+	s.settingsMu.Unlock()
+	if exists && time.Since(cached.loadedAt) < channelSettingsTTL {
+		return cached.settings
+	}
+
+	settings := *defaultChannelSettings(channel)
+	if globalChannelStore != nil {
+		stored, err := globalChannelStore.GetChannelSettings(channel)
+		if err != nil {
+			log.Printf("Error loading settings of %s: %v", channel, err)
+			if exists {
+				return cached.settings
+			}
+			return settings
+		}
+		settings = *stored
+	}
+	s.cacheChannelSettings(settings)
+	return settings
+}
+
+// cacheChannelSettings remembers a channel's settings for channelSettingsTTL.
+// Only channels that exist on this server are cached, until they are
+// destroyed, so looking up arbitrary names doesn't grow the cache.
+func (s *Server) cacheChannelSettings(settings ChannelSettings) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.mu.RLock()
+	_, exists := s.channels[settings.Channel]
+	s.mu.RUnlock()
+	if !exists {
+		return
+	}
+	if s.settingsCache == nil {
+		s.settingsCache = make(map[string]cachedChannelSettings)
+	}
+	s.settingsCache[settings.Channel] = cachedChannelSettings{settings: settings, loadedAt: time.Now()}
+}
+
+// forgetChannelSettings drops a destroyed channel's cached settings
+func (s *Server) forgetChannelSettings(channel string) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	delete(s.settingsCache, channel)
+}
+
+// UpdateChannelSettings stores a channel's settings and tells its subscribers
+func (s *Server) UpdateChannelSettings(settings ChannelSettings, actor string) error {
+	if globalChannelStore == nil {
+		return fmt.Errorf("channel store not available")
+	}
+	settings.UpdatedBy = actor
+	settings.UpdatedAt = s.now().Unix()
+	if err := globalChannelStore.SaveChannelSettings(&settings); err != nil {
+		return fmt.Errorf("save settings of %s: %w", settings.Channel, err)
+	}
+	s.cacheChannelSettings(settings)
+	if settings.SlowMode == 0 {
+		s.slowMode.forget(settings.Channel)
+	}
+
+	s.broadcastToChannel(settings.Channel, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeChannelSettings,
+		Sender:    "system",
+		Channel:   settings.Channel,
+		Timestamp: settings.UpdatedAt,
+		Payload: map[string]interface{}{
+			"topic":          settings.Topic,
+			"description":    settings.Description,
+			"broadcast_only": settings.BroadcastOnly,
+			"slow_mode":      settings.SlowMode,
+			"max_members":    settings.MaxMembers,
+			"retention":      settings.Retention,
+			"visibility":     string(settings.Visibility),
+			"by":             actor,
+		},
+	}, &BroadcastOptions{})
+	return nil
+}
+
+// checkBroadcastOnly refuses posts to a broadcast-only channel from anyone
+// below publisher. Trusted publishes are always allowed.
+func (s *Server) checkBroadcastOnly(conn *Connection, msg *Message) error {
+	if msg.Channel == "" || conn.trusted || readOnlyAllowed[msg.Type] {
+		return nil
+	}
+	if !s.ChannelSettings(msg.Channel).BroadcastOnly {
+		return nil
+	}
+	if s.ChannelRole(msg.Channel, conn.UserID).AtLeast(RolePublisher) {
+		return nil
+	}
+	return fmt.Errorf("%s is broadcast-only and %s is not a publisher", msg.Channel, conn.UserID)
+}
+
+// rejectReadOnly tells the sender a broadcast-only channel refused their message
+func (s *Server) rejectReadOnly(conn *Connection, msg *Message, err error) error {
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeError,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Channel:   msg.Channel,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"code":       "read_only",
+			"error":      err.Error(),
+			"message_id": msg.ID,
+		},
+	})
+	return fmt.Errorf("rejected message %s: %w", msg.ID, err)
+}
+
+// ChannelUpdateHandler changes a channel's settings. Moderators may set the
+// topic and description; owners may change anything.
+func ChannelUpdateHandler(conn *Connection, msg *Message) error {
+	if msg.Channel == "" {
+		return fmt.Errorf("channel is required")
+	}
+	if IsChannelPattern(msg.Channel) {
+		return fmt.Errorf("settings apply to a single channel, not a pattern")
+	}
+	update, err := channelSettingsUpdateFromPayload(msg.Payload)
+	if err != nil {
+		return err
+	}
+	if err := globalServer.requireChannelRole(conn, msg, msg.Channel, update.role()); err != nil {
+		return err
+	}
+	settings := globalServer.ChannelSettings(msg.Channel)
+	if err := update.apply(&settings); err != nil {
+		return err
+	}
+	return globalServer.UpdateChannelSettings(settings, conn.UserID)
+}
+
+// setupChannelSettingsAdminRoutes registers settings changes, guarded by admin API keys
+func setupChannelSettingsAdminRoutes(mux *http.ServeMux, s *Server, apiKeys []string) {
+	// POST changes a channel's settings; omitted fields keep their values
+	mux.HandleFunc("/api/admin/channels/settings", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Channel string `json:"channel"`
+			Actor   string `json:"actor"`
+			ChannelSettingsUpdate
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Channel == "" {
+			http.Error(w, "channel is required", http.StatusBadRequest)
+			return
+		}
+		if IsChannelPattern(req.Channel) {
+			http.Error(w, "settings apply to a single channel, not a pattern", http.StatusBadRequest)
+			return
+		}
+		if req.Actor == "" {
+			req.Actor = "admin"
+		}
+
+		settings := s.ChannelSettings(req.Channel)
+		if err := req.apply(&settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.UpdateChannelSettings(settings, req.Actor); err != nil {
+			log.Printf("Error updating settings of %s: %v", req.Channel, err)
+			http.Error(w, "Failed to update channel settings", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.ChannelSettings(req.Channel))
+	})
+}
@@ -0,0 +1,29 @@
+package wssocket
+
+import "testing"
+
+func TestChannelSettingsCachedOnlyWhileChannelExists(t *testing.T) {
+	server := newLimitedChannelServer(t)
+	alice := connectTo(t, server, "conn_alice", "alice")
+
+	for _, channel := range []string{"nobody-here-1", "nobody-here-2"} {
+		server.ChannelSettings(channel)
+	}
+	if len(server.settingsCache) != 0 {
+		t.Fatalf("cached settings of %d channels that don't exist, want none", len(server.settingsCache))
+	}
+
+	server.SubscribeToChannel(alice.ID, "room")
+	if server.ChannelSettings("room").MaxMembers != 1 {
+		t.Fatal("stored settings of room not loaded")
+	}
+	if _, cached := server.settingsCache["room"]; !cached {
+		t.Fatal("settings of a live channel not cached")
+	}
+
+	// The channel has no GC policy, so the last member leaving destroys it
+	server.UnsubscribeFromChannel(alice.ID, "room")
+	if _, cached := server.settingsCache["room"]; cached {
+		t.Fatal("settings still cached after the channel was destroyed")
+	}
+}
@@ -0,0 +1,216 @@
+package wssocket
+
+import (
+	"strings"
+	"time"
+)
+
+// ChannelPolicy controls when a channel with no subscribers is destroyed
+type ChannelPolicy struct {
+	EmptyTTL time.Duration // How long a channel may stay empty; 0 destroys it immediately
+	Persist  bool          // Never destroy the channel once created
+}
+
+// channelLifecycle reports what a membership change did to a channel
+type channelLifecycle struct {
+	channel   string
+	userID    string // Owner of the connection that joined or left
+	joined    bool
+	left      bool
+	userLeft  bool // The user has no other connection left in the channel
+	created   bool
+	emptied   bool
+	destroyed bool
+}
+
+// SetChannelPolicy sets the GC policy for a channel. A pattern ending in "*"
+// applies to every channel with that prefix, e.g. "match:*"; exact names win
+// over prefixes and the longest prefix wins among prefixes.
+func (s *Server) SetChannelPolicy(pattern string, policy ChannelPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channelPolicies[pattern] = policy
+}
+
+// RegisterOnChannelCreatedHook registers a hook that runs when a channel gets its first subscriber
+func (s *Server) RegisterOnChannelCreatedHook(fn func(channel string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChannelCreated = fn
+}
+
+// RegisterOnChannelEmptyHook registers a hook that runs when a channel loses its last subscriber
+func (s *Server) RegisterOnChannelEmptyHook(fn func(channel string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChannelEmpty = fn
+}
+
+// RegisterOnChannelDestroyedHook registers a hook that runs when a channel is garbage collected
+func (s *Server) RegisterOnChannelDestroyedHook(fn func(channel string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChannelDestroyed = fn
+}
+
+// channelPolicyLocked resolves the policy for a channel; s.mu must be held
+func (s *Server) channelPolicyLocked(channel string) ChannelPolicy {
+	if policy, exists := s.channelPolicies[channel]; exists {
+		return policy
+	}
+
+	best, found := -1, false
+	var policy ChannelPolicy
+	for pattern, p := range s.channelPolicies {
+		prefix, isPrefix := strings.CutSuffix(pattern, "*")
+		if isPrefix && strings.HasPrefix(channel, prefix) && len(prefix) > best {
+			best, policy, found = len(prefix), p, true
+		}
+	}
+	if found {
+		return policy
+	}
+	return s.config.ChannelPolicy
+}
+
+// joinChannelLocked adds a connection to a channel, creating or reviving it; s.mu must be held
+func (s *Server) joinChannelLocked(channel, connID string) channelLifecycle {
+	event := channelLifecycle{channel: channel}
+	if _, exists := s.channels[channel]; !exists {
+		s.channels[channel] = make(map[string]bool)
+		event.created = true
+	}
+	delete(s.channelEmptySince, channel)
+	if conn, exists := s.connections[connID]; exists && !s.channels[channel][connID] {
+		event.userID, event.joined = conn.UserID, true
+	}
+	s.channels[channel][connID] = true
+	return event
+}
+
+// leaveChannelLocked removes a connection from a channel and applies the GC
+// policy once it is empty; s.mu must be held
+func (s *Server) leaveChannelLocked(channel, connID string) channelLifecycle {
+	event := channelLifecycle{channel: channel}
+	members, exists := s.channels[channel]
+	if !exists || !members[connID] {
+		return event
+	}
+
+	delete(members, connID)
+	if conn, exists := s.connections[connID]; exists {
+		event.userID, event.left, event.userLeft = conn.UserID, true, true
+		for id := range members {
+			if other, exists := s.connections[id]; exists && other.UserID == conn.UserID {
+				event.userLeft = false
+				break
+			}
+		}
+	}
+	if len(members) > 0 {
+		return event
+	}
+	event.emptied = true
+
+	policy := s.channelPolicyLocked(channel)
+	switch {
+	case policy.Persist:
+	case policy.EmptyTTL <= 0:
+		delete(s.channels, channel)
+		event.destroyed = true
+	default:
+		since := s.now()
+		s.channelEmptySince[channel] = since
+		s.config.Clock.AfterFunc(policy.EmptyTTL, func() {
+			s.expireChannel(channel, since)
+		})
+	}
+	return event
+}
+
+// expireChannel destroys a channel that stayed empty since the given time
+func (s *Server) expireChannel(channel string, since time.Time) {
+	s.mu.Lock()
+	emptySince, waiting := s.channelEmptySince[channel]
+	if !waiting || !emptySince.Equal(since) || len(s.channels[channel]) > 0 {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.channelEmptySince, channel)
+	delete(s.channels, channel)
+	s.mu.Unlock()
+
+	s.fireChannelLifecycle(channelLifecycle{channel: channel, destroyed: true})
+}
+
+// confirmSubscription tells a connection its subscription state changed. It
+// carries the member count and where replay can start, so a client can
+// fetch anything it missed before the subscription took effect.
+func (s *Server) confirmSubscription(conn *Connection, channel string, msgType MessageType) {
+	payload := map[string]interface{}{
+		"channel": channel,
+		"pattern": IsChannelPattern(channel),
+	}
+	if !IsChannelPattern(channel) {
+		s.mu.RLock()
+		payload["members"] = len(s.channels[channel])
+		s.mu.RUnlock()
+
+		payload["latest_seq"], payload["oldest_seq"] = s.replayWindow(channel)
+		if ec, ephemeral := s.EphemeralChannel(channel); ephemeral {
+			payload["expires_at"] = ec.ExpiresAt
+		}
+	}
+
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      msgType,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Channel:   channel,
+		Timestamp: s.now().Unix(),
+		Payload:   payload,
+	})
+	s.syncDevices(conn, string(msgType), channel, map[string]interface{}{
+		"channel": channel,
+		"pattern": IsChannelPattern(channel),
+	})
+}
+
+// isSubscriptionConfirmation reports whether msg is a channel:subscribed or channel:unsubscribed notice
+func isSubscriptionConfirmation(msg *Message) bool {
+	return msg.Type == MessageTypeChannelSubscribed || msg.Type == MessageTypeChannelUnsubscribed
+}
+
+// fireChannelLifecycle runs the channel hooks for a membership change; s.mu must not be held
+func (s *Server) fireChannelLifecycle(events ...channelLifecycle) {
+	s.mu.RLock()
+	created, empty, destroyed := s.onChannelCreated, s.onChannelEmpty, s.onChannelDestroyed
+	cluster := s.cluster
+	s.mu.RUnlock()
+
+	for _, e := range events {
+		if cluster != nil && e.joined {
+			cluster.channelJoined(e.channel, e.userID)
+		}
+		if cluster != nil && e.left {
+			cluster.channelLeft(e.channel, e.userID)
+		}
+		if e.userLeft {
+			s.admitWaiting(e.channel)
+		}
+		if e.created && created != nil {
+			created(e.channel)
+		}
+		if e.emptied && empty != nil {
+			empty(e.channel)
+		}
+		if e.destroyed {
+			s.dropSequencer(e.channel)
+			s.forgetChannelSettings(e.channel)
+			if destroyed != nil {
+				destroyed(e.channel)
+			}
+		}
+	}
+}
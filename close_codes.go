@@ -0,0 +1,62 @@
+package wssocket
+
+import (
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Application close codes, from the 4000-4999 range the WebSocket protocol
+// leaves to applications. The server also uses the standard codes 1001
+// (shutting down), 1008 (IP banned), 1009 (message too big), 1012
+// (draining) and 1013 (server full or slow consumer).
+const (
+	CloseKicked         = 4000 // An administrator disconnected the connection
+	CloseSessionEnded   = 4001 // The session was logged out
+	CloseAccountRemoved = 4002 // The user was erased or the bot deleted
+	CloseUnauthorized   = 4401 // GraphQL connection_init was refused
+	CloseRateLimited    = 4429 // The client kept sending over the rate limit
+)
+
+// ErrServerFull is returned for a connection over the server's limit
+var ErrServerFull = errors.New("max connections reached")
+
+// maxCloseReason is the longest reason that fits in a close frame
+const maxCloseReason = 123
+
+// ShouldReconnect reports whether a client closed with code may reconnect.
+// After 1013 and 4429 it should back off first. Other codes mean the
+// server doesn't want the client back as it is.
+func ShouldReconnect(code int) bool {
+	switch code {
+	case websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseInternalServerErr,
+		websocket.CloseServiceRestart, websocket.CloseTryAgainLater, CloseRateLimited:
+		return true
+	}
+	return false
+}
+
+// closeCodeFor returns the close code for a connection that couldn't be
+// registered
+func closeCodeFor(err error) int {
+	switch {
+	case errors.Is(err, ErrServerFull):
+		return websocket.CloseTryAgainLater
+	case errors.Is(err, ErrDraining):
+		return websocket.CloseServiceRestart
+	}
+	return websocket.ClosePolicyViolation
+}
+
+// writeCloseFrame sends a close frame, cutting the reason to what fits
+func writeCloseFrame(ws *websocket.Conn, code int, reason string) {
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+		for !utf8.ValidString(reason) {
+			reason = reason[:len(reason)-1]
+		}
+	}
+	ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+}
@@ -0,0 +1,390 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Cluster defaults
+const (
+	DefaultClusterHeartbeat = 5 * time.Second // How often a node renews its membership
+	clusterNodeTTL          = 3               // Missed heartbeats before a node counts as dead
+	clusterVirtualNodes     = 64              // Points per node on the hash ring
+	clusterReceiveWait      = time.Second     // Longest a Receive call blocks
+)
+
+// Cluster coordinates servers that share a ClusterStore. Each node records
+// which users it has connections for, so a direct message for a user on
+// another node is forwarded to that node only, and who is in each channel,
+// so presence lists cover every node. A consistent-hash ring over
+// the live nodes gives every user a home node, which load balancers can use
+// to place a user's connections together.
+type Cluster struct {
+	server    *Server
+	store     ClusterStore
+	self      ClusterNode
+	heartbeat time.Duration
+
+	mu    sync.RWMutex
+	nodes []ClusterNode
+	ring  []ringPoint
+
+	// Held while a user's registration changes, so a quick reconnect can't
+	// reach the store before the disconnect it follows
+	usersMu sync.Mutex
+	users   map[string]int // Local connections per user
+
+	// Likewise for channel members
+	channelsMu sync.Mutex
+	channels   map[string]map[string]int // Channel -> user -> local connections
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// ringPoint is one virtual node on the hash ring
+type ringPoint struct {
+	hash uint64
+	node int // Index into nodes
+}
+
+// clusterEnvelope is a message forwarded to the node a user is connected to
+type clusterEnvelope struct {
+	From    string   `json:"from"`
+	UserID  string   `json:"user_id"`
+	Message *Message `json:"message"`
+}
+
+// JoinCluster registers the server as a node in store, reachable by clients
+// at address, and starts forwarding direct messages across nodes. Call it
+// before the server accepts connections.
+func (s *Server) JoinCluster(store ClusterStore, address string, heartbeat time.Duration) (*Cluster, error) {
+	if heartbeat <= 0 {
+		heartbeat = DefaultClusterHeartbeat
+	}
+	c := &Cluster{
+		server:    s,
+		store:     store,
+		self:      ClusterNode{ID: s.config.NodeID, Address: address},
+		heartbeat: heartbeat,
+		users:     make(map[string]int),
+		channels:  make(map[string]map[string]int),
+		stop:      make(chan struct{}),
+	}
+	// Forget what a previous run under the same ID left behind
+	if err := store.Leave(c.self.ID); err != nil {
+		return nil, fmt.Errorf("join cluster: %w", err)
+	}
+	if err := c.refresh(); err != nil {
+		return nil, fmt.Errorf("join cluster: %w", err)
+	}
+
+	s.mu.Lock()
+	s.cluster = c
+	s.mu.Unlock()
+
+	go c.heartbeatLoop()
+	go c.receiveLoop()
+	return c, nil
+}
+
+// Leave stops the node's cluster work and removes it from the store
+func (c *Cluster) Leave() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	return c.store.Leave(c.self.ID)
+}
+
+// Self returns this node
+func (c *Cluster) Self() ClusterNode {
+	return c.self
+}
+
+// Nodes returns the live nodes as of the last heartbeat
+func (c *Cluster) Nodes() []ClusterNode {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]ClusterNode(nil), c.nodes...)
+}
+
+// HomeNode returns the node a user's connections belong on
+func (c *Cluster) HomeNode(userID string) ClusterNode {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.ring) == 0 {
+		return c.self
+	}
+	h := ringHash(userID)
+	i := sort.Search(len(c.ring), func(i int) bool { return c.ring[i].hash >= h })
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.nodes[c.ring[i].node]
+}
+
+// UserNodes returns the live nodes a user has connections on
+func (c *Cluster) UserNodes(userID string) ([]string, error) {
+	nodeIDs, err := c.store.UserNodes(userID)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	live := nodeIDs[:0]
+	for _, nodeID := range nodeIDs {
+		for _, node := range c.nodes {
+			if node.ID == nodeID {
+				live = append(live, nodeID)
+				break
+			}
+		}
+	}
+	return live, nil
+}
+
+// ringHash places a key on the hash ring
+func ringHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// refresh renews this node's heartbeat and rebuilds the ring from the live nodes
+func (c *Cluster) refresh() error {
+	ttl := c.heartbeat * clusterNodeTTL
+	if err := c.store.Heartbeat(c.self, ttl); err != nil {
+		return err
+	}
+	c.channelsMu.Lock()
+	channels := make([]string, 0, len(c.channels))
+	for channel := range c.channels {
+		channels = append(channels, channel)
+	}
+	c.channelsMu.Unlock()
+	if err := c.store.RefreshChannels(c.self.ID, channels, ttl); err != nil {
+		return err
+	}
+	nodes, err := c.store.Nodes()
+	if err != nil {
+		return err
+	}
+
+	ring := make([]ringPoint, 0, len(nodes)*clusterVirtualNodes)
+	for i, node := range nodes {
+		for v := 0; v < clusterVirtualNodes; v++ {
+			ring = append(ring, ringPoint{hash: ringHash(node.ID + "#" + strconv.Itoa(v)), node: i})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	c.mu.Lock()
+	c.nodes, c.ring = nodes, ring
+	c.mu.Unlock()
+	return nil
+}
+
+// heartbeatLoop keeps the node registered until it leaves or the server stops
+func (c *Cluster) heartbeatLoop() {
+	ticker := time.NewTicker(c.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.refresh(); err != nil {
+				log.Printf("cluster: heartbeat: %v", err)
+			}
+		case <-c.stop:
+			return
+		case <-c.server.done:
+			return
+		}
+	}
+}
+
+// receiveLoop delivers messages other nodes forwarded to this one
+func (c *Cluster) receiveLoop() {
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-c.server.done:
+			return
+		default:
+		}
+
+		data, err := c.store.Receive(c.self.ID, clusterReceiveWait)
+		if err != nil {
+			log.Printf("cluster: receive: %v", err)
+			time.Sleep(clusterReceiveWait)
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		var env clusterEnvelope
+		if err := json.Unmarshal(data, &env); err != nil || env.Message == nil {
+			log.Printf("cluster: dropping malformed message from inbox: %v", err)
+			continue
+		}
+		c.server.sendToLocalUser(env.UserID, env.Message)
+	}
+}
+
+// connected registers a user on this node when its first connection opens
+func (c *Cluster) connected(userID string) {
+	c.usersMu.Lock()
+	defer c.usersMu.Unlock()
+	if c.users[userID]++; c.users[userID] > 1 {
+		return
+	}
+	if err := c.store.AddUser(userID, c.self.ID); err != nil {
+		log.Printf("cluster: register %s: %v", userID, err)
+	}
+}
+
+// disconnected unregisters a user from this node when its last connection closes
+func (c *Cluster) disconnected(userID string) {
+	c.usersMu.Lock()
+	defer c.usersMu.Unlock()
+	if c.users[userID]--; c.users[userID] > 0 {
+		return
+	}
+	delete(c.users, userID)
+	if err := c.store.RemoveUser(userID, c.self.ID); err != nil {
+		log.Printf("cluster: unregister %s: %v", userID, err)
+	}
+}
+
+// channelJoined records a user in a channel when its first connection here joins
+func (c *Cluster) channelJoined(channel, userID string) {
+	c.channelsMu.Lock()
+	defer c.channelsMu.Unlock()
+	if c.channels[channel] == nil {
+		c.channels[channel] = make(map[string]int)
+	}
+	if c.channels[channel][userID]++; c.channels[channel][userID] > 1 {
+		return
+	}
+	if err := c.store.AddChannelUser(channel, userID, c.self.ID, c.heartbeat*clusterNodeTTL); err != nil {
+		log.Printf("cluster: add %s to %s: %v", userID, channel, err)
+	}
+}
+
+// channelLeft removes a user from a channel when its last connection here leaves
+func (c *Cluster) channelLeft(channel, userID string) {
+	c.channelsMu.Lock()
+	defer c.channelsMu.Unlock()
+	members := c.channels[channel]
+	if members[userID] == 0 {
+		return
+	}
+	if members[userID]--; members[userID] > 0 {
+		return
+	}
+	delete(members, userID)
+	if len(members) == 0 {
+		delete(c.channels, channel)
+	}
+	if err := c.store.RemoveChannelUser(channel, userID, c.self.ID); err != nil {
+		log.Printf("cluster: remove %s from %s: %v", userID, channel, err)
+	}
+}
+
+// ChannelUsers returns who is in a channel on the other live nodes
+func (c *Cluster) ChannelUsers(channel string) ([]string, error) {
+	c.mu.RLock()
+	nodeIDs := make([]string, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		if node.ID != c.self.ID {
+			nodeIDs = append(nodeIDs, node.ID)
+		}
+	}
+	c.mu.RUnlock()
+	if len(nodeIDs) == 0 {
+		return nil, nil
+	}
+	return c.store.ChannelUsers(channel, nodeIDs)
+}
+
+// forward sends a message for a user to the other nodes it is connected to,
+// and returns how many there were
+func (c *Cluster) forward(userID string, msg *Message) int {
+	nodeIDs, err := c.UserNodes(userID)
+	if err != nil {
+		log.Printf("cluster: route to %s: %v", userID, err)
+		return 0
+	}
+	var data []byte
+	forwarded := 0
+	for _, nodeID := range nodeIDs {
+		if nodeID == c.self.ID {
+			continue
+		}
+		if data == nil {
+			if data, err = json.Marshal(clusterEnvelope{From: c.self.ID, UserID: userID, Message: msg}); err != nil {
+				log.Printf("cluster: encode message for %s: %v", userID, err)
+				return 0
+			}
+		}
+		if err := c.store.Send(nodeID, data); err != nil {
+			log.Printf("cluster: forward to %s: %v", nodeID, err)
+			continue
+		}
+		forwarded++
+	}
+	return forwarded
+}
+
+// Cluster returns the cluster the server joined; nil when it runs alone
+func (s *Server) Cluster() *Cluster {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cluster
+}
+
+// setupClusterAdminRoutes registers the cluster endpoint
+func setupClusterAdminRoutes(mux *http.ServeMux, s *Server, apiKeys []string) {
+	// GET lists the live nodes; with ?user_id= it shows that user's home
+	// node and the nodes it is connected to
+	mux.HandleFunc("/api/admin/cluster", func(w http.ResponseWriter, r *http.Request) {
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		c := s.Cluster()
+		if c == nil {
+			http.Error(w, "Clustering not enabled", http.StatusServiceUnavailable)
+			return
+		}
+
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"self":  c.Self(),
+				"nodes": c.Nodes(),
+			})
+			return
+		}
+		connected, err := c.UserNodes(userID)
+		if err != nil {
+			log.Printf("Error finding nodes of %s: %v", userID, err)
+			http.Error(w, "Failed to find user", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"user_id":   userID,
+			"home":      c.HomeNode(userID),
+			"connected": connected,
+		})
+	})
+}
@@ -0,0 +1,521 @@
+package wssocket
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clusterUserTTL bounds how long a user's node list outlives its last
+// update, so entries left by a crashed node don't pile up
+const clusterUserTTL = 24 * time.Hour
+
+// ClusterNode is a server taking part in a cluster
+type ClusterNode struct {
+	ID      string `json:"id"`
+	Address string `json:"address,omitempty"` // Where clients reach the node, e.g. wss://ws1.example.com/ws
+}
+
+// ClusterStore is the state nodes share to find each other, the users
+// connected to them and who is in each channel, and the queues they forward
+// messages through. Nodes() must not return nodes whose last heartbeat is
+// older than its ttl, and a node's channel members expire after the ttl
+// given when they were last added or refreshed.
+type ClusterStore interface {
+	Heartbeat(node ClusterNode, ttl time.Duration) error
+	Nodes() ([]ClusterNode, error)
+	Leave(nodeID string) error // Forgets the node and the users and channel members it registered
+
+	AddUser(userID, nodeID string) error
+	RemoveUser(userID, nodeID string) error
+	UserNodes(userID string) ([]string, error) // May include nodes that have since died
+
+	AddChannelUser(channel, userID, nodeID string, ttl time.Duration) error
+	RemoveChannelUser(channel, userID, nodeID string) error
+	RefreshChannels(nodeID string, channels []string, ttl time.Duration) error
+	ChannelUsers(channel string, nodeIDs []string) ([]string, error) // Members on any of nodeIDs
+
+	Send(nodeID string, data []byte) error
+	Receive(nodeID string, wait time.Duration) ([]byte, error) // nil when nothing arrived within wait
+}
+
+// MemoryClusterStore is a ClusterStore for servers in one process, such as
+// tests of multi-node behaviour
+type MemoryClusterStore struct {
+	mu        sync.Mutex
+	nodes     map[string]ClusterNode
+	expires   map[string]time.Time
+	userNodes map[string]map[string]bool       // user -> node IDs
+	nodeUsers map[string]map[string]bool       // node -> user IDs
+	members   map[string]*memoryChannelMembers // node + channel -> members
+	inboxes   map[string]chan []byte
+}
+
+// memoryChannelMembers is who is in a channel on one node
+type memoryChannelMembers struct {
+	users   map[string]bool
+	expires time.Time
+}
+
+// membersKey identifies a channel's members on a node
+func membersKey(nodeID, channel string) string {
+	return nodeID + "\x00" + channel
+}
+
+// NewMemoryClusterStore creates an empty in-memory cluster store
+func NewMemoryClusterStore() *MemoryClusterStore {
+	return &MemoryClusterStore{
+		nodes:     make(map[string]ClusterNode),
+		expires:   make(map[string]time.Time),
+		userNodes: make(map[string]map[string]bool),
+		nodeUsers: make(map[string]map[string]bool),
+		members:   make(map[string]*memoryChannelMembers),
+		inboxes:   make(map[string]chan []byte),
+	}
+}
+
+// Heartbeat marks a node alive for ttl
+func (m *MemoryClusterStore) Heartbeat(node ClusterNode, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodes[node.ID] = node
+	m.expires[node.ID] = time.Now().Add(ttl)
+	return nil
+}
+
+// Nodes returns the live nodes by ID
+func (m *MemoryClusterStore) Nodes() ([]ClusterNode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	nodes := make([]ClusterNode, 0, len(m.nodes))
+	for id, node := range m.nodes {
+		if now.After(m.expires[id]) {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes, nil
+}
+
+// Leave removes a node, its users and its channel members
+func (m *MemoryClusterStore) Leave(nodeID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.nodes, nodeID)
+	delete(m.expires, nodeID)
+	for userID := range m.nodeUsers[nodeID] {
+		m.removeUserLocked(userID, nodeID)
+	}
+	for key := range m.members {
+		if strings.HasPrefix(key, nodeID+"\x00") {
+			delete(m.members, key)
+		}
+	}
+	return nil
+}
+
+// AddUser records that a user has connections on a node
+func (m *MemoryClusterStore) AddUser(userID, nodeID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.userNodes[userID] == nil {
+		m.userNodes[userID] = make(map[string]bool)
+	}
+	if m.nodeUsers[nodeID] == nil {
+		m.nodeUsers[nodeID] = make(map[string]bool)
+	}
+	m.userNodes[userID][nodeID] = true
+	m.nodeUsers[nodeID][userID] = true
+	return nil
+}
+
+// RemoveUser records that a user's last connection on a node closed
+func (m *MemoryClusterStore) RemoveUser(userID, nodeID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeUserLocked(userID, nodeID)
+	return nil
+}
+
+func (m *MemoryClusterStore) removeUserLocked(userID, nodeID string) {
+	delete(m.userNodes[userID], nodeID)
+	if len(m.userNodes[userID]) == 0 {
+		delete(m.userNodes, userID)
+	}
+	delete(m.nodeUsers[nodeID], userID)
+	if len(m.nodeUsers[nodeID]) == 0 {
+		delete(m.nodeUsers, nodeID)
+	}
+}
+
+// UserNodes returns the nodes a user has connections on
+func (m *MemoryClusterStore) UserNodes(userID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	nodeIDs := make([]string, 0, len(m.userNodes[userID]))
+	for nodeID := range m.userNodes[userID] {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Strings(nodeIDs)
+	return nodeIDs, nil
+}
+
+// AddChannelUser records a user in a channel on a node, for ttl
+func (m *MemoryClusterStore) AddChannelUser(channel, userID, nodeID string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := membersKey(nodeID, channel)
+	members, exists := m.members[key]
+	if !exists || time.Now().After(members.expires) {
+		members = &memoryChannelMembers{users: make(map[string]bool)}
+		m.members[key] = members
+	}
+	members.users[userID] = true
+	members.expires = time.Now().Add(ttl)
+	return nil
+}
+
+// RemoveChannelUser records that a user left a channel on a node
+func (m *MemoryClusterStore) RemoveChannelUser(channel, userID, nodeID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := membersKey(nodeID, channel)
+	if members, exists := m.members[key]; exists {
+		delete(members.users, userID)
+		if len(members.users) == 0 {
+			delete(m.members, key)
+		}
+	}
+	return nil
+}
+
+// RefreshChannels extends the expiry of a node's channel members
+func (m *MemoryClusterStore) RefreshChannels(nodeID string, channels []string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	expires := time.Now().Add(ttl)
+	for _, channel := range channels {
+		if members, exists := m.members[membersKey(nodeID, channel)]; exists {
+			members.expires = expires
+		}
+	}
+	return nil
+}
+
+// ChannelUsers returns who is in a channel on any of the given nodes
+func (m *MemoryClusterStore) ChannelUsers(channel string, nodeIDs []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	seen := make(map[string]bool)
+	users := make([]string, 0)
+	for _, nodeID := range nodeIDs {
+		members, exists := m.members[membersKey(nodeID, channel)]
+		if !exists || now.After(members.expires) {
+			continue
+		}
+		for userID := range members.users {
+			if !seen[userID] {
+				seen[userID] = true
+				users = append(users, userID)
+			}
+		}
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// inbox returns a node's queue, creating it on first use
+func (m *MemoryClusterStore) inbox(nodeID string) chan []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inbox, exists := m.inboxes[nodeID]
+	if !exists {
+		inbox = make(chan []byte, 1024)
+		m.inboxes[nodeID] = inbox
+	}
+	return inbox
+}
+
+// Send queues data for a node
+func (m *MemoryClusterStore) Send(nodeID string, data []byte) error {
+	select {
+	case m.inbox(nodeID) <- data:
+		return nil
+	default:
+		return fmt.Errorf("inbox of node %s is full", nodeID)
+	}
+}
+
+// Receive waits for the next data queued for a node
+func (m *MemoryClusterStore) Receive(nodeID string, wait time.Duration) ([]byte, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case data := <-m.inbox(nodeID):
+		return data, nil
+	case <-timer.C:
+		return nil, nil
+	}
+}
+
+// RedisClusterStore shares cluster state through Redis. Live nodes are a
+// sorted set scored by heartbeat expiry, each user has a set of nodes, each
+// node keeps an expiring set of members per channel, and each node reads a
+// list as its inbox.
+type RedisClusterStore struct {
+	client *RedisClient
+	prefix string
+}
+
+// NewRedisClusterStore creates a store whose keys start with prefix,
+// "gows:" by default
+func NewRedisClusterStore(client *RedisClient, prefix string) *RedisClusterStore {
+	if prefix == "" {
+		prefix = "gows:"
+	}
+	return &RedisClusterStore{client: client, prefix: prefix}
+}
+
+func (r *RedisClusterStore) nodesKey() string     { return r.prefix + "cluster:nodes" }
+func (r *RedisClusterStore) addressesKey() string { return r.prefix + "cluster:addresses" }
+func (r *RedisClusterStore) userKey(userID string) string {
+	return r.prefix + "cluster:user:" + userID
+}
+func (r *RedisClusterStore) nodeUsersKey(nodeID string) string {
+	return r.prefix + "cluster:node_users:" + nodeID
+}
+func (r *RedisClusterStore) channelKey(nodeID, channel string) string {
+	return r.prefix + "cluster:channel:" + nodeID + ":" + channel
+}
+func (r *RedisClusterStore) nodeChannelsKey(nodeID string) string {
+	return r.prefix + "cluster:node_channels:" + nodeID
+}
+func (r *RedisClusterStore) inboxKey(nodeID string) string {
+	return r.prefix + "cluster:inbox:" + nodeID
+}
+
+// Heartbeat marks a node alive for ttl
+func (r *RedisClusterStore) Heartbeat(node ClusterNode, ttl time.Duration) error {
+	expires := time.Now().Add(ttl).UnixMilli()
+	if _, err := r.client.Do("HSET", r.addressesKey(), node.ID, node.Address); err != nil {
+		return fmt.Errorf("heartbeat %s: %w", node.ID, err)
+	}
+	if _, err := r.client.Do("ZADD", r.nodesKey(), strconv.FormatInt(expires, 10), node.ID); err != nil {
+		return fmt.Errorf("heartbeat %s: %w", node.ID, err)
+	}
+	return nil
+}
+
+// Nodes returns the live nodes by ID, pruning those that stopped heartbeating
+func (r *RedisClusterStore) Nodes() ([]ClusterNode, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	reply, err := r.client.Do("ZRANGEBYSCORE", r.nodesKey(), "-inf", "("+now)
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	if dead, _ := reply.([]interface{}); len(dead) > 0 {
+		args := []string{"HDEL", r.addressesKey()}
+		for _, id := range dead {
+			args = append(args, fmt.Sprint(id))
+		}
+		r.client.Do(args...)
+		r.client.Do("ZREMRANGEBYSCORE", r.nodesKey(), "-inf", "("+now)
+	}
+
+	reply, err = r.client.Do("ZRANGEBYSCORE", r.nodesKey(), now, "+inf")
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	ids, _ := reply.([]interface{})
+	nodes := make([]ClusterNode, 0, len(ids))
+	if len(ids) == 0 {
+		return nodes, nil
+	}
+	args := []string{"HMGET", r.addressesKey()}
+	for _, id := range ids {
+		args = append(args, fmt.Sprint(id))
+	}
+	reply, err = r.client.Do(args...)
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	addresses, _ := reply.([]interface{})
+	for i, id := range ids {
+		node := ClusterNode{ID: fmt.Sprint(id)}
+		if i < len(addresses) && addresses[i] != nil {
+			node.Address = fmt.Sprint(addresses[i])
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes, nil
+}
+
+// Leave removes a node, its users and its channel members
+func (r *RedisClusterStore) Leave(nodeID string) error {
+	reply, err := r.client.Do("SMEMBERS", r.nodeUsersKey(nodeID))
+	if err != nil {
+		return fmt.Errorf("leave %s: %w", nodeID, err)
+	}
+	users, _ := reply.([]interface{})
+	for _, userID := range users {
+		if _, err := r.client.Do("SREM", r.userKey(fmt.Sprint(userID)), nodeID); err != nil {
+			return fmt.Errorf("leave %s: %w", nodeID, err)
+		}
+	}
+	reply, err = r.client.Do("SMEMBERS", r.nodeChannelsKey(nodeID))
+	if err != nil {
+		return fmt.Errorf("leave %s: %w", nodeID, err)
+	}
+	channels, _ := reply.([]interface{})
+	for _, channel := range channels {
+		if _, err := r.client.Do("DEL", r.channelKey(nodeID, fmt.Sprint(channel))); err != nil {
+			return fmt.Errorf("leave %s: %w", nodeID, err)
+		}
+	}
+	for _, args := range [][]string{
+		{"DEL", r.nodeUsersKey(nodeID)},
+		{"DEL", r.nodeChannelsKey(nodeID)},
+		{"ZREM", r.nodesKey(), nodeID},
+		{"HDEL", r.addressesKey(), nodeID},
+	} {
+		if _, err := r.client.Do(args...); err != nil {
+			return fmt.Errorf("leave %s: %w", nodeID, err)
+		}
+	}
+	return nil
+}
+
+// AddUser records that a user has connections on a node
+func (r *RedisClusterStore) AddUser(userID, nodeID string) error {
+	ttl := fmt.Sprint(clusterUserTTL.Milliseconds())
+	for _, args := range [][]string{
+		{"SADD", r.userKey(userID), nodeID},
+		{"PEXPIRE", r.userKey(userID), ttl},
+		{"SADD", r.nodeUsersKey(nodeID), userID},
+		{"PEXPIRE", r.nodeUsersKey(nodeID), ttl},
+	} {
+		if _, err := r.client.Do(args...); err != nil {
+			return fmt.Errorf("add %s to %s: %w", userID, nodeID, err)
+		}
+	}
+	return nil
+}
+
+// RemoveUser records that a user's last connection on a node closed
+func (r *RedisClusterStore) RemoveUser(userID, nodeID string) error {
+	if _, err := r.client.Do("SREM", r.userKey(userID), nodeID); err != nil {
+		return fmt.Errorf("remove %s from %s: %w", userID, nodeID, err)
+	}
+	if _, err := r.client.Do("SREM", r.nodeUsersKey(nodeID), userID); err != nil {
+		return fmt.Errorf("remove %s from %s: %w", userID, nodeID, err)
+	}
+	return nil
+}
+
+// UserNodes returns the nodes a user has connections on
+func (r *RedisClusterStore) UserNodes(userID string) ([]string, error) {
+	reply, err := r.client.Do("SMEMBERS", r.userKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("find nodes of %s: %w", userID, err)
+	}
+	items, _ := reply.([]interface{})
+	nodeIDs := make([]string, 0, len(items))
+	for _, item := range items {
+		nodeIDs = append(nodeIDs, fmt.Sprint(item))
+	}
+	sort.Strings(nodeIDs)
+	return nodeIDs, nil
+}
+
+// AddChannelUser records a user in a channel on a node, for ttl
+func (r *RedisClusterStore) AddChannelUser(channel, userID, nodeID string, ttl time.Duration) error {
+	ms := fmt.Sprint(ttl.Milliseconds())
+	for _, args := range [][]string{
+		{"SADD", r.channelKey(nodeID, channel), userID},
+		{"PEXPIRE", r.channelKey(nodeID, channel), ms},
+		{"SADD", r.nodeChannelsKey(nodeID), channel},
+		{"PEXPIRE", r.nodeChannelsKey(nodeID), ms},
+	} {
+		if _, err := r.client.Do(args...); err != nil {
+			return fmt.Errorf("add %s to %s on %s: %w", userID, channel, nodeID, err)
+		}
+	}
+	return nil
+}
+
+// RemoveChannelUser records that a user left a channel on a node
+func (r *RedisClusterStore) RemoveChannelUser(channel, userID, nodeID string) error {
+	if _, err := r.client.Do("SREM", r.channelKey(nodeID, channel), userID); err != nil {
+		return fmt.Errorf("remove %s from %s on %s: %w", userID, channel, nodeID, err)
+	}
+	return nil
+}
+
+// RefreshChannels extends the expiry of a node's channel members
+func (r *RedisClusterStore) RefreshChannels(nodeID string, channels []string, ttl time.Duration) error {
+	ms := fmt.Sprint(ttl.Milliseconds())
+	for _, channel := range channels {
+		if _, err := r.client.Do("PEXPIRE", r.channelKey(nodeID, channel), ms); err != nil {
+			return fmt.Errorf("refresh %s on %s: %w", channel, nodeID, err)
+		}
+	}
+	if _, err := r.client.Do("PEXPIRE", r.nodeChannelsKey(nodeID), ms); err != nil {
+		return fmt.Errorf("refresh channels of %s: %w", nodeID, err)
+	}
+	return nil
+}
+
+// ChannelUsers returns who is in a channel on any of the given nodes
+func (r *RedisClusterStore) ChannelUsers(channel string, nodeIDs []string) ([]string, error) {
+	users := make([]string, 0)
+	if len(nodeIDs) == 0 {
+		return users, nil
+	}
+	args := []string{"SUNION"}
+	for _, nodeID := range nodeIDs {
+		args = append(args, r.channelKey(nodeID, channel))
+	}
+	reply, err := r.client.Do(args...)
+	if err != nil {
+		return nil, fmt.Errorf("list members of %s: %w", channel, err)
+	}
+	items, _ := reply.([]interface{})
+	for _, item := range items {
+		users = append(users, fmt.Sprint(item))
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// Send queues data for a node
+func (r *RedisClusterStore) Send(nodeID string, data []byte) error {
+	if _, err := r.client.Do("RPUSH", r.inboxKey(nodeID), string(data)); err != nil {
+		return fmt.Errorf("send to %s: %w", nodeID, err)
+	}
+	return nil
+}
+
+// Receive waits for the next data queued for a node. wait is rounded up to
+// whole seconds for BLPOP and must stay below the client's timeout.
+func (r *RedisClusterStore) Receive(nodeID string, wait time.Duration) ([]byte, error) {
+	seconds := max(int64((wait+time.Second-1)/time.Second), 1)
+	reply, err := r.client.Do("BLPOP", r.inboxKey(nodeID), strconv.FormatInt(seconds, 10))
+	if err == redisNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("receive for %s: %w", nodeID, err)
+	}
+	// [key, value]
+	if items, _ := reply.([]interface{}); len(items) == 2 && items[1] != nil {
+		return []byte(fmt.Sprint(items[1])), nil
+	}
+	return nil, nil
+}
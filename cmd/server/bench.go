@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	wssocket "github.com/Sirtheprogrammer/go-ws-socket"
+)
+
+// BenchConfig describes one benchmark scenario
+type BenchConfig struct {
+	Connections int    // Subscribers in the benchmark channel
+	Messages    int    // Broadcast rounds sent by the publisher
+	PayloadSize int    // Bytes of filler text per message
+	Codec       string // Wire codec: wssocket.CodecJSON or wssocket.CodecBinary
+	Compression bool   // permessage-deflate on server and clients
+	Transport   wssocket.TransportMode
+}
+
+// BenchResult holds the measurements for one scenario
+type BenchResult struct {
+	Config            BenchConfig `json:"config"`
+	Delivered         int         `json:"delivered"`
+	Dropped           int         `json:"dropped"`
+	Duration          string      `json:"duration"`
+	MessagesPerSec    float64     `json:"messages_per_sec"`
+	MessagesPerCore   float64     `json:"messages_per_sec_per_core"`
+	LatencyP50Micros  int64       `json:"latency_p50_us"`
+	LatencyP95Micros  int64       `json:"latency_p95_us"`
+	LatencyP99Micros  int64       `json:"latency_p99_us"`
+	HeapBytesPerConn  int64       `json:"heap_bytes_per_conn"`
+	GoroutinesPerConn float64     `json:"goroutines_per_conn"`
+}
+
+// runBenchCommand parses "bench" subcommand flags and prints results
+func runBenchCommand(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	conns := fs.String("conns", "100,1000", "comma-separated subscriber counts")
+	messages := fs.Int("messages", 200, "broadcast rounds per scenario")
+	size := fs.Int("size", 128, "payload filler bytes per message")
+	compression := fs.String("compression", "off,on", "compression modes to run (off, on)")
+	codecs := fs.String("codec", "json,binary", "comma-separated codecs to run (json, binary)")
+	transports := fs.String("transport", "goroutine", "comma-separated transport modes to run (goroutine, epoll)")
+	asJSON := fs.Bool("json", false, "print results as JSON for regression tracking")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var counts []int
+	for _, c := range strings.Split(*conns, ",") {
+		var n int
+		if _, err := fmt.Sscanf(strings.TrimSpace(c), "%d", &n); err != nil || n <= 0 {
+			return fmt.Errorf("invalid connection count: %q", c)
+		}
+		counts = append(counts, n)
+	}
+
+	// Handlers log every message; keep benchmark output readable
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var modes []wssocket.TransportMode
+	for _, t := range strings.Split(*transports, ",") {
+		mode, err := wssocket.ParseTransportMode(t)
+		if err != nil {
+			return err
+		}
+		modes = append(modes, mode)
+	}
+
+	results := make([]BenchResult, 0)
+	for _, transport := range modes {
+		for _, codec := range strings.Split(*codecs, ",") {
+			for _, mode := range strings.Split(*compression, ",") {
+				for _, n := range counts {
+					res, err := runBroadcastBenchmark(BenchConfig{
+						Connections: n,
+						Messages:    *messages,
+						PayloadSize: *size,
+						Codec:       strings.TrimSpace(codec),
+						Compression: strings.TrimSpace(mode) == "on",
+						Transport:   transport,
+					})
+					if err != nil {
+						return err
+					}
+					results = append(results, res)
+				}
+			}
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	fmt.Printf("%-9s %-6s %-5s %7s %9s %12s %12s %9s %9s %9s %12s %10s\n",
+		"transport", "codec", "comp", "conns", "msgs", "msg/s", "msg/s/core", "p50(us)", "p95(us)", "p99(us)", "heap/conn", "gr/conn")
+	for _, r := range results {
+		comp := "off"
+		if r.Config.Compression {
+			comp = "on"
+		}
+		fmt.Printf("%-9s %-6s %-5s %7d %9d %12.0f %12.0f %9d %9d %9d %12d %10.2f\n",
+			r.Config.Transport, r.Config.Codec, comp, r.Config.Connections, r.Delivered, r.MessagesPerSec, r.MessagesPerCore,
+			r.LatencyP50Micros, r.LatencyP95Micros, r.LatencyP99Micros, r.HeapBytesPerConn, r.GoroutinesPerConn)
+	}
+	return nil
+}
+
+// runBroadcastBenchmark boots an in-process server, subscribes the configured
+// number of clients to one channel and measures broadcast fan-out. Each round
+// waits for every subscriber before the next is sent, so latency reflects
+// fan-out cost rather than queue overflow. Heap per connection includes the
+// in-process client side as well as the server side.
+func runBroadcastBenchmark(cfg BenchConfig) (BenchResult, error) {
+	if _, _, err := wssocket.EncodeFrame(cfg.Codec, &wssocket.Message{}); err != nil {
+		return BenchResult{}, err
+	}
+
+	server := wssocket.NewServer(wssocket.ServerConfig{
+		MaxConnections:    cfg.Connections + 10,
+		EnableCompression: cfg.Compression,
+		TransportMode:     cfg.Transport,
+	})
+	restore := wssocket.UseHandlerStores(server, wssocket.HandlerStores{})
+	defer restore()
+
+	server.RegisterHandler(wssocket.MessageTypeChatGroup, wssocket.GroupChatHandler)
+	go server.ProcessMessages()
+	defer server.Stop()
+
+	const channel = "bench"
+	var nextID int
+	var idMu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idMu.Lock()
+		nextID++
+		connID := fmt.Sprintf("bench_%d", nextID)
+		idMu.Unlock()
+
+		if err := server.HandleConnection(w, r, connID, r.URL.Query().Get("user_id")); err != nil {
+			return
+		}
+		if r.URL.Query().Get("subscribe") == "1" {
+			server.SubscribeToChannel(connID, channel)
+		}
+	}))
+	defer ts.Close()
+
+	dialer := websocket.Dialer{EnableCompression: cfg.Compression}
+	if cfg.Codec == wssocket.CodecBinary {
+		dialer.Subprotocols = []string{wssocket.BinarySubprotocol}
+	}
+	baseURL := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	goroutinesBefore := runtime.NumGoroutine()
+
+	// Every subscriber reports per-message latency on a shared channel
+	type sample struct {
+		round   int
+		latency time.Duration
+	}
+	samples := make(chan sample, cfg.Connections*4)
+	clients := make([]*websocket.Conn, 0, cfg.Connections)
+	defer func() {
+		for _, c := range clients {
+			c.Close()
+		}
+	}()
+
+	for i := 0; i < cfg.Connections; i++ {
+		c, _, err := dialer.Dial(fmt.Sprintf("%s?user_id=sub_%d&subscribe=1", baseURL, i), nil)
+		if err != nil {
+			return BenchResult{}, fmt.Errorf("dial subscriber %d: %w", i, err)
+		}
+		clients = append(clients, c)
+	}
+
+	runtime.GC()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	goroutinesAfter := runtime.NumGoroutine()
+
+	for _, c := range clients {
+		go func(c *websocket.Conn) {
+			for {
+				_, data, err := c.ReadMessage()
+				if err != nil {
+					return
+				}
+				msg, err := wssocket.DecodeFrame(cfg.Codec, data)
+				if err != nil {
+					return
+				}
+				if msg.Type != wssocket.MessageTypeChatGroup {
+					continue // subscription confirmations
+				}
+				sentAt, _ := msg.Payload["sent_at"].(float64)
+				round, _ := msg.Payload["round"].(float64)
+				samples <- sample{round: int(round), latency: time.Since(time.Unix(0, int64(sentAt)))}
+			}
+		}(c)
+	}
+
+	// Wait until the server has registered every subscription
+	deadline := time.Now().Add(10 * time.Second)
+	for len(server.GetActiveUsersInChannel(channel)) < cfg.Connections {
+		if time.Now().After(deadline) {
+			return BenchResult{}, fmt.Errorf("subscribers did not join in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	publisher, _, err := dialer.Dial(baseURL+"?user_id=publisher", nil)
+	if err != nil {
+		return BenchResult{}, fmt.Errorf("dial publisher: %w", err)
+	}
+	defer publisher.Close()
+
+	filler := strings.Repeat("x", cfg.PayloadSize)
+	latencies := make([]time.Duration, 0, cfg.Connections*cfg.Messages)
+	dropped := 0
+	start := time.Now()
+
+	for round := 0; round < cfg.Messages; round++ {
+		frameType, data, err := wssocket.EncodeFrame(cfg.Codec, &wssocket.Message{
+			Type:    wssocket.MessageTypeChatGroup,
+			Channel: channel,
+			Payload: map[string]interface{}{
+				"content": filler,
+				"round":   round,
+				"sent_at": time.Now().UnixNano(),
+			},
+		})
+		if err == nil {
+			err = publisher.WriteMessage(frameType, data)
+		}
+		if err != nil {
+			return BenchResult{}, fmt.Errorf("publish: %w", err)
+		}
+
+		received := 0
+		timeout := time.After(5 * time.Second)
+	collect:
+		for received < cfg.Connections {
+			select {
+			case s := <-samples:
+				if s.round == round {
+					latencies = append(latencies, s.latency)
+					received++
+				}
+			case <-timeout:
+				break collect
+			}
+		}
+		dropped += cfg.Connections - received
+	}
+	elapsed := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) int64 {
+		if len(latencies) == 0 {
+			return 0
+		}
+		idx := int(float64(len(latencies)-1) * p)
+		return latencies[idx].Microseconds()
+	}
+
+	perSec := float64(len(latencies)) / elapsed.Seconds()
+	heapPerConn := int64(0)
+	if after.HeapAlloc > before.HeapAlloc {
+		heapPerConn = int64(after.HeapAlloc-before.HeapAlloc) / int64(cfg.Connections)
+	}
+
+	return BenchResult{
+		Config:            cfg,
+		Delivered:         len(latencies),
+		Dropped:           dropped,
+		Duration:          elapsed.String(),
+		MessagesPerSec:    perSec,
+		MessagesPerCore:   perSec / float64(runtime.GOMAXPROCS(0)),
+		LatencyP50Micros:  percentile(0.50),
+		LatencyP95Micros:  percentile(0.95),
+		LatencyP99Micros:  percentile(0.99),
+		HeapBytesPerConn:  heapPerConn,
+		GoroutinesPerConn: float64(goroutinesAfter-goroutinesBefore) / float64(cfg.Connections),
+	}, nil
+}
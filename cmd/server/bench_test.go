@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	wssocket "github.com/Sirtheprogrammer/go-ws-socket"
+)
+
+// BenchmarkBroadcast measures end-to-end fan-out through an in-process server;
+// each op is one broadcast round delivered to every subscriber
+func BenchmarkBroadcast(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, name := range []string{wssocket.CodecJSON, wssocket.CodecBinary} {
+		for _, compression := range []bool{false, true} {
+			comp := "off"
+			if compression {
+				comp = "on"
+			}
+			b.Run(fmt.Sprintf("%s/compression=%s", name, comp), func(b *testing.B) {
+				res, err := runBroadcastBenchmark(BenchConfig{
+					Connections: 100,
+					Messages:    b.N,
+					PayloadSize: 128,
+					Codec:       name,
+					Compression: compression,
+					Transport:   wssocket.TransportModeGoroutine,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if res.Dropped > 0 {
+					b.Fatalf("%d deliveries dropped", res.Dropped)
+				}
+				b.ReportMetric(res.MessagesPerSec, "deliveries/s")
+				b.ReportMetric(float64(res.LatencyP99Micros), "p99-us")
+				b.ReportMetric(float64(res.HeapBytesPerConn), "heap-B/conn")
+			})
+		}
+	}
+}
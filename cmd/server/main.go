@@ -0,0 +1,623 @@
+// Command server runs the WebSocket server standalone, configured from the
+// environment. See the wssocket package for embedding it in another program.
+//
+// "server bench" runs the in-process benchmark harness and "server migrate"
+// manages the database schema instead.
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	wssocket "github.com/Sirtheprogrammer/go-ws-socket"
+)
+
+func main() {
+	// "go-ws bench" runs the in-process benchmark harness instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBenchCommand(os.Args[2:]); err != nil {
+			log.Fatalf("Benchmark failed: %v", err)
+		}
+		return
+	}
+	// "go-ws migrate [up|down|to N|status]" manages the database schema
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	if err := wssocket.RunStandalone(configFromEnv()); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+}
+
+// configFromEnv reads the standalone server's configuration from the
+// environment, exiting on invalid values
+func configFromEnv() wssocket.StandaloneConfig {
+	var err error
+	cfg := wssocket.StandaloneConfig{
+		RedisURL:       os.Getenv("REDIS_URL"),
+		RedisKeyPrefix: os.Getenv("REDIS_KEY_PREFIX"),
+		ConfigFile:     os.Getenv("CONFIG_FILE"),
+		GRPCAddr:       os.Getenv("GRPC_ADDR"),
+		Routes: wssocket.RouteOptions{
+			SocketIO:   os.Getenv("ENABLE_SOCKETIO") == "true",
+			DemoClient: os.Getenv("DISABLE_DEMO_CLIENT") != "true",
+		},
+	}
+	if keys := os.Getenv("ADMIN_API_KEYS"); keys != "" {
+		cfg.Routes.AdminAPIKeys = strings.Split(keys, ",")
+	}
+	if keys := os.Getenv("PUBLISH_API_KEYS"); keys != "" {
+		cfg.Routes.PublishAPIKeys = strings.Split(keys, ",")
+	}
+
+	// Database
+	cfg.DatabaseURL = os.Getenv("DATABASE_URL")
+	if cfg.DatabaseURL == "" {
+		log.Fatal("DATABASE_URL is required")
+	}
+	if v := os.Getenv("DB_QUERY_TIMEOUT"); v != "" {
+		if cfg.DBQueryTimeout, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid DB_QUERY_TIMEOUT: %v", err)
+		}
+	}
+	if v := os.Getenv("DB_RETRIES"); v != "" {
+		if cfg.DBResilience.Retries, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("Invalid DB_RETRIES: %v", err)
+		}
+	}
+	if v := os.Getenv("DB_BREAKER_THRESHOLD"); v != "" {
+		if cfg.DBResilience.BreakerThreshold, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("Invalid DB_BREAKER_THRESHOLD: %v", err)
+		}
+	}
+	if v := os.Getenv("DB_BREAKER_COOLDOWN"); v != "" {
+		if cfg.DBResilience.BreakerCooldown, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid DB_BREAKER_COOLDOWN: %v", err)
+		}
+	}
+	if v := os.Getenv("DB_QUEUE_SIZE"); v != "" {
+		if cfg.DBResilience.QueueSize, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("Invalid DB_QUEUE_SIZE: %v", err)
+		}
+	}
+
+	// Serve recent channel history and channel members from Redis
+	cfg.RedisCache = os.Getenv("REDIS_CACHE") == "true"
+	if v := os.Getenv("REDIS_CACHE_HISTORY"); v != "" {
+		if cfg.Cache.History, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("Invalid REDIS_CACHE_HISTORY: %v", err)
+		}
+	}
+	if v := os.Getenv("REDIS_CACHE_TTL"); v != "" {
+		if cfg.Cache.TTL, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid REDIS_CACHE_TTL: %v", err)
+		}
+	}
+
+	// Encrypt stored message content when keys are configured
+	keys, err := wssocket.LoadKeyProvider(os.Getenv("ENCRYPTION_KEY_FILE"), os.Getenv("ENCRYPTION_KEYS"),
+		os.Getenv("ENCRYPTION_ACTIVE_KEY"), os.Getenv("ENCRYPTION_CHANNEL_KEYS"))
+	if err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+	if keys != nil {
+		cfg.EncryptionKeys = keys
+	}
+	cfg.LazyRekey = os.Getenv("ENCRYPTION_LAZY_REKEY") == "true"
+
+	// Attachment storage: S3 when a bucket is configured, local disk otherwise
+	cfg.Uploads.MaxUploadSize = 10 << 20
+	if v := os.Getenv("UPLOAD_MAX_BYTES"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.Uploads.MaxUploadSize = parsed
+		}
+	}
+	if v := os.Getenv("UPLOAD_ALLOWED_TYPES"); v != "" {
+		cfg.Uploads.AllowedTypes = strings.Split(v, ",")
+	}
+	if bucket := os.Getenv("UPLOAD_S3_BUCKET"); bucket != "" {
+		cfg.Blobs = wssocket.NewS3BlobStore(
+			os.Getenv("UPLOAD_S3_ENDPOINT"),
+			os.Getenv("UPLOAD_S3_REGION"),
+			bucket,
+			os.Getenv("UPLOAD_S3_ACCESS_KEY"),
+			os.Getenv("UPLOAD_S3_SECRET_KEY"),
+		)
+	} else {
+		uploadDir := os.Getenv("UPLOAD_DIR")
+		if uploadDir == "" {
+			uploadDir = "uploads"
+		}
+		local, err := wssocket.NewLocalBlobStore(uploadDir)
+		if err != nil {
+			log.Fatalf("Failed to initialize upload storage: %v", err)
+		}
+		cfg.Blobs = local
+	}
+
+	// Retention
+	if defaultAge := os.Getenv("RETENTION_DEFAULT"); defaultAge != "" {
+		if cfg.RetentionDefault, err = wssocket.ParseRetentionPolicy(defaultAge); err != nil {
+			log.Fatalf("Invalid RETENTION_DEFAULT: %v", err)
+		}
+	}
+	if v := os.Getenv("RETENTION_INTERVAL"); v != "" {
+		if cfg.RetentionInterval, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid RETENTION_INTERVAL: %v", err)
+		}
+	}
+	if cfg.RetentionPolicies, err = wssocket.ParseRetentionPolicies(os.Getenv("RETENTION_POLICIES")); err != nil {
+		log.Fatalf("Invalid RETENTION_POLICIES: %v", err)
+	}
+	if v := os.Getenv("PURGE_DELETED_AFTER"); v != "" {
+		purgeAfter, err := wssocket.ParseRetentionAge(v)
+		if err != nil {
+			log.Fatalf("Invalid PURGE_DELETED_AFTER: %v", err)
+		}
+		cfg.PurgeDeletedAfter = &purgeAfter
+	}
+
+	// Export message pipeline traces when an OTLP collector is configured
+	cfg.Tracing = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+
+	cfg.Server = serverConfigFromEnv()
+
+	// User data exports are kept in the upload blob store until they expire
+	cfg.ExportTTL = wssocket.DefaultExportTTL
+	if v := os.Getenv("EXPORT_TTL"); v != "" {
+		if cfg.ExportTTL, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid EXPORT_TTL: %v", err)
+		}
+	}
+
+	// Resumable sessions and clustering
+	cfg.SessionStore = os.Getenv("SESSION_STORE")
+	if v := os.Getenv("SESSION_TTL"); v != "" {
+		if cfg.SessionTTL, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid SESSION_TTL: %v", err)
+		}
+	}
+	cfg.ClusterStore = os.Getenv("CLUSTER_STORE")
+	cfg.ClusterAddress = os.Getenv("CLUSTER_ADDRESS")
+	if v := os.Getenv("CLUSTER_HEARTBEAT"); v != "" {
+		if cfg.ClusterHeartbeat, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid CLUSTER_HEARTBEAT: %v", err)
+		}
+	}
+
+	// Reject replayed frames: stale or future timestamps and reused message IDs
+	if skewEnv, windowEnv := os.Getenv("REPLAY_MAX_SKEW"), os.Getenv("REPLAY_WINDOW"); skewEnv != "" || windowEnv != "" {
+		var skew, window time.Duration
+		if skewEnv != "" {
+			if skew, err = time.ParseDuration(skewEnv); err != nil {
+				log.Fatalf("Invalid REPLAY_MAX_SKEW: %v", err)
+			}
+		}
+		if windowEnv != "" {
+			if window, err = time.ParseDuration(windowEnv); err != nil {
+				log.Fatalf("Invalid REPLAY_WINDOW: %v", err)
+			}
+		}
+		cfg.ReplayGuard = wssocket.NewReplayGuard(skew, window)
+	}
+
+	// Answer retried sends with their original acks instead of processing them twice
+	if v := os.Getenv("IDEMPOTENCY_WINDOW"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			log.Fatalf("Invalid IDEMPOTENCY_WINDOW: %s", v)
+		}
+		cfg.Idempotency = wssocket.NewIdempotencyCache(window)
+	}
+
+	// Mask emails, card numbers or listed words before messages are routed
+	if v := os.Getenv("REDACTION_RULES"); v != "" {
+		var words []string
+		if list := os.Getenv("PROFANITY_WORDS"); list != "" {
+			words = strings.Split(list, ",")
+		}
+		if cfg.Redactors, err = wssocket.ParseRedactionRules(v, words); err != nil {
+			log.Fatalf("Invalid REDACTION_RULES: %v", err)
+		}
+	}
+
+	// Mute spammers, reject blocked words and hold messages for an external moderation API
+	if os.Getenv("SPAM_DETECTION") == "true" {
+		cfg.Spam = &wssocket.SpamConfig{}
+		if v := os.Getenv("SPAM_MUTE_FOR"); v != "" {
+			if cfg.Spam.MuteFor, err = time.ParseDuration(v); err != nil {
+				log.Fatalf("Invalid SPAM_MUTE_FOR: %v", err)
+			}
+		}
+		if v := os.Getenv("SPAM_BURST_LIMIT"); v != "" {
+			if cfg.Spam.BurstLimit, err = strconv.Atoi(v); err != nil {
+				log.Fatalf("Invalid SPAM_BURST_LIMIT: %v", err)
+			}
+		}
+		if v := os.Getenv("SPAM_MAX_DUPLICATES"); v != "" {
+			if cfg.Spam.MaxDuplicates, err = strconv.Atoi(v); err != nil {
+				log.Fatalf("Invalid SPAM_MAX_DUPLICATES: %v", err)
+			}
+		}
+	}
+	if words, apiURL := os.Getenv("MODERATION_BLOCKED_WORDS"), os.Getenv("MODERATION_API_URL"); cfg.Spam != nil || words != "" || apiURL != "" {
+		policy := &wssocket.ModerationPolicy{FailOpen: os.Getenv("MODERATION_FAIL_OPEN") == "true"}
+		if words != "" {
+			policy.Filters = append(policy.Filters, wssocket.NewWordListFilter(wssocket.ModerationReject, strings.Split(words, ",")...))
+		}
+		if apiURL != "" {
+			policy.External = &wssocket.HTTPModerationFilter{URL: apiURL, APIKey: os.Getenv("MODERATION_API_KEY")}
+		}
+		if v := os.Getenv("MODERATION_TIMEOUT"); v != "" {
+			if policy.Timeout, err = time.ParseDuration(v); err != nil {
+				log.Fatalf("Invalid MODERATION_TIMEOUT: %v", err)
+			}
+		}
+		cfg.Moderation = policy
+		if v := os.Getenv("MODERATION_CHANNELS"); v != "" {
+			cfg.ModerationChannels = strings.Split(v, ",")
+		}
+	}
+
+	// Hold messages with a future deliver_at until they are due
+	if v := os.Getenv("SCHEDULER_INTERVAL"); v != "" {
+		if cfg.SchedulerInterval, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid SCHEDULER_INTERVAL: %v", err)
+		}
+	}
+	if v := os.Getenv("SCHEDULE_MAX_DELAY"); v != "" {
+		if cfg.ScheduleMaxDelay, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid SCHEDULE_MAX_DELAY: %v", err)
+		}
+	}
+
+	// Severity routing, deduplication and escalation for alert messages
+	cfg.AlertPolicy = wssocket.DefaultAlertPolicy
+	if v := os.Getenv("ALERT_ESCALATE_AFTER"); v != "" {
+		if cfg.AlertPolicy.EscalateAfter, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid ALERT_ESCALATE_AFTER: %v", err)
+		}
+	}
+	if v := os.Getenv("ALERT_DEDUP_WINDOW"); v != "" {
+		if cfg.AlertPolicy.DedupWindow, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid ALERT_DEDUP_WINDOW: %v", err)
+		}
+	}
+	if v := os.Getenv("ALERT_MIN_SEVERITY"); v != "" {
+		if cfg.AlertPolicy.MinSeverity, err = wssocket.ParseAlertSeverity(v); err != nil {
+			log.Fatalf("Invalid ALERT_MIN_SEVERITY: %v", err)
+		}
+	}
+
+	// Outbound webhooks for external integrations
+	if path := os.Getenv("WEBHOOKS_FILE"); path != "" {
+		if cfg.Webhooks, err = wssocket.LoadWebhooksFile(path); err != nil {
+			log.Fatalf("Failed to load webhooks: %v", err)
+		}
+	}
+
+	// Event stream of every processed message and connection for analytics and replay
+	if brokers := os.Getenv("KAFKA_BROKERS"); brokers != "" {
+		acks, err := wssocket.ParseKafkaAcks(os.Getenv("KAFKA_ACKS"))
+		if err != nil {
+			log.Fatalf("Invalid KAFKA_ACKS: %v", err)
+		}
+		cfg.Kafka = &wssocket.KafkaConfig{
+			Brokers: strings.Split(brokers, ","),
+			Topic:   os.Getenv("KAFKA_TOPIC"),
+			Acks:    acks,
+		}
+		if cfg.Kafka.Topic == "" {
+			cfg.Kafka.Topic = "gows.events"
+		}
+		if v := os.Getenv("KAFKA_RETRIES"); v != "" {
+			retries, err := strconv.Atoi(v)
+			if err != nil {
+				log.Fatalf("Invalid KAFKA_RETRIES: %v", err)
+			}
+			if retries == 0 {
+				retries = -1 // 0 means the default in KafkaConfig
+			}
+			cfg.Kafka.MaxRetries = retries
+		}
+		if os.Getenv("KAFKA_TLS") == "true" {
+			cfg.Kafka.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		if mechanism := os.Getenv("KAFKA_SASL_MECHANISM"); mechanism != "" {
+			cfg.Kafka.SASLMechanism = mechanism
+			cfg.Kafka.SASLUser = os.Getenv("KAFKA_SASL_USERNAME")
+			cfg.Kafka.SASLPassword = os.Getenv("KAFKA_SASL_PASSWORD")
+		}
+	}
+
+	// Push notifications for users with no live connection
+	cfg.PushSenders = make(map[wssocket.PushPlatform]wssocket.PushSender)
+	if path := os.Getenv("FCM_CREDENTIALS_FILE"); path != "" {
+		credentials, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read FCM credentials: %v", err)
+		}
+		if cfg.PushSenders[wssocket.PushFCM], err = wssocket.NewFCMSender(credentials); err != nil {
+			log.Fatalf("Invalid FCM credentials: %v", err)
+		}
+	}
+	if path := os.Getenv("APNS_KEY_FILE"); path != "" {
+		key, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read APNs key: %v", err)
+		}
+		cfg.PushSenders[wssocket.PushAPNs], err = wssocket.NewAPNsSender(key, os.Getenv("APNS_KEY_ID"), os.Getenv("APNS_TEAM_ID"),
+			os.Getenv("APNS_TOPIC"), os.Getenv("APNS_SANDBOX") == "true")
+		if err != nil {
+			log.Fatalf("Invalid APNs configuration: %v", err)
+		}
+	}
+	if key := os.Getenv("WEBPUSH_VAPID_PRIVATE_KEY"); key != "" {
+		if cfg.PushSenders[wssocket.PushWebPush], err = wssocket.NewWebPushSender(key, os.Getenv("WEBPUSH_SUBJECT")); err != nil {
+			log.Fatalf("Invalid web push configuration: %v", err)
+		}
+	}
+
+	// Notification templates that services render through the publish API
+	if path := os.Getenv("NOTIFICATION_TEMPLATES_FILE"); path != "" {
+		if cfg.Templates, err = wssocket.LoadTemplatesFile(path); err != nil {
+			log.Fatalf("Failed to load notification templates: %v", err)
+		}
+	}
+
+	// Sample a share of inbound messages for production debugging
+	if v := os.Getenv("AUDIT_SAMPLE_PERCENT"); v != "" {
+		percent, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Fatalf("Invalid AUDIT_SAMPLE_PERCENT: %v", err)
+		}
+		capacity := 1000
+		if v := os.Getenv("AUDIT_BUFFER_SIZE"); v != "" {
+			if parsed, err := strconv.Atoi(v); err == nil {
+				capacity = parsed
+			}
+		}
+		cfg.Audit = wssocket.NewAuditSampler(percent, wssocket.NewInMemoryAuditStore(capacity))
+	}
+	return cfg
+}
+
+// serverConfigFromEnv reads the Server's own settings from the environment
+func serverConfigFromEnv() wssocket.ServerConfig {
+	var err error
+	config := wssocket.ServerConfig{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		MaxConnections:  10000,
+		PingInterval:    30 * time.Second,
+		PongWait:        60 * time.Second,
+	}
+	if os.Getenv("WS_COMPRESSION") == "true" {
+		config.EnableCompression = true
+	}
+	if os.Getenv("ADAPTIVE_PING") == "true" {
+		config.AdaptivePing.Enabled = true
+		for name, field := range map[string]*time.Duration{
+			"PING_INTERVAL_MIN": &config.AdaptivePing.MinInterval,
+			"PING_INTERVAL_MAX": &config.AdaptivePing.MaxInterval,
+		} {
+			if v := os.Getenv(name); v != "" {
+				if *field, err = time.ParseDuration(v); err != nil {
+					log.Fatalf("Invalid %s: %v", name, err)
+				}
+			}
+		}
+	}
+	if v := os.Getenv("HANDLER_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid HANDLER_TIMEOUT: %v", err)
+		}
+		config.HandlerTimeout = timeout
+	}
+	if v := os.Getenv("MESSAGE_WORKERS"); v != "" {
+		workers, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("Invalid MESSAGE_WORKERS: %v", err)
+		}
+		config.MessageWorkers = workers
+	}
+	if v := os.Getenv("MESSAGE_PRIORITIES"); v != "" {
+		priorities, err := wssocket.ParseMessagePriorities(v)
+		if err != nil {
+			log.Fatalf("Invalid MESSAGE_PRIORITIES: %v", err)
+		}
+		config.MessagePriorities = priorities
+	}
+	if v := os.Getenv("SLOW_CONSUMER_POLICY"); v != "" {
+		policy, err := wssocket.ParseSlowConsumerPolicy(v)
+		if err != nil {
+			log.Fatalf("Invalid SLOW_CONSUMER_POLICY: %v", err)
+		}
+		config.SlowConsumerPolicy = policy
+		config.SpoolDir = os.Getenv("SPOOL_DIR")
+	}
+	if v := os.Getenv("TRANSPORT_MODE"); v != "" {
+		mode, err := wssocket.ParseTransportMode(v)
+		if err != nil {
+			log.Fatalf("Invalid TRANSPORT_MODE: %v", err)
+		}
+		config.TransportMode = mode
+	}
+	if v := os.Getenv("MAX_CONNECTIONS"); v != "" {
+		maxConnections, err := strconv.Atoi(v)
+		if err != nil || maxConnections <= 0 {
+			log.Fatalf("Invalid MAX_CONNECTIONS: %s", v)
+		}
+		config.MaxConnections = maxConnections
+	}
+	if v := os.Getenv("MAX_MESSAGE_SIZE"); v != "" {
+		maxMessageSize, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || maxMessageSize <= 0 {
+			log.Fatalf("Invalid MAX_MESSAGE_SIZE: %s", v)
+		}
+		config.MaxMessageSize = maxMessageSize
+	}
+	if v := os.Getenv("DRAIN_RATE"); v != "" {
+		drainRate, err := strconv.ParseFloat(v, 64)
+		if err != nil || drainRate <= 0 {
+			log.Fatalf("Invalid DRAIN_RATE: %s", v)
+		}
+		config.DrainRate = drainRate
+	}
+	if v := os.Getenv("RATE_LIMIT_PER_SECOND"); v != "" {
+		perSecond, err := strconv.ParseFloat(v, 64)
+		if err != nil || perSecond < 0 {
+			log.Fatalf("Invalid RATE_LIMIT_PER_SECOND: %s", v)
+		}
+		config.RateLimit.PerSecond = perSecond
+		if v := os.Getenv("RATE_LIMIT_BURST"); v != "" {
+			burst, err := strconv.Atoi(v)
+			if err != nil || burst < 0 {
+				log.Fatalf("Invalid RATE_LIMIT_BURST: %s", v)
+			}
+			config.RateLimit.Burst = burst
+		}
+		if v := os.Getenv("RATE_LIMIT_DISCONNECT"); v != "" {
+			disconnect, err := strconv.Atoi(v)
+			if err != nil || disconnect < 0 {
+				log.Fatalf("Invalid RATE_LIMIT_DISCONNECT: %s", v)
+			}
+			config.RateLimit.Disconnect = disconnect
+		}
+	}
+	if v := os.Getenv("BOT_RATE_LIMIT_PER_SECOND"); v != "" {
+		perSecond, err := strconv.ParseFloat(v, 64)
+		if err != nil || perSecond < 0 {
+			log.Fatalf("Invalid BOT_RATE_LIMIT_PER_SECOND: %s", v)
+		}
+		config.BotRateLimit.PerSecond = perSecond
+		if v := os.Getenv("BOT_RATE_LIMIT_BURST"); v != "" {
+			burst, err := strconv.Atoi(v)
+			if err != nil || burst < 0 {
+				log.Fatalf("Invalid BOT_RATE_LIMIT_BURST: %s", v)
+			}
+			config.BotRateLimit.Burst = burst
+		}
+	}
+	if v := os.Getenv("ALLOWED_ORIGINS"); v != "" {
+		config.AllowedOrigins = strings.Split(v, ",")
+	}
+	if v := os.Getenv("MAX_CONNECTIONS_PER_IP"); v != "" {
+		perIP, err := strconv.Atoi(v)
+		if err != nil || perIP < 0 {
+			log.Fatalf("Invalid MAX_CONNECTIONS_PER_IP: %s", v)
+		}
+		config.MaxConnectionsPerIP = perIP
+	}
+	if v := os.Getenv("IP_CONNECT_RATE"); v != "" {
+		perSecond, err := strconv.ParseFloat(v, 64)
+		if err != nil || perSecond < 0 {
+			log.Fatalf("Invalid IP_CONNECT_RATE: %s", v)
+		}
+		config.IPConnectRate.PerSecond = perSecond
+		if v := os.Getenv("IP_CONNECT_BURST"); v != "" {
+			burst, err := strconv.Atoi(v)
+			if err != nil || burst < 0 {
+				log.Fatalf("Invalid IP_CONNECT_BURST: %s", v)
+			}
+			config.IPConnectRate.Burst = burst
+		}
+	}
+	for name, field := range map[string]*time.Duration{
+		"RECONNECT_RETRY_AFTER":     &config.Reconnect.RetryAfter,
+		"RECONNECT_MAX_RETRY_AFTER": &config.Reconnect.MaxRetryAfter,
+		"RECONNECT_STORM_WINDOW":    &config.Reconnect.StormWindow,
+	} {
+		if v := os.Getenv(name); v != "" {
+			if *field, err = time.ParseDuration(v); err != nil {
+				log.Fatalf("Invalid %s: %v", name, err)
+			}
+		}
+	}
+	if v := os.Getenv("RECONNECT_STORM_THRESHOLD"); v != "" {
+		threshold, err := strconv.Atoi(v)
+		if err != nil || threshold < 0 {
+			log.Fatalf("Invalid RECONNECT_STORM_THRESHOLD: %s", v)
+		}
+		config.Reconnect.StormThreshold = threshold
+	}
+	config.TrustForwardedFor = os.Getenv("TRUST_FORWARDED_FOR") == "true"
+	if v := os.Getenv("CHALLENGE_CONNECT_RATE"); v != "" {
+		perSecond, err := strconv.ParseFloat(v, 64)
+		if err != nil || perSecond < 0 {
+			log.Fatalf("Invalid CHALLENGE_CONNECT_RATE: %s", v)
+		}
+		config.Challenge.ConnectRate.PerSecond = perSecond
+		if v := os.Getenv("CHALLENGE_CONNECT_BURST"); v != "" {
+			burst, err := strconv.Atoi(v)
+			if err != nil || burst < 0 {
+				log.Fatalf("Invalid CHALLENGE_CONNECT_BURST: %s", v)
+			}
+			config.Challenge.ConnectRate.Burst = burst
+		}
+	}
+	if v := os.Getenv("CHALLENGE_CONNECTIONS"); v != "" {
+		connections, err := strconv.Atoi(v)
+		if err != nil || connections < 0 {
+			log.Fatalf("Invalid CHALLENGE_CONNECTIONS: %s", v)
+		}
+		config.Challenge.Connections = connections
+	}
+	if v := os.Getenv("CHALLENGE_DIFFICULTY"); v != "" {
+		difficulty, err := strconv.Atoi(v)
+		if err != nil || difficulty <= 0 || difficulty > 32 {
+			log.Fatalf("Invalid CHALLENGE_DIFFICULTY: %s", v)
+		}
+		config.Challenge.Difficulty = difficulty
+	}
+	if v := os.Getenv("CHALLENGE_SECRET"); v != "" {
+		config.Challenge.Secret = []byte(v)
+	}
+	if v := os.Getenv("CAPTCHA_VERIFY_URL"); v != "" {
+		config.Challenge.Captcha = &wssocket.SiteVerifyCaptcha{
+			URL:    v,
+			Secret: os.Getenv("CAPTCHA_SECRET"),
+			Client: &http.Client{Timeout: 5 * time.Second},
+		}
+	}
+	if v := os.Getenv("HEALTH_MAX_QUEUE_DEPTH"); v != "" {
+		depth, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("Invalid HEALTH_MAX_QUEUE_DEPTH: %v", err)
+		}
+		config.Health.MaxQueueDepth = depth
+	}
+	if v := os.Getenv("HEALTH_MAX_GOROUTINES"); v != "" {
+		goroutines, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("Invalid HEALTH_MAX_GOROUTINES: %v", err)
+		}
+		config.Health.MaxGoroutines = goroutines
+	}
+	if v := os.Getenv("ACK_STAGES"); v != "" {
+		stages, err := wssocket.ParseAckStages(v)
+		if err != nil {
+			log.Fatalf("Invalid ACK_STAGES: %v", err)
+		}
+		config.AckStages = stages
+	}
+	config.NodeID = os.Getenv("NODE_ID")
+	if v := os.Getenv("CHANNEL_EMPTY_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid CHANNEL_EMPTY_TTL: %v", err)
+		}
+		config.ChannelPolicy.EmptyTTL = ttl
+	}
+	return config
+}
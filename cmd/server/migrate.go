@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	wssocket "github.com/Sirtheprogrammer/go-ws-socket"
+)
+
+// runMigrateCommand implements "go-ws migrate": up (the default) applies
+// every pending migration, down rolls back the latest, to N moves to version
+// N and status lists them all
+func runMigrateCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dsn := flags.String("database", os.Getenv("DATABASE_URL"), "PostgreSQL connection string")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dsn == "" {
+		return errors.New("set DATABASE_URL or -database")
+	}
+	db, err := wssocket.NewDatabase(*dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	args = flags.Args()
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		err = db.Migrate()
+	case "down":
+		var version int
+		if version, err = db.SchemaVersion(); err == nil && version > 0 {
+			err = db.MigrateTo(version - 1)
+		}
+	case "to":
+		if len(args) != 2 {
+			return errors.New("usage: migrate to <version>")
+		}
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		err = db.MigrateTo(version)
+	case "status":
+		var status []wssocket.MigrationStatus
+		if status, err = db.MigrationStatus(); err == nil {
+			for _, st := range status {
+				applied := "pending"
+				if st.AppliedAt != nil {
+					applied = "applied " + st.AppliedAt.Format(time.RFC3339)
+				}
+				fmt.Printf("%4d  %-30s %s\n", st.Version, st.Name, applied)
+			}
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown migrate command %q: want up, down, to or status", command)
+	}
+	if err != nil {
+		return err
+	}
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema at version %d\n", version)
+	return nil
+}
@@ -0,0 +1,93 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// BinarySubprotocol is the WebSocket subprotocol clients offer to exchange
+// binary frames instead of JSON text. Each frame is one protobuf-encoded
+// wsgateway.v1.Message (see proto/gateway.proto), the same encoding the gRPC
+// gateway uses.
+const BinarySubprotocol = "go-ws.proto.v1"
+
+// Wire codec names, for EncodeFrame, DecodeFrame and the bench command
+const (
+	CodecJSON   = "json"
+	CodecBinary = "binary"
+)
+
+// wireCodec encodes messages for one WebSocket frame type
+type wireCodec interface {
+	frameType() int
+	marshal(msg *Message) ([]byte, error)
+	unmarshal(data []byte) (*Message, error)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) frameType() int { return websocket.TextMessage }
+
+func (jsonCodec) marshal(msg *Message) ([]byte, error) { return json.Marshal(msg) }
+
+func (jsonCodec) unmarshal(data []byte) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) frameType() int { return websocket.BinaryMessage }
+
+func (binaryCodec) marshal(msg *Message) ([]byte, error) { return protoEncodeMessage(msg) }
+
+func (binaryCodec) unmarshal(data []byte) (*Message, error) { return protoDecodeMessage(data) }
+
+// codecByName returns the codec for a codec name
+func codecByName(name string) (wireCodec, error) {
+	switch name {
+	case CodecJSON:
+		return jsonCodec{}, nil
+	case CodecBinary:
+		return binaryCodec{}, nil
+	}
+	return nil, fmt.Errorf("unsupported codec: %s", name)
+}
+
+// EncodeFrame encodes a message as a client of the named codec sends it, and
+// returns the WebSocket frame type to send it as
+func EncodeFrame(codec string, msg *Message) (int, []byte, error) {
+	c, err := codecByName(codec)
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := c.marshal(msg)
+	return c.frameType(), data, err
+}
+
+// DecodeFrame decodes a frame of the named codec
+func DecodeFrame(codec string, data []byte) (*Message, error) {
+	c, err := codecByName(codec)
+	if err != nil {
+		return nil, err
+	}
+	return c.unmarshal(data)
+}
+
+// wantsBinary reports whether a WebSocket client negotiated binary frames
+func wantsBinary(ws *websocket.Conn) bool {
+	return ws.Subprotocol() == BinarySubprotocol
+}
+
+// codecFor returns the codec a WebSocket client negotiated
+func codecFor(ws *websocket.Conn) wireCodec {
+	if wantsBinary(ws) {
+		return binaryCodec{}
+	}
+	return jsonCodec{}
+}
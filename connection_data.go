@@ -0,0 +1,108 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+// Set stores a value on the connection for handlers and hooks to share
+func (c *Connection) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.extraData == nil {
+		c.extraData = make(map[string]interface{})
+	}
+	c.extraData[key] = value
+}
+
+// Get returns a value stored on the connection
+func (c *Connection) Get(key string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	value, ok := c.extraData[key]
+	return value, ok
+}
+
+// Delete removes a value from the connection
+func (c *Connection) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.extraData, key)
+}
+
+// GetString returns a string value; ok is false if it is missing or not a string
+func (c *Connection) GetString(key string) (string, bool) {
+	value, _ := c.Get(key)
+	s, ok := value.(string)
+	return s, ok
+}
+
+// GetInt returns an integer value. Whole floats and numeric strings count,
+// so values restored from JSON read back the same.
+func (c *Connection) GetInt(key string) (int, bool) {
+	value, _ := c.Get(key)
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case float64:
+		if v == math.Trunc(v) {
+			return int(v), true
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return int(n), true
+		}
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// PersistConnectionKeys makes the values stored under these keys survive a
+// session resume: they are saved with the session and set again on the
+// connection that resumes it. Values must encode as JSON.
+func (s *Server) PersistConnectionKeys(keys ...string) {
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	if s.sessions.persistKeys == nil {
+		s.sessions.persistKeys = make(map[string]bool)
+	}
+	for _, key := range keys {
+		s.sessions.persistKeys[key] = true
+	}
+}
+
+// persistedData encodes a connection's persisted values into dst
+func (c *Connection) persistedData(keys map[string]bool, dst map[string]json.RawMessage) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for key := range keys {
+		value, ok := c.extraData[key]
+		if !ok {
+			continue
+		}
+		if encoded, err := json.Marshal(value); err == nil {
+			dst[key] = encoded
+		}
+	}
+}
+
+// restoreData sets the persisted values saved with a resumed session
+func (c *Connection) restoreData(keys map[string]bool, saved map[string]json.RawMessage) {
+	for key, encoded := range saved {
+		if !keys[key] {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(encoded, &value); err == nil {
+			c.Set(key, value)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package wssocket
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// Conversation kinds
+const (
+	ConversationDirect = "direct"
+	ConversationGroup  = "group"
+)
+
+// DefaultConversationLimit caps a conversation listing
+const DefaultConversationLimit = 50
+
+// Conversation is a private exchange between a fixed set of users
+type Conversation struct {
+	ID           string   `json:"id"`
+	Kind         string   `json:"kind"`
+	Participants []string `json:"participants"`
+	LastMessage  *Message `json:"last_message,omitempty"`
+	Unread       int      `json:"unread"`
+	LastReadAt   int64    `json:"last_read_at"` // Unix time of the last message the user read
+	CreatedAt    int64    `json:"created_at"`
+	CreatedBy    string   `json:"created_by,omitempty"` // Set for group conversations
+}
+
+// ConversationStore is implemented by message stores that group direct
+// messages into conversations
+type ConversationStore interface {
+	// ListConversations returns a user's conversations, most recently active first
+	ListConversations(userID string, limit int) ([]*Conversation, error)
+	// MarkConversationRead records that a participant has read up to at
+	MarkConversationRead(conversationID, userID string, at int64) error
+	// CreateConversation stores a group conversation and its participants
+	CreateConversation(c *Conversation) error
+	// GetConversation returns a conversation with its participants, or nil
+	// when there is none
+	GetConversation(id string) (*Conversation, error)
+	// AddConversationParticipant adds a user to a group conversation
+	AddConversationParticipant(conversationID, userID string) error
+	// RemoveConversationParticipant takes a user out of a group conversation
+	RemoveConversationParticipant(conversationID, userID string) error
+}
+
+// DirectConversationID names the conversation between two users. The order
+// of the users doesn't matter.
+func DirectConversationID(user1, user2 string) string {
+	if user2 < user1 {
+		user1, user2 = user2, user1
+	}
+	return "dm:" + user1 + ":" + user2
+}
+
+// conversationIDFor returns the conversation a stored message belongs to,
+// or "" for channel messages
+func conversationIDFor(msg *Message) string {
+	if isGroupConversation(msg.Channel) {
+		return msg.Channel
+	}
+	if msg.Recipient == "" {
+		return ""
+	}
+	return DirectConversationID(msg.Sender, msg.Recipient)
+}
+
+// conversationLimit clamps a listing limit like a history page's
+func conversationLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultConversationLimit
+	}
+	return min(limit, MaxPageLimit)
+}
+
+// conversationStore returns the message store's conversation support, if any
+func conversationStore() (ConversationStore, bool) {
+	if globalStore == nil {
+		return nil, false
+	}
+	cs, ok := globalStore.(ConversationStore)
+	return cs, ok
+}
+
+// ConversationReadHandler marks a conversation read up to the payload's
+// timestamp, or up to now when none is given
+func ConversationReadHandler(conn *Connection, msg *Message) error {
+	conversationID, _ := msg.Payload["conversation_id"].(string)
+	if conversationID == "" {
+		return fmt.Errorf("conversation_id is required in payload")
+	}
+	cs, ok := conversationStore()
+	if !ok {
+		return fmt.Errorf("conversations not supported by the message store")
+	}
+	at := globalServer.now().Unix()
+	if ts, ok := msg.Payload["timestamp"].(float64); ok {
+		at = int64(ts)
+	}
+	if err := cs.MarkConversationRead(conversationID, conn.UserID, at); err != nil {
+		return fmt.Errorf("mark %s read: %w", conversationID, err)
+	}
+	globalServer.syncDevices(conn, string(MessageTypeConversationRead), "", map[string]interface{}{
+		"conversation_id": conversationID,
+		"timestamp":       at,
+	})
+	return nil
+}
+
+// ListConversations returns a user's group conversations and the direct
+// conversations found in the stored messages, most recently active first
+func (s *InMemoryMessageStore) ListConversations(userID string, limit int) ([]*Conversation, error) {
+	limit = conversationLimit(limit)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byID := make(map[string]*Conversation)
+	for id, group := range s.groups {
+		if slices.Contains(group.Participants, userID) {
+			c := *group
+			c.Participants = slices.Clone(group.Participants)
+			c.LastReadAt = s.readAt[id][userID]
+			byID[id] = &c
+		}
+	}
+	for _, msg := range s.messages {
+		id := conversationIDFor(msg)
+		if isGroupConversation(id) {
+			if c, exists := byID[id]; exists {
+				c.LastMessage = msg
+				if msg.Sender != userID && msg.Timestamp > c.LastReadAt {
+					c.Unread++
+				}
+			}
+			continue
+		}
+		if msg.Recipient == "" || (msg.Sender != userID && msg.Recipient != userID) {
+			continue
+		}
+		c, exists := byID[id]
+		if !exists {
+			participants := []string{msg.Sender, msg.Recipient}
+			sort.Strings(participants)
+			if participants[0] == participants[1] {
+				participants = participants[:1]
+			}
+			c = &Conversation{
+				ID:           id,
+				Kind:         ConversationDirect,
+				Participants: participants,
+				LastReadAt:   s.readAt[id][userID],
+				CreatedAt:    msg.Timestamp,
+			}
+			byID[id] = c
+		}
+		c.LastMessage = msg
+		if msg.Sender != userID && msg.Timestamp > c.LastReadAt {
+			c.Unread++
+		}
+	}
+
+	conversations := make([]*Conversation, 0, len(byID))
+	for _, c := range byID {
+		conversations = append(conversations, c)
+	}
+	sortConversations(conversations)
+	if len(conversations) > limit {
+		conversations = conversations[:limit]
+	}
+	return conversations, nil
+}
+
+// MarkConversationRead records how far a participant has read
+func (s *InMemoryMessageStore) MarkConversationRead(conversationID, userID string, at int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readAt[conversationID] == nil {
+		s.readAt[conversationID] = make(map[string]int64)
+	}
+	if at > s.readAt[conversationID][userID] {
+		s.readAt[conversationID][userID] = at
+	}
+	return nil
+}
+
+// sortConversations orders conversations by their latest message, newest first
+func sortConversations(conversations []*Conversation) {
+	activeAt := func(c *Conversation) int64 {
+		if c.LastMessage != nil {
+			return c.LastMessage.Timestamp
+		}
+		return c.CreatedAt
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		if a, b := activeAt(conversations[i]), activeAt(conversations[j]); a != b {
+			return a > b
+		}
+		return conversations[i].ID < conversations[j].ID
+	})
+}
+
+// ListConversations returns conversations with their previews decrypted
+func (e *EncryptedStore) ListConversations(userID string, limit int) ([]*Conversation, error) {
+	cs, ok := e.inner.(ConversationStore)
+	if !ok {
+		return nil, fmt.Errorf("conversations not supported by the underlying store")
+	}
+	conversations, err := cs.ListConversations(userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range conversations {
+		if c.LastMessage == nil {
+			continue
+		}
+		opened, err := e.open([]*Message{c.LastMessage})
+		if err != nil {
+			return nil, err
+		}
+		c.LastMessage = opened[0]
+	}
+	return conversations, nil
+}
+
+// MarkConversationRead passes read state through to the underlying store
+func (e *EncryptedStore) MarkConversationRead(conversationID, userID string, at int64) error {
+	cs, ok := e.inner.(ConversationStore)
+	if !ok {
+		return fmt.Errorf("conversations not supported by the underlying store")
+	}
+	return cs.MarkConversationRead(conversationID, userID, at)
+}
+
+// setupConversationRoutes registers the conversation listing
+func setupConversationRoutes(mux *http.ServeMux) {
+	// GET lists ?user_id='s conversations with a preview and unread count
+	mux.HandleFunc("/api/conversations", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cs, ok := conversationStore()
+		if !ok {
+			http.Error(w, "Conversations not available", http.StatusServiceUnavailable)
+			return
+		}
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+		limit := DefaultConversationLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed <= 0 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		conversations, err := cs.ListConversations(userID, limit)
+		if err != nil {
+			log.Printf("Error listing conversations of %s: %v", userID, err)
+			http.Error(w, "Failed to list conversations", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"conversations": conversations,
+			"count":         len(conversations),
+		})
+	})
+
+	// GET pages through group conversation ?conversation_id='s messages for
+	// one of its participants, who must authenticate as ?user_id=
+	mux.HandleFunc("/api/conversations/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cs, ok := conversationStore()
+		if !ok {
+			http.Error(w, "Conversations not available", http.StatusServiceUnavailable)
+			return
+		}
+		conversationID := r.URL.Query().Get("conversation_id")
+		if conversationID == "" {
+			http.Error(w, "conversation_id parameter required", http.StatusBadRequest)
+			return
+		}
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+		if !isGroupConversation(conversationID) {
+			http.Error(w, "direct conversation history is at /api/db/messages/dm", http.StatusBadRequest)
+			return
+		}
+		c, err := cs.GetConversation(conversationID)
+		if err != nil {
+			log.Printf("Error loading conversation %s: %v", conversationID, err)
+			http.Error(w, "Failed to load conversation", http.StatusInternalServerError)
+			return
+		}
+		if c == nil || !slices.Contains(c.Participants, userID) {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+
+		page, err := parseCursorPage(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		messages, err := globalStore.GetChannelMessages(c.ID, page)
+		if err != nil {
+			log.Printf("Error loading messages of %s: %v", conversationID, err)
+			http.Error(w, "Failed to load messages", http.StatusInternalServerError)
+			return
+		}
+		writeHistoryPage(w, messages, page)
+	})
+}
@@ -0,0 +1,351 @@
+package wssocket
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Database resilience defaults
+const (
+	DefaultDBRetries          = 3                      // Retries of a query that failed with a transient error
+	DefaultDBRetryBackoff     = 100 * time.Millisecond // Wait before the first retry, doubling after each
+	DefaultDBBreakerThreshold = 5                      // Consecutive transient failures that open the circuit
+	DefaultDBBreakerCooldown  = 5 * time.Second        // How long the circuit stays open before a trial query
+	DefaultDBQueueSize        = 10000                  // Messages held in memory while the database is unreachable
+)
+
+// ErrDatabaseUnavailable is returned without querying while the circuit is open
+var ErrDatabaseUnavailable = errors.New("database unavailable")
+
+// Circuit states
+const (
+	CircuitClosed   = "closed"    // Queries run normally
+	CircuitOpen     = "open"      // Queries fail fast until the cooldown ends
+	CircuitHalfOpen = "half_open" // One trial query decides whether the circuit closes
+)
+
+// ResilienceConfig sets how a Database rides out outages such as a
+// PostgreSQL restart
+type ResilienceConfig struct {
+	Retries          int           // Retries after a transient error; 0 uses DefaultDBRetries, -1 disables them
+	RetryBackoff     time.Duration // 0 uses DefaultDBRetryBackoff
+	BreakerThreshold int           // 0 uses DefaultDBBreakerThreshold
+	BreakerCooldown  time.Duration // 0 uses DefaultDBBreakerCooldown
+	QueueSize        int           // Messages queued while unreachable; 0 uses DefaultDBQueueSize, -1 disables queuing
+}
+
+// withDefaults fills in unset fields
+func (c ResilienceConfig) withDefaults() ResilienceConfig {
+	if c.Retries == 0 {
+		c.Retries = DefaultDBRetries
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = DefaultDBRetryBackoff
+	}
+	if c.BreakerThreshold <= 0 {
+		c.BreakerThreshold = DefaultDBBreakerThreshold
+	}
+	if c.BreakerCooldown <= 0 {
+		c.BreakerCooldown = DefaultDBBreakerCooldown
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = DefaultDBQueueSize
+	}
+	return c
+}
+
+// DatabaseStats reports the circuit breaker and the persistence queue
+type DatabaseStats struct {
+	Circuit  string `json:"circuit"`
+	Failures int    `json:"consecutive_failures"`
+	Retries  uint64 `json:"retries"`
+	Opened   uint64 `json:"circuit_opened"` // Times the circuit opened
+	Queued   int    `json:"queued"`         // Messages waiting for the database to come back
+	Flushed  uint64 `json:"flushed"`        // Queued messages saved since
+	Dropped  uint64 `json:"dropped"`        // Queued messages the database then rejected
+}
+
+// dbHealth is a Database's circuit breaker and the messages queued while
+// the circuit is open
+type dbHealth struct {
+	mu       sync.Mutex
+	config   ResilienceConfig
+	state    string
+	failures int
+	openedAt time.Time
+	queue    []*Message
+	flushing bool
+	closed   bool
+
+	retries atomic.Uint64
+	opened  atomic.Uint64
+	flushed atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// newDBHealth returns a closed circuit with the default settings
+func newDBHealth() *dbHealth {
+	return &dbHealth{config: ResilienceConfig{}.withDefaults(), state: CircuitClosed}
+}
+
+// SetResilience changes the retry, circuit breaker and queue settings
+func (db *Database) SetResilience(config ResilienceConfig) {
+	db.health.mu.Lock()
+	defer db.health.mu.Unlock()
+	db.health.config = config.withDefaults()
+}
+
+// transientDBError reports whether err means the connection to the
+// database failed, rather than that the query was wrong or slow. Deadlines
+// don't count: a query that ran out of time may still be running.
+func transientDBError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03", "53300": // Shutting down, starting up, too many connections
+			return true
+		}
+		return pqErr.Code.Class() == "08" // Connection exceptions
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && (dialError(err) || !netErr.Timeout())
+}
+
+// unsentDBError reports whether err means the statement never reached the
+// database, so retrying it can't apply it twice
+func unsentDBError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "57P03", "53300", "08001", "08004": // Starting up, too many connections, connection refused
+			return true
+		}
+		return false
+	}
+	return dialError(err)
+}
+
+// dialError reports whether err came from opening a connection
+func dialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// allow lets a query run unless the circuit is open. Once the cooldown
+// ends, the circuit turns half open and lets one trial query through.
+func (h *dbHealth) allow() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch h.state {
+	case CircuitOpen:
+		if time.Since(h.openedAt) < h.config.BreakerCooldown {
+			return ErrDatabaseUnavailable
+		}
+		h.state = CircuitHalfOpen
+	case CircuitHalfOpen:
+		return ErrDatabaseUnavailable
+	}
+	return nil
+}
+
+// record feeds a query's outcome to the circuit. Any answer from the
+// database closes it; transient failures count towards opening it.
+func (h *dbHealth) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !transientDBError(err) {
+		if h.state != CircuitClosed {
+			log.Printf("Database reachable again; circuit closed")
+		}
+		h.state = CircuitClosed
+		h.failures = 0
+		return
+	}
+	h.failures++
+	if h.state == CircuitHalfOpen || (h.state == CircuitClosed && h.failures >= h.config.BreakerThreshold) {
+		if h.state == CircuitClosed {
+			h.opened.Add(1)
+			log.Printf("Database circuit open after %d failures: %v", h.failures, err)
+		}
+		h.state = CircuitOpen
+		h.openedAt = time.Now()
+	}
+}
+
+// retry runs a query, retrying it with backoff while it fails with
+// transient errors. Statements that aren't idempotent are retried only when
+// they never reached the database. It fails fast while the circuit is open.
+// Queries in a transaction run once, since a failure ends the transaction.
+func (db *Database) retry(ctx context.Context, idempotent bool, fn func() error) error {
+	h := db.health
+	if h == nil {
+		return fn()
+	}
+	if db.tx != nil {
+		err := fn()
+		h.record(err)
+		return err
+	}
+
+	h.mu.Lock()
+	retries, backoff := h.config.Retries, h.config.RetryBackoff
+	h.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		if err := h.allow(); err != nil {
+			return err
+		}
+		err := fn()
+		h.record(err)
+		retryable := transientDBError(err)
+		if !idempotent {
+			retryable = unsentDBError(err)
+		}
+		if !retryable || attempt >= retries || ctx.Err() != nil {
+			return err
+		}
+		h.retries.Add(1)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// unreachable reports whether err means the database can't be reached now
+func unreachable(err error) bool {
+	return errors.Is(err, ErrDatabaseUnavailable) || transientDBError(err)
+}
+
+// queueing reports whether saves are waiting for the database, so new ones
+// must queue behind them to stay in order
+func (db *Database) queueing() bool {
+	if db.tx != nil || db.health == nil {
+		return false
+	}
+	db.health.mu.Lock()
+	defer db.health.mu.Unlock()
+	return len(db.health.queue) > 0
+}
+
+// queueSaves holds messages in memory until the database is back. It
+// reports false, leaving the caller to fail, in a transaction or when the
+// queue is full or disabled.
+func (db *Database) queueSaves(msgs []*Message) bool {
+	h := db.health
+	if db.tx != nil || h == nil {
+		return false
+	}
+	h.mu.Lock()
+	if h.closed || h.config.QueueSize < 0 || len(h.queue)+len(msgs) > h.config.QueueSize {
+		h.mu.Unlock()
+		return false
+	}
+	h.queue = append(h.queue, msgs...)
+	start := !h.flushing
+	h.flushing = true
+	h.mu.Unlock()
+
+	if start {
+		log.Printf("Database unreachable; queueing messages until it is back")
+		go db.flushQueue()
+	}
+	return true
+}
+
+// flushQueue saves queued messages in order as soon as the database
+// accepts them again
+func (db *Database) flushQueue() {
+	h := db.health
+	for {
+		h.mu.Lock()
+		if h.closed || len(h.queue) == 0 {
+			h.flushing = false
+			h.mu.Unlock()
+			return
+		}
+		msg := h.queue[0]
+		wait := h.config.BreakerCooldown
+		h.mu.Unlock()
+
+		// Saves are idempotent, so one that timed out is simply tried again
+		err := db.saveMessage(context.Background(), msg)
+		if unreachable(err) || errors.Is(err, context.DeadlineExceeded) {
+			time.Sleep(wait)
+			continue
+		}
+		h.mu.Lock()
+		h.queue = h.queue[1:]
+		remaining := len(h.queue)
+		h.mu.Unlock()
+		if err != nil {
+			h.dropped.Add(1)
+			log.Printf("Dropping queued message %s: %v", msg.ID, err)
+		} else {
+			h.flushed.Add(1)
+		}
+		if remaining == 0 {
+			log.Printf("Saved every message queued while the database was unreachable")
+		}
+	}
+}
+
+// stopQueue stops the flusher, reporting how many messages were left unsaved
+func (h *dbHealth) stopQueue() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	return len(h.queue)
+}
+
+// Stats returns the circuit state and queue counters
+func (db *Database) Stats() DatabaseStats {
+	h := db.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return DatabaseStats{
+		Circuit:  h.state,
+		Failures: h.failures,
+		Retries:  h.retries.Load(),
+		Opened:   h.opened.Load(),
+		Queued:   len(h.queue),
+		Flushed:  h.flushed.Load(),
+		Dropped:  h.dropped.Load(),
+	}
+}
+
+// Health is the database readiness check. Its ping also serves as the
+// circuit's trial query, so readiness returns as soon as the database does.
+func (db *Database) Health(ctx context.Context) error {
+	err := db.Ping(ctx)
+	db.health.record(err)
+	if err != nil {
+		stats := db.Stats()
+		return fmt.Errorf("reconnecting (circuit %s, %d messages queued): %w", stats.Circuit, stats.Queued, err)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package wssocket
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakePostgres is a database/sql driver that can be taken down: while down,
+// every connection attempt is refused. It records the ID of each message
+// inserted.
+type fakePostgres struct {
+	down     atomic.Bool
+	connects atomic.Int32
+
+	mu    sync.Mutex
+	saved []string
+}
+
+func (f *fakePostgres) Connect(ctx context.Context) (driver.Conn, error) {
+	f.connects.Add(1)
+	if f.down.Load() {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	}
+	return fakeConn{f}, nil
+}
+
+func (f *fakePostgres) Driver() driver.Driver { return nil }
+
+func (f *fakePostgres) savedIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.saved...)
+}
+
+type fakeConn struct{ db *fakePostgres }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt(c), nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type fakeStmt struct{ db *fakePostgres }
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if len(args) > 0 {
+		if id, ok := args[0].(string); ok {
+			s.db.mu.Lock()
+			s.db.saved = append(s.db.saved, id)
+			s.db.mu.Unlock()
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// newFakeDatabase returns a Database on a fakePostgres that opens a new
+// connection for every query, so taking it down takes effect at once
+func newFakeDatabase(t *testing.T, config ResilienceConfig) (*Database, *fakePostgres) {
+	t.Helper()
+	fake := &fakePostgres{}
+	conn := sql.OpenDB(fake)
+	conn.SetMaxIdleConns(0)
+	db := &Database{conn: conn, timeout: DefaultQueryTimeout, stmts: &sync.Map{}, health: newDBHealth()}
+	db.SetResilience(config)
+	t.Cleanup(func() { db.Close() })
+	return db, fake
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	db, fake := newFakeDatabase(t, ResilienceConfig{Retries: -1, BreakerThreshold: 2, BreakerCooldown: 20 * time.Millisecond, QueueSize: -1})
+	ctx := context.Background()
+	exec := func() error {
+		_, err := db.querier().ExecContext(ctx, "UPDATE users SET status = 'online'")
+		return err
+	}
+
+	fake.down.Store(true)
+	for i := 0; i < 2; i++ {
+		if err := exec(); !transientDBError(err) {
+			t.Fatalf("query %d while down: err = %v, want a connection error", i+1, err)
+		}
+	}
+	if stats := db.Stats(); stats.Circuit != CircuitOpen || stats.Opened != 1 {
+		t.Fatalf("after 2 failures: %+v, want the circuit open", stats)
+	}
+	connects := fake.connects.Load()
+	if err := exec(); !errors.Is(err, ErrDatabaseUnavailable) || fake.connects.Load() != connects {
+		t.Fatalf("query with the circuit open: err = %v, want it failed without connecting", err)
+	}
+
+	// After the cooldown one trial query goes through; its failure reopens the circuit
+	time.Sleep(30 * time.Millisecond)
+	if err := exec(); !transientDBError(err) {
+		t.Fatalf("trial query: err = %v, want a connection error", err)
+	}
+	if stats := db.Stats(); stats.Circuit != CircuitOpen || stats.Opened != 1 {
+		t.Fatalf("after a failed trial: %+v, want the circuit open again", stats)
+	}
+
+	// Half open, the trial blocks other queries until it succeeds
+	time.Sleep(30 * time.Millisecond)
+	if err := db.health.allow(); err != nil {
+		t.Fatalf("trial after the cooldown: %v", err)
+	}
+	if stats := db.Stats(); stats.Circuit != CircuitHalfOpen {
+		t.Fatalf("circuit = %s, want half open", stats.Circuit)
+	}
+	if err := exec(); !errors.Is(err, ErrDatabaseUnavailable) {
+		t.Fatalf("query during the trial: err = %v, want ErrDatabaseUnavailable", err)
+	}
+	fake.down.Store(false)
+	db.health.record(nil)
+	if err := exec(); err != nil {
+		t.Fatalf("query once the database is back: %v", err)
+	}
+	if stats := db.Stats(); stats.Circuit != CircuitClosed || stats.Failures != 0 {
+		t.Fatalf("after a successful trial: %+v, want the circuit closed", stats)
+	}
+}
+
+func TestRetryOnlyRepeatsSafeQueries(t *testing.T) {
+	db := &Database{health: newDBHealth()}
+	db.SetResilience(ResilienceConfig{Retries: 2, RetryBackoff: time.Millisecond})
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+	tests := []struct {
+		name       string
+		idempotent bool
+		err        error
+		attempts   int
+	}{
+		{"read on a dropped connection", true, io.ErrUnexpectedEOF, 3},
+		{"write on a dropped connection", false, io.ErrUnexpectedEOF, 1},
+		{"write never sent", false, refused, 3},
+		{"read past its deadline", true, context.DeadlineExceeded, 1},
+		{"write past its deadline", false, context.DeadlineExceeded, 1},
+		{"query error", true, errors.New("syntax error"), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db.health.record(nil)
+			attempts := 0
+			err := db.retry(context.Background(), tt.idempotent, func() error {
+				attempts++
+				return tt.err
+			})
+			if !errors.Is(err, tt.err) || attempts != tt.attempts {
+				t.Fatalf("attempts = %d, err = %v; want %d attempts", attempts, err, tt.attempts)
+			}
+		})
+	}
+
+	db.health.record(nil)
+	db.retry(context.Background(), true, func() error { return context.DeadlineExceeded })
+	if stats := db.Stats(); stats.Failures != 0 {
+		t.Fatalf("a deadline counted as %d circuit failures, want none", stats.Failures)
+	}
+}
+
+func TestQueuedSavesFlushed(t *testing.T) {
+	db, fake := newFakeDatabase(t, ResilienceConfig{Retries: -1, BreakerThreshold: 1, BreakerCooldown: 10 * time.Millisecond})
+
+	fake.down.Store(true)
+	for _, id := range []string{"m1", "m2", "m3"} {
+		if err := db.SaveMessage(&Message{ID: id, Sender: "alice", Channel: "general", Timestamp: time.Now().Unix()}); err != nil {
+			t.Fatalf("save %s while down: %v, want it queued", id, err)
+		}
+	}
+	if stats := db.Stats(); stats.Queued != 3 {
+		t.Fatalf("queued = %d, want 3", stats.Queued)
+	}
+	if saved := fake.savedIDs(); len(saved) != 0 {
+		t.Fatalf("saved %v while down", saved)
+	}
+
+	fake.down.Store(false)
+	deadline := time.Now().Add(2 * time.Second)
+	for db.Stats().Flushed < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats := db.Stats()
+	if stats.Queued != 0 || stats.Flushed != 3 || stats.Circuit != CircuitClosed {
+		t.Fatalf("after the database came back: %+v, want all 3 flushed", stats)
+	}
+	if saved := fake.savedIDs(); len(saved) != 3 || saved[0] != "m1" || saved[1] != "m2" || saved[2] != "m3" {
+		t.Fatalf("saved %v, want m1, m2, m3 in order", saved)
+	}
+}
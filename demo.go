@@ -0,0 +1,24 @@
+//go:build !nodemo
+
+package wssocket
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// demoFiles holds the browser demo clients served at /
+//
+//go:embed web
+var demoFiles embed.FS
+
+// setupDemoRoutes serves the embedded demo clients.
+// Build with -tags nodemo to leave them out of the binary entirely.
+func setupDemoRoutes(mux *http.ServeMux) {
+	web, err := fs.Sub(demoFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	mux.Handle("/", http.FileServer(http.FS(web)))
+}
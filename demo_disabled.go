@@ -0,0 +1,13 @@
+//go:build nodemo
+
+package wssocket
+
+import (
+	"log"
+	"net/http"
+)
+
+// setupDemoRoutes is a no-op when the demo clients are compiled out
+func setupDemoRoutes(mux *http.ServeMux) {
+	log.Println("Demo client disabled at build time")
+}
@@ -0,0 +1,17 @@
+// Package wssocket is a WebSocket messaging server: channels, direct
+// messages, presence, persistence and the transports and admin APIs around
+// them.
+//
+// Programs embed it by creating a Server with NewServer, registering
+// handlers and passing upgrade requests to Server.HandleConnection, from
+// whichever mux the program serves. The standalone server, configured from
+// the environment, is in cmd/server and runs RunStandalone. RegisterRoutes
+// adds the WebSocket endpoint, the other transports and the REST APIs to a
+// program's own mux.
+//
+// The server and its stores live in package state, set by
+// RegisterDefaultHandlers and UseHandlerStores. Server methods read it too:
+// channel roles and settings, membership checks, user authentication,
+// alerts and notifications all go through it, whichever handlers are
+// registered. A process can therefore run only one Server at a time.
+package wssocket
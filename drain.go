@@ -0,0 +1,240 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultDrainRate is how many connections per second a drain closes when
+// ServerConfig.DrainRate is unset
+const DefaultDrainRate = 50
+
+// drainTick is how often a drain closes its next batch of connections
+const drainTick = 100 * time.Millisecond
+
+// ErrDraining is returned for connections attempted while the server drains
+var ErrDraining = errors.New("server is draining")
+
+// drainState tracks a drain in progress
+type drainState struct {
+	draining atomic.Bool // Read on every connection attempt
+
+	mu          sync.Mutex
+	reconnectTo string
+	rate        float64 // Connections closed per second
+	startedAt   time.Time
+	closed      int           // Connections the drain has closed so far
+	stop        chan struct{} // Closed when the drain is cancelled
+}
+
+// DrainStatus reports whether the server is draining and how far it got
+type DrainStatus struct {
+	Draining    bool       `json:"draining"`
+	ReconnectTo string     `json:"reconnect_to,omitempty"`
+	Rate        float64    `json:"rate,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	Closed      int        `json:"closed"`    // Connections the drain closed; others left on their own
+	Remaining   int        `json:"remaining"` // Connections still open
+}
+
+// Draining reports whether the server has stopped accepting connections
+func (s *Server) Draining() bool {
+	return s.drain.draining.Load()
+}
+
+// StartDrain prepares the server for shutdown: it stops accepting
+// connections, tells every client to reconnect to reconnectTo (empty leaves
+// it to the load balancer), and closes whoever stays at rate connections per
+// second; 0 uses ServerConfig.DrainRate.
+func (s *Server) StartDrain(reconnectTo string, rate float64) error {
+	if reconnectTo != "" {
+		u, err := url.Parse(reconnectTo)
+		if err != nil || u.Host == "" || (u.Scheme != "ws" && u.Scheme != "wss" && u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("reconnect_to must be an absolute ws, wss, http or https URL")
+		}
+	}
+	if rate < 0 {
+		return fmt.Errorf("rate must not be negative")
+	}
+	if rate == 0 {
+		rate = s.config.DrainRate
+	}
+
+	s.drain.mu.Lock()
+	if s.drain.draining.Load() {
+		s.drain.mu.Unlock()
+		return fmt.Errorf("%w already", ErrDraining)
+	}
+	stop := make(chan struct{})
+	s.drain.reconnectTo, s.drain.rate = reconnectTo, rate
+	s.drain.startedAt, s.drain.closed, s.drain.stop = s.now(), 0, stop
+	s.drain.draining.Store(true)
+	s.drain.mu.Unlock()
+
+	s.mu.RLock()
+	connIDs := make([]string, 0, len(s.connections))
+	for connID := range s.connections {
+		connIDs = append(connIDs, connID)
+	}
+	s.mu.RUnlock()
+
+	payload := map[string]interface{}{"reason": "draining"}
+	if reconnectTo != "" {
+		payload["url"] = reconnectTo
+	}
+	for _, connID := range connIDs {
+		s.SendToConnection(connID, &Message{
+			ID:        generateMessageID(),
+			Type:      MessageTypeReconnectTo,
+			Sender:    "system",
+			Timestamp: s.now().Unix(),
+			Payload:   payload,
+		})
+	}
+
+	log.Printf("Draining %d connections at %g/s", len(connIDs), rate)
+	go s.drainConnections(stop, rate)
+	return nil
+}
+
+// CancelDrain accepts connections again and stops closing them. It reports
+// whether a drain was running.
+func (s *Server) CancelDrain() bool {
+	s.drain.mu.Lock()
+	defer s.drain.mu.Unlock()
+	if !s.drain.draining.Load() {
+		return false
+	}
+	s.drain.draining.Store(false)
+	close(s.drain.stop)
+	log.Printf("Drain cancelled after closing %d connections", s.drain.closed)
+	return true
+}
+
+// DrainStatus returns the progress of the current or last drain
+func (s *Server) DrainStatus() DrainStatus {
+	s.mu.RLock()
+	remaining := len(s.connections)
+	s.mu.RUnlock()
+
+	s.drain.mu.Lock()
+	defer s.drain.mu.Unlock()
+	status := DrainStatus{
+		Draining:    s.drain.draining.Load(),
+		ReconnectTo: s.drain.reconnectTo,
+		Rate:        s.drain.rate,
+		Closed:      s.drain.closed,
+		Remaining:   remaining,
+	}
+	if !s.drain.startedAt.IsZero() {
+		startedAt := s.drain.startedAt
+		status.StartedAt = &startedAt
+	}
+	return status
+}
+
+// drainConnections closes connections at rate per second until none are
+// left or the drain is cancelled
+func (s *Server) drainConnections(stop chan struct{}, rate float64) {
+	ticker := time.NewTicker(drainTick)
+	defer ticker.Stop()
+
+	var budget float64
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-s.done:
+			return
+		}
+
+		budget += rate * drainTick.Seconds()
+		n := int(budget)
+		budget -= float64(n)
+
+		s.mu.RLock()
+		remaining := len(s.connections)
+		connIDs := make([]string, 0, n)
+		for connID := range s.connections {
+			if len(connIDs) == n {
+				break
+			}
+			connIDs = append(connIDs, connID)
+		}
+		s.mu.RUnlock()
+		if remaining == 0 {
+			log.Printf("Drain complete")
+			return
+		}
+
+		closed := 0
+		for _, connID := range connIDs {
+			if s.closeConnection(connID, websocket.CloseServiceRestart, ErrDraining.Error()) == nil {
+				closed++
+			}
+		}
+		s.drain.mu.Lock()
+		if s.drain.stop == stop {
+			s.drain.closed += closed
+		}
+		s.drain.mu.Unlock()
+	}
+}
+
+// setupDrainAdminRoutes registers the drain endpoint
+func setupDrainAdminRoutes(mux *http.ServeMux, s *Server, apiKeys []string) {
+	// GET reports progress, POST starts a drain and DELETE cancels it
+	mux.HandleFunc("/api/admin/drain", func(w http.ResponseWriter, r *http.Request) {
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, s.DrainStatus())
+
+		case http.MethodPost:
+			var req struct {
+				ReconnectTo string  `json:"reconnect_to"`
+				Rate        float64 `json:"rate"`
+			}
+			if r.ContentLength != 0 {
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					http.Error(w, "invalid request body", http.StatusBadRequest)
+					return
+				}
+			}
+			err := s.StartDrain(req.ReconnectTo, req.Rate)
+			if errors.Is(err, ErrDraining) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, s.DrainStatus())
+
+		case http.MethodDelete:
+			if !s.CancelDrain() {
+				http.Error(w, "server is not draining", http.StatusConflict)
+				return
+			}
+			writeJSON(w, http.StatusOK, s.DrainStatus())
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,475 @@
+package wssocket
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Prefixes marking stored content sealed by EncryptedStore: v1 holds the
+// message text, v2 the whole payload encoded as JSON
+const (
+	encryptedPrefix        = "enc:v1:"
+	encryptedPayloadPrefix = "enc:v2:"
+)
+
+// cutSealed splits sealed content into its prefix and the rest
+func cutSealed(content string) (prefix, rest string, sealed bool) {
+	for _, prefix := range []string{encryptedPayloadPrefix, encryptedPrefix} {
+		if rest, ok := strings.CutPrefix(content, prefix); ok {
+			return prefix, rest, true
+		}
+	}
+	return "", content, false
+}
+
+// KeyProvider supplies AES keys for payload encryption at rest. Implement it
+// to fetch keys from a KMS; StaticKeyProvider covers keys held in env vars
+// or a key file, including files of KMS-wrapped keys.
+type KeyProvider interface {
+	// DataKey returns the ID and key used to encrypt new messages in a channel
+	DataKey(channel string) (string, []byte, error)
+	// KeyByID returns a key previously handed out by DataKey
+	KeyByID(keyID string) ([]byte, error)
+}
+
+// StaticKeyProvider serves a fixed set of keys with optional per-channel assignment
+type StaticKeyProvider struct {
+	keys        map[string][]byte
+	activeKey   string
+	channelKeys map[string]string
+}
+
+// NewStaticKeyProvider creates a provider whose default key is activeKey
+func NewStaticKeyProvider(keys map[string][]byte, activeKey string) (*StaticKeyProvider, error) {
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("key %s must be 16, 24 or 32 bytes, got %d", id, len(key))
+		}
+	}
+	if _, exists := keys[activeKey]; !exists {
+		return nil, fmt.Errorf("active key %q not found", activeKey)
+	}
+
+	return &StaticKeyProvider{
+		keys:        keys,
+		activeKey:   activeKey,
+		channelKeys: make(map[string]string),
+	}, nil
+}
+
+// SetChannelKey encrypts a channel (or tenant) with its own key
+func (p *StaticKeyProvider) SetChannelKey(channel, keyID string) error {
+	if _, exists := p.keys[keyID]; !exists {
+		return fmt.Errorf("key %q not found", keyID)
+	}
+	p.channelKeys[channel] = keyID
+	return nil
+}
+
+// DataKey returns the channel's key, or the active key
+func (p *StaticKeyProvider) DataKey(channel string) (string, []byte, error) {
+	keyID, exists := p.channelKeys[channel]
+	if !exists {
+		keyID = p.activeKey
+	}
+	return keyID, p.keys[keyID], nil
+}
+
+// KeyByID returns a key by ID
+func (p *StaticKeyProvider) KeyByID(keyID string) ([]byte, error) {
+	key, exists := p.keys[keyID]
+	if !exists {
+		return nil, fmt.Errorf("unknown encryption key: %s", keyID)
+	}
+	return key, nil
+}
+
+// KeyUnwrapper decrypts data keys that were wrapped by a KMS master key
+type KeyUnwrapper interface {
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// KeyUnwrapperFunc adapts a function to KeyUnwrapper
+type KeyUnwrapperFunc func(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+
+// Unwrap calls f
+func (f KeyUnwrapperFunc) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	return f(ctx, keyID, wrapped)
+}
+
+// DefaultKeyUnwrapper unwraps key files marked "wrapped" when the server
+// loads them from ENCRYPTION_KEY_FILE. A KMS integration sets it in init.
+var DefaultKeyUnwrapper KeyUnwrapper
+
+// KeyFile is the JSON format read by LoadKeyProviderFromFile
+type KeyFile struct {
+	Active   string            `json:"active"`   // Key for new writes; may be omitted with a single key
+	Keys     map[string]string `json:"keys"`     // Key ID -> base64 key, or base64 KMS ciphertext when Wrapped
+	Channels map[string]string `json:"channels"` // Channel or tenant -> key ID
+	Wrapped  bool              `json:"wrapped"`  // Keys are wrapped by a KMS and need a KeyUnwrapper
+}
+
+// LoadKeyProviderFromFile builds a StaticKeyProvider from a JSON KeyFile.
+// Wrapped keys are unwrapped once, at load, so the KMS is not on the
+// message path.
+func LoadKeyProviderFromFile(ctx context.Context, path string, unwrap KeyUnwrapper) (*StaticKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	var file KeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse key file %s: %w", path, err)
+	}
+	if len(file.Keys) == 0 {
+		return nil, fmt.Errorf("key file %s has no keys", path)
+	}
+	if file.Wrapped && unwrap == nil {
+		return nil, fmt.Errorf("key file %s holds wrapped keys but no key unwrapper is configured", path)
+	}
+
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decode key %s: %w", id, err)
+		}
+		if file.Wrapped {
+			if key, err = unwrap.Unwrap(ctx, id, key); err != nil {
+				return nil, fmt.Errorf("unwrap key %s: %w", id, err)
+			}
+		}
+		keys[id] = key
+	}
+
+	active := file.Active
+	if active == "" {
+		if len(keys) > 1 {
+			return nil, fmt.Errorf("key file %s must name the active key", path)
+		}
+		for id := range keys {
+			active = id
+		}
+	}
+	provider, err := NewStaticKeyProvider(keys, active)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sorted so a bad entry is reported the same way every time
+	channels := make([]string, 0, len(file.Channels))
+	for channel := range file.Channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		if err := provider.SetChannelKey(channel, file.Channels[channel]); err != nil {
+			return nil, fmt.Errorf("channel %s: %w", channel, err)
+		}
+	}
+	return provider, nil
+}
+
+// LoadKeyProvider builds a StaticKeyProvider from a key file, or from keys
+// ("id:base64key,..."), the active key ID (defaults to the first key) and
+// channel keys ("channel=id,..."). cmd/server passes ENCRYPTION_KEY_FILE,
+// ENCRYPTION_KEYS, ENCRYPTION_ACTIVE_KEY and ENCRYPTION_CHANNEL_KEYS. It
+// returns nil when encryption is not configured.
+func LoadKeyProvider(keyFile, keys, active, channelKeys string) (*StaticKeyProvider, error) {
+	if keyFile != "" {
+		if keys != "" {
+			return nil, fmt.Errorf("set keys or a key file, not both")
+		}
+		return LoadKeyProviderFromFile(context.Background(), keyFile, DefaultKeyUnwrapper)
+	}
+	if keys == "" {
+		return nil, nil
+	}
+
+	parsed := make(map[string][]byte)
+	var first string
+	for _, entry := range strings.Split(keys, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("key entry must be id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decode key %s: %w", id, err)
+		}
+		parsed[id] = key
+		if first == "" {
+			first = id
+		}
+	}
+
+	if active == "" {
+		active = first
+	}
+	provider, err := NewStaticKeyProvider(parsed, active)
+	if err != nil {
+		return nil, err
+	}
+
+	if channelKeys != "" {
+		for _, entry := range strings.Split(channelKeys, ",") {
+			channel, keyID, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok {
+				return nil, fmt.Errorf("channel key entry must be channel=id")
+			}
+			if err := provider.SetChannelKey(channel, keyID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return provider, nil
+}
+
+// newGCM builds an AES-GCM AEAD for a key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealContent encrypts content as "<prefix><key id>:<base64 nonce+ciphertext>",
+// binding it to the message ID so ciphertexts can't be swapped between rows
+func sealContent(keys KeyProvider, msg *Message, prefix, content string) (string, error) {
+	keyID, key, err := keys.DataKey(msg.Channel)
+	if err != nil {
+		return "", fmt.Errorf("get data key: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(content), []byte(msg.ID))
+	return prefix + keyID + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// openContent decrypts content produced by sealContent; plaintext passes through
+func openContent(keys KeyProvider, msg *Message, content string) (string, error) {
+	_, rest, encrypted := cutSealed(content)
+	if !encrypted {
+		return content, nil
+	}
+
+	keyID, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted content")
+	}
+	key, err := keys.KeyByID(keyID)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("decode encrypted content: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("encrypted content too short")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(msg.ID))
+	if err != nil {
+		return "", fmt.Errorf("decrypt: %w", err)
+	}
+	return string(plain), nil
+}
+
+// EncryptedStore wraps a MessageStore, encrypting message payloads before
+// they are persisted and decrypting them on read. Metadata and routing fields
+// stay in plaintext so queries keep working.
+type EncryptedStore struct {
+	inner MessageStore
+	keys  KeyProvider
+	rekey rekeyState
+}
+
+// NewEncryptedStore wraps a store with payload encryption
+func NewEncryptedStore(inner MessageStore, keys KeyProvider) *EncryptedStore {
+	return &EncryptedStore{inner: inner, keys: keys}
+}
+
+// within returns a store that encrypts with the same keys into inner, such
+// as a transaction
+func (e *EncryptedStore) within(inner MessageStore) *EncryptedStore {
+	return &EncryptedStore{inner: inner, keys: e.keys}
+}
+
+// seal returns a copy of msg whose payload is the whole original payload,
+// encrypted into its content
+func (e *EncryptedStore) seal(msg *Message) (*Message, error) {
+	data, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("encode message %s: %w", msg.ID, err)
+	}
+	sealed, err := sealContent(e.keys, msg, encryptedPayloadPrefix, string(data))
+	if err != nil {
+		return nil, fmt.Errorf("encrypt message %s: %w", msg.ID, err)
+	}
+	clone := *msg
+	clone.Payload = map[string]interface{}{"content": sealed}
+	return &clone, nil
+}
+
+// open returns copies of msgs with their payloads decrypted. Content sealed
+// before whole payloads were comes back as {"content": text}.
+func (e *EncryptedStore) open(msgs []*Message) ([]*Message, error) {
+	out := make([]*Message, len(msgs))
+	for i, msg := range msgs {
+		stored := messageContent(msg)
+		plain, err := openContent(e.keys, msg, stored)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt message %s: %w", msg.ID, err)
+		}
+		e.maybeLazyRekey(msg, stored, plain)
+
+		clone := *msg
+		clone.Payload = map[string]interface{}{"content": plain}
+		if prefix, _, _ := cutSealed(stored); prefix == encryptedPayloadPrefix {
+			clone.Payload = nil
+			if err := json.Unmarshal([]byte(plain), &clone.Payload); err != nil {
+				return nil, fmt.Errorf("decode message %s: %w", msg.ID, err)
+			}
+		}
+		out[i] = &clone
+	}
+	return out, nil
+}
+
+// SaveMessage encrypts and stores a message
+func (e *EncryptedStore) SaveMessage(msg *Message) error {
+	if err := validateStoredMessage(msg); err != nil {
+		return err
+	}
+	sealed, err := e.seal(msg)
+	if err != nil {
+		return err
+	}
+	return e.inner.SaveMessage(sealed)
+}
+
+// SaveMessages encrypts and stores multiple messages
+func (e *EncryptedStore) SaveMessages(msgs []*Message) ([]SaveStatus, error) {
+	sealed := make([]*Message, len(msgs))
+	for i, msg := range msgs {
+		if err := validateStoredMessage(msg); err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		s, err := e.seal(msg)
+		if err != nil {
+			return nil, err
+		}
+		sealed[i] = s
+	}
+	return e.inner.SaveMessages(sealed)
+}
+
+// GetMessage returns a decrypted message
+func (e *EncryptedStore) GetMessage(id string) (*Message, error) {
+	msg, err := e.inner.GetMessage(id)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := e.open([]*Message{msg})
+	if err != nil {
+		return nil, err
+	}
+	return msgs[0], nil
+}
+
+// GetChannelMessages returns decrypted channel history
+func (e *EncryptedStore) GetChannelMessages(channel string, page Page) ([]*Message, error) {
+	msgs, err := e.inner.GetChannelMessages(channel, page)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(msgs)
+}
+
+// GetDMMessages returns decrypted direct message history
+func (e *EncryptedStore) GetDMMessages(user1, user2 string, page Page) ([]*Message, error) {
+	msgs, err := e.inner.GetDMMessages(user1, user2, page)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(msgs)
+}
+
+// GetUserMessages returns decrypted messages sent by a user
+func (e *EncryptedStore) GetUserMessages(userID string, page Page) ([]*Message, error) {
+	msgs, err := e.inner.GetUserMessages(userID, page)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(msgs)
+}
+
+// FindMessages returns decrypted search results
+func (e *EncryptedStore) FindMessages(q MessageQuery) ([]*Message, error) {
+	msgs, err := e.inner.FindMessages(q)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(msgs)
+}
+
+// SearchMessages is unsupported: encrypted content can't be indexed
+func (e *EncryptedStore) SearchMessages(q SearchQuery) ([]*SearchResult, error) {
+	return nil, ErrSearchUnsupported
+}
+
+// GetMessageCount passes through to the wrapped store
+func (e *EncryptedStore) GetMessageCount(channel string) (int, error) {
+	return e.inner.GetMessageCount(channel)
+}
+
+// DeleteMessage passes through to the wrapped store
+func (e *EncryptedStore) DeleteMessage(messageID string) error {
+	return e.inner.DeleteMessage(messageID)
+}
+
+// ClearChannel passes through to the wrapped store
+func (e *EncryptedStore) ClearChannel(channel string) error {
+	return e.inner.ClearChannel(channel)
+}
+
+// DeleteMessagesBefore passes through to the wrapped store
+func (e *EncryptedStore) DeleteMessagesBefore(channel string, before int64) (int, error) {
+	return e.inner.DeleteMessagesBefore(channel, before)
+}
+
+// DeleteExpiredMessages passes through to the wrapped store
+func (e *EncryptedStore) DeleteExpiredMessages(now int64) (map[string]int, error) {
+	return e.inner.DeleteExpiredMessages(now)
+}
+
+// GetChannelStats passes through to the wrapped store; payload sizes reflect ciphertext
+func (e *EncryptedStore) GetChannelStats(channel string) ([]ChannelStats, error) {
+	return e.inner.GetChannelStats(channel)
+}
@@ -0,0 +1,252 @@
+package wssocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKey returns a 32-byte key filled with b
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+// newTestKeys returns a provider with the given keys whose active key is active
+func newTestKeys(t *testing.T, keys map[string][]byte, active string) *StaticKeyProvider {
+	t.Helper()
+	provider, err := NewStaticKeyProvider(keys, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	inner := NewInMemoryMessageStore()
+	store := NewEncryptedStore(inner, newTestKeys(t, map[string][]byte{"k1": testKey(1)}, "k1"))
+
+	msg := &Message{ID: "m1", Type: MessageTypeChat, Sender: "alice", Channel: "general",
+		Payload: map[string]interface{}{"content": "meet at noon", "priority": "high"}}
+	if err := store.SaveMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := inner.GetMessage("m1")
+	stored := messageContent(raw)
+	if !strings.HasPrefix(stored, encryptedPayloadPrefix+"k1:") || strings.Contains(stored, "noon") || raw.Payload["priority"] != nil {
+		t.Fatalf("stored payload = %v, want it sealed whole under k1", raw.Payload)
+	}
+
+	got, err := store.GetMessage("m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Payload["content"] != "meet at noon" || got.Payload["priority"] != "high" {
+		t.Fatalf("read back payload = %v, want the original", got.Payload)
+	}
+}
+
+func TestEncryptedContentRejectsTamperingAndWrongKeys(t *testing.T) {
+	keys := newTestKeys(t, map[string][]byte{"k1": testKey(1)}, "k1")
+	msg := &Message{ID: "m1", Channel: "general"}
+	sealed, err := sealContent(keys, msg, encryptedPrefix, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, encoded, _ := strings.Cut(strings.TrimPrefix(sealed, encryptedPrefix), ":")
+	data, _ := base64.RawStdEncoding.DecodeString(encoded)
+	data[len(data)-1] ^= 1
+	flipped := encryptedPrefix + keyID + ":" + base64.RawStdEncoding.EncodeToString(data)
+
+	tests := []struct {
+		name    string
+		keys    KeyProvider
+		id      string
+		content string
+	}{
+		{"flipped ciphertext bit", keys, "m1", flipped},
+		{"moved to another message", keys, "m2", sealed},
+		{"wrong key under the same ID", newTestKeys(t, map[string][]byte{"k1": testKey(2)}, "k1"), "m1", sealed},
+		{"unknown key ID", newTestKeys(t, map[string][]byte{"k2": testKey(1)}, "k2"), "m1", sealed},
+		{"truncated", keys, "m1", encryptedPrefix + "k1:AAAA"},
+		{"no key ID", keys, "m1", encryptedPrefix + "garbage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, err := openContent(tt.keys, &Message{ID: tt.id, Channel: "general"}, tt.content)
+			if err == nil {
+				t.Fatalf("opened %q, want an error", plain)
+			}
+		})
+	}
+
+	if plain, err := openContent(keys, msg, sealed); err != nil || plain != "secret" {
+		t.Fatalf("untouched content = %q, %v; want secret", plain, err)
+	}
+}
+
+func TestEncryptedStoreReadsOlderRows(t *testing.T) {
+	keys := newTestKeys(t, map[string][]byte{"k1": testKey(1)}, "k1")
+	inner := NewInMemoryMessageStore()
+	store := NewEncryptedStore(inner, keys)
+
+	// A row stored before encryption was turned on, and one sealed in the
+	// v1 format, which held only the text
+	inner.SaveMessage(&Message{ID: "m1", Sender: "alice", Channel: "general", Payload: map[string]interface{}{"content": "plain old"}})
+	v1, err := sealContent(keys, &Message{ID: "m2"}, encryptedPrefix, "sealed text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner.SaveMessage(&Message{ID: "m2", Sender: "alice", Channel: "general", Payload: map[string]interface{}{"content": v1}})
+
+	for id, want := range map[string]string{"m1": "plain old", "m2": "sealed text"} {
+		got, err := store.GetMessage(id)
+		if err != nil {
+			t.Fatalf("read %s: %v", id, err)
+		}
+		if got.Payload["content"] != want {
+			t.Fatalf("%s content = %v, want %q", id, got.Payload["content"], want)
+		}
+	}
+}
+
+// writeKeyFile writes a key file into a temporary directory
+func writeKeyFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadKeyProviderFromFile(t *testing.T) {
+	k1 := base64.StdEncoding.EncodeToString(testKey(1))
+	k2 := base64.StdEncoding.EncodeToString(testKey(2))
+	path := writeKeyFile(t, `{"active": "k2", "keys": {"k1": "`+k1+`", "k2": "`+k2+`"}, "channels": {"tenant-a": "k1"}}`)
+
+	keys, err := LoadKeyProviderFromFile(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, key, _ := keys.DataKey("general"); id != "k2" || !bytes.Equal(key, testKey(2)) {
+		t.Fatalf("default data key = %s, want k2", id)
+	}
+	if id, _, _ := keys.DataKey("tenant-a"); id != "k1" {
+		t.Fatalf("tenant-a data key = %s, want k1", id)
+	}
+	if key, err := keys.KeyByID("k1"); err != nil || !bytes.Equal(key, testKey(1)) {
+		t.Fatalf("k1 = %v, %v", key, err)
+	}
+}
+
+func TestLoadKeyProviderFromFileRejectsBadKeys(t *testing.T) {
+	k1 := base64.StdEncoding.EncodeToString(testKey(1))
+	short := base64.StdEncoding.EncodeToString([]byte("too short"))
+
+	tests := []struct {
+		name, contents, want string
+	}{
+		{"not JSON", `keys: k1`, "parse key file"},
+		{"no keys", `{"keys": {}}`, "has no keys"},
+		{"not base64", `{"keys": {"k1": "not base64!"}}`, "decode key k1"},
+		{"short key", `{"keys": {"k1": "` + short + `"}}`, "must be 16, 24 or 32 bytes"},
+		{"unknown active key", `{"active": "k9", "keys": {"k1": "` + k1 + `"}}`, `active key "k9" not found`},
+		{"no active key among several", `{"keys": {"k1": "` + k1 + `", "k2": "` + k1 + `"}}`, "must name the active key"},
+		{"channel on an unknown key", `{"keys": {"k1": "` + k1 + `"}, "channels": {"general": "k9"}}`, "channel general"},
+		{"wrapped without an unwrapper", `{"wrapped": true, "keys": {"k1": "` + k1 + `"}}`, "no key unwrapper"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadKeyProviderFromFile(context.Background(), writeKeyFile(t, tt.contents), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadKeyProviderFromFile(context.Background(), filepath.Join(t.TempDir(), "missing.json"), nil); err == nil {
+		t.Fatal("loaded a missing key file")
+	}
+}
+
+func TestLoadKeyProviderUnwrapsKMSKeys(t *testing.T) {
+	wrapped := base64.StdEncoding.EncodeToString([]byte("ciphertext-of-k1"))
+	path := writeKeyFile(t, `{"wrapped": true, "keys": {"k1": "`+wrapped+`"}}`)
+
+	var unwrapped []string
+	kms := KeyUnwrapperFunc(func(ctx context.Context, keyID string, data []byte) ([]byte, error) {
+		unwrapped = append(unwrapped, keyID+"="+string(data))
+		return testKey(1), nil
+	})
+	keys, err := LoadKeyProviderFromFile(context.Background(), path, kms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unwrapped) != 1 || unwrapped[0] != "k1=ciphertext-of-k1" {
+		t.Fatalf("unwrapped %v, want k1's ciphertext once", unwrapped)
+	}
+	if id, key, _ := keys.DataKey("general"); id != "k1" || !bytes.Equal(key, testKey(1)) {
+		t.Fatalf("data key = %s, want the unwrapped k1", id)
+	}
+
+	denied := errors.New("kms: access denied")
+	failing := KeyUnwrapperFunc(func(ctx context.Context, keyID string, data []byte) ([]byte, error) {
+		return nil, denied
+	})
+	if _, err := LoadKeyProviderFromFile(context.Background(), path, failing); !errors.Is(err, denied) || !strings.Contains(err.Error(), "unwrap key k1") {
+		t.Fatalf("err = %v, want the KMS error for k1", err)
+	}
+
+	// A KMS that hands back a key of the wrong size fails the load too
+	truncating := KeyUnwrapperFunc(func(ctx context.Context, keyID string, data []byte) ([]byte, error) {
+		return []byte("short"), nil
+	})
+	if _, err := LoadKeyProviderFromFile(context.Background(), path, truncating); err == nil {
+		t.Fatal("loaded a short unwrapped key")
+	}
+}
+
+func TestLoadKeyProvider(t *testing.T) {
+	k1 := base64.StdEncoding.EncodeToString(testKey(1))
+	k2 := base64.StdEncoding.EncodeToString(testKey(2))
+
+	keys, err := LoadKeyProvider("", "k1:"+k1+", k2:"+k2, "", "tenant-a=k2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _, _ := keys.DataKey("general"); id != "k1" {
+		t.Fatalf("default data key = %s, want the first key", id)
+	}
+	if id, _, _ := keys.DataKey("tenant-a"); id != "k2" {
+		t.Fatalf("tenant-a data key = %s, want k2", id)
+	}
+	if keys, err := LoadKeyProvider("", "", "", ""); keys != nil || err != nil {
+		t.Fatalf("nothing configured = %v, %v; want no provider", keys, err)
+	}
+
+	tests := []struct {
+		name                           string
+		keyFile, keys, active, channel string
+	}{
+		{"file and keys", "keys.json", "k1:" + k1, "", ""},
+		{"entry without an ID", "", k1, "", ""},
+		{"not base64", "", "k1:not base64!", "", ""},
+		{"short key", "", "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), "", ""},
+		{"unknown active key", "", "k1:" + k1, "k2", ""},
+		{"channel entry without a key", "", "k1:" + k1, "", "tenant-a"},
+		{"channel on an unknown key", "", "k1:" + k1, "", "tenant-a=k9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadKeyProvider(tt.keyFile, tt.keys, tt.active, tt.channel); err == nil {
+				t.Fatal("loaded, want an error")
+			}
+		})
+	}
+}
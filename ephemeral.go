@@ -0,0 +1,141 @@
+package wssocket
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// MaxEphemeralTTL is the longest lifetime an ephemeral channel may declare
+const MaxEphemeralTTL = 7 * 24 * time.Hour
+
+// EphemeralChannel is a channel that is torn down once its TTL elapses
+type EphemeralChannel struct {
+	Channel      string `json:"channel"`
+	CreatedBy    string `json:"created_by"`
+	ExpiresAt    int64  `json:"expires_at"`    // Unix time
+	PurgeHistory bool   `json:"purge_history"` // Delete the channel's stored messages on close
+}
+
+// CreateEphemeralChannel declares a channel that closes after ttl. The
+// channel must not exist yet. When the TTL elapses every subscriber is told
+// with a channel:closed message and unsubscribed, and with purgeHistory its
+// stored messages are deleted. The timer lives on this node and does not
+// survive a restart.
+func (s *Server) CreateEphemeralChannel(channel string, ttl time.Duration, purgeHistory bool, creator string) (*EphemeralChannel, error) {
+	if channel == "" || IsChannelPattern(channel) || isModerationChannel(channel) {
+		return nil, fmt.Errorf("an ephemeral channel needs a single, ordinary channel name")
+	}
+	if ttl <= 0 || ttl > MaxEphemeralTTL {
+		return nil, fmt.Errorf("ttl must be between 1s and %s", MaxEphemeralTTL)
+	}
+
+	s.mu.Lock()
+	if _, exists := s.channels[channel]; exists {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("channel %s already exists", channel)
+	}
+	if _, exists := s.ephemeralChannels[channel]; exists {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("channel %s is already ephemeral", channel)
+	}
+	ec := &EphemeralChannel{
+		Channel:      channel,
+		CreatedBy:    creator,
+		ExpiresAt:    s.now().Add(ttl).Unix(),
+		PurgeHistory: purgeHistory,
+	}
+	s.ephemeralChannels[channel] = ec
+	s.mu.Unlock()
+
+	s.config.Clock.AfterFunc(ttl, func() {
+		s.closeEphemeralChannel(ec)
+	})
+	copied := *ec
+	return &copied, nil
+}
+
+// EphemeralChannel returns a channel's declared lifetime, if it has one
+func (s *Server) EphemeralChannel(channel string) (*EphemeralChannel, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ec, exists := s.ephemeralChannels[channel]
+	if !exists {
+		return nil, false
+	}
+	copied := *ec
+	return &copied, true
+}
+
+// closeEphemeralChannel tears down an ephemeral channel whose TTL elapsed
+func (s *Server) closeEphemeralChannel(ec *EphemeralChannel) {
+	s.mu.Lock()
+	if s.ephemeralChannels[ec.Channel] != ec {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.ephemeralChannels, ec.Channel)
+	connIDs := make([]string, 0, len(s.channels[ec.Channel]))
+	for connID := range s.channels[ec.Channel] {
+		connIDs = append(connIDs, connID)
+	}
+	store := s.store
+	s.mu.Unlock()
+
+	purged := false
+	if ec.PurgeHistory && store != nil {
+		if err := store.ClearChannel(ec.Channel); err != nil {
+			log.Printf("Error purging history of %s: %v", ec.Channel, err)
+		} else {
+			purged = true
+		}
+	}
+
+	// Pattern subscribers hear about the close too, but stay subscribed
+	s.broadcastToChannel(ec.Channel, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeChannelClosed,
+		Sender:    "system",
+		Channel:   ec.Channel,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"channel": ec.Channel,
+			"reason":  "expired",
+			"purged":  purged,
+		},
+	}, &BroadcastOptions{})
+	for _, connID := range connIDs {
+		s.UnsubscribeFromChannel(connID, ec.Channel)
+	}
+	log.Printf("Ephemeral channel %s closed (%d subscribers)", ec.Channel, len(connIDs))
+}
+
+// ChannelCreateHandler creates an ephemeral channel and subscribes the
+// sender to it. The payload carries ttl in seconds and optionally
+// purge_history.
+func ChannelCreateHandler(conn *Connection, msg *Message) error {
+	ttl, _ := msg.Payload["ttl"].(float64)
+	purge, _ := msg.Payload["purge_history"].(bool)
+	ec, err := globalServer.CreateEphemeralChannel(msg.Channel, time.Duration(ttl*float64(time.Second)), purge, conn.UserID)
+	if err != nil {
+		return err
+	}
+	if err := globalServer.SubscribeToChannel(conn.ID, msg.Channel); err != nil {
+		return fmt.Errorf("subscribe to %s: %w", msg.Channel, err)
+	}
+
+	globalServer.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeChannelCreate,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Channel:   ec.Channel,
+		Timestamp: globalServer.now().Unix(),
+		Payload: map[string]interface{}{
+			"channel":       ec.Channel,
+			"expires_at":    ec.ExpiresAt,
+			"purge_history": ec.PurgeHistory,
+		},
+	})
+	return nil
+}
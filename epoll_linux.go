@@ -0,0 +1,84 @@
+//go:build linux
+
+package wssocket
+
+import (
+	"fmt"
+	"log"
+	"syscall"
+)
+
+// epollSupported reports whether TransportModeEpoll is available on this platform
+const epollSupported = true
+
+// netPoller waits for readable sockets with epoll. Sockets are registered
+// one-shot, so each readiness event goes to one handler until it is rearmed.
+type netPoller struct {
+	epfd int
+}
+
+// newNetPoller creates an epoll instance
+func newNetPoller() (*netPoller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("epoll create: %w", err)
+	}
+	return &netPoller{epfd: epfd}, nil
+}
+
+// pollEvent builds the one-shot read event carrying token
+func pollEvent(token uint64) *syscall.EpollEvent {
+	return &syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT,
+		Fd:     int32(token),
+		Pad:    int32(token >> 32),
+	}
+}
+
+// add starts watching fd, reporting readiness as token
+func (p *netPoller) add(fd int, token uint64) error {
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, pollEvent(token))
+}
+
+// rearm watches fd for its next readiness event
+func (p *netPoller) rearm(fd int, token uint64) error {
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_MOD, fd, pollEvent(token))
+}
+
+// remove stops watching fd
+func (p *netPoller) remove(fd int) error {
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+// wait hands each ready token to ready until done is closed
+func (p *netPoller) wait(ready func(token uint64), done <-chan struct{}) {
+	defer syscall.Close(p.epfd)
+
+	events := make([]syscall.EpollEvent, 256)
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		// Time out now and then to notice done
+		n, err := syscall.EpollWait(p.epfd, events, 500)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			log.Printf("epoll wait failed: %v", err)
+			return
+		}
+		for _, ev := range events[:n] {
+			ready(uint64(uint32(ev.Fd)) | uint64(uint32(ev.Pad))<<32)
+		}
+	}
+}
+
+// readFD reads whatever is buffered on fd without waiting. It returns
+// syscall.EAGAIN when nothing is.
+func readFD(fd uintptr, buf []byte) (int, error) {
+	return syscall.Read(int(fd), buf)
+}
@@ -0,0 +1,192 @@
+//go:build linux
+
+package wssocket
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// epollTestConn is a client of a server running the epoll transport, able
+// to write raw frames
+type epollTestConn struct {
+	server   *Server
+	raw      net.Conn
+	received chan *Message // Messages the server handled
+	pongs    chan string
+	closed   chan error // The error that ended the client's reads
+}
+
+const epollTestType MessageType = "test:echo"
+
+func newEpollTestConn(t *testing.T, config ServerConfig) *epollTestConn {
+	t.Helper()
+	config.TransportMode = TransportModeEpoll
+	server := NewServer(config)
+	t.Cleanup(server.Stop)
+	restore := UseHandlerStores(server, HandlerStores{})
+	t.Cleanup(restore)
+
+	tc := &epollTestConn{
+		server:   server,
+		received: make(chan *Message, 10),
+		pongs:    make(chan string, 10),
+		closed:   make(chan error, 1),
+	}
+	server.RegisterHandler(epollTestType, func(conn *Connection, msg *Message) error {
+		tc.received <- msg
+		return nil
+	})
+	go server.ProcessMessages()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.HandleConnection(w, r, "conn_1", "alice")
+	}))
+	t.Cleanup(httpServer.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.SetPongHandler(func(data string) error {
+		tc.pongs <- data
+		return nil
+	})
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				tc.closed <- err
+				return
+			}
+		}
+	}()
+	tc.raw = client.NetConn()
+	return tc
+}
+
+// write sends frames in the given chunks, pausing so each arrives as its own read
+func (tc *epollTestConn) write(t *testing.T, chunks ...[]byte) {
+	t.Helper()
+	for _, chunk := range chunks {
+		if _, err := tc.raw.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// expectMessage waits for the server to handle a message with content
+func (tc *epollTestConn) expectMessage(t *testing.T, content string) {
+	t.Helper()
+	select {
+	case msg := <-tc.received:
+		if got, _ := msg.Payload["content"].(string); got != content {
+			t.Fatalf("content = %q, want %q", got, content)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the server handled no message")
+	}
+}
+
+// expectClose waits for the server to close the connection with code
+func (tc *epollTestConn) expectClose(t *testing.T, code int) {
+	t.Helper()
+	select {
+	case err := <-tc.closed:
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != code {
+			t.Fatalf("read error = %v, want close code %d", err, code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the server did not close the connection")
+	}
+}
+
+func epollTestMessage(content string) []byte {
+	return []byte(`{"type":"` + string(epollTestType) + `","payload":{"content":"` + content + `"}}`)
+}
+
+func TestEpollFrameSplitAcrossReads(t *testing.T) {
+	tc := newEpollTestConn(t, ServerConfig{})
+	frame := clientFrame(true, websocket.TextMessage, epollTestMessage("split"), true)
+	tc.write(t, frame[:1], frame[1:7], frame[7:20], frame[20:])
+	tc.expectMessage(t, "split")
+
+	// Two frames in one read are both handled
+	tc.write(t, append(clientFrame(true, websocket.TextMessage, epollTestMessage("a"), true),
+		clientFrame(true, websocket.TextMessage, epollTestMessage("b"), true)...))
+	tc.expectMessage(t, "a")
+	tc.expectMessage(t, "b")
+}
+
+func TestEpollControlFrameInsideFragmentedMessage(t *testing.T) {
+	tc := newEpollTestConn(t, ServerConfig{})
+	data := epollTestMessage("fragmented")
+	tc.write(t,
+		clientFrame(false, websocket.TextMessage, data[:10], true),
+		clientFrame(true, websocket.PingMessage, []byte("p"), true),
+		clientFrame(false, continuationFrame, data[10:20], true),
+		clientFrame(true, continuationFrame, data[20:], true),
+	)
+	select {
+	case pong := <-tc.pongs:
+		if pong != "p" {
+			t.Fatalf("pong = %q, want the ping's payload", pong)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no pong for a ping between fragments")
+	}
+	tc.expectMessage(t, "fragmented")
+}
+
+func TestEpollRejectsBadFrames(t *testing.T) {
+	tests := []struct {
+		name     string
+		frames   [][]byte
+		wantCode int
+	}{
+		{"unmasked", [][]byte{clientFrame(true, websocket.TextMessage, epollTestMessage("x"), false)}, websocket.CloseProtocolError},
+		{"continuation without a start", [][]byte{clientFrame(true, continuationFrame, []byte("x"), true)}, websocket.CloseProtocolError},
+		{"new message inside a fragmented one", [][]byte{
+			clientFrame(false, websocket.TextMessage, []byte("{"), true),
+			clientFrame(true, websocket.TextMessage, []byte("{}"), true),
+		}, websocket.CloseProtocolError},
+		{"oversized length", [][]byte{{0x81, 0xff, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}}, websocket.CloseMessageTooBig},
+		{"oversized fragments", [][]byte{
+			clientFrame(false, websocket.TextMessage, make([]byte, 60), true),
+			clientFrame(true, continuationFrame, make([]byte, 60), true),
+		}, websocket.CloseMessageTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newEpollTestConn(t, ServerConfig{MaxMessageSize: 100})
+			tc.write(t, tt.frames...)
+			tc.expectClose(t, tt.wantCode)
+		})
+	}
+}
+
+func TestEpollClientClose(t *testing.T) {
+	tc := newEpollTestConn(t, ServerConfig{})
+	tc.write(t, clientFrame(true, websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), true))
+	tc.expectClose(t, websocket.CloseNormalClosure)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := tc.server.GetConnection("conn_1"); !ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the connection stayed registered after closing")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package wssocket
+
+import "errors"
+
+// epollSupported reports whether TransportModeEpoll is available on this platform
+const epollSupported = false
+
+var errEpollUnsupported = errors.New("epoll transport is only supported on linux")
+
+// netPoller is unavailable off Linux; servers fall back to goroutines
+type netPoller struct{}
+
+func newNetPoller() (*netPoller, error) { return nil, errEpollUnsupported }
+
+func (p *netPoller) add(fd int, token uint64) error   { return errEpollUnsupported }
+func (p *netPoller) rearm(fd int, token uint64) error { return errEpollUnsupported }
+func (p *netPoller) remove(fd int) error              { return errEpollUnsupported }
+
+func (p *netPoller) wait(ready func(token uint64), done <-chan struct{}) {}
+
+func readFD(fd uintptr, buf []byte) (int, error) { return 0, errEpollUnsupported }
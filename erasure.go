@@ -0,0 +1,269 @@
+package wssocket
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// ErasedUserID replaces an erased user as the sender of their messages
+const ErasedUserID = "deleted-user"
+
+// MessageErasure reports what erasing a user did to stored messages
+type MessageErasure struct {
+	Anonymized int            // Channel and group messages left without sender or content
+	Deleted    int            // Direct messages removed
+	Channels   map[string]int // Messages changed per channel
+}
+
+// MessageEraser is a MessageStore that can remove a user from its messages
+type MessageEraser interface {
+	// EraseUserMessages strips a user's identity and content from the
+	// messages they sent and deletes their direct messages
+	EraseUserMessages(userID string, now int64) (*MessageErasure, error)
+}
+
+// UserEraser is a store that can forget everything it keeps about a user
+type UserEraser interface {
+	EraseUser(userID string, now int64) error
+}
+
+// ErasureResult reports what erasing a user changed
+type ErasureResult struct {
+	UserID       string `json:"user_id"`
+	Anonymized   int    `json:"anonymized"`
+	Deleted      int    `json:"deleted"`
+	Disconnected int    `json:"disconnected"`
+	ErasedAt     int64  `json:"erased_at"` // Unix milliseconds
+}
+
+// messageEraser returns the message store's erasure support, if any. Erasure
+// blanks content rather than reading it, so it goes past the encryption.
+func messageEraser() (MessageEraser, bool) {
+	store := globalStore
+	if encrypted, ok := store.(*EncryptedStore); ok {
+		store = encrypted.inner
+	}
+	eraser, ok := store.(MessageEraser)
+	return eraser, ok
+}
+
+// userErasers returns each store holding user data once. The Redis cache
+// wraps the database, so the cached store stands in for it.
+func userErasers() []UserEraser {
+	erasers := make([]UserEraser, 0, 3)
+	seen := make(map[interface{}]bool)
+	for _, store := range []interface{}{globalChannelStore, globalUserStore, globalNotifications} {
+		eraser, ok := store.(UserEraser)
+		if !ok {
+			continue
+		}
+		key := store
+		if cached, ok := store.(*CachedDatabase); ok {
+			key = cached.Database
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		erasers = append(erasers, eraser)
+	}
+	return erasers
+}
+
+// auditEraser returns the audit store's erasure support, if any
+func (s *Server) auditEraser() (UserEraser, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.audit == nil {
+		return nil, false
+	}
+	eraser, ok := s.audit.store.(UserEraser)
+	return eraser, ok
+}
+
+// EraseUser carries out a right-to-erasure request. It disconnects the user,
+// anonymizes the channel messages they sent, deletes their direct messages
+// and clears their profile, their audit records and everything else stored
+// about them. Soft-deleted rows are purged by the retention janitor.
+// Retention archives are left alone: they are written once to the blob
+// store, outside the message store, and expire by the bucket's own policy.
+func (s *Server) EraseUser(userID string) (*ErasureResult, error) {
+	if userID == "" || userID == ErasedUserID {
+		return nil, fmt.Errorf("invalid user ID: %q", userID)
+	}
+	result := &ErasureResult{UserID: userID, ErasedAt: s.now().UnixMilli()}
+
+	// Disconnect first so nothing new is stored while erasing
+	for _, sess := range s.UserSessions(userID) {
+		for _, connID := range sess.Connections {
+			if s.closeConnection(connID, CloseAccountRemoved, "account erased") == nil {
+				result.Disconnected++
+			}
+		}
+	}
+
+	if eraser, ok := messageEraser(); ok {
+		erasure, err := eraser.EraseUserMessages(userID, result.ErasedAt)
+		if err != nil {
+			return nil, fmt.Errorf("erase messages of %s: %w", userID, err)
+		}
+		result.Anonymized, result.Deleted = erasure.Anonymized, erasure.Deleted
+	}
+	for _, eraser := range userErasers() {
+		if err := eraser.EraseUser(userID, result.ErasedAt); err != nil {
+			return nil, fmt.Errorf("erase data of %s: %w", userID, err)
+		}
+	}
+	if eraser, ok := s.auditEraser(); ok {
+		if err := eraser.EraseUser(userID, result.ErasedAt); err != nil {
+			return nil, fmt.Errorf("erase audit records of %s: %w", userID, err)
+		}
+	}
+	if globalExporter != nil {
+		globalExporter.Forget(userID)
+	}
+
+	log.Printf("Erased user %s: %d messages anonymized, %d deleted", userID, result.Anonymized, result.Deleted)
+	return result, nil
+}
+
+// EraseUserMessages anonymizes the channel messages a user sent, removes
+// their direct messages and takes them out of group conversations
+func (s *InMemoryMessageStore) EraseUserMessages(userID string, now int64) (*MessageErasure, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	erasure := &MessageErasure{Channels: make(map[string]int)}
+	kept := s.messages[:0]
+	for _, msg := range s.messages {
+		switch {
+		case msg.Recipient != "" && (msg.Sender == userID || msg.Recipient == userID):
+			erasure.Deleted++
+			erasure.Channels[msg.Channel]++
+			continue
+		case msg.Recipient == "" && msg.Sender == userID:
+			anonymized := *msg
+			anonymized.Sender = ErasedUserID
+			anonymized.Payload = map[string]interface{}{"content": ""}
+			anonymized.Metadata = nil
+			msg = &anonymized
+			erasure.Anonymized++
+			erasure.Channels[msg.Channel]++
+		}
+		kept = append(kept, msg)
+	}
+	clear(s.messages[len(kept):])
+	s.messages = kept
+	s.reindex()
+
+	for _, users := range s.readAt {
+		delete(users, userID)
+	}
+	delete(s.channelRead, userID)
+	for _, group := range s.groups {
+		group.Participants = slices.DeleteFunc(group.Participants, func(p string) bool { return p == userID })
+		if group.CreatedBy == userID {
+			group.CreatedBy = ""
+		}
+	}
+	return erasure, nil
+}
+
+// EraseUser forgets a user's profile, username, token, mutes, devices, push
+// preferences and mentions. The ID can't be registered again.
+func (s *InMemoryUserStore) EraseUser(userID string, now int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.erased[userID] = true
+
+	if p, exists := s.profiles[userID]; exists && p.Username != "" {
+		delete(s.usernames, p.Username)
+	}
+	for username, owner := range s.usernames {
+		if owner == userID {
+			delete(s.usernames, username)
+		}
+	}
+	delete(s.tokens, s.tokenHashes[userID])
+	delete(s.tokenHashes, userID)
+	delete(s.profiles, userID)
+	delete(s.mutedChannels, userID)
+	delete(s.devices, userID)
+	delete(s.pushPrefs, userID)
+	delete(s.mentions, userID)
+	for user, mentions := range s.mentions {
+		kept := mentions[:0]
+		for _, m := range mentions {
+			if m.Sender != userID {
+				kept = append(kept, m)
+			}
+		}
+		clear(mentions[len(kept):])
+		s.mentions[user] = kept
+	}
+	return nil
+}
+
+// EraseUser removes a user's channel roles and the invitations to and from them
+func (s *InMemoryChannelStore) EraseUser(userID string, now int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, members := range s.members {
+		delete(members, userID)
+	}
+	for id, invite := range s.invites {
+		if invite.UserID == userID || invite.InvitedBy == userID {
+			delete(s.invites, id)
+		}
+	}
+	return nil
+}
+
+// EraseUser removes a user's notifications and those about their messages
+func (s *InMemoryNotificationStore) EraseUser(userID string, now int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.byUser, userID)
+	for user, notifications := range s.byUser {
+		kept := notifications[:0]
+		for _, n := range notifications {
+			if n.Sender != userID {
+				kept = append(kept, n)
+			}
+		}
+		clear(notifications[len(kept):])
+		s.byUser[user] = kept
+	}
+	return nil
+}
+
+// setupErasureAdminRoutes registers the right-to-erasure endpoint, guarded
+// by admin API keys
+func setupErasureAdminRoutes(mux *http.ServeMux, s *Server, apiKeys []string) {
+	// POST erases a user
+	mux.HandleFunc("/api/users/{id}/erase", func(w http.ResponseWriter, r *http.Request) {
+		userID := r.PathValue("id")
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if userID == ErasedUserID {
+			http.Error(w, "invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		result, err := s.EraseUser(userID)
+		if err != nil {
+			log.Printf("Error erasing user %s: %v", userID, err)
+			http.Error(w, "Failed to erase user", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+}
@@ -0,0 +1,59 @@
+package wssocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestErasedUserLeavesNothingToInherit(t *testing.T) {
+	server := NewServer(ServerConfig{})
+	t.Cleanup(server.Stop)
+	messages := NewInMemoryMessageStore()
+	channels := NewInMemoryChannelStore()
+	users := NewInMemoryUserStore()
+	restore := UseHandlerStores(server, HandlerStores{Messages: messages, Channels: channels, Users: users})
+	t.Cleanup(restore)
+	audit := NewInMemoryAuditStore(10)
+	server.SetAuditSampler(NewAuditSampler(100, audit))
+
+	if _, _, err := server.RegisterUser("alice", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := messages.CreateConversation(&Conversation{ID: "grp_1", Kind: "group", CreatedBy: "alice", Participants: []string{"alice", "bob"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := channels.SaveInvite(&ChannelInvite{ID: "inv_1", Channel: "team", UserID: "carol", InvitedBy: "alice", Status: "pending"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []*Message{
+		{ID: "m1", Sender: "alice", Recipient: "bob"},
+		{ID: "m2", Sender: "bob", Recipient: "alice"},
+		{ID: "m3", Sender: "bob", Recipient: "carol"},
+	} {
+		audit.Record(&AuditRecord{Message: msg, UserID: msg.Sender, RecordedAt: time.Now()})
+	}
+
+	if _, err := server.EraseUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := server.RegisterUser("alice", "alice2"); !errors.Is(err, ErrUserClaimed) {
+		t.Fatalf("register an erased ID: err = %v, want ErrUserClaimed", err)
+	}
+	users.SaveUserProfile(&UserProfile{UserID: "alice", DisplayName: "Alice"})
+	if p, _ := users.GetUserProfile("alice"); p != nil {
+		t.Fatalf("profile of an erased user = %+v, want none", p)
+	}
+	group, _ := messages.GetConversation("grp_1")
+	if group.CreatedBy != "" || len(group.Participants) != 1 {
+		t.Fatalf("group = %+v, want alice gone as creator and participant", group)
+	}
+	if invite, _ := channels.GetInvite("inv_1"); invite != nil {
+		t.Fatalf("invite from alice = %+v, want it deleted", invite)
+	}
+	records, _ := audit.Recent(0)
+	if len(records) != 1 || records[0].Message.ID != "m3" {
+		t.Fatalf("audit records = %d, want only the one without alice", len(records))
+	}
+}
@@ -0,0 +1,85 @@
+package wssocket
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SinkEventSchema is the version of the SinkEvent layout. Fields are only
+// added within a version; a change that would break consumers bumps it.
+const SinkEventSchema = 1
+
+// Sink event kinds
+const (
+	SinkEventMessage    = "message"    // A message finished processing
+	SinkEventConnect    = "connect"    // A connection opened
+	SinkEventDisconnect = "disconnect" // A connection closed
+)
+
+// SinkEvent is the record an EventSink receives for every processed message
+// and every connection opened or closed
+type SinkEvent struct {
+	Schema       int       `json:"schema"`
+	ID           string    `json:"id"` // Unique per event, so consumers can drop redelivered ones
+	Kind         string    `json:"kind"`
+	Node         string    `json:"node"`
+	Time         time.Time `json:"time"`
+	ConnectionID string    `json:"connection_id"`
+	UserID       string    `json:"user_id"`
+	Transport    string    `json:"transport,omitempty"`
+	Channel      string    `json:"channel,omitempty"`
+	Route        string    `json:"route,omitempty"` // For messages: handler, direct, channel or broadcast
+	Message      *Message  `json:"message,omitempty"`
+}
+
+// Key returns what the event is partitioned by: its channel, so each
+// channel's messages stay in order, or else its user
+func (e *SinkEvent) Key() string {
+	if e.Channel != "" {
+		return e.Channel
+	}
+	return e.UserID
+}
+
+// EventSink receives the server's event stream, e.g. for analytics or
+// replay. Publish must not block.
+type EventSink interface {
+	Publish(event *SinkEvent)
+}
+
+// SetEventSink sends every processed message and connection event to sink;
+// nil stops them
+func (s *Server) SetEventSink(sink EventSink) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.eventSink = sink
+}
+
+// publishEvent hands an event about a connection, and the message it sent
+// if any, to the event sink
+func (s *Server) publishEvent(kind string, conn *Connection, msg *Message, route string) {
+	s.mu.RLock()
+	sink := s.eventSink
+	s.mu.RUnlock()
+	if sink == nil {
+		return
+	}
+
+	event := &SinkEvent{
+		Schema:       SinkEventSchema,
+		ID:           uuid.New().String(),
+		Kind:         kind,
+		Node:         s.config.NodeID,
+		Time:         s.now(),
+		ConnectionID: conn.ID,
+		UserID:       conn.UserID,
+		Transport:    conn.Transport,
+		Route:        route,
+		Message:      msg,
+	}
+	if msg != nil {
+		event.Channel = msg.Channel
+	}
+	sink.Publish(event)
+}
@@ -1,12 +1,16 @@
+// Command advanced shows patterns for extending an embedded WebSocket server
+// with hooks: rate limiting, status tracking, metadata, an event bus, a session
+// manager and a middleware chain.
 package main
 
-// ADVANCED USAGE EXAMPLES
-// This file shows advanced patterns for using the WebSocket server
-
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"time"
+
+	ws "github.com/Sirtheprogrammer/go-ws-socket"
+	"github.com/google/uuid"
 )
 
 // ===============================================
@@ -25,8 +29,8 @@ func NewRateLimiter(window time.Duration) *RateLimiter {
 	}
 }
 
-func RateLimitingBeforeHook(limiter *RateLimiter, messagesPerSecond int) func(*Connection, *Message) error {
-	return func(conn *Connection, msg *Message) error {
+func RateLimitingBeforeHook(limiter *RateLimiter, messagesPerSecond int) func(*ws.Connection, *ws.Message) error {
+	return func(conn *ws.Connection, msg *ws.Message) error {
 		userID := conn.UserID
 		current := limiter.limits[userID]
 
@@ -46,42 +50,24 @@ func RateLimitingBeforeHook(limiter *RateLimiter, messagesPerSecond int) func(*C
 }
 
 // ===============================================
-// Example 2: Message Encryption/Decryption
+// Example 2: Message Encryption
 // ===============================================
 
-type SecureMessageHandler struct {
-	encryptionKey string
-}
-
-func NewSecureMessageHandler(key string) *SecureMessageHandler {
-	return &SecureMessageHandler{encryptionKey: key}
-}
-
-func (h *SecureMessageHandler) BeforeHook(conn *Connection, msg *Message) error {
-	// Decrypt message payload
-	if encrypted, ok := msg.Payload["encrypted"].(bool); ok && encrypted {
-		// TODO: Implement decryption logic
-		log.Printf("Decrypting message from %s", msg.Sender)
-	}
-	return nil
-}
-
-func (h *SecureMessageHandler) AfterHook(conn *Connection, msg *Message) error {
-	// Encrypt message before sending
-	// TODO: Implement encryption logic
-	log.Printf("Message will be encrypted before sending")
-	return nil
-}
+// Messages are encrypted at rest by wrapping the store rather than in a hook;
+// see "Encryption at Rest" in the README:
+//
+//	store := ws.NewEncryptedStore(ws.NewInMemoryMessageStore(), keys)
+//	server.SetMessageStore(store)
 
 // ===============================================
 // Example 3: User Status Tracker
 // ===============================================
 
 type UserStatus struct {
-	UserID    string
-	Status    string
-	LastSeen  time.Time
-	Channels  []string
+	UserID   string
+	Status   string
+	LastSeen time.Time
+	Channels []string
 }
 
 type UserStatusTracker struct {
@@ -94,7 +80,7 @@ func NewUserStatusTracker() *UserStatusTracker {
 	}
 }
 
-func (t *UserStatusTracker) TrackConnection(conn *Connection) error {
+func (t *UserStatusTracker) TrackConnection(conn *ws.Connection) error {
 	t.statuses[conn.UserID] = &UserStatus{
 		UserID:   conn.UserID,
 		Status:   "online",
@@ -105,7 +91,7 @@ func (t *UserStatusTracker) TrackConnection(conn *Connection) error {
 	return nil
 }
 
-func (t *UserStatusTracker) TrackDisconnection(conn *Connection) error {
+func (t *UserStatusTracker) TrackDisconnection(conn *ws.Connection) error {
 	if status, exists := t.statuses[conn.UserID]; exists {
 		status.Status = "offline"
 		status.LastSeen = time.Now()
@@ -118,85 +104,21 @@ func (t *UserStatusTracker) TrackDisconnection(conn *Connection) error {
 // Example 4: Message Persistence
 // ===============================================
 
-type MessageStore interface {
-	SaveMessage(msg *Message) error
-	GetMessagesByChannel(channel string, limit int) ([]*Message, error)
-	GetMessagesByUser(userID string, limit int) ([]*Message, error)
-}
-
-type InMemoryMessageStore struct {
-	messages []*Message
-}
-
-func NewInMemoryMessageStore() *InMemoryMessageStore {
-	return &InMemoryMessageStore{
-		messages: make([]*Message, 0),
-	}
-}
-
-func (s *InMemoryMessageStore) SaveMessage(msg *Message) error {
-	s.messages = append(s.messages, msg)
-	// In production, use database
-	log.Printf("Message %s persisted", msg.ID)
-	return nil
-}
-
-func (s *InMemoryMessageStore) GetMessagesByChannel(channel string, limit int) ([]*Message, error) {
-	result := make([]*Message, 0)
-	for _, msg := range s.messages {
-		if msg.Channel == channel {
-			result = append(result, msg)
-			if len(result) >= limit {
-				break
-			}
-		}
-	}
-	return result, nil
-}
-
-func (s *InMemoryMessageStore) GetMessagesByUser(userID string, limit int) ([]*Message, error) {
-	result := make([]*Message, 0)
-	for _, msg := range s.messages {
-		if msg.Sender == userID || msg.Recipient == userID {
-			result = append(result, msg)
-			if len(result) >= limit {
-				break
-			}
-		}
-	}
-	return result, nil
-}
+// A MessageStore backs the REST history API and can also be wired into a hook:
+//
+//	store := ws.NewInMemoryMessageStore()
+//	server.RegisterAfterMessageHook(func(conn *ws.Connection, msg *ws.Message) error {
+//		return store.SaveMessage(msg)
+//	})
 
 // ===============================================
 // Example 5: Multi-Channel Router
 // ===============================================
 
-type ChannelRouter struct {
-	routes map[string]Handler
-}
-
-func NewChannelRouter() *ChannelRouter {
-	return &ChannelRouter{
-		routes: make(map[string]Handler),
-	}
-}
-
-func (r *ChannelRouter) RegisterChannelHandler(channel string, handler Handler) {
-	r.routes[channel] = handler
-}
-
-func (r *ChannelRouter) RouteByChannel(conn *Connection, msg *Message) error {
-	if msg.Channel == "" {
-		return fmt.Errorf("channel is required for channel routing")
-	}
-
-	if handler, exists := r.routes[msg.Channel]; exists {
-		return handler(conn, msg)
-	}
-
-	log.Printf("No specific handler for channel %s, using default", msg.Channel)
-	return nil
-}
+// Channel routing is built into the server:
+//
+//	server.RegisterChannelHandler("games:trivia", TriviaHandler, ws.MessageTypeChatGroup)
+//	server.RegisterChannelHandler("games:*", GameHandler)
 
 // ===============================================
 // Example 6: Connection Metadata Manager
@@ -236,20 +158,20 @@ func (m *MetadataManager) CleanMetadata(connID string) {
 // ===============================================
 
 type EventBus struct {
-	subscribers map[string][]func(*Event)
+	subscribers map[string][]func(*ws.Event)
 }
 
 func NewEventBus() *EventBus {
 	return &EventBus{
-		subscribers: make(map[string][]func(*Event)),
+		subscribers: make(map[string][]func(*ws.Event)),
 	}
 }
 
-func (b *EventBus) Subscribe(eventName string, handler func(*Event)) {
+func (b *EventBus) Subscribe(eventName string, handler func(*ws.Event)) {
 	b.subscribers[eventName] = append(b.subscribers[eventName], handler)
 }
 
-func (b *EventBus) Publish(event *Event) {
+func (b *EventBus) Publish(event *ws.Event) {
 	if handlers, exists := b.subscribers[event.Name]; exists {
 		for _, handler := range handlers {
 			go handler(event)
@@ -307,7 +229,7 @@ func (s *SessionManager) CloseSession(connID string) {
 // Example 9: Message Middleware Chain
 // ===============================================
 
-type Middleware func(*Connection, *Message) error
+type Middleware func(*ws.Connection, *ws.Message) error
 
 type MiddlewareChain struct {
 	middlewares []Middleware
@@ -324,7 +246,7 @@ func (mc *MiddlewareChain) Use(m Middleware) *MiddlewareChain {
 	return mc
 }
 
-func (mc *MiddlewareChain) Execute(conn *Connection, msg *Message) error {
+func (mc *MiddlewareChain) Execute(conn *ws.Connection, msg *ws.Message) error {
 	for _, m := range mc.middlewares {
 		if err := m(conn, msg); err != nil {
 			return err
@@ -337,44 +259,50 @@ func (mc *MiddlewareChain) Execute(conn *Connection, msg *Message) error {
 // Example Usage
 // ===============================================
 
-/*
-
-Example setup with all advanced features:
-
-func setupAdvancedServer() *Server {
-    server := NewServer(ServerConfig{})
-    
-    // Setup rate limiter
-    limiter := NewRateLimiter(time.Second)
-    server.RegisterBeforeMessageHook(
-        RateLimitingBeforeHook(limiter, 10),
-    )
-    
-    // Setup user status tracking
-    tracker := NewUserStatusTracker()
-    server.RegisterOnConnectHook(tracker.TrackConnection)
-    server.RegisterOnDisconnectHook(tracker.TrackDisconnection)
-    
-    // Setup message persistence
-    store := NewInMemoryMessageStore()
-    server.RegisterAfterMessageHook(func(conn *Connection, msg *Message) error {
-        return store.SaveMessage(msg)
-    })
-    
-    // Setup event bus
-    eventBus := NewEventBus()
-    eventBus.Subscribe("message:sent", func(evt *Event) {
-        log.Printf("Event: %v", evt)
-    })
-    
-    // Setup session manager
-    sessionMgr := NewSessionManager()
-    server.RegisterOnConnectHook(func(conn *Connection) error {
-        sessionMgr.CreateSession(conn.ID, conn.UserID)
-        return nil
-    })
-    
-    return server
+// setupAdvancedServer builds a server with the examples above wired in
+func setupAdvancedServer() *ws.Server {
+	server := ws.NewServer(ws.ServerConfig{})
+	ws.RegisterDefaultHandlers(server)
+
+	// Setup rate limiter
+	limiter := NewRateLimiter(time.Second)
+	server.RegisterBeforeMessageHook(RateLimitingBeforeHook(limiter, 10))
+
+	// Setup user status tracking and sessions
+	tracker := NewUserStatusTracker()
+	sessionMgr := NewSessionManager()
+	server.RegisterOnConnectHook(func(conn *ws.Connection) error {
+		sessionMgr.CreateSession(conn.ID, conn.UserID)
+		return tracker.TrackConnection(conn)
+	})
+	server.RegisterOnDisconnectHook(func(conn *ws.Connection) error {
+		sessionMgr.CloseSession(conn.ID)
+		return tracker.TrackDisconnection(conn)
+	})
+
+	// Setup message persistence
+	store := ws.NewInMemoryMessageStore()
+	server.RegisterAfterMessageHook(func(conn *ws.Connection, msg *ws.Message) error {
+		return store.SaveMessage(msg)
+	})
+
+	// Setup event bus
+	eventBus := NewEventBus()
+	eventBus.Subscribe("message:sent", func(evt *ws.Event) {
+		log.Printf("Event: %v", evt)
+	})
+
+	return server
+}
+
+func main() {
+	server := setupAdvancedServer()
+	go server.ProcessMessages()
+
+	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		connID := "conn_" + uuid.New().String()[:12]
+		server.HandleConnection(w, r, connID, r.URL.Query().Get("user_id"))
+	})
+	log.Println("Advanced example running on :8080")
+	log.Fatal(http.ListenAndServe(":8080", nil))
 }
-
-*/
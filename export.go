@@ -0,0 +1,511 @@
+package wssocket
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Export formats
+const (
+	ExportFormatJSON = "json"
+	ExportFormatCSV  = "csv"
+)
+
+// Export states
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// DefaultExportTTL is how long a finished export can be downloaded
+const DefaultExportTTL = 24 * time.Hour
+
+// ErrInvalidExportFormat is returned for a format other than json or csv
+var ErrInvalidExportFormat = errors.New("format must be json or csv")
+
+// Export is a user's data export and its progress
+type Export struct {
+	ID        string `json:"id"`
+	UserID    string `json:"user_id"`
+	Format    string `json:"format"`
+	Status    string `json:"status"`
+	Stage     string `json:"stage,omitempty"` // What is being collected: messages, channels, reads or archive
+	Messages  int    `json:"messages"`        // Messages collected so far
+	Size      int64  `json:"size,omitempty"`  // Archive bytes, once ready
+	Error     string `json:"error,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at,omitempty"` // When a ready export is deleted
+}
+
+// payload is the export as the body of an export:progress message
+func (x *Export) payload() map[string]interface{} {
+	payload := map[string]interface{}{
+		"export_id": x.ID,
+		"format":    x.Format,
+		"status":    x.Status,
+		"messages":  x.Messages,
+	}
+	if x.Stage != "" {
+		payload["stage"] = x.Stage
+	}
+	if x.Status == ExportReady {
+		payload["size"] = x.Size
+		payload["expires_at"] = x.ExpiresAt
+		payload["url"] = fmt.Sprintf("/api/users/%s/export/%s", url.PathEscape(x.UserID), x.ID)
+	}
+	if x.Error != "" {
+		payload["error"] = x.Error
+	}
+	return payload
+}
+
+// blobKey is where the export's archive is stored
+func (x *Export) blobKey() string {
+	return fmt.Sprintf("exports/%s/%s.zip", url.PathEscape(x.UserID), x.ID)
+}
+
+// UserChannelLister is a ChannelStore that can list a user's channels
+type UserChannelLister interface {
+	// ListUserChannels returns a user's recorded roles, by channel
+	ListUserChannels(userID string) ([]*ChannelMember, error)
+}
+
+// exportData is everything an export archive holds
+type exportData struct {
+	Profile  *UserProfile
+	Messages []*Message // Oldest first
+	Channels []*ChannelMember
+	Reads    []*ChannelReadState
+}
+
+// Exporter builds user data exports in the background and keeps each one in
+// a BlobStore until it expires
+type Exporter struct {
+	server *Server
+	blobs  BlobStore
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	exports map[string]*Export // ID -> export
+}
+
+// Global exporter (nil when exports are disabled)
+var globalExporter *Exporter
+
+// NewExporter creates an exporter storing archives in blobs for ttl
+func NewExporter(server *Server, blobs BlobStore, ttl time.Duration) *Exporter {
+	if ttl <= 0 {
+		ttl = DefaultExportTTL
+	}
+	return &Exporter{
+		server:  server,
+		blobs:   blobs,
+		ttl:     ttl,
+		exports: make(map[string]*Export),
+	}
+}
+
+// Start begins exporting a user's data. A user has one export in progress
+// at a time; starting another while it runs returns it.
+func (e *Exporter) Start(userID, format string) (*Export, error) {
+	if format == "" {
+		format = ExportFormatJSON
+	}
+	if format != ExportFormatJSON && format != ExportFormatCSV {
+		return nil, ErrInvalidExportFormat
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, x := range e.exports {
+		if x.UserID == userID && (x.Status == ExportPending || x.Status == ExportRunning) {
+			copied := *x
+			return &copied, nil
+		}
+	}
+	export := &Export{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Format:    format,
+		Status:    ExportPending,
+		CreatedAt: e.server.now().Unix(),
+	}
+	e.exports[export.ID] = export
+	go e.run(export)
+
+	copied := *export
+	return &copied, nil
+}
+
+// Get returns a copy of an export
+func (e *Exporter) Get(id string) (*Export, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	x, exists := e.exports[id]
+	if !exists {
+		return nil, false
+	}
+	copied := *x
+	return &copied, true
+}
+
+// List returns copies of a user's exports, newest first
+func (e *Exporter) List(userID string) []*Export {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	exports := make([]*Export, 0)
+	for _, x := range e.exports {
+		if x.UserID == userID {
+			copied := *x
+			exports = append(exports, &copied)
+		}
+	}
+	sort.Slice(exports, func(i, j int) bool {
+		if exports[i].CreatedAt != exports[j].CreatedAt {
+			return exports[i].CreatedAt > exports[j].CreatedAt
+		}
+		return exports[i].ID > exports[j].ID
+	})
+	return exports
+}
+
+// Forget deletes a user's exports and their archives
+func (e *Exporter) Forget(userID string) {
+	e.mu.Lock()
+	stored := make([]*Export, 0)
+	for id, x := range e.exports {
+		if x.UserID == userID {
+			delete(e.exports, id)
+			if x.Status == ExportReady {
+				stored = append(stored, x)
+			}
+		}
+	}
+	e.mu.Unlock()
+	for _, x := range stored {
+		e.deleteArchive(x)
+	}
+}
+
+// expire removes an export once its download window ends
+func (e *Exporter) expire(export *Export) {
+	e.mu.Lock()
+	_, exists := e.exports[export.ID]
+	delete(e.exports, export.ID)
+	stored := export.Status == ExportReady
+	e.mu.Unlock()
+	if exists && stored {
+		e.deleteArchive(export)
+	}
+}
+
+// deleteArchive removes an export's archive from the blob store
+func (e *Exporter) deleteArchive(export *Export) {
+	if err := e.blobs.Delete(export.blobKey()); err != nil {
+		log.Printf("Error deleting export %s: %v", export.ID, err)
+	}
+}
+
+// update changes an export and tells the user's connections how it is going
+func (e *Exporter) update(export *Export, fn func(x *Export)) {
+	e.mu.Lock()
+	fn(export)
+	payload := export.payload()
+	e.mu.Unlock()
+
+	e.server.sendToUser(export.UserID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeExportProgress,
+		Sender:    "system",
+		Recipient: export.UserID,
+		Timestamp: e.server.now().Unix(),
+		Payload:   payload,
+	})
+}
+
+// run collects the user's data, stores the archive and reports the outcome
+func (e *Exporter) run(export *Export) {
+	e.update(export, func(x *Export) { x.Status, x.Stage = ExportRunning, "messages" })
+
+	size, err := e.build(export)
+	if err != nil {
+		log.Printf("Export %s for %s failed: %v", export.ID, export.UserID, err)
+		e.update(export, func(x *Export) { x.Status, x.Stage, x.Error = ExportFailed, "", err.Error() })
+		time.AfterFunc(e.ttl, func() { e.expire(export) })
+		return
+	}
+	e.mu.Lock()
+	_, exists := e.exports[export.ID]
+	e.mu.Unlock()
+	if !exists {
+		// Forgotten while it ran, as when the user was erased
+		e.deleteArchive(export)
+		return
+	}
+	e.update(export, func(x *Export) {
+		x.Status, x.Stage, x.Size = ExportReady, "", size
+		x.ExpiresAt = e.server.now().Add(e.ttl).Unix()
+	})
+	time.AfterFunc(e.ttl, func() { e.expire(export) })
+	log.Printf("Export %s for %s ready (%d bytes)", export.ID, export.UserID, size)
+}
+
+// build gathers the user's data into an archive in the blob store and
+// returns its size
+func (e *Exporter) build(export *Export) (int64, error) {
+	var data exportData
+	var err error
+	if data.Profile, err = e.server.UserProfile(export.UserID); err != nil {
+		return 0, err
+	}
+	if data.Messages, err = e.collectMessages(export); err != nil {
+		return 0, fmt.Errorf("messages: %w", err)
+	}
+
+	e.update(export, func(x *Export) { x.Stage = "channels" })
+	data.Channels = make([]*ChannelMember, 0)
+	if lister, ok := globalChannelStore.(UserChannelLister); ok {
+		if data.Channels, err = lister.ListUserChannels(export.UserID); err != nil {
+			return 0, fmt.Errorf("channels: %w", err)
+		}
+	}
+
+	e.update(export, func(x *Export) { x.Stage = "reads" })
+	data.Reads = make([]*ChannelReadState, 0)
+	if rs, ok := readStateStore(); ok {
+		if data.Reads, err = rs.ChannelReadStates(export.UserID, nil); err != nil {
+			return 0, fmt.Errorf("reads: %w", err)
+		}
+	}
+
+	e.update(export, func(x *Export) { x.Stage = "archive" })
+	var buf bytes.Buffer
+	if err := writeExportArchive(&buf, export.Format, &data); err != nil {
+		return 0, fmt.Errorf("archive: %w", err)
+	}
+	size := int64(buf.Len())
+	if err := e.blobs.Put(export.blobKey(), &buf, size, "application/zip"); err != nil {
+		return 0, fmt.Errorf("store archive: %w", err)
+	}
+	return size, nil
+}
+
+// collectMessages reads every message the user sent or received, page by
+// page from the newest, and returns them oldest first
+func (e *Exporter) collectMessages(export *Export) ([]*Message, error) {
+	if globalStore == nil {
+		return []*Message{}, nil
+	}
+	pages := make([][]*Message, 0)
+	page := Page{Limit: MaxPageLimit}
+	total := 0
+	for {
+		msgs, err := globalStore.GetUserMessages(export.UserID, page)
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		pages = append(pages, msgs)
+		total += len(msgs)
+		e.update(export, func(x *Export) { x.Messages = total })
+		if len(msgs) < page.Limit {
+			break
+		}
+		page.Before = &Cursor{Timestamp: msgs[0].Timestamp, ID: msgs[0].ID}
+	}
+
+	messages := make([]*Message, 0, total)
+	for i := len(pages) - 1; i >= 0; i-- {
+		messages = append(messages, pages[i]...)
+	}
+	return messages, nil
+}
+
+// writeExportArchive writes the data as a zip of JSON or CSV files. The
+// profile is always JSON, since its metadata can nest.
+func writeExportArchive(w io.Writer, format string, data *exportData) error {
+	zw := zip.NewWriter(w)
+	writeJSONFile := func(name string, v interface{}) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	writeCSVFile := func(name string, header []string, rows [][]string) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		cw := csv.NewWriter(f)
+		cw.Write(header)
+		cw.WriteAll(rows)
+		return cw.Error()
+	}
+
+	if err := writeJSONFile("profile.json", data.Profile); err != nil {
+		return err
+	}
+	if format == ExportFormatCSV {
+		messages := make([][]string, 0, len(data.Messages))
+		for _, msg := range data.Messages {
+			var payload, metadata []byte
+			if structured := structuredPayload(msg); structured != nil {
+				payload, _ = json.Marshal(structured)
+			}
+			if len(msg.Metadata) > 0 {
+				metadata, _ = json.Marshal(msg.Metadata)
+			}
+			messages = append(messages, []string{
+				msg.ID, strconv.FormatInt(msg.Timestamp, 10), string(msg.Type), msg.Channel,
+				msg.Sender, msg.Recipient, messageText(msg), string(payload), string(metadata),
+			})
+		}
+		channels := make([][]string, 0, len(data.Channels))
+		for _, m := range data.Channels {
+			channels = append(channels, []string{m.Channel, string(m.Role), strconv.FormatInt(m.UpdatedAt, 10)})
+		}
+		reads := make([][]string, 0, len(data.Reads))
+		for _, rs := range data.Reads {
+			reads = append(reads, []string{rs.Channel, rs.LastReadMessageID, strconv.FormatInt(rs.LastReadAt, 10),
+				strconv.Itoa(rs.Unread), strconv.FormatInt(rs.UpdatedAt, 10)})
+		}
+
+		if err := writeCSVFile("messages.csv", []string{"id", "timestamp", "type", "channel", "sender", "recipient", "content", "payload", "metadata"}, messages); err != nil {
+			return err
+		}
+		if err := writeCSVFile("channels.csv", []string{"channel", "role", "updated_at"}, channels); err != nil {
+			return err
+		}
+		if err := writeCSVFile("reads.csv", []string{"channel", "last_read_message_id", "last_read_at", "unread", "updated_at"}, reads); err != nil {
+			return err
+		}
+	} else {
+		messages := make([]*HistoryMessage, 0, len(data.Messages))
+		for _, msg := range data.Messages {
+			messages = append(messages, NewHistoryMessage(msg))
+		}
+		if err := writeJSONFile("messages.json", messages); err != nil {
+			return err
+		}
+		if err := writeJSONFile("channels.json", data.Channels); err != nil {
+			return err
+		}
+		if err := writeJSONFile("reads.json", data.Reads); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// ListUserChannels returns a user's recorded roles, by channel
+func (s *InMemoryChannelStore) ListUserChannels(userID string) ([]*ChannelMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	members := make([]*ChannelMember, 0)
+	for _, byUser := range s.members {
+		if m, exists := byUser[userID]; exists {
+			copied := *m
+			members = append(members, &copied)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Channel < members[j].Channel })
+	return members, nil
+}
+
+// setupExportRoutes registers the user data export endpoints
+func setupExportRoutes(mux *http.ServeMux) {
+	// POST starts an export of the user's data, as JSON files or, with
+	// ?format=csv, CSV ones; progress arrives as export:progress messages.
+	// GET lists the user's exports.
+	mux.HandleFunc("/api/users/{id}/export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if globalExporter == nil {
+			http.Error(w, "Exports not available", http.StatusServiceUnavailable)
+			return
+		}
+		userID, ok := requestUser(w, r, r.PathValue("id"))
+		if !ok {
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			exports := globalExporter.List(userID)
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"exports": exports,
+				"count":   len(exports),
+			})
+			return
+		}
+		export, err := globalExporter.Start(userID, r.URL.Query().Get("format"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, export)
+	})
+
+	// GET downloads a ready export
+	mux.HandleFunc("/api/users/{id}/export/{export_id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if globalExporter == nil {
+			http.Error(w, "Exports not available", http.StatusServiceUnavailable)
+			return
+		}
+		userID, ok := requestUser(w, r, r.PathValue("id"))
+		if !ok {
+			return
+		}
+
+		export, exists := globalExporter.Get(r.PathValue("export_id"))
+		if !exists || export.UserID != userID {
+			http.Error(w, "export not found", http.StatusNotFound)
+			return
+		}
+		if export.Status != ExportReady {
+			http.Error(w, "export is "+export.Status, http.StatusConflict)
+			return
+		}
+
+		body, err := globalExporter.blobs.Get(export.blobKey())
+		if err != nil {
+			log.Printf("Error reading export %s: %v", export.ID, err)
+			http.Error(w, "Failed to read export", http.StatusInternalServerError)
+			return
+		}
+		defer body.Close()
+
+		filename := fmt.Sprintf("export-%s-%s.zip", export.UserID, time.Unix(export.CreatedAt, 0).UTC().Format("20060102"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		w.Header().Set("Content-Length", strconv.FormatInt(export.Size, 10))
+		io.Copy(w, body)
+	})
+}
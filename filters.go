@@ -0,0 +1,541 @@
+package wssocket
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter limits
+const (
+	MaxFiltersPerConnection = 16  // Channels, patterns and the catch-all filter combined
+	MaxFilterLength         = 512 // Characters in a filter expression
+)
+
+// Filter is a server-side condition on the messages a connection receives,
+// such as `severity >= warning` or `type == chat and mentions_me`.
+//
+// A comparison is a field, an operator and a value: ==, !=, <, <=, >, >=
+// or contains. A field on its own tests that it is set and not false, zero
+// or empty. Comparisons combine with and, or, not and parentheses (or &&,
+// || and !). The fields are id, type, sender, channel, recipient,
+// payload.<path>, metadata.<path> and mentions_me; any other name is looked
+// up in the payload and then the metadata. Values are numbers, true, false,
+// or strings, quoted or bare. Alert severities compare by urgency.
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+// String returns the expression the filter was parsed from
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match reports whether a message passes the filter for a connection of userID
+func (f *Filter) Match(msg *Message, userID string) bool {
+	return f.root.eval(msg, userID)
+}
+
+// ParseFilter parses a filter expression
+func ParseFilter(expr string) (*Filter, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, fmt.Errorf("filter is empty")
+	}
+	if len([]rune(expr)) > MaxFilterLength {
+		return nil, fmt.Errorf("filter is longer than %d characters", MaxFilterLength)
+	}
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in filter", p.tokens[p.pos].text)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// filterToken is a lexed piece of a filter expression. Quoted strings are
+// kept apart so "and" in quotes is a value, not an operator.
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+// lexFilter splits a filter expression into tokens
+func lexFilter(expr string) ([]filterToken, error) {
+	tokens := make([]filterToken, 0)
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, filterToken{text: string(r)})
+			i++
+		case r == '"' || r == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				b.WriteRune(runes[j])
+			}
+			if j == len(runes) {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			tokens = append(tokens, filterToken{text: b.String(), quoted: true})
+			i = j + 1
+		case strings.ContainsRune("=!<>&|", r):
+			j := i + 1
+			for j < len(runes) && j < i+2 && strings.ContainsRune("=&|", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, filterToken{text: string(runes[i:j])})
+			i = j
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune("()\"'=!<>&|", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, filterToken{text: string(runes[i:j])})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// filterParser is a recursive descent parser over filter tokens
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// peek returns the next unquoted token's text, or "" at the end
+func (p *filterParser) peek() string {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].quoted {
+		return ""
+	}
+	return p.tokens[p.pos].text
+}
+
+// keyword reports whether the next token is one of words, consuming it if so
+func (p *filterParser) keyword(words ...string) bool {
+	next := strings.ToLower(p.peek())
+	for _, w := range words {
+		if next == w {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or", "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = filterOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and", "&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = filterAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.keyword("not", "!") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{inner}, nil
+	}
+	if p.keyword("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword(")") {
+			return nil, fmt.Errorf("missing ) in filter")
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+// filterOperators are the comparison operators
+var filterOperators = map[string]bool{
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "contains": true,
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("filter ends early")
+	}
+	field := p.tokens[p.pos]
+	if field.quoted || field.text == ")" || filterOperators[field.text] {
+		return nil, fmt.Errorf("expected a field name in filter, got %q", field.text)
+	}
+	p.pos++
+
+	op := strings.ToLower(p.peek())
+	if op == "=" {
+		op = "=="
+	}
+	if !filterOperators[op] {
+		return filterTruthy{field.text}, nil
+	}
+	p.pos++
+	if p.pos >= len(p.tokens) || (!p.tokens[p.pos].quoted && strings.ContainsAny(p.tokens[p.pos].text, "()")) {
+		return nil, fmt.Errorf("expected a value after %s in filter", op)
+	}
+	value := filterLiteral(p.tokens[p.pos])
+	p.pos++
+	return filterCompare{field: field.text, op: op, value: value}, nil
+}
+
+// filterLiteral converts a value token to a number, bool or string
+func filterLiteral(tok filterToken) interface{} {
+	if tok.quoted {
+		return tok.text
+	}
+	switch strings.ToLower(tok.text) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if n, err := strconv.ParseFloat(tok.text, 64); err == nil {
+		return n
+	}
+	return tok.text
+}
+
+// filterNode is a parsed filter expression
+type filterNode interface {
+	eval(msg *Message, userID string) bool
+}
+
+type filterAnd [2]filterNode
+type filterOr [2]filterNode
+type filterNot [1]filterNode
+
+func (n filterAnd) eval(msg *Message, userID string) bool {
+	return n[0].eval(msg, userID) && n[1].eval(msg, userID)
+}
+
+func (n filterOr) eval(msg *Message, userID string) bool {
+	return n[0].eval(msg, userID) || n[1].eval(msg, userID)
+}
+
+func (n filterNot) eval(msg *Message, userID string) bool {
+	return !n[0].eval(msg, userID)
+}
+
+// filterTruthy tests that a field is set and not false, zero or empty
+type filterTruthy struct {
+	field string
+}
+
+func (n filterTruthy) eval(msg *Message, userID string) bool {
+	switch v := filterField(msg, userID, n.field).(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case []string:
+		return len(v) > 0
+	default:
+		n, ok := filterNumber(v)
+		return !ok || n != 0
+	}
+}
+
+// filterCompare compares a field with a value
+type filterCompare struct {
+	field string
+	op    string
+	value interface{}
+}
+
+func (n filterCompare) eval(msg *Message, userID string) bool {
+	actual := filterField(msg, userID, n.field)
+	if n.op == "contains" {
+		return filterContains(actual, n.value)
+	}
+	if actual == nil {
+		return n.op == "!="
+	}
+	cmp, ok := filterCmp(actual, n.value)
+	if !ok {
+		return n.op == "!="
+	}
+	switch n.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// filterField looks up a field of a message, nil when it's missing
+func filterField(msg *Message, userID, field string) interface{} {
+	switch field {
+	case "id":
+		return msg.ID
+	case "type":
+		return string(msg.Type)
+	case "sender":
+		return msg.Sender
+	case "channel":
+		return msg.Channel
+	case "recipient":
+		return msg.Recipient
+	case "mentions_me":
+		return mentionsUser(msg, userID)
+	}
+	if path, ok := strings.CutPrefix(field, "payload."); ok {
+		return filterPath(msg.Payload, path)
+	}
+	if path, ok := strings.CutPrefix(field, "metadata."); ok {
+		return filterPath(msg.Metadata, path)
+	}
+	if v := filterPath(msg.Payload, field); v != nil {
+		return v
+	}
+	return filterPath(msg.Metadata, field)
+}
+
+// filterPath follows a dotted path through nested objects
+func filterPath(m map[string]interface{}, path string) interface{} {
+	var v interface{} = m
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if v, ok = obj[key]; !ok {
+			return nil
+		}
+	}
+	return v
+}
+
+// filterNumber converts the numeric types a payload may hold
+func filterNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// filterCmp orders a field's value against a filter value. Numbers compare
+// numerically, alert severities by urgency and other strings as text.
+func filterCmp(actual, value interface{}) (int, bool) {
+	if want, ok := value.(bool); ok {
+		got, ok := actual.(bool)
+		if !ok {
+			return 0, false
+		}
+		if got == want {
+			return 0, true
+		}
+		return 1, true
+	}
+	if want, ok := value.(float64); ok {
+		got, ok := filterNumber(actual)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case got < want:
+			return -1, true
+		case got > want:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	got := fmt.Sprint(actual)
+	want := fmt.Sprint(value)
+	gotRank, gotSeverity := alertSeverityRank[AlertSeverity(strings.ToLower(got))]
+	wantRank, wantSeverity := alertSeverityRank[AlertSeverity(strings.ToLower(want))]
+	if gotSeverity && wantSeverity {
+		return gotRank - wantRank, true
+	}
+	return strings.Compare(got, want), true
+}
+
+// filterContains reports whether a string contains a substring, or a list
+// an element equal to the value
+func filterContains(actual, value interface{}) bool {
+	want := fmt.Sprint(value)
+	switch v := actual.(type) {
+	case string:
+		return strings.Contains(v, want)
+	case []string:
+		for _, item := range v {
+			if item == want {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if cmp, ok := filterCmp(item, value); ok && cmp == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetFilter registers the filter for a channel or channel pattern, or with
+// an empty channel the one for every message routed to the connection. A
+// nil filter removes it.
+func (c *Connection) SetFilter(channel string, f *Filter) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f == nil {
+		delete(c.filters, channel)
+		return nil
+	}
+	if _, exists := c.filters[channel]; !exists && len(c.filters) >= MaxFiltersPerConnection {
+		return fmt.Errorf("at most %d filters per connection", MaxFiltersPerConnection)
+	}
+	if c.filters == nil {
+		c.filters = make(map[string]*Filter)
+	}
+	c.filters[channel] = f
+	return nil
+}
+
+// Filters returns a snapshot of the connection's filter expressions by channel
+func (c *Connection) Filters() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	filters := make(map[string]string, len(c.filters))
+	for channel, f := range c.filters {
+		filters[channel] = f.String()
+	}
+	return filters
+}
+
+// wants reports whether a message passes the connection's filters. The
+// catch-all filter always applies; for a broadcast on channel, so do the
+// filters of that channel and of patterns matching it.
+func (c *Connection) wants(msg *Message, channel string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for key, f := range c.filters {
+		if key != "" && (channel == "" || !MatchChannel(key, channel)) {
+			continue
+		}
+		if !f.Match(msg, c.UserID) {
+			return false
+		}
+	}
+	return true
+}
+
+// rejectFilter tells the sender their filter was refused
+func (s *Server) rejectFilter(conn *Connection, msg *Message, err error) error {
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeError,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"code":       "invalid_filter",
+			"error":      err.Error(),
+			"message_id": msg.ID,
+		},
+	})
+	return fmt.Errorf("rejected filter %s: %w", msg.ID, err)
+}
+
+// SubscribeFilterHandler sets or clears the sender's filter for a channel,
+// or for every message when no channel is given. An empty payload filter
+// clears it.
+func SubscribeFilterHandler(conn *Connection, msg *Message) error {
+	expr, _ := msg.Payload["filter"].(string)
+	var f *Filter
+	if strings.TrimSpace(expr) != "" {
+		var err error
+		if f, err = ParseFilter(expr); err != nil {
+			return globalServer.rejectFilter(conn, msg, err)
+		}
+	}
+	if err := conn.SetFilter(msg.Channel, f); err != nil {
+		return globalServer.rejectFilter(conn, msg, err)
+	}
+
+	payload := map[string]interface{}{"channel": msg.Channel, "active": f != nil}
+	if f != nil {
+		payload["filter"] = f.String()
+	}
+	globalServer.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeSubscribeFilter,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Channel:   msg.Channel,
+		Timestamp: globalServer.now().Unix(),
+		Payload:   payload,
+	})
+	return nil
+}
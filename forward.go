@@ -0,0 +1,107 @@
+package wssocket
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+)
+
+// MetadataForwardedFrom records where a forwarded message was copied from
+const MetadataForwardedFrom = "forwarded_from"
+
+// ForwardHandler copies a stored message to the channel or recipient of the
+// forward request. The sender must be able to read the original and post to
+// the destination. The copy is sent by the forwarder, carries the original's
+// id, channel, sender and timestamp in forwarded_from, and passes through the
+// destination's moderation and redaction like any message sent there.
+func ForwardHandler(conn *Connection, msg *Message) error {
+	messageID, _ := msg.Payload["message_id"].(string)
+	if messageID == "" {
+		return fmt.Errorf("message_id is required in payload")
+	}
+	if (msg.Channel == "") == (msg.Recipient == "") {
+		return fmt.Errorf("forward needs exactly one of channel or recipient")
+	}
+	if globalStore == nil {
+		return fmt.Errorf("message store not available")
+	}
+
+	original, err := globalStore.GetMessage(messageID)
+	if errors.Is(err, ErrMessageNotFound) {
+		return fmt.Errorf("message %s not found", messageID)
+	}
+	if err != nil {
+		return fmt.Errorf("load message %s: %w", messageID, err)
+	}
+	if !canReadMessage(conn, original) {
+		return globalServer.rejectForbidden(conn, msg, fmt.Errorf("%s can't read message %s: %w", conn.UserID, messageID, ErrForbidden))
+	}
+	if msg.Channel != "" && (!conn.InChannel(msg.Channel) || isModerationChannel(msg.Channel)) {
+		return globalServer.rejectForbidden(conn, msg, fmt.Errorf("%s can't post to %q: %w", conn.UserID, msg.Channel, ErrForbidden))
+	}
+
+	forwarded := forwardedCopy(original, conn.UserID, msg.Channel, msg.Recipient, globalServer.now().Unix())
+	return globalServer.deliverForward(conn, forwarded)
+}
+
+// canReadMessage reports whether a connection could have received a message
+func canReadMessage(conn *Connection, msg *Message) bool {
+	if msg.Recipient != "" {
+		return msg.Sender == conn.UserID || msg.Recipient == conn.UserID
+	}
+	return msg.Channel != "" && globalServer.follows(conn, msg.Channel)
+}
+
+// forwardedCopy builds the message a forward delivers. Chat messages take the
+// chat type of their destination; other types keep theirs.
+func forwardedCopy(original *Message, sender, channel, recipient string, now int64) *Message {
+	msgType := original.Type
+	switch msgType {
+	case MessageTypeChat, MessageTypeChatGroup, MessageTypeChatPrivate:
+		if recipient != "" {
+			msgType = MessageTypeChatPrivate
+		} else {
+			msgType = MessageTypeChatGroup
+		}
+	}
+
+	from := map[string]interface{}{
+		"message_id": original.ID,
+		"sender":     original.Sender,
+		"timestamp":  original.Timestamp,
+	}
+	if original.Channel != "" {
+		from["channel"] = original.Channel
+	}
+	if original.Recipient != "" {
+		from["recipient"] = original.Recipient
+	}
+
+	return &Message{
+		ID:        generateMessageID(),
+		Type:      msgType,
+		Sender:    sender,
+		Channel:   channel,
+		Recipient: recipient,
+		Timestamp: now,
+		Payload:   maps.Clone(original.Payload),
+		Metadata:  map[string]interface{}{MetadataForwardedFrom: from},
+	}
+}
+
+// deliverForward moderates a forwarded copy for its destination and routes it
+func (s *Server) deliverForward(conn *Connection, msg *Message) error {
+	s.mu.RLock()
+	moderation := s.moderationFor(msg.Channel)
+	s.mu.RUnlock()
+	if moderation != nil {
+		verdict := runModeration(conn.Context(), conn, msg, moderation.Filters, moderation.FailOpen)
+		if err := s.applyVerdict(conn, msg, verdict); err != nil {
+			return err
+		}
+	}
+	s.redact(msg)
+
+	_, err := s.processMessage(conn, msg)
+	return err
+}
@@ -0,0 +1,593 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// TransportGraphQL identifies connections using the GraphQL subscriptions endpoint
+const TransportGraphQL = "graphql"
+
+// Supported GraphQL over WebSocket subprotocols
+const (
+	graphqlTransportWS = "graphql-transport-ws" // graphql-ws library
+	graphqlLegacyWS    = "graphql-ws"           // subscriptions-transport-ws (Apollo legacy)
+)
+
+// graphqlSelection is a parsed root subscription field
+type graphqlSelection struct {
+	Field     string
+	Alias     string
+	Arguments map[string]interface{}
+	Fields    []string
+}
+
+// graphqlLexer splits a GraphQL document into tokens
+type graphqlLexer struct {
+	src string
+	pos int
+}
+
+// next returns the next token: a punctuator, name, $variable or quoted string
+func (l *graphqlLexer) next() (string, error) {
+	for l.pos < len(l.src) {
+		c := rune(l.src[l.pos])
+		if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+			continue
+		}
+		if unicode.IsSpace(c) || c == ',' {
+			l.pos++
+			continue
+		}
+		break
+	}
+	if l.pos >= len(l.src) {
+		return "", nil
+	}
+
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case strings.ContainsRune("{}():!=[]@", rune(c)):
+		l.pos++
+		return string(c), nil
+	case c == '"':
+		l.pos++
+		for l.pos < len(l.src) && l.src[l.pos] != '"' {
+			if l.src[l.pos] == '\\' {
+				l.pos++
+			}
+			l.pos++
+		}
+		if l.pos >= len(l.src) {
+			return "", fmt.Errorf("unterminated string")
+		}
+		l.pos++
+		return l.src[start:l.pos], nil
+	case c == '$' || c == '_' || c == '-' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+		l.pos++
+		for l.pos < len(l.src) {
+			r := rune(l.src[l.pos])
+			if r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				break
+			}
+			l.pos++
+		}
+		return l.src[start:l.pos], nil
+	}
+	return "", fmt.Errorf("unexpected character %q", c)
+}
+
+// peek returns the next token without consuming it
+func (l *graphqlLexer) peek() (string, error) {
+	pos := l.pos
+	tok, err := l.next()
+	l.pos = pos
+	return tok, err
+}
+
+// expect consumes a specific token
+func (l *graphqlLexer) expect(want string) error {
+	tok, err := l.next()
+	if err != nil {
+		return err
+	}
+	if tok != want {
+		return fmt.Errorf("expected %q, got %q", want, tok)
+	}
+	return nil
+}
+
+// parseGraphQLSubscription parses a subscription operation with a single root
+// field, resolving $variables. Only the subset needed for the supported
+// subscription fields is implemented: arguments are scalars and the
+// selection set is a flat list of field names.
+func parseGraphQLSubscription(query string, variables map[string]interface{}) (*graphqlSelection, error) {
+	l := &graphqlLexer{src: query}
+
+	tok, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok != "subscription" {
+		return nil, fmt.Errorf("only subscription operations are supported")
+	}
+
+	// Optional operation name and variable definitions
+	tok, err = l.peek()
+	if err != nil {
+		return nil, err
+	}
+	if tok != "{" && tok != "(" {
+		l.next()
+		tok, _ = l.peek()
+	}
+	if tok == "(" {
+		depth := 0
+		for {
+			t, err := l.next()
+			if err != nil {
+				return nil, err
+			}
+			if t == "" {
+				return nil, fmt.Errorf("unterminated variable definitions")
+			}
+			if t == "(" {
+				depth++
+			} else if t == ")" {
+				depth--
+				if depth == 0 {
+					break
+				}
+			}
+		}
+	}
+
+	if err := l.expect("{"); err != nil {
+		return nil, err
+	}
+
+	sel := &graphqlSelection{Arguments: make(map[string]interface{})}
+	name, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+	if t, _ := l.peek(); t == ":" {
+		l.next()
+		sel.Alias = name
+		if name, err = l.next(); err != nil {
+			return nil, err
+		}
+	}
+	sel.Field = name
+	if sel.Alias == "" {
+		sel.Alias = name
+	}
+
+	if t, _ := l.peek(); t == "(" {
+		l.next()
+		for {
+			argName, err := l.next()
+			if err != nil {
+				return nil, err
+			}
+			if argName == ")" {
+				break
+			}
+			if err := l.expect(":"); err != nil {
+				return nil, err
+			}
+			raw, err := l.next()
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case strings.HasPrefix(raw, "$"):
+				sel.Arguments[argName] = variables[raw[1:]]
+			case strings.HasPrefix(raw, `"`):
+				var s string
+				if err := json.Unmarshal([]byte(raw), &s); err != nil {
+					return nil, fmt.Errorf("invalid string argument: %w", err)
+				}
+				sel.Arguments[argName] = s
+			default:
+				sel.Arguments[argName] = raw
+			}
+		}
+	}
+
+	if err := l.expect("{"); err != nil {
+		return nil, err
+	}
+	for {
+		field, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if field == "}" {
+			break
+		}
+		if field == "" || field == "{" {
+			return nil, fmt.Errorf("nested selections are not supported")
+		}
+		sel.Fields = append(sel.Fields, field)
+	}
+
+	return sel, nil
+}
+
+// graphqlMessageFields maps schema field names to Message values
+func graphqlMessageFields(msg *Message) map[string]interface{} {
+	return map[string]interface{}{
+		"__typename": "Message",
+		"id":         msg.ID,
+		"type":       string(msg.Type),
+		"sender":     msg.Sender,
+		"recipient":  msg.Recipient,
+		"channel":    msg.Channel,
+		"payload":    msg.Payload,
+		"metadata":   msg.Metadata,
+		"timestamp":  msg.Timestamp,
+	}
+}
+
+// project keeps only the selected fields
+func project(values map[string]interface{}, fields []string) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		v, ok := values[f]
+		if !ok {
+			return nil, fmt.Errorf("unknown field: %s", f)
+		}
+		out[f] = v
+	}
+	return out, nil
+}
+
+// graphqlSubscription is an active subscription on a session
+type graphqlSubscription struct {
+	ID        string
+	Selection *graphqlSelection
+	Channel   string
+}
+
+// resolve returns the result for a message, or nil when the message doesn't belong to the subscription
+func (sub *graphqlSubscription) resolve(conn *Connection, msg *Message) (map[string]interface{}, error) {
+	var values map[string]interface{}
+	switch sub.Selection.Field {
+	case "channelMessages":
+		if msg.Channel == "" || !MatchChannel(sub.Channel, msg.Channel) || msg.Type == MessageTypePresence || isSubscriptionConfirmation(msg) {
+			return nil, nil
+		}
+		if t, ok := sub.Selection.Arguments["type"].(string); ok && t != "" && string(msg.Type) != t {
+			return nil, nil
+		}
+		values = graphqlMessageFields(msg)
+	case "directMessages":
+		if msg.Channel != "" || msg.Recipient != conn.UserID {
+			return nil, nil
+		}
+		values = graphqlMessageFields(msg)
+	case "presence":
+		if msg.Channel == "" || !MatchChannel(sub.Channel, msg.Channel) || msg.Type != MessageTypePresence {
+			return nil, nil
+		}
+		values = map[string]interface{}{
+			"__typename": "Presence",
+			"channel":    msg.Channel,
+			"users":      msg.Payload["users"],
+		}
+	default:
+		return nil, nil
+	}
+
+	data, err := project(values, sub.Selection.Fields)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{sub.Selection.Alias: data}, nil
+}
+
+// graphqlSession holds per-connection protocol state
+type graphqlSession struct {
+	server        *Server
+	conn          *Connection
+	ws            *websocket.Conn
+	legacy        bool
+	writeMu       sync.Mutex
+	mu            sync.RWMutex
+	initialized   bool
+	subscriptions map[string]*graphqlSubscription
+}
+
+// HandleGraphQL serves GraphQL subscriptions over the graphql-transport-ws
+// and legacy graphql-ws subprotocols. Supported root fields:
+//
+//	channelMessages(channel: String!, type: String): Message
+//	directMessages: Message
+//	presence(channel: String!): Presence
+func (s *Server) HandleGraphQL(w http.ResponseWriter, r *http.Request, connID, userID string) error {
+	upgrader := s.upgrader
+	upgrader.Subprotocols = []string{graphqlTransportWS, graphqlLegacyWS}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return fmt.Errorf("upgrade error: %w", err)
+	}
+
+	conn := newConnection(connID, userID, TransportGraphQL)
+	conn.Client = clientInfoFromRequest(r, connID)
+	if err := s.registerConnection(conn, ws); err != nil {
+		ws.Close()
+		return err
+	}
+
+	sess := &graphqlSession{
+		server:        s,
+		conn:          conn,
+		ws:            ws,
+		legacy:        ws.Subprotocol() == graphqlLegacyWS,
+		subscriptions: make(map[string]*graphqlSubscription),
+	}
+
+	go sess.readLoop()
+	go sess.writeLoop()
+
+	return nil
+}
+
+// send writes a protocol message
+func (sess *graphqlSession) send(msgType, id string, payload interface{}) error {
+	frame := map[string]interface{}{"type": msgType}
+	if id != "" {
+		frame["id"] = id
+	}
+	if payload != nil {
+		frame["payload"] = payload
+	}
+
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	sess.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return sess.ws.WriteJSON(frame)
+}
+
+// sendNext delivers a subscription result
+func (sess *graphqlSession) sendNext(id string, data interface{}) error {
+	msgType := "next"
+	if sess.legacy {
+		msgType = "data"
+	}
+	return sess.send(msgType, id, map[string]interface{}{"data": data})
+}
+
+// sendError reports a subscription error
+func (sess *graphqlSession) sendError(id string, err error) error {
+	errs := []map[string]string{{"message": err.Error()}}
+	if sess.legacy {
+		return sess.send("error", id, errs[0])
+	}
+	return sess.send("error", id, errs)
+}
+
+// readLoop handles client protocol messages
+func (sess *graphqlSession) readLoop() {
+	s := sess.server
+	defer func() {
+		s.removeConnection(sess.conn.ID)
+		sess.ws.Close()
+	}()
+
+	sess.ws.SetReadLimit(s.config.MaxMessageSize)
+	sess.ws.SetReadDeadline(time.Now().Add(s.config.PongWait))
+	sess.ws.SetPongHandler(func(string) error {
+		sess.ws.SetReadDeadline(time.Now().Add(s.config.PongWait))
+		return nil
+	})
+
+	for {
+		var frame struct {
+			Type    string          `json:"type"`
+			ID      string          `json:"id"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := sess.ws.ReadJSON(&frame); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("graphql error: %v", err)
+			}
+			return
+		}
+		sess.ws.SetReadDeadline(time.Now().Add(s.config.PongWait))
+		sess.conn.Touch()
+
+		switch frame.Type {
+		case "connection_init":
+			sess.mu.Lock()
+			sess.initialized = true
+			sess.mu.Unlock()
+			sess.send("connection_ack", "", nil)
+		case "ping":
+			sess.send("pong", "", nil)
+		case "subscribe", "start":
+			sess.mu.RLock()
+			initialized := sess.initialized
+			sess.mu.RUnlock()
+			if !initialized {
+				// 4401 Unauthorized per the graphql-transport-ws spec
+				sess.ws.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(CloseUnauthorized, "Unauthorized"), time.Now().Add(time.Second))
+				return
+			}
+			if err := sess.subscribe(frame.ID, frame.Payload); err != nil {
+				sess.sendError(frame.ID, err)
+			}
+		case "complete", "stop":
+			sess.unsubscribe(frame.ID)
+		case "connection_terminate":
+			return
+		}
+	}
+}
+
+// subscribe starts a subscription from a subscribe/start payload
+func (sess *graphqlSession) subscribe(id string, raw json.RawMessage) error {
+	if id == "" {
+		return fmt.Errorf("subscription id is required")
+	}
+
+	var payload struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return fmt.Errorf("invalid subscribe payload")
+	}
+
+	sel, err := parseGraphQLSubscription(payload.Query, payload.Variables)
+	if err != nil {
+		return err
+	}
+
+	sub := &graphqlSubscription{ID: id, Selection: sel}
+	switch sel.Field {
+	case "channelMessages", "presence":
+		channel, _ := sel.Arguments["channel"].(string)
+		if channel == "" {
+			return fmt.Errorf("%s requires a channel argument", sel.Field)
+		}
+		sub.Channel = channel
+		if err := sess.server.SubscribeToChannel(sess.conn.ID, channel); err != nil {
+			return err
+		}
+	case "directMessages":
+	default:
+		return fmt.Errorf("unknown subscription field: %s", sel.Field)
+	}
+
+	sess.mu.Lock()
+	sess.subscriptions[id] = sub
+	sess.mu.Unlock()
+
+	// Presence subscriptions start with the current member list
+	if sel.Field == "presence" {
+		snapshot := &Message{
+			Type:    MessageTypePresence,
+			Channel: sub.Channel,
+			Payload: map[string]interface{}{"users": sess.server.GetActiveUsersInChannel(sub.Channel)},
+		}
+		if data, err := sub.resolve(sess.conn, snapshot); err == nil && data != nil {
+			sess.sendNext(id, data)
+		}
+	}
+	return nil
+}
+
+// unsubscribe stops a subscription and leaves its channel if no other subscription uses it
+func (sess *graphqlSession) unsubscribe(id string) {
+	sess.mu.Lock()
+	sub, exists := sess.subscriptions[id]
+	delete(sess.subscriptions, id)
+	stillNeeded := false
+	if exists {
+		for _, other := range sess.subscriptions {
+			if other.Channel == sub.Channel {
+				stillNeeded = true
+				break
+			}
+		}
+	}
+	sess.mu.Unlock()
+
+	if exists && sub.Channel != "" && !stillNeeded {
+		sess.server.UnsubscribeFromChannel(sess.conn.ID, sub.Channel)
+	}
+	if exists && sess.legacy {
+		sess.send("complete", id, nil)
+	}
+}
+
+// writeLoop resolves queued messages against active subscriptions
+func (sess *graphqlSession) writeLoop() {
+	s := sess.server
+	ticker := time.NewTicker(s.config.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			sess.writeMu.Lock()
+			sess.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			err := sess.ws.WriteMessage(websocket.PingMessage, []byte{})
+			sess.writeMu.Unlock()
+			if err == nil && sess.legacy {
+				err = sess.send("ka", "", nil)
+			}
+			if err != nil {
+				return
+			}
+		case msg := <-sess.conn.outChan:
+			if msg == nil {
+				return
+			}
+			if s.dropExpired(msg) {
+				continue
+			}
+
+			sess.mu.RLock()
+			subs := make([]*graphqlSubscription, 0, len(sess.subscriptions))
+			for _, sub := range sess.subscriptions {
+				subs = append(subs, sub)
+			}
+			sess.mu.RUnlock()
+
+			sent := false
+			for _, sub := range subs {
+				data, err := sub.resolve(sess.conn, msg)
+				if err != nil {
+					sess.sendError(sub.ID, err)
+					continue
+				}
+				if data == nil {
+					continue
+				}
+				if err := sess.sendNext(sub.ID, data); err != nil {
+					s.deliveryFailed(sess.conn, msg, err)
+					return
+				}
+				sent = true
+			}
+			if sent {
+				s.delivered(sess.conn, msg)
+			}
+		}
+	}
+}
+
+// setupGraphQLRoutes registers the GraphQL subscriptions endpoint
+func setupGraphQLRoutes(mux *http.ServeMux, server *Server) {
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := connectUserID(w, r)
+		if !ok {
+			return
+		}
+		connID := "conn_" + uuid.New().String()[:12]
+
+		if err := server.HandleGraphQL(w, r, connID, userID); err != nil {
+			log.Printf("GraphQL connection error: %v", err)
+		}
+	})
+}
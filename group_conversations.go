@@ -0,0 +1,284 @@
+package wssocket
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// groupConversationPrefix starts the ID of every group conversation. Group
+// messages carry the ID as their channel.
+const groupConversationPrefix = "group:"
+
+// Group conversation sizes, counting the creator
+const (
+	MinGroupParticipants = 3
+	MaxGroupParticipants = 100
+)
+
+// isGroupConversation reports whether a channel is a group conversation
+func isGroupConversation(channel string) bool {
+	return strings.HasPrefix(channel, groupConversationPrefix)
+}
+
+// conversationParticipants returns a group's participants, or nil when the
+// group doesn't exist or conversations aren't supported
+func (s *Server) conversationParticipants(id string) []string {
+	cs, ok := conversationStore()
+	if !ok {
+		return nil
+	}
+	c, err := cs.GetConversation(id)
+	if err != nil || c == nil {
+		if err != nil {
+			log.Printf("Error loading conversation %s: %v", id, err)
+		}
+		return nil
+	}
+	return c.Participants
+}
+
+// isParticipant reports whether a user takes part in a group conversation
+func (s *Server) isParticipant(id, userID string) bool {
+	return slices.Contains(s.conversationParticipants(id), userID)
+}
+
+// authorizeConversation keeps group conversations to their participants
+func (s *Server) authorizeConversation(conn *Connection, msg *Message) error {
+	if conn.trusted || !isGroupConversation(msg.Channel) || s.isParticipant(msg.Channel, conn.UserID) {
+		return nil
+	}
+	return fmt.Errorf("%s is not in conversation %s: %w", conn.UserID, msg.Channel, ErrForbidden)
+}
+
+// CreateGroupConversation starts a group conversation between the creator
+// and the other participants and tells all of them
+func (s *Server) CreateGroupConversation(creator string, others []string) (*Conversation, error) {
+	cs, ok := conversationStore()
+	if !ok {
+		return nil, fmt.Errorf("conversations not supported by the message store")
+	}
+	participants := []string{creator}
+	for _, userID := range others {
+		if userID != "" && !slices.Contains(participants, userID) {
+			participants = append(participants, userID)
+		}
+	}
+	if len(participants) < MinGroupParticipants || len(participants) > MaxGroupParticipants {
+		return nil, fmt.Errorf("a group conversation needs %d to %d participants", MinGroupParticipants, MaxGroupParticipants)
+	}
+	sort.Strings(participants)
+
+	c := &Conversation{
+		ID:           groupConversationPrefix + uuid.New().String(),
+		Kind:         ConversationGroup,
+		Participants: participants,
+		CreatedBy:    creator,
+		CreatedAt:    s.now().Unix(),
+	}
+	if err := cs.CreateConversation(c); err != nil {
+		return nil, fmt.Errorf("create conversation: %w", err)
+	}
+
+	s.broadcastToChannel(c.ID, s.conversationNotice(MessageTypeConversationCreate, c.ID, creator, map[string]interface{}{
+		"conversation_id": c.ID,
+		"participants":    c.Participants,
+		"created_by":      creator,
+	}), &BroadcastOptions{})
+	return c, nil
+}
+
+// AddParticipant adds a user to a group conversation. Any participant may
+// add someone.
+func (s *Server) AddParticipant(id, userID, actor string) error {
+	cs, ok := conversationStore()
+	if !ok {
+		return fmt.Errorf("conversations not supported by the message store")
+	}
+	participants := s.conversationParticipants(id)
+	if !slices.Contains(participants, actor) {
+		return fmt.Errorf("%s is not in conversation %s: %w", actor, id, ErrForbidden)
+	}
+	if slices.Contains(participants, userID) {
+		return nil
+	}
+	if len(participants) >= MaxGroupParticipants {
+		return fmt.Errorf("conversation %s already has %d participants", id, MaxGroupParticipants)
+	}
+	if err := cs.AddConversationParticipant(id, userID); err != nil {
+		return fmt.Errorf("add %s to %s: %w", userID, id, err)
+	}
+
+	s.broadcastToChannel(id, s.conversationNotice(MessageTypeConversationAdd, id, actor, map[string]interface{}{
+		"conversation_id": id,
+		"user_id":         userID,
+		"by":              actor,
+	}), &BroadcastOptions{})
+	return nil
+}
+
+// RemoveParticipant takes a user out of a group conversation. Users may
+// leave; only the creator may remove someone else. The removed user is told
+// along with everyone who remains.
+func (s *Server) RemoveParticipant(id, userID, actor string) error {
+	cs, ok := conversationStore()
+	if !ok {
+		return fmt.Errorf("conversations not supported by the message store")
+	}
+	c, err := cs.GetConversation(id)
+	if err != nil {
+		return fmt.Errorf("load conversation %s: %w", id, err)
+	}
+	if c == nil || !slices.Contains(c.Participants, userID) {
+		return fmt.Errorf("%s is not in conversation %s", userID, id)
+	}
+	if actor != userID && actor != c.CreatedBy {
+		return fmt.Errorf("only the creator of %s may remove others: %w", id, ErrForbidden)
+	}
+	if err := cs.RemoveConversationParticipant(id, userID); err != nil {
+		return fmt.Errorf("remove %s from %s: %w", userID, id, err)
+	}
+
+	payload := map[string]interface{}{
+		"conversation_id": id,
+		"user_id":         userID,
+		"by":              actor,
+	}
+	s.sendToUser(userID, s.conversationNotice(MessageTypeConversationRemove, id, actor, payload))
+	s.broadcastToChannel(id, s.conversationNotice(MessageTypeConversationRemove, id, actor, payload), &BroadcastOptions{})
+	return nil
+}
+
+// conversationNotice builds a system message about a group conversation
+func (s *Server) conversationNotice(msgType MessageType, id, actor string, payload map[string]interface{}) *Message {
+	return &Message{
+		ID:        generateMessageID(),
+		Type:      msgType,
+		Sender:    "system",
+		Channel:   id,
+		Timestamp: s.now().Unix(),
+		Payload:   payload,
+	}
+}
+
+// ConversationCreateHandler starts a group conversation with the payload's
+// participants
+func ConversationCreateHandler(conn *Connection, msg *Message) error {
+	raw, _ := msg.Payload["participants"].([]interface{})
+	others := make([]string, 0, len(raw))
+	for _, v := range raw {
+		if userID, ok := v.(string); ok {
+			others = append(others, userID)
+		}
+	}
+	_, err := globalServer.CreateGroupConversation(conn.UserID, others)
+	return err
+}
+
+// ConversationMemberHandler adds or removes the payload's user_id in the
+// group conversation named by the message's channel
+func ConversationMemberHandler(conn *Connection, msg *Message) error {
+	userID, _ := msg.Payload["user_id"].(string)
+	if !isGroupConversation(msg.Channel) || userID == "" {
+		return fmt.Errorf("a group conversation channel and user_id are required")
+	}
+	var err error
+	if msg.Type == MessageTypeConversationAdd {
+		err = globalServer.AddParticipant(msg.Channel, userID, conn.UserID)
+	} else {
+		err = globalServer.RemoveParticipant(msg.Channel, userID, conn.UserID)
+	}
+	if errors.Is(err, ErrForbidden) {
+		return globalServer.rejectForbidden(conn, msg, err)
+	}
+	return err
+}
+
+// CreateConversation stores a group conversation
+func (s *InMemoryMessageStore) CreateConversation(c *Conversation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *c
+	stored.Participants = slices.Clone(c.Participants)
+	s.groups[c.ID] = &stored
+	return nil
+}
+
+// GetConversation returns a group conversation, or nil when there is none
+func (s *InMemoryMessageStore) GetConversation(id string) (*Conversation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, exists := s.groups[id]
+	if !exists {
+		return nil, nil
+	}
+	copied := *c
+	copied.Participants = slices.Clone(c.Participants)
+	return &copied, nil
+}
+
+// AddConversationParticipant adds a user to a group conversation
+func (s *InMemoryMessageStore) AddConversationParticipant(id, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, exists := s.groups[id]
+	if !exists {
+		return fmt.Errorf("conversation %s not found", id)
+	}
+	if !slices.Contains(c.Participants, userID) {
+		c.Participants = append(c.Participants, userID)
+		sort.Strings(c.Participants)
+	}
+	return nil
+}
+
+// RemoveConversationParticipant takes a user out of a group conversation
+func (s *InMemoryMessageStore) RemoveConversationParticipant(id, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, exists := s.groups[id]; exists {
+		c.Participants = slices.DeleteFunc(c.Participants, func(p string) bool { return p == userID })
+	}
+	return nil
+}
+
+// CreateConversation passes through to the underlying store
+func (e *EncryptedStore) CreateConversation(c *Conversation) error {
+	cs, ok := e.inner.(ConversationStore)
+	if !ok {
+		return fmt.Errorf("conversations not supported by the underlying store")
+	}
+	return cs.CreateConversation(c)
+}
+
+// GetConversation passes through to the underlying store
+func (e *EncryptedStore) GetConversation(id string) (*Conversation, error) {
+	cs, ok := e.inner.(ConversationStore)
+	if !ok {
+		return nil, fmt.Errorf("conversations not supported by the underlying store")
+	}
+	return cs.GetConversation(id)
+}
+
+// AddConversationParticipant passes through to the underlying store
+func (e *EncryptedStore) AddConversationParticipant(id, userID string) error {
+	cs, ok := e.inner.(ConversationStore)
+	if !ok {
+		return fmt.Errorf("conversations not supported by the underlying store")
+	}
+	return cs.AddConversationParticipant(id, userID)
+}
+
+// RemoveConversationParticipant passes through to the underlying store
+func (e *EncryptedStore) RemoveConversationParticipant(id, userID string) error {
+	cs, ok := e.inner.(ConversationStore)
+	if !ok {
+		return fmt.Errorf("conversations not supported by the underlying store")
+	}
+	return cs.RemoveConversationParticipant(id, userID)
+}
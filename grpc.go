@@ -0,0 +1,514 @@
+package wssocket
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// TransportGRPC identifies connections made through the gRPC gateway
+const TransportGRPC = "grpc"
+
+// grpcServicePath prefixes the gateway's method paths (see proto/gateway.proto)
+const grpcServicePath = "/wsgateway.v1.Gateway/"
+
+// grpcMaxMessageSize bounds a single request message
+const grpcMaxMessageSize = 4 << 20
+
+// gRPC status codes used by the gateway
+const (
+	grpcCodeOK                = 0
+	grpcCodeInvalidArgument   = 3
+	grpcCodeResourceExhausted = 8
+	grpcCodeUnimplemented     = 12
+	grpcCodeInternal          = 13
+	grpcCodeUnavailable       = 14
+	grpcCodeUnauthenticated   = 16
+)
+
+// grpcError carries a gRPC status code back to the client
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", e.code, e.message)
+}
+
+// grpcErrorf builds a grpcError with a formatted message
+func grpcErrorf(code int, format string, args ...interface{}) error {
+	return &grpcError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// grpcSendRequest is the SendMessage request
+type grpcSendRequest struct {
+	Message *Message `json:"message"`
+}
+
+// grpcSendResponse is the SendMessage response
+type grpcSendResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// grpcStreamRequest is the StreamMessages and Subscribe request
+type grpcStreamRequest struct {
+	UserID   string   `json:"user_id"`
+	Channels []string `json:"channels"`
+}
+
+// grpcCall is a single gRPC request/response exchange
+type grpcCall struct {
+	w    http.ResponseWriter
+	r    *http.Request
+	json bool // application/grpc+json instead of protobuf
+}
+
+// readRequest reads the single length-prefixed request message
+func (c *grpcCall) readRequest() ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r.Body, header[:]); err != nil {
+		return nil, grpcErrorf(grpcCodeInvalidArgument, "missing request message")
+	}
+	if header[0]&1 != 0 {
+		return nil, grpcErrorf(grpcCodeUnimplemented, "compressed messages are not supported")
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > grpcMaxMessageSize {
+		return nil, grpcErrorf(grpcCodeResourceExhausted, "request message exceeds %d bytes", grpcMaxMessageSize)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(c.r.Body, body); err != nil {
+		return nil, grpcErrorf(grpcCodeInvalidArgument, "truncated request message")
+	}
+	return body, nil
+}
+
+// send writes one length-prefixed response message and flushes it
+func (c *grpcCall) send(body []byte) error {
+	frame := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+	frame = append(frame, body...)
+
+	if _, err := c.w.Write(frame); err != nil {
+		return err
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// finish sets the grpc-status trailers for the call
+func (c *grpcCall) finish(err error) {
+	code, message := grpcCodeOK, ""
+	if err != nil {
+		var gerr *grpcError
+		if errors.As(err, &gerr) {
+			code, message = gerr.code, gerr.message
+		} else {
+			code, message = grpcCodeInternal, err.Error()
+		}
+	}
+
+	c.w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		c.w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcEncodeMessage(message))
+	}
+}
+
+// grpcEncodeMessage percent-encodes a status message as the gRPC spec requires
+func grpcEncodeMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// decodeSendRequest decodes a SendMessage request in the call's codec
+func (c *grpcCall) decodeSendRequest(body []byte) (*Message, error) {
+	if c.json {
+		var req grpcSendRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, grpcErrorf(grpcCodeInvalidArgument, "invalid request: %v", err)
+		}
+		if req.Message == nil {
+			return nil, grpcErrorf(grpcCodeInvalidArgument, "message is required")
+		}
+		return req.Message, nil
+	}
+
+	fields, err := protoDecode(body)
+	if err != nil {
+		return nil, grpcErrorf(grpcCodeInvalidArgument, "invalid request: %v", err)
+	}
+	for _, f := range fields {
+		if f.num == 1 && f.wire == protoWireBytes {
+			msg, err := protoDecodeMessage(f.bytes)
+			if err != nil {
+				return nil, grpcErrorf(grpcCodeInvalidArgument, "invalid message: %v", err)
+			}
+			return msg, nil
+		}
+	}
+	return nil, grpcErrorf(grpcCodeInvalidArgument, "message is required")
+}
+
+// decodeStreamRequest decodes a StreamMessages or Subscribe request
+func (c *grpcCall) decodeStreamRequest(body []byte) (*grpcStreamRequest, error) {
+	req := &grpcStreamRequest{}
+	if c.json {
+		if err := json.Unmarshal(body, req); err != nil {
+			return nil, grpcErrorf(grpcCodeInvalidArgument, "invalid request: %v", err)
+		}
+		return req, nil
+	}
+
+	fields, err := protoDecode(body)
+	if err != nil {
+		return nil, grpcErrorf(grpcCodeInvalidArgument, "invalid request: %v", err)
+	}
+	for _, f := range fields {
+		if f.wire != protoWireBytes {
+			continue
+		}
+		switch f.num {
+		case 1:
+			req.UserID = string(f.bytes)
+		case 2:
+			req.Channels = append(req.Channels, string(f.bytes))
+		}
+	}
+	return req, nil
+}
+
+// encodeSendResponse encodes a SendMessage response
+func (c *grpcCall) encodeSendResponse(resp grpcSendResponse) ([]byte, error) {
+	if c.json {
+		return json.Marshal(resp)
+	}
+	var b []byte
+	b = protoAppendString(b, 1, resp.ID)
+	b = protoAppendString(b, 2, resp.Status)
+	return b, nil
+}
+
+// encodeMessage encodes a streamed Message
+func (c *grpcCall) encodeMessage(msg *Message) ([]byte, error) {
+	if c.json {
+		return json.Marshal(msg)
+	}
+	return protoEncodeMessage(msg)
+}
+
+// Protobuf wire types used by the gateway messages
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+	protoWireFixed32 = 5
+)
+
+// protoField is one decoded protobuf field
+type protoField struct {
+	num    int
+	wire   int
+	varint uint64
+	bytes  []byte
+}
+
+// protoDecode splits a protobuf message into its fields
+func protoDecode(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid field key")
+		}
+		b = b[n:]
+
+		f := protoField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case protoWireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid varint in field %d", f.num)
+			}
+			f.varint = v
+			b = b[n:]
+		case protoWireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return nil, fmt.Errorf("invalid length in field %d", f.num)
+			}
+			f.bytes = b[n : n+int(size)]
+			b = b[n+int(size):]
+		case protoWireFixed64:
+			if len(b) < 8 {
+				return nil, fmt.Errorf("truncated field %d", f.num)
+			}
+			b = b[8:]
+		case protoWireFixed32:
+			if len(b) < 4 {
+				return nil, fmt.Errorf("truncated field %d", f.num)
+			}
+			b = b[4:]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", f.wire)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// protoAppendString appends a string field, omitting the proto3 default
+func protoAppendString(b []byte, num int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(num)<<3|protoWireBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// protoAppendInt64 appends an int64 field, omitting the proto3 default
+func protoAppendInt64(b []byte, num int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(num)<<3|protoWireVarint)
+	return binary.AppendUvarint(b, uint64(v))
+}
+
+// protoEncodeMessage encodes a Message as wsgateway.v1.Message
+func protoEncodeMessage(msg *Message) ([]byte, error) {
+	var b []byte
+	b = protoAppendString(b, 1, msg.ID)
+	b = protoAppendString(b, 2, string(msg.Type))
+	b = protoAppendString(b, 3, msg.Sender)
+	b = protoAppendString(b, 4, msg.Recipient)
+	b = protoAppendString(b, 5, msg.Channel)
+	if msg.Payload != nil {
+		payload, err := json.Marshal(msg.Payload)
+		if err != nil {
+			return nil, err
+		}
+		b = protoAppendString(b, 6, string(payload))
+	}
+	b = protoAppendInt64(b, 7, msg.Timestamp)
+	if msg.Metadata != nil {
+		metadata, err := json.Marshal(msg.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		b = protoAppendString(b, 8, string(metadata))
+	}
+	return b, nil
+}
+
+// protoDecodeMessage decodes a wsgateway.v1.Message
+func protoDecodeMessage(b []byte) (*Message, error) {
+	fields, err := protoDecode(b)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &Message{}
+	for _, f := range fields {
+		switch {
+		case f.num == 7 && f.wire == protoWireVarint:
+			msg.Timestamp = int64(f.varint)
+		case f.wire != protoWireBytes:
+			continue
+		case f.num == 1:
+			msg.ID = string(f.bytes)
+		case f.num == 2:
+			msg.Type = MessageType(f.bytes)
+		case f.num == 3:
+			msg.Sender = string(f.bytes)
+		case f.num == 4:
+			msg.Recipient = string(f.bytes)
+		case f.num == 5:
+			msg.Channel = string(f.bytes)
+		case f.num == 6:
+			if err := json.Unmarshal(f.bytes, &msg.Payload); err != nil {
+				return nil, fmt.Errorf("payload_json: %w", err)
+			}
+		case f.num == 8:
+			if err := json.Unmarshal(f.bytes, &msg.Metadata); err != nil {
+				return nil, fmt.Errorf("metadata_json: %w", err)
+			}
+		}
+	}
+	return msg, nil
+}
+
+// grpcMethod handles one gateway RPC
+type grpcMethod func(c *grpcCall) error
+
+// grpcHandler serves the gateway methods on a dedicated mux. Every call must
+// carry one of apiKeys as a bearer token in its authorization metadata.
+func (s *Server) grpcHandler(apiKeys []string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(grpcServicePath+"SendMessage", s.serveGRPC(apiKeys, s.grpcSendMessage))
+	mux.HandleFunc(grpcServicePath+"StreamMessages", s.serveGRPC(apiKeys, func(c *grpcCall) error {
+		return s.grpcStream(c, false)
+	}))
+	mux.HandleFunc(grpcServicePath+"Subscribe", s.serveGRPC(apiKeys, func(c *grpcCall) error {
+		return s.grpcStream(c, true)
+	}))
+	return mux
+}
+
+// serveGRPC adapts a gateway method to an HTTP/2 handler
+func (s *Server) serveGRPC(apiKeys []string, method grpcMethod) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		contentType := r.Header.Get("Content-Type")
+		call := &grpcCall{w: w, r: r}
+		switch contentType {
+		case "application/grpc", "application/grpc+proto":
+		case "application/grpc+json":
+			call.json = true
+		default:
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			call.finish(grpcErrorf(grpcCodeUnauthenticated, "invalid API key"))
+			return
+		}
+		call.finish(method(call))
+	}
+}
+
+// grpcSendMessage publishes a message through the same pipeline as frames
+// read from a WebSocket
+func (s *Server) grpcSendMessage(c *grpcCall) error {
+	body, err := c.readRequest()
+	if err != nil {
+		return err
+	}
+	msg, err := c.decodeSendRequest(body)
+	if err != nil {
+		return err
+	}
+
+	if msg.Sender == "" {
+		return grpcErrorf(grpcCodeInvalidArgument, "message sender is required")
+	}
+	if err := s.Publish(msg, TransportGRPC); err != nil {
+		return grpcErrorf(grpcCodeInvalidArgument, "%v", err)
+	}
+
+	resp, err := c.encodeSendResponse(grpcSendResponse{ID: msg.ID, Status: "queued"})
+	if err != nil {
+		return err
+	}
+	return c.send(resp)
+}
+
+// grpcStream registers a connection for the caller and streams what it
+// receives. With channelsOnly set, only messages published to the requested
+// channels are forwarded.
+func (s *Server) grpcStream(c *grpcCall, channelsOnly bool) error {
+	body, err := c.readRequest()
+	if err != nil {
+		return err
+	}
+	req, err := c.decodeStreamRequest(body)
+	if err != nil {
+		return err
+	}
+	if channelsOnly && len(req.Channels) == 0 {
+		return grpcErrorf(grpcCodeInvalidArgument, "at least one channel is required")
+	}
+
+	userID := req.UserID
+	if userID == "" {
+		userID = "svc_" + uuid.New().String()[:8]
+	}
+
+	conn := newConnection("conn_"+uuid.New().String()[:12], userID, TransportGRPC)
+	conn.Client = clientInfoFromRequest(c.r, conn.ID)
+	if err := s.registerConnection(conn, nil); err != nil {
+		return grpcErrorf(grpcCodeUnavailable, "%v", err)
+	}
+	defer s.removeConnection(conn.ID)
+
+	wanted := make(map[string]bool, len(req.Channels))
+	for _, channel := range req.Channels {
+		if err := s.SubscribeToChannel(conn.ID, channel); err != nil {
+			return grpcErrorf(grpcCodeInternal, "%v", err)
+		}
+		wanted[channel] = true
+	}
+
+	// Send headers now so clients see the stream open before the first message
+	c.w.WriteHeader(http.StatusOK)
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	for {
+		select {
+		case <-s.done:
+			return grpcErrorf(grpcCodeUnavailable, "server shutting down")
+		case <-c.r.Context().Done():
+			return nil
+		case <-conn.Context().Done():
+			return nil
+		case msg := <-conn.outChan:
+			if msg == nil {
+				return nil
+			}
+			if channelsOnly && !wanted[msg.Channel] || s.dropExpired(msg) {
+				continue
+			}
+
+			data, err := c.encodeMessage(msg)
+			if err != nil {
+				s.deliveryFailed(conn, msg, err)
+				continue
+			}
+			if err := c.send(data); err != nil {
+				s.deliveryFailed(conn, msg, err)
+				return nil
+			}
+			s.delivered(conn, msg)
+		}
+	}
+}
+
+// ListenAndServeGRPC serves the gRPC gateway over cleartext HTTP/2 (h2c) so
+// backend services can publish and consume without speaking WebSocket. Calls
+// must send one of apiKeys as "authorization: Bearer <key>" metadata; with no
+// keys every call is refused. It blocks like http.ListenAndServe.
+func (s *Server) ListenAndServeGRPC(addr string, apiKeys []string) error {
+	srv := &http.Server{Addr: addr, Handler: s.grpcHandler(apiKeys)}
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,55 @@
+package wssocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// grpcFrame wraps body in a gRPC length-prefixed message
+func grpcFrame(body string) []byte {
+	frame := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+	return append(frame, body...)
+}
+
+func TestGRPCRequiresAPIKey(t *testing.T) {
+	server := NewServer(ServerConfig{})
+	t.Cleanup(server.Stop)
+	handler := server.grpcHandler([]string{"svc-key"})
+
+	tests := []struct {
+		name   string
+		auth   string
+		method string
+		status string
+	}{
+		{"no key", "", "SendMessage", "16"},
+		{"wrong key", "Bearer nope", "SendMessage", "16"},
+		{"stream without key", "", "StreamMessages", "16"},
+		{"subscribe without key", "", "Subscribe", "16"},
+		{"valid key", "Bearer svc-key", "SendMessage", "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"message":{"type":"chat:group","sender":"svc","channel":"general","payload":{}}}`
+			req := httptest.NewRequest(http.MethodPost, grpcServicePath+tt.method, bytes.NewReader(grpcFrame(body)))
+			req.Header.Set("Content-Type", "application/grpc+json")
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			got := rec.Result().Trailer.Get("Grpc-Status")
+			if got == "" {
+				got = rec.Header().Get(http.TrailerPrefix + "Grpc-Status")
+			}
+			if got != tt.status {
+				t.Fatalf("grpc-status = %q, want %q", got, tt.status)
+			}
+		})
+	}
+}
@@ -1,26 +1,56 @@
-package main
+package wssocket
 
 import (
 	"fmt"
 	"log"
+	"time"
 )
 
 // Global server reference for handlers (set during init)
 var globalServer *Server
 
+// HandlerStores are the stores the built-in handlers keep their data in
+type HandlerStores struct {
+	Messages      MessageStore
+	Notifications NotificationStore
+	Channels      ChannelStore
+	Users         UserStore
+}
+
+// UseHandlerStores points the built-in handlers at a server and its stores,
+// and returns a function that puts back what they used before. Embedders
+// call it once before serving; tests use the restore function to run the
+// handlers against a throwaway server. The stores are shared by the whole
+// package, Server methods included, so a process runs one server at a time.
+func UseHandlerStores(server *Server, stores HandlerStores) (restore func()) {
+	prevServer, prevStore, prevNotifications := globalServer, globalStore, globalNotifications
+	prevChannels, prevUsers := globalChannelStore, globalUserStore
+	globalServer, globalStore, globalNotifications = server, stores.Messages, stores.Notifications
+	globalChannelStore, globalUserStore = stores.Channels, stores.Users
+	return func() {
+		globalServer, globalStore, globalNotifications = prevServer, prevStore, prevNotifications
+		globalChannelStore, globalUserStore = prevChannels, prevUsers
+	}
+}
+
 // ChatHandler handles chat messages
 func ChatHandler(conn *Connection, msg *Message) error {
 	if msg.Payload == nil {
 		return fmt.Errorf("payload is required for chat messages")
 	}
+	if err := ValidateAttachments(msg); err != nil {
+		return err
+	}
 
 	// Messages are persisted client-side with IndexedDB
 	// Server just routes real-time messages
+	mentions := globalServer.resolveMentions(msg)
 	if msg.Recipient != "" {
-		globalServer.sendToUser(msg.Recipient, msg)
+		globalServer.deliverDirect(conn, msg)
 	} else if msg.Channel != "" {
 		globalServer.broadcastToChannel(msg.Channel, msg, &BroadcastOptions{ExcludeConnID: true})
 	}
+	globalServer.notifyMentions(msg, mentions)
 
 	log.Printf("Chat message from %s to %s: %v", msg.Sender, msg.Recipient, msg.Payload)
 	return nil
@@ -32,6 +62,10 @@ func NotificationHandler(conn *Connection, msg *Message) error {
 		return fmt.Errorf("payload is required for notifications")
 	}
 
+	if err := RecordNotification(globalNotifications, globalServer.notificationRecipients(msg), msg); err != nil {
+		log.Printf("Error recording notification: %v", err)
+	}
+
 	log.Printf("Notification from %s: %v", msg.Sender, msg.Payload)
 	return nil
 }
@@ -77,7 +111,7 @@ func TypingHandler(conn *Connection, msg *Message) error {
 	return nil
 }
 
-// PresenceHandler handles presence updates and channel joins
+// PresenceHandler handles presence updates, channel joins and leaves
 func PresenceHandler(conn *Connection, msg *Message) error {
 	log.Printf("Presence update from %s in channel %s: %v", msg.Sender, msg.Channel, msg.Payload)
 
@@ -102,14 +136,44 @@ func PresenceHandler(conn *Connection, msg *Message) error {
 			Channel:   msg.Channel,
 			Timestamp: msg.Timestamp,
 			Payload: map[string]interface{}{
-				"user": msg.Sender,
+				"user":    msg.Sender,
+				"profile": globalServer.profileSnippet(conn.UserID),
+				"bot":     globalServer.IsBot(conn.UserID),
 			},
 		}
 		globalServer.broadcastToChannel(msg.Channel, joinMsg, &BroadcastOptions{})
 	}
 
-	// Broadcast presence update (list of active users)
+	// Handle leave action
+	if action, ok := msg.Payload["action"].(string); ok && action == "leave" && msg.Channel != "" {
+		if err := globalServer.UnsubscribeFromChannel(conn.ID, msg.Channel); err != nil {
+			return err
+		}
+
+		// Tell the rest of the channel
+		leaveMsg := &Message{
+			ID:        generateMessageID(),
+			Type:      MessageTypeUserLeft,
+			Sender:    "system",
+			Channel:   msg.Channel,
+			Timestamp: msg.Timestamp,
+			Payload: map[string]interface{}{
+				"user":    msg.Sender,
+				"profile": globalServer.profileSnippet(conn.UserID),
+				"bot":     globalServer.IsBot(conn.UserID),
+			},
+		}
+		globalServer.broadcastToChannel(msg.Channel, leaveMsg, &BroadcastOptions{})
+	}
+
+	// Broadcast presence update (list of active users, and which are bots)
 	users := globalServer.GetActiveUsersInChannel(msg.Channel)
+	bots := make([]string, 0)
+	for _, userID := range users {
+		if globalServer.IsBot(userID) {
+			bots = append(bots, userID)
+		}
+	}
 	presenceMsg := &Message{
 		ID:        generateMessageID(),
 		Type:      MessageTypePresence,
@@ -118,6 +182,7 @@ func PresenceHandler(conn *Connection, msg *Message) error {
 		Timestamp: msg.Timestamp,
 		Payload: map[string]interface{}{
 			"users": users,
+			"bots":  bots,
 		},
 	}
 	globalServer.broadcastToChannel(msg.Channel, presenceMsg, &BroadcastOptions{})
@@ -130,10 +195,15 @@ func GroupChatHandler(conn *Connection, msg *Message) error {
 	if msg.Channel == "" {
 		return fmt.Errorf("channel is required for group chat messages")
 	}
+	if err := ValidateAttachments(msg); err != nil {
+		return err
+	}
 
 	// Messages are persisted client-side with IndexedDB
 	// Server just routes real-time messages
+	mentions := globalServer.resolveMentions(msg)
 	globalServer.broadcastToChannel(msg.Channel, msg, &BroadcastOptions{ExcludeConnID: true})
+	globalServer.notifyMentions(msg, mentions)
 	log.Printf("Group chat message from %s in channel %s: %v", msg.Sender, msg.Channel, msg.Payload)
 	return nil
 }
@@ -143,16 +213,30 @@ func PrivateChatHandler(conn *Connection, msg *Message) error {
 	if msg.Recipient == "" {
 		return fmt.Errorf("recipient is required for private chat messages")
 	}
+	if err := ValidateAttachments(msg); err != nil {
+		return err
+	}
 
 	// Messages are persisted client-side with IndexedDB
 	// Server just routes real-time messages
-	globalServer.sendToUser(msg.Recipient, msg)
+	mentions := globalServer.resolveMentions(msg)
+	globalServer.deliverDirect(conn, msg)
+	globalServer.notifyMentions(msg, mentions)
 	log.Printf("Private chat message from %s to %s: %v", msg.Sender, msg.Recipient, msg.Payload)
 	return nil
 }
 
 // AlertHandler handles alert notifications
 func AlertHandler(conn *Connection, msg *Message) error {
+	if globalAlerts != nil {
+		_, err := globalAlerts.Raise(msg)
+		return err
+	}
+
+	if err := RecordNotification(globalNotifications, globalServer.notificationRecipients(msg), msg); err != nil {
+		log.Printf("Error recording alert: %v", err)
+	}
+
 	if severity, ok := msg.Payload["severity"].(string); ok {
 		log.Printf("Alert with severity %s: %v", severity, msg.Payload)
 	}
@@ -202,10 +286,13 @@ func DeleteMessageHandler(conn *Connection, msg *Message) error {
 	}
 
 	log.Printf("Delete request from %s for message %s", msg.Sender, messageID)
+	if err := globalServer.authorizeDelete(conn, msg, messageID); err != nil {
+		return err
+	}
 
-	// Delete from database first
-	if globalDB != nil {
-		if err := globalDB.DeleteMessage(messageID); err != nil {
+	// Delete from the message store first
+	if globalStore != nil {
+		if err := globalStore.DeleteMessage(messageID); err != nil {
 			log.Printf("Error deleting message from database: %v", err)
 			// Continue to broadcast even if DB delete fails
 		} else {
@@ -239,3 +326,140 @@ func DeleteMessageHandler(conn *Connection, msg *Message) error {
 	log.Printf("Delete notification sent for message %s", messageID)
 	return nil
 }
+
+// ChannelReplayHandler answers a replay request for a range of channel
+// sequence numbers with the buffered messages
+func ChannelReplayHandler(conn *Connection, msg *Message) error {
+	if msg.Channel == "" {
+		return fmt.Errorf("channel is required for replay")
+	}
+	if !globalServer.follows(conn, msg.Channel) {
+		return fmt.Errorf("not subscribed to channel %s", msg.Channel)
+	}
+
+	from, _ := msg.Payload["from"].(float64)
+	to, _ := msg.Payload["to"].(float64)
+	replay := globalServer.ReplayChannel(msg.Channel, uint64(from), uint64(to))
+
+	return globalServer.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeChannelReplay,
+		Sender:    "system",
+		Channel:   msg.Channel,
+		Timestamp: time.Now().Unix(),
+		Payload: map[string]interface{}{
+			"from":      replay.From,
+			"to":        replay.To,
+			"latest":    replay.Latest,
+			"oldest":    replay.Oldest,
+			"truncated": replay.Truncated,
+			"messages":  replay.Messages,
+		},
+	})
+}
+
+// HistoryRequestHandler answers a history:request for a channel, or for the
+// sender's DMs with msg.Recipient, with one page of history. Pass the
+// response's next_cursor back as payload.cursor to load older messages.
+func HistoryRequestHandler(conn *Connection, msg *Message) error {
+	if globalStore == nil {
+		return fmt.Errorf("message history is not available")
+	}
+
+	page := Page{Limit: DefaultPageLimit}
+	if limit, ok := msg.Payload["limit"].(float64); ok {
+		page.Limit = int(limit)
+	}
+	if token, ok := msg.Payload["cursor"].(string); ok && token != "" {
+		cursor, err := ParseCursor(token)
+		if err != nil {
+			return err
+		}
+		page.Before = cursor
+	}
+	page = page.normalize()
+
+	var messages []*Message
+	var err error
+	switch {
+	case msg.Channel != "":
+		if !globalServer.follows(conn, msg.Channel) {
+			return fmt.Errorf("not subscribed to channel %s", msg.Channel)
+		}
+		messages, err = globalStore.GetChannelMessages(msg.Channel, page)
+	case msg.Recipient != "":
+		messages, err = globalStore.GetDMMessages(conn.UserID, msg.Recipient, page)
+	default:
+		return fmt.Errorf("channel or recipient is required for history")
+	}
+	if err != nil {
+		return fmt.Errorf("load history: %w", err)
+	}
+
+	payload := historyPage(messages, page)
+	payload["request_id"] = msg.ID
+	if msg.Recipient != "" {
+		payload["with"] = msg.Recipient
+	} else {
+		payload["last_read_message_id"] = lastReadMessageID(conn.UserID, msg.Channel)
+	}
+	return globalServer.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeHistoryResponse,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Channel:   msg.Channel,
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	})
+}
+
+// SearchHandler answers a search message with ranked full-text results. A
+// channel search requires following the channel; otherwise only the sender's
+// own messages are searched.
+func SearchHandler(conn *Connection, msg *Message) error {
+	if globalStore == nil {
+		return fmt.Errorf("message search is not available")
+	}
+
+	q := SearchQuery{Channel: msg.Channel}
+	q.Text, _ = msg.Payload["q"].(string)
+	if limit, ok := msg.Payload["limit"].(float64); ok {
+		q.Limit = int(limit)
+	}
+	if since, ok := msg.Payload["since"].(float64); ok {
+		q.Since = int64(since)
+	}
+	if until, ok := msg.Payload["until"].(float64); ok {
+		q.Until = int64(until)
+	}
+	if q.Channel != "" {
+		if !globalServer.follows(conn, q.Channel) {
+			return fmt.Errorf("not subscribed to channel %s", q.Channel)
+		}
+	} else {
+		q.User = conn.UserID
+	}
+	if err := q.Validate(); err != nil {
+		return err
+	}
+
+	results, err := globalStore.SearchMessages(q)
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+
+	return globalServer.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeSearchResults,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Channel:   msg.Channel,
+		Timestamp: time.Now().Unix(),
+		Payload: map[string]interface{}{
+			"request_id": msg.ID,
+			"results":    results,
+			"count":      len(results),
+		},
+	})
+}
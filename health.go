@@ -0,0 +1,188 @@
+package wssocket
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// HealthCheck reports whether a dependency such as a database or broker is usable
+type HealthCheck func(ctx context.Context) error
+
+// HealthConfig sets the limits the health probes enforce
+type HealthConfig struct {
+	MaxQueueDepth int           // Readiness fails above this many queued inbound messages; 0 uses 80% of capacity
+	MaxGoroutines int           // Liveness fails above this many goroutines; 0 only reports the count
+	CheckTimeout  time.Duration // How long each dependency check may take; 0 uses the default
+}
+
+// DefaultHealthCheckTimeout bounds each dependency check
+const DefaultHealthCheckTimeout = 2 * time.Second
+
+// Probe statuses
+const (
+	ProbeOK   = "ok"
+	ProbeFail = "fail"
+)
+
+// CheckResult is the outcome of one probe check
+type CheckResult struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	Value      *int    `json:"value,omitempty"` // Observed value for threshold checks
+	Limit      int     `json:"limit,omitempty"`
+	DurationMs float64 `json:"duration_ms,omitempty"` // How long a dependency check took
+}
+
+// ProbeResult is a liveness or readiness report
+type ProbeResult struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// RegisterReadinessCheck adds a dependency that must be healthy for the
+// server to receive traffic. A nil check removes it.
+func (s *Server) RegisterReadinessCheck(name string, check HealthCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if check == nil {
+		delete(s.readinessChecks, name)
+		return
+	}
+	s.readinessChecks[name] = check
+}
+
+// Liveness reports whether the process is healthy enough to keep running.
+// It checks nothing external, so a dependency outage doesn't restart it.
+func (s *Server) Liveness() ProbeResult {
+	result := ProbeResult{Status: ProbeOK, Checks: make(map[string]CheckResult)}
+	result.add("goroutines", thresholdCheck(runtime.NumGoroutine(), s.config.Health.MaxGoroutines))
+	return result
+}
+
+// Readiness reports whether the server should receive traffic: it isn't
+// stopping, its inbound queue isn't backed up, and every registered
+// dependency answers
+func (s *Server) Readiness(ctx context.Context) ProbeResult {
+	result := ProbeResult{Status: ProbeOK, Checks: make(map[string]CheckResult)}
+
+	server := CheckResult{Status: ProbeOK}
+	select {
+	case <-s.done:
+		server = CheckResult{Status: ProbeFail, Error: "server is stopping"}
+	default:
+		if s.Draining() {
+			server = CheckResult{Status: ProbeFail, Error: ErrDraining.Error()}
+		}
+	}
+	result.add("server", server)
+	result.add("queue_depth", thresholdCheck(s.queueDepth(), s.maxQueueDepth()))
+
+	s.mu.RLock()
+	checks := make(map[string]HealthCheck, len(s.readinessChecks))
+	for name, check := range s.readinessChecks {
+		checks[name] = check
+	}
+	s.mu.RUnlock()
+
+	timeout := s.config.Health.CheckTimeout
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+
+	// Run dependency checks in parallel so one slow dependency doesn't hide the rest
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check HealthCheck) {
+			defer wg.Done()
+			checked := runCheck(ctx, check, timeout)
+			mu.Lock()
+			result.add(name, checked)
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return result
+}
+
+// maxQueueDepth returns the queue depth above which the server isn't ready
+func (s *Server) maxQueueDepth() int {
+	if s.config.Health.MaxQueueDepth > 0 {
+		return s.config.Health.MaxQueueDepth
+	}
+	capacity := 0
+	for _, queue := range s.messageQueues {
+		capacity += queue.capacity()
+	}
+	return capacity * 8 / 10
+}
+
+// add records a check, failing the probe if the check failed
+func (p *ProbeResult) add(name string, check CheckResult) {
+	p.Checks[name] = check
+	if check.Status != ProbeOK {
+		p.Status = ProbeFail
+	}
+}
+
+// thresholdCheck fails once value exceeds limit; a limit of 0 never fails
+func thresholdCheck(value, limit int) CheckResult {
+	check := CheckResult{Status: ProbeOK, Value: &value, Limit: limit}
+	if limit > 0 && value > limit {
+		check.Status = ProbeFail
+		check.Error = fmt.Sprintf("%d exceeds limit of %d", value, limit)
+	}
+	return check
+}
+
+// runCheck runs a dependency check with a timeout
+func runCheck(ctx context.Context, check HealthCheck, timeout time.Duration) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	result := CheckResult{Status: ProbeOK, DurationMs: float64(time.Since(start)) / float64(time.Millisecond)}
+	if err != nil {
+		result.Status = ProbeFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// writeProbe writes a probe result with 200 when it passed and 503 when it failed
+func writeProbe(w http.ResponseWriter, result ProbeResult) {
+	status := http.StatusOK
+	if result.Status != ProbeOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, result)
+}
+
+// setupHealthRoutes registers the Kubernetes liveness and readiness probes
+func setupHealthRoutes(mux *http.ServeMux, server *Server) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, server.Liveness())
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, server.Readiness(r.Context()))
+	})
+}
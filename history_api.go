@@ -0,0 +1,703 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// setupHistoryRoutes registers the REST message history endpoints.
+// Every route goes through globalStore so any MessageStore implementation
+// works. Stats across all channels need one of apiKeys.
+func setupHistoryRoutes(mux *http.ServeMux, apiKeys []string) {
+	// Save a single message. Only its sender may save it, to a channel they
+	// may post in.
+	mux.HandleFunc("/api/db/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var in HistoryMessage
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "Invalid message format", http.StatusBadRequest)
+			return
+		}
+
+		if globalStore == nil {
+			http.Error(w, "Database not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		msg := in.ToMessage()
+		if err := validateStoredMessage(msg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		userID, ok := requestUser(w, r, msg.Sender)
+		if !ok {
+			return
+		}
+		if status, err := authorizeHistoryWrite(userID, msg); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		if err := globalStore.SaveMessage(msg); err != nil {
+			log.Printf("Error saving message: %v", err)
+			http.Error(w, "Failed to save message", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "saved",
+			"id":     msg.ID,
+		})
+	})
+
+	// Save multiple messages for the ?user_id= they were sent by. Invalid
+	// entries, and ones the user may not save, are reported individually and
+	// the remaining entries are inserted in one transaction.
+	mux.HandleFunc("/api/db/messages/batch", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var in []json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "Invalid messages format", http.StatusBadRequest)
+			return
+		}
+
+		if len(in) > MaxBatchSize {
+			http.Error(w, fmt.Sprintf("batch exceeds %d messages", MaxBatchSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if globalStore == nil {
+			http.Error(w, "Database not available", http.StatusServiceUnavailable)
+			return
+		}
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+
+		results := make([]BatchItemResult, len(in))
+		valid := make([]*Message, 0, len(in))
+		validIdx := make([]int, 0, len(in))
+		for i, raw := range in {
+			results[i].Index = i
+
+			var item HistoryMessage
+			if err := json.Unmarshal(raw, &item); err != nil {
+				results[i].Status = SaveStatusInvalid
+				results[i].Error = "invalid message format"
+				continue
+			}
+			results[i].ID = item.ID
+
+			msg := item.ToMessage()
+			if err := validateStoredMessage(msg); err != nil {
+				results[i].Status = SaveStatusInvalid
+				results[i].Error = err.Error()
+				continue
+			}
+			if _, err := authorizeHistoryWrite(userID, msg); err != nil {
+				results[i].Status = SaveStatusInvalid
+				results[i].Error = err.Error()
+				continue
+			}
+
+			valid = append(valid, msg)
+			validIdx = append(validIdx, i)
+		}
+
+		statuses, err := globalStore.SaveMessages(valid)
+		if err != nil {
+			log.Printf("Error saving messages: %v", err)
+			http.Error(w, "Failed to save messages", http.StatusInternalServerError)
+			return
+		}
+
+		for j, status := range statuses {
+			results[validIdx[j]].Status = status
+		}
+
+		summary := map[SaveStatus]int{}
+		for _, res := range results {
+			summary[res.Status]++
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":     "processed",
+			"count":      summary[SaveStatusSaved],
+			"saved":      summary[SaveStatusSaved],
+			"duplicates": summary[SaveStatusDuplicate],
+			"invalid":    summary[SaveStatusInvalid],
+			"queued":     summary[SaveStatusQueued],
+			"results":    results,
+		})
+	})
+
+	// Get channel messages. Private and secret channels are only served to
+	// members, who must authenticate as ?user_id=, as for history:request.
+	mux.HandleFunc("/api/db/messages/channel", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		channel := r.URL.Query().Get("channel")
+		if channel == "" {
+			http.Error(w, "channel parameter required", http.StatusBadRequest)
+			return
+		}
+		if isGroupConversation(channel) {
+			http.Error(w, "group conversation history is at /api/conversations/messages", http.StatusForbidden)
+			return
+		}
+		if !authorizeChannelRequest(w, r, channel) {
+			return
+		}
+
+		if globalStore == nil {
+			http.Error(w, "Database not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		page, err := parseCursorPage(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		messages, err := globalStore.GetChannelMessages(channel, page)
+		if err != nil {
+			log.Printf("Error loading channel messages: %v", err)
+			http.Error(w, "Failed to load messages", http.StatusInternalServerError)
+			return
+		}
+
+		writeHistoryPage(w, messages, page)
+	})
+
+	// Delete channel messages
+	mux.HandleFunc("/api/db/messages/channel/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		channel := r.URL.Path[len("/api/db/messages/channel/"):]
+		if channel == "" {
+			http.Error(w, "channel parameter required", http.StatusBadRequest)
+			return
+		}
+
+		if globalStore == nil {
+			http.Error(w, "Database not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		if err := globalStore.ClearChannel(channel); err != nil {
+			log.Printf("Error clearing channel: %v", err)
+			http.Error(w, "Failed to clear channel", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "cleared"})
+	})
+
+	// Get DM messages for one of the two users, who authenticates as
+	// ?user_id=. Deprecated: use /api/messages with sender and recipient.
+	mux.HandleFunc("/api/db/messages/dm", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		markDeprecated(w, "/api/messages")
+
+		user1 := r.URL.Query().Get("user1")
+		user2 := r.URL.Query().Get("user2")
+		if user1 == "" || user2 == "" {
+			http.Error(w, "user1 and user2 parameters required", http.StatusBadRequest)
+			return
+		}
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+		if userID != user1 && userID != user2 {
+			http.Error(w, "only user1 or user2 may read their messages", http.StatusForbidden)
+			return
+		}
+
+		if globalStore == nil {
+			http.Error(w, "Database not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		page, err := parseCursorPage(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		messages, err := globalStore.GetDMMessages(user1, user2, page)
+		if err != nil {
+			log.Printf("Error loading DM messages: %v", err)
+			http.Error(w, "Failed to load messages", http.StatusInternalServerError)
+			return
+		}
+
+		writeHistoryPage(w, messages, page)
+	})
+
+	// Get the messages a user sent or received, for that user only; a
+	// registered user must send their token. Deprecated: use /api/messages
+	// with sender or recipient.
+	mux.HandleFunc("/api/db/messages/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		markDeprecated(w, "/api/messages")
+
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+
+		if globalStore == nil {
+			http.Error(w, "Database not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		page, err := parseCursorPage(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		messages, err := globalStore.GetUserMessages(userID, page)
+		if err != nil {
+			log.Printf("Error loading user messages: %v", err)
+			http.Error(w, "Failed to load messages", http.StatusInternalServerError)
+			return
+		}
+
+		// Leave out channels the user can no longer read; paging still
+		// follows the unfiltered page
+		readable := make([]*Message, 0, len(messages))
+		for _, msg := range messages {
+			if msg.Channel == "" {
+				readable = append(readable, msg)
+			} else if _, err := authorizeHistoryRead(userID, msg.Channel); err == nil {
+				readable = append(readable, msg)
+			}
+		}
+		reply := historyPage(messages, page)
+		reply["messages"] = NewHistoryMessages(readable)
+		reply["count"] = len(readable)
+		writeJSON(w, http.StatusOK, reply)
+	})
+
+	// Get message count and storage usage for one channel, readable as for
+	// its history, or for every channel when all=true with an admin key
+	mux.HandleFunc("/api/db/messages/count", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		channel := r.URL.Query().Get("channel")
+		all := r.URL.Query().Get("all") == "true"
+		if channel == "" && !all {
+			http.Error(w, "channel parameter required", http.StatusBadRequest)
+			return
+		}
+		if all && !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if !all && !authorizeChannelRequest(w, r, channel) {
+			return
+		}
+
+		if globalStore == nil {
+			http.Error(w, "Database not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		if all {
+			stats, err := globalStore.GetChannelStats("")
+			if err != nil {
+				log.Printf("Error getting channel stats: %v", err)
+				http.Error(w, "Failed to get count", http.StatusInternalServerError)
+				return
+			}
+
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"channels": stats,
+				"count":    len(stats),
+			})
+			return
+		}
+
+		stats, err := globalStore.GetChannelStats(channel)
+		if err != nil {
+			log.Printf("Error getting message count: %v", err)
+			http.Error(w, "Failed to get count", http.StatusInternalServerError)
+			return
+		}
+
+		result := ChannelStats{Channel: channel}
+		if len(stats) > 0 {
+			result = stats[0]
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"channel":          result.Channel,
+			"count":            result.MessageCount,
+			"payload_bytes":    result.PayloadBytes,
+			"oldest_timestamp": result.OldestTimestamp,
+			"newest_timestamp": result.NewestTimestamp,
+		})
+	})
+
+	// Delete message
+	mux.HandleFunc("/api/db/messages/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		messageID := r.URL.Path[len("/api/db/messages/"):]
+		if messageID == "" {
+			http.Error(w, "message ID required", http.StatusBadRequest)
+			return
+		}
+
+		if globalStore == nil {
+			http.Error(w, "Database not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		if err := globalStore.DeleteMessage(messageID); err != nil {
+			log.Printf("Error deleting message: %v", err)
+			http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "deleted"})
+	})
+}
+
+// markDeprecated flags a response from an endpoint that successor replaces
+func markDeprecated(w http.ResponseWriter, successor string) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+}
+
+// setupSearchRoutes registers the message search endpoints. The combined
+// search spans every user's messages, so it needs one of apiKeys. Full-text
+// search is open to users too, over the channels they may read and their
+// own messages.
+func setupSearchRoutes(mux *http.ServeMux, apiKeys []string) {
+	// Search messages by sender, channel, type, date range and metadata.
+	// Metadata filters are passed as meta.<key>=<value>.
+	mux.HandleFunc("/api/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		if globalStore == nil {
+			http.Error(w, "Database not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		q, err := parseMessageQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		messages, err := globalStore.FindMessages(q)
+		if err != nil {
+			log.Printf("Error searching messages: %v", err)
+			http.Error(w, "Failed to search messages", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"messages": NewHistoryMessages(messages),
+			"count":    len(messages),
+			"limit":    q.Page.Limit,
+			"offset":   q.Page.Offset,
+			"has_more": len(messages) == q.Page.Limit,
+		})
+	})
+
+	// Ranked full-text search over message content:
+	// ?q=...&channel=&user=&since=&until=&limit=
+	// Without an admin key the caller authenticates as ?user_id=, as for
+	// the search message: a channel search needs read access to the
+	// channel, and any other search covers only the caller's own messages.
+	mux.HandleFunc("/api/messages/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if globalStore == nil {
+			http.Error(w, "Database not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		q, err := parseSearchQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			if r.URL.Query().Get("user_id") == "" && userTokenFromRequest(r) == "" {
+				http.Error(w, "admin API key or user_id required", http.StatusUnauthorized)
+				return
+			}
+			userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+			if !ok {
+				return
+			}
+			switch {
+			case q.Channel != "":
+				if status, err := authorizeHistoryRead(userID, q.Channel); err != nil {
+					http.Error(w, err.Error(), status)
+					return
+				}
+			case q.User != "" && q.User != userID:
+				http.Error(w, "only your own messages can be searched without a channel", http.StatusForbidden)
+				return
+			default:
+				q.User = userID
+			}
+		}
+
+		results, err := globalStore.SearchMessages(q)
+		if errors.Is(err, ErrSearchUnsupported) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			log.Printf("Error searching message content: %v", err)
+			http.Error(w, "Failed to search messages", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"results": results,
+			"count":   len(results),
+		})
+	})
+}
+
+// parseSearchQuery builds a SearchQuery from URL query parameters
+func parseSearchQuery(r *http.Request) (SearchQuery, error) {
+	values := r.URL.Query()
+	q := SearchQuery{
+		Text:    values.Get("q"),
+		Channel: values.Get("channel"),
+		User:    values.Get("user"),
+	}
+	if l := values.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil {
+			q.Limit = parsed
+		}
+	}
+	for _, field := range []struct {
+		name string
+		dst  *int64
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		if v := values.Get(field.name); v != "" {
+			parsed, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return q, fmt.Errorf("%s must be a unix timestamp", field.name)
+			}
+			*field.dst = parsed
+		}
+	}
+	return q, q.Validate()
+}
+
+// parseMessageQuery builds a MessageQuery from URL query parameters
+func parseMessageQuery(r *http.Request) (MessageQuery, error) {
+	values := r.URL.Query()
+	q := MessageQuery{
+		Sender:    values.Get("sender"),
+		Recipient: values.Get("recipient"),
+		Channel:   values.Get("channel"),
+		Type:      values.Get("type"),
+		Metadata:  make(map[string]string),
+		Page:      parsePage(r),
+	}
+
+	for _, field := range []struct {
+		name string
+		dst  *int64
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		if v := values.Get(field.name); v != "" {
+			parsed, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return q, fmt.Errorf("%s must be a unix timestamp", field.name)
+			}
+			*field.dst = parsed
+		}
+	}
+
+	// sort=timestamp ascending, sort=-timestamp descending
+	if sortBy := values.Get("sort"); sortBy != "" {
+		if strings.HasPrefix(sortBy, "-") {
+			q.SortDesc = true
+			sortBy = sortBy[1:]
+		}
+		q.SortBy = sortBy
+	}
+
+	for key, vals := range values {
+		if strings.HasPrefix(key, "meta.") && len(vals) > 0 {
+			q.Metadata[strings.TrimPrefix(key, "meta.")] = vals[0]
+		}
+	}
+
+	return q, q.Validate()
+}
+
+// MaxBatchSize caps the number of messages accepted by the batch endpoint
+const MaxBatchSize = 1000
+
+// authorizeHistoryWrite checks that a user may store a message: it must be
+// their own, in a channel or group conversation they belong to unless the
+// channel is public. The status is the one to answer with when they may not.
+func authorizeHistoryWrite(userID string, msg *Message) (int, error) {
+	if msg.Sender != userID {
+		return http.StatusForbidden, fmt.Errorf("only the sender may save a message")
+	}
+	return authorizeHistoryRead(userID, msg.Channel)
+}
+
+// authorizeHistoryRead checks that a user may read a channel's messages: a
+// group conversation they take part in, or a channel they belong to unless
+// it is public. The status is the one to answer with when they may not.
+func authorizeHistoryRead(userID, channel string) (int, error) {
+	if isGroupConversation(channel) {
+		if !globalServer.isParticipant(channel, userID) {
+			return http.StatusForbidden, fmt.Errorf("not a participant of this conversation")
+		}
+		return 0, nil
+	}
+	if visibility := globalServer.ChannelSettings(channel).Visibility; visibility != VisibilityPublic && !globalServer.isChannelMember(channel, userID) {
+		if visibility == VisibilitySecret {
+			return http.StatusNotFound, fmt.Errorf("channel not found")
+		}
+		return http.StatusForbidden, fmt.Errorf("channel is members only")
+	}
+	return 0, nil
+}
+
+// authorizeChannelRequest lets anyone read a public channel; any other
+// channel or group conversation needs the ?user_id= caller to be allowed to
+// read it. On failure it has already replied.
+func authorizeChannelRequest(w http.ResponseWriter, r *http.Request, channel string) bool {
+	if !isGroupConversation(channel) && globalServer.ChannelSettings(channel).Visibility == VisibilityPublic {
+		return true
+	}
+	userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+	if !ok {
+		return false
+	}
+	if status, err := authorizeHistoryRead(userID, channel); err != nil {
+		http.Error(w, err.Error(), status)
+		return false
+	}
+	return true
+}
+
+// BatchItemResult reports the outcome for one entry of a batch save
+type BatchItemResult struct {
+	Index  int        `json:"index"`
+	ID     string     `json:"id,omitempty"`
+	Status SaveStatus `json:"status"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// parsePage reads limit and offset query parameters
+func parsePage(r *http.Request) Page {
+	page := Page{Limit: DefaultPageLimit}
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil {
+			page.Limit = parsed
+		}
+	}
+	if o := r.URL.Query().Get("offset"); o != "" {
+		if parsed, err := strconv.Atoi(o); err == nil {
+			page.Offset = parsed
+		}
+	}
+	return page.normalize()
+}
+
+// parseCursorPage reads limit and cursor query parameters for history pages
+func parseCursorPage(r *http.Request) (Page, error) {
+	page := Page{Limit: DefaultPageLimit}
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil {
+			page.Limit = parsed
+		}
+	}
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		cursor, err := ParseCursor(c)
+		if err != nil {
+			return page, err
+		}
+		page.Before = cursor
+	}
+	return page.normalize(), nil
+}
+
+// historyPage builds the shared response shape for a page of history. Pass
+// next_cursor back as cursor to load the next, older page.
+func historyPage(messages []*Message, page Page) map[string]interface{} {
+	hasMore := len(messages) == page.Limit
+	var nextCursor string
+	if hasMore && len(messages) > 0 {
+		nextCursor = CursorFor(messages[0]).String()
+	}
+	return map[string]interface{}{
+		"messages":    NewHistoryMessages(messages),
+		"count":       len(messages),
+		"limit":       page.Limit,
+		"has_more":    hasMore,
+		"next_cursor": nextCursor,
+	}
+}
+
+// writeHistoryPage writes a page of messages in the shared response shape
+func writeHistoryPage(w http.ResponseWriter, messages []*Message, page Page) {
+	writeJSON(w, http.StatusOK, historyPage(messages, page))
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
@@ -0,0 +1,516 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useHistoryStore serves the history and search routes from store for one
+// test, with in-memory channel and user stores behind them
+func useHistoryStore(t *testing.T, store MessageStore) (*InMemoryChannelStore, *http.ServeMux) {
+	t.Helper()
+	mux := http.NewServeMux()
+	setupHistoryRoutes(mux, []string{"admin-key"})
+	setupSearchRoutes(mux, []string{"admin-key"})
+	server := NewServer(ServerConfig{})
+	channels := NewInMemoryChannelStore()
+	restore := UseHandlerStores(server, HandlerStores{
+		Messages: store,
+		Channels: channels,
+		Users:    NewInMemoryUserStore(),
+	})
+	t.Cleanup(func() {
+		server.Stop()
+		restore()
+	})
+	return channels, mux
+}
+
+// serveHistory sends a request through mux and decodes a JSON reply
+func serveHistory(t *testing.T, mux *http.ServeMux, method, target, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	var reply map[string]interface{}
+	if strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+			t.Fatalf("%s %s: decode reply: %v", method, target, err)
+		}
+	}
+	return rec.Code, reply
+}
+
+// replyIDs returns the IDs of the messages in a history reply, in order
+func replyIDs(reply map[string]interface{}) []string {
+	ids := make([]string, 0)
+	messages, _ := reply["messages"].([]interface{})
+	for _, m := range messages {
+		ids = append(ids, m.(map[string]interface{})["id"].(string))
+	}
+	return ids
+}
+
+// seedHistory fills a store with channel messages and a direct conversation
+func seedHistory(t *testing.T, store MessageStore) {
+	t.Helper()
+	msgs := []*Message{
+		{ID: "g1", Sender: "alice", Channel: "general", Timestamp: 100, Payload: map[string]interface{}{"content": "one"}},
+		{ID: "g2", Sender: "bob", Channel: "general", Timestamp: 200, Payload: map[string]interface{}{"content": "two"}},
+		{ID: "r1", Sender: "carol", Channel: "random", Timestamp: 150, Payload: map[string]interface{}{"content": "elsewhere"}},
+		{ID: "d1", Sender: "alice", Recipient: "bob", Channel: "dm", Timestamp: 300, Payload: map[string]interface{}{"content": "hi bob"}},
+		{ID: "d2", Sender: "bob", Recipient: "alice", Channel: "dm", Timestamp: 400, Payload: map[string]interface{}{"content": "hi alice"}},
+		{ID: "d3", Sender: "bob", Recipient: "carol", Channel: "dm", Timestamp: 500, Payload: map[string]interface{}{"content": "hi carol"}},
+	}
+	if _, err := store.SaveMessages(msgs); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+}
+
+func TestHistoryReadRoutes(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantIDs    []string
+	}{
+		{"channel", "/api/db/messages/channel?channel=general", http.StatusOK, []string{"g1", "g2"}},
+		{"empty channel", "/api/db/messages/channel?channel=nobody-here", http.StatusOK, []string{}},
+		{"channel missing", "/api/db/messages/channel", http.StatusBadRequest, nil},
+		{"group conversation", "/api/db/messages/channel?channel=" + groupConversationPrefix + "abc", http.StatusForbidden, nil},
+		{"channel bad cursor", "/api/db/messages/channel?channel=general&cursor=!!", http.StatusBadRequest, nil},
+		{"dm either order", "/api/db/messages/dm?user1=bob&user2=alice&user_id=alice", http.StatusOK, []string{"d1", "d2"}},
+		{"dm missing user", "/api/db/messages/dm?user1=bob&user_id=bob", http.StatusBadRequest, nil},
+		{"dm without caller", "/api/db/messages/dm?user1=bob&user2=alice", http.StatusBadRequest, nil},
+		{"dm outsider", "/api/db/messages/dm?user1=bob&user2=alice&user_id=carol", http.StatusForbidden, nil},
+		{"user sent or received", "/api/db/messages/user?user_id=carol", http.StatusOK, []string{"r1", "d3"}},
+		{"user missing", "/api/db/messages/user", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewInMemoryMessageStore()
+			seedHistory(t, store)
+			_, mux := useHistoryStore(t, store)
+
+			status, reply := serveHistory(t, mux, http.MethodGet, tt.target, "")
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if tt.wantIDs == nil {
+				return
+			}
+			if got := replyIDs(reply); strings.Join(got, ",") != strings.Join(tt.wantIDs, ",") {
+				t.Fatalf("ids = %v, want %v", got, tt.wantIDs)
+			}
+			if reply["has_more"] != false || reply["next_cursor"] != "" {
+				t.Fatalf("short page reported more: %v", reply)
+			}
+		})
+	}
+}
+
+func TestHistoryChannelVisibility(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		withToken  bool
+		wantStatus int
+	}{
+		{"public without user", "/api/db/messages/channel?channel=general", false, http.StatusOK},
+		{"private without user", "/api/db/messages/channel?channel=staff", false, http.StatusBadRequest},
+		{"private member", "/api/db/messages/channel?channel=staff&user_id=alice", false, http.StatusOK},
+		{"private non-member", "/api/db/messages/channel?channel=staff&user_id=carol", false, http.StatusForbidden},
+		{"secret member", "/api/db/messages/channel?channel=vault&user_id=alice", false, http.StatusOK},
+		{"secret non-member", "/api/db/messages/channel?channel=vault&user_id=carol", false, http.StatusNotFound},
+		{"registered member without token", "/api/db/messages/channel?channel=vault&user_id=bob", false, http.StatusUnauthorized},
+		{"registered member with token", "/api/db/messages/channel?channel=vault&user_id=bob", true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewInMemoryMessageStore()
+			seedHistory(t, store)
+			channels, mux := useHistoryStore(t, store)
+			for channel, visibility := range map[string]ChannelVisibility{"staff": VisibilityPrivate, "vault": VisibilitySecret} {
+				channels.SaveChannelSettings(&ChannelSettings{Channel: channel, Visibility: visibility})
+				for _, member := range []string{"alice", "bob"} {
+					channels.SetChannelRole(&ChannelMember{Channel: channel, UserID: member, Role: RoleMember})
+				}
+			}
+			_, token, err := globalServer.RegisterUser("bob", "bob")
+			if err != nil {
+				t.Fatalf("register: %v", err)
+			}
+
+			target := tt.target
+			if tt.withToken {
+				target += "&token=" + token
+			}
+			if status, _ := serveHistory(t, mux, http.MethodGet, target, ""); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHistoryWriteRoutes(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantLeft   []string // IDs still in general afterwards
+	}{
+		{"save", http.MethodPost, "/api/db/messages",
+			`{"id":"g3","sender":"carol","channel":"general","content":"three","timestamp":300}`,
+			http.StatusOK, []string{"g1", "g2", "g3"}},
+		{"save without channel", http.MethodPost, "/api/db/messages",
+			`{"id":"g3","sender":"carol","content":"three"}`, http.StatusBadRequest, []string{"g1", "g2"}},
+		{"save bad json", http.MethodPost, "/api/db/messages", `{`, http.StatusBadRequest, []string{"g1", "g2"}},
+		{"save wrong method", http.MethodGet, "/api/db/messages", "", http.StatusMethodNotAllowed, []string{"g1", "g2"}},
+		{"delete message", http.MethodDelete, "/api/db/messages/g1", "", http.StatusOK, []string{"g2"}},
+		{"clear channel", http.MethodDelete, "/api/db/messages/channel/general", "", http.StatusOK, []string{}},
+		{"clear other channel", http.MethodDelete, "/api/db/messages/channel/random", "", http.StatusOK, []string{"g1", "g2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewInMemoryMessageStore()
+			seedHistory(t, store)
+			_, mux := useHistoryStore(t, store)
+
+			if status, _ := serveHistory(t, mux, tt.method, tt.target, tt.body); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			_, reply := serveHistory(t, mux, http.MethodGet, "/api/db/messages/channel?channel=general", "")
+			if got := replyIDs(reply); strings.Join(got, ",") != strings.Join(tt.wantLeft, ",") {
+				t.Fatalf("general = %v, want %v", got, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestHistoryWriteAuthorization(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		withToken  bool
+		wantStatus int
+	}{
+		{"public channel", `{"id":"n1","sender":"carol","channel":"general"}`, false, http.StatusOK},
+		{"private member", `{"id":"n1","sender":"alice","channel":"staff"}`, false, http.StatusOK},
+		{"private non-member", `{"id":"n1","sender":"carol","channel":"staff"}`, false, http.StatusForbidden},
+		{"secret non-member", `{"id":"n1","sender":"carol","channel":"vault"}`, false, http.StatusNotFound},
+		{"registered sender without token", `{"id":"n1","sender":"bob","channel":"general"}`, false, http.StatusUnauthorized},
+		{"registered sender with token", `{"id":"n1","sender":"bob","channel":"vault"}`, true, http.StatusOK},
+		{"another sender with a token", `{"id":"n1","sender":"alice","channel":"general"}`, true, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewInMemoryMessageStore()
+			channels, mux := useHistoryStore(t, store)
+			for channel, visibility := range map[string]ChannelVisibility{"staff": VisibilityPrivate, "vault": VisibilitySecret} {
+				channels.SaveChannelSettings(&ChannelSettings{Channel: channel, Visibility: visibility})
+				for _, member := range []string{"alice", "bob"} {
+					channels.SetChannelRole(&ChannelMember{Channel: channel, UserID: member, Role: RoleMember})
+				}
+			}
+			_, token, err := globalServer.RegisterUser("bob", "bob")
+			if err != nil {
+				t.Fatalf("register: %v", err)
+			}
+
+			target := "/api/db/messages"
+			if tt.withToken {
+				target += "?token=" + token
+			}
+			status, _ := serveHistory(t, mux, http.MethodPost, target, tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if _, err := store.GetMessage("n1"); (err == nil) != (status == http.StatusOK) {
+				t.Fatalf("stored = %v after status %d", err == nil, status)
+			}
+		})
+	}
+}
+
+func TestHistoryBatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       map[string]float64
+	}{
+		{"all saved", `[{"id":"n1","sender":"alice","channel":"c"},{"id":"n2","sender":"alice","channel":"c"}]`,
+			http.StatusOK, map[string]float64{"saved": 2, "duplicates": 0, "invalid": 0}},
+		{"duplicates and invalid", `[{"id":"g1","sender":"alice","channel":"general"},{"id":"n1","sender":"alice"},7,{"id":"n2","sender":"alice","channel":"c"}]`,
+			http.StatusOK, map[string]float64{"saved": 1, "duplicates": 1, "invalid": 2}},
+		{"other senders", `[{"id":"n1","sender":"bob","channel":"c"},{"id":"n2","sender":"alice","channel":"c"}]`,
+			http.StatusOK, map[string]float64{"saved": 1, "duplicates": 0, "invalid": 1}},
+		{"not a list", `{"id":"n1"}`, http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewInMemoryMessageStore()
+			seedHistory(t, store)
+			_, mux := useHistoryStore(t, store)
+
+			status, reply := serveHistory(t, mux, http.MethodPost, "/api/db/messages/batch?user_id=alice", tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			for key, want := range tt.want {
+				if reply[key] != want {
+					t.Errorf("%s = %v, want %v", key, reply[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryCursorPagination(t *testing.T) {
+	tests := []struct {
+		name      string
+		count     int
+		limit     string
+		wantPages int
+	}{
+		{"exact pages", 6, "3", 3}, // the last page is empty: a full page can't know it was the last
+		{"partial last page", 7, "3", 3},
+		{"one page", 2, "10", 1},
+		{"same timestamps", 5, "2", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewInMemoryMessageStore()
+			want := make([]string, 0, tt.count)
+			for i := 0; i < tt.count; i++ {
+				id := string(rune('a' + i))
+				ts := int64(1000 + i)
+				if tt.name == "same timestamps" {
+					ts = 1000
+				}
+				if err := store.SaveMessage(&Message{ID: id, Sender: "alice", Channel: "paged", Timestamp: ts}); err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, id)
+			}
+			_, mux := useHistoryStore(t, store)
+
+			// Walk back from the newest page, prepending each older page
+			got := make([]string, 0, tt.count)
+			cursor, pages := "", 0
+			for {
+				target := "/api/db/messages/channel?channel=paged&limit=" + tt.limit
+				if cursor != "" {
+					target += "&cursor=" + cursor
+				}
+				status, reply := serveHistory(t, mux, http.MethodGet, target, "")
+				if status != http.StatusOK {
+					t.Fatalf("page %d: status %d", pages, status)
+				}
+				pages++
+				got = append(replyIDs(reply), got...)
+				if reply["has_more"] != true {
+					break
+				}
+				cursor = reply["next_cursor"].(string)
+				if cursor == "" || pages > tt.count+1 {
+					t.Fatalf("page %d: has_more without a usable cursor", pages)
+				}
+			}
+
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Fatalf("pages joined = %v, want %v", got, want)
+			}
+			if pages != tt.wantPages {
+				t.Fatalf("pages = %d, want %d", pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestMessageQueryRequiresAdminKey(t *testing.T) {
+	store := NewInMemoryMessageStore()
+	seedHistory(t, store)
+	_, mux := useHistoryStore(t, store)
+
+	tests := []struct {
+		name       string
+		key        string
+		wantStatus int
+		wantIDs    []string
+	}{
+		{"no key", "", http.StatusUnauthorized, nil},
+		{"wrong key", "nope", http.StatusUnauthorized, nil},
+		{"admin key", "admin-key", http.StatusOK, []string{"d1", "d2", "d3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/messages?channel=dm&sort=timestamp", nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantIDs == nil {
+				return
+			}
+			var reply map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+				t.Fatalf("decode reply: %v", err)
+			}
+			if got := replyIDs(reply); strings.Join(got, ",") != strings.Join(tt.wantIDs, ",") {
+				t.Fatalf("ids = %v, want %v", got, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestDeprecatedHistoryRoutes(t *testing.T) {
+	store := NewInMemoryMessageStore()
+	_, mux := useHistoryStore(t, store)
+
+	for _, target := range []string{"/api/db/messages/dm?user1=alice&user2=bob", "/api/db/messages/user?user_id=alice"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Header().Get("Deprecation") != "true" || !strings.Contains(rec.Header().Get("Link"), "</api/messages>") {
+			t.Errorf("%s: headers = %v, want a deprecation pointing at /api/messages", target, rec.Header())
+		}
+	}
+}
+
+func TestMessageSearchScopedToCaller(t *testing.T) {
+	store := NewInMemoryMessageStore()
+	seedHistory(t, store)
+	channels, mux := useHistoryStore(t, store)
+	channels.SaveChannelSettings(&ChannelSettings{Channel: "vault", Visibility: VisibilitySecret})
+	channels.SetChannelRole(&ChannelMember{Channel: "vault", UserID: "bob", Role: RoleMember})
+	if err := store.SaveMessage(&Message{ID: "v1", Sender: "bob", Channel: "vault", Timestamp: 600, Payload: map[string]interface{}{"content": "hi vault"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		key        string
+		wantStatus int
+		wantCount  float64
+	}{
+		{"no key or user", "q=hi", "", http.StatusUnauthorized, 0},
+		{"own messages", "q=hi&user_id=alice", "", http.StatusOK, 2},
+		{"another user's messages", "q=hi&user=bob&user_id=alice", "", http.StatusForbidden, 0},
+		{"secret channel non-member", "q=hi&channel=vault&user_id=alice", "", http.StatusNotFound, 0},
+		{"secret channel member", "q=hi&channel=vault&user_id=bob", "", http.StatusOK, 1},
+		{"admin key", "q=hi", "admin-key", http.StatusOK, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/messages/search?"+tt.query, nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var reply map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+				t.Fatalf("decode reply: %v", err)
+			}
+			if reply["count"] != tt.wantCount {
+				t.Fatalf("count = %v, want %v", reply["count"], tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestDeprecatedHistoryRoutesAuthenticate(t *testing.T) {
+	store := NewInMemoryMessageStore()
+	seedHistory(t, store)
+	_, mux := useHistoryStore(t, store)
+	_, token, err := globalServer.RegisterUser("bob", "bob")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+	}{
+		{"dm without token", "/api/db/messages/dm?user1=alice&user2=bob&user_id=bob", http.StatusUnauthorized},
+		{"dm with token", "/api/db/messages/dm?user1=alice&user2=bob&token=" + token, http.StatusOK},
+		{"dm of others with token", "/api/db/messages/dm?user1=alice&user2=carol&token=" + token, http.StatusForbidden},
+		{"user without token", "/api/db/messages/user?user_id=bob", http.StatusUnauthorized},
+		{"user with token", "/api/db/messages/user?user_id=bob&token=" + token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := serveHistory(t, mux, http.MethodGet, tt.target, ""); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestChannelStatsVisibility(t *testing.T) {
+	store := NewInMemoryMessageStore()
+	seedHistory(t, store)
+	channels, mux := useHistoryStore(t, store)
+	channels.SaveChannelSettings(&ChannelSettings{Channel: "vault", Visibility: VisibilitySecret})
+	channels.SetChannelRole(&ChannelMember{Channel: "vault", UserID: "alice", Role: RoleMember})
+	if err := store.SaveMessage(&Message{ID: "v1", Sender: "carol", Channel: "vault", Timestamp: 600}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		key        string
+		wantStatus int
+	}{
+		{"all without key", "/api/db/messages/count?all=true", "", http.StatusUnauthorized},
+		{"all with key", "/api/db/messages/count?all=true", "admin-key", http.StatusOK},
+		{"public channel", "/api/db/messages/count?channel=general", "", http.StatusOK},
+		{"secret member", "/api/db/messages/count?channel=vault&user_id=alice", "", http.StatusOK},
+		{"secret non-member", "/api/db/messages/count?channel=vault&user_id=bob", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	// carol sent v1 but isn't a member, so her own listing leaves it out
+	_, reply := serveHistory(t, mux, http.MethodGet, "/api/db/messages/user?user_id=carol", "")
+	if got := replyIDs(reply); strings.Join(got, ",") != "r1,d3" {
+		t.Fatalf("carol's messages = %v, want [r1 d3]", got)
+	}
+}
@@ -0,0 +1,143 @@
+package wssocket
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// IdempotencyCache deduplicates the message IDs each user sent within a
+// window. A retried send with the same ID is dropped and answered with the
+// original acks instead of being processed again. Messages without a client
+// ID are never deduplicated. IDs and acks are remembered in the server's
+// message window, shared with replay protection.
+type IdempotencyCache struct {
+	window time.Duration
+}
+
+// NewIdempotencyCache creates a cache that remembers message IDs for window
+func NewIdempotencyCache(window time.Duration) *IdempotencyCache {
+	return &IdempotencyCache{window: window}
+}
+
+// SetIdempotencyCache enables deduplication of inbound messages; nil disables it
+func (s *Server) SetIdempotencyCache(c *IdempotencyCache) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idempotency = c
+	s.resizeMessageWindowLocked()
+}
+
+// messageWindow remembers the message IDs each user sent, and the acks the
+// server sent for them. The server keeps one for both replay protection and
+// duplicate detection, holding IDs for the longer of their windows.
+type messageWindow struct {
+	mu        sync.Mutex
+	retain    time.Duration
+	entries   map[string]*messageWindowEntry // sender + id -> entry
+	lastSweep time.Time
+}
+
+// messageWindowEntry is a message ID seen within the window
+type messageWindowEntry struct {
+	seenAt time.Time
+	acks   []map[string]interface{} // Payloads of the acks sent so far
+}
+
+// newMessageWindow creates a window that remembers message IDs for retain
+func newMessageWindow(retain time.Duration) *messageWindow {
+	return &messageWindow{
+		retain:  retain,
+		entries: make(map[string]*messageWindowEntry),
+	}
+}
+
+// resizeMessageWindowLocked keeps the message window as long as the longer of
+// the replay and duplicate windows, dropping it when neither needs it;
+// s.mu must be held
+func (s *Server) resizeMessageWindowLocked() {
+	var retain time.Duration
+	if s.replayGuard != nil {
+		retain = s.replayGuard.window
+	}
+	if s.idempotency != nil {
+		retain = max(retain, s.idempotency.window)
+	}
+	switch {
+	case retain <= 0:
+		s.messageIDs = nil
+	case s.messageIDs == nil:
+		s.messageIDs = newMessageWindow(retain)
+	default:
+		s.messageIDs.mu.Lock()
+		s.messageIDs.retain = retain
+		s.messageIDs.mu.Unlock()
+	}
+}
+
+// messageWindowKey identifies a message ID per sender
+func messageWindowKey(userID, id string) string {
+	return userID + "\x00" + id
+}
+
+// claim records a message ID as of now, or reports when it was first seen
+// within the window and returns the acks sent for it so far
+func (w *messageWindow) claim(userID, id string, now time.Time) (seenAt time.Time, acks []map[string]interface{}, seen bool) {
+	key := messageWindowKey(userID, id)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if now.Sub(w.lastSweep) > w.retain {
+		for k, entry := range w.entries {
+			if now.Sub(entry.seenAt) > w.retain {
+				delete(w.entries, k)
+			}
+		}
+		w.lastSweep = now
+	}
+
+	if entry, exists := w.entries[key]; exists && now.Sub(entry.seenAt) <= w.retain {
+		acks = make([]map[string]interface{}, 0, len(entry.acks))
+		for _, ack := range entry.acks {
+			acks = append(acks, maps.Clone(ack))
+		}
+		return entry.seenAt, acks, true
+	}
+	w.entries[key] = &messageWindowEntry{seenAt: now}
+	return now, nil, false
+}
+
+// release forgets a message ID whose message was rejected, so a retry is
+// processed
+func (w *messageWindow) release(userID, id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.entries, messageWindowKey(userID, id))
+}
+
+// recordAck keeps an ack sent for a remembered message ID
+func (w *messageWindow) recordAck(userID, id string, payload map[string]interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if entry, exists := w.entries[messageWindowKey(userID, id)]; exists {
+		entry.acks = append(entry.acks, maps.Clone(payload))
+	}
+}
+
+// answerDuplicate drops a retried message, resending the acks its first
+// send got. If it is still being processed, the acks follow as usual.
+func (s *Server) answerDuplicate(conn *Connection, msg *Message, acks []map[string]interface{}) {
+	s.metrics.duplicatesDropped.Add(1)
+	for _, payload := range acks {
+		payload["duplicate"] = true
+		s.SendToConnection(conn.ID, &Message{
+			ID:        generateMessageID(),
+			Type:      MessageTypeAck,
+			Sender:    "system",
+			Recipient: conn.UserID,
+			Channel:   msg.Channel,
+			Timestamp: s.now().Unix(),
+			Payload:   payload,
+		})
+	}
+}
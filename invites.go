@@ -0,0 +1,294 @@
+package wssocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ChannelVisibility controls who may join a channel and whether it is listed
+type ChannelVisibility string
+
+// Channel visibilities. Private and secret channels are joined by invitation;
+// a secret channel also looks like it doesn't exist to anyone outside it.
+const (
+	VisibilityPublic  ChannelVisibility = "public"
+	VisibilityPrivate ChannelVisibility = "private"
+	VisibilitySecret  ChannelVisibility = "secret"
+)
+
+// ParseChannelVisibility validates a visibility name
+func ParseChannelVisibility(s string) (ChannelVisibility, error) {
+	switch v := ChannelVisibility(s); v {
+	case VisibilityPublic, VisibilityPrivate, VisibilitySecret:
+		return v, nil
+	}
+	return "", fmt.Errorf("unknown channel visibility: %s", s)
+}
+
+// DefaultInviteTTL is how long an invitation can be accepted
+const DefaultInviteTTL = 7 * 24 * time.Hour
+
+// Invite statuses
+const (
+	InvitePending  = "pending"
+	InviteAccepted = "accepted"
+	InviteDeclined = "declined"
+)
+
+// ErrInviteRequired is returned when a user joins a private channel they
+// aren't a member of
+var ErrInviteRequired = errors.New("channel requires an invitation")
+
+// ChannelInvite is an invitation for a user to join a channel
+type ChannelInvite struct {
+	ID          string `json:"id"`
+	Channel     string `json:"channel"`
+	UserID      string `json:"user_id"` // Who is invited
+	InvitedBy   string `json:"invited_by"`
+	Status      string `json:"status"`
+	CreatedAt   int64  `json:"created_at"`
+	ExpiresAt   int64  `json:"expires_at"`
+	RespondedAt int64  `json:"responded_at,omitempty"`
+}
+
+// patternsReach reports whether pattern subscribers receive a channel.
+// Moderation, private, secret and group conversation channels only reach
+// their own subscribers.
+func (s *Server) patternsReach(channel string) bool {
+	return !isModerationChannel(channel) && !isGroupConversation(channel) &&
+		s.ChannelSettings(channel).Visibility == VisibilityPublic
+}
+
+// follows reports whether a connection receives a channel's broadcasts
+func (s *Server) follows(conn *Connection, channel string) bool {
+	if isGroupConversation(channel) {
+		return s.isParticipant(channel, conn.UserID)
+	}
+	if s.patternsReach(channel) {
+		return conn.Follows(channel)
+	}
+	return conn.InChannel(channel)
+}
+
+// isChannelMember reports whether a user has a recorded place in a channel,
+// from a role or an accepted invitation
+func (s *Server) isChannelMember(channel, userID string) bool {
+	if globalChannelStore == nil {
+		return false
+	}
+	member, err := globalChannelStore.GetChannelMember(channel, userID)
+	if err != nil {
+		log.Printf("Error loading membership of %s in %s: %v", userID, channel, err)
+		return false
+	}
+	return member != nil
+}
+
+// authorizeJoin keeps private and secret channels to their members. A
+// secret channel is reported as not found.
+func (s *Server) authorizeJoin(conn *Connection, channel string) error {
+	visibility := s.ChannelSettings(channel).Visibility
+	if visibility == VisibilityPublic || s.isChannelMember(channel, conn.UserID) {
+		return nil
+	}
+	if visibility == VisibilitySecret {
+		return fmt.Errorf("channel not found: %s", channel)
+	}
+	return fmt.Errorf("%s may not join %s: %w", conn.UserID, channel, ErrInviteRequired)
+}
+
+// authorizePost keeps posts to private and secret channels to their members.
+// Joins, reads and moderation actions are checked where they are handled.
+func (s *Server) authorizePost(conn *Connection, msg *Message) error {
+	if msg.Channel == "" || conn.trusted || readOnlyAllowed[msg.Type] || isGroupConversation(msg.Channel) {
+		return nil
+	}
+	return s.authorizeJoin(conn, msg.Channel)
+}
+
+// channelHidden reports whether a channel is secret, for endpoints that
+// must not reveal it
+func channelHidden(channel string) bool {
+	return globalServer.ChannelSettings(channel).Visibility == VisibilitySecret
+}
+
+// InviteToChannel invites a user to a channel and tells them. The invite can
+// be accepted until it expires.
+func (s *Server) InviteToChannel(channel, userID, invitedBy string) (*ChannelInvite, error) {
+	if globalChannelStore == nil {
+		return nil, fmt.Errorf("channel store not available")
+	}
+	now := s.now()
+	invite := &ChannelInvite{
+		ID:        "inv_" + uuid.New().String(),
+		Channel:   channel,
+		UserID:    userID,
+		InvitedBy: invitedBy,
+		Status:    InvitePending,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(DefaultInviteTTL).Unix(),
+	}
+	if err := globalChannelStore.SaveInvite(invite); err != nil {
+		return nil, fmt.Errorf("save invite to %s: %w", channel, err)
+	}
+
+	s.sendToUser(userID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeChannelInvite,
+		Sender:    "system",
+		Recipient: userID,
+		Channel:   channel,
+		Timestamp: invite.CreatedAt,
+		Payload:   invite.payload(),
+	})
+	return invite, nil
+}
+
+// RespondToInvite accepts or declines a pending invitation for userID.
+// Accepting records the user as a member of the channel, in the same
+// transaction as the answer.
+func (s *Server) RespondToInvite(inviteID, userID string, accept bool) (*ChannelInvite, error) {
+	if globalChannelStore == nil {
+		return nil, fmt.Errorf("channel store not available")
+	}
+	invite, err := globalChannelStore.GetInvite(inviteID)
+	if err != nil {
+		return nil, fmt.Errorf("load invite %s: %w", inviteID, err)
+	}
+	if invite == nil || invite.UserID != userID {
+		return nil, fmt.Errorf("invite %s not found", inviteID)
+	}
+	if invite.Status != InvitePending {
+		return nil, fmt.Errorf("invite %s was already %s", inviteID, invite.Status)
+	}
+	if s.now().Unix() >= invite.ExpiresAt {
+		return nil, fmt.Errorf("invite %s has expired", inviteID)
+	}
+
+	join := accept && !s.isChannelMember(invite.Channel, userID)
+	invite.Status = InviteDeclined
+	if accept {
+		invite.Status = InviteAccepted
+	}
+	invite.RespondedAt = s.now().Unix()
+	err = s.WithTx(context.Background(), func(tx Tx) error {
+		if join {
+			member := &ChannelMember{Channel: invite.Channel, UserID: userID, Role: RoleMember, UpdatedAt: invite.RespondedAt}
+			if err := tx.SetChannelRole(member); err != nil {
+				return fmt.Errorf("add %s to %s: %w", userID, invite.Channel, err)
+			}
+		}
+		if err := tx.SaveInvite(invite); err != nil {
+			return fmt.Errorf("save invite %s: %w", inviteID, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// payload is the invite as the body of an invite message
+func (inv *ChannelInvite) payload() map[string]interface{} {
+	return map[string]interface{}{
+		"invite_id":  inv.ID,
+		"channel":    inv.Channel,
+		"user_id":    inv.UserID,
+		"invited_by": inv.InvitedBy,
+		"status":     inv.Status,
+		"expires_at": inv.ExpiresAt,
+	}
+}
+
+// ChannelInviteHandler lets a moderator invite a user to a channel. The
+// payload carries user_id; the inviter gets the invite back as confirmation.
+func ChannelInviteHandler(conn *Connection, msg *Message) error {
+	userID, _ := msg.Payload["user_id"].(string)
+	if msg.Channel == "" || userID == "" {
+		return fmt.Errorf("channel and user_id are required")
+	}
+	if err := globalServer.requireChannelRole(conn, msg, msg.Channel, RoleModerator); err != nil {
+		return err
+	}
+	invite, err := globalServer.InviteToChannel(msg.Channel, userID, conn.UserID)
+	if err != nil {
+		return err
+	}
+	return globalServer.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeChannelInvite,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Channel:   msg.Channel,
+		Timestamp: invite.CreatedAt,
+		Payload:   invite.payload(),
+	})
+}
+
+// InviteResponseHandler accepts or declines the invite named by invite_id.
+// Accepting subscribes the connection; either way the inviter is told.
+func InviteResponseHandler(conn *Connection, msg *Message) error {
+	inviteID, _ := msg.Payload["invite_id"].(string)
+	if inviteID == "" {
+		return fmt.Errorf("invite_id is required in payload")
+	}
+	accept := msg.Type == MessageTypeInviteAccept
+	invite, err := globalServer.RespondToInvite(inviteID, conn.UserID, accept)
+	if err != nil {
+		return err
+	}
+	if accept {
+		if err := globalServer.SubscribeToChannel(conn.ID, invite.Channel); err != nil {
+			return fmt.Errorf("subscribe to %s: %w", invite.Channel, err)
+		}
+	}
+
+	globalServer.sendToUser(invite.InvitedBy, &Message{
+		ID:        generateMessageID(),
+		Type:      msg.Type,
+		Sender:    "system",
+		Recipient: invite.InvitedBy,
+		Channel:   invite.Channel,
+		Timestamp: invite.RespondedAt,
+		Payload:   invite.payload(),
+	})
+	return nil
+}
+
+// setupInviteRoutes registers the listing of a user's pending invitations
+func setupInviteRoutes(mux *http.ServeMux) {
+	// GET lists ?user_id='s pending invitations, newest first. Registered
+	// users must send their token, since invitations name secret channels.
+	mux.HandleFunc("/api/channels/invites", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if globalChannelStore == nil {
+			http.Error(w, "Channel store not available", http.StatusServiceUnavailable)
+			return
+		}
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+
+		invites, err := globalChannelStore.ListPendingInvites(userID, globalServer.now().Unix())
+		if err != nil {
+			log.Printf("Error listing invites of %s: %v", userID, err)
+			http.Error(w, "Failed to list invites", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"invites": invites,
+			"count":   len(invites),
+		})
+	})
+}
@@ -0,0 +1,470 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ipMaintenance is how often bans are re-read from the store, so bans made
+// on other nodes take effect here too, and idle connect rates are forgotten
+const ipMaintenance = time.Minute
+
+// IP admission errors
+var (
+	ErrIPBanned          = errors.New("ip address is banned")
+	ErrIPConnectionLimit = errors.New("too many connections from this ip address")
+)
+
+// IPBan refuses connections from an address or a CIDR range
+type IPBan struct {
+	IP        string `json:"ip"` // e.g. 203.0.113.7 or 203.0.113.0/24
+	Reason    string `json:"reason,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at,omitempty"` // Unix seconds; 0 never expires
+
+	prefix netip.Prefix
+}
+
+// parse validates the ban's address and normalizes it, so 10.0.0.9/8 and
+// 10.0.0.0/8 are the same ban
+func (b *IPBan) parse() error {
+	if strings.Contains(b.IP, "/") {
+		prefix, err := netip.ParsePrefix(b.IP)
+		if err != nil {
+			return fmt.Errorf("invalid ip range %q", b.IP)
+		}
+		b.prefix = prefix.Masked()
+	} else {
+		addr, err := netip.ParseAddr(b.IP)
+		if err != nil {
+			return fmt.Errorf("invalid ip address %q", b.IP)
+		}
+		b.prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+	}
+	if b.prefix.IsSingleIP() {
+		b.IP = b.prefix.Addr().String()
+	} else {
+		b.IP = b.prefix.String()
+	}
+	return nil
+}
+
+// expired reports whether the ban has lapsed at now (unix seconds)
+func (b *IPBan) expired(now int64) bool {
+	return b.ExpiresAt != 0 && b.ExpiresAt <= now
+}
+
+// IPBanStore persists the ban list
+type IPBanStore interface {
+	SaveIPBan(ban *IPBan) error
+	DeleteIPBan(ip string) error
+	ListIPBans(now int64) ([]*IPBan, error) // Bans still in force at now
+}
+
+// ipThrottle tracks connections per client IP and the bans in force
+type ipThrottle struct {
+	mu      sync.Mutex
+	open    map[string]int          // IP -> open connections
+	conns   map[string]string       // Connection ID -> IP
+	buckets map[string]*tokenBucket // IP -> connect rate allowance
+	bans    map[string]*IPBan       // Keyed by normalized IP
+	store   IPBanStore
+}
+
+// IPConnectionCount is how many connections one client IP has open
+type IPConnectionCount struct {
+	IP          string `json:"ip"`
+	Connections int    `json:"connections"`
+}
+
+// clientIP returns the address a connect request came from. X-Forwarded-For
+// is only believed with ServerConfig.TrustForwardedFor, since clients can
+// otherwise set it to dodge limits and bans.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.config.TrustForwardedFor {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// admitIP checks a connection attempt against the bans and per-IP limits,
+// and counts it against its IP if allowed
+func (s *Server) admitIP(connID, ip string) error {
+	t := &s.ips
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ban := t.banFor(ip, s.now().Unix()); ban != nil {
+		s.metrics.ipRejected.Add(1)
+		return ErrIPBanned
+	}
+	if limit := s.config.MaxConnectionsPerIP; limit > 0 && t.open[ip] >= limit {
+		s.metrics.ipRejected.Add(1)
+		return fmt.Errorf("%w: at most %d", ErrIPConnectionLimit, limit)
+	}
+	if limit := s.config.IPConnectRate; limit.Enabled() {
+		if t.buckets == nil {
+			t.buckets = make(map[string]*tokenBucket)
+		}
+		bucket, ok := t.buckets[ip]
+		if !ok {
+			bucket = &tokenBucket{}
+			t.buckets[ip] = bucket
+		}
+		if !bucket.allow(limit) {
+			s.metrics.ipRejected.Add(1)
+			return fmt.Errorf("%w: at most %g connections/s", ErrRateLimited, limit.PerSecond)
+		}
+	}
+
+	if t.open == nil {
+		t.open = make(map[string]int)
+		t.conns = make(map[string]string)
+	}
+	t.open[ip]++
+	t.conns[connID] = ip
+	return nil
+}
+
+// releaseIP stops counting a connection against its IP; it is safe to call
+// more than once
+func (s *Server) releaseIP(connID string) {
+	t := &s.ips
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ip, ok := t.conns[connID]
+	if !ok {
+		return
+	}
+	delete(t.conns, connID)
+	if t.open[ip]--; t.open[ip] <= 0 {
+		delete(t.open, ip)
+	}
+}
+
+// banFor returns the ban covering ip, if any. The caller holds t.mu.
+func (t *ipThrottle) banFor(ip string, now int64) *IPBan {
+	if len(t.bans) == 0 {
+		return nil
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	addr = addr.Unmap()
+	if ban, ok := t.bans[addr.String()]; ok && !ban.expired(now) {
+		return ban
+	}
+	for _, ban := range t.bans {
+		if !ban.prefix.IsSingleIP() && ban.prefix.Contains(addr) && !ban.expired(now) {
+			return ban
+		}
+	}
+	return nil
+}
+
+// SetIPBanStore loads the ban list from store, keeps new bans there and
+// re-reads it periodically to pick up changes made elsewhere
+func (s *Server) SetIPBanStore(store IPBanStore) error {
+	bans, err := store.ListIPBans(s.now().Unix())
+	if err != nil {
+		return fmt.Errorf("load ip bans: %w", err)
+	}
+	s.ips.mu.Lock()
+	defer s.ips.mu.Unlock()
+	s.ips.store = store
+	s.ips.setBans(bans)
+	return nil
+}
+
+// setBans replaces the ban list, skipping entries that don't parse. The
+// caller holds t.mu.
+func (t *ipThrottle) setBans(bans []*IPBan) {
+	t.bans = make(map[string]*IPBan, len(bans))
+	for _, ban := range bans {
+		if err := ban.parse(); err != nil {
+			log.Printf("Skipping stored ip ban: %v", err)
+			continue
+		}
+		t.bans[ban.IP] = ban
+	}
+}
+
+// maintainIPs refreshes the ban list from the store and forgets the connect
+// rate of IPs that have been quiet, until the server stops
+func (s *Server) maintainIPs() {
+	ticker := time.NewTicker(ipMaintenance)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		s.ips.mu.Lock()
+		store := s.ips.store
+		s.ips.mu.Unlock()
+		var bans []*IPBan
+		var err error
+		if store != nil {
+			if bans, err = store.ListIPBans(s.now().Unix()); err != nil {
+				log.Printf("Error reloading ip bans: %v", err)
+			}
+		}
+
+		s.ips.mu.Lock()
+		if store != nil && err == nil {
+			s.ips.setBans(bans)
+		}
+		s.ips.pruneBuckets(s.config.IPConnectRate)
+		s.ips.mu.Unlock()
+		s.pruneChallenges()
+		s.pruneReconnects()
+	}
+}
+
+// pruneBuckets drops the rate allowance of IPs whose bucket has refilled, as
+// a fresh bucket would start in the same state. The caller holds t.mu.
+func (t *ipThrottle) pruneBuckets(limit RateLimit) {
+	for ip, bucket := range t.buckets {
+		bucket.mu.Lock()
+		idle := !limit.Enabled() || time.Since(bucket.refilledAt).Seconds()*limit.PerSecond >= limit.burst()
+		bucket.mu.Unlock()
+		if idle {
+			delete(t.buckets, ip)
+		}
+	}
+}
+
+// BanIP refuses new connections from an address or range and closes the
+// ones it already has. The ban is saved to the ban store, if one is set.
+func (s *Server) BanIP(ban *IPBan) error {
+	if err := ban.parse(); err != nil {
+		return err
+	}
+	if ban.CreatedAt == 0 {
+		ban.CreatedAt = s.now().Unix()
+	}
+
+	s.ips.mu.Lock()
+	store := s.ips.store
+	s.ips.mu.Unlock()
+	if store != nil {
+		if err := store.SaveIPBan(ban); err != nil {
+			return fmt.Errorf("save ip ban: %w", err)
+		}
+	}
+
+	s.ips.mu.Lock()
+	if s.ips.bans == nil {
+		s.ips.bans = make(map[string]*IPBan)
+	}
+	s.ips.bans[ban.IP] = ban
+	var banned []string
+	for connID, ip := range s.ips.conns {
+		if addr, err := netip.ParseAddr(ip); err == nil && ban.prefix.Contains(addr.Unmap()) {
+			banned = append(banned, connID)
+		}
+	}
+	s.ips.mu.Unlock()
+
+	for _, connID := range banned {
+		s.closeConnection(connID, websocket.ClosePolicyViolation, ErrIPBanned.Error())
+	}
+	log.Printf("Banned %s (%s), closed %d connections", ban.IP, ban.Reason, len(banned))
+	return nil
+}
+
+// UnbanIP lifts the ban on an address or range, reporting whether there was one
+func (s *Server) UnbanIP(ip string) (bool, error) {
+	ban := &IPBan{IP: ip}
+	if err := ban.parse(); err != nil {
+		return false, err
+	}
+
+	s.ips.mu.Lock()
+	store := s.ips.store
+	_, existed := s.ips.bans[ban.IP]
+	s.ips.mu.Unlock()
+	if store != nil {
+		if err := store.DeleteIPBan(ban.IP); err != nil {
+			return false, fmt.Errorf("delete ip ban: %w", err)
+		}
+	}
+
+	s.ips.mu.Lock()
+	delete(s.ips.bans, ban.IP)
+	s.ips.mu.Unlock()
+	return existed, nil
+}
+
+// IPBans lists the bans in force, newest first
+func (s *Server) IPBans() []*IPBan {
+	now := s.now().Unix()
+	s.ips.mu.Lock()
+	bans := make([]*IPBan, 0, len(s.ips.bans))
+	for _, ban := range s.ips.bans {
+		if !ban.expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	s.ips.mu.Unlock()
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].CreatedAt != bans[j].CreatedAt {
+			return bans[i].CreatedAt > bans[j].CreatedAt
+		}
+		return bans[i].IP < bans[j].IP
+	})
+	return bans
+}
+
+// IPConnections returns the client IPs with open connections, busiest first
+func (s *Server) IPConnections() []IPConnectionCount {
+	s.ips.mu.Lock()
+	counts := make([]IPConnectionCount, 0, len(s.ips.open))
+	for ip, n := range s.ips.open {
+		counts = append(counts, IPConnectionCount{IP: ip, Connections: n})
+	}
+	s.ips.mu.Unlock()
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Connections != counts[j].Connections {
+			return counts[i].Connections > counts[j].Connections
+		}
+		return counts[i].IP < counts[j].IP
+	})
+	return counts
+}
+
+// setupIPAdminRoutes registers the IP ban and connection endpoints
+func setupIPAdminRoutes(mux *http.ServeMux, s *Server, apiKeys []string) {
+	// GET lists bans, POST adds one and DELETE ?ip= lifts one
+	mux.HandleFunc("/api/admin/ip-bans", func(w http.ResponseWriter, r *http.Request) {
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"bans": s.IPBans()})
+
+		case http.MethodPost:
+			var req struct {
+				IP       string `json:"ip"`
+				Reason   string `json:"reason"`
+				Duration int64  `json:"duration"` // Seconds; 0 bans until lifted
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Duration < 0 {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			ban := &IPBan{IP: req.IP, Reason: req.Reason, CreatedAt: s.now().Unix()}
+			if req.Duration > 0 {
+				ban.ExpiresAt = ban.CreatedAt + req.Duration
+			}
+			if err := ban.parse(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := s.BanIP(ban); err != nil {
+				log.Printf("Error banning %s: %v", req.IP, err)
+				http.Error(w, "Failed to ban ip", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusCreated, ban)
+
+		case http.MethodDelete:
+			ip := r.URL.Query().Get("ip")
+			if ip == "" {
+				http.Error(w, "ip is required", http.StatusBadRequest)
+				return
+			}
+			if err := (&IPBan{IP: ip}).parse(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			existed, err := s.UnbanIP(ip)
+			if err != nil {
+				log.Printf("Error unbanning %s: %v", ip, err)
+				http.Error(w, "Failed to unban ip", http.StatusInternalServerError)
+				return
+			}
+			if !existed {
+				http.Error(w, "ip is not banned", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// GET lists the client IPs with open connections, busiest first
+	mux.HandleFunc("/api/admin/ips", func(w http.ResponseWriter, r *http.Request) {
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		counts := s.IPConnections()
+		total := len(counts)
+		if len(counts) > limit {
+			counts = counts[:limit]
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"ips":   counts,
+			"total": total,
+		})
+	})
+
+	// GET lists the users and IPs in a reconnect storm, busiest first
+	mux.HandleFunc("/api/admin/reconnects", func(w http.ResponseWriter, r *http.Request) {
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		storms := s.ReconnectStorms()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"storms":    storms,
+			"count":     len(storms),
+			"threshold": s.config.Reconnect.StormThreshold,
+			"window":    s.config.Reconnect.StormWindow.String(),
+		})
+	})
+}
@@ -0,0 +1,241 @@
+package wssocket
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+// KafkaAcks is how many replicas must store a batch before the broker
+// acknowledges it
+type KafkaAcks string
+
+// Kafka delivery guarantees
+const (
+	KafkaAcksNone   KafkaAcks = "none"   // Fire and forget; a broker failure loses events silently
+	KafkaAcksLeader KafkaAcks = "leader" // The partition leader stored it
+	KafkaAcksAll    KafkaAcks = "all"    // Every in-sync replica stored it
+)
+
+// ParseKafkaAcks accepts none, leader or all, or Kafka's 0, 1 and -1
+func ParseKafkaAcks(s string) (KafkaAcks, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "all", "-1":
+		return KafkaAcksAll, nil
+	case "leader", "1":
+		return KafkaAcksLeader, nil
+	case "none", "0":
+		return KafkaAcksNone, nil
+	}
+	return "", fmt.Errorf("invalid kafka acks %q: want none, leader or all", s)
+}
+
+// opts returns the producer options for the acks level. Idempotent writes
+// need every in-sync replica, so they are turned off below that.
+func (a KafkaAcks) opts() []kgo.Opt {
+	switch a {
+	case KafkaAcksNone:
+		return []kgo.Opt{kgo.RequiredAcks(kgo.NoAck()), kgo.DisableIdempotentWrite()}
+	case KafkaAcksLeader:
+		return []kgo.Opt{kgo.RequiredAcks(kgo.LeaderAck()), kgo.DisableIdempotentWrite()}
+	}
+	return []kgo.Opt{kgo.RequiredAcks(kgo.AllISRAcks())}
+}
+
+// Kafka SASL mechanisms
+const (
+	KafkaSASLPlain       = "PLAIN"
+	KafkaSASLScramSHA256 = "SCRAM-SHA-256"
+	KafkaSASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// KafkaConfig controls where and how events are produced
+type KafkaConfig struct {
+	Brokers       []string      // Bootstrap host:port addresses
+	Topic         string        // Must exist unless the brokers auto-create topics
+	ClientID      string        // Sent to the brokers for their logs and quotas
+	TLS           *tls.Config   // nil for plaintext
+	SASLMechanism string        // PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512; empty for none
+	SASLUser      string        // Required with a SASL mechanism
+	SASLPassword  string        // Required with a SASL mechanism
+	Acks          KafkaAcks     // Defaults to KafkaAcksAll
+	MaxRetries    int           // Retries per batch before its events are dropped; negative never retries
+	QueueSize     int           // Pending events before new ones are dropped
+	Linger        time.Duration // How long a batch waits to fill before it is sent
+	Timeout       time.Duration // Per-request timeout, and how long Stop waits to flush
+}
+
+// saslMechanism returns the configured SASL mechanism, or nil without one
+func (c KafkaConfig) saslMechanism() (sasl.Mechanism, error) {
+	if c.SASLMechanism == "" {
+		return nil, nil
+	}
+	if c.SASLUser == "" || c.SASLPassword == "" {
+		return nil, fmt.Errorf("kafka SASL %s needs a user and password", c.SASLMechanism)
+	}
+	switch strings.ToUpper(c.SASLMechanism) {
+	case KafkaSASLPlain:
+		return plain.Auth{User: c.SASLUser, Pass: c.SASLPassword}.AsMechanism(), nil
+	case KafkaSASLScramSHA256:
+		return scram.Auth{User: c.SASLUser, Pass: c.SASLPassword}.AsSha256Mechanism(), nil
+	case KafkaSASLScramSHA512:
+		return scram.Auth{User: c.SASLUser, Pass: c.SASLPassword}.AsSha512Mechanism(), nil
+	}
+	return nil, fmt.Errorf("invalid kafka SASL mechanism %q: want PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512", c.SASLMechanism)
+}
+
+// KafkaStats counts what a KafkaSink did with its events
+type KafkaStats struct {
+	Published uint64 `json:"published"`
+	Dropped   uint64 `json:"dropped"` // Queue full, retries exhausted or rejected by the broker
+	Queued    int    `json:"queued"`
+}
+
+// KafkaSink is an EventSink that produces events as JSON records to a Kafka
+// topic, keyed by SinkEvent.Key so a channel's events share a partition and
+// stay in order. Producing, batching and retries are left to franz-go, whose
+// key partitioner hashes like Kafka's default one. A batch is retried after a
+// timeout as well, so delivery is at least once; consumers drop repeats by
+// event ID.
+type KafkaSink struct {
+	config KafkaConfig
+	client *kgo.Client
+
+	published atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// NewKafkaSink creates a sink and its producer. Brokers aren't contacted
+// until the first event.
+func NewKafkaSink(config KafkaConfig) (*KafkaSink, error) {
+	if len(config.Brokers) == 0 {
+		return nil, fmt.Errorf("kafka brokers are required")
+	}
+	if config.Topic == "" {
+		return nil, fmt.Errorf("kafka topic is required")
+	}
+	acks, err := ParseKafkaAcks(string(config.Acks))
+	if err != nil {
+		return nil, err
+	}
+	config.Acks = acks
+	mechanism, err := config.saslMechanism()
+	if err != nil {
+		return nil, err
+	}
+	if config.ClientID == "" {
+		config.ClientID = "go-ws"
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 5
+	}
+	if config.QueueSize == 0 {
+		config.QueueSize = 10000
+	}
+	if config.Linger == 0 {
+		config.Linger = 50 * time.Millisecond
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(config.Brokers...),
+		kgo.ClientID(config.ClientID),
+		kgo.DefaultProduceTopic(config.Topic),
+		kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)),
+		kgo.RecordRetries(max(config.MaxRetries, 0)),
+		kgo.MaxBufferedRecords(config.QueueSize),
+		kgo.ProducerLinger(config.Linger),
+		kgo.ProduceRequestTimeout(config.Timeout),
+		kgo.WithLogger(kgo.BasicLogger(log.Writer(), kgo.LogLevelWarn, func() string { return "kafka: " })),
+	}
+	opts = append(opts, acks.opts()...)
+	if config.TLS != nil {
+		opts = append(opts, kgo.DialTLSConfig(config.TLS))
+	}
+	if mechanism != nil {
+		opts = append(opts, kgo.SASL(mechanism))
+	}
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("kafka client: %w", err)
+	}
+	return &KafkaSink{config: config, client: client}, nil
+}
+
+// Publish queues an event without blocking, dropping it when the queue is full
+func (k *KafkaSink) Publish(event *SinkEvent) {
+	record, err := kafkaRecord(event)
+	if err != nil {
+		k.drop(err)
+		return
+	}
+	k.client.TryProduce(context.Background(), record, func(_ *kgo.Record, err error) {
+		if err != nil {
+			k.drop(err)
+			return
+		}
+		k.published.Add(1)
+	})
+}
+
+// drop counts a dropped event, logging the first of every thousand
+func (k *KafkaSink) drop(err error) {
+	if k.dropped.Add(1)%1000 == 1 {
+		log.Printf("kafka: dropping events: %v", err)
+	}
+}
+
+// Stats returns the sink's counters
+func (k *KafkaSink) Stats() KafkaStats {
+	return KafkaStats{
+		Published: k.published.Load(),
+		Dropped:   k.dropped.Load(),
+		Queued:    int(k.client.BufferedProduceRecords()),
+	}
+}
+
+// Stop sends what is still queued, waiting up to the configured timeout, and
+// closes the broker connections. Events not sent by then are dropped.
+func (k *KafkaSink) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), k.config.Timeout)
+	defer cancel()
+	if err := k.client.Flush(ctx); err != nil {
+		log.Printf("kafka: flush at shutdown: %v", err)
+		// Fail what is left so its promises count the drops before we return
+		abort, cancel := context.WithTimeout(context.Background(), k.config.Timeout)
+		defer cancel()
+		k.client.AbortBufferedRecords(abort)
+	}
+	k.client.Close()
+}
+
+// kafkaRecord encodes an event as a record carrying its key, its JSON and
+// kind and schema headers
+func kafkaRecord(event *SinkEvent) (*kgo.Record, error) {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("encode event %s: %w", event.ID, err)
+	}
+	return &kgo.Record{
+		Key:       []byte(event.Key()),
+		Value:     value,
+		Timestamp: event.Time,
+		Headers: []kgo.RecordHeader{
+			{Key: "kind", Value: []byte(event.Kind)},
+			{Key: "schema", Value: []byte(strconv.Itoa(event.Schema))},
+		},
+	}, nil
+}
@@ -0,0 +1,173 @@
+package wssocket
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const kafkaTestTopic = "gows.events"
+
+// kafkaTestEvents returns n message events spread over a few channels
+func kafkaTestEvents(n int) []*SinkEvent {
+	channels := []string{"general", "random", "support"}
+	events := make([]*SinkEvent, n)
+	for i := range events {
+		events[i] = &SinkEvent{
+			Schema:  SinkEventSchema,
+			ID:      "evt_" + strconv.Itoa(i),
+			Kind:    SinkEventMessage,
+			Time:    time.Now(),
+			UserID:  "alice",
+			Channel: channels[i%len(channels)],
+		}
+	}
+	return events
+}
+
+// consumeKafka reads n records from the test topic
+func consumeKafka(t *testing.T, cluster *kfake.Cluster, n int, opts ...kgo.Opt) []*kgo.Record {
+	t.Helper()
+	opts = append(opts, kgo.SeedBrokers(cluster.ListenAddrs()...), kgo.ConsumeTopics(kafkaTestTopic))
+	consumer, err := kgo.NewClient(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var records []*kgo.Record
+	for len(records) < n {
+		fetches := consumer.PollFetches(ctx)
+		if err := fetches.Err(); err != nil {
+			t.Fatalf("consume after %d of %d records: %v", len(records), n, err)
+		}
+		records = append(records, fetches.Records()...)
+	}
+	return records
+}
+
+func TestKafkaSinkProducesKeyedRecords(t *testing.T) {
+	cluster := kfake.MustCluster(kfake.NumBrokers(3), kfake.SeedTopics(6, kafkaTestTopic))
+	defer cluster.Close()
+
+	sink, err := NewKafkaSink(KafkaConfig{Brokers: cluster.ListenAddrs(), Topic: kafkaTestTopic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := kafkaTestEvents(30)
+	for _, event := range events {
+		sink.Publish(event)
+	}
+	sink.Stop()
+
+	if stats := sink.Stats(); stats.Published != uint64(len(events)) || stats.Dropped != 0 {
+		t.Fatalf("stats = %+v, want %d published", stats, len(events))
+	}
+
+	partitions := make(map[string]int32)
+	for _, record := range consumeKafka(t, cluster, len(events)) {
+		var event SinkEvent
+		if err := json.Unmarshal(record.Value, &event); err != nil {
+			t.Fatalf("decode %s: %v", record.Value, err)
+		}
+		if string(record.Key) != event.Channel {
+			t.Errorf("record key = %q, want channel %q", record.Key, event.Channel)
+		}
+		headers := make(map[string]string)
+		for _, h := range record.Headers {
+			headers[h.Key] = string(h.Value)
+		}
+		if headers["kind"] != SinkEventMessage || headers["schema"] != strconv.Itoa(SinkEventSchema) {
+			t.Errorf("headers = %v, want kind and schema", headers)
+		}
+		if p, ok := partitions[event.Channel]; ok && p != record.Partition {
+			t.Errorf("channel %s on partitions %d and %d", event.Channel, p, record.Partition)
+		}
+		partitions[event.Channel] = record.Partition
+	}
+}
+
+func TestKafkaSinkAuthenticatesWithSASL(t *testing.T) {
+	for _, mechanism := range []string{KafkaSASLPlain, KafkaSASLScramSHA256, KafkaSASLScramSHA512} {
+		t.Run(mechanism, func(t *testing.T) {
+			cluster := kfake.MustCluster(
+				kfake.EnableSASL(),
+				kfake.Superuser(mechanism, "gows", "s3cret"),
+				kfake.SeedTopics(1, kafkaTestTopic),
+			)
+			defer cluster.Close()
+
+			sink, err := NewKafkaSink(KafkaConfig{
+				Brokers:       cluster.ListenAddrs(),
+				Topic:         kafkaTestTopic,
+				SASLMechanism: mechanism,
+				SASLUser:      "gows",
+				SASLPassword:  "s3cret",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sink.Publish(kafkaTestEvents(1)[0])
+			sink.Stop()
+			if stats := sink.Stats(); stats.Published != 1 {
+				t.Fatalf("stats = %+v, want the event published", stats)
+			}
+		})
+	}
+}
+
+func TestKafkaSinkDropsWhenTheQueueIsFull(t *testing.T) {
+	// Nothing listens on the broker, so published events stay queued
+	sink, err := NewKafkaSink(KafkaConfig{
+		Brokers:   []string{"127.0.0.1:1"},
+		Topic:     kafkaTestTopic,
+		QueueSize: 5,
+		Timeout:   100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range kafkaTestEvents(8) {
+		sink.Publish(event)
+	}
+	// Drops are reported through the producer's promises, which run async
+	deadline := time.Now().Add(5 * time.Second)
+	for sink.Stats().Dropped < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := sink.Stats(); stats.Queued != 5 || stats.Dropped != 3 {
+		t.Fatalf("stats = %+v, want 5 queued and 3 dropped", stats)
+	}
+	sink.Stop()
+	if stats := sink.Stats(); stats.Published != 0 || stats.Dropped != 8 {
+		t.Fatalf("stats after stop = %+v, want all 8 dropped", stats)
+	}
+}
+
+func TestNewKafkaSinkValidatesConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config KafkaConfig
+	}{
+		{"no brokers", KafkaConfig{Topic: kafkaTestTopic}},
+		{"no topic", KafkaConfig{Brokers: []string{"kafka:9092"}}},
+		{"bad acks", KafkaConfig{Brokers: []string{"kafka:9092"}, Topic: kafkaTestTopic, Acks: "some"}},
+		{"unknown SASL mechanism", KafkaConfig{Brokers: []string{"kafka:9092"}, Topic: kafkaTestTopic, SASLMechanism: "GSSAPI", SASLUser: "u", SASLPassword: "p"}},
+		{"SASL without a password", KafkaConfig{Brokers: []string{"kafka:9092"}, Topic: kafkaTestTopic, SASLMechanism: KafkaSASLPlain, SASLUser: "u"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if sink, err := NewKafkaSink(tt.config); err == nil {
+				sink.Stop()
+				t.Fatal("config accepted")
+			}
+		})
+	}
+}
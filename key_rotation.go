@@ -0,0 +1,262 @@
+package wssocket
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentKeyUsage counts stored messages in a channel sealed with one key
+type ContentKeyUsage struct {
+	Channel string `json:"channel"`
+	KeyID   string `json:"key_id"` // Empty for plaintext content
+	Count   int    `json:"count"`
+}
+
+// ContentRewriter is implemented by stores that let EncryptedStore rewrite
+// stored content in place for key rotation
+type ContentRewriter interface {
+	// ContentKeyUsage counts stored messages per channel and key
+	ContentKeyUsage() ([]ContentKeyUsage, error)
+	// ScanMessages returns raw stored messages with IDs after afterID, ordered by ID
+	ScanMessages(afterID string, limit int) ([]*Message, error)
+	// UpdateContent replaces a message's stored content if it still equals oldContent
+	UpdateContent(id, oldContent, newContent string) error
+}
+
+// contentKeyID returns the key ID of sealed content, or "" for plaintext
+func contentKeyID(content string) string {
+	_, rest, encrypted := cutSealed(content)
+	if !encrypted {
+		return ""
+	}
+	keyID, _, _ := strings.Cut(rest, ":")
+	return keyID
+}
+
+// RekeyStatus reports the progress of the background re-keying job
+type RekeyStatus struct {
+	Running    bool      `json:"running"`
+	Scanned    int       `json:"scanned"`
+	Rekeyed    int       `json:"rekeyed"`
+	Failed     int       `json:"failed"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// KeyReport summarizes how stored data is spread across keys
+type KeyReport struct {
+	Keys     map[string]int    `json:"keys"`      // Message count per key ID; "" is plaintext
+	Stale    int               `json:"stale"`     // Messages not on their channel's current key
+	Channels []ContentKeyUsage `json:"channels"`  // Per-channel breakdown
+	Job      RekeyStatus       `json:"rekey_job"` // Last or running re-keying job
+}
+
+// rekeyState tracks rotation settings and the job on an EncryptedStore
+type rekeyState struct {
+	mu     sync.Mutex
+	lazy   bool
+	status RekeyStatus
+}
+
+// SetLazyRekey re-encrypts stale messages with the current key when they are read
+func (e *EncryptedStore) SetLazyRekey(enabled bool) {
+	e.rekey.mu.Lock()
+	defer e.rekey.mu.Unlock()
+	e.rekey.lazy = enabled
+}
+
+// lazyRekeyEnabled reports whether reads should re-encrypt stale messages
+func (e *EncryptedStore) lazyRekeyEnabled() bool {
+	e.rekey.mu.Lock()
+	defer e.rekey.mu.Unlock()
+	return e.rekey.lazy
+}
+
+// isStale reports whether stored content is not on the channel's current key
+func (e *EncryptedStore) isStale(channel, content string) bool {
+	currentID, _, err := e.keys.DataKey(channel)
+	if err != nil {
+		return false
+	}
+	return contentKeyID(content) != currentID
+}
+
+// reseal re-encrypts a stored message with its channel's current key, in the
+// format it was stored in
+func (e *EncryptedStore) reseal(rw ContentRewriter, msg *Message, stored, plain string) error {
+	prefix, _, _ := cutSealed(stored)
+	sealed, err := sealContent(e.keys, msg, prefix, plain)
+	if err != nil {
+		return err
+	}
+	return rw.UpdateContent(msg.ID, stored, sealed)
+}
+
+// maybeLazyRekey re-encrypts a stale message after it was read
+func (e *EncryptedStore) maybeLazyRekey(msg *Message, stored, plain string) {
+	rw, ok := e.inner.(ContentRewriter)
+	if !ok || !e.lazyRekeyEnabled() || !e.isStale(msg.Channel, stored) {
+		return
+	}
+	if err := e.reseal(rw, msg, stored, plain); err != nil {
+		log.Printf("lazy rekey of message %s failed: %v", msg.ID, err)
+	}
+}
+
+// KeyReport reports how much stored data remains on old keys
+func (e *EncryptedStore) KeyReport() (*KeyReport, error) {
+	rw, ok := e.inner.(ContentRewriter)
+	if !ok {
+		return nil, fmt.Errorf("store does not support key rotation")
+	}
+
+	usage, err := rw.ContentKeyUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &KeyReport{Keys: make(map[string]int), Channels: usage}
+	for _, u := range usage {
+		report.Keys[u.KeyID] += u.Count
+		currentID, _, err := e.keys.DataKey(u.Channel)
+		if err == nil && u.KeyID != currentID {
+			report.Stale += u.Count
+		}
+	}
+
+	e.rekey.mu.Lock()
+	report.Job = e.rekey.status
+	e.rekey.mu.Unlock()
+	return report, nil
+}
+
+// StartRekey launches a background job that re-encrypts every stale message
+// in batches. It fails if a job is already running.
+func (e *EncryptedStore) StartRekey(ctx context.Context, batchSize int) error {
+	rw, ok := e.inner.(ContentRewriter)
+	if !ok {
+		return fmt.Errorf("store does not support key rotation")
+	}
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+
+	e.rekey.mu.Lock()
+	if e.rekey.status.Running {
+		e.rekey.mu.Unlock()
+		return fmt.Errorf("rekey job already running")
+	}
+	e.rekey.status = RekeyStatus{Running: true, StartedAt: time.Now()}
+	e.rekey.mu.Unlock()
+
+	go e.runRekey(ctx, rw, batchSize)
+	return nil
+}
+
+// runRekey walks the store in ID order, resealing stale messages
+func (e *EncryptedStore) runRekey(ctx context.Context, rw ContentRewriter, batchSize int) {
+	var jobErr error
+	defer func() {
+		e.rekey.mu.Lock()
+		e.rekey.status.Running = false
+		e.rekey.status.FinishedAt = time.Now()
+		if jobErr != nil {
+			e.rekey.status.LastError = jobErr.Error()
+		}
+		e.rekey.mu.Unlock()
+	}()
+
+	afterID := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			jobErr = err
+			return
+		}
+
+		batch, err := rw.ScanMessages(afterID, batchSize)
+		if err != nil {
+			jobErr = fmt.Errorf("scan messages: %w", err)
+			return
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		scanned, rekeyed, failed := 0, 0, 0
+		var lastErr error
+		for _, msg := range batch {
+			afterID = msg.ID
+			scanned++
+
+			stored := messageContent(msg)
+			if !e.isStale(msg.Channel, stored) {
+				continue
+			}
+			plain, err := openContent(e.keys, msg, stored)
+			if err == nil {
+				err = e.reseal(rw, msg, stored, plain)
+			}
+			if err != nil {
+				failed++
+				lastErr = fmt.Errorf("message %s: %w", msg.ID, err)
+				continue
+			}
+			rekeyed++
+		}
+
+		e.rekey.mu.Lock()
+		e.rekey.status.Scanned += scanned
+		e.rekey.status.Rekeyed += rekeyed
+		e.rekey.status.Failed += failed
+		if lastErr != nil {
+			e.rekey.status.LastError = lastErr.Error()
+		}
+		e.rekey.mu.Unlock()
+	}
+}
+
+// setupEncryptionAdminRoutes registers key rotation endpoints, guarded by admin API keys
+func setupEncryptionAdminRoutes(mux *http.ServeMux, store *EncryptedStore, apiKeys []string) {
+	// Report data remaining on old keys
+	mux.HandleFunc("/api/admin/encryption", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		report, err := store.KeyReport()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	})
+
+	// Start the background re-keying job
+	mux.HandleFunc("/api/admin/encryption/rekey", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		if err := store.StartRekey(context.Background(), 0); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+	})
+}
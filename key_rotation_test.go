@@ -0,0 +1,102 @@
+package wssocket
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// rotatedStores saves messages under k1, then returns a store on the same
+// data whose active key is k2, as after a rotation and restart
+func rotatedStores(t *testing.T, ids ...string) (*InMemoryMessageStore, *EncryptedStore) {
+	t.Helper()
+	inner := NewInMemoryMessageStore()
+	before := NewEncryptedStore(inner, newTestKeys(t, map[string][]byte{"k1": testKey(1)}, "k1"))
+	for _, id := range ids {
+		msg := &Message{ID: id, Sender: "alice", Channel: "general", Payload: map[string]interface{}{"content": "text of " + id}}
+		if err := before.SaveMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after := NewEncryptedStore(inner, newTestKeys(t, map[string][]byte{"k1": testKey(1), "k2": testKey(2)}, "k2"))
+	return inner, after
+}
+
+// storedKeyID returns the key a message is stored under
+func storedKeyID(t *testing.T, inner *InMemoryMessageStore, id string) string {
+	t.Helper()
+	msg, err := inner.GetMessage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contentKeyID(messageContent(msg))
+}
+
+func TestLazyRekeyOnRead(t *testing.T) {
+	inner, store := rotatedStores(t, "m1", "m2")
+
+	// Without lazy re-keying, reads leave old messages alone
+	if got, err := store.GetMessage("m1"); err != nil || got.Payload["content"] != "text of m1" {
+		t.Fatalf("read under the old key = %v, %v", got, err)
+	}
+	if keyID := storedKeyID(t, inner, "m1"); keyID != "k1" {
+		t.Fatalf("m1 stored under %s, want it left on k1", keyID)
+	}
+
+	store.SetLazyRekey(true)
+	if got, err := store.GetMessage("m1"); err != nil || got.Payload["content"] != "text of m1" {
+		t.Fatalf("lazy read = %v, %v", got, err)
+	}
+	if keyID := storedKeyID(t, inner, "m1"); keyID != "k2" {
+		t.Fatalf("m1 stored under %s after a read, want k2", keyID)
+	}
+	if keyID := storedKeyID(t, inner, "m2"); keyID != "k1" {
+		t.Fatalf("unread m2 stored under %s, want k1", keyID)
+	}
+	if got, err := store.GetMessage("m1"); err != nil || got.Payload["content"] != "text of m1" {
+		t.Fatalf("read after re-keying = %v, %v", got, err)
+	}
+}
+
+func TestBackgroundRekey(t *testing.T) {
+	ids := make([]string, 7)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("m%d", i)
+	}
+	inner, store := rotatedStores(t, ids...)
+
+	report, err := store.KeyReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Stale != len(ids) || report.Keys["k1"] != len(ids) {
+		t.Fatalf("report before re-keying = %+v, want all %d stale on k1", report, len(ids))
+	}
+
+	if err := store.StartRekey(context.Background(), 3); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if report, err = store.KeyReport(); err != nil {
+			t.Fatal(err)
+		}
+		if !report.Job.Running || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if report.Job.Running || report.Job.Rekeyed != len(ids) || report.Job.Failed != 0 || report.Stale != 0 {
+		t.Fatalf("report after re-keying = %+v, want all %d moved to k2", report, len(ids))
+	}
+	for _, id := range ids {
+		if keyID := storedKeyID(t, inner, id); keyID != "k2" {
+			t.Fatalf("%s stored under %s, want k2", id, keyID)
+		}
+		if got, err := store.GetMessage(id); err != nil || got.Payload["content"] != "text of "+id {
+			t.Fatalf("read %s after re-keying = %v, %v", id, got, err)
+		}
+	}
+}
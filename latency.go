@@ -0,0 +1,124 @@
+package wssocket
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxReportedRTT bounds the round trip times clients report, so one bogus
+// value can't dominate the average
+const maxReportedRTT = time.Minute
+
+// latencyStats tracks what a client's application pings say about its link
+type latencyStats struct {
+	mu      sync.Mutex
+	rtt     time.Duration // Smoothed round trip time the client reported
+	skew    time.Duration // Client clock minus server clock, from the latest ping
+	samples uint64        // Round trip times reported so far
+}
+
+// report records a round trip time the client measured
+func (l *latencyStats) report(rtt time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.samples == 0 {
+		l.rtt = rtt
+	} else {
+		l.rtt = (l.rtt*7 + rtt) / 8
+	}
+	l.samples++
+}
+
+// setSkew records the latest clock skew estimate
+func (l *latencyStats) setSkew(skew time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.skew = skew
+}
+
+// Latency returns the connection's smoothed round trip time: what the client
+// reported in application pings, else the keepalive ping RTT; 0 before either
+func (c *Connection) Latency() time.Duration {
+	c.latency.mu.Lock()
+	rtt, samples := c.latency.rtt, c.latency.samples
+	c.latency.mu.Unlock()
+	if samples > 0 {
+		return rtt
+	}
+	return c.RTT()
+}
+
+// ClockSkew returns how far the client's clock is ahead of the server's,
+// negative when behind; 0 before its first application ping
+func (c *Connection) ClockSkew() time.Duration {
+	c.latency.mu.Lock()
+	defer c.latency.mu.Unlock()
+	return c.latency.skew
+}
+
+// latencySummary returns the mean and largest round trip time over the
+// connections that have measured one
+func (s *Server) latencySummary() (avg, longest time.Duration) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var total time.Duration
+	var count int
+	for _, conn := range s.connections {
+		rtt := conn.Latency()
+		if rtt <= 0 {
+			continue
+		}
+		total += rtt
+		count++
+		longest = max(longest, rtt)
+	}
+	if count > 0 {
+		avg = total / time.Duration(count)
+	}
+	return avg, longest
+}
+
+// PingHandler answers an application-level ping. The payload's client_time
+// (unix milliseconds) is echoed in a pong next to server_time, so the client
+// can work out its round trip time and clock offset. A client may send the
+// round trip time it measured last as rtt (milliseconds), which is averaged
+// into the connection's latency.
+func PingHandler(conn *Connection, msg *Message) error {
+	clientTime, ok := msg.Payload["client_time"].(float64)
+	if !ok {
+		return fmt.Errorf("client_time is required for ping")
+	}
+	if v, exists := msg.Payload["rtt"]; exists {
+		reported, ok := v.(float64)
+		if !ok || reported < 0 {
+			return fmt.Errorf("rtt must be a non-negative number of milliseconds")
+		}
+		conn.latency.report(min(time.Duration(reported*float64(time.Millisecond)), maxReportedRTT))
+	}
+
+	now := globalServer.now()
+	serverTime := now.UnixMilli()
+	// The ping was sent about half a round trip before it arrived
+	rtt := conn.Latency()
+	skew := time.UnixMilli(int64(clientTime)).Add(rtt / 2).Sub(now)
+	conn.latency.setSkew(skew)
+	payload := map[string]interface{}{
+		"client_time": clientTime,
+		"server_time": serverTime,
+		"skew":        skew.Milliseconds(),
+	}
+	if rtt > 0 {
+		payload["rtt"] = rtt.Milliseconds()
+	}
+	globalServer.metrics.appPings.Add(1)
+
+	return globalServer.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypePong,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Timestamp: now.Unix(),
+		Payload:   payload,
+	})
+}
@@ -0,0 +1,264 @@
+package wssocket
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Mention limits
+const (
+	MaxMentionsPerMessage = 20  // Further @usernames in a message are ignored
+	maxMentionExcerpt     = 200 // Characters of the message a mention event quotes
+)
+
+// mentionPattern finds @username where the @ doesn't follow a word
+// character, so email addresses aren't mentions
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9][A-Za-z0-9_.-]{2,31})`)
+
+// Mention records that a message mentioned a user. Only the reference is
+// kept; the content stays with the message.
+type Mention struct {
+	MessageID string `json:"message_id"`
+	UserID    string `json:"user_id"` // Who was mentioned
+	Sender    string `json:"sender"`
+	Channel   string `json:"channel,omitempty"` // Empty for direct messages
+	Timestamp int64  `json:"timestamp"`
+}
+
+// mentionCursor returns the cursor positioned at m
+func mentionCursor(m *Mention) *Cursor {
+	return &Cursor{Timestamp: m.Timestamp, ID: m.MessageID}
+}
+
+// truncateRunes shortens text to at most n characters, marking the cut
+func truncateRunes(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	return string([]rune(text)[:n-1]) + "…"
+}
+
+// mentionText returns the text of a chat message that may mention users
+func mentionText(msg *Message) string {
+	if content, ok := msg.Payload["content"].(string); ok {
+		return content
+	}
+	text, _ := msg.Payload["text"].(string)
+	return text
+}
+
+// parseMentions returns the distinct usernames mentioned in text, in order
+func parseMentions(text string) []string {
+	usernames := make([]string, 0)
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		// A trailing dot ends the sentence rather than the username
+		username, err := NormalizeUsername(strings.TrimRight(match[1], "."))
+		if err != nil || slices.Contains(usernames, username) {
+			continue
+		}
+		usernames = append(usernames, username)
+		if len(usernames) == MaxMentionsPerMessage {
+			break
+		}
+	}
+	return usernames
+}
+
+// canSee reports whether a user may read a message: the recipient of a
+// direct message, or anyone allowed in its channel
+func (s *Server) canSee(userID string, msg *Message) bool {
+	switch {
+	case msg.Recipient != "":
+		return userID == msg.Recipient
+	case isGroupConversation(msg.Channel):
+		return s.isParticipant(msg.Channel, userID)
+	case channelHidden(msg.Channel):
+		return s.isChannelMember(msg.Channel, userID)
+	default:
+		return true
+	}
+}
+
+// resolveMentions finds the registered users a chat message mentions and
+// lists their IDs in its "mentions" metadata for recipients. Users who
+// can't see the message, and the sender, are left out.
+func (s *Server) resolveMentions(msg *Message) []*Mention {
+	if msg.Metadata != nil {
+		delete(msg.Metadata, "mentions")
+	}
+	if globalUserStore == nil {
+		return nil
+	}
+	usernames := parseMentions(mentionText(msg))
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	mentions := make([]*Mention, 0, len(usernames))
+	userIDs := make([]string, 0, len(usernames))
+	for _, username := range usernames {
+		userID, err := globalUserStore.UserIDForUsername(username)
+		if err != nil {
+			log.Printf("Error resolving mention of %s: %v", username, err)
+			continue
+		}
+		if userID == "" || userID == msg.Sender || slices.Contains(userIDs, userID) || !s.canSee(userID, msg) {
+			continue
+		}
+		userIDs = append(userIDs, userID)
+		mentions = append(mentions, &Mention{
+			MessageID: msg.ID,
+			UserID:    userID,
+			Sender:    msg.Sender,
+			Channel:   msg.Channel,
+			Timestamp: msg.Timestamp,
+		})
+	}
+	if len(mentions) == 0 {
+		return nil
+	}
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]interface{})
+	}
+	msg.Metadata["mentions"] = userIDs
+	return mentions
+}
+
+// mentionsUser reports whether resolveMentions found userID in a message.
+// Only the server sets the list, so metadata a client sent doesn't count.
+func mentionsUser(msg *Message, userID string) bool {
+	userIDs, ok := msg.Metadata["mentions"].([]string)
+	return ok && slices.Contains(userIDs, userID)
+}
+
+// notifyMentions records a message's mentions and sends each mentioned user
+// a mention event. Offline users get it through the notifier and the
+// offline queue hook instead.
+func (s *Server) notifyMentions(msg *Message, mentions []*Mention) {
+	if len(mentions) == 0 {
+		return
+	}
+	if err := globalUserStore.SaveMentions(mentions); err != nil {
+		log.Printf("Error recording mentions in %s: %v", msg.ID, err)
+	}
+
+	s.mu.RLock()
+	hook := s.offlineQueueHook
+	s.mu.RUnlock()
+
+	excerpt := truncateRunes(mentionText(msg), maxMentionExcerpt)
+	for _, m := range mentions {
+		payload := map[string]interface{}{
+			"message_id": m.MessageID,
+			"sender":     m.Sender,
+			"excerpt":    excerpt,
+			"timestamp":  m.Timestamp,
+		}
+		if profile := s.profileSnippet(m.Sender); profile != nil {
+			payload["profile"] = profile
+		}
+		event := &Message{
+			ID:        generateMessageID(),
+			Type:      MessageTypeMention,
+			Sender:    "system",
+			Recipient: m.UserID,
+			Channel:   m.Channel,
+			Timestamp: s.now().Unix(),
+			Payload:   payload,
+		}
+		if err := s.sendToUser(m.UserID, event); errors.Is(err, ErrRecipientOffline) && hook != nil {
+			hook(event)
+		}
+	}
+}
+
+// SaveMentions records mentions, ignoring any already recorded
+func (s *InMemoryUserStore) SaveMentions(mentions []*Mention) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range mentions {
+		list := s.mentions[m.UserID]
+		if slices.ContainsFunc(list, func(existing *Mention) bool { return existing.MessageID == m.MessageID }) {
+			continue
+		}
+		stored := *m
+		list = append(list, &stored)
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Timestamp != list[j].Timestamp {
+				return list[i].Timestamp < list[j].Timestamp
+			}
+			return list[i].MessageID < list[j].MessageID
+		})
+		s.mentions[m.UserID] = list
+	}
+	return nil
+}
+
+// ListMentions returns a page of a user's mentions, newest first
+func (s *InMemoryUserStore) ListMentions(userID string, page Page) ([]*Mention, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	page = page.normalize()
+
+	mentions := make([]*Mention, 0, page.Limit)
+	list := s.mentions[userID]
+	for i := len(list) - 1; i >= 0 && len(mentions) < page.Limit; i-- {
+		m := list[i]
+		if page.Before != nil && (m.Timestamp > page.Before.Timestamp ||
+			(m.Timestamp == page.Before.Timestamp && m.MessageID >= page.Before.ID)) {
+			continue
+		}
+		copied := *m
+		mentions = append(mentions, &copied)
+	}
+	return mentions, nil
+}
+
+// setupMentionRoutes registers the listing of a user's mentions
+func setupMentionRoutes(mux *http.ServeMux) {
+	// GET lists the messages that mentioned ?user_id=, newest first
+	mux.HandleFunc("/api/users/mentions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if globalUserStore == nil {
+			http.Error(w, "User store not available", http.StatusServiceUnavailable)
+			return
+		}
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+		page, err := parseCursorPage(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mentions, err := globalUserStore.ListMentions(userID, page)
+		if err != nil {
+			log.Printf("Error listing mentions of %s: %v", userID, err)
+			http.Error(w, "Failed to list mentions", http.StatusInternalServerError)
+			return
+		}
+		hasMore := len(mentions) == page.Limit
+		var nextCursor string
+		if hasMore {
+			nextCursor = mentionCursor(mentions[len(mentions)-1]).String()
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"mentions":    mentions,
+			"count":       len(mentions),
+			"limit":       page.Limit,
+			"has_more":    hasMore,
+			"next_cursor": nextCursor,
+		})
+	})
+}
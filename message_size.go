@@ -0,0 +1,74 @@
+package wssocket
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultMaxMessageSize is the largest inbound message, in bytes, when
+// ServerConfig.MaxMessageSize is unset
+const DefaultMaxMessageSize = 1 << 20
+
+// tooLargeGracePeriod is how long a client that sent a message over the
+// limit has to answer the close frame
+const tooLargeGracePeriod = 5 * time.Second
+
+// errMessageTooLarge is returned for an inbound message over the size limit
+var errMessageTooLarge = errors.New("message too large")
+
+// readLimited reads the next message, giving up once it is over limit bytes.
+// Unlike SetReadLimit, this leaves the connection open, so the client can be
+// told why before it is closed.
+func readLimited(ws *websocket.Conn, limit int64) ([]byte, error) {
+	_, r, err := ws.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errMessageTooLarge
+	}
+	return data, nil
+}
+
+// rejectTooLarge tells the client its message was over the size limit, and
+// closes the connection with 1009 once that has been written
+func (s *Server) rejectTooLarge(conn *Connection) {
+	limit := s.config.MaxMessageSize
+	s.metrics.oversizedRejected.Add(1)
+	msg := &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeError,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"code":  "message_too_large",
+			"error": fmt.Sprintf("messages are limited to %d bytes", limit),
+			"limit": limit,
+		},
+		closeFrame: websocket.FormatCloseMessage(websocket.CloseMessageTooBig, errMessageTooLarge.Error()),
+	}
+	if err := s.SendToConnection(conn.ID, msg); err != nil {
+		s.closeConnection(conn.ID, websocket.CloseMessageTooBig, errMessageTooLarge.Error())
+	}
+}
+
+// writeClose sends the close frame a message carries, if any, and reports
+// whether it did
+func writeClose(ws *websocket.Conn, msgs ...*Message) bool {
+	for _, msg := range msgs {
+		if msg.closeFrame != nil {
+			ws.WriteControl(websocket.CloseMessage, msg.closeFrame, time.Now().Add(time.Second))
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Metadata keys for message expiry. A sender sets "ttl" to a number of
+// seconds or a duration string such as "5s"; the server stamps "expires_at"
+// in unix milliseconds when it accepts the message.
+const (
+	MetadataTTL       = "ttl"
+	MetadataExpiresAt = "expires_at"
+)
+
+// messageTTL returns the time to live a message asks for; 0 means none
+func messageTTL(msg *Message) time.Duration {
+	switch v := msg.Metadata[MetadataTTL].(type) {
+	case float64:
+		return time.Duration(v * float64(time.Second))
+	case int:
+		return time.Duration(v) * time.Second
+	case int64:
+		return time.Duration(v) * time.Second
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return time.Duration(f * float64(time.Second))
+		}
+	case string:
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return time.Duration(n * float64(time.Second))
+		}
+	}
+	return 0
+}
+
+// messageExpiry returns when a message expires. Messages the server never
+// stamped expire ttl after their timestamp.
+func messageExpiry(msg *Message) (time.Time, bool) {
+	switch v := msg.Metadata[MetadataExpiresAt].(type) {
+	case float64:
+		return time.UnixMilli(int64(math.Round(v))), true
+	case int64:
+		return time.UnixMilli(v), true
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return time.UnixMilli(n), true
+		}
+	}
+	if ttl := messageTTL(msg); ttl > 0 && msg.Timestamp > 0 {
+		return time.Unix(msg.Timestamp, 0).Add(ttl), true
+	}
+	return time.Time{}, false
+}
+
+// stampExpiry records when a message with a ttl expires, counting from now
+func stampExpiry(msg *Message, now time.Time) {
+	ttl := messageTTL(msg)
+	if ttl <= 0 {
+		return
+	}
+	if _, stamped := msg.Metadata[MetadataExpiresAt]; stamped {
+		return
+	}
+	msg.Metadata[MetadataExpiresAt] = now.Add(ttl).UnixMilli()
+}
+
+// isEphemeral reports whether a message carries an expiry
+func isEphemeral(msg *Message) bool {
+	_, ok := messageExpiry(msg)
+	return ok
+}
+
+// expired reports whether a message is past its expiry on the server clock
+func (s *Server) expired(msg *Message) bool {
+	at, ok := messageExpiry(msg)
+	return ok && !s.now().Before(at)
+}
+
+// dropExpired counts and discards a message that can no longer be delivered
+// in time; it reports whether msg was dropped
+func (s *Server) dropExpired(msg *Message) bool {
+	if !s.expired(msg) {
+		return false
+	}
+	s.metrics.expiredDropped.Add(1)
+	return true
+}
+
+// sendEphemeral queues a message with an expiry only if the connection can
+// take it now. Spooling it behind a backlog would only deliver it late.
+func (s *Server) sendEphemeral(conn *Connection, msg *Message) error {
+	if s.dropExpired(msg) {
+		return nil
+	}
+	conn.mu.RLock()
+	o := conn.overflow
+	conn.mu.RUnlock()
+	if o == nil || !o.backlogged() {
+		select {
+		case conn.outChan <- msg:
+			conn.notifyWriter()
+			return nil
+		default:
+		}
+	}
+	s.metrics.expiredDropped.Add(1)
+	return nil
+}
@@ -0,0 +1,167 @@
+package wssocket
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// serverMetrics holds counters updated on hot paths
+type serverMetrics struct {
+	handlerTimeouts atomic.Uint64
+	replaysRejected atomic.Uint64
+	rateLimited     atomic.Uint64
+	expiredDropped  atomic.Uint64
+	pingsSent       atomic.Uint64
+	pongsMissed     atomic.Uint64
+	appPings        atomic.Uint64
+
+	moderationFlagged     atomic.Uint64
+	moderationRejected    atomic.Uint64
+	moderationQuarantined atomic.Int64 // Messages waiting for an external verdict
+	spamDetected          atomic.Uint64
+	mutedRejected         atomic.Uint64
+	slowModeRejected      atomic.Uint64
+	duplicatesDropped     atomic.Uint64
+	oversizedRejected     atomic.Uint64
+	ipRejected            atomic.Uint64
+	challengesIssued      atomic.Uint64
+	challengesSolved      atomic.Uint64
+	challengesFailed      atomic.Uint64
+	reconnectStorms       atomic.Uint64
+
+	sessionLookups     atomic.Uint64
+	sessionMisses      atomic.Uint64
+	sessionsResumed    atomic.Uint64
+	sessionStoreErrors atomic.Uint64
+	queueWaitCount     atomic.Uint64
+	queueWaitTotal     atomic.Int64 // Nanoseconds
+	queueWaitMax       atomic.Int64 // Nanoseconds
+	workerBusy         atomic.Int64 // Nanoseconds workers spent processing
+}
+
+// observeQueueWait records how long a message waited for a worker
+func (m *serverMetrics) observeQueueWait(wait time.Duration) {
+	m.queueWaitCount.Add(1)
+	m.queueWaitTotal.Add(int64(wait))
+	for {
+		longest := m.queueWaitMax.Load()
+		if int64(wait) <= longest || m.queueWaitMax.CompareAndSwap(longest, int64(wait)) {
+			return
+		}
+	}
+}
+
+// MetricsSnapshot is a point-in-time copy of the server counters
+type MetricsSnapshot struct {
+	ActiveConnections  int     `json:"active_connections"`
+	HandlerTimeouts    uint64  `json:"handler_timeouts"`
+	ReplaysRejected    uint64  `json:"replays_rejected"`
+	RateLimited        uint64  `json:"rate_limited"`
+	ExpiredDropped     uint64  `json:"expired_dropped"`      // Messages with a ttl dropped instead of queued or sent late
+	PingsSent          uint64  `json:"pings_sent"`           // WebSocket keepalive pings
+	PongsMissed        uint64  `json:"pongs_missed"`         // Pings still unanswered when the next one went out
+	SessionLookups     uint64  `json:"session_lookups"`      // Session store reads
+	SessionMisses      uint64  `json:"session_misses"`       // Reads that found no live session
+	SessionsResumed    uint64  `json:"sessions_resumed"`     // Reconnects that resumed a stored session
+	SessionStoreErrors uint64  `json:"session_store_errors"` // Failed session store calls
+	MessageWorkers     int     `json:"message_workers"`
+	QueueDepth         int     `json:"queue_depth"`       // Messages waiting for a worker
+	QueueWaitAvgMs     float64 `json:"queue_wait_avg_ms"` // Mean time from receipt to processing
+	QueueWaitMaxMs     float64 `json:"queue_wait_max_ms"` // Longest wait since startup
+
+	QueueDepthByPriority map[string]int `json:"queue_depth_by_priority"` // Waiting messages per priority level
+
+	ModerationFlagged     uint64 `json:"moderation_flagged"`
+	ModerationRejected    uint64 `json:"moderation_rejected"`
+	ModerationQuarantined int64  `json:"moderation_quarantined"` // Messages waiting for an external verdict
+	SpamDetected          uint64 `json:"spam_detected"`
+	MutedRejected         uint64 `json:"muted_rejected"`     // Messages dropped because their sender was muted
+	SlowModeRejected      uint64 `json:"slow_mode_rejected"` // Posts sent before the channel's slow mode allowed
+
+	DuplicatesDropped uint64 `json:"duplicates_dropped"` // Retried sends answered with their original acks
+	OversizedRejected uint64 `json:"oversized_rejected"` // Connections closed for a message over MaxMessageSize
+	IPRejected        uint64 `json:"ip_rejected"`        // Connection attempts refused by IP bans and limits
+	ChallengesIssued  uint64 `json:"challenges_issued"`
+	ChallengesSolved  uint64 `json:"challenges_solved"`
+	ChallengesFailed  uint64 `json:"challenges_failed"` // Wrong, expired or reused solutions and rejected CAPTCHAs
+	ReconnectStorms   uint64 `json:"reconnect_storms"`  // Users and IPs that went over the storm threshold
+
+	AppPings     uint64  `json:"app_pings"`      // Application-level pings answered
+	LatencyAvgMs float64 `json:"latency_avg_ms"` // Mean round trip time over connections that measured one
+	LatencyMaxMs float64 `json:"latency_max_ms"`
+
+	EventSink *KafkaStats    `json:"event_sink,omitempty"` // Set when events go to Kafka
+	Database  *DatabaseStats `json:"database,omitempty"`   // Set when messages persist to PostgreSQL
+}
+
+// Metrics returns the current server counters
+func (s *Server) Metrics() MetricsSnapshot {
+	s.mu.RLock()
+	active := len(s.connections)
+	s.mu.RUnlock()
+
+	snapshot := MetricsSnapshot{
+		ActiveConnections:  active,
+		HandlerTimeouts:    s.metrics.handlerTimeouts.Load(),
+		ReplaysRejected:    s.metrics.replaysRejected.Load(),
+		RateLimited:        s.metrics.rateLimited.Load(),
+		ExpiredDropped:     s.metrics.expiredDropped.Load(),
+		PingsSent:          s.metrics.pingsSent.Load(),
+		PongsMissed:        s.metrics.pongsMissed.Load(),
+		SessionLookups:     s.metrics.sessionLookups.Load(),
+		SessionMisses:      s.metrics.sessionMisses.Load(),
+		SessionsResumed:    s.metrics.sessionsResumed.Load(),
+		SessionStoreErrors: s.metrics.sessionStoreErrors.Load(),
+		MessageWorkers:     len(s.messageQueues),
+		QueueDepth:         s.queueDepth(),
+		QueueWaitMaxMs:     float64(s.metrics.queueWaitMax.Load()) / float64(time.Millisecond),
+
+		QueueDepthByPriority: s.queueDepthByPriority(),
+
+		ModerationFlagged:     s.metrics.moderationFlagged.Load(),
+		ModerationRejected:    s.metrics.moderationRejected.Load(),
+		ModerationQuarantined: s.metrics.moderationQuarantined.Load(),
+		SpamDetected:          s.metrics.spamDetected.Load(),
+		MutedRejected:         s.metrics.mutedRejected.Load(),
+		SlowModeRejected:      s.metrics.slowModeRejected.Load(),
+
+		DuplicatesDropped: s.metrics.duplicatesDropped.Load(),
+		OversizedRejected: s.metrics.oversizedRejected.Load(),
+		IPRejected:        s.metrics.ipRejected.Load(),
+		ChallengesIssued:  s.metrics.challengesIssued.Load(),
+		ChallengesSolved:  s.metrics.challengesSolved.Load(),
+		ChallengesFailed:  s.metrics.challengesFailed.Load(),
+		ReconnectStorms:   s.metrics.reconnectStorms.Load(),
+
+		AppPings: s.metrics.appPings.Load(),
+	}
+	avgLatency, maxLatency := s.latencySummary()
+	snapshot.LatencyAvgMs = float64(avgLatency) / float64(time.Millisecond)
+	snapshot.LatencyMaxMs = float64(maxLatency) / float64(time.Millisecond)
+	s.mu.RLock()
+	if kafka, ok := s.eventSink.(*KafkaSink); ok {
+		stats := kafka.Stats()
+		snapshot.EventSink = &stats
+	}
+	s.mu.RUnlock()
+	if globalDB != nil {
+		stats := globalDB.Stats()
+		snapshot.Database = &stats
+	}
+	if count := s.metrics.queueWaitCount.Load(); count > 0 {
+		snapshot.QueueWaitAvgMs = float64(s.metrics.queueWaitTotal.Load()) / float64(count) / float64(time.Millisecond)
+	}
+	return snapshot
+}
+
+// setupMetricsRoutes registers the metrics endpoint
+func setupMetricsRoutes(mux *http.ServeMux, server *Server) {
+	mux.HandleFunc("/api/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, server.Metrics())
+	})
+}
@@ -0,0 +1,233 @@
+package wssocket
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema migrations, named
+// <version>_<name>.up.sql and <version>_<name>.down.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the advisory lock key held while migrating, so nodes
+// starting together apply each migration once
+const migrationLock = 0x67_6f_77_73 // "gows"
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// loadMigrations reads the embedded migrations in version order. Versions
+// must run from 1 without gaps, and each needs an up and a down file.
+func loadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		file := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), ".")
+		prefix, name, hasName := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || !hasName || err != nil || version <= 0 || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: want <version>_<name>.up.sql or .down.sql", file)
+		}
+		data, err := migrationFiles.ReadFile(path.Join("migrations", file))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d needs both an up and a down file", m.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrate brings the schema up to the latest migration
+func (db *Database) Migrate() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return db.migrate(migrations, len(migrations))
+}
+
+// MigrateTo applies or rolls back migrations until the schema is at version;
+// 0 rolls back everything
+func (db *Database) MigrateTo(version int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if version < 0 || version > len(migrations) {
+		return fmt.Errorf("no migration %d; the latest is %d", version, len(migrations))
+	}
+	return db.migrate(migrations, version)
+}
+
+// SchemaVersion returns the latest applied migration, 0 for none
+func (db *Database) SchemaVersion() (int, error) {
+	if err := db.ensureSchemaVersion(); err != nil {
+		return 0, err
+	}
+	var version int
+	err := db.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// MigrationStatus lists every migration and when it was applied
+func (db *Database) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.ensureSchemaVersion(); err != nil {
+		return nil, err
+	}
+	rows, err := db.conn.Query(`SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		st := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			st.AppliedAt = &at
+		}
+		status = append(status, st)
+	}
+	return status, nil
+}
+
+// ensureSchemaVersion creates the table that records applied migrations
+func (db *Database) ensureSchemaVersion() error {
+	return db.withMigrationLock(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+		return err
+	})
+}
+
+// migrate steps the schema towards target one migration at a time. Each
+// step runs in its own transaction, so a failure leaves the schema at the
+// last version that succeeded.
+func (db *Database) migrate(migrations []Migration, target int) error {
+	if err := db.ensureSchemaVersion(); err != nil {
+		return fmt.Errorf("create schema_version: %w", err)
+	}
+	for {
+		done := false
+		err := db.withMigrationLock(func(tx *sql.Tx) error {
+			var current int
+			if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&current); err != nil {
+				return err
+			}
+			switch {
+			case current == target:
+				done = true
+				return nil
+			case current > len(migrations):
+				return fmt.Errorf("schema is at version %d, newer than this build knows (%d)", current, len(migrations))
+			case current < target:
+				m := migrations[current]
+				if _, err := tx.Exec(m.Up); err != nil {
+					return fmt.Errorf("migration %d_%s up: %w", m.Version, m.Name, err)
+				}
+				_, err := tx.Exec(`INSERT INTO schema_version (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+				log.Printf("Applied migration %d_%s", m.Version, m.Name)
+				return err
+			default:
+				m := migrations[current-1]
+				if _, err := tx.Exec(m.Down); err != nil {
+					return fmt.Errorf("migration %d_%s down: %w", m.Version, m.Name, err)
+				}
+				_, err := tx.Exec(`DELETE FROM schema_version WHERE version = $1`, m.Version)
+				log.Printf("Rolled back migration %d_%s", m.Version, m.Name)
+				return err
+			}
+		})
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// withMigrationLock runs fn in a transaction holding the migration lock
+func (db *Database) withMigrationLock(fn func(tx *sql.Tx) error) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,20 @@
+-- Drops every table, and all data with them
+DROP TABLE IF EXISTS ip_bans;
+DROP TABLE IF EXISTS channel_read_state;
+DROP TABLE IF EXISTS push_preferences;
+DROP TABLE IF EXISTS bots;
+DROP TABLE IF EXISTS mentions;
+DROP TABLE IF EXISTS device_tokens;
+DROP TABLE IF EXISTS user_channel_mutes;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS conversation_members;
+DROP TABLE IF EXISTS conversations;
+DROP TABLE IF EXISTS channel_invites;
+DROP TABLE IF EXISTS channels;
+DROP TABLE IF EXISTS channel_pins;
+DROP TABLE IF EXISTS scheduled_messages;
+DROP TABLE IF EXISTS channel_members;
+DROP TABLE IF EXISTS moderation_log;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS messages;
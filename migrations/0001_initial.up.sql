@@ -0,0 +1,229 @@
+-- Baseline schema. Every statement is idempotent, so databases created
+-- before migrations existed apply it cleanly and are then tracked like any other.
+
+CREATE TABLE IF NOT EXISTS messages (
+	id TEXT PRIMARY KEY,
+	sender TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	content TEXT NOT NULL,
+	type TEXT NOT NULL DEFAULT 'chat',
+	timestamp BIGINT NOT NULL,
+	recipient TEXT,
+	metadata JSONB,
+	expires_at BIGINT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS metadata JSONB;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS expires_at BIGINT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS conversation_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_messages_channel ON messages(channel);
+CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);
+CREATE INDEX IF NOT EXISTS idx_messages_channel_timestamp ON messages(channel, timestamp);
+CREATE INDEX IF NOT EXISTS idx_messages_channel_cursor ON messages(channel, timestamp DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_messages_recipient ON messages(recipient);
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_cursor ON messages(conversation_id, timestamp DESC, id DESC) WHERE conversation_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages(sender);
+CREATE INDEX IF NOT EXISTS idx_messages_metadata ON messages USING GIN (metadata);
+CREATE INDEX IF NOT EXISTS idx_messages_expires_at ON messages(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_content_fts ON messages USING GIN (to_tsvector('english', content));
+
+CREATE TABLE IF NOT EXISTS notifications (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	message_id TEXT NOT NULL,
+	type TEXT NOT NULL,
+	sender TEXT NOT NULL,
+	channel TEXT NOT NULL DEFAULT '',
+	payload JSONB,
+	timestamp BIGINT NOT NULL,
+	state TEXT NOT NULL DEFAULT 'unread',
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (user_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_cursor ON notifications(user_id, timestamp DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications(user_id) WHERE state = 'unread';
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	record JSONB NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
+
+CREATE TABLE IF NOT EXISTS moderation_log (
+	id TEXT PRIMARY KEY,
+	action TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	channel TEXT NOT NULL DEFAULT '',
+	reason TEXT NOT NULL DEFAULT '',
+	actor TEXT NOT NULL,
+	until BIGINT,
+	timestamp BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_moderation_log_cursor ON moderation_log(timestamp DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_moderation_log_user ON moderation_log(user_id);
+CREATE INDEX IF NOT EXISTS idx_moderation_log_channel ON moderation_log(channel);
+
+CREATE TABLE IF NOT EXISTS channel_members (
+	channel TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	PRIMARY KEY (channel, user_id)
+);
+
+ALTER TABLE channel_members ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member';
+ALTER TABLE channel_members ADD COLUMN IF NOT EXISTS updated_at BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	deliver_at BIGINT NOT NULL,
+	message JSONB NOT NULL,
+	created_at BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages(deliver_at);
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_user ON scheduled_messages(user_id, deliver_at);
+
+CREATE TABLE IF NOT EXISTS channel_pins (
+	channel TEXT NOT NULL,
+	message_id TEXT NOT NULL,
+	pinned_by TEXT NOT NULL,
+	pinned_at BIGINT NOT NULL,
+	PRIMARY KEY (channel, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS channels (
+	channel TEXT PRIMARY KEY,
+	broadcast_only BOOLEAN NOT NULL DEFAULT FALSE,
+	updated_by TEXT NOT NULL DEFAULT '',
+	updated_at BIGINT NOT NULL DEFAULT 0
+);
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS visibility TEXT NOT NULL DEFAULT 'public';
+
+CREATE TABLE IF NOT EXISTS channel_invites (
+	id TEXT PRIMARY KEY,
+	channel TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	invited_by TEXT NOT NULL,
+	status TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	expires_at BIGINT NOT NULL,
+	responded_at BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_channel_invites_user ON channel_invites(user_id, status);
+
+CREATE TABLE IF NOT EXISTS conversations (
+	id TEXT PRIMARY KEY,
+	kind TEXT NOT NULL,
+	created_at BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS conversation_members (
+	conversation_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	last_read_at BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversation_members_user ON conversation_members(user_id);
+
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS created_by TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	username TEXT UNIQUE
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_text TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS metadata JSONB;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_hash TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_token_hash ON users(token_hash) WHERE token_hash IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS user_channel_mutes (
+	user_id TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	PRIMARY KEY (user_id, channel)
+);
+
+CREATE TABLE IF NOT EXISTS device_tokens (
+	token TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	platform TEXT NOT NULL,
+	p256dh TEXT NOT NULL DEFAULT '',
+	auth TEXT NOT NULL DEFAULT '',
+	created_at BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_device_tokens_user ON device_tokens(user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS mentions (
+	user_id TEXT NOT NULL,
+	message_id TEXT NOT NULL,
+	sender TEXT NOT NULL,
+	channel TEXT NOT NULL DEFAULT '',
+	timestamp BIGINT NOT NULL,
+	PRIMARY KEY (user_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_mentions_user_time ON mentions(user_id, timestamp DESC, message_id DESC);
+
+CREATE TABLE IF NOT EXISTS bots (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	channels TEXT[] NOT NULL DEFAULT '{}',
+	direct_messages BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS push_preferences (
+	user_id TEXT PRIMARY KEY,
+	enabled BOOLEAN NOT NULL,
+	show_preview BOOLEAN NOT NULL,
+	updated_at BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS channel_read_state (
+	user_id TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	last_read_message_id TEXT NOT NULL,
+	last_read_at BIGINT NOT NULL,
+	updated_at BIGINT NOT NULL,
+	PRIMARY KEY (user_id, channel)
+);
+
+CREATE TABLE IF NOT EXISTS ip_bans (
+	ip TEXT PRIMARY KEY,
+	reason TEXT NOT NULL DEFAULT '',
+	created_at BIGINT NOT NULL,
+	expires_at BIGINT NOT NULL DEFAULT 0
+);
+
+-- File direct messages stored before conversations existed under their
+-- conversation. COLLATE "C" orders the pair like DirectConversationID.
+WITH dms AS (
+	UPDATE messages
+	SET conversation_id = 'dm:' || LEAST(sender COLLATE "C", recipient COLLATE "C") || ':' || GREATEST(sender COLLATE "C", recipient COLLATE "C")
+	WHERE recipient IS NOT NULL AND recipient <> '' AND conversation_id IS NULL
+	RETURNING conversation_id, sender, recipient, timestamp
+), created AS (
+	INSERT INTO conversations (id, kind, created_at)
+	SELECT conversation_id, 'direct', MIN(timestamp) FROM dms GROUP BY conversation_id
+	ON CONFLICT (id) DO NOTHING
+)
+INSERT INTO conversation_members (conversation_id, user_id)
+SELECT conversation_id, sender FROM dms UNION SELECT conversation_id, recipient FROM dms
+ON CONFLICT DO NOTHING;
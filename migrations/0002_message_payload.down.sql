@@ -0,0 +1,9 @@
+-- Folds payloads without text back into content as JSON, then drops the
+-- payload column; other payload fields are lost
+UPDATE messages SET content = payload::text
+WHERE content = ''
+	AND jsonb_typeof(payload) = 'object'
+	AND jsonb_typeof(payload -> 'content') IS DISTINCT FROM 'string'
+	AND jsonb_typeof(payload -> 'text') IS DISTINCT FROM 'string';
+
+ALTER TABLE messages DROP COLUMN IF EXISTS payload;
@@ -0,0 +1,28 @@
+-- Keeps each message's full payload beside its text. Until now the content
+-- column held the payload's text, or the whole payload as JSON when it had
+-- none, and reads turned it back into {"content": ...}.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS payload JSONB;
+
+-- Parses text as JSON, or returns NULL when it isn't
+CREATE FUNCTION pg_temp.gows_try_jsonb(input TEXT) RETURNS JSONB AS $$
+BEGIN
+	RETURN input::jsonb;
+EXCEPTION WHEN others THEN
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+-- Rows whose content is a JSON payload without text of its own get it back
+-- as their payload, and empty content
+UPDATE messages SET payload = parsed, content = ''
+FROM (
+	SELECT id AS parsed_id, pg_temp.gows_try_jsonb(content) AS parsed
+	FROM messages
+	WHERE payload IS NULL AND content LIKE '{%}'
+) p
+WHERE messages.id = p.parsed_id
+	AND jsonb_typeof(p.parsed) = 'object'
+	AND jsonb_typeof(p.parsed -> 'content') IS DISTINCT FROM 'string'
+	AND jsonb_typeof(p.parsed -> 'text') IS DISTINCT FROM 'string';
+
+DROP FUNCTION pg_temp.gows_try_jsonb(TEXT);
@@ -0,0 +1,7 @@
+-- Only broadcast-only survives; the other options have no column to go back to
+ALTER TABLE channels ADD COLUMN broadcast_only BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE channels SET broadcast_only = COALESCE((settings->>'broadcast_only')::BOOLEAN, FALSE);
+
+ALTER TABLE channels DROP COLUMN IF EXISTS settings;
+ALTER TABLE channels DROP COLUMN IF EXISTS description;
+ALTER TABLE channels DROP COLUMN IF EXISTS topic;
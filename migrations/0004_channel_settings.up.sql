@@ -0,0 +1,8 @@
+-- A channel's topic and description, and the options its owners set. The
+-- options share one JSONB document so adding one needs no migration.
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS topic TEXT NOT NULL DEFAULT '';
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS settings JSONB NOT NULL DEFAULT '{}';
+
+UPDATE channels SET settings = jsonb_build_object('broadcast_only', TRUE) WHERE broadcast_only;
+ALTER TABLE channels DROP COLUMN broadcast_only;
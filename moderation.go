@@ -0,0 +1,223 @@
+package wssocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MetadataModeration records a flag verdict on a delivered message
+const MetadataModeration = "moderation"
+
+// ModerationAction is what a filter decided about a message
+type ModerationAction string
+
+// Moderation actions
+const (
+	ModerationAllow  ModerationAction = "allow"
+	ModerationFlag   ModerationAction = "flag" // Deliver, marked for review
+	ModerationReject ModerationAction = "reject"
+)
+
+// ModerationVerdict is a filter's decision and why
+type ModerationVerdict struct {
+	Action ModerationAction `json:"action"`
+	Reason string           `json:"reason,omitempty"`
+}
+
+// ModerationFilter inspects a message before it is routed
+type ModerationFilter interface {
+	Moderate(ctx context.Context, conn *Connection, msg *Message) (ModerationVerdict, error)
+}
+
+// ModerationPolicy is the moderation applied to a channel
+type ModerationPolicy struct {
+	Filters  []ModerationFilter // Run in order before the message is queued; the first non-allow verdict wins
+	External ModerationFilter   // Run afterwards, off the read path; the message is quarantined until it answers
+	Timeout  time.Duration      // How long to wait for External; 5s by default
+	FailOpen bool               // Deliver when a filter errors or times out; reject otherwise
+}
+
+// WordListFilter matches whole words from a list, ignoring case
+type WordListFilter struct {
+	pattern *regexp.Regexp
+	action  ModerationAction
+}
+
+// NewWordListFilter creates a filter that takes action on messages containing any of words
+func NewWordListFilter(action ModerationAction, words ...string) *WordListFilter {
+	return &WordListFilter{pattern: ProfanityRule(words...).Pattern, action: action}
+}
+
+// Moderate checks the message text for listed words
+func (f *WordListFilter) Moderate(ctx context.Context, conn *Connection, msg *Message) (ModerationVerdict, error) {
+	if match := f.pattern.FindString(messageContent(msg)); match != "" {
+		return ModerationVerdict{Action: f.action, Reason: "blocked word"}, nil
+	}
+	return ModerationVerdict{Action: ModerationAllow}, nil
+}
+
+// HTTPModerationFilter asks an external moderation API for a verdict. It
+// POSTs {"id", "type", "sender", "channel", "content"} and expects a
+// ModerationVerdict back.
+type HTTPModerationFilter struct {
+	URL    string
+	APIKey string       // Sent as a bearer token when set
+	Client *http.Client // http.DefaultClient when nil
+}
+
+// Moderate calls the moderation API
+func (f *HTTPModerationFilter) Moderate(ctx context.Context, conn *Connection, msg *Message) (ModerationVerdict, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"id":      msg.ID,
+		"type":    msg.Type,
+		"sender":  msg.Sender,
+		"channel": msg.Channel,
+		"content": messageContent(msg),
+	})
+	if err != nil {
+		return ModerationVerdict{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.URL, bytes.NewReader(body))
+	if err != nil {
+		return ModerationVerdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if f.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+f.APIKey)
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return ModerationVerdict{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ModerationVerdict{}, fmt.Errorf("moderation API returned %s", resp.Status)
+	}
+
+	var verdict ModerationVerdict
+	if err := json.NewDecoder(resp.Body).Decode(&verdict); err != nil {
+		return ModerationVerdict{}, fmt.Errorf("decode moderation verdict: %w", err)
+	}
+	switch verdict.Action {
+	case ModerationAllow, ModerationFlag, ModerationReject:
+		return verdict, nil
+	default:
+		return ModerationVerdict{}, fmt.Errorf("unknown moderation action: %q", verdict.Action)
+	}
+}
+
+// SetModeration enables moderation for a channel. A pattern ending in "*"
+// applies to every channel with that prefix, and "*" to every channel and
+// direct message. Exact names win, then the longest prefix. A nil policy
+// removes the entry.
+func (s *Server) SetModeration(pattern string, policy *ModerationPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if policy == nil {
+		delete(s.moderation, pattern)
+		return
+	}
+	if s.moderation == nil {
+		s.moderation = make(map[string]*ModerationPolicy)
+	}
+	s.moderation[pattern] = policy
+}
+
+// moderationFor resolves the policy for a channel; the caller holds s.mu
+func (s *Server) moderationFor(channel string) *ModerationPolicy {
+	if policy, exists := s.moderation[channel]; exists {
+		return policy
+	}
+	best := -1
+	var policy *ModerationPolicy
+	for pattern, p := range s.moderation {
+		prefix, isPrefix := strings.CutSuffix(pattern, "*")
+		if isPrefix && strings.HasPrefix(channel, prefix) && len(prefix) > best {
+			best, policy = len(prefix), p
+		}
+	}
+	return policy
+}
+
+// runModeration applies filters in order and returns the first verdict that
+// isn't allow. A filter error becomes allow or reject depending on FailOpen.
+func runModeration(ctx context.Context, conn *Connection, msg *Message, filters []ModerationFilter, failOpen bool) ModerationVerdict {
+	for _, filter := range filters {
+		verdict, err := filter.Moderate(ctx, conn, msg)
+		if err != nil {
+			log.Printf("moderation of message %s failed: %v", msg.ID, err)
+			if failOpen {
+				continue
+			}
+			return ModerationVerdict{Action: ModerationReject, Reason: "moderation unavailable"}
+		}
+		if verdict.Action != ModerationAllow {
+			return verdict
+		}
+	}
+	return ModerationVerdict{Action: ModerationAllow}
+}
+
+// applyVerdict flags or rejects a message. It returns an error if the
+// message must not be delivered.
+func (s *Server) applyVerdict(conn *Connection, msg *Message, verdict ModerationVerdict) error {
+	switch verdict.Action {
+	case ModerationFlag:
+		s.metrics.moderationFlagged.Add(1)
+		if msg.Metadata == nil {
+			msg.Metadata = make(map[string]interface{})
+		}
+		msg.Metadata[MetadataModeration] = verdict
+	case ModerationReject:
+		s.metrics.moderationRejected.Add(1)
+		err := fmt.Errorf("message %s rejected by moderation: %s", msg.ID, verdict.Reason)
+		s.SendToConnection(conn.ID, &Message{
+			ID:        generateMessageID(),
+			Type:      MessageTypeError,
+			Sender:    "system",
+			Recipient: conn.UserID,
+			Timestamp: s.now().Unix(),
+			Payload: map[string]interface{}{
+				"code":       "moderation_rejected",
+				"error":      verdict.Reason,
+				"message_id": msg.ID,
+			},
+		})
+		return err
+	}
+	return nil
+}
+
+// releaseAfterModeration waits for the external verdict on a quarantined
+// message, then queues or rejects it
+func (s *Server) releaseAfterModeration(conn *Connection, inMsg *internalMessage, policy *ModerationPolicy, release func()) {
+	timeout := policy.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	verdict := runModeration(ctx, conn, inMsg.msg, []ModerationFilter{policy.External}, policy.FailOpen)
+	s.metrics.moderationQuarantined.Add(-1)
+	if err := s.applyVerdict(conn, inMsg.msg, verdict); err != nil {
+		if inMsg.audit != nil {
+			inMsg.audit.record(conn, inMsg.msg, AuditOutcomeRejected, "", err, inMsg.queuedAt, inMsg.queuedAt)
+		}
+		log.Printf("%v", err)
+		return
+	}
+	release()
+}
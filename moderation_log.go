@@ -0,0 +1,208 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Moderation log actions
+const (
+	ModerationLogMute   = "mute"
+	ModerationLogUnmute = "unmute"
+	ModerationLogKick   = "kick"
+	ModerationLogRole   = "role"
+)
+
+// ModerationLogEntry records one moderation action against a user
+type ModerationLogEntry struct {
+	ID        string `json:"id"`
+	Action    string `json:"action"`
+	UserID    string `json:"user_id"`
+	Channel   string `json:"channel"`
+	Reason    string `json:"reason,omitempty"`
+	Actor     string `json:"actor"`           // Who took the action; "system" for automatic ones
+	Until     int64  `json:"until,omitempty"` // Unix time a mute ends
+	Timestamp int64  `json:"timestamp"`
+}
+
+// payload is the entry as the body of a moderation event
+func (e *ModerationLogEntry) payload() map[string]interface{} {
+	payload := map[string]interface{}{
+		"id":        e.ID,
+		"action":    e.Action,
+		"user_id":   e.UserID,
+		"channel":   e.Channel,
+		"actor":     e.Actor,
+		"timestamp": e.Timestamp,
+	}
+	if e.Reason != "" {
+		payload["reason"] = e.Reason
+	}
+	if e.Until != 0 {
+		payload["until"] = e.Until
+	}
+	return payload
+}
+
+// ModerationLogQuery selects a page of moderation actions, newest first.
+// Empty fields match everything.
+type ModerationLogQuery struct {
+	UserID  string
+	Channel string
+	Page    Page
+}
+
+// ModerationLog persists moderation actions
+type ModerationLog interface {
+	RecordModerationAction(entry *ModerationLogEntry) error
+	ListModerationActions(q ModerationLogQuery) ([]*ModerationLogEntry, error)
+}
+
+// globalModerationLog is where moderation actions are recorded (set during init)
+var globalModerationLog ModerationLog
+
+// InMemoryModerationLog keeps moderation actions in memory
+type InMemoryModerationLog struct {
+	mu      sync.RWMutex
+	entries []*ModerationLogEntry // In the order recorded
+}
+
+// NewInMemoryModerationLog creates an empty in-memory moderation log
+func NewInMemoryModerationLog() *InMemoryModerationLog {
+	return &InMemoryModerationLog{}
+}
+
+// RecordModerationAction appends an entry
+func (l *InMemoryModerationLog) RecordModerationAction(entry *ModerationLogEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stored := *entry
+	l.entries = append(l.entries, &stored)
+	return nil
+}
+
+// ListModerationActions returns a page of matching entries, newest first
+func (l *InMemoryModerationLog) ListModerationActions(q ModerationLogQuery) ([]*ModerationLogEntry, error) {
+	page := q.Page.normalize()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	results := make([]*ModerationLogEntry, 0)
+	for i := len(l.entries) - 1; i >= 0 && len(results) < page.Limit; i-- {
+		e := l.entries[i]
+		if page.Before != nil && !(e.Timestamp < page.Before.Timestamp ||
+			e.Timestamp == page.Before.Timestamp && e.ID < page.Before.ID) {
+			continue
+		}
+		if q.UserID != "" && e.UserID != q.UserID || q.Channel != "" && e.Channel != q.Channel {
+			continue
+		}
+		copied := *e
+		results = append(results, &copied)
+	}
+	return results, nil
+}
+
+// setupModerationAdminRoutes registers the mute and moderation log endpoints
+func setupModerationAdminRoutes(mux *http.ServeMux, s *Server, apiKeys []string) {
+	// GET lists moderation actions, newest first, optionally for one user or channel
+	mux.HandleFunc("/api/admin/moderation/log", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if globalModerationLog == nil {
+			http.Error(w, "Moderation log not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		page, err := parseCursorPage(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries, err := globalModerationLog.ListModerationActions(ModerationLogQuery{
+			UserID:  r.URL.Query().Get("user_id"),
+			Channel: r.URL.Query().Get("channel"),
+			Page:    page,
+		})
+		if err != nil {
+			log.Printf("Error listing moderation actions: %v", err)
+			http.Error(w, "Failed to list moderation actions", http.StatusInternalServerError)
+			return
+		}
+
+		hasMore := len(entries) == page.Limit
+		var nextCursor string
+		if hasMore {
+			last := entries[len(entries)-1]
+			nextCursor = (&Cursor{Timestamp: last.Timestamp, ID: last.ID}).String()
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"entries":     entries,
+			"count":       len(entries),
+			"limit":       page.Limit,
+			"has_more":    hasMore,
+			"next_cursor": nextCursor,
+		})
+	})
+
+	// POST mutes a user in a channel; DELETE lifts the mute
+	mux.HandleFunc("/api/admin/moderation/mute", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			UserID   string `json:"user_id"`
+			Channel  string `json:"channel"`
+			Duration string `json:"duration"`
+			Reason   string `json:"reason"`
+			Actor    string `json:"actor"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.UserID == "" {
+			http.Error(w, "user_id is required", http.StatusBadRequest)
+			return
+		}
+		if req.Actor == "" {
+			req.Actor = "admin"
+		}
+
+		if r.Method == http.MethodDelete {
+			unmuted, err := s.Unmute(req.UserID, req.Channel, req.Actor)
+			if err != nil {
+				log.Printf("Error unmuting %s: %v", req.UserID, err)
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"unmuted": unmuted})
+			return
+		}
+
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			http.Error(w, "duration must be a positive Go duration such as 30m", http.StatusBadRequest)
+			return
+		}
+		if err := s.Mute(req.UserID, req.Channel, d, req.Reason, req.Actor); err != nil {
+			log.Printf("Error muting %s: %v", req.UserID, err)
+		}
+		until, _ := s.MutedUntil(req.UserID, req.Channel)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"muted_until": until.Unix()})
+	})
+}
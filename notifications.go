@@ -0,0 +1,342 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// NotificationState tracks what a user has done with a notification
+type NotificationState string
+
+const (
+	NotificationUnread    NotificationState = "unread"
+	NotificationRead      NotificationState = "read"
+	NotificationDismissed NotificationState = "dismissed"
+)
+
+// Notification is a notification or alert message kept for one recipient
+type Notification struct {
+	ID        string                 `json:"id"`
+	UserID    string                 `json:"user_id"`
+	MessageID string                 `json:"message_id"`
+	Type      MessageType            `json:"type"`
+	Sender    string                 `json:"sender"`
+	Channel   string                 `json:"channel,omitempty"`
+	Payload   map[string]interface{} `json:"payload"`
+	Timestamp int64                  `json:"timestamp"`
+	State     NotificationState      `json:"state"`
+}
+
+// NotificationQuery selects a page of a user's notifications, newest first
+type NotificationQuery struct {
+	UserID string
+	State  NotificationState // Empty lists unread and read, but not dismissed
+	Page   Page
+}
+
+// NotificationStore persists per-recipient notifications
+type NotificationStore interface {
+	// SaveNotifications stores notifications, ignoring any the user already has for the same message
+	SaveNotifications(notifications []*Notification) error
+	ListNotifications(q NotificationQuery) ([]*Notification, error)
+	// UpdateNotifications moves a user's notifications to state; no IDs means all of them.
+	// Marking read only affects unread notifications, and dismissed ones stay dismissed.
+	UpdateNotifications(userID string, ids []string, state NotificationState) (int, error)
+	CountUnread(userID string) (int, error)
+}
+
+// globalNotifications is where notification and alert messages are kept (set during init)
+var globalNotifications NotificationStore
+
+// notificationRecipients returns who a notification is kept for: the
+// recipient, or the other users following its channel when it is sent to one
+func (s *Server) notificationRecipients(msg *Message) []string {
+	if msg.Recipient != "" {
+		return []string{msg.Recipient}
+	}
+	if msg.Channel == "" {
+		return nil
+	}
+
+	recipients := make([]string, 0)
+	for _, userID := range s.GetActiveUsersInChannel(msg.Channel) {
+		if userID != msg.Sender {
+			recipients = append(recipients, userID)
+		}
+	}
+	return recipients
+}
+
+// RecordNotification saves a notification or alert for each of its recipients
+func RecordNotification(store NotificationStore, recipients []string, msg *Message) error {
+	if store == nil || len(recipients) == 0 {
+		return nil
+	}
+
+	notifications := make([]*Notification, 0, len(recipients))
+	for _, userID := range recipients {
+		notifications = append(notifications, &Notification{
+			ID:        "ntf_" + uuid.New().String(),
+			UserID:    userID,
+			MessageID: msg.ID,
+			Type:      msg.Type,
+			Sender:    msg.Sender,
+			Channel:   msg.Channel,
+			Payload:   msg.Payload,
+			Timestamp: msg.Timestamp,
+			State:     NotificationUnread,
+		})
+	}
+	if err := store.SaveNotifications(notifications); err != nil {
+		return fmt.Errorf("save notifications for %s: %w", msg.ID, err)
+	}
+	return nil
+}
+
+// notificationCursor returns the cursor positioned at n
+func notificationCursor(n *Notification) *Cursor {
+	return &Cursor{Timestamp: n.Timestamp, ID: n.ID}
+}
+
+// InMemoryNotificationStore keeps notifications in memory
+type InMemoryNotificationStore struct {
+	mu     sync.RWMutex
+	byUser map[string][]*Notification // Sorted by timestamp then ID
+}
+
+// NewInMemoryNotificationStore creates an empty in-memory notification store
+func NewInMemoryNotificationStore() *InMemoryNotificationStore {
+	return &InMemoryNotificationStore{byUser: make(map[string][]*Notification)}
+}
+
+// SaveNotifications stores notifications, skipping duplicates per user and message
+func (s *InMemoryNotificationStore) SaveNotifications(notifications []*Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, n := range notifications {
+		list := s.byUser[n.UserID]
+		duplicate := false
+		for _, existing := range list {
+			if existing.MessageID == n.MessageID {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+
+		stored := *n
+		i := sort.Search(len(list), func(i int) bool {
+			if list[i].Timestamp != n.Timestamp {
+				return list[i].Timestamp > n.Timestamp
+			}
+			return list[i].ID > n.ID
+		})
+		list = append(list, nil)
+		copy(list[i+1:], list[i:])
+		list[i] = &stored
+		s.byUser[n.UserID] = list
+	}
+	return nil
+}
+
+// ListNotifications returns a page of a user's notifications, newest first
+func (s *InMemoryNotificationStore) ListNotifications(q NotificationQuery) ([]*Notification, error) {
+	page := q.Page.normalize()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := s.byUser[q.UserID]
+	results := make([]*Notification, 0)
+	for i := len(list) - 1; i >= 0 && len(results) < page.Limit; i-- {
+		n := list[i]
+		if page.Before != nil && !(n.Timestamp < page.Before.Timestamp ||
+			n.Timestamp == page.Before.Timestamp && n.ID < page.Before.ID) {
+			continue
+		}
+		if q.State == "" && n.State == NotificationDismissed || q.State != "" && n.State != q.State {
+			continue
+		}
+		copied := *n
+		results = append(results, &copied)
+	}
+	return results, nil
+}
+
+// UpdateNotifications changes the state of a user's notifications
+func (s *InMemoryNotificationStore) UpdateNotifications(userID string, ids []string, state NotificationState) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := 0
+	for _, n := range s.byUser[userID] {
+		if len(ids) > 0 && !containsString(ids, n.ID) {
+			continue
+		}
+		if !canMoveNotification(n.State, state) {
+			continue
+		}
+		n.State = state
+		updated++
+	}
+	return updated, nil
+}
+
+// CountUnread returns how many unread notifications a user has
+func (s *InMemoryNotificationStore) CountUnread(userID string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, n := range s.byUser[userID] {
+		if n.State == NotificationUnread {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// canMoveNotification reports whether a notification may change between states
+func canMoveNotification(from, to NotificationState) bool {
+	switch to {
+	case NotificationRead:
+		return from == NotificationUnread
+	case NotificationDismissed:
+		return from != NotificationDismissed
+	}
+	return false
+}
+
+// parseNotificationState validates a state from a request; empty is allowed
+func parseNotificationState(s string) (NotificationState, error) {
+	switch state := NotificationState(s); state {
+	case "", NotificationUnread, NotificationRead, NotificationDismissed:
+		return state, nil
+	default:
+		return "", fmt.Errorf("unknown notification state: %s", s)
+	}
+}
+
+// setupNotificationRoutes registers the notification center endpoints
+func setupNotificationRoutes(mux *http.ServeMux) {
+	// GET lists a user's notifications, newest first. Both routes need the
+	// user's token if they are registered.
+	mux.HandleFunc("/api/notifications", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if globalNotifications == nil {
+			http.Error(w, "Notification store not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+		state, err := parseNotificationState(r.URL.Query().Get("state"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page, err := parseCursorPage(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		notifications, err := globalNotifications.ListNotifications(NotificationQuery{UserID: userID, State: state, Page: page})
+		if err != nil {
+			log.Printf("Error listing notifications: %v", err)
+			http.Error(w, "Failed to list notifications", http.StatusInternalServerError)
+			return
+		}
+		unread, err := globalNotifications.CountUnread(userID)
+		if err != nil {
+			log.Printf("Error counting unread notifications: %v", err)
+			http.Error(w, "Failed to list notifications", http.StatusInternalServerError)
+			return
+		}
+
+		hasMore := len(notifications) == page.Limit
+		var nextCursor string
+		if hasMore {
+			nextCursor = notificationCursor(notifications[len(notifications)-1]).String()
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"notifications": notifications,
+			"count":         len(notifications),
+			"unread":        unread,
+			"limit":         page.Limit,
+			"has_more":      hasMore,
+			"next_cursor":   nextCursor,
+		})
+	})
+
+	// POST /api/notifications/read and /api/notifications/dismiss change state.
+	// The body names the user and, optionally, which notifications; none means all.
+	mux.HandleFunc("/api/notifications/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if globalNotifications == nil {
+			http.Error(w, "Notification store not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		var state NotificationState
+		switch strings.TrimPrefix(r.URL.Path, "/api/notifications/") {
+		case "read":
+			state = NotificationRead
+		case "dismiss":
+			state = NotificationDismissed
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		var req struct {
+			UserID string   `json:"user_id"`
+			IDs    []string `json:"ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		userID, ok := requestUser(w, r, req.UserID)
+		if !ok {
+			return
+		}
+
+		updated, err := globalNotifications.UpdateNotifications(userID, req.IDs, state)
+		if err != nil {
+			log.Printf("Error updating notifications: %v", err)
+			http.Error(w, "Failed to update notifications", http.StatusInternalServerError)
+			return
+		}
+		unread, err := globalNotifications.CountUnread(userID)
+		if err != nil {
+			log.Printf("Error counting unread notifications: %v", err)
+			http.Error(w, "Failed to update notifications", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"state":   state,
+			"updated": updated,
+			"unread":  unread,
+		})
+	})
+}
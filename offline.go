@@ -0,0 +1,50 @@
+package wssocket
+
+import "errors"
+
+// MessageTypeRecipientOffline tells a sender their direct message reached nobody
+const MessageTypeRecipientOffline MessageType = "recipient_offline"
+
+// ErrRecipientOffline is returned when a user has no live connections
+var ErrRecipientOffline = errors.New("recipient is offline")
+
+// RegisterOfflineQueueHook registers a hook that is offered direct messages
+// for offline users. It returns true if it kept the message for later
+// delivery; otherwise the sender is told the recipient is offline. Messages
+// with a ttl are never offered.
+func (s *Server) RegisterOfflineQueueHook(fn func(*Message) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offlineQueueHook = fn
+}
+
+// deliverDirect sends a direct message and, when the recipient is offline and
+// nothing queued it, sends the sender a recipient_offline status. The
+// sender's other devices are told about the message either way.
+func (s *Server) deliverDirect(conn *Connection, msg *Message) error {
+	s.syncSent(conn, msg)
+	err := s.sendToUser(msg.Recipient, msg)
+	if !errors.Is(err, ErrRecipientOffline) {
+		return err
+	}
+
+	s.mu.RLock()
+	hook := s.offlineQueueHook
+	s.mu.RUnlock()
+	// A message with a ttl is only worth delivering now
+	if hook != nil && !isEphemeral(msg) && hook(msg) {
+		return nil
+	}
+
+	return s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeRecipientOffline,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"message_id": msg.ID,
+			"recipient":  msg.Recipient,
+		},
+	})
+}
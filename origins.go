@@ -0,0 +1,73 @@
+package wssocket
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SetAllowedOrigins restricts which browser origins may open connections,
+// e.g. "https://app.example.com" or "*.example.com". No origins allows all.
+// Existing connections are not affected.
+func (s *Server) SetAllowedOrigins(origins []string) {
+	cleaned := make([]string, 0, len(origins))
+	for _, origin := range origins {
+		if origin = strings.ToLower(strings.TrimSpace(origin)); origin != "" {
+			cleaned = append(cleaned, origin)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allowedOrigins = cleaned
+}
+
+// AllowedOrigins returns the origin allow-list; empty means all origins
+func (s *Server) AllowedOrigins() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.allowedOrigins...)
+}
+
+// OriginAllowed reports whether a browser origin is allowed. Requests without
+// an Origin header come from non-browser clients and are always allowed.
+func (s *Server) OriginAllowed(origin string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if origin == "" || len(s.allowedOrigins) == 0 {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	host := origin
+	if _, rest, ok := strings.Cut(origin, "://"); ok {
+		host = rest
+	}
+	for _, allowed := range s.allowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		// "*.example.com" matches any subdomain, on any scheme
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin is the upgrader's origin check
+func (s *Server) checkOrigin(r *http.Request) bool {
+	return s.OriginAllowed(r.Header.Get("Origin"))
+}
+
+// setCORSOrigin sets Access-Control-Allow-Origin for a request: "*" without
+// an allow-list, the request's own origin when it is allowed, nothing otherwise
+func (s *Server) setCORSOrigin(w http.ResponseWriter, r *http.Request) {
+	if len(s.AllowedOrigins()) == 0 {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Add("Vary", "Origin")
+	if origin := r.Header.Get("Origin"); origin != "" && s.OriginAllowed(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
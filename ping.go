@@ -0,0 +1,147 @@
+package wssocket
+
+import (
+	"sync"
+	"time"
+)
+
+// AdaptivePingConfig lets each connection's ping interval move between
+// bounds: it doubles after a run of prompt pongs and halves when a pong is
+// missed, so stable idle connections wake the server less often and flaky
+// ones are found dead sooner.
+type AdaptivePingConfig struct {
+	Enabled     bool
+	MinInterval time.Duration // Floor for flaky connections; PingInterval/4 by default
+	MaxInterval time.Duration // Ceiling for stable connections; PingInterval*4 by default
+	StablePongs int           // Prompt pongs in a row before the interval doubles; 3 by default
+	SlowRTT     time.Duration // A pong slower than this doesn't count as prompt; 1s by default
+}
+
+// withDefaults fills in unset bounds relative to the base ping interval
+func (c AdaptivePingConfig) withDefaults(base time.Duration) AdaptivePingConfig {
+	if c.MinInterval <= 0 {
+		c.MinInterval = base / 4
+	}
+	if c.MaxInterval <= 0 {
+		c.MaxInterval = base * 4
+	}
+	if c.MaxInterval < c.MinInterval {
+		c.MaxInterval = c.MinInterval
+	}
+	if c.StablePongs <= 0 {
+		c.StablePongs = 3
+	}
+	if c.SlowRTT <= 0 {
+		c.SlowRTT = time.Second
+	}
+	return c
+}
+
+// pingState tracks a connection's keepalive pings
+type pingState struct {
+	mu       sync.Mutex
+	policy   AdaptivePingConfig // Disabled keeps the interval fixed
+	interval time.Duration
+	lastPing time.Time
+	awaiting bool          // A ping is out without a pong yet
+	rtt      time.Duration // Smoothed round trip time
+	streak   int           // Prompt pongs since the interval last changed
+}
+
+// reset starts the connection at the base interval
+func (p *pingState) reset(interval time.Duration, policy AdaptivePingConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.policy = policy
+	p.interval = interval
+}
+
+// current returns the interval until the next ping
+func (p *pingState) current() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.interval
+}
+
+// due reports whether a ping should go out at now. slack absorbs the jitter
+// of a poller that checks every slack*2.
+func (p *pingState) due(now time.Time, slack time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !now.Add(slack).Before(p.lastPing.Add(p.interval))
+}
+
+// sent records a ping going out. It reports whether the previous ping went
+// unanswered, which shortens the interval.
+func (p *pingState) sent(now time.Time) (missed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	missed = p.awaiting
+	if missed && p.policy.Enabled {
+		p.interval = max(p.interval/2, p.policy.MinInterval)
+		p.streak = 0
+	}
+	p.lastPing, p.awaiting = now, true
+	return missed
+}
+
+// ponged records the answer to the outstanding ping
+func (p *pingState) ponged(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.awaiting {
+		return
+	}
+	p.awaiting = false
+	rtt := now.Sub(p.lastPing)
+	if p.rtt == 0 {
+		p.rtt = rtt
+	} else {
+		p.rtt = (p.rtt*7 + rtt) / 8
+	}
+
+	if !p.policy.Enabled {
+		return
+	}
+	if rtt > p.policy.SlowRTT {
+		p.streak = 0
+		return
+	}
+	if p.streak++; p.streak >= p.policy.StablePongs {
+		p.interval = min(p.interval*2, p.policy.MaxInterval)
+		p.streak = 0
+	}
+}
+
+// PingInterval returns the connection's current keepalive interval
+func (c *Connection) PingInterval() time.Duration {
+	return c.ping.current()
+}
+
+// RTT returns the smoothed ping round trip time; 0 before the first pong
+func (c *Connection) RTT() time.Duration {
+	c.ping.mu.Lock()
+	defer c.ping.mu.Unlock()
+	return c.ping.rtt
+}
+
+// pongTimeout is how long a connection may stay silent past its ping interval
+func (s *Server) pongTimeout() time.Duration {
+	if timeout := s.config.PongWait - s.config.PingInterval; timeout > 0 {
+		return timeout
+	}
+	return s.config.PongWait
+}
+
+// readDeadline is when a silent connection is considered dead
+func (s *Server) readDeadline(conn *Connection) time.Time {
+	return time.Now().Add(conn.ping.current() + s.pongTimeout())
+}
+
+// pingSent records a keepalive ping and counts a missed pong
+func (s *Server) pingSent(conn *Connection) {
+	s.metrics.pingsSent.Add(1)
+	if conn.ping.sent(time.Now()) {
+		s.metrics.pongsMissed.Add(1)
+	}
+}
@@ -0,0 +1,61 @@
+package wssocket
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// preparedFrame encodes a fanned-out message once per codec and shares the
+// WebSocket frame between every connection it is written to
+type preparedFrame struct {
+	text   preparedEncoding
+	binary preparedEncoding
+}
+
+// preparedEncoding is a fanned-out message's frame in one codec
+type preparedEncoding struct {
+	once sync.Once
+	pm   *websocket.PreparedMessage
+	err  error
+}
+
+// forFanout returns a copy of msg that carries a shared prepared frame. The
+// copy is sent instead of msg so writers never race with later changes to
+// msg's frame.
+func forFanout(msg *Message, recipients int) *Message {
+	if recipients < 2 {
+		return msg
+	}
+	shared := *msg
+	shared.frame = &preparedFrame{}
+	return &shared
+}
+
+// writeMessage writes msg in the codec the client negotiated, a JSON text
+// frame by default, reusing its prepared frame if it has one
+func writeMessage(ws *websocket.Conn, msg *Message) error {
+	codec := codecFor(ws)
+	if msg.frame == nil {
+		data, err := codec.marshal(msg)
+		if err != nil {
+			return err
+		}
+		return ws.WriteMessage(codec.frameType(), data)
+	}
+
+	f := &msg.frame.text
+	if codec.frameType() == websocket.BinaryMessage {
+		f = &msg.frame.binary
+	}
+	f.once.Do(func() {
+		var data []byte
+		if data, f.err = codec.marshal(msg); f.err == nil {
+			f.pm, f.err = websocket.NewPreparedMessage(codec.frameType(), data)
+		}
+	})
+	if f.err != nil {
+		return f.err
+	}
+	return ws.WritePreparedMessage(f.pm)
+}
@@ -0,0 +1,460 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Profile field limits
+const (
+	MaxDisplayNameLength = 64
+	MaxStatusTextLength  = 140
+	MaxAvatarURLLength   = 2048
+	MaxProfileMetadata   = 4 << 10 // Bytes of JSON
+	MaxProfileLookup     = 100     // User IDs per batch lookup
+)
+
+// UserProfile is what other users see about someone
+type UserProfile struct {
+	UserID      string                 `json:"user_id"`
+	Username    string                 `json:"username,omitempty"`
+	DisplayName string                 `json:"display_name"`
+	AvatarURL   string                 `json:"avatar_url"`
+	StatusText  string                 `json:"status_text"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	UpdatedAt   int64                  `json:"updated_at,omitempty"`
+}
+
+// defaultUserProfile returns the profile of a user who hasn't set one
+func defaultUserProfile(userID string) *UserProfile {
+	return &UserProfile{UserID: userID}
+}
+
+// ProfileUpdate changes some of a profile's fields; nil fields keep their
+// values. A non-nil Metadata replaces the whole map.
+type ProfileUpdate struct {
+	DisplayName *string                 `json:"display_name"`
+	AvatarURL   *string                 `json:"avatar_url"`
+	StatusText  *string                 `json:"status_text"`
+	Metadata    *map[string]interface{} `json:"metadata"`
+}
+
+// apply validates the update and copies it onto a profile
+func (u ProfileUpdate) apply(p *UserProfile) error {
+	if u.DisplayName != nil {
+		name := strings.TrimSpace(*u.DisplayName)
+		if utf8.RuneCountInString(name) > MaxDisplayNameLength {
+			return fmt.Errorf("display_name is longer than %d characters", MaxDisplayNameLength)
+		}
+		p.DisplayName = name
+	}
+	if u.AvatarURL != nil {
+		if err := validateAvatarURL(*u.AvatarURL); err != nil {
+			return err
+		}
+		p.AvatarURL = *u.AvatarURL
+	}
+	if u.StatusText != nil {
+		if utf8.RuneCountInString(*u.StatusText) > MaxStatusTextLength {
+			return fmt.Errorf("status_text is longer than %d characters", MaxStatusTextLength)
+		}
+		p.StatusText = *u.StatusText
+	}
+	if u.Metadata != nil {
+		data, err := json.Marshal(*u.Metadata)
+		if err != nil {
+			return fmt.Errorf("invalid metadata: %w", err)
+		}
+		if len(data) > MaxProfileMetadata {
+			return fmt.Errorf("metadata is larger than %d bytes", MaxProfileMetadata)
+		}
+		p.Metadata = *u.Metadata
+	}
+	return nil
+}
+
+// validateAvatarURL accepts an empty URL or an absolute http(s) one
+func validateAvatarURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	if len(raw) > MaxAvatarURLLength {
+		return fmt.Errorf("avatar_url is longer than %d bytes", MaxAvatarURLLength)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("avatar_url must be an http or https URL")
+	}
+	return nil
+}
+
+// profileUpdateFromPayload reads the fields a user:update message sets
+func profileUpdateFromPayload(payload map[string]interface{}) (ProfileUpdate, error) {
+	var update ProfileUpdate
+	for field, dst := range map[string]**string{
+		"display_name": &update.DisplayName,
+		"avatar_url":   &update.AvatarURL,
+		"status_text":  &update.StatusText,
+	} {
+		raw, present := payload[field]
+		if !present {
+			continue
+		}
+		s, ok := raw.(string)
+		if !ok {
+			return update, fmt.Errorf("%s must be a string", field)
+		}
+		*dst = &s
+	}
+	if raw, present := payload["metadata"]; present {
+		m, ok := raw.(map[string]interface{})
+		if !ok && raw != nil {
+			return update, fmt.Errorf("metadata must be an object")
+		}
+		update.Metadata = &m
+	}
+	return update, nil
+}
+
+// UserStore keeps user profiles
+type UserStore interface {
+	// GetUserProfile returns a user's profile, or nil when there is none
+	GetUserProfile(userID string) (*UserProfile, error)
+	// GetUserProfiles returns the stored profiles among userIDs
+	GetUserProfiles(userIDs []string) ([]*UserProfile, error)
+	// SaveUserProfile inserts or replaces a profile's editable fields
+	SaveUserProfile(profile *UserProfile) error
+	// ClaimUsername records a username and token hash for a user without
+	// one. It returns ErrUsernameTaken or ErrUserClaimed on a collision,
+	// and ErrUserClaimed for a bot's ID or an erased user.
+	ClaimUsername(userID, username, tokenHash string) error
+	// UserTokenHash returns a registered user's token hash, "" when unclaimed
+	UserTokenHash(userID string) (string, error)
+	// UserIDForTokenHash returns the user a token was issued to, "" when none
+	UserIDForTokenHash(tokenHash string) (string, error)
+	// UserIDForUsername returns who holds a username, "" when it is free
+	UserIDForUsername(username string) (string, error)
+	// SetChannelMuted records whether a user muted a channel's notifications
+	SetChannelMuted(userID, channel string, muted bool) error
+	// MutedChannels returns the channels a user muted, sorted
+	MutedChannels(userID string) ([]string, error)
+	// SaveDeviceToken registers a device for push notifications, taking it
+	// from any other user and dropping the user's oldest beyond
+	// MaxDeviceTokens
+	SaveDeviceToken(device *DeviceToken) error
+	// DeleteDeviceToken unregisters one of a user's devices
+	DeleteDeviceToken(userID, token string) error
+	// DeviceTokens returns a user's devices, oldest first
+	DeviceTokens(userID string) ([]*DeviceToken, error)
+	// PushPreferences returns a user's notification settings, or the defaults
+	PushPreferences(userID string) (*PushPreferences, error)
+	// SavePushPreferences replaces a user's notification settings
+	SavePushPreferences(prefs *PushPreferences) error
+	// SaveMentions records mentions, ignoring any already recorded
+	SaveMentions(mentions []*Mention) error
+	// ListMentions returns a page of a user's mentions, newest first
+	ListMentions(userID string, page Page) ([]*Mention, error)
+	// SaveBot stores or replaces a bot
+	SaveBot(bot *Bot) error
+	// DeleteBot removes a bot
+	DeleteBot(id string) error
+	// ListBots returns every bot, oldest first
+	ListBots() ([]*Bot, error)
+}
+
+// globalUserStore is where user profiles are kept (set during init)
+var globalUserStore UserStore
+
+// InMemoryUserStore keeps user profiles and username claims in memory
+type InMemoryUserStore struct {
+	mu          sync.RWMutex
+	profiles    map[string]*UserProfile
+	usernames   map[string]string // username -> user
+	tokens      map[string]string // token hash -> user
+	tokenHashes map[string]string // user -> token hash
+
+	mutedChannels map[string]map[string]bool // user -> muted channels
+	devices       map[string][]*DeviceToken  // user -> devices, oldest first
+	pushPrefs     map[string]*PushPreferences
+	mentions      map[string][]*Mention // user -> mentions, oldest first
+	bots          map[string]*Bot
+	erased        map[string]bool // Erased users, whose IDs can't be registered
+}
+
+// NewInMemoryUserStore creates an empty in-memory user store
+func NewInMemoryUserStore() *InMemoryUserStore {
+	return &InMemoryUserStore{
+		profiles:    make(map[string]*UserProfile),
+		usernames:   make(map[string]string),
+		tokens:      make(map[string]string),
+		tokenHashes: make(map[string]string),
+
+		mutedChannels: make(map[string]map[string]bool),
+		devices:       make(map[string][]*DeviceToken),
+		pushPrefs:     make(map[string]*PushPreferences),
+		mentions:      make(map[string][]*Mention),
+		bots:          make(map[string]*Bot),
+		erased:        make(map[string]bool),
+	}
+}
+
+// GetUserProfile returns a copy of a user's profile, or nil when there is none
+func (s *InMemoryUserStore) GetUserProfile(userID string) (*UserProfile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, exists := s.profiles[userID]
+	if !exists {
+		return nil, nil
+	}
+	copied := *p
+	return &copied, nil
+}
+
+// GetUserProfiles returns copies of the stored profiles among userIDs
+func (s *InMemoryUserStore) GetUserProfiles(userIDs []string) ([]*UserProfile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	profiles := make([]*UserProfile, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if p, exists := s.profiles[userID]; exists {
+			copied := *p
+			profiles = append(profiles, &copied)
+		}
+	}
+	return profiles, nil
+}
+
+// SaveUserProfile stores a profile, keeping any username already recorded
+func (s *InMemoryUserStore) SaveUserProfile(profile *UserProfile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.erased[profile.UserID] {
+		return nil
+	}
+	stored := *profile
+	if existing, exists := s.profiles[profile.UserID]; exists {
+		stored.Username = existing.Username
+	}
+	s.profiles[profile.UserID] = &stored
+	return nil
+}
+
+// UserProfile returns a user's profile, the defaults when none is stored or
+// there is no user store
+func (s *Server) UserProfile(userID string) (*UserProfile, error) {
+	if globalUserStore == nil {
+		return defaultUserProfile(userID), nil
+	}
+	p, err := globalUserStore.GetUserProfile(userID)
+	if err != nil {
+		return nil, fmt.Errorf("load profile of %s: %w", userID, err)
+	}
+	if p == nil {
+		return defaultUserProfile(userID), nil
+	}
+	return p, nil
+}
+
+// UpdateUserProfile changes a user's profile and sends the result to all of
+// their connections
+func (s *Server) UpdateUserProfile(userID string, update ProfileUpdate) (*UserProfile, error) {
+	if globalUserStore == nil {
+		return nil, fmt.Errorf("user store not available")
+	}
+	p, err := s.UserProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := update.apply(p); err != nil {
+		return nil, err
+	}
+	p.UpdatedAt = s.now().Unix()
+	if err := globalUserStore.SaveUserProfile(p); err != nil {
+		return nil, fmt.Errorf("save profile of %s: %w", userID, err)
+	}
+
+	s.sendToUser(userID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeUserUpdate,
+		Sender:    "system",
+		Recipient: userID,
+		Timestamp: p.UpdatedAt,
+		Payload:   p.payload(),
+	})
+	return p, nil
+}
+
+// payload is the profile as the body of a user message
+func (p *UserProfile) payload() map[string]interface{} {
+	payload := p.snippet()
+	payload["status_text"] = p.StatusText
+	payload["metadata"] = p.Metadata
+	payload["updated_at"] = p.UpdatedAt
+	return payload
+}
+
+// snippet is the part of a profile shown alongside a user's activity
+func (p *UserProfile) snippet() map[string]interface{} {
+	snippet := map[string]interface{}{
+		"user_id":      p.UserID,
+		"display_name": p.DisplayName,
+		"avatar_url":   p.AvatarURL,
+	}
+	if p.Username != "" {
+		snippet["username"] = p.Username
+	}
+	return snippet
+}
+
+// profileSnippet returns a user's profile snippet, or nil when it can't be
+// loaded
+func (s *Server) profileSnippet(userID string) map[string]interface{} {
+	p, err := s.UserProfile(userID)
+	if err != nil {
+		log.Printf("Error loading profile snippet of %s: %v", userID, err)
+		return nil
+	}
+	return p.snippet()
+}
+
+// UserGetHandler replies with the profile of the payload's user_id, or the
+// sender's own profile when none is given
+func UserGetHandler(conn *Connection, msg *Message) error {
+	userID, _ := msg.Payload["user_id"].(string)
+	if userID == "" {
+		userID = conn.UserID
+	}
+	p, err := globalServer.UserProfile(userID)
+	if err != nil {
+		return err
+	}
+	return globalServer.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeUserGet,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Timestamp: globalServer.now().Unix(),
+		Payload:   p.payload(),
+	})
+}
+
+// UserUpdateHandler changes the sender's own profile. The payload carries
+// any of display_name, avatar_url, status_text and metadata.
+func UserUpdateHandler(conn *Connection, msg *Message) error {
+	update, err := profileUpdateFromPayload(msg.Payload)
+	if err != nil {
+		return err
+	}
+	_, err = globalServer.UpdateUserProfile(conn.UserID, update)
+	return err
+}
+
+// setupProfileRoutes registers profile lookups
+func setupProfileRoutes(mux *http.ServeMux) {
+	// GET returns ?user_id='s profile
+	mux.HandleFunc("/api/users/profile", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "user_id parameter required", http.StatusBadRequest)
+			return
+		}
+		p, err := globalServer.UserProfile(userID)
+		if err != nil {
+			log.Printf("Error loading profile: %v", err)
+			http.Error(w, "Failed to load profile", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	})
+
+	// GET returns the profiles of the comma-separated ?user_ids=; users
+	// without one get the defaults
+	mux.HandleFunc("/api/users/profiles", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		userIDs := make([]string, 0)
+		for _, userID := range strings.Split(r.URL.Query().Get("user_ids"), ",") {
+			if userID = strings.TrimSpace(userID); userID != "" {
+				userIDs = append(userIDs, userID)
+			}
+		}
+		if len(userIDs) == 0 || len(userIDs) > MaxProfileLookup {
+			http.Error(w, fmt.Sprintf("user_ids must name 1 to %d users", MaxProfileLookup), http.StatusBadRequest)
+			return
+		}
+
+		byID := make(map[string]*UserProfile, len(userIDs))
+		if globalUserStore != nil {
+			stored, err := globalUserStore.GetUserProfiles(userIDs)
+			if err != nil {
+				log.Printf("Error loading profiles: %v", err)
+				http.Error(w, "Failed to load profiles", http.StatusInternalServerError)
+				return
+			}
+			for _, p := range stored {
+				byID[p.UserID] = p
+			}
+		}
+		profiles := make([]*UserProfile, 0, len(userIDs))
+		for _, userID := range userIDs {
+			if p, exists := byID[userID]; exists {
+				profiles = append(profiles, p)
+			} else {
+				profiles = append(profiles, defaultUserProfile(userID))
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"profiles": profiles,
+			"count":    len(profiles),
+		})
+	})
+}
+
+// setupProfileAdminRoutes registers profile changes, guarded by admin API keys
+func setupProfileAdminRoutes(mux *http.ServeMux, s *Server, apiKeys []string) {
+	// POST changes ?user_id='s profile; omitted fields keep their values
+	mux.HandleFunc("/api/admin/users/profile", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "user_id parameter required", http.StatusBadRequest)
+			return
+		}
+
+		var update ProfileUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := update.apply(defaultUserProfile(userID)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, err := s.UpdateUserProfile(userID, update)
+		if err != nil {
+			log.Printf("Error updating profile of %s: %v", userID, err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	})
+}
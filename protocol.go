@@ -0,0 +1,196 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// CurrentProtocolVersion is the envelope version the server speaks natively.
+// Clients ask for an older one with ?protocol=N or an X-Protocol-Version header.
+const CurrentProtocolVersion = 2
+
+// ProtocolTranslation maps one older envelope version to the current one.
+// Incoming messages are upgraded with it; outgoing ones are downgraded with
+// the reverse of the same tables.
+type ProtocolTranslation struct {
+	Fields map[string]string           // Legacy envelope field -> current field
+	Types  map[MessageType]MessageType // Legacy type -> current type; keep it one-to-one
+
+	Upgrade   func(msg *Message)                    // Extra fix-ups after the tables are applied; may be nil
+	Downgrade func(envelope map[string]interface{}) // Extra fix-ups on the legacy envelope; may be nil
+}
+
+// LegacyProtocolV1 is the envelope used before the current field names:
+// {"type": "message", "room": "general", "from": "alice", "data": {...}, "ts": 1700000000}
+var LegacyProtocolV1 = ProtocolTranslation{
+	Fields: map[string]string{
+		"from": "sender",
+		"to":   "recipient",
+		"room": "channel",
+		"data": "payload",
+		"ts":   "timestamp",
+		"meta": "metadata",
+	},
+	Types: map[MessageType]MessageType{
+		"message":  MessageTypeChat,
+		"group":    MessageTypeChatGroup,
+		"dm":       MessageTypeChatPrivate,
+		"typing":   MessageTypeTyping,
+		"presence": MessageTypePresence,
+		"joined":   MessageTypeUserJoined,
+		"left":     MessageTypeUserLeft,
+	},
+	Upgrade: func(msg *Message) {
+		// v1 joined rooms with a bare "join" message
+		if msg.Type == "join" {
+			msg.Type = MessageTypePresence
+			msg.Payload["action"] = "join"
+		}
+	},
+}
+
+// defaultProtocols are the legacy versions every server understands
+func defaultProtocols() map[int]*ProtocolTranslation {
+	v1 := LegacyProtocolV1
+	return map[int]*ProtocolTranslation{1: &v1}
+}
+
+// RegisterProtocolVersion adds or replaces the translation for an older
+// protocol version. Connections already open keep the one they started with.
+func (s *Server) RegisterProtocolVersion(version int, t ProtocolTranslation) error {
+	if version <= 0 || version >= CurrentProtocolVersion {
+		return fmt.Errorf("protocol version %d is not older than %d", version, CurrentProtocolVersion)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.protocols[version] = &t
+	return nil
+}
+
+// protocolVersionFromRequest reads the requested protocol version; 0 means current
+func protocolVersionFromRequest(r *http.Request) int {
+	v := r.URL.Query().Get("protocol")
+	if v == "" {
+		v = r.Header.Get("X-Protocol-Version")
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version <= 0 {
+		return 0
+	}
+	return version
+}
+
+// protocolFor resolves a connection's translation; nil means the current protocol
+func (s *Server) protocolFor(conn *Connection) *ProtocolTranslation {
+	version := conn.Client.ProtocolVersion
+	if version == 0 || version >= CurrentProtocolVersion {
+		return nil
+	}
+	s.mu.RLock()
+	t, exists := s.protocols[version]
+	s.mu.RUnlock()
+	if !exists {
+		log.Printf("connection %s asked for unknown protocol version %d; using %d", conn.ID, version, CurrentProtocolVersion)
+	}
+	return t
+}
+
+// decodeIncoming parses a client frame, upgrading legacy envelopes
+func (s *Server) decodeIncoming(conn *Connection, data []byte) (*Message, error) {
+	t := conn.protocol
+	if t == nil {
+		var codec wireCodec = jsonCodec{}
+		if conn.binary {
+			codec = binaryCodec{}
+		}
+		msg, err := codec.unmarshal(data)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Payload == nil {
+			msg.Payload = map[string]interface{}{}
+		}
+		return msg, nil
+	}
+
+	var msg Message
+
+	var envelope map[string]interface{}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	renameFields(envelope, t.Fields)
+	upgraded, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(upgraded, &msg); err != nil {
+		return nil, err
+	}
+
+	if current, ok := t.Types[msg.Type]; ok {
+		msg.Type = current
+	}
+	if msg.Payload == nil {
+		msg.Payload = map[string]interface{}{}
+	}
+	if msg.Sender == "" {
+		msg.Sender = conn.UserID
+	}
+	if t.Upgrade != nil {
+		t.Upgrade(&msg)
+	}
+	return &msg, nil
+}
+
+// outgoing returns what to encode for a connection: msg itself, or its
+// legacy envelope for clients on an older protocol
+func (s *Server) outgoing(conn *Connection, msg *Message) interface{} {
+	t := conn.protocol
+	if t == nil {
+		return msg
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return msg
+	}
+	var envelope map[string]interface{}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return msg
+	}
+
+	for legacy, current := range t.Types {
+		if msg.Type == current {
+			envelope["type"] = string(legacy)
+			break
+		}
+	}
+	reverse := make(map[string]string, len(t.Fields))
+	for legacy, current := range t.Fields {
+		reverse[current] = legacy
+	}
+	renameFields(envelope, reverse)
+	if t.Downgrade != nil {
+		t.Downgrade(envelope)
+	}
+	return envelope
+}
+
+// renameFields moves envelope keys according to from -> to, without
+// overwriting a key the envelope already has
+func renameFields(envelope map[string]interface{}, names map[string]string) {
+	for from, to := range names {
+		value, ok := envelope[from]
+		if !ok {
+			continue
+		}
+		delete(envelope, from)
+		if _, taken := envelope[to]; !taken {
+			envelope[to] = value
+		}
+	}
+}
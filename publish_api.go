@@ -0,0 +1,163 @@
+package wssocket
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// TransportHTTP identifies messages injected through the publish API
+const TransportHTTP = "http"
+
+// apiKeyFromRequest reads an API key from "Authorization: Bearer" or X-API-Key
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// validAPIKey compares a key against the allowed list in constant time
+func validAPIKey(key string, allowed []string) bool {
+	if key == "" {
+		return false
+	}
+	valid := false
+	for _, k := range allowed {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// setupPublishRoutes registers the publish API for trusted services and bots
+func setupPublishRoutes(mux *http.ServeMux, server *Server, apiKeys []string) {
+	// Inject a message into a channel or to a user. A bot's API key publishes
+	// as the bot, within its scope and rate limit.
+	mux.HandleFunc("/api/publish", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		bot, isBot := server.bots.forKey(apiKeyFromRequest(r))
+		if !isBot && !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		var req publishRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "Invalid message format", http.StatusBadRequest)
+			return
+		}
+		msg := req.Message
+
+		if msg.Channel == "" && msg.Recipient == "" {
+			http.Error(w, "channel or recipient is required", http.StatusBadRequest)
+			return
+		}
+
+		if req.Template != "" {
+			if err := renderTemplateInto(&msg, req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Continue the caller's trace unless the message carries its own
+		if _, traced := msg.Metadata["traceparent"]; !traced {
+			InjectTrace(tracePropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header)), &msg)
+		}
+
+		var err error
+		if isBot {
+			err = server.PublishAsBot(bot, &msg)
+		} else {
+			err = server.Publish(&msg, TransportHTTP)
+		}
+		switch {
+		case errors.Is(err, ErrForbidden):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case errors.Is(err, ErrRateLimited):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{
+			"status": "queued",
+			"id":     msg.ID,
+		})
+	})
+
+	// List the notification templates that can be published
+	mux.HandleFunc("/api/templates", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		names := []string{}
+		if globalTemplates != nil {
+			names = globalTemplates.Names()
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"templates": names,
+			"count":     len(names),
+		})
+	})
+}
+
+// publishRequest is a message to publish, optionally rendered from a named template
+type publishRequest struct {
+	Message
+	Template string                 `json:"template,omitempty"`
+	Params   map[string]interface{} `json:"params,omitempty"`
+	Locale   string                 `json:"locale,omitempty"`
+}
+
+// renderTemplateInto fills msg's type and payload from the requested template
+func renderTemplateInto(msg *Message, req publishRequest) error {
+	if len(msg.Payload) > 0 {
+		return fmt.Errorf("payload and template are mutually exclusive")
+	}
+	if globalTemplates == nil {
+		return fmt.Errorf("template not found: %s", req.Template)
+	}
+
+	msgType, payload, locale, err := globalTemplates.Render(req.Template, req.Locale, req.Params)
+	if err != nil {
+		return err
+	}
+	if msg.Type == "" {
+		msg.Type = msgType
+	}
+	msg.Payload = payload
+
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]interface{})
+	}
+	msg.Metadata["template"] = req.Template
+	if locale != "" {
+		msg.Metadata["locale"] = locale
+	}
+	return nil
+}
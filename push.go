@@ -0,0 +1,547 @@
+package wssocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PushPlatform names a push notification service
+type PushPlatform string
+
+// Supported push services
+const (
+	PushFCM     PushPlatform = "fcm"
+	PushAPNs    PushPlatform = "apns"
+	PushWebPush PushPlatform = "webpush"
+)
+
+// Push limits
+const (
+	MaxDeviceTokens    = 10  // Devices per user; registering another drops the oldest
+	maxPushBodyLength  = 200 // Characters of message content a notification shows
+	maxDeviceTokenSize = 4096
+)
+
+// pushPreviewHidden is the body of a notification for a user who turned
+// previews off
+const pushPreviewHidden = "New message"
+
+// ErrDeviceTokenInvalid is returned by a PushSender when its service no
+// longer accepts a token. The token is then unregistered.
+var ErrDeviceTokenInvalid = errors.New("device token is no longer valid")
+
+// pushTypes are the message types worth waking a device for
+var pushTypes = map[MessageType]bool{
+	MessageTypeChat:          true,
+	MessageTypeChatPrivate:   true,
+	MessageTypeChatGroup:     true,
+	MessageTypeNotification:  true,
+	MessageTypeAlert:         true,
+	MessageTypeForward:       true,
+	MessageTypeChannelInvite: true,
+	MessageTypeMention:       true,
+}
+
+// DeviceToken is one device's address with a push service. A web push
+// subscription's endpoint is its token, with its keys alongside.
+type DeviceToken struct {
+	UserID    string       `json:"user_id"`
+	Platform  PushPlatform `json:"platform"`
+	Token     string       `json:"token"`
+	P256DH    string       `json:"-"` // Web push subscription keys, never listed
+	Auth      string       `json:"-"`
+	CreatedAt int64        `json:"created_at"`
+}
+
+// validate checks a device token is complete for its platform. Web push
+// endpoints must be https URLs on a named host, since the server POSTs to
+// them.
+func (d *DeviceToken) validate() error {
+	if d.Token == "" || len(d.Token) > maxDeviceTokenSize {
+		return fmt.Errorf("token is required and at most %d bytes", maxDeviceTokenSize)
+	}
+	switch d.Platform {
+	case PushFCM, PushAPNs:
+		return nil
+	case PushWebPush:
+		u, err := url.Parse(d.Token)
+		if err != nil || u.Scheme != "https" || u.Hostname() == "" || net.ParseIP(u.Hostname()) != nil {
+			return fmt.Errorf("a web push token must be an https endpoint URL")
+		}
+		if d.P256DH == "" || d.Auth == "" {
+			return fmt.Errorf("a web push subscription needs its p256dh and auth keys")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown platform %q: use %s, %s or %s", d.Platform, PushFCM, PushAPNs, PushWebPush)
+	}
+}
+
+// PushPreferences are a user's notification settings
+type PushPreferences struct {
+	UserID      string `json:"user_id"`
+	Enabled     bool   `json:"enabled"`
+	ShowPreview bool   `json:"show_preview"` // Show message content; otherwise a generic body
+	UpdatedAt   int64  `json:"updated_at,omitempty"`
+}
+
+// defaultPushPreferences returns the settings of a user who hasn't chosen any
+func defaultPushPreferences(userID string) *PushPreferences {
+	return &PushPreferences{UserID: userID, Enabled: true, ShowPreview: true}
+}
+
+// PushNotification is what a push service shows on a device
+type PushNotification struct {
+	Title string
+	Body  string
+	Data  map[string]string // Handed to the app with the alert
+}
+
+// PushSender delivers notifications through one push service
+type PushSender interface {
+	// Send notifies one device. It returns ErrDeviceTokenInvalid when the
+	// service has dropped the token.
+	Send(ctx context.Context, device *DeviceToken, n *PushNotification) error
+}
+
+// Notifier is told about messages for users with no live connection. Notify
+// is called on the delivery path and must not block.
+type Notifier interface {
+	Notify(userID string, msg *Message)
+}
+
+// SetNotifier sets who is told about messages for offline users
+func (s *Server) SetNotifier(n Notifier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifier = n
+}
+
+// wantsPush reports whether a message for an offline user should reach them
+// as a notification. Notes to self and messages with a ttl don't, nor do
+// mentions in a direct message, whose own notification already says so.
+func wantsPush(userID string, msg *Message) bool {
+	if msg.Type == MessageTypeMention && msg.Channel == "" {
+		return false
+	}
+	return pushTypes[msg.Type] && msg.Sender != userID && !isEphemeral(msg)
+}
+
+// PushConfig controls notification delivery
+type PushConfig struct {
+	Workers   int           // Concurrent deliveries
+	QueueSize int           // Pending notifications before new ones are dropped
+	Timeout   time.Duration // Per-device request timeout
+}
+
+// pushJob is one message bound for one user's devices
+type pushJob struct {
+	userID string
+	msg    *Message
+}
+
+// PushService notifies offline users' registered devices, honouring their
+// preferences and channel mutes
+type PushService struct {
+	store   UserStore
+	senders map[PushPlatform]PushSender
+	config  PushConfig
+	queue   chan *pushJob
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewPushService creates a push service and starts its workers. Devices on
+// platforms without a sender are skipped.
+func NewPushService(store UserStore, senders map[PushPlatform]PushSender, config PushConfig) *PushService {
+	if config.Workers == 0 {
+		config.Workers = 4
+	}
+	if config.QueueSize == 0 {
+		config.QueueSize = 1000
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	p := &PushService{
+		store:   store,
+		senders: senders,
+		config:  config,
+		queue:   make(chan *pushJob, config.QueueSize),
+		done:    make(chan struct{}),
+	}
+	for i := 0; i < config.Workers; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+	return p
+}
+
+// Notify queues a message for a user's devices, dropping it when the queue
+// is full
+func (p *PushService) Notify(userID string, msg *Message) {
+	select {
+	case p.queue <- &pushJob{userID: userID, msg: msg}:
+	default:
+		log.Printf("Push queue full, dropping notification of %s for %s", msg.ID, userID)
+	}
+}
+
+// Stop waits for in-flight notifications and stops the workers
+func (p *PushService) Stop() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+// worker delivers queued notifications until the service stops
+func (p *PushService) worker() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.done:
+			return
+		case job := <-p.queue:
+			p.deliver(job.userID, job.msg)
+		}
+	}
+}
+
+// webPushSender returns the configured web push sender, if any
+func (p *PushService) webPushSender() (*WebPushSender, bool) {
+	if p == nil {
+		return nil, false
+	}
+	sender, ok := p.senders[PushWebPush].(*WebPushSender)
+	return sender, ok
+}
+
+// deliver sends a message to each of a user's devices unless they turned
+// notifications off or muted its channel. Mentions get through a mute.
+func (p *PushService) deliver(userID string, msg *Message) {
+	prefs, err := p.store.PushPreferences(userID)
+	if err != nil {
+		log.Printf("Error loading push preferences of %s: %v", userID, err)
+		return
+	}
+	if !prefs.Enabled {
+		return
+	}
+	if msg.Channel != "" && msg.Type != MessageTypeMention {
+		muted, err := p.store.MutedChannels(userID)
+		if err != nil {
+			log.Printf("Error loading muted channels of %s: %v", userID, err)
+			return
+		}
+		if slices.Contains(muted, msg.Channel) {
+			return
+		}
+	}
+	devices, err := p.store.DeviceTokens(userID)
+	if err != nil {
+		log.Printf("Error loading devices of %s: %v", userID, err)
+		return
+	}
+	if len(devices) == 0 {
+		return
+	}
+
+	n := p.notification(userID, msg, prefs.ShowPreview)
+	for _, device := range devices {
+		sender, ok := p.senders[device.Platform]
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), p.config.Timeout)
+		err := sender.Send(ctx, device, n)
+		cancel()
+		if errors.Is(err, ErrDeviceTokenInvalid) {
+			log.Printf("Unregistering stale %s device of %s", device.Platform, userID)
+			if err := p.store.DeleteDeviceToken(userID, device.Token); err != nil {
+				log.Printf("Error unregistering device of %s: %v", userID, err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Error sending %s notification to %s: %v", device.Platform, userID, err)
+		}
+	}
+}
+
+// notification builds what a device shows for a message: the sender's name
+// and where it was sent as the title, and the start of its content. A
+// mention of the user says so in the title and data.
+func (p *PushService) notification(userID string, msg *Message, showPreview bool) *PushNotification {
+	sender, messageID, content := msg.Sender, msg.ID, messageContent(msg)
+	mentioned := mentionsUser(msg, userID)
+	if msg.Type == MessageTypeMention {
+		sender, _ = msg.Payload["sender"].(string)
+		messageID, _ = msg.Payload["message_id"].(string)
+		content, _ = msg.Payload["excerpt"].(string)
+		mentioned = true
+	}
+
+	title := sender
+	if profile, err := p.store.GetUserProfile(sender); err == nil && profile != nil {
+		if profile.DisplayName != "" {
+			title = profile.DisplayName
+		} else if profile.Username != "" {
+			title = profile.Username
+		}
+	}
+	if mentioned {
+		title += " mentioned you"
+	}
+	if t, ok := msg.Payload["title"].(string); ok && t != "" && !mentioned {
+		title = t
+	} else if msg.Channel != "" && !isGroupConversation(msg.Channel) {
+		title += " in " + msg.Channel
+	}
+
+	body := pushPreviewHidden
+	if showPreview {
+		body = truncateRunes(content, maxPushBodyLength)
+	}
+
+	data := map[string]string{
+		"message_id": messageID,
+		"type":       string(msg.Type),
+		"sender":     sender,
+	}
+	if msg.Channel != "" {
+		data["channel"] = msg.Channel
+	}
+	if mentioned {
+		data["mention"] = "true"
+	}
+	return &PushNotification{Title: title, Body: body, Data: data}
+}
+
+// SaveDeviceToken registers a device for a user, taking it from whoever had
+// it before and dropping the user's oldest beyond MaxDeviceTokens
+func (s *InMemoryUserStore) SaveDeviceToken(device *DeviceToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for userID, devices := range s.devices {
+		s.devices[userID] = slices.DeleteFunc(devices, func(d *DeviceToken) bool { return d.Token == device.Token })
+	}
+	stored := *device
+	devices := append(s.devices[device.UserID], &stored)
+	if len(devices) > MaxDeviceTokens {
+		devices = devices[len(devices)-MaxDeviceTokens:]
+	}
+	s.devices[device.UserID] = devices
+	return nil
+}
+
+// DeleteDeviceToken unregisters one of a user's devices
+func (s *InMemoryUserStore) DeleteDeviceToken(userID, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[userID] = slices.DeleteFunc(s.devices[userID], func(d *DeviceToken) bool { return d.Token == token })
+	return nil
+}
+
+// DeviceTokens returns a user's devices, oldest first
+func (s *InMemoryUserStore) DeviceTokens(userID string) ([]*DeviceToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	devices := make([]*DeviceToken, 0, len(s.devices[userID]))
+	for _, d := range s.devices[userID] {
+		copied := *d
+		devices = append(devices, &copied)
+	}
+	return devices, nil
+}
+
+// PushPreferences returns a user's notification settings, or the defaults
+func (s *InMemoryUserStore) PushPreferences(userID string) (*PushPreferences, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	prefs, exists := s.pushPrefs[userID]
+	if !exists {
+		return defaultPushPreferences(userID), nil
+	}
+	copied := *prefs
+	return &copied, nil
+}
+
+// SavePushPreferences replaces a user's notification settings
+func (s *InMemoryUserStore) SavePushPreferences(prefs *PushPreferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *prefs
+	s.pushPrefs[prefs.UserID] = &stored
+	return nil
+}
+
+// setupPushRoutes registers device registration and notification settings.
+// push may be nil when no push service is configured; devices can still be
+// registered ahead of time.
+func setupPushRoutes(mux *http.ServeMux, s *Server, push *PushService) {
+	// GET lists ?user_id='s devices, POST registers one and DELETE
+	// unregisters ?device_token= (?token= is the user's auth token)
+	mux.HandleFunc("/api/users/devices", func(w http.ResponseWriter, r *http.Request) {
+		if globalUserStore == nil {
+			http.Error(w, "User store not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+			if !ok {
+				return
+			}
+			devices, err := globalUserStore.DeviceTokens(userID)
+			if err != nil {
+				log.Printf("Error listing devices of %s: %v", userID, err)
+				http.Error(w, "Failed to list devices", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"devices": devices,
+				"count":   len(devices),
+			})
+
+		case http.MethodPost:
+			var req struct {
+				UserID   string       `json:"user_id"`
+				Platform PushPlatform `json:"platform"`
+				Token    string       `json:"token"`
+				P256DH   string       `json:"p256dh"`
+				Auth     string       `json:"auth"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			userID, ok := requestUser(w, r, req.UserID)
+			if !ok {
+				return
+			}
+			device := &DeviceToken{
+				UserID:    userID,
+				Platform:  req.Platform,
+				Token:     req.Token,
+				P256DH:    req.P256DH,
+				Auth:      req.Auth,
+				CreatedAt: s.now().Unix(),
+			}
+			if err := device.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := globalUserStore.SaveDeviceToken(device); err != nil {
+				log.Printf("Error registering device of %s: %v", userID, err)
+				http.Error(w, "Failed to register device", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusCreated, device)
+
+		case http.MethodDelete:
+			userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+			if !ok {
+				return
+			}
+			token := r.URL.Query().Get("device_token")
+			if token == "" {
+				http.Error(w, "device_token parameter required", http.StatusBadRequest)
+				return
+			}
+			if err := globalUserStore.DeleteDeviceToken(userID, token); err != nil {
+				log.Printf("Error unregistering device of %s: %v", userID, err)
+				http.Error(w, "Failed to unregister device", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// GET returns ?user_id='s notification settings; PUT changes the ones
+	// given
+	mux.HandleFunc("/api/users/push-preferences", func(w http.ResponseWriter, r *http.Request) {
+		if globalUserStore == nil {
+			http.Error(w, "User store not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+			if !ok {
+				return
+			}
+			prefs, err := globalUserStore.PushPreferences(userID)
+			if err != nil {
+				log.Printf("Error loading push preferences of %s: %v", userID, err)
+				http.Error(w, "Failed to load preferences", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, prefs)
+
+		case http.MethodPut:
+			var req struct {
+				UserID      string `json:"user_id"`
+				Enabled     *bool  `json:"enabled"`
+				ShowPreview *bool  `json:"show_preview"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			userID, ok := requestUser(w, r, req.UserID)
+			if !ok {
+				return
+			}
+			prefs, err := globalUserStore.PushPreferences(userID)
+			if err != nil {
+				log.Printf("Error loading push preferences of %s: %v", userID, err)
+				http.Error(w, "Failed to load preferences", http.StatusInternalServerError)
+				return
+			}
+			if req.Enabled != nil {
+				prefs.Enabled = *req.Enabled
+			}
+			if req.ShowPreview != nil {
+				prefs.ShowPreview = *req.ShowPreview
+			}
+			prefs.UpdatedAt = s.now().Unix()
+			if err := globalUserStore.SavePushPreferences(prefs); err != nil {
+				log.Printf("Error saving push preferences of %s: %v", userID, err)
+				http.Error(w, "Failed to save preferences", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, prefs)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// GET returns the VAPID public key browsers subscribe with
+	mux.HandleFunc("/api/push/vapid-public-key", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sender, ok := push.webPushSender()
+		if !ok {
+			http.Error(w, "Web push not configured", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"public_key": sender.PublicKey()})
+	})
+}
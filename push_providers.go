@@ -0,0 +1,506 @@
+package wssocket
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Push service endpoints
+const (
+	fcmScope          = "https://www.googleapis.com/auth/firebase.messaging"
+	fcmSendURL        = "https://fcm.googleapis.com/v1/projects/%s/messages:send"
+	apnsProductionURL = "https://api.push.apple.com"
+	apnsSandboxURL    = "https://api.sandbox.push.apple.com"
+)
+
+// Credential lifetimes. APNs rejects provider tokens older than an hour and
+// throttles ones refreshed more often than every 20 minutes.
+const (
+	apnsTokenLifetime = 50 * time.Minute
+	vapidLifetime     = 12 * time.Hour
+	webPushTTL        = 24 * time.Hour
+	webPushRecordSize = 4096
+)
+
+// signJWT returns a compact JWT over claims, signed with alg by sign
+func signJWT(alg, kid string, claims map[string]interface{}, sign func(digest []byte) ([]byte, error)) (string, error) {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := sign(digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign token: %w", err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// signES256 signs a digest as JWS wants it: r and s, 32 bytes each
+func signES256(key *ecdsa.PrivateKey) func([]byte) ([]byte, error) {
+	return func(digest []byte) ([]byte, error) {
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			return nil, err
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	}
+}
+
+// pushResponseError reads a push service's error reply
+func pushResponseError(service string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s returned %d: %s", service, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// FCMSender sends through Firebase Cloud Messaging's HTTP v1 API,
+// authenticating as a service account
+type FCMSender struct {
+	projectID   string
+	clientEmail string
+	tokenURI    string
+	key         *rsa.PrivateKey
+	client      *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expires     time.Time
+}
+
+// NewFCMSender creates an FCM sender from a service account JSON key
+func NewFCMSender(credentials []byte) (*FCMSender, error) {
+	var account struct {
+		ProjectID   string `json:"project_id"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(credentials, &account); err != nil {
+		return nil, fmt.Errorf("parse FCM credentials: %w", err)
+	}
+	if account.ProjectID == "" || account.ClientEmail == "" || account.TokenURI == "" {
+		return nil, fmt.Errorf("FCM credentials need project_id, client_email and token_uri")
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("FCM credentials have no PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse FCM private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("FCM private key is not RSA")
+	}
+	return &FCMSender{
+		projectID:   account.ProjectID,
+		clientEmail: account.ClientEmail,
+		tokenURI:    account.TokenURI,
+		key:         key,
+		client:      &http.Client{},
+	}, nil
+}
+
+// token returns an OAuth access token, exchanging a signed assertion for a
+// new one shortly before the last expires
+func (f *FCMSender) token(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.accessToken != "" && time.Now().Before(f.expires) {
+		return f.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := signJWT("RS256", "", map[string]interface{}{
+		"iss":   f.clientEmail,
+		"scope": fcmScope,
+		"aud":   f.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}, func(digest []byte) ([]byte, error) {
+		return rsa.SignPKCS1v15(nil, f.key, crypto.SHA256, digest)
+	})
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetch FCM access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", pushResponseError("FCM token endpoint", resp)
+	}
+	var grant struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&grant); err != nil {
+		return "", fmt.Errorf("parse FCM access token: %w", err)
+	}
+	f.accessToken = grant.AccessToken
+	f.expires = now.Add(time.Duration(grant.ExpiresIn)*time.Second - time.Minute)
+	return f.accessToken, nil
+}
+
+// Send notifies one Android or FCM-registered device
+func (f *FCMSender) Send(ctx context.Context, device *DeviceToken, n *PushNotification) error {
+	token, err := f.token(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":        device.Token,
+			"notification": map[string]string{"title": n.Title, "body": n.Body},
+			"data":         n.Data,
+			"android":      map[string]string{"priority": "high"},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(fcmSendURL, f.projectID), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		// UNREGISTERED: the app was uninstalled or the token rotated
+		return ErrDeviceTokenInvalid
+	default:
+		return pushResponseError("FCM", resp)
+	}
+}
+
+// APNsSender sends through Apple's push service with a token-based
+// (.p8 key) provider connection
+type APNsSender struct {
+	baseURL string
+	keyID   string
+	teamID  string
+	topic   string // The app's bundle ID
+	key     *ecdsa.PrivateKey
+	client  *http.Client
+
+	mu       sync.Mutex
+	jwt      string
+	issuedAt time.Time
+}
+
+// NewAPNsSender creates an APNs sender from a PEM .p8 signing key. sandbox
+// selects the development environment.
+func NewAPNsSender(keyPEM []byte, keyID, teamID, topic string, sandbox bool) (*APNsSender, error) {
+	if keyID == "" || teamID == "" || topic == "" {
+		return nil, fmt.Errorf("APNs needs a key ID, team ID and topic")
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("APNs key is not PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse APNs key: %w", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("APNs key is not an EC key")
+	}
+	baseURL := apnsProductionURL
+	if sandbox {
+		baseURL = apnsSandboxURL
+	}
+	// The default transport negotiates the HTTP/2 APNs requires
+	return &APNsSender{
+		baseURL: baseURL,
+		keyID:   keyID,
+		teamID:  teamID,
+		topic:   topic,
+		key:     key,
+		client:  &http.Client{},
+	}, nil
+}
+
+// providerToken returns the signed provider token, reissuing it once it is
+// apnsTokenLifetime old
+func (a *APNsSender) providerToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.jwt != "" && time.Since(a.issuedAt) < apnsTokenLifetime {
+		return a.jwt, nil
+	}
+	now := time.Now()
+	token, err := signJWT("ES256", a.keyID, map[string]interface{}{
+		"iss": a.teamID,
+		"iat": now.Unix(),
+	}, signES256(a.key))
+	if err != nil {
+		return "", err
+	}
+	a.jwt, a.issuedAt = token, now
+	return token, nil
+}
+
+// Send notifies one iOS device
+func (a *APNsSender) Send(ctx context.Context, device *DeviceToken, n *PushNotification) error {
+	token, err := a.providerToken()
+	if err != nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": n.Title, "body": n.Body},
+			"sound": "default",
+		},
+	}
+	for k, v := range n.Data {
+		payload[k] = v
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+"/3/device/"+url.PathEscape(device.Token), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var reply struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&reply)
+	switch {
+	case resp.StatusCode == http.StatusGone,
+		reply.Reason == "BadDeviceToken",
+		reply.Reason == "DeviceTokenNotForTopic":
+		return ErrDeviceTokenInvalid
+	default:
+		return fmt.Errorf("APNs returned %d: %s", resp.StatusCode, reply.Reason)
+	}
+}
+
+// WebPushSender sends to browser push subscriptions, encrypting payloads
+// per RFC 8291 and identifying the server with VAPID (RFC 8292)
+type WebPushSender struct {
+	key       *ecdsa.PrivateKey
+	publicKey string // Uncompressed point, base64url
+	subject   string // mailto: or https: contact for the push service
+	client    *http.Client
+}
+
+// NewWebPushSender creates a web push sender from a base64url VAPID private
+// key (the raw 32-byte P-256 scalar) and a contact subject
+func NewWebPushSender(privateKey, subject string) (*WebPushSender, error) {
+	if !strings.HasPrefix(subject, "mailto:") && !strings.HasPrefix(subject, "https://") {
+		return nil, fmt.Errorf("VAPID subject must be a mailto: or https: URL")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(privateKey, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decode VAPID private key: %w", err)
+	}
+	key, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse VAPID private key: %w", err)
+	}
+	public, err := key.PublicKey.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &WebPushSender{
+		key:       key,
+		publicKey: base64.RawURLEncoding.EncodeToString(public),
+		subject:   subject,
+		client:    &http.Client{},
+	}, nil
+}
+
+// PublicKey returns the applicationServerKey browsers subscribe with
+func (w *WebPushSender) PublicKey() string {
+	return w.publicKey
+}
+
+// Send notifies one browser subscription
+func (w *WebPushSender) Send(ctx context.Context, device *DeviceToken, n *PushNotification) error {
+	endpoint, err := url.Parse(device.Token)
+	if err != nil {
+		return ErrDeviceTokenInvalid
+	}
+	plaintext, err := json.Marshal(map[string]interface{}{
+		"title": n.Title,
+		"body":  n.Body,
+		"data":  n.Data,
+	})
+	if err != nil {
+		return err
+	}
+	body, err := encryptWebPush(plaintext, device.P256DH, device.Auth)
+	if err != nil {
+		return fmt.Errorf("encrypt web push payload: %w", err)
+	}
+	vapid, err := signJWT("ES256", "", map[string]interface{}{
+		"aud": endpoint.Scheme + "://" + endpoint.Host,
+		"exp": time.Now().Add(vapidLifetime).Unix(),
+		"sub": w.subject,
+	}, signES256(w.key))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, device.Token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "vapid t="+vapid+", k="+w.publicKey)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", fmt.Sprint(int(webPushTTL.Seconds())))
+	req.Header.Set("Urgency", "high")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		// The subscription expired or the user revoked permission
+		return ErrDeviceTokenInvalid
+	default:
+		return pushResponseError("web push service", resp)
+	}
+}
+
+// encryptWebPush encrypts a payload for a subscription's keys as a single
+// aes128gcm record (RFC 8188), keyed by an ephemeral ECDH exchange
+func encryptWebPush(plaintext []byte, p256dh, authSecret string) ([]byte, error) {
+	uaPublicBytes, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(p256dh, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decode p256dh: %w", err)
+	}
+	auth, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(authSecret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decode auth: %w", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse p256dh: %w", err)
+	}
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublicBytes := asPrivate.PublicKey().Bytes()
+	shared, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := "WebPush: info\x00" + string(uaPublicBytes) + string(asPublicBytes)
+	ikm, err := hkdf.Key(sha256.New, shared, auth, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// 0x02 pads and marks the last (only) record
+	record := append(append([]byte{}, plaintext...), 0x02)
+	if len(record)+gcm.Overhead() > webPushRecordSize {
+		return nil, fmt.Errorf("payload of %d bytes is too large for web push", len(plaintext))
+	}
+
+	header := make([]byte, 0, 16+4+1+len(asPublicBytes))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, webPushRecordSize)
+	header = append(header, byte(len(asPublicBytes)))
+	header = append(header, asPublicBytes...)
+	return gcm.Seal(header, nonce, record, nil), nil
+}
@@ -0,0 +1,129 @@
+package wssocket
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned for a message dropped for going over a rate limit
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimit caps how fast each connection may send messages
+type RateLimit struct {
+	PerSecond float64 `json:"per_second"` // Sustained rate; 0 disables the limit
+	Burst     int     `json:"burst"`      // Messages allowed at once; 0 uses one second's worth
+
+	// Disconnect closes a connection with CloseRateLimited once this many
+	// of its messages in a row were dropped; 0 never closes it
+	Disconnect int `json:"disconnect,omitempty"`
+}
+
+// Enabled reports whether the limit applies
+func (l RateLimit) Enabled() bool {
+	return l.PerSecond > 0
+}
+
+// burst returns the bucket size
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return max(l.PerSecond, 1)
+}
+
+// tokenBucket meters one connection's messages
+type tokenBucket struct {
+	mu         sync.Mutex
+	tokens     float64
+	refilledAt time.Time
+	rejected   int // Messages refused since the last one allowed
+}
+
+// allow takes a token if one is available. A new bucket starts full, and
+// shrinking the limit caps what a bucket has saved up.
+func (b *tokenBucket) allow(limit RateLimit) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	burst := limit.burst()
+	if b.refilledAt.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = min(b.tokens+now.Sub(b.refilledAt).Seconds()*limit.PerSecond, burst)
+	}
+	b.refilledAt = now
+
+	if b.tokens < 1 {
+		b.rejected++
+		return false
+	}
+	b.tokens--
+	b.rejected = 0
+	return true
+}
+
+// wait returns how long until the bucket next has a token
+func (b *tokenBucket) wait(limit RateLimit) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens >= 1 || !limit.Enabled() {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / limit.PerSecond * float64(time.Second))
+}
+
+// rejectedInARow returns how many messages were refused since the last one
+// allowed
+func (b *tokenBucket) rejectedInARow() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rejected
+}
+
+// SetRateLimit changes the per-connection message rate limit; the zero
+// value removes it. Connections keep their current allowance.
+func (s *Server) SetRateLimit(limit RateLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimit = limit
+}
+
+// RateLimit returns the per-connection message rate limit
+func (s *Server) RateLimit() RateLimit {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rateLimit
+}
+
+// rejectRateLimited tells a sender its message was dropped for going over the limit
+func (s *Server) rejectRateLimited(conn *Connection, msg *Message, limit RateLimit, wait time.Duration) error {
+	s.metrics.rateLimited.Add(1)
+	err := fmt.Errorf("%w: at most %g messages/s", ErrRateLimited, limit.PerSecond)
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeError,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"code":        "rate_limited",
+			"error":       err.Error(),
+			"message_id":  msg.ID,
+			"retry_after": wait.Seconds(), // Until the next message is allowed
+		},
+	})
+	return err
+}
+
+// disconnectFlooder closes a connection that kept sending over its limit
+func (s *Server) disconnectFlooder(conn *Connection, limit RateLimit) {
+	if limit.Disconnect <= 0 || conn.bucket.rejectedInARow() < limit.Disconnect {
+		return
+	}
+	log.Printf("Closing connection %s of %s: %d messages in a row over the rate limit", conn.ID, conn.UserID, limit.Disconnect)
+	s.closeConnection(conn.ID, CloseRateLimited, s.retryReason(conn, ErrRateLimited.Error()))
+}
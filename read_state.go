@@ -0,0 +1,254 @@
+package wssocket
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// MaxUnreadChannels caps how many channels one unread query covers
+const MaxUnreadChannels = 100
+
+// ChannelReadState is how far a user has read a channel. Messages after the
+// marker, in (timestamp, id) order and sent by others, are unread.
+type ChannelReadState struct {
+	Channel           string `json:"channel"`
+	UserID            string `json:"user_id"`
+	LastReadMessageID string `json:"last_read_message_id,omitempty"`
+	LastReadAt        int64  `json:"last_read_at"` // Timestamp of the last read message
+	Unread            int    `json:"unread"`
+	UpdatedAt         int64  `json:"updated_at,omitempty"`
+}
+
+// ReadStateStore is implemented by message stores that keep per-channel
+// read markers
+type ReadStateStore interface {
+	// MarkChannelRead moves a user's marker in a channel forward to a stored
+	// message of that channel and returns the resulting state
+	MarkChannelRead(userID, channel, messageID string, now int64) (*ChannelReadState, error)
+	// ChannelReadStates returns a user's read state and unread count for
+	// each channel. Without channels, it covers every channel with a marker.
+	ChannelReadStates(userID string, channels []string) ([]*ChannelReadState, error)
+}
+
+// readStateStore returns the message store's read marker support, if any
+func readStateStore() (ReadStateStore, bool) {
+	if globalStore == nil {
+		return nil, false
+	}
+	rs, ok := globalStore.(ReadStateStore)
+	return rs, ok
+}
+
+// readAfter reports whether msg comes after a read marker
+func (rs *ChannelReadState) readAfter(msg *Message) bool {
+	if msg.Timestamp != rs.LastReadAt {
+		return msg.Timestamp > rs.LastReadAt
+	}
+	return msg.ID > rs.LastReadMessageID
+}
+
+// payload is the read state as the body of a channel:read message
+func (rs *ChannelReadState) payload() map[string]interface{} {
+	return map[string]interface{}{
+		"channel":              rs.Channel,
+		"last_read_message_id": rs.LastReadMessageID,
+		"last_read_at":         rs.LastReadAt,
+		"unread":               rs.Unread,
+	}
+}
+
+// lastReadMessageID returns a user's marker in a channel, "" when there is
+// none, for history replies to draw a "new messages" divider
+func lastReadMessageID(userID, channel string) string {
+	rs, ok := readStateStore()
+	if !ok {
+		return ""
+	}
+	states, err := rs.ChannelReadStates(userID, []string{channel})
+	if err != nil {
+		log.Printf("Error loading read state of %s in %s: %v", userID, channel, err)
+		return ""
+	}
+	if len(states) == 0 {
+		return ""
+	}
+	return states[0].LastReadMessageID
+}
+
+// ChannelReadHandler moves the sender's read marker in a channel to the
+// payload's message_id. The sender gets the new state back and their other
+// devices get it as a sync event.
+func ChannelReadHandler(conn *Connection, msg *Message) error {
+	messageID, _ := msg.Payload["message_id"].(string)
+	if msg.Channel == "" || messageID == "" {
+		return fmt.Errorf("channel and message_id are required")
+	}
+	if !globalServer.follows(conn, msg.Channel) {
+		return fmt.Errorf("not subscribed to channel %s", msg.Channel)
+	}
+	rs, ok := readStateStore()
+	if !ok {
+		return fmt.Errorf("read markers not supported by the message store")
+	}
+	state, err := rs.MarkChannelRead(conn.UserID, msg.Channel, messageID, globalServer.now().Unix())
+	if err != nil {
+		return fmt.Errorf("mark %s read: %w", msg.Channel, err)
+	}
+
+	globalServer.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeChannelRead,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Channel:   msg.Channel,
+		Timestamp: globalServer.now().Unix(),
+		Payload:   state.payload(),
+	})
+	globalServer.syncDevices(conn, string(MessageTypeChannelRead), msg.Channel, state.payload())
+	return nil
+}
+
+// MarkChannelRead moves a user's marker forward to a message of the channel
+func (s *InMemoryMessageStore) MarkChannelRead(userID, channel, messageID string, now int64) (*ChannelReadState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pos, exists := s.index[messageID]
+	if !exists || s.messages[pos].Channel != channel {
+		return nil, ErrMessageNotFound
+	}
+	if s.channelRead[userID] == nil {
+		s.channelRead[userID] = make(map[string]*ChannelReadState)
+	}
+	state := s.channelRead[userID][channel]
+	if state == nil || state.readAfter(s.messages[pos]) {
+		state = &ChannelReadState{
+			Channel:           channel,
+			UserID:            userID,
+			LastReadMessageID: messageID,
+			LastReadAt:        s.messages[pos].Timestamp,
+			UpdatedAt:         now,
+		}
+		s.channelRead[userID][channel] = state
+	}
+
+	copied := *state
+	copied.Unread = s.unreadLocked(&copied)
+	return &copied, nil
+}
+
+// ChannelReadStates returns a user's markers and unread counts
+func (s *InMemoryMessageStore) ChannelReadStates(userID string, channels []string) ([]*ChannelReadState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(channels) == 0 {
+		for channel := range s.channelRead[userID] {
+			channels = append(channels, channel)
+		}
+		sort.Strings(channels)
+	}
+	states := make([]*ChannelReadState, 0, len(channels))
+	for _, channel := range channels {
+		state := ChannelReadState{Channel: channel, UserID: userID}
+		if stored := s.channelRead[userID][channel]; stored != nil {
+			state = *stored
+		}
+		state.Unread = s.unreadLocked(&state)
+		states = append(states, &state)
+	}
+	return states, nil
+}
+
+// unreadLocked counts the messages from others after a marker. The caller
+// holds s.mu.
+func (s *InMemoryMessageStore) unreadLocked(state *ChannelReadState) int {
+	unread := 0
+	for _, msg := range s.messages {
+		if msg.Channel == state.Channel && msg.Sender != state.UserID && state.readAfter(msg) {
+			unread++
+		}
+	}
+	return unread
+}
+
+// MarkChannelRead passes read markers through to the underlying store
+func (e *EncryptedStore) MarkChannelRead(userID, channel, messageID string, now int64) (*ChannelReadState, error) {
+	rs, ok := e.inner.(ReadStateStore)
+	if !ok {
+		return nil, fmt.Errorf("read markers not supported by the underlying store")
+	}
+	return rs.MarkChannelRead(userID, channel, messageID, now)
+}
+
+// ChannelReadStates passes read markers through to the underlying store
+func (e *EncryptedStore) ChannelReadStates(userID string, channels []string) ([]*ChannelReadState, error) {
+	rs, ok := e.inner.(ReadStateStore)
+	if !ok {
+		return nil, fmt.Errorf("read markers not supported by the underlying store")
+	}
+	return rs.ChannelReadStates(userID, channels)
+}
+
+// setupReadStateRoutes registers unread count lookups
+func setupReadStateRoutes(mux *http.ServeMux) {
+	// GET returns ?user_id='s read state and unread count in each of the
+	// comma-separated ?channels=, or in every channel they have read
+	mux.HandleFunc("/api/channels/unread", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rs, ok := readStateStore()
+		if !ok {
+			http.Error(w, "Read markers not available", http.StatusServiceUnavailable)
+			return
+		}
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+		channels := make([]string, 0)
+		for _, channel := range strings.Split(r.URL.Query().Get("channels"), ",") {
+			if channel = strings.TrimSpace(channel); channel != "" {
+				channels = append(channels, channel)
+			}
+		}
+		if len(channels) > MaxUnreadChannels {
+			http.Error(w, fmt.Sprintf("at most %d channels per query", MaxUnreadChannels), http.StatusBadRequest)
+			return
+		}
+
+		// Don't reveal channels the user can't see
+		requested := len(channels)
+		channels = slices.DeleteFunc(channels, func(channel string) bool {
+			if isGroupConversation(channel) {
+				return !globalServer.isParticipant(channel, userID)
+			}
+			return channelHidden(channel) && !globalServer.isChannelMember(channel, userID)
+		})
+
+		states := make([]*ChannelReadState, 0)
+		var err error
+		if requested == 0 || len(channels) > 0 {
+			states, err = rs.ChannelReadStates(userID, channels)
+		}
+		if err != nil {
+			log.Printf("Error loading read state of %s: %v", userID, err)
+			http.Error(w, "Failed to load read state", http.StatusInternalServerError)
+			return
+		}
+		total := 0
+		for _, state := range states {
+			total += state.Unread
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"channels":     states,
+			"total_unread": total,
+		})
+	})
+}
@@ -0,0 +1,219 @@
+package wssocket
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Reconnect hint and storm detection defaults
+const (
+	DefaultRetryAfter     = 5 * time.Second
+	DefaultMaxRetryAfter  = 5 * time.Minute
+	DefaultStormWindow    = time.Minute
+	DefaultStormThreshold = 20
+)
+
+// ReconnectConfig sets the backoff hints sent to refused and disconnected
+// clients, and when repeated connects from one user or IP count as a
+// reconnect storm
+type ReconnectConfig struct {
+	RetryAfter     time.Duration // Hint for clients that aren't storming; 0 uses DefaultRetryAfter
+	MaxRetryAfter  time.Duration // Longest hint a storming client gets; 0 uses DefaultMaxRetryAfter
+	StormWindow    time.Duration // Period connects are counted over; 0 uses DefaultStormWindow
+	StormThreshold int           // Connects per window from one user or IP that make a storm; 0 uses DefaultStormThreshold
+}
+
+// withDefaults fills in unset fields
+func (c ReconnectConfig) withDefaults() ReconnectConfig {
+	if c.RetryAfter <= 0 {
+		c.RetryAfter = DefaultRetryAfter
+	}
+	if c.MaxRetryAfter <= 0 {
+		c.MaxRetryAfter = DefaultMaxRetryAfter
+	}
+	if c.StormWindow <= 0 {
+		c.StormWindow = DefaultStormWindow
+	}
+	if c.StormThreshold <= 0 {
+		c.StormThreshold = DefaultStormThreshold
+	}
+	return c
+}
+
+// retryAfter returns the hint for a client that connected this many times
+// in the window. It doubles for each multiple of the storm threshold.
+func (c ReconnectConfig) retryAfter(connects int) time.Duration {
+	if connects <= c.StormThreshold {
+		return c.RetryAfter
+	}
+	hint := c.RetryAfter << min(connects/c.StormThreshold, 16)
+	return min(hint, c.MaxRetryAfter)
+}
+
+// ReconnectStorm is a user or IP connecting more often than the threshold
+type ReconnectStorm struct {
+	Key        string `json:"key"`         // "user:<id>" or "ip:<address>"
+	Connects   int    `json:"connects"`    // Attempts in the current window
+	Since      int64  `json:"since"`       // Unix seconds the window started
+	RetryAfter int    `json:"retry_after"` // Seconds the client is told to wait
+}
+
+// connectWindow counts one user's or IP's connect attempts
+type connectWindow struct {
+	start    time.Time
+	connects int
+}
+
+// reconnectTracker counts connect attempts per user and per IP
+type reconnectTracker struct {
+	mu      sync.Mutex
+	windows map[string]*connectWindow
+}
+
+// connectKeys returns the keys a connect attempt is counted under
+func connectKeys(userID, ip string) []string {
+	keys := make([]string, 0, 2)
+	if userID != "" {
+		keys = append(keys, "user:"+userID)
+	}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// recordConnect counts a connect attempt and returns the retry hint for
+// the client, should it be refused or later disconnected
+func (s *Server) recordConnect(userID, ip string) time.Duration {
+	config := s.config.Reconnect
+	now := s.now()
+	t := &s.reconnects
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.windows == nil {
+		t.windows = make(map[string]*connectWindow)
+	}
+
+	hint := config.RetryAfter
+	for _, key := range connectKeys(userID, ip) {
+		w, exists := t.windows[key]
+		if !exists || now.Sub(w.start) >= config.StormWindow {
+			w = &connectWindow{start: now}
+			t.windows[key] = w
+		}
+		w.connects++
+		if w.connects == config.StormThreshold+1 {
+			s.metrics.reconnectStorms.Add(1)
+			log.Printf("Reconnect storm from %s: over %d connects in %s", key, config.StormThreshold, config.StormWindow)
+		}
+		hint = max(hint, config.retryAfter(w.connects))
+	}
+	return hint
+}
+
+// retryAfter returns the retry hint for a user and IP without counting a connect
+func (s *Server) retryAfter(userID, ip string) time.Duration {
+	config := s.config.Reconnect
+	now := s.now()
+	t := &s.reconnects
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hint := config.RetryAfter
+	for _, key := range connectKeys(userID, ip) {
+		if w, exists := t.windows[key]; exists && now.Sub(w.start) < config.StormWindow {
+			hint = max(hint, config.retryAfter(w.connects))
+		}
+	}
+	return hint
+}
+
+// ReconnectStorms lists the users and IPs over the storm threshold in their
+// current window, busiest first
+func (s *Server) ReconnectStorms() []ReconnectStorm {
+	config := s.config.Reconnect
+	now := s.now()
+	t := &s.reconnects
+	t.mu.Lock()
+	storms := make([]ReconnectStorm, 0)
+	for key, w := range t.windows {
+		if w.connects <= config.StormThreshold || now.Sub(w.start) >= config.StormWindow {
+			continue
+		}
+		storms = append(storms, ReconnectStorm{
+			Key:        key,
+			Connects:   w.connects,
+			Since:      w.start.Unix(),
+			RetryAfter: retrySeconds(config.retryAfter(w.connects)),
+		})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(storms, func(i, j int) bool {
+		if storms[i].Connects != storms[j].Connects {
+			return storms[i].Connects > storms[j].Connects
+		}
+		return storms[i].Key < storms[j].Key
+	})
+	return storms
+}
+
+// pruneReconnects forgets windows that have ended
+func (s *Server) pruneReconnects() {
+	now := s.now()
+	t := &s.reconnects
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, w := range t.windows {
+		if now.Sub(w.start) >= s.config.Reconnect.StormWindow {
+			delete(t.windows, key)
+		}
+	}
+}
+
+// retrySeconds rounds a hint up to whole seconds for clients
+func retrySeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// withRetryAfter appends a retry hint to a close reason
+func withRetryAfter(reason string, d time.Duration) string {
+	return fmt.Sprintf("%s; retry_after=%d", reason, retrySeconds(d))
+}
+
+// retryReason appends a connection's retry hint to a close reason
+func (s *Server) retryReason(conn *Connection, reason string) string {
+	return withRetryAfter(reason, s.retryAfter(conn.UserID, conn.Client.IP))
+}
+
+// refuseConnection tells a client whose connection couldn't be registered
+// why, and when to try again if it should
+func (s *Server) refuseConnection(ws *websocket.Conn, err error, retry time.Duration) {
+	code, reason := closeCodeFor(err), err.Error()
+	if ShouldReconnect(code) {
+		errorCode := "server_full"
+		if code == websocket.CloseServiceRestart {
+			errorCode = "draining"
+		}
+		ws.SetWriteDeadline(time.Now().Add(time.Second))
+		writeMessage(ws, &Message{
+			ID:        generateMessageID(),
+			Type:      MessageTypeError,
+			Sender:    "system",
+			Timestamp: s.now().Unix(),
+			Payload: map[string]interface{}{
+				"code":        errorCode,
+				"error":       reason,
+				"retry_after": retrySeconds(retry),
+			},
+		})
+		reason = withRetryAfter(reason, retry)
+	}
+	writeCloseFrame(ws, code, reason)
+}
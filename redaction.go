@@ -0,0 +1,213 @@
+package wssocket
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MetadataRedacted records how many matches were masked in a message
+const MetadataRedacted = "redacted"
+
+// RedactionRule masks every match of a pattern in payload text
+type RedactionRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Mask    string            // Replacement for each match; "" masks every character with *
+	Accept  func(string) bool // Optional check on each match; rejected matches are left alone
+}
+
+// Built-in redaction rules
+var (
+	RedactEmails = RedactionRule{
+		Name:    "emails",
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+		Mask:    "[email]",
+	}
+	RedactCardNumbers = RedactionRule{
+		Name:    "cards",
+		Pattern: regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`),
+		Mask:    "[card]",
+		Accept:  luhnValid,
+	}
+)
+
+// ProfanityRule masks whole words from a list, ignoring case
+func ProfanityRule(words ...string) RedactionRule {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		// Matches nothing
+		return RedactionRule{Name: "profanity", Pattern: regexp.MustCompile(`[^\s\S]`)}
+	}
+	return RedactionRule{
+		Name:    "profanity",
+		Pattern: regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`),
+	}
+}
+
+// Redactor applies a set of rules to message payloads
+type Redactor struct {
+	Rules []RedactionRule
+}
+
+// NewRedactor creates a redactor from rules applied in order
+func NewRedactor(rules ...RedactionRule) *Redactor {
+	return &Redactor{Rules: rules}
+}
+
+// RedactText masks every rule's matches and returns the text and match count
+func (r *Redactor) RedactText(text string) (string, int) {
+	count := 0
+	for _, rule := range r.Rules {
+		text = rule.Pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if rule.Accept != nil && !rule.Accept(match) {
+				return match
+			}
+			count++
+			if rule.Mask == "" {
+				return strings.Repeat("*", utf8.RuneCountInString(match))
+			}
+			return rule.Mask
+		})
+	}
+	return text, count
+}
+
+// redactValue masks the strings in a payload value, descending into maps and lists
+func (r *Redactor) redactValue(value interface{}) (interface{}, int) {
+	switch v := value.(type) {
+	case string:
+		return r.RedactText(v)
+	case map[string]interface{}:
+		total := 0
+		for key, item := range v {
+			redacted, n := r.redactValue(item)
+			if n > 0 {
+				v[key] = redacted
+				total += n
+			}
+		}
+		return v, total
+	case []interface{}:
+		total := 0
+		for i, item := range v {
+			redacted, n := r.redactValue(item)
+			if n > 0 {
+				v[i] = redacted
+				total += n
+			}
+		}
+		return v, total
+	}
+	return value, 0
+}
+
+// SetRedactor sets the redactor for a channel. A pattern ending in "*"
+// applies to every channel with that prefix, so "acme:*" covers a tenant and
+// "*" covers everything, direct messages included. Exact names win, then the
+// longest prefix. A nil redactor removes the entry.
+func (s *Server) SetRedactor(pattern string, r *Redactor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r == nil {
+		delete(s.redactors, pattern)
+		return
+	}
+	if s.redactors == nil {
+		s.redactors = make(map[string]*Redactor)
+	}
+	s.redactors[pattern] = r
+}
+
+// redactorFor resolves the redactor for a channel; the caller holds s.mu
+func (s *Server) redactorFor(channel string) *Redactor {
+	if r, exists := s.redactors[channel]; exists {
+		return r
+	}
+	best := -1
+	var redactor *Redactor
+	for pattern, r := range s.redactors {
+		prefix, isPrefix := strings.CutSuffix(pattern, "*")
+		if isPrefix && strings.HasPrefix(channel, prefix) && len(prefix) > best {
+			best, redactor = len(prefix), r
+		}
+	}
+	return redactor
+}
+
+// redact masks a message's payload text with its channel's redactor
+func (s *Server) redact(msg *Message) {
+	s.mu.RLock()
+	r := s.redactorFor(msg.Channel)
+	s.mu.RUnlock()
+	if r == nil || msg.Payload == nil {
+		return
+	}
+
+	if _, n := r.redactValue(msg.Payload); n > 0 {
+		if msg.Metadata == nil {
+			msg.Metadata = make(map[string]interface{})
+		}
+		msg.Metadata[MetadataRedacted] = n
+	}
+}
+
+// ParseRedactionRules parses "channel=rule+rule" entries separated by commas,
+// e.g. "*=cards,support=emails+cards,acme:*=profanity". Rules are emails,
+// cards and profanity, which masks the given words.
+func ParseRedactionRules(s string, profanity []string) (map[string]*Redactor, error) {
+	redactors := make(map[string]*Redactor)
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		channel, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("redaction entry must be channel=rules: %s", entry)
+		}
+		var rules []RedactionRule
+		for _, name := range strings.Split(spec, "+") {
+			switch strings.TrimSpace(name) {
+			case "emails":
+				rules = append(rules, RedactEmails)
+			case "cards":
+				rules = append(rules, RedactCardNumbers)
+			case "profanity":
+				if len(profanity) == 0 {
+					return nil, fmt.Errorf("profanity redaction for %s needs a word list", channel)
+				}
+				rules = append(rules, ProfanityRule(profanity...))
+			default:
+				return nil, fmt.Errorf("unknown redaction rule for %s: %s", channel, name)
+			}
+		}
+		redactors[channel] = NewRedactor(rules...)
+	}
+	return redactors, nil
+}
+
+// luhnValid reports whether the digits in s pass the Luhn checksum
+func luhnValid(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,73 @@
+package wssocket
+
+import "testing"
+
+func TestRedactionPerChannel(t *testing.T) {
+	redactors, err := ParseRedactionRules("support=emails+cards, acme:*=profanity, *=cards", []string{"darn", "heck"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(ServerConfig{})
+	t.Cleanup(server.Stop)
+	for pattern, r := range redactors {
+		server.SetRedactor(pattern, r)
+	}
+
+	tests := []struct {
+		name, channel, text, want string
+		count                     int
+	}{
+		{"email", "support", "write to bob@example.com today", "write to [email] today", 1},
+		{"email with tags and subdomain", "support", "a.b+c@mail.example.co.uk", "[email]", 1},
+		{"no domain suffix", "support", "bob@localhost", "bob@localhost", 0},
+		{"card with spaces", "support", "card 4111 1111 1111 1111 ok", "card [card] ok", 1},
+		{"card with dashes", "support", "5500-0000-0000-0004", "[card]", 1},
+		{"card and email", "support", "bob@example.com 4111111111111111", "[email] [card]", 2},
+		{"digits failing the checksum", "support", "order 4111111111111112", "order 4111111111111112", 0},
+		{"too few digits", "support", "call 123456789012", "call 123456789012", 0},
+		{"too many digits", "support", "id 41111111111111111111", "id 41111111111111111111", 0},
+		{"digits inside a word", "support", "ref4111111111111111", "ref4111111111111111", 0},
+		{"listed word", "acme:general", "Darn it", "**** it", 1},
+		{"listed word before punctuation", "acme:general", "what the heck!", "what the ****!", 1},
+		{"listed word inside another word", "acme:general", "darning socks, check", "darning socks, check", 0},
+		{"tenant channel keeps emails", "acme:general", "bob@example.com", "bob@example.com", 0},
+		{"catch-all masks cards", "random", "4111 1111 1111 1111", "[card]", 1},
+		{"catch-all keeps emails and words", "random", "darn bob@example.com", "darn bob@example.com", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &Message{Channel: tt.channel, Payload: map[string]interface{}{"content": tt.text}}
+			server.redact(msg)
+			if got := msg.Payload["content"]; got != tt.want {
+				t.Fatalf("content = %q, want %q", got, tt.want)
+			}
+			count, _ := msg.Metadata[MetadataRedacted].(int)
+			if count != tt.count {
+				t.Fatalf("redacted count = %d, want %d", count, tt.count)
+			}
+		})
+	}
+}
+
+func TestRedactionReachesNestedPayloads(t *testing.T) {
+	r := NewRedactor(RedactEmails)
+	payload := map[string]interface{}{
+		"content": "hi",
+		"card":    map[string]interface{}{"contact": "bob@example.com"},
+		"cc":      []interface{}{"carol@example.com", 3},
+	}
+	if _, n := r.redactValue(payload); n != 2 {
+		t.Fatalf("redacted %d values, want 2", n)
+	}
+	if payload["card"].(map[string]interface{})["contact"] != "[email]" || payload["cc"].([]interface{})[0] != "[email]" {
+		t.Fatalf("payload = %v, want nested emails masked", payload)
+	}
+}
+
+func TestParseRedactionRulesErrors(t *testing.T) {
+	for _, spec := range []string{"support", "support=phones", "support=profanity"} {
+		if _, err := ParseRedactionRules(spec, nil); err == nil {
+			t.Fatalf("parsed %q, want an error", spec)
+		}
+	}
+}
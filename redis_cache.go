@@ -0,0 +1,482 @@
+package wssocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Cache defaults
+const (
+	DefaultCacheHistory = DefaultPageLimit // Newest messages cached per channel
+	DefaultCacheTTL     = 10 * time.Minute // How long cached history and membership live before a reload
+)
+
+// CacheConfig sizes the Redis cache
+type CacheConfig struct {
+	History int           // Newest messages cached per channel; 0 uses DefaultCacheHistory
+	TTL     time.Duration // Time before cached entries are reloaded from PostgreSQL; 0 uses DefaultCacheTTL
+}
+
+// withDefaults fills in the history size and TTL
+func (c CacheConfig) withDefaults() CacheConfig {
+	if c.History <= 0 {
+		c.History = DefaultCacheHistory
+	}
+	if c.History > MaxPageLimit {
+		c.History = MaxPageLimit
+	}
+	if c.TTL <= 0 {
+		c.TTL = DefaultCacheTTL
+	}
+	return c
+}
+
+// CachedDatabase keeps each channel's newest messages and its members in
+// Redis, so join-time history replay and member lookups don't reach
+// PostgreSQL. Saved messages are written through to the cache; deletes and
+// role changes drop the affected entries. Everything else goes straight to
+// the embedded Database.
+//
+// A channel's history is cached as a list of recent messages, which may
+// hold a message twice, and a marker key saying it was loaded in full.
+// Members are a hash of user ID to member, with an empty field as its marker.
+type CachedDatabase struct {
+	*Database
+	client *RedisClient
+	prefix string
+	config CacheConfig
+
+	pending *[]func() // Cache updates waiting for the transaction to commit; see WithTx
+}
+
+// NewCachedDatabase caches db in Redis under keys starting with prefix,
+// "gows:" by default
+func NewCachedDatabase(db *Database, client *RedisClient, prefix string, config CacheConfig) *CachedDatabase {
+	if prefix == "" {
+		prefix = "gows:"
+	}
+	return &CachedDatabase{Database: db, client: client, prefix: prefix, config: config.withDefaults()}
+}
+
+func (c *CachedDatabase) historyKey(channel string) string { return c.prefix + "history:" + channel }
+func (c *CachedDatabase) historyLoadedKey(channel string) string {
+	return c.prefix + "history_loaded:" + channel
+}
+func (c *CachedDatabase) membersKey(channel string) string { return c.prefix + "members:" + channel }
+
+// WithTx runs fn in a transaction. Reads in it skip the cache, and cache
+// updates wait until it commits, so a rollback leaves the cache untouched.
+func (c *CachedDatabase) WithTx(ctx context.Context, fn func(tx Tx) error) error {
+	if c.pending != nil {
+		return fn(c)
+	}
+	var pending []func()
+	err := c.Database.WithTx(ctx, func(tx Tx) error {
+		return fn(&CachedDatabase{Database: tx.(*Database), client: c.client, prefix: c.prefix, config: c.config, pending: &pending})
+	})
+	if err == nil {
+		for _, apply := range pending {
+			apply()
+		}
+	}
+	return err
+}
+
+// deferred queues fn until c's transaction commits, reporting false when c
+// isn't in one
+func (c *CachedDatabase) deferred(fn func(c *CachedDatabase)) bool {
+	if c.pending == nil {
+		return false
+	}
+	committed := *c
+	committed.pending = nil
+	*c.pending = append(*c.pending, func() { fn(&committed) })
+	return true
+}
+
+// ttl returns the cache TTL as a PEXPIRE argument
+func (c *CachedDatabase) ttl() string { return fmt.Sprint(c.config.TTL.Milliseconds()) }
+
+// SaveMessage saves a message and adds it to its channel's cached history
+func (c *CachedDatabase) SaveMessage(msg *Message) error {
+	if err := c.Database.SaveMessage(msg); err != nil {
+		return err
+	}
+	c.cacheMessage(msg)
+	return nil
+}
+
+// SaveMessages saves messages and adds the inserted ones to the cache
+func (c *CachedDatabase) SaveMessages(msgs []*Message) ([]SaveStatus, error) {
+	statuses, err := c.Database.SaveMessages(msgs)
+	if err != nil {
+		return nil, err
+	}
+	for i, status := range statuses {
+		if status == SaveStatusSaved {
+			c.cacheMessage(msgs[i])
+		}
+	}
+	return statuses, nil
+}
+
+// cacheMessage pushes a saved message onto its channel's history. The list
+// is kept at twice the cached size so duplicates never crowd out a message.
+// If the push fails the channel is reloaded on its next read.
+func (c *CachedDatabase) cacheMessage(msg *Message) {
+	if msg.Channel == "" || c.deferred(func(c *CachedDatabase) { c.cacheMessage(msg) }) {
+		return
+	}
+	stored := *msg
+	if stored.Type == "" {
+		stored.Type = MessageTypeChat
+	}
+	data, err := json.Marshal(&stored)
+	if err == nil {
+		key := c.historyKey(msg.Channel)
+		if _, err = c.client.Do("LPUSH", key, string(data)); err == nil {
+			if _, err = c.client.Do("LTRIM", key, "0", fmt.Sprint(2*c.config.History-1)); err == nil {
+				_, err = c.client.Do("PEXPIRE", key, c.ttl())
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Error caching message %s: %v", msg.ID, err)
+		c.invalidateHistory(msg.Channel)
+	}
+}
+
+// GetChannelMessages serves the newest page of a channel from the cache,
+// loading it on a miss. Older pages come from PostgreSQL.
+func (c *CachedDatabase) GetChannelMessages(channel string, page Page) ([]*Message, error) {
+	page = page.normalize()
+	if page.Before != nil || page.Offset > 0 || page.Limit > c.config.History || c.pending != nil {
+		return c.Database.GetChannelMessages(channel, page)
+	}
+
+	msgs, err := c.cachedHistory(channel)
+	if err != nil {
+		log.Printf("Error reading cached history of %s: %v", channel, err)
+	}
+	if msgs == nil {
+		if msgs, err = c.loadHistory(channel); err != nil {
+			return nil, err
+		}
+	}
+	if len(msgs) > page.Limit {
+		msgs = msgs[len(msgs)-page.Limit:]
+	}
+	return msgs, nil
+}
+
+// cachedHistory returns a channel's cached messages oldest first, or nil
+// when they aren't loaded
+func (c *CachedDatabase) cachedHistory(channel string) ([]*Message, error) {
+	if _, err := c.client.Do("GET", c.historyLoadedKey(channel)); err != nil {
+		if err == redisNil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	reply, err := c.client.Do("LRANGE", c.historyKey(channel), "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+
+	seen := make(map[string]bool, len(items))
+	msgs := make([]*Message, 0, len(items))
+	for _, item := range items {
+		data, _ := item.(string)
+		var msg Message
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			return nil, fmt.Errorf("decode cached message: %w", err)
+		}
+		if !seen[msg.ID] {
+			seen[msg.ID] = true
+			msgs = append(msgs, &msg)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		if msgs[i].Timestamp != msgs[j].Timestamp {
+			return msgs[i].Timestamp < msgs[j].Timestamp
+		}
+		return msgs[i].ID < msgs[j].ID
+	})
+	if len(msgs) > c.config.History {
+		msgs = msgs[len(msgs)-c.config.History:]
+	}
+	return msgs, nil
+}
+
+// loadHistory reads a channel's newest messages from PostgreSQL and caches
+// them. Messages saved meanwhile are already in the list, so appending
+// these after them loses nothing.
+func (c *CachedDatabase) loadHistory(channel string) ([]*Message, error) {
+	msgs, err := c.Database.GetChannelMessages(channel, Page{Limit: c.config.History})
+	if err != nil {
+		return nil, err
+	}
+
+	key := c.historyKey(channel)
+	args := []string{"RPUSH", key}
+	for i := len(msgs) - 1; i >= 0; i-- {
+		data, err := json.Marshal(msgs[i])
+		if err != nil {
+			return msgs, nil
+		}
+		args = append(args, string(data))
+	}
+	if len(msgs) > 0 {
+		if _, err = c.client.Do(args...); err == nil {
+			_, err = c.client.Do("LTRIM", key, "0", fmt.Sprint(2*c.config.History-1))
+		}
+	}
+	if err == nil {
+		if _, err = c.client.Do("PEXPIRE", key, c.ttl()); err == nil {
+			_, err = c.client.Do("SET", c.historyLoadedKey(channel), "1", "PX", c.ttl())
+		}
+	}
+	if err != nil {
+		log.Printf("Error caching history of %s: %v", channel, err)
+	}
+	return msgs, nil
+}
+
+// invalidateHistory drops a channel's cached history
+func (c *CachedDatabase) invalidateHistory(channel string) {
+	if c.deferred(func(c *CachedDatabase) { c.invalidateHistory(channel) }) {
+		return
+	}
+	if _, err := c.client.Do("DEL", c.historyLoadedKey(channel), c.historyKey(channel)); err != nil {
+		log.Printf("Error invalidating cached history of %s: %v", channel, err)
+	}
+}
+
+// invalidateMessage drops the cached history holding a message
+func (c *CachedDatabase) invalidateMessage(msg *Message) {
+	if msg != nil && msg.Channel != "" {
+		c.invalidateHistory(msg.Channel)
+	}
+}
+
+// DeleteMessage deletes a message and drops its channel's cached history
+func (c *CachedDatabase) DeleteMessage(id string) error {
+	msg, _ := c.Database.GetMessage(id)
+	if err := c.Database.DeleteMessage(id); err != nil {
+		return err
+	}
+	c.invalidateMessage(msg)
+	return nil
+}
+
+// ClearChannel deletes a channel's messages and its cached history
+func (c *CachedDatabase) ClearChannel(channel string) error {
+	if err := c.Database.ClearChannel(channel); err != nil {
+		return err
+	}
+	c.invalidateHistory(channel)
+	return nil
+}
+
+// DeleteMessagesBefore deletes a channel's older messages and drops its
+// cached history
+func (c *CachedDatabase) DeleteMessagesBefore(channel string, before int64) (int, error) {
+	n, err := c.Database.DeleteMessagesBefore(channel, before)
+	if err == nil && n > 0 {
+		c.invalidateHistory(channel)
+	}
+	return n, err
+}
+
+// DeleteExpiredMessages deletes expired messages and drops the cached
+// history of each channel they were in
+func (c *CachedDatabase) DeleteExpiredMessages(now int64) (map[string]int, error) {
+	deleted, err := c.Database.DeleteExpiredMessages(now)
+	for channel := range deleted {
+		c.invalidateHistory(channel)
+	}
+	return deleted, err
+}
+
+// UpdateContent rewrites a message's content and drops its channel's
+// cached history
+func (c *CachedDatabase) UpdateContent(id, oldContent, newContent string) error {
+	msg, _ := c.Database.GetMessage(id)
+	if err := c.Database.UpdateContent(id, oldContent, newContent); err != nil {
+		return err
+	}
+	c.invalidateMessage(msg)
+	return nil
+}
+
+// EraseUserMessages removes a user from stored messages and drops the cached
+// history of each channel that changed
+func (c *CachedDatabase) EraseUserMessages(userID string, now int64) (*MessageErasure, error) {
+	erasure, err := c.Database.EraseUserMessages(userID, now)
+	if err != nil {
+		return nil, err
+	}
+	for channel := range erasure.Channels {
+		c.invalidateHistory(channel)
+	}
+	return erasure, nil
+}
+
+// GetChannelRole returns a user's role from the cached members
+func (c *CachedDatabase) GetChannelRole(channel, userID string) (ChannelRole, error) {
+	member, err := c.GetChannelMember(channel, userID)
+	if err != nil {
+		return "", err
+	}
+	if member == nil {
+		return RoleMember, nil
+	}
+	return member.Role, nil
+}
+
+// GetChannelMember returns a user's recorded role from the cached members,
+// or nil when there is none
+func (c *CachedDatabase) GetChannelMember(channel, userID string) (*ChannelMember, error) {
+	if c.pending != nil {
+		return c.Database.GetChannelMember(channel, userID)
+	}
+	reply, err := c.client.Do("HMGET", c.membersKey(channel), "", userID)
+	if err != nil {
+		log.Printf("Error reading cached members of %s: %v", channel, err)
+		return c.Database.GetChannelMember(channel, userID)
+	}
+	if fields, _ := reply.([]interface{}); len(fields) == 2 && fields[0] != nil {
+		data, ok := fields[1].(string)
+		if !ok {
+			return nil, nil
+		}
+		var member ChannelMember
+		if err := json.Unmarshal([]byte(data), &member); err != nil {
+			return nil, fmt.Errorf("decode cached member %s of %s: %w", userID, channel, err)
+		}
+		return &member, nil
+	}
+
+	members, err := c.loadMembers(channel)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if member.UserID == userID {
+			return member, nil
+		}
+	}
+	return nil, nil
+}
+
+// ListChannelMembers returns a channel's cached members, most senior first
+func (c *CachedDatabase) ListChannelMembers(channel string) ([]*ChannelMember, error) {
+	if c.pending != nil {
+		return c.Database.ListChannelMembers(channel)
+	}
+	reply, err := c.client.Do("HGETALL", c.membersKey(channel))
+	if err != nil {
+		log.Printf("Error reading cached members of %s: %v", channel, err)
+		return c.Database.ListChannelMembers(channel)
+	}
+	fields, _ := reply.([]interface{})
+	loaded := false
+	members := make([]*ChannelMember, 0, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		field, _ := fields[i].(string)
+		if field == "" {
+			loaded = true
+			continue
+		}
+		data, _ := fields[i+1].(string)
+		var member ChannelMember
+		if err := json.Unmarshal([]byte(data), &member); err != nil {
+			return nil, fmt.Errorf("decode cached member %s of %s: %w", field, channel, err)
+		}
+		members = append(members, &member)
+	}
+	if !loaded {
+		return c.loadMembers(channel)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Role != members[j].Role {
+			return members[i].Role.rank() > members[j].Role.rank()
+		}
+		return members[i].UserID < members[j].UserID
+	})
+	return members, nil
+}
+
+// loadMembers reads a channel's members from PostgreSQL and caches them
+func (c *CachedDatabase) loadMembers(channel string) ([]*ChannelMember, error) {
+	members, err := c.Database.ListChannelMembers(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	key := c.membersKey(channel)
+	args := []string{"HSET", key, "", "1"}
+	for _, member := range members {
+		data, err := json.Marshal(member)
+		if err != nil {
+			return members, nil
+		}
+		args = append(args, member.UserID, string(data))
+	}
+	if _, err = c.client.Do("DEL", key); err == nil {
+		if _, err = c.client.Do(args...); err == nil {
+			_, err = c.client.Do("PEXPIRE", key, c.ttl())
+		}
+	}
+	if err != nil {
+		log.Printf("Error caching members of %s: %v", channel, err)
+	}
+	return members, nil
+}
+
+// invalidateMembers drops a channel's cached members
+func (c *CachedDatabase) invalidateMembers(channel string) {
+	if c.deferred(func(c *CachedDatabase) { c.invalidateMembers(channel) }) {
+		return
+	}
+	if _, err := c.client.Do("DEL", c.membersKey(channel)); err != nil {
+		log.Printf("Error invalidating cached members of %s: %v", channel, err)
+	}
+}
+
+// SetChannelRole records a user's role and drops the channel's cached members
+func (c *CachedDatabase) SetChannelRole(member *ChannelMember) error {
+	if err := c.Database.SetChannelRole(member); err != nil {
+		return err
+	}
+	c.invalidateMembers(member.Channel)
+	return nil
+}
+
+// RemoveChannelMember deletes a user's role and drops the channel's cached
+// members
+func (c *CachedDatabase) RemoveChannelMember(channel, userID string) error {
+	if err := c.Database.RemoveChannelMember(channel, userID); err != nil {
+		return err
+	}
+	c.invalidateMembers(channel)
+	return nil
+}
+
+// EraseUser erases a user and drops the cached members of every channel
+// they were in
+func (c *CachedDatabase) EraseUser(userID string, now int64) error {
+	channels, err := c.Database.eraseUser(userID, now)
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		c.invalidateMembers(channel)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package wssocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestRedis starts an in-process Redis and a client connected to it
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *RedisClient) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client, err := NewRedisClient("redis://" + mr.Addr() + "/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+func TestRedisClientReplies(t *testing.T) {
+	mr, client := newTestRedis(t)
+	mr.Set("name", "alice")
+	mr.Lpush("list", "x")
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    interface{}
+		wantErr error
+	}{
+		{"status", []string{"PING"}, "PONG", nil},
+		{"bulk", []string{"GET", "name"}, "alice", nil},
+		{"nil bulk", []string{"GET", "missing"}, nil, redisNil},
+		{"integer", []string{"INCR", "counter"}, int64(1), nil},
+		{"array with nil", []string{"MGET", "name", "missing"}, []interface{}{"alice", nil}, nil},
+		{"empty array", []string{"SMEMBERS", "missing"}, []interface{}{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.Do(tt.args...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !equalRedisReply(got, tt.want) {
+				t.Fatalf("reply = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	// An error reply is an error, but not a missing value
+	if _, err := client.Do("GET", "list"); err == nil || errors.Is(err, redisNil) {
+		t.Fatalf("GET on a list: err = %v, want a WRONGTYPE error", err)
+	}
+	// The connection is still usable after an error reply
+	if got, err := client.Do("GET", "name"); err != nil || got != "alice" {
+		t.Fatalf("GET after an error = %v, %v", got, err)
+	}
+}
+
+func TestRedisClientReconnects(t *testing.T) {
+	mr, client := newTestRedis(t)
+	if _, err := client.Do("SET", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	mr.Restart()
+	mr.Set("k", "v")
+	var err error
+	for range 3 {
+		if _, err = client.Do("GET", "k"); err == nil {
+			return
+		}
+	}
+	t.Fatalf("GET after a restart: %v", err)
+}
+
+func TestRedisStoresRoundTrip(t *testing.T) {
+	mr, client := newTestRedis(t)
+
+	sessions := NewRedisSessionStore(client, "")
+	for _, id := range []string{"s1", "s2"} {
+		rec := &SessionRecord{ID: id, UserID: "alice", ExpiresAt: time.Now().Add(time.Hour)}
+		if err := sessions.SaveSession(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mr.Del("gows:session:s2") // As if it expired
+	records, err := sessions.ListUserSessions("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != "s1" {
+		t.Fatalf("sessions = %v, want only s1", records)
+	}
+	if members, _ := mr.Members("gows:user_sessions:alice"); len(members) != 1 {
+		t.Fatalf("index = %v, want the expired session pruned", members)
+	}
+	if _, err := sessions.GetSession("s2"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expired session: err = %v, want ErrSessionNotFound", err)
+	}
+
+	cluster := NewRedisClusterStore(client, "")
+	if err := cluster.Send("node-1", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := cluster.Receive("node-1", time.Second)
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("receive = %q, %v", data, err)
+	}
+	if data, err := cluster.Receive("node-1", time.Second); err != nil || data != nil {
+		t.Fatalf("receive from an empty inbox = %q, %v, want nothing", data, err)
+	}
+}
+
+// equalRedisReply compares replies, including arrays
+func equalRedisReply(got, want interface{}) bool {
+	wantItems, ok := want.([]interface{})
+	if !ok {
+		return got == want
+	}
+	gotItems, ok := got.([]interface{})
+	if !ok || len(gotItems) != len(wantItems) {
+		return false
+	}
+	for i := range wantItems {
+		if gotItems[i] != wantItems[i] {
+			return false
+		}
+	}
+	return true
+}
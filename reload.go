@@ -0,0 +1,198 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// ReloadableConfig holds the settings that can change without a restart.
+// Fields left out are unchanged.
+type ReloadableConfig struct {
+	RateLimit      *RateLimit       `json:"rate_limit,omitempty"`
+	AllowedOrigins *[]string        `json:"allowed_origins,omitempty"`
+	MaxConnections *int             `json:"max_connections,omitempty"`
+	Retention      *RetentionConfig `json:"retention,omitempty"`
+}
+
+// RetentionConfig is the reloadable form of the retention policies. Setting
+// it replaces the default and every per-channel policy.
+type RetentionConfig struct {
+	Default  string            `json:"default"`            // e.g. "30d+archive"; "" or "0" keeps messages forever
+	Policies map[string]string `json:"policies,omitempty"` // channel or "prefix*" -> policy
+}
+
+// ConfigReloader applies configuration changes to a running server. Existing
+// connections are kept; new limits apply from their next message or connect.
+type ConfigReloader struct {
+	server  *Server
+	janitor *RetentionJanitor // nil when retention is disabled
+	path    string            // JSON file read by ReloadFile; may be empty
+
+	mu sync.Mutex // Serializes reloads
+}
+
+// NewConfigReloader creates a reloader. path is the JSON config file re-read
+// on SIGHUP; janitor may be nil if retention is not running.
+func NewConfigReloader(server *Server, janitor *RetentionJanitor, path string) *ConfigReloader {
+	return &ConfigReloader{server: server, janitor: janitor, path: path}
+}
+
+// Apply validates every field of cfg and then applies them together, so a
+// bad value leaves the running configuration untouched
+func (c *ConfigReloader) Apply(cfg ReloadableConfig) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cfg.RateLimit != nil && (cfg.RateLimit.PerSecond < 0 || cfg.RateLimit.Burst < 0 || cfg.RateLimit.Disconnect < 0) {
+		return fmt.Errorf("rate limit must not be negative")
+	}
+	if cfg.MaxConnections != nil && *cfg.MaxConnections <= 0 {
+		return fmt.Errorf("max connections must be positive")
+	}
+
+	var fallback RetentionPolicy
+	var policies map[string]RetentionPolicy
+	if cfg.Retention != nil {
+		if c.janitor == nil {
+			return fmt.Errorf("retention is not enabled on this server")
+		}
+		var err error
+		if cfg.Retention.Default != "" {
+			if fallback, err = ParseRetentionPolicy(cfg.Retention.Default); err != nil {
+				return fmt.Errorf("invalid default retention: %w", err)
+			}
+		}
+		policies = make(map[string]RetentionPolicy, len(cfg.Retention.Policies))
+		for channel, spec := range cfg.Retention.Policies {
+			if policies[channel], err = ParseRetentionPolicy(spec); err != nil {
+				return fmt.Errorf("invalid retention for %s: %w", channel, err)
+			}
+		}
+	}
+
+	if cfg.RateLimit != nil {
+		c.server.SetRateLimit(*cfg.RateLimit)
+		log.Printf("config: rate limit set to %g/s, burst %d", cfg.RateLimit.PerSecond, cfg.RateLimit.Burst)
+	}
+	if cfg.AllowedOrigins != nil {
+		c.server.SetAllowedOrigins(*cfg.AllowedOrigins)
+		log.Printf("config: allowed origins set to %v", c.server.AllowedOrigins())
+	}
+	if cfg.MaxConnections != nil {
+		c.server.SetMaxConnections(*cfg.MaxConnections)
+		log.Printf("config: max connections set to %d", *cfg.MaxConnections)
+	}
+	if cfg.Retention != nil {
+		c.janitor.SetPolicies(fallback, policies)
+		log.Printf("config: retention set to %s with %d channel policies", fallback, len(policies))
+	}
+	return nil
+}
+
+// ReloadFile re-reads the config file and applies it
+func (c *ConfigReloader) ReloadFile() error {
+	if c.path == "" {
+		return fmt.Errorf("no config file configured")
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	var cfg ReloadableConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("parse config %s: %w", c.path, err)
+	}
+	return c.Apply(cfg)
+}
+
+// Current returns the configuration in effect
+func (c *ConfigReloader) Current() ReloadableConfig {
+	limit := c.server.RateLimit()
+	origins := c.server.AllowedOrigins()
+	maxConnections := c.server.MaxConnections()
+	cfg := ReloadableConfig{
+		RateLimit:      &limit,
+		AllowedOrigins: &origins,
+		MaxConnections: &maxConnections,
+	}
+	if c.janitor != nil {
+		fallback, policies := c.janitor.Policies()
+		cfg.Retention = &RetentionConfig{Default: fallback.String(), Policies: make(map[string]string, len(policies))}
+		for channel, policy := range policies {
+			cfg.Retention.Policies[channel] = policy.String()
+		}
+	}
+	return cfg
+}
+
+// WatchSignals reloads the config file whenever the process receives SIGHUP.
+// Call the returned function to stop watching.
+func (c *ConfigReloader) WatchSignals() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+				if err := c.ReloadFile(); err != nil {
+					log.Printf("config reload failed: %v", err)
+				} else {
+					log.Printf("config reloaded from %s", c.path)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// setupConfigAdminRoutes registers the runtime configuration endpoints
+func setupConfigAdminRoutes(mux *http.ServeMux, reloader *ConfigReloader, apiKeys []string) {
+	// GET shows the configuration in effect; POST applies a JSON body, or
+	// re-reads the config file when the body is empty
+	mux.HandleFunc("/api/admin/config", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(body) == 0 {
+				err = reloader.ReloadFile()
+			} else {
+				var cfg ReloadableConfig
+				if err = json.Unmarshal(body, &cfg); err == nil {
+					err = reloader.Apply(cfg)
+				}
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, reloader.Current())
+	})
+}
@@ -0,0 +1,58 @@
+package wssocket
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReplayGuard rejects inbound messages that look replayed: a client timestamp
+// too far from the server clock, or a message ID the sender already used
+// within the window. Messages without a client ID are only checked for skew.
+// Message IDs are remembered in the server's message window, shared with
+// duplicate detection.
+type ReplayGuard struct {
+	maxSkew time.Duration // Largest allowed difference between client and server clocks
+	window  time.Duration // How long a (sender, id) pair is remembered
+}
+
+// NewReplayGuard creates a guard; a zero maxSkew or window disables that check
+func NewReplayGuard(maxSkew, window time.Duration) *ReplayGuard {
+	return &ReplayGuard{maxSkew: maxSkew, window: window}
+}
+
+// checkSkew rejects a client timestamp too far from now on the server's clock
+func (g *ReplayGuard) checkSkew(msg *Message, now time.Time) error {
+	if g.maxSkew <= 0 || msg.Timestamp == 0 {
+		return nil
+	}
+	skew := now.Sub(time.Unix(msg.Timestamp, 0))
+	if skew > g.maxSkew || -skew > g.maxSkew {
+		return fmt.Errorf("timestamp %d is outside the allowed skew of %s", msg.Timestamp, g.maxSkew)
+	}
+	return nil
+}
+
+// SetReplayGuard enables replay protection for inbound messages; nil disables it
+func (s *Server) SetReplayGuard(g *ReplayGuard) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replayGuard = g
+	s.resizeMessageWindowLocked()
+}
+
+// rejectReplay tells the sender a message was dropped as a replay
+func (s *Server) rejectReplay(conn *Connection, msg *Message, err error) {
+	s.metrics.replaysRejected.Add(1)
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeError,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"code":       "replay_rejected",
+			"error":      err.Error(),
+			"message_id": msg.ID,
+		},
+	})
+}
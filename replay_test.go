@@ -0,0 +1,97 @@
+package wssocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplayGuardUsesServerClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	server := NewServer(ServerConfig{Clock: clock})
+	t.Cleanup(server.Stop)
+	server.SetReplayGuard(NewReplayGuard(30*time.Second, time.Minute))
+
+	conn := newConnection("conn_1", "alice", TransportWebSocket)
+	if err := server.registerConnection(conn, nil); err != nil {
+		t.Fatal(err)
+	}
+	send := func(id string) error {
+		return server.acceptMessage(conn, &Message{
+			ID:        id,
+			Type:      MessageTypeChatGroup,
+			Channel:   "general",
+			Timestamp: clock.Now().Unix(),
+			Payload:   map[string]interface{}{"content": "hi"},
+		})
+	}
+
+	// Years behind the wall clock, but current on the server's clock
+	if err := send("m1"); err != nil {
+		t.Fatalf("first send rejected: %v", err)
+	}
+	if err := send("m1"); err == nil {
+		t.Fatal("reused id within the window was accepted")
+	}
+	clock.Advance(time.Minute + time.Second)
+	if err := send("m1"); err != nil {
+		t.Fatalf("reused id after the window was rejected: %v", err)
+	}
+	if got := server.metrics.replaysRejected.Load(); got != 1 {
+		t.Fatalf("replays rejected = %d, want 1", got)
+	}
+}
+
+func TestReplayAndDuplicatesShareOneWindow(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	server := NewServer(ServerConfig{Clock: clock})
+	t.Cleanup(server.Stop)
+	server.SetReplayGuard(NewReplayGuard(0, 5*time.Minute))
+	server.SetIdempotencyCache(NewIdempotencyCache(2 * time.Minute))
+	if server.messageIDs == nil || server.messageIDs.retain != 5*time.Minute {
+		t.Fatalf("message window = %+v, want one window kept for 5m", server.messageIDs)
+	}
+
+	conn := newConnection("conn_1", "alice", TransportWebSocket)
+	if err := server.registerConnection(conn, nil); err != nil {
+		t.Fatal(err)
+	}
+	send := func() error {
+		return server.acceptMessage(conn, &Message{
+			ID:      "m1",
+			Type:    MessageTypeChatGroup,
+			Channel: "general",
+			Payload: map[string]interface{}{"content": "hi"},
+		})
+	}
+
+	steps := []struct {
+		after      time.Duration
+		wantErr    bool
+		duplicates uint64
+		replays    uint64
+	}{
+		{0, false, 0, 0},
+		{time.Minute, false, 1, 0},     // Within both windows: answered as a duplicate
+		{2 * time.Minute, true, 1, 1},  // Past the duplicate window: rejected as a replay
+		{3 * time.Minute, false, 1, 1}, // Past both: processed again
+	}
+	for i, step := range steps {
+		clock.Advance(step.after)
+		err := send()
+		if (err != nil) != step.wantErr {
+			t.Fatalf("step %d: err = %v, want error %v", i, err, step.wantErr)
+		}
+		if got := server.metrics.duplicatesDropped.Load(); got != step.duplicates {
+			t.Fatalf("step %d: duplicates dropped = %d, want %d", i, got, step.duplicates)
+		}
+		if got := server.metrics.replaysRejected.Load(); got != step.replays {
+			t.Fatalf("step %d: replays rejected = %d, want %d", i, got, step.replays)
+		}
+	}
+
+	server.SetReplayGuard(nil)
+	server.SetIdempotencyCache(nil)
+	if server.messageIDs != nil {
+		t.Fatal("message window kept after both features were disabled")
+	}
+}
@@ -0,0 +1,192 @@
+package wssocket
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// Resume limits
+const (
+	MaxResumeChannels = 100          // Channels one resume message may name
+	MaxResumeMessages = MaxPageLimit // Messages replayed per channel; further behind is truncated
+)
+
+// ChannelResume reports what a resume replayed in one channel
+type ChannelResume struct {
+	Channel   string `json:"channel"`
+	From      uint64 `json:"from"`      // First sequence number replayed
+	Latest    uint64 `json:"latest"`    // Last sequence number replayed; later ones arrive live
+	Replayed  int    `json:"replayed"`  // Messages sent, after the connection's filters
+	Truncated bool   `json:"truncated"` // Some missed messages were no longer available, or didn't fit the outbound queue
+	Reset     bool   `json:"reset"`     // The channel's numbering restarted below what the client saw
+	Error     string `json:"error,omitempty"`
+	Code      string `json:"code,omitempty"` // channel_full when the connection was put on the waitlist instead
+}
+
+// ResumeChannel subscribes a connection to a channel if it isn't already,
+// within the channel's member limit, and sends it the messages numbered after last. The replay and the switch
+// to live delivery happen under the channel's sequencer, so nothing is
+// missed or sent twice in between. Messages no longer buffered in memory
+// come from the message store. If the connection's outbound queue fills up,
+// the replay stops there: the result is truncated and Latest is the last
+// message queued, so the client can resume again from it.
+func (s *Server) ResumeChannel(conn *Connection, channel string, last uint64) *ChannelResume {
+	result := &ChannelResume{Channel: channel}
+	if channel == "" || IsChannelPattern(channel) {
+		result.Error = "a single channel name is required"
+		return result
+	}
+	following := s.follows(conn, channel)
+	limit := 0
+	if !following {
+		if isGroupConversation(channel) {
+			result.Error = fmt.Sprintf("not a participant of %s", channel)
+			return result
+		}
+		if err := s.authorizeSubscription(conn, channel); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		limit = s.memberLimit(conn, channel)
+	}
+
+	// Fetch from the store before holding the sequencer, so slow storage
+	// doesn't stall the channel. Anything that leaves the buffer meanwhile
+	// was stored already.
+	seq := s.sequencer(channel)
+	seq.mu.Lock()
+	latest, oldest := seq.last, seq.oldest()
+	seq.mu.Unlock()
+	var stored map[uint64]*Message
+	if last > latest {
+		last = 0
+		result.Reset = true
+	}
+	if latest > last && (oldest == 0 || last+1 < oldest) {
+		stored = s.storedSince(channel, last)
+	}
+
+	seq.mu.Lock()
+	var event channelLifecycle
+	if !following {
+		s.mu.Lock()
+		if _, exists := s.connections[conn.ID]; !exists {
+			s.mu.Unlock()
+			seq.mu.Unlock()
+			result.Error = "connection closed"
+			return result
+		}
+		if full := s.channelFullLocked(channel, conn, limit); full != nil {
+			full.Position = s.waitlistLocked(channel, conn.ID)
+			s.mu.Unlock()
+			seq.mu.Unlock()
+			s.sendWaitlist(conn, full)
+			result.Error, result.Code = full.Error(), "channel_full"
+			return result
+		}
+		conn.addChannel(channel)
+		event = s.joinChannelLocked(channel, conn.ID)
+		s.leaveWaitlistLocked(channel, conn.ID)
+		s.mu.Unlock()
+	}
+
+	from := last + 1
+	if seq.last >= MaxResumeMessages && seq.last-MaxResumeMessages+1 > from {
+		from = seq.last - MaxResumeMessages + 1
+		result.Truncated = true
+	}
+	result.From, result.Latest = from, seq.last
+	oldest = seq.oldest()
+	for n := from; n <= seq.last; n++ {
+		var msg *Message
+		if oldest != 0 && n >= oldest {
+			msg = seq.recent[n%uint64(len(seq.recent))]
+		} else {
+			msg = stored[n]
+		}
+		if msg == nil {
+			result.Truncated = true
+			continue
+		}
+		if !conn.wants(msg, channel) {
+			continue
+		}
+		if err := s.SendToConnection(conn.ID, msg); err != nil {
+			result.Truncated = true
+			result.Latest = n - 1
+			break
+		}
+		result.Replayed++
+	}
+	seq.mu.Unlock()
+
+	if !following {
+		s.fireChannelLifecycle(event)
+		s.confirmSubscription(conn, channel, MessageTypeChannelSubscribed)
+	}
+	return result
+}
+
+// storedSince returns a channel's latest stored messages numbered after
+// last, by sequence number
+func (s *Server) storedSince(channel string, last uint64) map[uint64]*Message {
+	s.mu.RLock()
+	store := s.store
+	s.mu.RUnlock()
+	if store == nil {
+		return nil
+	}
+	msgs, err := store.GetChannelMessages(channel, Page{Limit: MaxResumeMessages})
+	if err != nil {
+		log.Printf("Error loading %s for resume: %v", channel, err)
+		return nil
+	}
+	stored := make(map[uint64]*Message, len(msgs))
+	for _, msg := range msgs {
+		if n := MessageSeq(msg); n > last {
+			stored[n] = msg
+		}
+	}
+	return stored
+}
+
+// ResumeHandler replays what the sender missed in each channel of the
+// payload's channels, a map of channel to the last sequence number seen,
+// and answers with a resume message listing what was replayed
+func ResumeHandler(conn *Connection, msg *Message) error {
+	channels, ok := msg.Payload["channels"].(map[string]interface{})
+	if !ok || len(channels) == 0 {
+		return fmt.Errorf("channels is required for resume")
+	}
+	if len(channels) > MaxResumeChannels {
+		return fmt.Errorf("at most %d channels may be resumed at once", MaxResumeChannels)
+	}
+
+	names := make([]string, 0, len(channels))
+	for channel := range channels {
+		names = append(names, channel)
+	}
+	sort.Strings(names)
+
+	results := make([]*ChannelResume, 0, len(names))
+	for _, channel := range names {
+		last, ok := channels[channel].(float64)
+		if !ok || last < 0 {
+			results = append(results, &ChannelResume{Channel: channel, Error: "last sequence number must be a number"})
+			continue
+		}
+		results = append(results, globalServer.ResumeChannel(conn, channel, uint64(last)))
+	}
+
+	return globalServer.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeResume,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Timestamp: globalServer.now().Unix(),
+		Payload: map[string]interface{}{
+			"channels": results,
+		},
+	})
+}
@@ -0,0 +1,47 @@
+package wssocket
+
+import "testing"
+
+func TestResumeStopsWhenTheQueueIsFull(t *testing.T) {
+	server := NewServer(ServerConfig{ChannelReplayBuffer: 300})
+	t.Cleanup(server.Stop)
+	restore := UseHandlerStores(server, HandlerStores{})
+	t.Cleanup(restore)
+
+	seq := server.sequencer("news")
+	seq.mu.Lock()
+	for i := 0; i < 180; i++ {
+		seq.assign(&Message{ID: generateMessageID(), Type: MessageTypeChatGroup, Channel: "news", Payload: map[string]interface{}{}})
+	}
+	seq.mu.Unlock()
+
+	// Nothing drains the outbound queue, so it fills up partway
+	conn := newConnection("conn_1", "alice", TransportWebSocket)
+	if err := server.registerConnection(conn, nil); err != nil {
+		t.Fatal(err)
+	}
+	queued := len(conn.outChan)
+
+	result := server.ResumeChannel(conn, "news", 0)
+	if result.Error != "" {
+		t.Fatalf("resume failed: %s", result.Error)
+	}
+	if !result.Truncated {
+		t.Fatalf("replay that overflowed the queue was not truncated: %+v", result)
+	}
+	if want := cap(conn.outChan) - queued; result.Replayed != want {
+		t.Fatalf("replayed %d, want %d", result.Replayed, want)
+	}
+	if result.Latest != result.From+uint64(result.Replayed)-1 {
+		t.Fatalf("latest = %d, want the last queued message %d", result.Latest, result.From+uint64(result.Replayed)-1)
+	}
+
+	// Resuming from Latest picks up the rest once the client has caught up
+	for len(conn.outChan) > 0 {
+		<-conn.outChan
+	}
+	rest := server.ResumeChannel(conn, "news", result.Latest)
+	if rest.Truncated || rest.From != result.Latest+1 || rest.Latest != 180 || rest.Replayed != int(180-result.Latest) {
+		t.Fatalf("second resume = %+v, want the remaining messages", rest)
+	}
+}
@@ -0,0 +1,384 @@
+package wssocket
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetentionPolicy controls how long a channel's stored messages are kept
+type RetentionPolicy struct {
+	MaxAge  time.Duration // Messages older than this are removed; 0 keeps them forever
+	Archive bool          // Export messages to the archive BlobStore before removing them
+}
+
+// RetentionResult reports what one janitor pass did to a channel
+type RetentionResult struct {
+	Channel    string `json:"channel"`
+	Archived   int    `json:"archived"`
+	Deleted    int    `json:"deleted"`
+	Expired    int    `json:"expired,omitempty"` // Messages removed because their ttl ran out
+	ArchiveKey string `json:"archive_key,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// DeletedPurger is a store that keeps soft-deleted rows until they are purged
+type DeletedPurger interface {
+	// PurgeDeleted removes rows soft-deleted before a unix time in
+	// milliseconds and returns how many went
+	PurgeDeleted(before int64) (int, error)
+}
+
+// ChannelSettingsReader is a store that keeps channel settings, whose
+// retention overrides the janitor's policies
+type ChannelSettingsReader interface {
+	GetChannelSettings(channel string) (*ChannelSettings, error)
+}
+
+// DefaultPurgeAfter is how long soft-deleted messages and erased users are
+// kept before the janitor purges them
+const DefaultPurgeAfter = 30 * 24 * time.Hour
+
+// RetentionJanitor periodically removes, and optionally archives, messages
+// that have outlived their channel's retention policy
+type RetentionJanitor struct {
+	store    MessageStore
+	archive  BlobStore // Where archived messages are written; nil disables archiving
+	interval time.Duration
+
+	mu       sync.Mutex
+	policies map[string]RetentionPolicy // channel or "prefix*" -> policy
+	fallback RetentionPolicy
+	stop     chan struct{}
+
+	purgeAfter time.Duration // How long soft-deleted rows are kept
+}
+
+// retentionArchiveBatch is how many messages are read per archive query
+const retentionArchiveBatch = 1000
+
+// NewRetentionJanitor creates a janitor that checks the store every interval.
+// fallback applies to channels without their own policy.
+func NewRetentionJanitor(store MessageStore, archive BlobStore, interval time.Duration, fallback RetentionPolicy) *RetentionJanitor {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	return &RetentionJanitor{
+		store:    store,
+		archive:  archive,
+		interval: interval,
+		policies: make(map[string]RetentionPolicy),
+		fallback: fallback,
+
+		purgeAfter: DefaultPurgeAfter,
+	}
+}
+
+// SetPurgeAfter sets how long soft-deleted rows are kept before they are
+// purged; 0 purges them on the next pass
+func (j *RetentionJanitor) SetPurgeAfter(d time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.purgeAfter = d
+}
+
+// SetPolicy sets the retention for a channel. A pattern ending in "*" applies
+// to every channel with that prefix; exact names win, then the longest prefix.
+func (j *RetentionJanitor) SetPolicy(pattern string, policy RetentionPolicy) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.policies[pattern] = policy
+}
+
+// SetPolicies replaces the fallback and every per-channel policy at once
+func (j *RetentionJanitor) SetPolicies(fallback RetentionPolicy, policies map[string]RetentionPolicy) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.fallback = fallback
+	j.policies = make(map[string]RetentionPolicy, len(policies))
+	for pattern, policy := range policies {
+		j.policies[pattern] = policy
+	}
+}
+
+// Policies returns the fallback and a copy of the per-channel policies
+func (j *RetentionJanitor) Policies() (RetentionPolicy, map[string]RetentionPolicy) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	policies := make(map[string]RetentionPolicy, len(j.policies))
+	for pattern, policy := range j.policies {
+		policies[pattern] = policy
+	}
+	return j.fallback, policies
+}
+
+// policyFor resolves the retention policy for a channel
+func (j *RetentionJanitor) policyFor(channel string) RetentionPolicy {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if policy, exists := j.policies[channel]; exists {
+		return policy
+	}
+	best, found := -1, false
+	var policy RetentionPolicy
+	for pattern, p := range j.policies {
+		prefix, isPrefix := strings.CutSuffix(pattern, "*")
+		if isPrefix && strings.HasPrefix(channel, prefix) && len(prefix) > best {
+			best, policy, found = len(prefix), p, true
+		}
+	}
+	if found {
+		return policy
+	}
+	return j.fallback
+}
+
+// Start runs the janitor in the background until Stop is called
+func (j *RetentionJanitor) Start() {
+	j.mu.Lock()
+	if j.stop != nil {
+		j.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	j.stop = stop
+	j.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			if _, err := j.RunOnce(); err != nil {
+				log.Printf("retention janitor: %v", err)
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop halts the background janitor
+func (j *RetentionJanitor) Stop() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stop != nil {
+		close(j.stop)
+		j.stop = nil
+	}
+}
+
+// withOverride applies the retention age set in a channel's settings, if
+// the store keeps them
+func (j *RetentionJanitor) withOverride(channel string, policy RetentionPolicy) RetentionPolicy {
+	reader, ok := j.store.(ChannelSettingsReader)
+	if !ok {
+		return policy
+	}
+	settings, err := reader.GetChannelSettings(channel)
+	if err != nil {
+		log.Printf("retention for channel %s: loading settings: %v", channel, err)
+		return policy
+	}
+	if settings.Retention == "" {
+		return policy
+	}
+	age, err := ParseRetentionAge(settings.Retention)
+	if err != nil {
+		log.Printf("retention for channel %s: ignoring override: %v", channel, err)
+		return policy
+	}
+	policy.MaxAge = age
+	return policy
+}
+
+// RunOnce removes expired messages, purges old soft-deleted rows, applies
+// every channel's policy and reports the channels it touched
+func (j *RetentionJanitor) RunOnce() ([]RetentionResult, error) {
+	now := time.Now()
+	expired, err := j.store.DeleteExpiredMessages(now.UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("delete expired messages: %w", err)
+	}
+	if purger, ok := j.store.(DeletedPurger); ok {
+		j.mu.Lock()
+		cutoff := now.Add(-j.purgeAfter).UnixMilli()
+		j.mu.Unlock()
+		purged, err := purger.PurgeDeleted(cutoff)
+		if err != nil {
+			return nil, fmt.Errorf("purge deleted rows: %w", err)
+		}
+		if purged > 0 {
+			log.Printf("retention: %d soft-deleted rows purged", purged)
+		}
+	}
+	results := make([]RetentionResult, 0, len(expired))
+	for channel, count := range expired {
+		log.Printf("retention for channel %s: %d expired messages deleted", channel, count)
+		results = append(results, RetentionResult{Channel: channel, Expired: count})
+	}
+
+	stats, err := j.store.GetChannelStats("")
+	if err != nil {
+		return nil, fmt.Errorf("list channels: %w", err)
+	}
+
+	for _, st := range stats {
+		policy := j.withOverride(st.Channel, j.policyFor(st.Channel))
+		if policy.MaxAge <= 0 {
+			continue
+		}
+		cutoff := now.Add(-policy.MaxAge).Unix()
+		if st.OldestTimestamp >= cutoff {
+			continue
+		}
+
+		result := j.expire(st.Channel, cutoff, policy)
+		if result.Error != "" {
+			log.Printf("retention for channel %s failed: %s", st.Channel, result.Error)
+		} else {
+			log.Printf("retention for channel %s: archived %d, deleted %d", st.Channel, result.Archived, result.Deleted)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// expire archives, if requested, then deletes a channel's messages older than cutoff
+func (j *RetentionJanitor) expire(channel string, cutoff int64, policy RetentionPolicy) RetentionResult {
+	result := RetentionResult{Channel: channel}
+
+	if policy.Archive {
+		if j.archive == nil {
+			result.Error = "archiving requested but no archive store is configured"
+			return result
+		}
+		key, count, err := j.archiveBefore(channel, cutoff)
+		if err != nil {
+			// Never delete what we failed to archive
+			result.Error = fmt.Sprintf("archive: %v", err)
+			return result
+		}
+		result.ArchiveKey, result.Archived = key, count
+	}
+
+	deleted, err := j.store.DeleteMessagesBefore(channel, cutoff)
+	if err != nil {
+		result.Error = fmt.Sprintf("delete: %v", err)
+	}
+	result.Deleted = deleted
+	return result
+}
+
+// archiveBefore writes a channel's messages older than cutoff to the archive
+// as gzip-compressed JSON lines and returns the blob key
+func (j *RetentionJanitor) archiveBefore(channel string, cutoff int64) (string, int, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gz)
+
+	count := 0
+	for {
+		batch, err := j.store.FindMessages(MessageQuery{
+			Channel: channel,
+			Until:   cutoff - 1,
+			Page:    Page{Limit: retentionArchiveBatch, Offset: count},
+		})
+		if err != nil {
+			return "", 0, err
+		}
+		for _, msg := range batch {
+			if err := enc.Encode(NewHistoryMessage(msg)); err != nil {
+				return "", 0, err
+			}
+		}
+		count += len(batch)
+		if len(batch) < retentionArchiveBatch {
+			break
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return "", 0, err
+	}
+	if count == 0 {
+		return "", 0, nil
+	}
+
+	key := fmt.Sprintf("archive/%s/%s-%d.jsonl.gz",
+		url.PathEscape(channel), time.Unix(cutoff, 0).UTC().Format("2006-01-02"), time.Now().UnixNano())
+	if err := j.archive.Put(key, &buf, int64(buf.Len()), "application/gzip"); err != nil {
+		return "", 0, err
+	}
+	return key, count, nil
+}
+
+// ParseRetentionAge parses a retention age such as "30d", "12h" or "0"
+func ParseRetentionAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid retention age: %s", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	if s == "0" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// ParseRetentionPolicy parses "<age>" or "<age>+archive", e.g. "30d+archive"
+func ParseRetentionPolicy(s string) (RetentionPolicy, error) {
+	age, archive := strings.CutSuffix(strings.TrimSpace(s), "+archive")
+	maxAge, err := ParseRetentionAge(age)
+	if err != nil {
+		return RetentionPolicy{}, err
+	}
+	return RetentionPolicy{MaxAge: maxAge, Archive: archive}, nil
+}
+
+// ParseRetentionPolicies parses "channel=policy" entries separated by commas,
+// e.g. "support=30d+archive,logs*=7d"
+func ParseRetentionPolicies(s string) (map[string]RetentionPolicy, error) {
+	policies := make(map[string]RetentionPolicy)
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		channel, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("retention entry must be channel=age: %s", entry)
+		}
+		policy, err := ParseRetentionPolicy(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention for %s: %w", channel, err)
+		}
+		policies[channel] = policy
+	}
+	return policies, nil
+}
+
+// String formats the policy the way ParseRetentionPolicy reads it
+func (p RetentionPolicy) String() string {
+	age := "0"
+	if p.MaxAge > 0 {
+		age = p.MaxAge.String()
+		if p.MaxAge%(24*time.Hour) == 0 {
+			age = strconv.Itoa(int(p.MaxAge/(24*time.Hour))) + "d"
+		}
+	}
+	if p.Archive {
+		return age + "+archive"
+	}
+	return age
+}
@@ -0,0 +1,783 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ChannelRole is a user's standing in a channel
+type ChannelRole string
+
+// Channel roles, most senior first. Users without a recorded role are members.
+const (
+	RoleOwner     ChannelRole = "owner"
+	RoleModerator ChannelRole = "moderator"
+	RolePublisher ChannelRole = "publisher" // May post in broadcast-only channels
+	RoleMember    ChannelRole = "member"
+)
+
+// rank orders roles by seniority
+func (r ChannelRole) rank() int {
+	switch r {
+	case RoleOwner:
+		return 4
+	case RoleModerator:
+		return 3
+	case RolePublisher:
+		return 2
+	case RoleMember:
+		return 1
+	}
+	return 0
+}
+
+// AtLeast reports whether r is as senior as other
+func (r ChannelRole) AtLeast(other ChannelRole) bool {
+	return r.rank() >= other.rank()
+}
+
+// ParseChannelRole validates a role name
+func ParseChannelRole(s string) (ChannelRole, error) {
+	role := ChannelRole(s)
+	if role.rank() == 0 {
+		return "", fmt.Errorf("unknown channel role: %s", s)
+	}
+	return role, nil
+}
+
+// ErrForbidden is returned when a user's channel role doesn't allow an action
+var ErrForbidden = errors.New("not allowed in this channel")
+
+// ChannelMember is a user's recorded role in a channel
+type ChannelMember struct {
+	Channel   string      `json:"channel"`
+	UserID    string      `json:"user_id"`
+	Role      ChannelRole `json:"role"`
+	UpdatedAt int64       `json:"updated_at"`
+}
+
+// ChannelPin is a message pinned to a channel
+type ChannelPin struct {
+	Channel   string `json:"channel"`
+	MessageID string `json:"message_id"`
+	PinnedBy  string `json:"pinned_by"`
+	PinnedAt  int64  `json:"pinned_at"`
+}
+
+// ChannelStore persists channel roles, pins, settings and invitations
+type ChannelStore interface {
+	// GetChannelRole returns a user's role, RoleMember when none is recorded
+	GetChannelRole(channel, userID string) (ChannelRole, error)
+	// GetChannelMember returns a user's recorded role, or nil when there is none
+	GetChannelMember(channel, userID string) (*ChannelMember, error)
+	SetChannelRole(member *ChannelMember) error
+	RemoveChannelMember(channel, userID string) error
+	// ListChannelMembers returns the users with a recorded role, most senior first
+	ListChannelMembers(channel string) ([]*ChannelMember, error)
+	PinMessage(pin *ChannelPin) error
+	UnpinMessage(channel, messageID string) error
+	// ListPins returns a channel's pins, newest first
+	ListPins(channel string) ([]*ChannelPin, error)
+	// GetChannelSettings returns a channel's settings, the defaults when none are stored
+	GetChannelSettings(channel string) (*ChannelSettings, error)
+	SaveChannelSettings(settings *ChannelSettings) error
+	// SaveInvite inserts or replaces an invitation
+	SaveInvite(invite *ChannelInvite) error
+	// GetInvite returns an invitation, or nil when there is none
+	GetInvite(id string) (*ChannelInvite, error)
+	// ListPendingInvites returns a user's unexpired pending invitations, newest first
+	ListPendingInvites(userID string, now int64) ([]*ChannelInvite, error)
+}
+
+// globalChannelStore is where channel roles and pins are kept (set during init)
+var globalChannelStore ChannelStore
+
+// InMemoryChannelStore keeps channel roles, pins, settings and invitations in memory
+type InMemoryChannelStore struct {
+	mu       sync.RWMutex
+	members  map[string]map[string]*ChannelMember // channel -> user -> member
+	pins     map[string][]*ChannelPin             // channel -> pins in the order pinned
+	settings map[string]*ChannelSettings
+	invites  map[string]*ChannelInvite
+}
+
+// NewInMemoryChannelStore creates an empty in-memory channel store
+func NewInMemoryChannelStore() *InMemoryChannelStore {
+	return &InMemoryChannelStore{
+		members:  make(map[string]map[string]*ChannelMember),
+		pins:     make(map[string][]*ChannelPin),
+		settings: make(map[string]*ChannelSettings),
+		invites:  make(map[string]*ChannelInvite),
+	}
+}
+
+// GetChannelRole returns a user's role in a channel
+func (s *InMemoryChannelStore) GetChannelRole(channel, userID string) (ChannelRole, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if m, exists := s.members[channel][userID]; exists {
+		return m.Role, nil
+	}
+	return RoleMember, nil
+}
+
+// GetChannelMember returns a user's recorded role in a channel
+func (s *InMemoryChannelStore) GetChannelMember(channel, userID string) (*ChannelMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, exists := s.members[channel][userID]
+	if !exists {
+		return nil, nil
+	}
+	copied := *m
+	return &copied, nil
+}
+
+// SetChannelRole records a user's role in a channel
+func (s *InMemoryChannelStore) SetChannelRole(member *ChannelMember) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.members[member.Channel] == nil {
+		s.members[member.Channel] = make(map[string]*ChannelMember)
+	}
+	stored := *member
+	s.members[member.Channel][member.UserID] = &stored
+	return nil
+}
+
+// RemoveChannelMember forgets a user's role in a channel
+func (s *InMemoryChannelStore) RemoveChannelMember(channel, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.members[channel], userID)
+	return nil
+}
+
+// ListChannelMembers returns a channel's recorded roles, most senior first
+func (s *InMemoryChannelStore) ListChannelMembers(channel string) ([]*ChannelMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	members := make([]*ChannelMember, 0, len(s.members[channel]))
+	for _, m := range s.members[channel] {
+		copied := *m
+		members = append(members, &copied)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Role != members[j].Role {
+			return members[i].Role.rank() > members[j].Role.rank()
+		}
+		return members[i].UserID < members[j].UserID
+	})
+	return members, nil
+}
+
+// PinMessage pins a message, ignoring one already pinned
+func (s *InMemoryChannelStore) PinMessage(pin *ChannelPin) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.pins[pin.Channel] {
+		if p.MessageID == pin.MessageID {
+			return nil
+		}
+	}
+	stored := *pin
+	s.pins[pin.Channel] = append(s.pins[pin.Channel], &stored)
+	return nil
+}
+
+// UnpinMessage removes a pin
+func (s *InMemoryChannelStore) UnpinMessage(channel, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pins := s.pins[channel]
+	for i, p := range pins {
+		if p.MessageID == messageID {
+			s.pins[channel] = append(pins[:i:i], pins[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// ListPins returns a channel's pins, newest first
+func (s *InMemoryChannelStore) ListPins(channel string) ([]*ChannelPin, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pins := make([]*ChannelPin, 0, len(s.pins[channel]))
+	for i := len(s.pins[channel]) - 1; i >= 0; i-- {
+		copied := *s.pins[channel][i]
+		pins = append(pins, &copied)
+	}
+	return pins, nil
+}
+
+// GetChannelSettings returns a channel's settings
+func (s *InMemoryChannelStore) GetChannelSettings(channel string) (*ChannelSettings, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if stored, exists := s.settings[channel]; exists {
+		copied := *stored
+		return &copied, nil
+	}
+	return defaultChannelSettings(channel), nil
+}
+
+// SaveChannelSettings replaces a channel's settings
+func (s *InMemoryChannelStore) SaveChannelSettings(settings *ChannelSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *settings
+	s.settings[settings.Channel] = &stored
+	return nil
+}
+
+// SaveInvite inserts or replaces an invitation
+func (s *InMemoryChannelStore) SaveInvite(invite *ChannelInvite) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *invite
+	s.invites[invite.ID] = &stored
+	return nil
+}
+
+// GetInvite returns an invitation by ID
+func (s *InMemoryChannelStore) GetInvite(id string) (*ChannelInvite, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	invite, exists := s.invites[id]
+	if !exists {
+		return nil, nil
+	}
+	copied := *invite
+	return &copied, nil
+}
+
+// ListPendingInvites returns a user's unexpired pending invitations, newest first
+func (s *InMemoryChannelStore) ListPendingInvites(userID string, now int64) ([]*ChannelInvite, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	invites := make([]*ChannelInvite, 0)
+	for _, invite := range s.invites {
+		if invite.UserID == userID && invite.Status == InvitePending && invite.ExpiresAt > now {
+			copied := *invite
+			invites = append(invites, &copied)
+		}
+	}
+	sort.Slice(invites, func(i, j int) bool {
+		if invites[i].CreatedAt != invites[j].CreatedAt {
+			return invites[i].CreatedAt > invites[j].CreatedAt
+		}
+		return invites[i].ID > invites[j].ID
+	})
+	return invites, nil
+}
+
+// ChannelRole returns a user's role in a channel. Without a channel store
+// everyone is a member.
+func (s *Server) ChannelRole(channel, userID string) ChannelRole {
+	if globalChannelStore == nil {
+		return RoleMember
+	}
+	role, err := globalChannelStore.GetChannelRole(channel, userID)
+	if err != nil {
+		log.Printf("Error loading role of %s in %s: %v", userID, channel, err)
+		return RoleMember
+	}
+	return role
+}
+
+// requireChannelRole checks the sender holds at least min in channel, and
+// tells them if not
+func (s *Server) requireChannelRole(conn *Connection, msg *Message, channel string, min ChannelRole) error {
+	if s.ChannelRole(channel, conn.UserID).AtLeast(min) {
+		return nil
+	}
+	return s.rejectForbidden(conn, msg, fmt.Errorf("%s needs the %s role in %q: %w", conn.UserID, min, channel, ErrForbidden))
+}
+
+// requireOutrank checks the sender is a moderator who is more senior than
+// the target user
+func (s *Server) requireOutrank(conn *Connection, msg *Message, channel, target string) error {
+	if err := s.requireChannelRole(conn, msg, channel, RoleModerator); err != nil {
+		return err
+	}
+	if s.ChannelRole(channel, conn.UserID).rank() > s.ChannelRole(channel, target).rank() {
+		return nil
+	}
+	return s.rejectForbidden(conn, msg, fmt.Errorf("%s does not outrank %s in %q: %w", conn.UserID, target, channel, ErrForbidden))
+}
+
+// rejectForbidden tells the sender an action was refused
+func (s *Server) rejectForbidden(conn *Connection, msg *Message, err error) error {
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeError,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"code":       "forbidden",
+			"error":      err.Error(),
+			"message_id": msg.ID,
+		},
+	})
+	return err
+}
+
+// authorizeSubscription keeps moderation channels to the moderators of the
+// channel they report on, and private channels to their members. Group
+// conversations reach their participants without a subscription.
+func (s *Server) authorizeSubscription(conn *Connection, channel string) error {
+	if IsChannelPattern(channel) {
+		return nil
+	}
+	if isGroupConversation(channel) {
+		return fmt.Errorf("%s is a group conversation and can't be subscribed to", channel)
+	}
+	moderated, isModeration := strings.CutPrefix(channel, moderationChannelPrefix)
+	if !isModeration {
+		return s.authorizeJoin(conn, channel)
+	}
+	if moderated == "direct" {
+		moderated = ""
+	}
+	if !s.ChannelRole(moderated, conn.UserID).AtLeast(RoleModerator) {
+		return fmt.Errorf("%s may not subscribe to %s: %w", conn.UserID, channel, ErrForbidden)
+	}
+	return nil
+}
+
+// SetChannelRole gives a user a role in a channel and announces the change.
+// A channel always keeps at least one owner once it has one.
+func (s *Server) SetChannelRole(channel, userID string, role ChannelRole, actor string) error {
+	if globalChannelStore == nil {
+		return fmt.Errorf("channel store not available")
+	}
+	if err := s.keepOwner(channel, userID, role); err != nil {
+		return err
+	}
+	member := &ChannelMember{Channel: channel, UserID: userID, Role: role, UpdatedAt: s.now().Unix()}
+	if err := globalChannelStore.SetChannelRole(member); err != nil {
+		return fmt.Errorf("set role of %s in %s: %w", userID, channel, err)
+	}
+
+	s.announceChannelRole(channel, userID, role, actor)
+	s.logRoleChange(channel, userID, "role set to "+string(role), actor)
+	return nil
+}
+
+// RemoveChannelMember forgets a user's role, leaving them a plain member
+func (s *Server) RemoveChannelMember(channel, userID, actor string) error {
+	if globalChannelStore == nil {
+		return fmt.Errorf("channel store not available")
+	}
+	if err := s.keepOwner(channel, userID, RoleMember); err != nil {
+		return err
+	}
+	if err := globalChannelStore.RemoveChannelMember(channel, userID); err != nil {
+		return fmt.Errorf("remove %s from %s: %w", userID, channel, err)
+	}
+
+	s.announceChannelRole(channel, userID, RoleMember, actor)
+	s.logRoleChange(channel, userID, "role removed", actor)
+	return nil
+}
+
+// logRoleChange records a role change in the moderation log. The change has
+// already happened, so a logging failure is only reported.
+func (s *Server) logRoleChange(channel, userID, reason, actor string) {
+	err := s.moderationEvent(&ModerationLogEntry{
+		Action:    ModerationLogRole,
+		UserID:    userID,
+		Channel:   channel,
+		Reason:    reason,
+		Actor:     actor,
+		Timestamp: s.now().Unix(),
+	})
+	if err != nil {
+		log.Printf("Error logging role change of %s in %s: %v", userID, channel, err)
+	}
+}
+
+// keepOwner refuses to demote a channel's last owner
+func (s *Server) keepOwner(channel, userID string, role ChannelRole) error {
+	if role == RoleOwner || s.ChannelRole(channel, userID) != RoleOwner {
+		return nil
+	}
+	members, err := globalChannelStore.ListChannelMembers(channel)
+	if err != nil {
+		return fmt.Errorf("list members of %s: %w", channel, err)
+	}
+	for _, m := range members {
+		if m.Role == RoleOwner && m.UserID != userID {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is the last owner of %s: %w", userID, channel, ErrForbidden)
+}
+
+// announceChannelRole tells a channel that a member's role changed
+func (s *Server) announceChannelRole(channel, userID string, role ChannelRole, actor string) {
+	s.broadcastToChannel(channel, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeChannelRole,
+		Sender:    "system",
+		Channel:   channel,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"user_id": userID,
+			"role":    string(role),
+			"by":      actor,
+		},
+	}, &BroadcastOptions{})
+}
+
+// KickFromChannel unsubscribes every connection a user has in a channel and
+// returns how many there were. The user can join again.
+func (s *Server) KickFromChannel(channel, userID, actor, reason string) (int, error) {
+	s.mu.RLock()
+	connIDs := make([]string, 0)
+	for connID := range s.channels[channel] {
+		if conn, exists := s.connections[connID]; exists && conn.UserID == userID {
+			connIDs = append(connIDs, connID)
+		}
+	}
+	s.mu.RUnlock()
+
+	notice := &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeChannelKick,
+		Sender:    "system",
+		Channel:   channel,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"user_id": userID,
+			"by":      actor,
+			"reason":  reason,
+		},
+	}
+	for _, connID := range connIDs {
+		s.UnsubscribeFromChannel(connID, channel)
+		s.SendToConnection(connID, notice)
+	}
+	s.broadcastToChannel(channel, notice, &BroadcastOptions{})
+
+	return len(connIDs), s.moderationEvent(&ModerationLogEntry{
+		Action:    ModerationLogKick,
+		UserID:    userID,
+		Channel:   channel,
+		Reason:    reason,
+		Actor:     actor,
+		Timestamp: s.now().Unix(),
+	})
+}
+
+// ChannelRoleHandler lets an owner change a member's role. The payload
+// carries user_id and role; the change is broadcast to the channel.
+func ChannelRoleHandler(conn *Connection, msg *Message) error {
+	userID, _ := msg.Payload["user_id"].(string)
+	roleName, _ := msg.Payload["role"].(string)
+	if msg.Channel == "" || userID == "" {
+		return fmt.Errorf("channel and user_id are required")
+	}
+	role, err := ParseChannelRole(roleName)
+	if err != nil {
+		return err
+	}
+	if err := globalServer.requireChannelRole(conn, msg, msg.Channel, RoleOwner); err != nil {
+		return err
+	}
+	if err := globalServer.SetChannelRole(msg.Channel, userID, role, conn.UserID); err != nil {
+		if errors.Is(err, ErrForbidden) {
+			return globalServer.rejectForbidden(conn, msg, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// KickHandler lets a moderator remove a less senior user from a channel
+func KickHandler(conn *Connection, msg *Message) error {
+	userID, _ := msg.Payload["user_id"].(string)
+	if msg.Channel == "" || userID == "" {
+		return fmt.Errorf("channel and user_id are required")
+	}
+	if err := globalServer.requireOutrank(conn, msg, msg.Channel, userID); err != nil {
+		return err
+	}
+	reason, _ := msg.Payload["reason"].(string)
+	_, err := globalServer.KickFromChannel(msg.Channel, userID, conn.UserID, reason)
+	return err
+}
+
+// PinHandler lets a moderator pin or unpin a message in a channel
+func PinHandler(conn *Connection, msg *Message) error {
+	messageID, _ := msg.Payload["message_id"].(string)
+	if msg.Channel == "" || messageID == "" {
+		return fmt.Errorf("channel and message_id are required")
+	}
+	if err := globalServer.requireChannelRole(conn, msg, msg.Channel, RoleModerator); err != nil {
+		return err
+	}
+	if globalChannelStore == nil {
+		return fmt.Errorf("channel store not available")
+	}
+
+	var err error
+	if msg.Type == MessageTypeMessagePin {
+		err = globalChannelStore.PinMessage(&ChannelPin{
+			Channel:   msg.Channel,
+			MessageID: messageID,
+			PinnedBy:  conn.UserID,
+			PinnedAt:  globalServer.now().Unix(),
+		})
+	} else {
+		err = globalChannelStore.UnpinMessage(msg.Channel, messageID)
+	}
+	if err != nil {
+		return fmt.Errorf("%s message %s: %w", msg.Type, messageID, err)
+	}
+
+	return globalServer.broadcastToChannel(msg.Channel, &Message{
+		ID:        generateMessageID(),
+		Type:      msg.Type,
+		Sender:    "system",
+		Channel:   msg.Channel,
+		Timestamp: globalServer.now().Unix(),
+		Payload: map[string]interface{}{
+			"message_id": messageID,
+			"by":         conn.UserID,
+		},
+	}, &BroadcastOptions{})
+}
+
+// authorizeDelete lets users delete their own messages and moderators
+// delete anyone's in their channel. Without a stored copy the author is
+// unknown, so only a moderator may delete a channel message and no one may
+// delete someone else's direct message.
+func (s *Server) authorizeDelete(conn *Connection, msg *Message, messageID string) error {
+	channel := msg.Channel
+	if globalStore != nil {
+		original, err := globalStore.GetMessage(messageID)
+		if err != nil && !errors.Is(err, ErrMessageNotFound) {
+			return fmt.Errorf("load message %s: %w", messageID, err)
+		}
+		if original != nil {
+			if original.Sender == conn.UserID {
+				return nil
+			}
+			channel = original.Channel
+			if original.Recipient != "" {
+				channel = ""
+			}
+		}
+	}
+	if channel == "" {
+		return s.rejectForbidden(conn, msg, fmt.Errorf("%s may only delete their own direct messages: %w", conn.UserID, ErrForbidden))
+	}
+	return s.requireChannelRole(conn, msg, channel, RoleModerator)
+}
+
+// setupChannelRoutes registers the channel member, pin and settings listings
+func setupChannelRoutes(mux *http.ServeMux) {
+	// GET lists the users with a recorded role in ?channel=, most senior first
+	mux.HandleFunc("/api/channels/members", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if globalChannelStore == nil {
+			http.Error(w, "Channel store not available", http.StatusServiceUnavailable)
+			return
+		}
+		channel := r.URL.Query().Get("channel")
+		if channel == "" {
+			http.Error(w, "channel parameter required", http.StatusBadRequest)
+			return
+		}
+		if channelHidden(channel) {
+			http.Error(w, "channel not found", http.StatusNotFound)
+			return
+		}
+
+		members, err := globalChannelStore.ListChannelMembers(channel)
+		if err != nil {
+			log.Printf("Error listing members of %s: %v", channel, err)
+			http.Error(w, "Failed to list members", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"channel": channel,
+			"members": members,
+			"count":   len(members),
+		})
+	})
+
+	// GET lists the messages pinned in ?channel=, newest first
+	mux.HandleFunc("/api/channels/pins", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if globalChannelStore == nil {
+			http.Error(w, "Channel store not available", http.StatusServiceUnavailable)
+			return
+		}
+		channel := r.URL.Query().Get("channel")
+		if channel == "" {
+			http.Error(w, "channel parameter required", http.StatusBadRequest)
+			return
+		}
+		if channelHidden(channel) {
+			http.Error(w, "channel not found", http.StatusNotFound)
+			return
+		}
+
+		pins, err := globalChannelStore.ListPins(channel)
+		if err != nil {
+			log.Printf("Error listing pins of %s: %v", channel, err)
+			http.Error(w, "Failed to list pins", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"channel": channel,
+			"pins":    pins,
+			"count":   len(pins),
+		})
+	})
+
+	// GET returns the settings of ?channel=; POST changes them as user_id,
+	// who must be a moderator to set the topic and description and an owner
+	// for anything else
+	mux.HandleFunc("/api/channels/settings", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			updateChannelSettings(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		channel := r.URL.Query().Get("channel")
+		if channel == "" {
+			http.Error(w, "channel parameter required", http.StatusBadRequest)
+			return
+		}
+		if channelHidden(channel) {
+			http.Error(w, "channel not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, globalServer.ChannelSettings(channel))
+	})
+}
+
+// updateChannelSettings applies a user's change to a channel's settings;
+// omitted fields keep their values
+func updateChannelSettings(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Channel string `json:"channel"`
+		UserID  string `json:"user_id"`
+		ChannelSettingsUpdate
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	userID, ok := requestUser(w, r, req.UserID)
+	if !ok {
+		return
+	}
+	if req.Channel == "" {
+		http.Error(w, "channel is required", http.StatusBadRequest)
+		return
+	}
+	if IsChannelPattern(req.Channel) {
+		http.Error(w, "settings apply to a single channel, not a pattern", http.StatusBadRequest)
+		return
+	}
+	if !globalServer.ChannelRole(req.Channel, userID).AtLeast(req.role()) {
+		if channelHidden(req.Channel) {
+			http.Error(w, "channel not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("%s needs the %s role in %q", userID, req.role(), req.Channel), http.StatusForbidden)
+		return
+	}
+
+	settings := globalServer.ChannelSettings(req.Channel)
+	if err := req.apply(&settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := globalServer.UpdateChannelSettings(settings, userID); err != nil {
+		log.Printf("Error updating settings of %s: %v", req.Channel, err)
+		http.Error(w, "Failed to update channel settings", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, globalServer.ChannelSettings(req.Channel))
+}
+
+// setupChannelRoleAdminRoutes registers role management, guarded by admin API keys
+func setupChannelRoleAdminRoutes(mux *http.ServeMux, s *Server, apiKeys []string) {
+	// POST sets a user's role in a channel; DELETE removes it
+	mux.HandleFunc("/api/admin/channels/members", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validAPIKey(apiKeyFromRequest(r), apiKeys) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Channel string `json:"channel"`
+			UserID  string `json:"user_id"`
+			Role    string `json:"role"`
+			Actor   string `json:"actor"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Channel == "" || req.UserID == "" {
+			http.Error(w, "channel and user_id are required", http.StatusBadRequest)
+			return
+		}
+		if req.Actor == "" {
+			req.Actor = "admin"
+		}
+
+		var err error
+		if r.Method == http.MethodDelete {
+			err = s.RemoveChannelMember(req.Channel, req.UserID, req.Actor)
+		} else {
+			var role ChannelRole
+			if role, err = ParseChannelRole(req.Role); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = s.SetChannelRole(req.Channel, req.UserID, role, req.Actor)
+		}
+		if errors.Is(err, ErrForbidden) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Error updating role of %s in %s: %v", req.UserID, req.Channel, err)
+			http.Error(w, "Failed to update role", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"channel": req.Channel,
+			"user_id": req.UserID,
+			"role":    s.ChannelRole(req.Channel, req.UserID),
+		})
+	})
+}
@@ -0,0 +1,155 @@
+package wssocket
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Saturation thresholds behind the scaling recommendation
+const (
+	DefaultScaleUpAt   = 0.75
+	DefaultScaleDownAt = 0.30
+)
+
+// Scaling recommendations
+const (
+	ScaleUp   = "scale_up"
+	ScaleHold = "hold"
+	ScaleDown = "scale_down"
+)
+
+// minBusySample is the shortest window worker utilization is measured over;
+// scrapes closer together than this reuse the last measurement
+const minBusySample = time.Second
+
+// ScalingSignals reports how saturated a node is, for autoscalers. Ratios are
+// 0 (idle) to 1 (full); Saturation is the highest of them.
+type ScalingSignals struct {
+	Connections      int     `json:"connections"`
+	ConnectionLimit  int     `json:"connection_limit"`
+	ConnectionRatio  float64 `json:"connection_ratio"`
+	QueueDepth       int     `json:"queue_depth"`
+	QueueCapacity    int     `json:"queue_capacity"`
+	QueueRatio       float64 `json:"queue_ratio"`
+	Workers          int     `json:"workers"`
+	WorkerBusyRatio  float64 `json:"worker_busy_ratio"` // Share of worker time spent processing and fanning out
+	OutboundQueued   int     `json:"outbound_queued"`   // Messages waiting to be written across all connections
+	SlowConnections  int     `json:"slow_connections"`  // Connections with a mostly full outbound queue or overflow
+	Saturation       float64 `json:"saturation"`
+	Recommendation   string  `json:"recommendation"`
+	SampleWindowSecs float64 `json:"sample_window_secs"` // Period the worker busy ratio covers
+}
+
+// busySampler turns cumulative worker busy time into a utilization ratio
+type busySampler struct {
+	mu       sync.Mutex
+	lastAt   time.Time // Server start until the first sample
+	lastBusy int64
+	ratio    float64
+	window   time.Duration
+}
+
+// sample returns worker utilization since the previous sample
+func (b *busySampler) sample(busy int64, workers int) (float64, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(b.lastAt)
+	if elapsed < minBusySample {
+		return b.ratio, b.window
+	}
+
+	b.ratio = min(float64(busy-b.lastBusy)/(float64(elapsed)*float64(workers)), 1)
+	b.window = elapsed
+	b.lastAt, b.lastBusy = now, busy
+	return b.ratio, b.window
+}
+
+// ScalingSignals returns the node's current saturation
+func (s *Server) ScalingSignals() ScalingSignals {
+	s.mu.RLock()
+	signals := ScalingSignals{
+		Connections:     len(s.connections),
+		ConnectionLimit: s.maxConnections,
+	}
+	for _, conn := range s.connections {
+		queued := len(conn.outChan)
+		signals.OutboundQueued += queued
+		conn.mu.RLock()
+		overflowing := conn.overflow != nil
+		conn.mu.RUnlock()
+		if overflowing || queued*5 >= cap(conn.outChan)*4 {
+			signals.SlowConnections++
+		}
+	}
+	s.mu.RUnlock()
+
+	signals.QueueDepth = s.queueDepth()
+	for _, queue := range s.messageQueues {
+		signals.QueueCapacity += queue.capacity()
+	}
+	signals.Workers = len(s.messageQueues)
+
+	var window time.Duration
+	signals.WorkerBusyRatio, window = s.busy.sample(s.metrics.workerBusy.Load(), signals.Workers)
+	signals.SampleWindowSecs = window.Seconds()
+
+	if signals.ConnectionLimit > 0 {
+		signals.ConnectionRatio = float64(signals.Connections) / float64(signals.ConnectionLimit)
+	}
+	if signals.QueueCapacity > 0 {
+		signals.QueueRatio = float64(signals.QueueDepth) / float64(signals.QueueCapacity)
+	}
+	signals.Saturation = max(signals.ConnectionRatio, signals.QueueRatio, signals.WorkerBusyRatio)
+
+	switch {
+	case signals.Saturation >= DefaultScaleUpAt:
+		signals.Recommendation = ScaleUp
+	case signals.Saturation < DefaultScaleDownAt:
+		signals.Recommendation = ScaleDown
+	default:
+		signals.Recommendation = ScaleHold
+	}
+	return signals
+}
+
+// prometheusText renders the signals in the Prometheus text format
+func (sig ScalingSignals) prometheusText() string {
+	var b strings.Builder
+	gauge := func(name, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP gows_%s %s\n# TYPE gows_%s gauge\ngows_%s %g\n", name, help, name, name, value)
+	}
+	gauge("connections", "Open connections.", float64(sig.Connections))
+	gauge("connection_ratio", "Share of connection slots in use.", sig.ConnectionRatio)
+	gauge("queue_depth", "Inbound messages waiting for a worker.", float64(sig.QueueDepth))
+	gauge("queue_ratio", "Share of the inbound queue in use.", sig.QueueRatio)
+	gauge("worker_busy_ratio", "Share of worker time spent processing messages.", sig.WorkerBusyRatio)
+	gauge("outbound_queued", "Messages waiting to be written to connections.", float64(sig.OutboundQueued))
+	gauge("slow_connections", "Connections that are falling behind.", float64(sig.SlowConnections))
+	gauge("saturation", "Highest of the connection, queue and worker ratios.", sig.Saturation)
+	return b.String()
+}
+
+// setupScalingRoutes registers the autoscaling signals endpoint
+func setupScalingRoutes(mux *http.ServeMux, server *Server) {
+	// JSON by default; ?format=prometheus for metrics adapters
+	mux.HandleFunc("/api/scaling", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		signals := server.ScalingSignals()
+		w.Header().Set("Cache-Control", "no-store")
+		if r.URL.Query().Get("format") == "prometheus" {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			fmt.Fprint(w, signals.prometheusText())
+			return
+		}
+		writeJSON(w, http.StatusOK, signals)
+	})
+}
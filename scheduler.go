@@ -0,0 +1,389 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MetadataDeliverAt holds when a scheduled message should be delivered, in
+// unix milliseconds
+const MetadataDeliverAt = "deliver_at"
+
+// Scheduler defaults
+const (
+	DefaultSchedulerInterval = time.Second
+	DefaultScheduleMaxDelay  = 30 * 24 * time.Hour
+	scheduledClaimBatch      = 100
+)
+
+// ScheduledMessage is a message held until its delivery time
+type ScheduledMessage struct {
+	ID        string   `json:"id"` // The message ID
+	UserID    string   `json:"user_id"`
+	DeliverAt int64    `json:"deliver_at"` // Unix milliseconds
+	Message   *Message `json:"message"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+// ScheduledStore persists scheduled messages until they are due
+type ScheduledStore interface {
+	SaveScheduled(sm *ScheduledMessage) error
+	// ClaimDueScheduled removes and returns up to limit messages due at or
+	// before now, oldest first. Each message is claimed by one caller, so
+	// several nodes can poll the same store.
+	ClaimDueScheduled(now int64, limit int) ([]*ScheduledMessage, error)
+	// ListScheduled returns a user's pending messages, soonest first
+	ListScheduled(userID string) ([]*ScheduledMessage, error)
+	// CancelScheduled removes a user's pending message and reports whether it existed
+	CancelScheduled(userID, id string) (bool, error)
+}
+
+// messageDeliverAt returns when a message asks to be delivered
+func messageDeliverAt(msg *Message) (time.Time, bool) {
+	switch v := msg.Metadata[MetadataDeliverAt].(type) {
+	case float64:
+		return time.UnixMilli(int64(math.Round(v))), true
+	case int64:
+		return time.UnixMilli(v), true
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return time.UnixMilli(n), true
+		}
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Scheduler holds messages with a future deliver_at and feeds them into the
+// worker queues once they are due. It polls its store, so every node can run
+// one against a shared database.
+type Scheduler struct {
+	server   *Server
+	store    ScheduledStore
+	interval time.Duration
+	maxDelay time.Duration
+
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+// NewScheduler creates a scheduler that checks store every interval and
+// accepts messages up to maxDelay ahead. Zero values use the defaults.
+func NewScheduler(s *Server, store ScheduledStore, interval, maxDelay time.Duration) *Scheduler {
+	if interval <= 0 {
+		interval = DefaultSchedulerInterval
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultScheduleMaxDelay
+	}
+	return &Scheduler{server: s, store: store, interval: interval, maxDelay: maxDelay}
+}
+
+// SetScheduler lets clients schedule messages; nil turns scheduling off
+func (s *Server) SetScheduler(sch *Scheduler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scheduler = sch
+}
+
+// Start begins polling for due messages
+func (sch *Scheduler) Start() {
+	sch.mu.Lock()
+	if sch.stop != nil {
+		sch.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	sch.stop = stop
+	sch.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(sch.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if _, err := sch.RunOnce(); err != nil {
+				log.Printf("scheduler: %v", err)
+			}
+		}
+	}()
+}
+
+// Stop ends polling
+func (sch *Scheduler) Stop() {
+	sch.mu.Lock()
+	defer sch.mu.Unlock()
+	if sch.stop != nil {
+		close(sch.stop)
+		sch.stop = nil
+	}
+}
+
+// RunOnce delivers every message that is due and returns how many it queued
+func (sch *Scheduler) RunOnce() (int, error) {
+	queued := 0
+	for {
+		due, err := sch.store.ClaimDueScheduled(sch.server.now().UnixMilli(), scheduledClaimBatch)
+		if err != nil {
+			return queued, fmt.Errorf("claim due messages: %w", err)
+		}
+		for _, sm := range due {
+			if sch.server.deliverScheduled(sm) {
+				queued++
+			}
+		}
+		if len(due) < scheduledClaimBatch {
+			return queued, nil
+		}
+	}
+}
+
+// checkSchedule validates a message's deliver_at before it is accepted
+func (s *Server) checkSchedule(msg *Message) error {
+	at, ok := messageDeliverAt(msg)
+	if !ok {
+		if _, set := msg.Metadata[MetadataDeliverAt]; set {
+			return fmt.Errorf("deliver_at must be unix milliseconds or an RFC 3339 time")
+		}
+		return nil
+	}
+	s.mu.RLock()
+	sch := s.scheduler
+	s.mu.RUnlock()
+	if sch == nil {
+		return fmt.Errorf("scheduled messages are not enabled")
+	}
+	if at.Sub(s.now()) > sch.maxDelay {
+		return fmt.Errorf("deliver_at is more than %s away", sch.maxDelay)
+	}
+	return nil
+}
+
+// rejectSchedule tells the sender their deliver_at was refused
+func (s *Server) rejectSchedule(conn *Connection, msg *Message, err error) error {
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeError,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"code":       "invalid_schedule",
+			"error":      err.Error(),
+			"message_id": msg.ID,
+		},
+	})
+	return fmt.Errorf("rejected scheduled message %s: %w", msg.ID, err)
+}
+
+// schedule holds a message whose deliver_at is in the future and reports
+// whether it did. The sender is told with a message:scheduled confirmation.
+func (s *Server) schedule(conn *Connection, msg *Message) bool {
+	at, ok := messageDeliverAt(msg)
+	if !ok || !at.After(s.now()) {
+		return false
+	}
+	s.mu.RLock()
+	sch := s.scheduler
+	s.mu.RUnlock()
+	if sch == nil {
+		return false
+	}
+
+	err := sch.store.SaveScheduled(&ScheduledMessage{
+		ID:        msg.ID,
+		UserID:    conn.UserID,
+		DeliverAt: at.UnixMilli(),
+		Message:   msg,
+		CreatedAt: s.now().Unix(),
+	})
+	payload := map[string]interface{}{
+		"message_id": msg.ID,
+		"deliver_at": at.UnixMilli(),
+		"ok":         err == nil,
+	}
+	if err != nil {
+		log.Printf("schedule message %s: %v", msg.ID, err)
+		payload["error"] = "failed to schedule message"
+	}
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeScheduled,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Channel:   msg.Channel,
+		Timestamp: s.now().Unix(),
+		Payload:   payload,
+	})
+	return true
+}
+
+// deliverScheduled queues a due message as if its sender had just sent it.
+// Hooks and moderation already ran when it was scheduled; a sender muted
+// since then, or a message that expired, is dropped.
+func (s *Server) deliverScheduled(sm *ScheduledMessage) bool {
+	msg := sm.Message
+	msg.Timestamp = s.now().Unix()
+	if s.dropExpired(msg) {
+		return false
+	}
+	if _, muted := s.MutedUntil(sm.UserID, msg.Channel); muted {
+		s.metrics.mutedRejected.Add(1)
+		return false
+	}
+
+	conn := newConnection("sched_"+uuid.New().String()[:12], sm.UserID, "scheduler")
+	s.mu.RLock()
+	priority := s.priorityFor(msg.Type)
+	s.mu.RUnlock()
+	s.queueFor(conn.ID).push(&internalMessage{conn: conn, msg: msg, queuedAt: time.Now()}, priority)
+	return true
+}
+
+// InMemoryScheduledStore keeps scheduled messages in memory
+type InMemoryScheduledStore struct {
+	mu      sync.Mutex
+	pending map[string]*ScheduledMessage
+}
+
+// NewInMemoryScheduledStore creates an empty in-memory scheduled store
+func NewInMemoryScheduledStore() *InMemoryScheduledStore {
+	return &InMemoryScheduledStore{pending: make(map[string]*ScheduledMessage)}
+}
+
+// SaveScheduled stores a scheduled message, replacing one with the same ID
+func (s *InMemoryScheduledStore) SaveScheduled(sm *ScheduledMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[sm.ID] = sm
+	return nil
+}
+
+// ClaimDueScheduled removes and returns due messages, oldest first
+func (s *InMemoryScheduledStore) ClaimDueScheduled(now int64, limit int) ([]*ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := make([]*ScheduledMessage, 0)
+	for _, sm := range s.pending {
+		if sm.DeliverAt <= now {
+			due = append(due, sm)
+		}
+	}
+	sortScheduled(due)
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for _, sm := range due {
+		delete(s.pending, sm.ID)
+	}
+	return due, nil
+}
+
+// ListScheduled returns a user's pending messages, soonest first
+func (s *InMemoryScheduledStore) ListScheduled(userID string) ([]*ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]*ScheduledMessage, 0)
+	for _, sm := range s.pending {
+		if sm.UserID == userID {
+			list = append(list, sm)
+		}
+	}
+	sortScheduled(list)
+	return list, nil
+}
+
+// CancelScheduled removes a user's pending message
+func (s *InMemoryScheduledStore) CancelScheduled(userID, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sm, exists := s.pending[id]
+	if !exists || sm.UserID != userID {
+		return false, nil
+	}
+	delete(s.pending, id)
+	return true, nil
+}
+
+// sortScheduled orders messages by delivery time then ID
+func sortScheduled(list []*ScheduledMessage) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].DeliverAt != list[j].DeliverAt {
+			return list[i].DeliverAt < list[j].DeliverAt
+		}
+		return list[i].ID < list[j].ID
+	})
+}
+
+// setupScheduledRoutes registers the endpoints for a user's pending messages
+func setupScheduledRoutes(mux *http.ServeMux, sch *Scheduler) {
+	// GET lists ?user_id='s pending messages, soonest first. Both routes need
+	// the user's token if they are registered.
+	mux.HandleFunc("/api/scheduled", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+
+		list, err := sch.store.ListScheduled(userID)
+		if err != nil {
+			log.Printf("Error listing scheduled messages: %v", err)
+			http.Error(w, "Failed to list scheduled messages", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"scheduled": list,
+			"count":     len(list),
+		})
+	})
+
+	// DELETE /api/scheduled/{id}?user_id= cancels a pending message. Only
+	// its owner may; anyone else gets 404, as if it didn't exist.
+	mux.HandleFunc("/api/scheduled/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/api/scheduled/")
+		if id == "" {
+			http.Error(w, "message ID is required", http.StatusBadRequest)
+			return
+		}
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+
+		cancelled, err := sch.store.CancelScheduled(userID, id)
+		if err != nil {
+			log.Printf("Error cancelling scheduled message %s: %v", id, err)
+			http.Error(w, "Failed to cancel scheduled message", http.StatusInternalServerError)
+			return
+		}
+		if !cancelled {
+			http.Error(w, "scheduled message not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "cancelled"})
+	})
+}
@@ -0,0 +1,58 @@
+package wssocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScheduledRoutesCheckTheOwner(t *testing.T) {
+	sch := &Scheduler{}
+	mux := http.NewServeMux()
+	setupScheduledRoutes(mux, sch)
+	server := NewServer(ServerConfig{})
+	t.Cleanup(server.Stop)
+	restore := UseHandlerStores(server, HandlerStores{Users: NewInMemoryUserStore()})
+	t.Cleanup(restore)
+	_, token, err := server.RegisterUser("bob", "bob")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		token      string
+		wantStatus int
+		wantLeft   bool
+	}{
+		{"list without token", http.MethodGet, "/api/scheduled?user_id=bob", "", http.StatusUnauthorized, true},
+		{"list with token", http.MethodGet, "/api/scheduled?user_id=bob", token, http.StatusOK, true},
+		{"cancel as another user", http.MethodDelete, "/api/scheduled/msg_1?user_id=carol", "", http.StatusNotFound, true},
+		{"cancel as carol with bob's token", http.MethodDelete, "/api/scheduled/msg_1?user_id=carol", token, http.StatusUnauthorized, true},
+		{"cancel without token", http.MethodDelete, "/api/scheduled/msg_1?user_id=bob", "", http.StatusUnauthorized, true},
+		{"cancel as the owner", http.MethodDelete, "/api/scheduled/msg_1", token, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewInMemoryScheduledStore()
+			store.SaveScheduled(&ScheduledMessage{ID: "msg_1", UserID: "bob", DeliverAt: 1, Message: &Message{ID: "msg_1"}})
+			sch.store = store
+
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			left, _ := store.ListScheduled("bob")
+			if (len(left) == 1) != tt.wantLeft {
+				t.Fatalf("pending = %d, want left %v", len(left), tt.wantLeft)
+			}
+		})
+	}
+}
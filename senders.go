@@ -0,0 +1,98 @@
+package wssocket
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// systemMessage builds a server-originated message with a copy of payload
+func (s *Server) systemMessage(msgType MessageType, payload map[string]interface{}) *Message {
+	copied := make(map[string]interface{}, len(payload)+2)
+	for k, v := range payload {
+		copied[k] = v
+	}
+	return &Message{
+		ID:        generateMessageID(),
+		Type:      msgType,
+		Sender:    "system",
+		Timestamp: s.now().Unix(),
+		Payload:   copied,
+	}
+}
+
+// NotifyUser sends a notification to all of a user's connections and keeps it
+// in their notification center. An offline user gets it as a push
+// notification instead, which is not an error.
+func (s *Server) NotifyUser(userID, title, body string) error {
+	if userID == "" {
+		return fmt.Errorf("user ID is required")
+	}
+	msg := s.systemMessage(MessageTypeNotification, map[string]interface{}{"title": title, "body": body})
+	msg.Recipient = userID
+
+	if err := RecordNotification(globalNotifications, []string{userID}, msg); err != nil {
+		log.Printf("Error recording notification: %v", err)
+	}
+	if err := s.sendToUser(userID, msg); err != nil && !errors.Is(err, ErrRecipientOffline) {
+		return err
+	}
+	return nil
+}
+
+// Alert raises an alert in a channel. It goes through the alert manager when
+// one is set up, so the channel's policy, deduplication and escalation apply.
+func (s *Server) Alert(channel string, severity AlertSeverity, payload map[string]interface{}) error {
+	if channel == "" {
+		return fmt.Errorf("channel is required for alerts")
+	}
+	severity, err := ParseAlertSeverity(string(severity))
+	if err != nil {
+		return err
+	}
+	msg := s.systemMessage(MessageTypeAlert, payload)
+	msg.Channel = channel
+	msg.Payload["severity"] = string(severity)
+
+	if globalAlerts != nil {
+		_, err := globalAlerts.Raise(msg)
+		return err
+	}
+	if err := RecordNotification(globalNotifications, s.notificationRecipients(msg), msg); err != nil {
+		log.Printf("Error recording alert: %v", err)
+	}
+	return s.broadcastToChannel(channel, msg, &BroadcastOptions{})
+}
+
+// eventMessage builds an event message; the name goes in payload.event
+func (s *Server) eventMessage(event Event) (*Message, error) {
+	if event.Name == "" {
+		return nil, fmt.Errorf("event name is required")
+	}
+	msg := s.systemMessage(MessageTypeEvent, event.Payload)
+	msg.Payload["event"] = event.Name
+	if event.Sender != "" {
+		msg.Sender = event.Sender
+	}
+	return msg, nil
+}
+
+// Emit broadcasts a named event to every connection on this node
+func (s *Server) Emit(eventName string, payload map[string]interface{}) error {
+	msg, err := s.eventMessage(Event{Name: eventName, Payload: payload})
+	if err != nil {
+		return err
+	}
+	return s.broadcastAll(msg, &BroadcastOptions{})
+}
+
+// SendEvent sends a named event to all of a user's connections. It returns
+// ErrRecipientOffline if the user has none.
+func (s *Server) SendEvent(userID string, event Event) error {
+	msg, err := s.eventMessage(event)
+	if err != nil {
+		return err
+	}
+	msg.Recipient = userID
+	return s.sendToUser(userID, msg)
+}
@@ -0,0 +1,173 @@
+package wssocket
+
+import (
+	"log"
+	"sync"
+)
+
+// MessageTypeChannelReplay requests, and answers with, a range of channel messages
+const MessageTypeChannelReplay MessageType = "channel:replay"
+
+// DefaultChannelReplayBuffer is how many recent messages each channel keeps for replay
+const DefaultChannelReplayBuffer = 100
+
+// channelSequencer numbers a channel's messages and keeps the most recent for
+// replay. Holding mu while fanning out keeps delivery in sequence order.
+type channelSequencer struct {
+	mu     sync.Mutex
+	last   uint64
+	base   uint64     // Sequence the store had when this was created; recent holds later ones
+	recent []*Message // Ring buffer indexed by seq % len
+}
+
+// ChannelReplay is the answer to a replay request
+type ChannelReplay struct {
+	Channel   string     `json:"channel"`
+	From      uint64     `json:"from"`
+	To        uint64     `json:"to"`
+	Latest    uint64     `json:"latest"`    // Highest sequence assigned so far
+	Oldest    uint64     `json:"oldest"`    // Oldest sequence still available; 0 when none
+	Truncated bool       `json:"truncated"` // Part of the range fell out of the buffer
+	Messages  []*Message `json:"messages"`
+}
+
+// MessageSeq returns the channel sequence number of a message, or 0 if it has none
+func MessageSeq(msg *Message) uint64 {
+	switch v := msg.Metadata["seq"].(type) {
+	case uint64:
+		return v
+	case float64:
+		return uint64(v)
+	}
+	return 0
+}
+
+// sequencer returns the sequencer for a channel, creating it if needed. A
+// new sequencer continues from the store's numbering.
+func (s *Server) sequencer(channel string) *channelSequencer {
+	s.seqMu.Lock()
+	seq, exists := s.sequences[channel]
+	s.seqMu.Unlock()
+	if exists {
+		return seq
+	}
+
+	base := s.storedSequence(channel)
+	s.seqMu.Lock()
+	defer s.seqMu.Unlock()
+	if seq, exists := s.sequences[channel]; exists {
+		return seq
+	}
+	seq = &channelSequencer{last: base, base: base, recent: make([]*Message, s.config.ChannelReplayBuffer)}
+	s.sequences[channel] = seq
+	return seq
+}
+
+// storedSequence returns the highest sequence number among a channel's
+// latest stored messages, or 0 without a store
+func (s *Server) storedSequence(channel string) uint64 {
+	s.mu.RLock()
+	store := s.store
+	s.mu.RUnlock()
+	if store == nil {
+		return 0
+	}
+	msgs, err := store.GetChannelMessages(channel, Page{Limit: DefaultPageLimit})
+	if err != nil {
+		log.Printf("Error loading the sequence of %s: %v", channel, err)
+		return 0
+	}
+	var latest uint64
+	for _, msg := range msgs {
+		latest = max(latest, MessageSeq(msg))
+	}
+	return latest
+}
+
+// dropSequencer forgets a destroyed channel's numbering and replay buffer
+func (s *Server) dropSequencer(channel string) {
+	s.seqMu.Lock()
+	defer s.seqMu.Unlock()
+	delete(s.sequences, channel)
+}
+
+// assign gives msg the next sequence number and buffers it; seq.mu must be held
+func (seq *channelSequencer) assign(msg *Message) {
+	seq.last++
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]interface{})
+	}
+	msg.Metadata["seq"] = seq.last
+	if len(seq.recent) > 0 {
+		seq.recent[seq.last%uint64(len(seq.recent))] = msg
+	}
+}
+
+// oldest returns the oldest sequence still buffered; seq.mu must be held
+func (seq *channelSequencer) oldest() uint64 {
+	if seq.last == seq.base || len(seq.recent) == 0 {
+		return 0
+	}
+	if seq.last-seq.base <= uint64(len(seq.recent)) {
+		return seq.base + 1
+	}
+	return seq.last - uint64(len(seq.recent)) + 1
+}
+
+// ChannelSequence returns the highest sequence number assigned in a channel
+func (s *Server) ChannelSequence(channel string) uint64 {
+	latest, _ := s.replayWindow(channel)
+	return latest
+}
+
+// replayWindow returns the latest and oldest replayable sequence in a channel
+func (s *Server) replayWindow(channel string) (latest, oldest uint64) {
+	s.seqMu.Lock()
+	seq, exists := s.sequences[channel]
+	s.seqMu.Unlock()
+	if !exists {
+		return 0, 0
+	}
+
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+	return seq.last, seq.oldest()
+}
+
+// ReplayChannel returns buffered channel messages with sequence numbers in
+// [from, to]; to of 0 means up to the latest. Clients that see a jump in
+// metadata.seq call this to fill the gap.
+func (s *Server) ReplayChannel(channel string, from, to uint64) *ChannelReplay {
+	replay := &ChannelReplay{Channel: channel, From: from, To: to, Messages: make([]*Message, 0)}
+
+	s.seqMu.Lock()
+	seq, exists := s.sequences[channel]
+	s.seqMu.Unlock()
+	if !exists {
+		return replay
+	}
+
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+
+	replay.Latest = seq.last
+	replay.Oldest = seq.oldest()
+	if from == 0 {
+		from = 1
+	}
+	if to == 0 || to > seq.last {
+		to = seq.last
+	}
+	if replay.Oldest == 0 || from < replay.Oldest {
+		replay.Truncated = from <= to
+		from = max(from, replay.Oldest)
+	}
+	if replay.Oldest == 0 {
+		return replay
+	}
+
+	for n := from; n <= to; n++ {
+		replay.Messages = append(replay.Messages, seq.recent[n%uint64(len(seq.recent))])
+	}
+	return replay
+}
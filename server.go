@@ -1,13 +1,23 @@
-package main
+package wssocket
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Server is a stateless websocket server
@@ -16,21 +26,82 @@ type Server struct {
 	connections       map[string]*Connection
 	connectionWSMap   map[string]*websocket.Conn
 	channels          map[string]map[string]bool // channel -> {connID -> true}
+	patterns          *patternTrie               // wildcard subscriptions
 	handlers          map[MessageType]Handler
+	channelRoutes     []channelRoute // Channel handlers, checked before type handlers
+	handlerTimeouts   map[MessageType]time.Duration
+	ackStages         map[MessageType][]AckStage
+	store             MessageStore // Where persisted-stage messages are saved
 	beforeMessageHook func(*Connection, *Message) error
 	afterMessageHook  func(*Connection, *Message) error
 	onConnectHook     func(*Connection) error
 	onDisconnectHook  func(*Connection) error
+	onDeliveredHook   func(*Connection, *Message)
+	onDeliveryFailed  func(*Connection, *Message, error)
+	offlineQueueHook  func(*Message) bool
+	notifier          Notifier // Told about messages for offline users
 	config            ServerConfig
 	upgrader          websocket.Upgrader
-	messageQueue      chan *internalMessage
+	messageQueues     []*workerQueue // One per worker
+	priorities        map[MessageType]MessagePriority
+	processing        atomic.Bool
 	done              chan struct{}
+	ctx               context.Context
+	cancel            context.CancelFunc
 	maxConnections    int
+	metrics           serverMetrics
+	busy              busySampler
+	webhooks          *WebhookDispatcher
+	replayGuard       *ReplayGuard
+	idempotency       *IdempotencyCache
+	messageIDs        *messageWindow // Shared by replayGuard and idempotency; nil when neither remembers IDs
+	audit             *AuditSampler
+	readinessChecks   map[string]HealthCheck
+	rateLimit         RateLimit
+	allowedOrigins    []string
+	sessions          sessionTracker
+	protocols         map[int]*ProtocolTranslation
+	redactors         map[string]*Redactor         // channel or "prefix*" -> redactor
+	moderation        map[string]*ModerationPolicy // channel or "prefix*" -> policy
+	mutes             map[string]map[string]time.Time
+	scheduler         *Scheduler
+
+	channelPolicies    map[string]ChannelPolicy
+	channelEmptySince  map[string]time.Time
+	waitlists          map[string][]string // channel -> connection IDs waiting for a place
+	ephemeralChannels  map[string]*EphemeralChannel
+	onChannelCreated   func(string)
+	onChannelEmpty     func(string)
+	onChannelDestroyed func(string)
+
+	seqMu     sync.Mutex
+	sequences map[string]*channelSequencer
+
+	settingsMu    sync.Mutex
+	settingsCache map[string]cachedChannelSettings
+	slowMode      slowModeState
+
+	epollOnce sync.Once
+	epoll     *epollTransport
+	epollErr  error
+
+	bots         botRegistry
+	botRateLimit RateLimit
+
+	drain     drainState
+	cluster   *Cluster
+	eventSink EventSink
+
+	ips        ipThrottle
+	challenges challengeState
+	reconnects reconnectTracker
 }
 
 type internalMessage struct {
-	conn *Connection
-	msg  *Message
+	conn     *Connection
+	msg      *Message
+	audit    *AuditSampler // Set when the message was sampled for auditing
+	queuedAt time.Time
 }
 
 // NewServer creates a new WebSocket server instance
@@ -47,27 +118,109 @@ func NewServer(config ServerConfig) *Server {
 	if config.PongWait == 0 {
 		config.PongWait = 60 * time.Second
 	}
+	if config.AdaptivePing.Enabled {
+		config.AdaptivePing = config.AdaptivePing.withDefaults(config.PingInterval)
+	}
 	if config.MaxConnections == 0 {
 		config.MaxConnections = 10000
 	}
+	if config.MessageWorkers <= 0 {
+		config.MessageWorkers = runtime.GOMAXPROCS(0)
+	}
+	if config.BatchWindow <= 0 {
+		config.BatchWindow = DefaultBatchWindow
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	if config.SlowConsumerPolicy == "" {
+		config.SlowConsumerPolicy = SlowConsumerDrop
+	}
+	if config.SpoolLimit <= 0 {
+		config.SpoolLimit = DefaultSpoolLimit
+	}
+	if config.TransportMode == "" {
+		config.TransportMode = TransportModeGoroutine
+	}
+	if config.TransportMode == TransportModeEpoll && !epollSupported {
+		log.Printf("epoll transport is only supported on linux; using goroutines")
+		config.TransportMode = TransportModeGoroutine
+	}
+	if config.TransportMode == TransportModeEpoll && config.EnableCompression {
+		log.Printf("compression is not supported by the epoll transport; disabling it")
+		config.EnableCompression = false
+	}
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+	if config.NodeID == "" {
+		config.NodeID, _ = os.Hostname()
+	}
+	if config.DrainRate <= 0 {
+		config.DrainRate = DefaultDrainRate
+	}
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = DefaultMaxMessageSize
+	}
+	config.Challenge = config.Challenge.withDefaults()
+	config.Reconnect = config.Reconnect.withDefaults()
+	if config.ChannelReplayBuffer == 0 {
+		config.ChannelReplayBuffer = DefaultChannelReplayBuffer
+	} else if config.ChannelReplayBuffer < 0 {
+		config.ChannelReplayBuffer = 0
+	}
 
-	return &Server{
-		connections:     make(map[string]*Connection),
-		connectionWSMap: make(map[string]*websocket.Conn),
-		channels:        make(map[string]map[string]bool),
-		handlers:        make(map[MessageType]Handler),
-		config:          config,
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Server{
+		ctx:               ctx,
+		cancel:            cancel,
+		connections:       make(map[string]*Connection),
+		connectionWSMap:   make(map[string]*websocket.Conn),
+		channels:          make(map[string]map[string]bool),
+		patterns:          newPatternTrie(),
+		handlers:          make(map[MessageType]Handler),
+		handlerTimeouts:   make(map[MessageType]time.Duration),
+		ackStages:         make(map[MessageType][]AckStage),
+		channelPolicies:   make(map[string]ChannelPolicy),
+		channelEmptySince: make(map[string]time.Time),
+		ephemeralChannels: make(map[string]*EphemeralChannel),
+		sequences:         make(map[string]*channelSequencer),
+		readinessChecks:   make(map[string]HealthCheck),
+		protocols:         defaultProtocols(),
+		config:            config,
 		upgrader: websocket.Upgrader{
-			ReadBufferSize:  config.ReadBufferSize,
-			WriteBufferSize: config.WriteBufferSize,
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins in this implementation
-			},
+			ReadBufferSize:    config.ReadBufferSize,
+			WriteBufferSize:   config.WriteBufferSize,
+			EnableCompression: config.EnableCompression,
+			Subprotocols:      []string{BatchSubprotocol, BinarySubprotocol},
 		},
-		messageQueue:   make(chan *internalMessage, 10000),
+		messageQueues:  newWorkerQueues(config.MessageWorkers),
+		priorities:     newPriorities(config.MessagePriorities),
+		busy:           busySampler{lastAt: time.Now()},
 		done:           make(chan struct{}),
 		maxConnections: config.MaxConnections,
+		rateLimit:      config.RateLimit,
+		botRateLimit:   config.BotRateLimit,
 	}
+	s.upgrader.CheckOrigin = s.checkOrigin
+	s.SetAllowedOrigins(config.AllowedOrigins)
+	return s
+}
+
+// SetMaxConnections changes how many connections the server accepts. Lowering
+// it below the current count refuses new connections but drops none.
+func (s *Server) SetMaxConnections(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxConnections = n
+}
+
+// MaxConnections returns how many connections the server accepts
+func (s *Server) MaxConnections() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.maxConnections
 }
 
 // RegisterHandler registers a handler for a specific message type
@@ -77,6 +230,36 @@ func (s *Server) RegisterHandler(msgType MessageType, handler Handler) {
 	s.handlers[msgType] = handler
 }
 
+// UnregisterHandler removes the handler for a message type. Messages already
+// being handled finish with it; later ones fall back to default routing.
+func (s *Server) UnregisterHandler(msgType MessageType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.handlers, msgType)
+}
+
+// SetWebhookDispatcher forwards every processed message to the dispatcher
+func (s *Server) SetWebhookDispatcher(d *WebhookDispatcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks = d
+}
+
+// SetAuditSampler records a sample of inbound messages and their routing outcome
+func (s *Server) SetAuditSampler(a *AuditSampler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = a
+}
+
+// SetHandlerTimeout overrides the default handler execution timeout for a message type.
+// A zero duration removes the limit for that type.
+func (s *Server) SetHandlerTimeout(msgType MessageType, timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlerTimeouts[msgType] = timeout
+}
+
 // RegisterBeforeMessageHook registers a hook that runs before message processing
 func (s *Server) RegisterBeforeMessageHook(fn func(*Connection, *Message) error) {
 	s.mu.Lock()
@@ -105,52 +288,335 @@ func (s *Server) RegisterOnDisconnectHook(fn func(*Connection) error) {
 	s.onDisconnectHook = fn
 }
 
+// RegisterOnDeliveredHook registers a hook that runs after a message has been
+// written to a connection's transport
+func (s *Server) RegisterOnDeliveredHook(fn func(*Connection, *Message)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onDeliveredHook = fn
+}
+
+// RegisterOnDeliveryFailedHook registers a hook that runs when a message could
+// not be delivered to a connection (queue full or write error)
+func (s *Server) RegisterOnDeliveryFailedHook(fn func(*Connection, *Message, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onDeliveryFailed = fn
+}
+
+// delivered runs the delivered hook
+func (s *Server) delivered(conn *Connection, msg *Message) {
+	s.mu.RLock()
+	hook := s.onDeliveredHook
+	s.mu.RUnlock()
+	if hook != nil {
+		hook(conn, msg)
+	}
+}
+
+// deliveryFailed runs the delivery failed hook
+func (s *Server) deliveryFailed(conn *Connection, msg *Message, err error) {
+	s.mu.RLock()
+	hook := s.onDeliveryFailed
+	s.mu.RUnlock()
+	if hook != nil {
+		hook(conn, msg, err)
+	}
+}
+
 // HandleConnection upgrades an HTTP connection to WebSocket and handles it
-func (s *Server) HandleConnection(w http.ResponseWriter, r *http.Request, connID, userID string) error {
+func (s *Server) HandleConnection(w http.ResponseWriter, r *http.Request, connID, userID string) (err error) {
+	ctx := tracePropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	_, span := tracer.Start(ctx, "ws.connect", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+
+	ip := s.clientIP(r)
+	retry := s.recordConnect(userID, ip)
+	if s.Draining() {
+		w.Header().Set("Retry-After", strconv.Itoa(retrySeconds(retry)))
+		http.Error(w, ErrDraining.Error(), http.StatusServiceUnavailable)
+		return ErrDraining
+	}
+	if err := s.admitIP(connID, ip); err != nil {
+		status := http.StatusTooManyRequests
+		if errors.Is(err, ErrIPBanned) {
+			status = http.StatusForbidden
+		} else {
+			w.Header().Set("Retry-After", strconv.Itoa(retrySeconds(retry)))
+		}
+		http.Error(w, err.Error(), status)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			s.releaseIP(connID)
+		}
+	}()
+	if err := s.checkChallenge(r, ip); err != nil {
+		s.writeChallenge(w, ip, err)
+		return err
+	}
+
 	ws, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return fmt.Errorf("upgrade error: %w", err)
 	}
 
-	s.mu.Lock()
-	if len(s.connections) >= s.maxConnections {
-		s.mu.Unlock()
+	conn := newConnection(connID, userID, TransportWebSocket)
+	conn.Client = clientInfoFromRequest(r, connID)
+	conn.binary = wantsBinary(ws)
+	span.SetAttributes(connectionAttributes(conn)...)
+
+	var ec *epollConn
+	if s.config.TransportMode == TransportModeEpoll {
+		if ec, err = s.newEpollConn(conn, ws); err != nil {
+			log.Printf("serving connection %s with goroutines: %v", connID, err)
+		}
+	}
+
+	if err := s.registerConnection(conn, ws); err != nil {
+		s.refuseConnection(ws, err, retry)
 		ws.Close()
-		return fmt.Errorf("max connections reached")
+		return err
 	}
-	s.mu.Unlock()
 
+	if ec != nil {
+		return s.epoll.add(ec)
+	}
+
+	// Start reading messages from this connection
+	go s.readMessages(conn, ws)
+	go s.writeMessages(conn, ws)
+
+	return nil
+}
+
+// newConnection creates a Connection for the given transport
+func newConnection(connID, userID, transport string) *Connection {
 	conn := &Connection{
 		ID:        connID,
 		UserID:    userID,
-		Channels:  make(map[string]bool),
-		ExtraData: make(map[string]interface{}),
+		Transport: transport,
 		CreatedAt: time.Now(),
-		LastSeen:  time.Now(),
 		outChan:   make(chan *Message, 100),
+		channels:  make(map[string]bool),
 	}
+	conn.Touch()
+	return conn
+}
 
+// registerConnection adds a connection to the server and runs the connect hook.
+// It also attaches the connection context, cancelled on disconnect or Stop.
+// ws may be nil for transports that don't use a websocket.
+func (s *Server) registerConnection(conn *Connection, ws *websocket.Conn) error {
+	conn.ctx, conn.cancel = context.WithCancel(s.ctx)
+	if !conn.binary {
+		// Binary clients always speak the current message format
+		conn.protocol = s.protocolFor(conn)
+	}
+	conn.ping.reset(s.config.PingInterval, s.config.AdaptivePing)
+	session, resumed := s.prepareSession(conn)
+
+	if s.Draining() {
+		return ErrDraining
+	}
 	s.mu.Lock()
-	s.connections[connID] = conn
-	s.connectionWSMap[connID] = ws
+	if len(s.connections) >= s.maxConnections {
+		s.mu.Unlock()
+		return ErrServerFull
+	}
+	s.connections[conn.ID] = conn
+	if ws != nil {
+		s.connectionWSMap[conn.ID] = ws
+	}
+	connectHook := s.onConnectHook
+	cluster := s.cluster
 	s.mu.Unlock()
 
+	if cluster != nil {
+		cluster.connected(conn.UserID)
+	}
+
 	// Call on connect hook
-	if s.onConnectHook != nil {
-		if err := s.onConnectHook(conn); err != nil {
-			s.removeConnection(connID)
-			ws.Close()
+	if connectHook != nil {
+		if err := connectHook(conn); err != nil {
+			s.removeConnection(conn.ID)
 			return fmt.Errorf("on connect hook error: %w", err)
 		}
 	}
 
-	// Start reading messages from this connection
-	go s.readMessages(conn, ws)
-	go s.writeMessages(conn, ws)
+	s.openSession(conn, session, resumed)
+	s.publishEvent(SinkEventConnect, conn, nil, "")
+	return nil
+}
+
+// acceptMessage fills in defaults, runs the before hook and queues an inbound
+// message. It continues any trace the message carries, and passes its own
+// span on in msg.Metadata.
+func (s *Server) acceptMessage(conn *Connection, msg *Message) (err error) {
+	ctx, span := tracer.Start(ExtractTrace(context.Background(), msg), "ws.receive",
+		trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(connectionAttributes(conn)...))
+	defer func() { endSpan(span, err) }()
+
+	s.mu.RLock()
+	guard := s.replayGuard
+	idempotency := s.idempotency
+	ids := s.messageIDs
+	limit := s.rateLimit
+	s.mu.RUnlock()
+	if bot := s.bots.get(conn.UserID); bot != nil && !conn.trusted {
+		if botLimit, ok := s.allowBot(bot); !ok {
+			return s.rejectRateLimited(conn, msg, botLimit, bot.bucket.wait(botLimit))
+		}
+	} else if limit.Enabled() && !conn.bucket.allow(limit) {
+		err := s.rejectRateLimited(conn, msg, limit, conn.bucket.wait(limit))
+		s.disconnectFlooder(conn, limit)
+		return err
+	}
+	now := s.now()
+	if ids != nil && msg.ID != "" {
+		seenAt, acks, seen := ids.claim(conn.UserID, msg.ID, now)
+		age := now.Sub(seenAt)
+		switch {
+		case seen && idempotency != nil && age <= idempotency.window:
+			s.answerDuplicate(conn, msg, acks)
+			return nil
+		case seen && guard != nil && age <= guard.window:
+			err := fmt.Errorf("message %s was already received", msg.ID)
+			s.rejectReplay(conn, msg, err)
+			return fmt.Errorf("rejected message from %s: %w", conn.UserID, err)
+		case !seen:
+			// A rejected message may be retried
+			defer func() {
+				if err != nil {
+					ids.release(conn.UserID, msg.ID)
+				}
+			}()
+		}
+	}
+	if guard != nil {
+		if err := guard.checkSkew(msg, now); err != nil {
+			s.rejectReplay(conn, msg, err)
+			return fmt.Errorf("rejected message from %s: %w", conn.UserID, err)
+		}
+	}
+
+	if msg.ID == "" {
+		msg.ID = generateMessageID()
+	}
+	if msg.Timestamp == 0 {
+		msg.Timestamp = s.now().Unix()
+	}
+	// Clients speak only as the user they authenticated as; trusted publishes
+	// and bots name their own sender
+	if msg.Sender == "" {
+		msg.Sender = conn.UserID
+	} else if msg.Sender != conn.UserID && !conn.trusted {
+		return s.rejectForbidden(conn, msg, fmt.Errorf("sender %q is not the connected user %q", msg.Sender, conn.UserID))
+	}
+	stampExpiry(msg, s.now())
+	if until, muted := s.MutedUntil(conn.UserID, msg.Channel); muted {
+		return s.rejectMuted(conn, msg, until)
+	}
+	if err := s.authorizeConversation(conn, msg); err != nil {
+		return s.rejectForbidden(conn, msg, err)
+	}
+	if err := s.authorizePost(conn, msg); err != nil {
+		return s.rejectForbidden(conn, msg, err)
+	}
+	if err := s.authorizeBot(conn, msg); err != nil {
+		return s.rejectForbidden(conn, msg, err)
+	}
+	if err := s.checkBroadcastOnly(conn, msg); err != nil {
+		return s.rejectReadOnly(conn, msg, err)
+	}
+	if wait, err := s.checkSlowMode(conn, msg); err != nil {
+		return s.rejectSlowMode(conn, msg, wait, err)
+	}
+	if err := s.checkSchedule(msg); err != nil {
+		return s.rejectSchedule(conn, msg, err)
+	}
+
+	conn.Touch()
+	span.SetAttributes(messageAttributes(msg)...)
+	InjectTrace(ctx, msg)
+	msg.span = span.SpanContext()
+
+	inMsg := &internalMessage{conn: conn, msg: msg, queuedAt: time.Now()}
+	s.mu.RLock()
+	if s.audit != nil && s.audit.sample() {
+		inMsg.audit = s.audit
+	}
+	beforeHook := s.beforeMessageHook
+	priority := s.priorityFor(msg.Type)
+	moderation := s.moderationFor(msg.Channel)
+	s.mu.RUnlock()
+
+	// Call before hook
+	if beforeHook != nil {
+		if err := beforeHook(conn, msg); err != nil {
+			if inMsg.audit != nil {
+				inMsg.audit.record(conn, msg, AuditOutcomeRejected, "", err, inMsg.queuedAt, inMsg.queuedAt)
+			}
+			return fmt.Errorf("before message hook error: %w", err)
+		}
+	}
+	if moderation != nil {
+		verdict := runModeration(ctx, conn, msg, moderation.Filters, moderation.FailOpen)
+		if err := s.applyVerdict(conn, msg, verdict); err != nil {
+			if inMsg.audit != nil {
+				inMsg.audit.record(conn, msg, AuditOutcomeRejected, "", err, inMsg.queuedAt, inMsg.queuedAt)
+			}
+			return err
+		}
+	}
+	// Only accepted posts count towards slow mode, so a message refused
+	// above doesn't make the sender wait
+	if wait, err := s.claimSlowMode(conn, msg); err != nil {
+		if inMsg.audit != nil {
+			inMsg.audit.record(conn, msg, AuditOutcomeRejected, "", err, inMsg.queuedAt, inMsg.queuedAt)
+		}
+		return s.rejectSlowMode(conn, msg, wait, err)
+	}
 
+	// Ack before queueing so it always precedes the processed ack
+	if s.wantsAck(msg.Type, AckReceived) {
+		s.sendAck(conn, msg, AckReceived, nil)
+	}
+	release := func() {
+		// Hooks and moderation see the original text; handlers, subscribers and storage don't
+		s.redact(msg)
+		if s.schedule(conn, msg) {
+			return
+		}
+		s.queueFor(conn.ID).push(inMsg, priority)
+	}
+	if moderation != nil && moderation.External != nil {
+		s.metrics.moderationQuarantined.Add(1)
+		go s.releaseAfterModeration(conn, inMsg, moderation, release)
+		return nil
+	}
+	release()
 	return nil
 }
 
+// Publish injects a server-originated message into the normal pipeline, so
+// hooks and handlers run as if the sender had sent it over a socket. The
+// sender acts through a transient connection that is never registered, so
+// replies aimed at it are dropped. transport records where it came from.
+func (s *Server) Publish(msg *Message, transport string) error {
+	if msg.Type == "" {
+		return fmt.Errorf("message type is required")
+	}
+	if msg.Sender == "" {
+		msg.Sender = "system"
+	}
+
+	conn := newConnection("pub_"+uuid.New().String()[:12], msg.Sender, transport)
+	conn.trusted = true
+	return s.acceptMessage(conn, msg)
+}
+
 // readMessages reads incoming messages from a connection
 func (s *Server) readMessages(conn *Connection, ws *websocket.Conn) {
 	defer func() {
@@ -158,120 +624,239 @@ func (s *Server) readMessages(conn *Connection, ws *websocket.Conn) {
 		ws.Close()
 	}()
 
-	ws.SetReadDeadline(time.Now().Add(s.config.PongWait))
+	ws.SetReadDeadline(s.readDeadline(conn))
 	ws.SetPongHandler(func(string) error {
-		ws.SetReadDeadline(time.Now().Add(s.config.PongWait))
+		conn.ping.ponged(time.Now())
+		ws.SetReadDeadline(s.readDeadline(conn))
+		conn.Touch()
 		return nil
 	})
 
 	for {
-		var msg Message
-		err := ws.ReadJSON(&msg)
+		data, err := readLimited(ws, s.config.MaxMessageSize)
+		if errors.Is(err, errMessageTooLarge) {
+			s.rejectTooLarge(conn)
+			// Discard anything else until the client answers the close frame
+			ws.SetPongHandler(nil)
+			ws.SetReadDeadline(time.Now().Add(tooLargeGracePeriod))
+			for {
+				if _, _, err := ws.NextReader(); err != nil {
+					return
+				}
+			}
+		}
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("websocket error: %v", err)
 			}
 			return
 		}
-
-		if msg.ID == "" {
-			msg.ID = generateMessageID()
-		}
-		if msg.Timestamp == 0 {
-			msg.Timestamp = time.Now().Unix()
-		}
-		if msg.Sender == "" {
-			msg.Sender = conn.UserID
+		msg, err := s.decodeIncoming(conn, data)
+		if err != nil {
+			// Same as a failed ReadJSON: the client sent something that isn't a message
+			return
 		}
 
-		conn.LastSeen = time.Now()
-
-		// Call before hook
-		if s.beforeMessageHook != nil {
-			if err := s.beforeMessageHook(conn, &msg); err != nil {
-				log.Printf("before message hook error: %v", err)
-				continue
-			}
+		if err := s.acceptMessage(conn, msg); err != nil {
+			log.Printf("%v", err)
 		}
-
-		s.messageQueue <- &internalMessage{conn: conn, msg: &msg}
 	}
 }
 
 // writeMessages handles outgoing messages to a connection
 func (s *Server) writeMessages(conn *Connection, ws *websocket.Conn) {
-	ticker := time.NewTicker(s.config.PingInterval)
-	defer ticker.Stop()
+	pingTimer := time.NewTimer(conn.ping.current())
+	defer pingTimer.Stop()
 	defer close(conn.outChan)
+	batched := wantsBatches(ws)
 
 	for {
 		select {
 		case <-s.done:
 			return
-		case <-ticker.C:
+		case <-pingTimer.C:
+			s.pingSent(conn)
 			ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if err := ws.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
 				return
 			}
+			pingTimer.Reset(conn.ping.current())
 		case msg := <-conn.outChan:
-			if msg == nil {
-				return
-			}
-			ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := ws.WriteJSON(msg); err != nil {
+			if msg == nil || !s.writeQueued(conn, ws, msg, batched) {
 				return
 			}
 		}
 	}
 }
 
-// ProcessMessages is the main message processing loop
-func (s *Server) ProcessMessages() {
-	for {
-		select {
-		case <-s.done:
-			return
-		case inMsg := <-s.messageQueue:
-			s.processMessage(inMsg.conn, inMsg.msg)
+// writeQueued writes msg, with anything batched alongside it, and runs the
+// delivery hooks. It returns false once the connection can't be written to, or
+// a message closed it.
+func (s *Server) writeQueued(conn *Connection, ws *websocket.Conn, msg *Message, batched bool) bool {
+	if batched {
+		batch, closed := s.collectBatch(conn, msg)
+		if len(batch) == 0 {
+			return !closed
 		}
+		return s.writeBatch(conn, ws, batch) == nil && !closed && !writeClose(ws, batch...)
+	}
+	if s.dropExpired(msg) {
+		return true
 	}
+	var span trace.Span
+	if msg.span.IsValid() {
+		_, span = tracer.Start(trace.ContextWithSpanContext(context.Background(), msg.span), "ws.write",
+			trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(connectionAttributes(conn)...))
+		span.SetAttributes(attribute.String("messaging.message.id", msg.ID))
+	}
+
+	ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	var err error
+	if conn.protocol != nil {
+		err = ws.WriteJSON(s.outgoing(conn, msg))
+	} else {
+		err = writeMessage(ws, msg)
+	}
+	if span != nil {
+		endSpan(span, err)
+	}
+	if err != nil {
+		s.deliveryFailed(conn, msg, err)
+		return false
+	}
+	s.delivered(conn, msg)
+	return !writeClose(ws, msg)
+}
+
+// ProcessMessages runs the message workers and blocks until the server stops.
+// Each connection's messages are handled in order by one worker, so a slow
+// handler only delays the connections that share its worker.
+func (s *Server) ProcessMessages() {
+	if !s.processing.CompareAndSwap(false, true) {
+		log.Printf("ProcessMessages is already running")
+		return
+	}
+
+	go s.maintainIPs()
+	for _, queue := range s.messageQueues[1:] {
+		go s.runWorker(queue)
+	}
+	s.runWorker(s.messageQueues[0])
 }
 
-// processMessage handles the routing and processing of a message
-func (s *Server) processMessage(conn *Connection, msg *Message) {
+// processMessage handles the routing and processing of a message. It reports
+// how the message was routed and any handler or delivery error. Handlers and
+// hooks are read once up front, so registering or removing them while the
+// message is in flight doesn't change how it is handled.
+func (s *Server) processMessage(conn *Connection, msg *Message) (string, error) {
 	s.mu.RLock()
-	handler, exists := s.handlers[msg.Type]
+	handler, exists := s.channelHandler(msg)
+	if !exists {
+		handler, exists = s.handlers[msg.Type]
+	}
+	timeout, overridden := s.handlerTimeouts[msg.Type]
+	webhooks := s.webhooks
+	afterHook := s.afterMessageHook
 	s.mu.RUnlock()
+	if !overridden {
+		timeout = s.config.HandlerTimeout
+	}
+
+	ctx, span := tracer.Start(traceContext(context.Background(), msg), "ws.process",
+		trace.WithAttributes(messageAttributes(msg)...))
+	msg.span = span.SpanContext()
 
+	var route string
+	var err error
 	if exists {
-		if err := handler(conn, msg); err != nil {
+		route = "handler"
+		_, handlerSpan := tracer.Start(ctx, "ws.handler", trace.WithAttributes(attribute.String("ws.message.type", string(msg.Type))))
+		msg.span = handlerSpan.SpanContext()
+		err = s.runHandler(handler, conn, msg, timeout, handlerSpan)
+		endSpan(handlerSpan, err)
+		if err != nil {
 			log.Printf("handler error for type %s: %v", msg.Type, err)
 		}
 	} else {
 		// Default handling - route to recipient or channel
-		s.routeMessage(conn, msg)
+		route, err = s.routeMessage(conn, msg)
 	}
+	span.SetAttributes(attribute.String("ws.route", route))
+	endSpan(span, err)
 
 	// Call after hook
-	if s.afterMessageHook != nil {
-		if err := s.afterMessageHook(conn, msg); err != nil {
+	if afterHook != nil {
+		if err := afterHook(conn, msg); err != nil {
 			log.Printf("after message hook error: %v", err)
 		}
 	}
+
+	// Notify external systems
+	if webhooks != nil {
+		webhooks.Dispatch(msg)
+	}
+	s.publishEvent(SinkEventMessage, conn, msg, route)
+
+	return route, err
+}
+
+// runHandler runs a handler, aborting it once the timeout expires. An aborted
+// handler keeps running in the background until it observes msg.Context(),
+// but the queue moves on and the sender is told the message timed out.
+// msg.Context() carries span, so handlers can trace their own work under it.
+func (s *Server) runHandler(handler Handler, conn *Connection, msg *Message, timeout time.Duration, span trace.Span) error {
+	base := trace.ContextWithSpan(conn.Context(), span)
+	if timeout <= 0 {
+		msg.ctx = base
+		return handler(conn, msg)
+	}
+
+	ctx, cancel := context.WithTimeout(base, timeout)
+	defer cancel()
+	msg.ctx = ctx
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler(conn, msg)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	if ctx.Err() != context.DeadlineExceeded {
+		return fmt.Errorf("handler aborted: %w", ctx.Err())
+	}
+
+	s.metrics.handlerTimeouts.Add(1)
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeError,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"code":       "timeout",
+			"error":      fmt.Sprintf("handler for %s exceeded %s", msg.Type, timeout),
+			"message_id": msg.ID,
+		},
+	})
+	return fmt.Errorf("handler timed out after %s", timeout)
 }
 
-// routeMessage routes a message to its destination
-func (s *Server) routeMessage(conn *Connection, msg *Message) {
+// routeMessage routes a message to its destination and reports the route taken
+func (s *Server) routeMessage(conn *Connection, msg *Message) (string, error) {
 	if msg.Recipient != "" {
 		// Direct message
-		s.sendToUser(msg.Recipient, msg)
+		return "direct", s.deliverDirect(conn, msg)
 	} else if msg.Channel != "" {
 		// Channel broadcast
-		s.broadcastToChannel(msg.Channel, msg, &BroadcastOptions{ExcludeConnID: true})
-	} else {
-		// Broadcast to all
-		s.broadcastAll(msg, &BroadcastOptions{})
+		return "channel", s.broadcastToChannel(msg.Channel, msg, &BroadcastOptions{ExcludeConnID: true})
 	}
+	// Broadcast to all
+	return "broadcast", s.broadcastAll(msg, &BroadcastOptions{})
 }
 
 // SendToConnection sends a message to a specific connection
@@ -289,48 +874,125 @@ func (s *Server) SendToConnection(connID string, msg *Message) error {
 			log.Printf("Recovered from panic sending to connection %s: %v", connID, r)
 		}
 	}()
+	if isEphemeral(msg) {
+		return s.sendEphemeral(conn, msg)
+	}
+	// Once a connection has overflow, later messages queue behind it
+	if queued, err := s.sendBacklogged(conn, msg); queued || err != nil {
+		return err
+	}
 	select {
 	case conn.outChan <- msg:
+		conn.notifyWriter()
 		return nil
 	default:
-		return fmt.Errorf("outgoing message channel full for connection: %s", connID)
+		return s.slowConsumer(conn, msg)
 	}
 }
 
-// sendToUser sends a message to a specific user (to all their connections)
+// sendToUser sends a message to a specific user (to all their connections),
+// forwarding it to the other cluster nodes the user is connected to. It
+// returns ErrRecipientOffline if the user has none, after handing the
+// message to the notifier.
 func (s *Server) sendToUser(userID string, msg *Message) error {
+	online := s.sendToLocalUser(userID, msg)
+
 	s.mu.RLock()
-	defer s.mu.RUnlock()
+	notifier := s.notifier
+	cluster := s.cluster
+	s.mu.RUnlock()
+	if cluster != nil && cluster.forward(userID, msg) > 0 {
+		online = true
+	}
+
+	if !online {
+		if notifier != nil && wantsPush(userID, msg) {
+			notifier.Notify(userID, msg)
+		}
+		return ErrRecipientOffline
+	}
+	return nil
+}
 
+// sendToLocalUser sends a message to a user's connections on this node, and
+// reports whether it has any
+func (s *Server) sendToLocalUser(userID string, msg *Message) bool {
+	s.mu.RLock()
+	connIDs := make([]string, 0)
+	online := false
 	for connID, conn := range s.connections {
 		if conn.UserID == userID {
-			ws := s.connectionWSMap[connID]
-			if ws != nil {
-				ws.WriteJSON(msg)
+			online = true
+			if conn.wants(msg, "") {
+				connIDs = append(connIDs, connID)
 			}
 		}
 	}
-	return nil
+	s.mu.RUnlock()
+
+	for _, connID := range connIDs {
+		s.SendToConnection(connID, msg)
+	}
+	return online
 }
 
 // BroadcastToChannel sends a message to all connections in a channel
 func (s *Server) broadcastToChannel(channel string, msg *Message, opts *BroadcastOptions) error {
-	s.mu.RLock()
-	connIDs, exists := s.channels[channel]
-	if !exists {
-		s.mu.RUnlock()
-		return fmt.Errorf("channel not found: %s", channel)
+	if IsChannelPattern(channel) {
+		return fmt.Errorf("cannot broadcast to channel pattern: %s", channel)
 	}
 
-	// Create a copy of connection IDs to avoid holding lock during sends
-	connsToSend := make([]string, 0, len(connIDs))
+	patternsReach := s.patternsReach(channel)
+	var participants []string
+	if isGroupConversation(channel) {
+		participants = s.conversationParticipants(channel)
+	}
+
+	// Number the message and fan out under the channel's sequencer so every
+	// subscriber sees the channel in sequence order
+	seq := s.sequencer(channel)
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+
+	// Collect exact and pattern subscribers to avoid holding lock during sends
+	s.mu.RLock()
+	connIDs, exists := s.channels[channel]
+	connsToSend := make(map[string]bool, len(connIDs))
 	for connID := range connIDs {
-		connsToSend = append(connsToSend, connID)
+		connsToSend[connID] = true
+	}
+	if patternsReach {
+		s.patterns.match(channel, connsToSend)
+	}
+	// Group conversations reach every connection of their participants
+	for _, userID := range participants {
+		for connID, conn := range s.connections {
+			if conn.UserID == userID {
+				connsToSend[connID] = true
+			}
+		}
+	}
+	found := exists || len(connsToSend) > 0
+	// Skip connections whose filters turn the message down
+	for connID := range connsToSend {
+		if conn := s.connections[connID]; conn != nil && !conn.wants(msg, channel) {
+			delete(connsToSend, connID)
+		}
 	}
 	s.mu.RUnlock()
 
-	for _, connID := range connsToSend {
-		s.SendToConnection(connID, msg)
+	if !found {
+		return fmt.Errorf("channel not found: %s", channel)
+	}
+	seq.assign(msg)
+
+	_, span := tracer.Start(traceContext(context.Background(), msg), "ws.broadcast", trace.WithAttributes(
+		attribute.String("ws.channel", channel), attribute.Int("ws.recipients", len(connsToSend))))
+	defer span.End()
+
+	out := withSpan(forFanout(msg, len(connsToSend)), span.SpanContext())
+	for connID := range connsToSend {
+		s.SendToConnection(connID, out)
 	}
 
 	return nil
@@ -340,57 +1002,86 @@ func (s *Server) broadcastToChannel(channel string, msg *Message, opts *Broadcas
 func (s *Server) broadcastAll(msg *Message, opts *BroadcastOptions) error {
 	s.mu.RLock()
 	connIDs := make([]string, 0, len(s.connections))
-	for connID := range s.connections {
-		connIDs = append(connIDs, connID)
+	for connID, conn := range s.connections {
+		if conn.wants(msg, "") {
+			connIDs = append(connIDs, connID)
+		}
 	}
 	s.mu.RUnlock()
 
+	_, span := tracer.Start(traceContext(context.Background(), msg), "ws.broadcast",
+		trace.WithAttributes(attribute.Int("ws.recipients", len(connIDs))))
+	defer span.End()
+
+	out := withSpan(forFanout(msg, len(connIDs)), span.SpanContext())
 	for _, connID := range connIDs {
-		s.SendToConnection(connID, msg)
+		s.SendToConnection(connID, out)
 	}
 
 	return nil
 }
 
-// SubscribeToChannel subscribes a connection to a channel
+// SubscribeToChannel subscribes a connection to a channel or a channel
+// pattern such as "orders.*"
 func (s *Server) SubscribeToChannel(connID, channel string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	limit := 0
+	if conn, exists := s.GetConnection(connID); exists {
+		if err := s.authorizeSubscription(conn, channel); err != nil {
+			return err
+		}
+		limit = s.memberLimit(conn, channel)
+	}
 
+	s.mu.Lock()
 	conn, exists := s.connections[connID]
 	if !exists {
+		s.mu.Unlock()
 		return fmt.Errorf("connection not found: %s", connID)
 	}
+	if full := s.channelFullLocked(channel, conn, limit); full != nil {
+		full.Position = s.waitlistLocked(channel, connID)
+		s.mu.Unlock()
+		s.sendWaitlist(conn, full)
+		return full
+	}
 
-	conn.Channels[channel] = true
-
-	if _, exists := s.channels[channel]; !exists {
-		s.channels[channel] = make(map[string]bool)
+	conn.addChannel(channel)
+	if IsChannelPattern(channel) {
+		s.patterns.add(channel, connID)
+		s.mu.Unlock()
+		s.confirmSubscription(conn, channel, MessageTypeChannelSubscribed)
+		return nil
 	}
-	s.channels[channel][connID] = true
+	event := s.joinChannelLocked(channel, connID)
+	s.leaveWaitlistLocked(channel, connID)
+	s.mu.Unlock()
 
+	s.fireChannelLifecycle(event)
+	s.confirmSubscription(conn, channel, MessageTypeChannelSubscribed)
 	return nil
 }
 
 // UnsubscribeFromChannel unsubscribes a connection from a channel
 func (s *Server) UnsubscribeFromChannel(connID, channel string) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	conn, exists := s.connections[connID]
 	if !exists {
+		s.mu.Unlock()
 		return fmt.Errorf("connection not found: %s", connID)
 	}
 
-	delete(conn.Channels, channel)
-
-	if chans, exists := s.channels[channel]; exists {
-		delete(chans, connID)
-		if len(chans) == 0 {
-			delete(s.channels, channel)
-		}
+	conn.removeChannel(channel)
+	if IsChannelPattern(channel) {
+		s.patterns.remove(channel, connID)
+		s.mu.Unlock()
+		s.confirmSubscription(conn, channel, MessageTypeChannelUnsubscribed)
+		return nil
 	}
+	event := s.leaveChannelLocked(channel, connID)
+	s.mu.Unlock()
 
+	s.fireChannelLifecycle(event)
+	s.confirmSubscription(conn, channel, MessageTypeChannelUnsubscribed)
 	return nil
 }
 
@@ -409,42 +1100,53 @@ func (s *Server) GetConnections() []ConnectionInfo {
 
 	conns := make([]ConnectionInfo, 0, len(s.connections))
 	for _, conn := range s.connections {
-		channels := make([]string, 0, len(conn.Channels))
-		for ch := range conn.Channels {
-			channels = append(channels, ch)
-		}
+		channels := conn.Channels()
 
 		conns = append(conns, ConnectionInfo{
-			ID:       conn.ID,
-			UserID:   conn.UserID,
-			Status:   "active",
-			Channels: channels,
+			ID:        conn.ID,
+			UserID:    conn.UserID,
+			Status:    "active",
+			Transport: conn.Transport,
+			Channels:  channels,
+			Tags:      conn.Tags(),
+			Dropped:   conn.Dropped(),
+			RTT:       conn.Latency(),
+			ClockSkew: conn.ClockSkew(),
 		})
 	}
 
 	return conns
 }
 
-// GetActiveUsersInChannel returns all active users in a specific channel
+// GetActiveUsersInChannel returns all active users in a specific channel,
+// including those connected to other cluster nodes
 func (s *Server) GetActiveUsersInChannel(channel string) []string {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	users := make([]string, 0)
-	connIDs, exists := s.channels[channel]
-	if !exists {
-		return users
-	}
-
 	seen := make(map[string]bool)
-	for connID := range connIDs {
+	for connID := range s.channels[channel] {
 		conn, exists := s.connections[connID]
 		if exists && !seen[conn.UserID] {
 			users = append(users, conn.UserID)
 			seen[conn.UserID] = true
 		}
 	}
+	cluster := s.cluster
+	s.mu.RUnlock()
 
+	if cluster == nil {
+		return users
+	}
+	remote, err := cluster.ChannelUsers(channel)
+	if err != nil {
+		log.Printf("cluster: presence of %s: %v", channel, err)
+	}
+	for _, userID := range remote {
+		if !seen[userID] {
+			users = append(users, userID)
+			seen[userID] = true
+		}
+	}
 	return users
 }
 
@@ -462,27 +1164,49 @@ func (s *Server) removeConnection(connID string) {
 		s.onDisconnectHook(conn)
 	}
 
-	delete(s.connections, connID)
-	delete(s.connectionWSMap, connID)
+	// Cancel any work still running on behalf of this connection
+	if conn.cancel != nil {
+		conn.cancel()
+	}
 
-	// Remove from all channels
-	for channel := range conn.Channels {
-		if chans, exists := s.channels[channel]; exists {
-			delete(chans, connID)
-			if len(chans) == 0 {
-				delete(s.channels, channel)
-			}
+	// Remove from all channels, while the connection can still be looked up
+	channels := conn.Channels()
+	events := make([]channelLifecycle, 0, len(channels))
+	for _, channel := range channels {
+		if IsChannelPattern(channel) {
+			s.patterns.remove(channel, connID)
+			continue
 		}
+		events = append(events, s.leaveChannelLocked(channel, connID))
 	}
 
+	delete(s.connections, connID)
+	delete(s.connectionWSMap, connID)
+	cluster := s.cluster
+
 	s.mu.Unlock()
+
+	s.releaseIP(connID)
+	if cluster != nil {
+		cluster.disconnected(conn.UserID)
+	}
+	s.fireChannelLifecycle(events...)
+	s.closeSession(conn)
+	s.publishEvent(SinkEventDisconnect, conn, nil, "")
 }
 
 // Stop gracefully stops the server
 func (s *Server) Stop() {
+	if cluster := s.Cluster(); cluster != nil {
+		if err := cluster.Leave(); err != nil {
+			log.Printf("cluster: leave: %v", err)
+		}
+	}
 	close(s.done)
+	s.cancel()
 	s.mu.Lock()
 	for _, ws := range s.connectionWSMap {
+		writeCloseFrame(ws, websocket.CloseGoingAway, "server shutting down")
 		ws.Close()
 	}
 	s.mu.Unlock()
@@ -0,0 +1,89 @@
+package wssocket
+
+import "testing"
+
+func TestSpoofedSenderIsRejected(t *testing.T) {
+	server := NewServer(ServerConfig{})
+	t.Cleanup(server.Stop)
+	restore := UseHandlerStores(server, HandlerStores{})
+	t.Cleanup(restore)
+
+	mallory := newConnection("conn_1", "mallory", TransportWebSocket)
+	if err := server.registerConnection(mallory, nil); err != nil {
+		t.Fatal(err)
+	}
+	for len(mallory.outChan) > 0 {
+		<-mallory.outChan
+	}
+
+	msg := &Message{
+		ID:        "m1",
+		Type:      MessageTypeChatPrivate,
+		Sender:    "alice",
+		Recipient: "bob",
+		Payload:   map[string]interface{}{"content": "hi"},
+	}
+	if err := server.acceptMessage(mallory, msg); err == nil {
+		t.Fatal("message sent as another user was accepted")
+	}
+	select {
+	case reply := <-mallory.outChan:
+		if reply.Type != MessageTypeError || reply.Payload["code"] != "forbidden" {
+			t.Fatalf("reply = %+v, want a forbidden error", reply)
+		}
+	default:
+		t.Fatal("the spoofing connection got no error")
+	}
+
+	// The connection's own user, or none, is accepted
+	for _, sender := range []string{"mallory", ""} {
+		msg := &Message{Type: MessageTypeChatPrivate, Sender: sender, Recipient: "bob", Payload: map[string]interface{}{}}
+		if err := server.acceptMessage(mallory, msg); err != nil {
+			t.Fatalf("sender %q rejected: %v", sender, err)
+		}
+		if msg.Sender != "mallory" {
+			t.Fatalf("sender = %q, want mallory", msg.Sender)
+		}
+	}
+
+	// Trusted publishes name their own sender
+	if err := server.Publish(&Message{Type: MessageTypeChatPrivate, Sender: "alerts", Recipient: "bob", Payload: map[string]interface{}{}}, TransportHTTP); err != nil {
+		t.Fatalf("trusted publish rejected: %v", err)
+	}
+}
+
+func TestNonMembersCannotPostToPrivateChannels(t *testing.T) {
+	server := NewServer(ServerConfig{})
+	t.Cleanup(server.Stop)
+	channels := NewInMemoryChannelStore()
+	restore := UseHandlerStores(server, HandlerStores{Channels: channels})
+	t.Cleanup(restore)
+	for channel, visibility := range map[string]ChannelVisibility{"staff": VisibilitySecret, "ops": VisibilityPrivate} {
+		channels.SaveChannelSettings(&ChannelSettings{Channel: channel, Visibility: visibility})
+		channels.SetChannelRole(&ChannelMember{Channel: channel, UserID: "alice", Role: RoleMember})
+	}
+
+	connect := func(id, userID string) *Connection {
+		conn := newConnection(id, userID, TransportWebSocket)
+		if err := server.registerConnection(conn, nil); err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	alice, outsider := connect("conn_1", "alice"), connect("conn_2", "mallory")
+	post := func(conn *Connection, channel string) error {
+		return server.acceptMessage(conn, &Message{Type: MessageTypeChatGroup, Channel: channel, Payload: map[string]interface{}{"content": "hi"}})
+	}
+
+	for _, channel := range []string{"staff", "ops"} {
+		if err := post(outsider, channel); err == nil {
+			t.Errorf("non-member posted to %s", channel)
+		}
+		if err := post(alice, channel); err != nil {
+			t.Errorf("member refused in %s: %v", channel, err)
+		}
+	}
+	if err := post(outsider, "general"); err != nil {
+		t.Errorf("post to a public channel refused: %v", err)
+	}
+}
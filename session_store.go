@@ -0,0 +1,585 @@
+package wssocket
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultSessionTTL is how long a session can be resumed after its last
+// connection closes
+const DefaultSessionTTL = 2 * time.Minute
+
+// MessageTypeSession tells a client its session ID and resume token
+const MessageTypeSession MessageType = "session"
+
+// ErrSessionNotFound is returned for unknown or expired sessions
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionRecord is a session as kept in a SessionStore, so it can be resumed
+// after a reconnect and found from any node
+type SessionRecord struct {
+	ID          string                     `json:"id"`
+	UserID      string                     `json:"user_id"`
+	Node        string                     `json:"node"`      // Node holding, or last holding, the session's connections
+	Connected   bool                       `json:"connected"` // False once every connection has closed
+	Device      string                     `json:"device"`
+	IP          string                     `json:"ip"`
+	ResumeToken string                     `json:"resume_token,omitempty"`
+	Data        map[string]string          `json:"data,omitempty"`            // Application state carried across reconnects
+	ConnData    map[string]json.RawMessage `json:"connection_data,omitempty"` // Connection values under persisted keys
+	ConnectedAt time.Time                  `json:"connected_at"`
+	LastSeen    time.Time                  `json:"last_seen"`
+	ExpiresAt   time.Time                  `json:"expires_at"`
+}
+
+// SessionStore keeps session records where every node can read them.
+// Records must not be returned after ExpiresAt.
+type SessionStore interface {
+	SaveSession(rec *SessionRecord) error
+	GetSession(id string) (*SessionRecord, error) // ErrSessionNotFound when missing or expired
+	DeleteSession(id string) error
+	ListUserSessions(userID string) ([]*SessionRecord, error)
+}
+
+// MemorySessionStore keeps sessions in process, for single-node deployments
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]SessionRecord
+}
+
+// NewMemorySessionStore creates an empty in-memory session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]SessionRecord)}
+}
+
+// SaveSession stores a copy of the record
+func (m *MemorySessionStore) SaveSession(rec *SessionRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[rec.ID] = rec.clone()
+	return nil
+}
+
+// GetSession returns a live record
+func (m *MemorySessionStore) GetSession(id string) (*SessionRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, exists := m.sessions[id]
+	if !exists || time.Now().After(rec.ExpiresAt) {
+		delete(m.sessions, id)
+		return nil, ErrSessionNotFound
+	}
+	rec = rec.clone()
+	return &rec, nil
+}
+
+// DeleteSession removes a record
+func (m *MemorySessionStore) DeleteSession(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// ListUserSessions returns a user's live records, dropping expired ones
+func (m *MemorySessionStore) ListUserSessions(userID string) ([]*SessionRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	records := make([]*SessionRecord, 0)
+	for id, rec := range m.sessions {
+		if now.After(rec.ExpiresAt) {
+			delete(m.sessions, id)
+			continue
+		}
+		if rec.UserID == userID {
+			rec = rec.clone()
+			records = append(records, &rec)
+		}
+	}
+	return records, nil
+}
+
+// RedisSessionStore keeps sessions in Redis with native key expiry
+type RedisSessionStore struct {
+	client *RedisClient
+	prefix string
+}
+
+// NewRedisSessionStore creates a store whose keys start with prefix,
+// "gows:" by default
+func NewRedisSessionStore(client *RedisClient, prefix string) *RedisSessionStore {
+	if prefix == "" {
+		prefix = "gows:"
+	}
+	return &RedisSessionStore{client: client, prefix: prefix}
+}
+
+func (r *RedisSessionStore) sessionKey(id string) string { return r.prefix + "session:" + id }
+func (r *RedisSessionStore) userKey(userID string) string {
+	return r.prefix + "user_sessions:" + userID
+}
+
+// SaveSession writes the record and indexes it under its user
+func (r *RedisSessionStore) SaveSession(rec *SessionRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	ttl := max(time.Until(rec.ExpiresAt).Milliseconds(), 1)
+	if _, err := r.client.Do("SET", r.sessionKey(rec.ID), string(data), "PX", fmt.Sprint(ttl)); err != nil {
+		return fmt.Errorf("save session %s: %w", rec.ID, err)
+	}
+	// Every session shares one TTL, so the index lives as long as the
+	// most recently saved session
+	if _, err := r.client.Do("SADD", r.userKey(rec.UserID), rec.ID); err != nil {
+		return fmt.Errorf("index session %s: %w", rec.ID, err)
+	}
+	_, err = r.client.Do("PEXPIRE", r.userKey(rec.UserID), fmt.Sprint(ttl))
+	return err
+}
+
+// GetSession reads a record
+func (r *RedisSessionStore) GetSession(id string) (*SessionRecord, error) {
+	reply, err := r.client.Do("GET", r.sessionKey(id))
+	if err == redisNil {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get session %s: %w", id, err)
+	}
+	return decodeSessionRecord(reply)
+}
+
+// DeleteSession removes a record and its index entry
+func (r *RedisSessionStore) DeleteSession(id string) error {
+	rec, err := r.GetSession(id)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := r.client.Do("DEL", r.sessionKey(id)); err != nil {
+		return fmt.Errorf("delete session %s: %w", id, err)
+	}
+	_, err = r.client.Do("SREM", r.userKey(rec.UserID), id)
+	return err
+}
+
+// ListUserSessions returns a user's records, pruning index entries that expired
+func (r *RedisSessionStore) ListUserSessions(userID string) ([]*SessionRecord, error) {
+	reply, err := r.client.Do("SMEMBERS", r.userKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("list sessions for %s: %w", userID, err)
+	}
+	ids, _ := reply.([]interface{})
+	records := make([]*SessionRecord, 0, len(ids))
+	if len(ids) == 0 {
+		return records, nil
+	}
+
+	keys := make([]string, 0, len(ids)+1)
+	keys = append(keys, "MGET")
+	for _, id := range ids {
+		keys = append(keys, r.sessionKey(fmt.Sprint(id)))
+	}
+	reply, err = r.client.Do(keys...)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions for %s: %w", userID, err)
+	}
+	values, _ := reply.([]interface{})
+
+	expired := []string{"SREM", r.userKey(userID)}
+	for i, value := range values {
+		if value == nil {
+			expired = append(expired, fmt.Sprint(ids[i]))
+			continue
+		}
+		rec, err := decodeSessionRecord(value)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	if len(expired) > 2 {
+		if _, err := r.client.Do(expired...); err != nil {
+			log.Printf("prune expired sessions for %s: %v", userID, err)
+		}
+	}
+	return records, nil
+}
+
+// decodeSessionRecord parses a record stored as JSON
+func decodeSessionRecord(reply interface{}) (*SessionRecord, error) {
+	data, ok := reply.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected session reply %T", reply)
+	}
+	var rec SessionRecord
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return nil, fmt.Errorf("decode session: %w", err)
+	}
+	return &rec, nil
+}
+
+// clone copies a record, including its data map
+func (rec SessionRecord) clone() SessionRecord {
+	if rec.Data != nil {
+		data := make(map[string]string, len(rec.Data))
+		for k, v := range rec.Data {
+			data[k] = v
+		}
+		rec.Data = data
+	}
+	if rec.ConnData != nil {
+		connData := make(map[string]json.RawMessage, len(rec.ConnData))
+		for k, v := range rec.ConnData {
+			connData[k] = v
+		}
+		rec.ConnData = connData
+	}
+	return rec
+}
+
+// sessionTracker holds the sessions with connections on this node and
+// mirrors them to the configured SessionStore
+type sessionTracker struct {
+	mu          sync.Mutex
+	store       SessionStore
+	ttl         time.Duration
+	live        map[string]*liveSession // session ID -> local state
+	persistKeys map[string]bool         // Connection values saved with the session
+	refreshing  bool
+}
+
+// liveSession is a session with at least one connection on this node
+type liveSession struct {
+	record SessionRecord
+	conns  map[string]*Connection
+}
+
+// snapshot copies the record with the persisted values of its connections
+func (l *liveSession) snapshot(persistKeys map[string]bool) SessionRecord {
+	rec := l.record.clone()
+	if len(persistKeys) > 0 {
+		rec.ConnData = make(map[string]json.RawMessage)
+		for _, conn := range l.conns {
+			conn.persistedData(persistKeys, rec.ConnData)
+		}
+	}
+	return rec
+}
+
+// SetSessionStore keeps sessions in store so clients can resume them for ttl
+// after disconnecting, and other nodes can look them up. ttl of 0 uses
+// DefaultSessionTTL; a nil store turns this off.
+func (s *Server) SetSessionStore(store SessionStore, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+
+	t := &s.sessions
+	t.mu.Lock()
+	t.store, t.ttl = store, ttl
+	if t.live == nil {
+		t.live = make(map[string]*liveSession)
+	}
+	start := store != nil && !t.refreshing
+	if start {
+		t.refreshing = true
+	}
+	t.mu.Unlock()
+
+	if start {
+		go s.refreshSessions()
+	}
+}
+
+// prepareSession decides which session a new connection belongs to before it
+// is registered. A client resumes a stored session by sending its session_id
+// and resume_token; a session_id held by someone else gets a fresh ID.
+func (s *Server) prepareSession(conn *Connection) (rec *SessionRecord, resumed bool) {
+	t := &s.sessions
+	t.mu.Lock()
+	store, ttl := t.store, t.ttl
+	if live, exists := t.live[conn.Client.SessionID]; exists && live.record.UserID == conn.UserID {
+		// Another connection from the same device on this node
+		t.mu.Unlock()
+		return nil, false
+	}
+	t.mu.Unlock()
+	if store == nil {
+		return nil, false
+	}
+
+	now := time.Now()
+	rec = &SessionRecord{
+		ID:          conn.Client.SessionID,
+		UserID:      conn.UserID,
+		ConnectedAt: now,
+	}
+
+	// Sessions the server named after the connection can't exist yet
+	if conn.Client.SessionID != conn.ID {
+		s.metrics.sessionLookups.Add(1)
+		stored, err := store.GetSession(conn.Client.SessionID)
+		switch {
+		case err == nil:
+			if stored.UserID == conn.UserID && conn.Client.ResumeToken != "" &&
+				subtle.ConstantTimeCompare([]byte(stored.ResumeToken), []byte(conn.Client.ResumeToken)) == 1 {
+				rec, resumed = stored, true
+				s.metrics.sessionsResumed.Add(1)
+				t.mu.Lock()
+				conn.restoreData(t.persistKeys, rec.ConnData)
+				t.mu.Unlock()
+			} else {
+				rec.ID = "sess_" + uuid.New().String()
+				conn.Client.SessionID = rec.ID
+			}
+		case errors.Is(err, ErrSessionNotFound):
+			s.metrics.sessionMisses.Add(1)
+		default:
+			s.metrics.sessionStoreErrors.Add(1)
+			log.Printf("session lookup for %s failed: %v", conn.ID, err)
+		}
+	}
+
+	if rec.ResumeToken == "" {
+		rec.ResumeToken = newResumeToken()
+	}
+	rec.Node = s.config.NodeID
+	rec.Connected = true
+	rec.Device, rec.IP = conn.Client.Device, conn.Client.IP
+	rec.LastSeen = now
+	rec.ExpiresAt = now.Add(ttl)
+	return rec, resumed
+}
+
+// openSession records a registered connection in its session and tells the
+// client how to resume it. rec and resumed are what prepareSession returned.
+func (s *Server) openSession(conn *Connection, rec *SessionRecord, resumed bool) {
+	t := &s.sessions
+	t.mu.Lock()
+	if t.store == nil {
+		t.mu.Unlock()
+		return
+	}
+	live, exists := t.live[conn.Client.SessionID]
+	if !exists || live.record.UserID != conn.UserID {
+		if rec == nil {
+			// The session this connection meant to join has just closed
+			t.mu.Unlock()
+			rec, resumed := s.prepareSession(conn)
+			s.openSession(conn, rec, resumed)
+			return
+		}
+		live = &liveSession{record: *rec, conns: make(map[string]*Connection)}
+		t.live[rec.ID] = live
+	} else {
+		rec = nil // Joined a session already saved
+	}
+	live.conns[conn.ID] = conn
+	info := live.record.clone()
+	store, ttl := t.store, t.ttl
+	t.mu.Unlock()
+
+	if rec != nil {
+		s.saveSession(store, rec)
+	}
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeSession,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"session_id":   info.ID,
+			"resume_token": info.ResumeToken,
+			"resumed":      resumed,
+			"ttl_secs":     int(ttl.Seconds()),
+		},
+	})
+}
+
+// closeSession removes a connection from its session. When it was the last
+// one here, the session is saved as disconnected and can be resumed until it
+// expires.
+func (s *Server) closeSession(conn *Connection) {
+	t := &s.sessions
+	t.mu.Lock()
+	live, exists := t.live[conn.Client.SessionID]
+	if !exists || live.conns[conn.ID] == nil {
+		t.mu.Unlock()
+		return
+	}
+	if len(live.conns) > 1 {
+		delete(live.conns, conn.ID)
+		t.mu.Unlock()
+		return
+	}
+	rec := live.snapshot(t.persistKeys)
+	delete(t.live, conn.Client.SessionID)
+	store, ttl := t.store, t.ttl
+	t.mu.Unlock()
+
+	if store == nil {
+		return
+	}
+	now := time.Now()
+	rec.Connected = false
+	rec.LastSeen = now
+	rec.ExpiresAt = now.Add(ttl)
+	s.saveSession(store, &rec)
+}
+
+// forgetSession deletes a session so it can't be resumed
+func (s *Server) forgetSession(sessionID string) {
+	t := &s.sessions
+	t.mu.Lock()
+	store := t.store
+	delete(t.live, sessionID)
+	t.mu.Unlock()
+
+	if store != nil {
+		if err := store.DeleteSession(sessionID); err != nil {
+			s.metrics.sessionStoreErrors.Add(1)
+			log.Printf("delete session %s: %v", sessionID, err)
+		}
+	}
+}
+
+// saveSession writes a record, counting failures
+func (s *Server) saveSession(store SessionStore, rec *SessionRecord) {
+	if err := store.SaveSession(rec); err != nil {
+		s.metrics.sessionStoreErrors.Add(1)
+		log.Printf("save session %s: %v", rec.ID, err)
+	}
+}
+
+// refreshSessions extends the expiry of sessions that are still connected
+func (s *Server) refreshSessions() {
+	for {
+		s.sessions.mu.Lock()
+		interval := s.sessions.ttl / 3
+		s.sessions.mu.Unlock()
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		t := &s.sessions
+		t.mu.Lock()
+		store, ttl := t.store, t.ttl
+		records := make([]SessionRecord, 0, len(t.live))
+		now := time.Now()
+		for _, live := range t.live {
+			live.record.ExpiresAt = now.Add(ttl)
+			live.record.LastSeen = now
+			records = append(records, live.snapshot(t.persistKeys))
+		}
+		t.mu.Unlock()
+
+		if store == nil {
+			continue
+		}
+		for i := range records {
+			s.saveSession(store, &records[i])
+		}
+		if pruner, ok := store.(interface{ DeleteExpiredSessions() (int, error) }); ok {
+			if _, err := pruner.DeleteExpiredSessions(); err != nil {
+				log.Printf("delete expired sessions: %v", err)
+			}
+		}
+	}
+}
+
+// LookupSession finds a session on any node, including disconnected sessions
+// that can still be resumed. The resume token is not included.
+func (s *Server) LookupSession(sessionID string) (*SessionRecord, error) {
+	t := &s.sessions
+	t.mu.Lock()
+	store := t.store
+	if live, exists := t.live[sessionID]; exists {
+		rec := live.record.clone()
+		t.mu.Unlock()
+		rec.ResumeToken = ""
+		return &rec, nil
+	}
+	t.mu.Unlock()
+
+	if store == nil {
+		return nil, ErrSessionNotFound
+	}
+	s.metrics.sessionLookups.Add(1)
+	rec, err := store.GetSession(sessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		s.metrics.sessionMisses.Add(1)
+	} else if err != nil {
+		s.metrics.sessionStoreErrors.Add(1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rec.ResumeToken = ""
+	return rec, nil
+}
+
+// SetSessionData stores a value with a session connected to this node. It is
+// restored when the client resumes the session, here or on another node.
+func (s *Server) SetSessionData(sessionID, key, value string) error {
+	t := &s.sessions
+	t.mu.Lock()
+	live, exists := t.live[sessionID]
+	if !exists {
+		t.mu.Unlock()
+		return fmt.Errorf("session not connected to this node: %s", sessionID)
+	}
+	if live.record.Data == nil {
+		live.record.Data = make(map[string]string)
+	}
+	live.record.Data[key] = value
+	rec := live.snapshot(t.persistKeys)
+	store := t.store
+	t.mu.Unlock()
+
+	if store != nil {
+		if err := store.SaveSession(&rec); err != nil {
+			s.metrics.sessionStoreErrors.Add(1)
+			return fmt.Errorf("save session %s: %w", sessionID, err)
+		}
+	}
+	return nil
+}
+
+// SessionData returns a value stored with a session connected to this node
+func (s *Server) SessionData(sessionID, key string) (string, bool) {
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	live, exists := s.sessions.live[sessionID]
+	if !exists {
+		return "", false
+	}
+	value, ok := live.record.Data[key]
+	return value, ok
+}
+
+// newResumeToken returns a random secret for resuming a session
+func newResumeToken() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("read random: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
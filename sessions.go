@@ -0,0 +1,238 @@
+package wssocket
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ClientInfo describes the device behind a connection
+type ClientInfo struct {
+	SessionID   string `json:"session_id"` // Stable across reconnects when the client sends one
+	Device      string `json:"device"`
+	IP          string `json:"ip"`
+	ResumeToken string `json:"-"` // Proves the client owns a stored session it reconnects to
+
+	ProtocolVersion int `json:"protocol_version,omitempty"` // Envelope version the client speaks; 0 is current
+}
+
+// clientInfoFromRequest reads client details from the connect request. Clients
+// keep a session across reconnects by sending the same session_id; otherwise
+// each connection is its own session.
+func clientInfoFromRequest(r *http.Request, connID string) ClientInfo {
+	info := ClientInfo{
+		SessionID: r.URL.Query().Get("session_id"),
+		Device:    r.URL.Query().Get("device"),
+		IP:        r.RemoteAddr,
+	}
+	if info.SessionID == "" {
+		info.SessionID = r.Header.Get("X-Session-ID")
+	}
+	info.ProtocolVersion = protocolVersionFromRequest(r)
+	info.ResumeToken = r.URL.Query().Get("resume_token")
+	if info.ResumeToken == "" {
+		info.ResumeToken = r.Header.Get("X-Resume-Token")
+	}
+	if info.SessionID == "" {
+		info.SessionID = connID
+	}
+	if info.Device == "" {
+		info.Device = r.UserAgent()
+	}
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		first, _, _ := strings.Cut(fwd, ",")
+		info.IP = strings.TrimSpace(first)
+	} else if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		info.IP = host
+	}
+	return info
+}
+
+// Session groups a user's live connections from one device
+type Session struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	Device      string    `json:"device"`
+	IP          string    `json:"ip"`
+	Transports  []string  `json:"transports"`
+	Connections []string  `json:"connections"`
+	ConnectedAt time.Time `json:"connected_at"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// UserSessions lists a user's active sessions, oldest first
+func (s *Server) UserSessions(userID string) []Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byID := make(map[string]*Session)
+	for _, conn := range s.connections {
+		if conn.UserID != userID {
+			continue
+		}
+
+		sess, exists := byID[conn.Client.SessionID]
+		if !exists {
+			sess = &Session{
+				ID:          conn.Client.SessionID,
+				UserID:      userID,
+				Device:      conn.Client.Device,
+				IP:          conn.Client.IP,
+				ConnectedAt: conn.CreatedAt,
+			}
+			byID[sess.ID] = sess
+		}
+
+		sess.Connections = append(sess.Connections, conn.ID)
+		if !containsString(sess.Transports, conn.Transport) {
+			sess.Transports = append(sess.Transports, conn.Transport)
+		}
+		if conn.CreatedAt.Before(sess.ConnectedAt) {
+			sess.ConnectedAt = conn.CreatedAt
+		}
+		if seen := conn.LastSeen(); seen.After(sess.LastSeen) {
+			// The most recently active connection describes the device best
+			sess.LastSeen = seen
+			sess.Device, sess.IP = conn.Client.Device, conn.Client.IP
+		}
+	}
+
+	sessions := make([]Session, 0, len(byID))
+	for _, sess := range byID {
+		sort.Strings(sess.Connections)
+		sessions = append(sessions, *sess)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+	})
+	return sessions
+}
+
+// TerminateSession closes every connection in one of a user's sessions and
+// returns how many were closed
+func (s *Server) TerminateSession(userID, sessionID string) (int, error) {
+	s.mu.RLock()
+	connIDs := make([]string, 0)
+	for _, conn := range s.connections {
+		if conn.UserID == userID && conn.Client.SessionID == sessionID {
+			connIDs = append(connIDs, conn.ID)
+		}
+	}
+	s.mu.RUnlock()
+
+	if len(connIDs) == 0 {
+		return 0, fmt.Errorf("session not found: %s", sessionID)
+	}
+	for _, connID := range connIDs {
+		s.closeConnection(connID, CloseSessionEnded, "session terminated")
+	}
+	// A logged out session must not be resumed
+	s.forgetSession(sessionID)
+	return len(connIDs), nil
+}
+
+// DisconnectConnection closes a connection immediately with a close reason.
+// WebSocket clients get a close frame with code CloseKicked; streaming
+// transports see their connection context cancelled.
+func (s *Server) DisconnectConnection(connID, reason string) error {
+	return s.closeConnection(connID, CloseKicked, reason)
+}
+
+// closeConnection closes a connection with a WebSocket close code
+func (s *Server) closeConnection(connID string, code int, reason string) error {
+	s.mu.RLock()
+	_, exists := s.connections[connID]
+	ws := s.connectionWSMap[connID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("connection not found: %s", connID)
+	}
+
+	if ws != nil {
+		writeCloseFrame(ws, code, reason)
+		ws.Close()
+	}
+	s.removeConnection(connID)
+	return nil
+}
+
+// containsString reports whether list contains v
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// setupSessionRoutes registers session listing and remote logout endpoints
+func setupSessionRoutes(mux *http.ServeMux, server *Server) {
+	// GET lists a user's sessions; DELETE /api/sessions/{id} logs one out
+	mux.HandleFunc("/api/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+
+		sessions := server.UserSessions(userID)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"sessions": sessions,
+			"count":    len(sessions),
+		})
+	})
+
+	// GET /api/sessions/{id} finds a session on any node through the session
+	// store, including disconnected sessions that can still be resumed
+	mux.HandleFunc("/api/sessions/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sessionID := strings.TrimPrefix(r.URL.Path, "/api/sessions/")
+		if sessionID == "" {
+			http.Error(w, "session ID required", http.StatusBadRequest)
+			return
+		}
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			rec, err := server.LookupSession(sessionID)
+			if errors.Is(err, ErrSessionNotFound) || (err == nil && rec.UserID != userID) {
+				http.Error(w, ErrSessionNotFound.Error(), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			writeJSON(w, http.StatusOK, rec)
+			return
+		}
+
+		closed, err := server.TerminateSession(userID, sessionID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "terminated",
+			"closed": closed,
+		})
+	})
+}
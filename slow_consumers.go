@@ -0,0 +1,313 @@
+package wssocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// SlowConsumerPolicy decides what happens when a connection's outbound queue is full
+type SlowConsumerPolicy string
+
+const (
+	SlowConsumerDrop       SlowConsumerPolicy = "drop"       // Drop the message (the default)
+	SlowConsumerSpool      SlowConsumerPolicy = "spool"      // Buffer to disk and deliver once the client catches up
+	SlowConsumerCoalesce   SlowConsumerPolicy = "coalesce"   // Keep the latest presence/typing update per sender; drop the rest
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect" // Close with 1013 (try again later)
+)
+
+// DefaultSpoolLimit is how many messages a connection may have spooled before it is disconnected
+const DefaultSpoolLimit = 10000
+
+// ParseSlowConsumerPolicy parses a policy name; empty means drop
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(strings.TrimSpace(s)); policy {
+	case "":
+		return SlowConsumerDrop, nil
+	case SlowConsumerDrop, SlowConsumerSpool, SlowConsumerCoalesce, SlowConsumerDisconnect:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown slow consumer policy: %s", s)
+	}
+}
+
+// Dropped returns how many messages were dropped because the connection fell behind
+func (c *Connection) Dropped() uint64 {
+	return c.dropped.Load()
+}
+
+// isCoalescable reports whether only the latest message of its kind matters
+func isCoalescable(msg *Message) bool {
+	return msg.Type == MessageTypeTyping || msg.Type == MessageTypePresence
+}
+
+// coalesceKey identifies the updates a newer message supersedes
+func coalesceKey(msg *Message) string {
+	return string(msg.Type) + "\x00" + msg.Channel + "\x00" + msg.Sender + "\x00" + msg.Recipient
+}
+
+// overflowQueue holds messages for a connection whose outbound queue is full,
+// in memory when coalescing or in a spool file on disk
+type overflowQueue struct {
+	mu       sync.Mutex
+	coalesce bool
+	limit    int
+	queued   int  // Waiting in mem or the spool file
+	inflight bool // Popped but not yet handed to outChan
+	wake     chan struct{}
+
+	mem []*Message
+
+	path   string
+	file   *os.File // Append handle
+	spool  *os.File // Read handle
+	reader *bufio.Reader
+}
+
+// newOverflowQueue creates an overflow queue; dir is only used when spooling
+func newOverflowQueue(coalesce bool, dir string, limit int) (*overflowQueue, error) {
+	o := &overflowQueue{coalesce: coalesce, limit: limit, wake: make(chan struct{}, 1)}
+	if coalesce {
+		return o, nil
+	}
+
+	file, err := os.CreateTemp(dir, "ws-spool-*.jsonl")
+	if err != nil {
+		return nil, fmt.Errorf("create spool file: %w", err)
+	}
+	spool, err := os.Open(file.Name())
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("open spool file: %w", err)
+	}
+	o.path, o.file, o.spool, o.reader = file.Name(), file, spool, bufio.NewReader(spool)
+	return o, nil
+}
+
+// backlogged reports whether messages are waiting in the overflow
+func (o *overflowQueue) backlogged() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.queued > 0 || o.inflight
+}
+
+// pushIfBacklogged queues msg behind earlier overflow so delivery stays in order.
+// It returns false when nothing is waiting and msg can go straight to outChan.
+func (o *overflowQueue) pushIfBacklogged(msg *Message) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.queued == 0 && !o.inflight {
+		return false, nil
+	}
+	if o.coalesce && !isCoalescable(msg) {
+		return false, nil
+	}
+	return true, o.pushLocked(msg)
+}
+
+// push queues msg
+func (o *overflowQueue) push(msg *Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.pushLocked(msg)
+}
+
+func (o *overflowQueue) pushLocked(msg *Message) error {
+	if o.coalesce {
+		key := coalesceKey(msg)
+		for i, pending := range o.mem {
+			if coalesceKey(pending) == key {
+				o.mem[i] = msg
+				return nil
+			}
+		}
+	}
+	if o.queued >= o.limit {
+		return fmt.Errorf("overflow limit of %d messages reached", o.limit)
+	}
+
+	if o.coalesce {
+		o.mem = append(o.mem, msg)
+	} else {
+		line, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if _, err := o.file.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("write spool file: %w", err)
+		}
+	}
+	o.queued++
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next waits for the oldest queued message
+func (o *overflowQueue) next(done <-chan struct{}) (*Message, error) {
+	for {
+		o.mu.Lock()
+		if o.queued > 0 {
+			msg, err := o.popLocked()
+			o.mu.Unlock()
+			return msg, err
+		}
+		o.mu.Unlock()
+
+		select {
+		case <-o.wake:
+		case <-done:
+			return nil, io.EOF
+		}
+	}
+}
+
+func (o *overflowQueue) popLocked() (*Message, error) {
+	o.queued--
+	o.inflight = true
+	if o.coalesce {
+		msg := o.mem[0]
+		o.mem = o.mem[1:]
+		return msg, nil
+	}
+
+	line, err := o.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read spool file: %w", err)
+	}
+	var msg Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return nil, fmt.Errorf("decode spooled message: %w", err)
+	}
+	return &msg, nil
+}
+
+// handedOff marks the in-flight message delivered and, once the spool is
+// drained, truncates it so disk use doesn't grow for the connection's lifetime
+func (o *overflowQueue) handedOff() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.inflight = false
+	if o.coalesce || o.queued > 0 {
+		return
+	}
+	if err := o.file.Truncate(0); err != nil {
+		return
+	}
+	o.spool.Seek(0, io.SeekStart)
+	o.reader.Reset(o.spool)
+}
+
+// close releases the spool file
+func (o *overflowQueue) close() {
+	if o.coalesce {
+		return
+	}
+	o.file.Close()
+	o.spool.Close()
+	os.Remove(o.path)
+}
+
+// overflowFor returns a connection's overflow queue, creating it and its
+// delivery goroutine on first use
+func (s *Server) overflowFor(conn *Connection, coalesce bool) (*overflowQueue, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.overflow != nil {
+		return conn.overflow, nil
+	}
+
+	o, err := newOverflowQueue(coalesce, s.config.SpoolDir, s.config.SpoolLimit)
+	if err != nil {
+		return nil, err
+	}
+	conn.overflow = o
+	go s.drainOverflow(conn, o)
+	return o, nil
+}
+
+// drainOverflow moves overflow into the connection's outbound queue as the
+// client catches up, until the connection closes
+func (s *Server) drainOverflow(conn *Connection, o *overflowQueue) {
+	defer o.close()
+	defer func() {
+		// outChan is closed when the writer exits
+		if r := recover(); r != nil {
+			log.Printf("stopped draining overflow for connection %s: %v", conn.ID, r)
+		}
+	}()
+
+	done := conn.Context().Done()
+	for {
+		msg, err := o.next(done)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Printf("overflow for connection %s failed: %v", conn.ID, err)
+			go s.closeConnection(conn.ID, websocket.CloseTryAgainLater, s.retryReason(conn, "slow consumer"))
+			return
+		}
+
+		select {
+		case conn.outChan <- msg:
+			o.handedOff()
+			conn.notifyWriter()
+		case <-done:
+			return
+		}
+	}
+}
+
+// sendBacklogged queues msg behind the connection's overflow, if it has any
+func (s *Server) sendBacklogged(conn *Connection, msg *Message) (bool, error) {
+	conn.mu.RLock()
+	o := conn.overflow
+	conn.mu.RUnlock()
+	if o == nil {
+		return false, nil
+	}
+	return o.pushIfBacklogged(msg)
+}
+
+// slowConsumer applies the slow-consumer policy to a message that didn't fit
+// in the connection's outbound queue
+func (s *Server) slowConsumer(conn *Connection, msg *Message) error {
+	policy := s.config.SlowConsumerPolicy
+
+	var err error
+	switch {
+	case policy == SlowConsumerSpool, policy == SlowConsumerCoalesce && isCoalescable(msg):
+		var o *overflowQueue
+		if o, err = s.overflowFor(conn, policy == SlowConsumerCoalesce); err == nil {
+			if err = o.push(msg); err == nil {
+				return nil
+			}
+		}
+		if policy == SlowConsumerSpool {
+			// A client that outgrows its spool isn't coming back
+			go s.closeConnection(conn.ID, websocket.CloseTryAgainLater, s.retryReason(conn, "slow consumer"))
+		}
+		err = fmt.Errorf("overflow for connection %s: %w", conn.ID, err)
+	case policy == SlowConsumerDisconnect:
+		go s.closeConnection(conn.ID, websocket.CloseTryAgainLater, s.retryReason(conn, "slow consumer"))
+		err = fmt.Errorf("disconnecting slow consumer: %s", conn.ID)
+	default:
+		err = fmt.Errorf("outgoing message channel full for connection: %s", conn.ID)
+	}
+
+	conn.dropped.Add(1)
+	s.deliveryFailed(conn, msg, err)
+	return err
+}
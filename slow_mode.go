@@ -0,0 +1,142 @@
+package wssocket
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// slowModeSweepSize is how many senders a channel tracks before entries
+// older than its interval are dropped
+const slowModeSweepSize = 1024
+
+// slowModeTypes lists the posts slow mode spaces out. Typing indicators,
+// reads and moderation are never slowed.
+var slowModeTypes = map[MessageType]bool{
+	MessageTypeChat:      true,
+	MessageTypeChatGroup: true,
+	MessageTypeForward:   true,
+}
+
+// slowModeState remembers when each user last posted to each slowed channel
+type slowModeState struct {
+	mu       sync.Mutex
+	lastPost map[string]map[string]time.Time // channel -> user -> last post
+}
+
+// wait returns how long the user must still wait before posting again
+func (st *slowModeState) wait(channel, userID string, interval time.Duration, now time.Time) time.Duration {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	last, exists := st.lastPost[channel][userID]
+	if !exists {
+		return 0
+	}
+	return max(last.Add(interval).Sub(now), 0)
+}
+
+// claim records a post at now unless the user posted within interval, in
+// which case it returns how long they must still wait
+func (st *slowModeState) claim(channel, userID string, interval time.Duration, now time.Time) (time.Duration, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.lastPost == nil {
+		st.lastPost = make(map[string]map[string]time.Time)
+	}
+	users := st.lastPost[channel]
+	if users == nil {
+		users = make(map[string]time.Time)
+		st.lastPost[channel] = users
+	}
+	if last, exists := users[userID]; exists {
+		if wait := last.Add(interval).Sub(now); wait > 0 {
+			return wait, false
+		}
+	}
+	users[userID] = now
+	if len(users) >= slowModeSweepSize {
+		for user, last := range users {
+			if now.Sub(last) >= interval {
+				delete(users, user)
+			}
+		}
+	}
+	return 0, true
+}
+
+// forget drops a channel's post times, once its slow mode is turned off
+func (st *slowModeState) forget(channel string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.lastPost, channel)
+}
+
+// slowModeInterval returns the slow mode interval that applies to a post,
+// 0 when none does. Moderators, owners and trusted publishes are exempt.
+func (s *Server) slowModeInterval(conn *Connection, msg *Message) time.Duration {
+	if msg.Channel == "" || conn.trusted || !slowModeTypes[msg.Type] {
+		return 0
+	}
+	seconds := s.ChannelSettings(msg.Channel).SlowMode
+	if seconds <= 0 || s.ChannelRole(msg.Channel, conn.UserID).AtLeast(RoleModerator) {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// checkSlowMode refuses a post sent before the channel's slow mode interval
+// has passed since the sender's last one, returning how long is left. It
+// doesn't count the post; claimSlowMode does once the message is accepted.
+func (s *Server) checkSlowMode(conn *Connection, msg *Message) (time.Duration, error) {
+	interval := s.slowModeInterval(conn, msg)
+	if interval == 0 {
+		return 0, nil
+	}
+	if wait := s.slowMode.wait(msg.Channel, conn.UserID, interval, s.now()); wait > 0 {
+		return wait, slowModeError(conn, msg, wait)
+	}
+	return 0, nil
+}
+
+// claimSlowMode counts an accepted post towards the sender's slow mode
+// interval. It refuses the post if another one from the sender was accepted
+// since checkSlowMode.
+func (s *Server) claimSlowMode(conn *Connection, msg *Message) (time.Duration, error) {
+	interval := s.slowModeInterval(conn, msg)
+	if interval == 0 {
+		return 0, nil
+	}
+	if wait, ok := s.slowMode.claim(msg.Channel, conn.UserID, interval, s.now()); !ok {
+		return wait, slowModeError(conn, msg, wait)
+	}
+	return 0, nil
+}
+
+// slowModeError describes a post refused by slow mode
+func slowModeError(conn *Connection, msg *Message, wait time.Duration) error {
+	return fmt.Errorf("%q is in slow mode; %s may post again in %s", msg.Channel, conn.UserID, wait.Round(time.Second))
+}
+
+// rejectSlowMode tells the sender how many seconds to wait before posting again
+func (s *Server) rejectSlowMode(conn *Connection, msg *Message, wait time.Duration, err error) error {
+	s.metrics.slowModeRejected.Add(1)
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeError,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Channel:   msg.Channel,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"code":        "slow_mode",
+			"error":       err.Error(),
+			"message_id":  msg.ID,
+			"slow_mode":   s.ChannelSettings(msg.Channel).SlowMode,
+			"retry_after": retryAfter, // Seconds
+			"retry_at":    s.now().Add(wait).Unix(),
+		},
+	})
+	return fmt.Errorf("rejected message %s: %w", msg.ID, err)
+}
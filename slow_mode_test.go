@@ -0,0 +1,111 @@
+package wssocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// slowModeServer runs a server on a fake clock whose "lobby" channel allows
+// one post every 10 seconds, with mod as a moderator
+func slowModeServer(t *testing.T) (*Server, *FakeClock) {
+	t.Helper()
+	clock := NewFakeClock(time.Unix(1_700_000_000, 0))
+	server := NewServer(ServerConfig{Clock: clock})
+	t.Cleanup(server.Stop)
+	channels := NewInMemoryChannelStore()
+	restore := UseHandlerStores(server, HandlerStores{Channels: channels})
+	t.Cleanup(restore)
+	channels.SaveChannelSettings(&ChannelSettings{Channel: "lobby", SlowMode: 10, Visibility: VisibilityPublic})
+	channels.SetChannelRole(&ChannelMember{Channel: "lobby", UserID: "mod", Role: RoleModerator})
+	return server, clock
+}
+
+// postTo sends a chat message to lobby through the accept pipeline
+func postTo(server *Server, conn *Connection, text string) error {
+	return server.acceptMessage(conn, &Message{Type: MessageTypeChatGroup, Channel: "lobby", Payload: map[string]interface{}{"content": text}})
+}
+
+// lastSlowModeError drains a connection's queue and returns the payload of
+// the last slow_mode error, nil when there was none
+func lastSlowModeError(conn *Connection) map[string]interface{} {
+	var payload map[string]interface{}
+	for len(conn.outChan) > 0 {
+		msg := <-conn.outChan
+		if msg.Type == MessageTypeError && msg.Payload["code"] == "slow_mode" {
+			payload = msg.Payload
+		}
+	}
+	return payload
+}
+
+func TestSlowModeCountdown(t *testing.T) {
+	server, clock := slowModeServer(t)
+	alice := connectTo(t, server, "conn_alice", "alice")
+
+	if err := postTo(server, alice, "first"); err != nil {
+		t.Fatal(err)
+	}
+	lastSlowModeError(alice)
+
+	for _, step := range []struct {
+		advance    time.Duration
+		retryAfter int
+	}{{0, 10}, {4 * time.Second, 6}, {5500 * time.Millisecond, 1}} {
+		clock.Advance(step.advance)
+		if err := postTo(server, alice, "again"); err == nil {
+			t.Fatalf("post %s after the last one accepted", step.advance)
+		}
+		reply := lastSlowModeError(alice)
+		if reply == nil || reply["retry_after"] != step.retryAfter || reply["slow_mode"] != 10 {
+			t.Fatalf("slow mode error = %v, want retry_after %d", reply, step.retryAfter)
+		}
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if err := postTo(server, alice, "on time"); err != nil {
+		t.Fatalf("post once the interval passed: %v", err)
+	}
+}
+
+func TestSlowModeExemptsModerators(t *testing.T) {
+	server, _ := slowModeServer(t)
+	mod := connectTo(t, server, "conn_mod", "mod")
+	for i := 0; i < 3; i++ {
+		if err := postTo(server, mod, "announcement"); err != nil {
+			t.Fatalf("moderator post %d: %v", i+1, err)
+		}
+	}
+	if reply := lastSlowModeError(mod); reply != nil {
+		t.Fatalf("moderator got %v", reply)
+	}
+	if err := server.Publish(&Message{Type: MessageTypeChatGroup, Channel: "lobby", Sender: "bot", Payload: map[string]interface{}{}}, TransportHTTP); err != nil {
+		t.Fatalf("trusted publish: %v", err)
+	}
+}
+
+func TestSlowModeCountsOnlyAcceptedPosts(t *testing.T) {
+	server, _ := slowModeServer(t)
+	server.SetModeration("lobby", &ModerationPolicy{Filters: []ModerationFilter{NewWordListFilter(ModerationReject, "buy now")}})
+	refused := errors.New("no links")
+	server.RegisterBeforeMessageHook(func(conn *Connection, msg *Message) error {
+		if msg.Payload["content"] == "http://example.com" {
+			return refused
+		}
+		return nil
+	})
+	bob := connectTo(t, server, "conn_bob", "bob")
+
+	if err := postTo(server, bob, "buy now"); err == nil {
+		t.Fatal("blocked post accepted")
+	}
+	if err := postTo(server, bob, "http://example.com"); !errors.Is(err, refused) {
+		t.Fatalf("hook-refused post: err = %v", err)
+	}
+	if err := postTo(server, bob, "hello"); err != nil {
+		t.Fatalf("first accepted post held back by refused ones: %v", err)
+	}
+	if reply := lastSlowModeError(bob); reply != nil {
+		t.Fatalf("bob got %v", reply)
+	}
+}
@@ -0,0 +1,361 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// TransportSocketIO identifies connections made through the Socket.IO adapter
+const TransportSocketIO = "socketio"
+
+// Engine.IO v4 packet types
+const (
+	eioOpen    = '0'
+	eioClose   = '1'
+	eioPing    = '2'
+	eioPong    = '3'
+	eioMessage = '4'
+)
+
+// Socket.IO v5 packet types
+const (
+	sioConnect      = '0'
+	sioDisconnect   = '1'
+	sioEvent        = '2'
+	sioAck          = '3'
+	sioConnectError = '4'
+)
+
+// socketIOPacket is a decoded Socket.IO packet
+type socketIOPacket struct {
+	Type      byte
+	Namespace string
+	AckID     int // -1 when the packet carries no ack id
+	Data      json.RawMessage
+}
+
+// parseSocketIOPacket decodes a Socket.IO packet from an Engine.IO message payload,
+// e.g. `2/chat,12["event",{"a":1}]`
+func parseSocketIOPacket(s string) (*socketIOPacket, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty socket.io packet")
+	}
+
+	p := &socketIOPacket{Type: s[0], Namespace: "/", AckID: -1}
+	rest := s[1:]
+
+	if strings.HasPrefix(rest, "/") {
+		end := strings.IndexByte(rest, ',')
+		if end < 0 {
+			p.Namespace = rest
+			return p, nil
+		}
+		p.Namespace = rest[:end]
+		rest = rest[end+1:]
+	}
+
+	i := 0
+	for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+		i++
+	}
+	if i > 0 {
+		id, err := strconv.Atoi(rest[:i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid ack id: %w", err)
+		}
+		p.AckID = id
+		rest = rest[i:]
+	}
+
+	if rest != "" {
+		p.Data = json.RawMessage(rest)
+	}
+	return p, nil
+}
+
+// encodeSocketIOPacket builds the Engine.IO message frame for a Socket.IO packet
+func encodeSocketIOPacket(packetType byte, namespace string, ackID int, data interface{}) (string, error) {
+	var b strings.Builder
+	b.WriteByte(eioMessage)
+	b.WriteByte(packetType)
+	if namespace != "" && namespace != "/" {
+		b.WriteString(namespace)
+		b.WriteByte(',')
+	}
+	if ackID >= 0 {
+		b.WriteString(strconv.Itoa(ackID))
+	}
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return "", err
+		}
+		b.Write(encoded)
+	}
+	return b.String(), nil
+}
+
+// socketIOSession holds per-connection adapter state
+type socketIOSession struct {
+	server    *Server
+	conn      *Connection
+	ws        *websocket.Conn
+	writeMu   sync.Mutex
+	mu        sync.RWMutex
+	namespace string
+	connected bool
+}
+
+// write sends a raw Engine.IO text frame
+func (sess *socketIOSession) write(frame string) error {
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	sess.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return sess.ws.WriteMessage(websocket.TextMessage, []byte(frame))
+}
+
+// emit sends a Socket.IO packet on the session's namespace
+func (sess *socketIOSession) emit(packetType byte, ackID int, data interface{}) error {
+	sess.mu.RLock()
+	namespace := sess.namespace
+	sess.mu.RUnlock()
+
+	frame, err := encodeSocketIOPacket(packetType, namespace, ackID, data)
+	if err != nil {
+		return err
+	}
+	return sess.write(frame)
+}
+
+// HandleSocketIO serves an Engine.IO v4 / Socket.IO v5 client over the
+// websocket transport. Rooms map onto server channels, events map onto
+// message types, and acks are answered once the message is queued.
+// Clients must connect with transports: ["websocket"]; long-polling is not supported.
+func (s *Server) HandleSocketIO(w http.ResponseWriter, r *http.Request, connID, userID string) error {
+	if r.URL.Query().Get("transport") != "websocket" {
+		http.Error(w, "only the websocket transport is supported", http.StatusBadRequest)
+		return fmt.Errorf("unsupported socket.io transport: %s", r.URL.Query().Get("transport"))
+	}
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return fmt.Errorf("upgrade error: %w", err)
+	}
+
+	conn := newConnection(connID, userID, TransportSocketIO)
+	conn.Client = clientInfoFromRequest(r, connID)
+	if err := s.registerConnection(conn, ws); err != nil {
+		ws.Close()
+		return err
+	}
+
+	sess := &socketIOSession{server: s, conn: conn, ws: ws, namespace: "/"}
+
+	open, _ := json.Marshal(map[string]interface{}{
+		"sid":          conn.ID,
+		"upgrades":     []string{},
+		"pingInterval": s.config.PingInterval.Milliseconds(),
+		"pingTimeout":  s.config.PongWait.Milliseconds(),
+		"maxPayload":   1000000,
+	})
+	if err := sess.write(string(eioOpen) + string(open)); err != nil {
+		s.removeConnection(conn.ID)
+		ws.Close()
+		return err
+	}
+
+	go sess.readLoop()
+	go sess.writeLoop()
+
+	return nil
+}
+
+// readLoop handles Engine.IO frames from the client
+func (sess *socketIOSession) readLoop() {
+	s := sess.server
+	defer func() {
+		s.removeConnection(sess.conn.ID)
+		sess.ws.Close()
+	}()
+
+	sess.ws.SetReadLimit(s.config.MaxMessageSize)
+	sess.ws.SetReadDeadline(time.Now().Add(s.config.PingInterval + s.config.PongWait))
+
+	for {
+		_, data, err := sess.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("socket.io error: %v", err)
+			}
+			return
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		switch data[0] {
+		case eioPong:
+			sess.ws.SetReadDeadline(time.Now().Add(s.config.PingInterval + s.config.PongWait))
+			sess.conn.Touch()
+		case eioClose:
+			return
+		case eioMessage:
+			packet, err := parseSocketIOPacket(string(data[1:]))
+			if err != nil {
+				log.Printf("socket.io packet error: %v", err)
+				continue
+			}
+			sess.handlePacket(packet)
+		}
+	}
+}
+
+// writeLoop forwards queued messages as Socket.IO events and sends heartbeats
+func (sess *socketIOSession) writeLoop() {
+	s := sess.server
+	ticker := time.NewTicker(s.config.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := sess.write(string(eioPing)); err != nil {
+				return
+			}
+		case msg := <-sess.conn.outChan:
+			if msg == nil {
+				return
+			}
+			if s.dropExpired(msg) {
+				continue
+			}
+			if err := sess.emit(sioEvent, -1, []interface{}{string(msg.Type), msg}); err != nil {
+				s.deliveryFailed(sess.conn, msg, err)
+				return
+			}
+			s.delivered(sess.conn, msg)
+		}
+	}
+}
+
+// handlePacket dispatches a Socket.IO packet
+func (sess *socketIOSession) handlePacket(p *socketIOPacket) {
+	switch p.Type {
+	case sioConnect:
+		sess.mu.Lock()
+		sess.namespace = p.Namespace
+		sess.connected = true
+		sess.mu.Unlock()
+		sess.emit(sioConnect, -1, map[string]string{"sid": sess.conn.ID})
+
+	case sioDisconnect:
+		sess.mu.Lock()
+		sess.connected = false
+		sess.mu.Unlock()
+
+	case sioEvent:
+		sess.mu.RLock()
+		connected := sess.connected
+		sess.mu.RUnlock()
+		if !connected {
+			sess.emit(sioConnectError, -1, map[string]string{"message": "not connected to namespace"})
+			return
+		}
+
+		result, err := sess.handleEvent(p)
+		if p.AckID < 0 {
+			if err != nil {
+				log.Printf("socket.io event error: %v", err)
+			}
+			return
+		}
+		if err != nil {
+			result = map[string]interface{}{"status": "error", "error": err.Error()}
+		}
+		sess.emit(sioAck, p.AckID, []interface{}{result})
+	}
+}
+
+// handleEvent maps a Socket.IO event onto the server. "join" and "leave"
+// manage room (channel) membership; any other event name is used as the
+// message type and its first argument supplies the message fields.
+func (sess *socketIOSession) handleEvent(p *socketIOPacket) (map[string]interface{}, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(p.Data, &args); err != nil || len(args) == 0 {
+		return nil, fmt.Errorf("invalid event payload")
+	}
+
+	var name string
+	if err := json.Unmarshal(args[0], &name); err != nil {
+		return nil, fmt.Errorf("invalid event name")
+	}
+
+	var body json.RawMessage
+	if len(args) > 1 {
+		body = args[1]
+	}
+
+	switch name {
+	case "join", "leave":
+		var room string
+		if err := json.Unmarshal(body, &room); err != nil {
+			var obj struct {
+				Channel string `json:"channel"`
+			}
+			if err := json.Unmarshal(body, &obj); err != nil || obj.Channel == "" {
+				return nil, fmt.Errorf("room name is required")
+			}
+			room = obj.Channel
+		}
+
+		var err error
+		if name == "join" {
+			err = sess.server.SubscribeToChannel(sess.conn.ID, room)
+		} else {
+			err = sess.server.UnsubscribeFromChannel(sess.conn.ID, room)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"status": "ok", "channel": room}, nil
+	}
+
+	var msg Message
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return nil, fmt.Errorf("invalid message body: %w", err)
+		}
+	}
+	msg.Type = MessageType(name)
+
+	if err := sess.server.acceptMessage(sess.conn, &msg); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"status": "queued", "id": msg.ID}, nil
+}
+
+// setupSocketIORoutes registers the Socket.IO compatibility endpoint
+func setupSocketIORoutes(mux *http.ServeMux, server *Server) {
+	mux.HandleFunc("/socket.io/", func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := connectUserID(w, r)
+		if !ok {
+			return
+		}
+		connID := "conn_" + uuid.New().String()[:12]
+
+		if err := server.HandleSocketIO(w, r, connID, userID); err != nil {
+			log.Printf("Socket.IO connection error: %v", err)
+		}
+	})
+}
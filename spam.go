@@ -0,0 +1,312 @@
+package wssocket
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SpamConfig sets the heuristics that mark a sender as a spammer. Only
+// messages with text content are checked, so typing and presence updates
+// never count.
+type SpamConfig struct {
+	DuplicateWindow time.Duration // How far back identical messages are compared; 1m by default
+	MaxDuplicates   int           // Identical messages allowed within the window; 3 by default
+	BurstWindow     time.Duration // 10s by default
+	BurstLimit      int           // Messages allowed within BurstWindow; 20 by default
+	MaxLinks        int           // Links allowed in one message; 5 by default
+	LinkDensity     float64       // Largest share of words that may be links once there are two; 0.5 by default
+	MuteFor         time.Duration // How long a spammer is muted in the channel; 10m by default
+}
+
+// withDefaults fills in unset thresholds
+func (c SpamConfig) withDefaults() SpamConfig {
+	if c.DuplicateWindow <= 0 {
+		c.DuplicateWindow = time.Minute
+	}
+	if c.MaxDuplicates <= 0 {
+		c.MaxDuplicates = 3
+	}
+	if c.BurstWindow <= 0 {
+		c.BurstWindow = 10 * time.Second
+	}
+	if c.BurstLimit <= 0 {
+		c.BurstLimit = 20
+	}
+	if c.MaxLinks <= 0 {
+		c.MaxLinks = 5
+	}
+	if c.LinkDensity <= 0 {
+		c.LinkDensity = 0.5
+	}
+	if c.MuteFor <= 0 {
+		c.MuteFor = 10 * time.Minute
+	}
+	return c
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// SpamDetector is a moderation filter that rejects spam and mutes the
+// sender in that channel
+type SpamDetector struct {
+	server *Server
+	config SpamConfig
+
+	mu        sync.Mutex
+	senders   map[string]*spamHistory // user and channel -> recent messages
+	lastSweep time.Time
+}
+
+// spamHistory is what one user recently sent to one channel
+type spamHistory struct {
+	sent    []time.Time
+	digests []spamDigest
+}
+
+type spamDigest struct {
+	at  time.Time
+	sum uint64
+}
+
+// NewSpamDetector creates a spam filter for s. Add it to a ModerationPolicy's Filters.
+func NewSpamDetector(s *Server, config SpamConfig) *SpamDetector {
+	return &SpamDetector{
+		server:  s,
+		config:  config.withDefaults(),
+		senders: make(map[string]*spamHistory),
+	}
+}
+
+// Moderate rejects spam and mutes its sender
+func (d *SpamDetector) Moderate(ctx context.Context, conn *Connection, msg *Message) (ModerationVerdict, error) {
+	text := spamText(msg)
+	if text == "" {
+		return ModerationVerdict{Action: ModerationAllow}, nil
+	}
+	reason := d.check(conn.UserID, msg.Channel, text, d.server.now())
+	if reason == "" {
+		return ModerationVerdict{Action: ModerationAllow}, nil
+	}
+
+	d.server.metrics.spamDetected.Add(1)
+	if err := d.server.Mute(conn.UserID, msg.Channel, d.config.MuteFor, "spam: "+reason, "system"); err != nil {
+		log.Printf("Error muting %s: %v", conn.UserID, err)
+	}
+	return ModerationVerdict{Action: ModerationReject, Reason: "spam: " + reason}, nil
+}
+
+// check records a message and returns why it looks like spam, or ""
+func (d *SpamDetector) check(userID, channel, text string, now time.Time) string {
+	if links := len(linkPattern.FindAllString(text, -1)); links > d.config.MaxLinks {
+		return "too many links"
+	} else if links >= 2 && float64(links)/float64(len(strings.Fields(text))) > d.config.LinkDensity {
+		return "link density"
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sweepLocked(now)
+
+	key := userID + "\x00" + channel
+	h := d.senders[key]
+	if h == nil {
+		h = &spamHistory{}
+		d.senders[key] = h
+	}
+	h.prune(now, d.config)
+
+	h.sent = append(h.sent, now)
+	if len(h.sent) > d.config.BurstLimit {
+		return "burst rate"
+	}
+
+	sum := digestText(text)
+	duplicates := 0
+	for _, digest := range h.digests {
+		if digest.sum == sum {
+			duplicates++
+		}
+	}
+	h.digests = append(h.digests, spamDigest{at: now, sum: sum})
+	if duplicates >= d.config.MaxDuplicates {
+		return "duplicate messages"
+	}
+	return ""
+}
+
+// prune forgets messages older than both windows
+func (h *spamHistory) prune(now time.Time, config SpamConfig) {
+	i := 0
+	for i < len(h.sent) && now.Sub(h.sent[i]) > config.BurstWindow {
+		i++
+	}
+	h.sent = h.sent[i:]
+
+	i = 0
+	for i < len(h.digests) && now.Sub(h.digests[i].at) > config.DuplicateWindow {
+		i++
+	}
+	h.digests = h.digests[i:]
+}
+
+// sweepLocked drops the history of senders who have gone quiet; d.mu must be held
+func (d *SpamDetector) sweepLocked(now time.Time) {
+	every := max(d.config.BurstWindow, d.config.DuplicateWindow)
+	if now.Sub(d.lastSweep) < every {
+		return
+	}
+	d.lastSweep = now
+	for key, h := range d.senders {
+		if h.prune(now, d.config); len(h.sent) == 0 && len(h.digests) == 0 {
+			delete(d.senders, key)
+		}
+	}
+}
+
+// spamText returns the text a user typed, ignoring structured payloads
+func spamText(msg *Message) string {
+	if content, ok := msg.Payload["content"].(string); ok {
+		return content
+	}
+	text, _ := msg.Payload["text"].(string)
+	return text
+}
+
+// digestText hashes text so trivially different copies compare equal
+func digestText(text string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(strings.Fields(strings.ToLower(text)), " ")))
+	return h.Sum64()
+}
+
+// moderationChannelPrefix starts the name of every moderation channel
+const moderationChannelPrefix = "moderation:"
+
+// ModerationChannel is where moderation events for a channel are published.
+// Only the channel's moderators may subscribe to it.
+func ModerationChannel(channel string) string {
+	if channel == "" {
+		return moderationChannelPrefix + "direct"
+	}
+	return moderationChannelPrefix + channel
+}
+
+// isModerationChannel reports whether a channel carries moderation events.
+// Pattern subscriptions never receive them.
+func isModerationChannel(channel string) bool {
+	return strings.HasPrefix(channel, moderationChannelPrefix)
+}
+
+// Mute stops a user sending to a channel until d has passed. An empty
+// channel mutes direct messages. The action is logged and announced on the
+// channel's moderation channel.
+func (s *Server) Mute(userID, channel string, d time.Duration, reason, actor string) error {
+	until := s.now().Add(d)
+	s.mu.Lock()
+	if s.mutes == nil {
+		s.mutes = make(map[string]map[string]time.Time)
+	}
+	if s.mutes[channel] == nil {
+		s.mutes[channel] = make(map[string]time.Time)
+	}
+	s.mutes[channel][userID] = until
+	s.mu.Unlock()
+
+	log.Printf("Muted %s in %q until %s: %s", userID, channel, until.Format(time.RFC3339), reason)
+	return s.moderationEvent(&ModerationLogEntry{
+		Action:    ModerationLogMute,
+		UserID:    userID,
+		Channel:   channel,
+		Reason:    reason,
+		Actor:     actor,
+		Until:     until.Unix(),
+		Timestamp: s.now().Unix(),
+	})
+}
+
+// Unmute lifts a user's mute in a channel. It reports whether one was in place.
+func (s *Server) Unmute(userID, channel, actor string) (bool, error) {
+	s.mu.Lock()
+	_, muted := s.mutes[channel][userID]
+	delete(s.mutes[channel], userID)
+	s.mu.Unlock()
+	if !muted {
+		return false, nil
+	}
+
+	return true, s.moderationEvent(&ModerationLogEntry{
+		Action:    ModerationLogUnmute,
+		UserID:    userID,
+		Channel:   channel,
+		Actor:     actor,
+		Timestamp: s.now().Unix(),
+	})
+}
+
+// MutedUntil returns when a user's mute in a channel ends
+func (s *Server) MutedUntil(userID, channel string) (time.Time, bool) {
+	s.mu.RLock()
+	until, muted := s.mutes[channel][userID]
+	s.mu.RUnlock()
+	if !muted {
+		return time.Time{}, false
+	}
+	if !s.now().Before(until) {
+		s.mu.Lock()
+		if s.mutes[channel][userID].Equal(until) {
+			delete(s.mutes[channel], userID)
+		}
+		s.mu.Unlock()
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// rejectMuted tells a muted sender their message was dropped
+func (s *Server) rejectMuted(conn *Connection, msg *Message, until time.Time) error {
+	s.metrics.mutedRejected.Add(1)
+	err := fmt.Errorf("%s is muted in %q until %s", conn.UserID, msg.Channel, until.Format(time.RFC3339))
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeError,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"code":        "muted",
+			"error":       "you are muted in this channel",
+			"message_id":  msg.ID,
+			"muted_until": until.Unix(),
+		},
+	})
+	return err
+}
+
+// moderationEvent records a moderation action and tells the channel's moderators
+func (s *Server) moderationEvent(entry *ModerationLogEntry) error {
+	entry.ID = "mod_" + uuid.New().String()
+	s.broadcastToChannel(ModerationChannel(entry.Channel), &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeModerationAction,
+		Sender:    "system",
+		Channel:   ModerationChannel(entry.Channel),
+		Timestamp: entry.Timestamp,
+		Payload:   entry.payload(),
+	}, &BroadcastOptions{})
+
+	if globalModerationLog == nil {
+		return nil
+	}
+	if err := globalModerationLog.RecordModerationAction(entry); err != nil {
+		return fmt.Errorf("record moderation action %s: %w", entry.ID, err)
+	}
+	return nil
+}
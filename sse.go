@@ -0,0 +1,153 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// HandleSSE serves a Server-Sent Events stream for clients that can't use
+// WebSockets. The connection is registered like any other, so channels,
+// presence and broadcasts reach it through its outgoing queue. It blocks
+// until the client goes away or the server stops.
+func (s *Server) HandleSSE(w http.ResponseWriter, r *http.Request, connID, userID string) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return fmt.Errorf("response writer does not support flushing")
+	}
+
+	conn := newConnection(connID, userID, TransportSSE)
+	conn.Client = clientInfoFromRequest(r, connID)
+	if err := s.registerConnection(conn, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return err
+	}
+	defer s.removeConnection(conn.ID)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	// Tell the client which connection ID to use when posting messages
+	fmt.Fprintf(w, "event: connected\ndata: {\"conn_id\": %q, \"user_id\": %q}\n\n", conn.ID, conn.UserID)
+	flusher.Flush()
+
+	ticker := time.NewTicker(s.config.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return nil
+		case <-r.Context().Done():
+			return nil
+		case <-conn.Context().Done():
+			return nil
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		case msg := <-conn.outChan:
+			if msg == nil {
+				return nil
+			}
+			if s.dropExpired(msg) {
+				continue
+			}
+			data, err := json.Marshal(s.outgoing(conn, msg))
+			if err != nil {
+				log.Printf("sse encode error: %v", err)
+				s.deliveryFailed(conn, msg, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: message\ndata: %s\n\n", msg.ID, data); err != nil {
+				s.deliveryFailed(conn, msg, err)
+				return nil
+			}
+			flusher.Flush()
+			s.delivered(conn, msg)
+		}
+	}
+}
+
+// HandleSSESend accepts a message posted by an SSE client and feeds it into
+// the same pipeline as frames read from a WebSocket. The request must
+// authenticate as the connection's user, as the stream did: with user_id,
+// and the user's token or bot key when it has one.
+func (s *Server) HandleSSESend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	connID := r.URL.Query().Get("conn_id")
+	conn, exists := s.GetConnection(connID)
+	if !exists || conn.Transport != TransportSSE {
+		http.Error(w, "unknown SSE connection", http.StatusNotFound)
+		return
+	}
+	userID, err := s.requestUserID(r)
+	if err != nil && !errors.Is(err, ErrUnauthorized) {
+		log.Printf("Error authenticating SSE send: %v", err)
+		http.Error(w, "Failed to authenticate", http.StatusInternalServerError)
+		return
+	}
+	if err != nil || userID != conn.UserID {
+		http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid message format", http.StatusBadRequest)
+		return
+	}
+	msg, err := s.decodeIncoming(conn, data)
+	if err != nil {
+		http.Error(w, "Invalid message format", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.acceptMessage(conn, msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"status": "queued",
+		"id":     msg.ID,
+	})
+}
+
+// setupSSERoutes registers the SSE fallback transport endpoints
+func setupSSERoutes(mux *http.ServeMux, server *Server) {
+	// Event stream
+	mux.HandleFunc("/sse", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := connectUserID(w, r)
+		if !ok {
+			return
+		}
+		connID := "conn_" + uuid.New().String()[:12]
+
+		if err := server.HandleSSE(w, r, connID, userID); err != nil {
+			log.Printf("SSE connection error: %v", err)
+		}
+	})
+
+	// Client-to-server messages for SSE connections
+	mux.HandleFunc("/sse/send", server.HandleSSESend)
+}
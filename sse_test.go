@@ -0,0 +1,50 @@
+package wssocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSSESendAuthenticatesTheConnectionUser(t *testing.T) {
+	server := NewServer(ServerConfig{})
+	t.Cleanup(server.Stop)
+	restore := UseHandlerStores(server, HandlerStores{Users: NewInMemoryUserStore()})
+	t.Cleanup(restore)
+
+	_, token, err := server.RegisterUser("bob", "bob")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	for id, userID := range map[string]string{"conn_alice": "alice", "conn_bob": "bob"} {
+		if err := server.registerConnection(newConnection(id, userID, TransportSSE), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{"connection ID only", "conn_id=conn_alice", http.StatusUnauthorized},
+		{"another user", "conn_id=conn_alice&user_id=mallory", http.StatusUnauthorized},
+		{"connection user", "conn_id=conn_alice&user_id=alice", http.StatusAccepted},
+		{"registered user without token", "conn_id=conn_bob&user_id=bob", http.StatusUnauthorized},
+		{"registered user with token", "conn_id=conn_bob&token=" + token, http.StatusAccepted},
+		{"another user's token", "conn_id=conn_alice&token=" + token, http.StatusUnauthorized},
+		{"unknown connection", "conn_id=conn_nobody&user_id=alice", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"type":"chat:private","recipient":"carol","payload":{"content":"hi"}}`
+			req := httptest.NewRequest(http.MethodPost, "/sse/send?"+tt.query, strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			server.HandleSSESend(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
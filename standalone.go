@@ -0,0 +1,597 @@
+package wssocket
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Global database instance
+var globalDB *Database
+
+// StandaloneConfig configures the standalone server that RunStandalone runs.
+// cmd/server fills it from the environment; zero fields keep their defaults
+// or leave their feature off.
+type StandaloneConfig struct {
+	Addr   string // Listen address; ":8080" when empty
+	Server ServerConfig
+	Routes RouteOptions
+
+	DatabaseURL    string
+	DBQueryTimeout time.Duration
+	DBResilience   ResilienceConfig
+
+	RedisURL       string
+	RedisKeyPrefix string
+	RedisCache     bool // Serve recent history and channel members from Redis
+	Cache          CacheConfig
+
+	EncryptionKeys KeyProvider // Encrypts stored message content; nil stores it as is
+	LazyRekey      bool        // Re-encrypt messages under the active key as they are read
+
+	Blobs   BlobStore // Attachments, exports and retention archives
+	Uploads UploadConfig
+
+	RetentionDefault  RetentionPolicy
+	RetentionPolicies map[string]RetentionPolicy // Channel or pattern -> policy
+	RetentionInterval time.Duration
+	PurgeDeletedAfter *time.Duration // nil keeps DefaultPurgeAfter
+
+	Tracing bool // Export traces to the OTLP collector in the OTEL_* variables
+
+	ExportTTL time.Duration
+
+	SessionStore string // memory, redis or postgres; "" turns resumable sessions off
+	SessionTTL   time.Duration
+
+	ClusterStore     string // redis; "" runs a single node
+	ClusterAddress   string
+	ClusterHeartbeat time.Duration
+
+	ReplayGuard *ReplayGuard
+	Idempotency *IdempotencyCache
+	Redactors   map[string]*Redactor // Channel pattern -> redactor
+
+	Moderation         *ModerationPolicy // Filters and external API; nil without either
+	Spam               *SpamConfig       // Spam detection, run before the policy's filters; nil turns it off
+	ModerationChannels []string          // Channel patterns moderated; all when empty
+
+	SchedulerInterval time.Duration
+	ScheduleMaxDelay  time.Duration
+
+	AlertPolicy AlertPolicy
+	Webhooks    []*Webhook
+	Kafka       *KafkaConfig
+	PushSenders map[PushPlatform]PushSender
+	Templates   []*NotificationTemplate
+	Audit       *AuditSampler
+
+	ConfigFile string // Reloaded on SIGHUP
+	GRPCAddr   string
+}
+
+// RunStandalone runs the standalone server: it connects to PostgreSQL, sets
+// up the configured stores and features, registers the built-in handlers and
+// every route on its own mux and serves until the listener fails. It uses
+// the package state, so it can't run beside another Server.
+func RunStandalone(cfg StandaloneConfig) error {
+	log.Println("✅ Initializing WebSocket server with PostgreSQL for API routes")
+
+	db, err := NewDatabase(cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("initialize database: %w", err)
+	}
+	defer db.Close()
+	if cfg.DBQueryTimeout > 0 {
+		db.SetQueryTimeout(cfg.DBQueryTimeout)
+	}
+	db.SetResilience(cfg.DBResilience)
+	if err := db.Migrate(); err != nil {
+		return fmt.Errorf("migrate database schema: %w", err)
+	}
+
+	globalDB = db
+	globalStore = db
+	globalNotifications = db
+	globalModerationLog = db
+	globalChannelStore = db
+	globalUserStore = db
+
+	// Serve recent channel history and channel members from Redis
+	if cfg.RedisCache {
+		redisClient, err := NewRedisClient(cfg.RedisURL)
+		if err != nil {
+			return fmt.Errorf("redis cache: %w", err)
+		}
+		defer redisClient.Close()
+		cached := NewCachedDatabase(db, redisClient, cfg.RedisKeyPrefix, cfg.Cache)
+		globalStore = cached
+		globalChannelStore = cached
+		log.Println("✅ Redis cache enabled for history and channel members")
+	}
+
+	// Encrypt stored message content when keys are configured
+	var encryptedStore *EncryptedStore
+	if cfg.EncryptionKeys != nil {
+		encryptedStore = NewEncryptedStore(globalStore, cfg.EncryptionKeys)
+		encryptedStore.SetLazyRekey(cfg.LazyRekey)
+		globalStore = encryptedStore
+		log.Println("✅ Message encryption at rest enabled")
+	}
+	log.Println("✅ PostgreSQL initialized for API routes")
+
+	if cfg.Blobs == nil {
+		return fmt.Errorf("a blob store is required for attachments")
+	}
+	globalAttachments = NewAttachmentRegistry(cfg.Blobs, cfg.Uploads)
+
+	// Delete or archive old messages, and messages whose ttl ran out. The
+	// janitor works on the raw database so archives keep encrypted content
+	// encrypted. Without retention policies it only removes expired messages.
+	janitor := NewRetentionJanitor(db, cfg.Blobs, cfg.RetentionInterval, cfg.RetentionDefault)
+	janitor.SetPolicies(cfg.RetentionDefault, cfg.RetentionPolicies)
+	if cfg.PurgeDeletedAfter != nil {
+		janitor.SetPurgeAfter(*cfg.PurgeDeletedAfter)
+	}
+	janitor.Start()
+	defer janitor.Stop()
+	log.Println("✅ Message retention janitor started")
+
+	// Export message pipeline traces when an OTLP collector is configured
+	if cfg.Tracing {
+		shutdown, err := EnableOTLPTracing(context.Background())
+		if err != nil {
+			return fmt.Errorf("enable tracing: %w", err)
+		}
+		defer shutdown(context.Background())
+		log.Println("✅ OpenTelemetry tracing enabled")
+	}
+
+	server := NewServer(cfg.Server)
+
+	// Set global server reference for handlers
+	globalServer = server
+	server.SetMessageStore(globalStore)
+	server.RegisterReadinessCheck("database", db.Health)
+	if err := server.LoadBots(); err != nil {
+		return fmt.Errorf("load bots: %w", err)
+	}
+	if err := server.SetIPBanStore(db); err != nil {
+		return fmt.Errorf("load IP bans: %w", err)
+	}
+
+	// User data exports are kept in the upload blob store until they expire
+	globalExporter = NewExporter(server, cfg.Blobs, cfg.ExportTTL)
+
+	// Keep sessions in a shared store so clients can resume them after a
+	// reconnect, on this node or another
+	if cfg.SessionStore != "" {
+		var sessionStore SessionStore
+		switch cfg.SessionStore {
+		case "memory":
+			sessionStore = NewMemorySessionStore()
+		case "postgres":
+			sessionStore = db
+		case "redis":
+			redisClient, err := NewRedisClient(cfg.RedisURL)
+			if err != nil {
+				return fmt.Errorf("session store: %w", err)
+			}
+			defer redisClient.Close()
+			sessionStore = NewRedisSessionStore(redisClient, cfg.RedisKeyPrefix)
+			server.RegisterReadinessCheck("redis", redisClient.Ping)
+		default:
+			return fmt.Errorf("invalid session store %q: want memory, redis or postgres", cfg.SessionStore)
+		}
+		server.SetSessionStore(sessionStore, cfg.SessionTTL)
+		log.Printf("✅ Resumable sessions enabled (%s store)", cfg.SessionStore)
+	}
+
+	// Share membership with other nodes so direct messages reach users
+	// connected elsewhere
+	if cfg.ClusterStore != "" {
+		if cfg.ClusterStore != "redis" {
+			return fmt.Errorf("invalid cluster store %q: want redis", cfg.ClusterStore)
+		}
+		redisClient, err := NewRedisClient(cfg.RedisURL)
+		if err != nil {
+			return fmt.Errorf("cluster store: %w", err)
+		}
+		defer redisClient.Close()
+		store := NewRedisClusterStore(redisClient, cfg.RedisKeyPrefix)
+		cluster, err := server.JoinCluster(store, cfg.ClusterAddress, cfg.ClusterHeartbeat)
+		if err != nil {
+			return fmt.Errorf("join cluster: %w", err)
+		}
+		server.RegisterReadinessCheck("cluster", redisClient.Ping)
+		log.Printf("✅ Joined cluster as %s (%d nodes)", cluster.Self().ID, len(cluster.Nodes()))
+	}
+
+	// Reject replayed frames: stale or future timestamps and reused message IDs
+	if cfg.ReplayGuard != nil {
+		server.SetReplayGuard(cfg.ReplayGuard)
+		log.Printf("✅ Replay protection enabled (max skew %s, window %s)", cfg.ReplayGuard.maxSkew, cfg.ReplayGuard.window)
+	}
+
+	// Answer retried sends with their original acks instead of processing them twice
+	if cfg.Idempotency != nil {
+		server.SetIdempotencyCache(cfg.Idempotency)
+		log.Printf("✅ Duplicate message detection enabled (window %s)", cfg.Idempotency.window)
+	}
+
+	// Mask emails, card numbers or listed words before messages are routed
+	if len(cfg.Redactors) > 0 {
+		for pattern, r := range cfg.Redactors {
+			server.SetRedactor(pattern, r)
+		}
+		log.Printf("✅ Redaction enabled for %d channel patterns", len(cfg.Redactors))
+	}
+
+	// Mute spammers, reject blocked words and hold messages for an external moderation API
+	if policy := cfg.Moderation; policy != nil || cfg.Spam != nil {
+		if policy == nil {
+			policy = &ModerationPolicy{}
+		}
+		if cfg.Spam != nil {
+			policy.Filters = append([]ModerationFilter{NewSpamDetector(server, *cfg.Spam)}, policy.Filters...)
+		}
+		channels := cfg.ModerationChannels
+		if len(channels) == 0 {
+			channels = []string{"*"}
+		}
+		for _, channel := range channels {
+			server.SetModeration(strings.TrimSpace(channel), policy)
+		}
+		log.Printf("✅ Moderation enabled for %s", strings.Join(channels, ", "))
+	}
+
+	mux := http.NewServeMux()
+
+	// Hold messages with a future deliver_at until they are due
+	scheduler := NewScheduler(server, db, cfg.SchedulerInterval, cfg.ScheduleMaxDelay)
+	server.SetScheduler(scheduler)
+	scheduler.Start()
+	defer scheduler.Stop()
+	setupScheduledRoutes(mux, scheduler)
+
+	// Register message handlers
+	RegisterDefaultHandlers(server)
+
+	// Severity routing, deduplication and escalation for alert messages
+	globalAlerts = NewAlertManager(server, cfg.AlertPolicy)
+
+	// Register hooks
+	server.RegisterBeforeMessageHook(DefaultBeforeHook)
+	server.RegisterAfterMessageHook(DefaultAfterHook)
+	server.RegisterOnConnectHook(OnConnect)
+	server.RegisterOnDisconnectHook(OnDisconnect)
+
+	// Outbound webhooks for external integrations
+	if len(cfg.Webhooks) > 0 {
+		dispatcher := NewWebhookDispatcher(WebhookConfig{})
+		for _, hook := range cfg.Webhooks {
+			if err := dispatcher.Add(hook); err != nil {
+				return fmt.Errorf("invalid webhook: %w", err)
+			}
+		}
+		server.SetWebhookDispatcher(dispatcher)
+		log.Printf("✅ %d webhook(s) registered", len(cfg.Webhooks))
+	}
+
+	// Event stream of every processed message and connection for analytics and replay
+	if cfg.Kafka != nil {
+		if cfg.Kafka.SASLMechanism != "" && cfg.Kafka.TLS == nil {
+			log.Printf("Kafka SASL is configured without TLS; credentials will cross the network unencrypted")
+		}
+		sink, err := NewKafkaSink(*cfg.Kafka)
+		if err != nil {
+			return fmt.Errorf("create Kafka sink: %w", err)
+		}
+		defer sink.Stop()
+		server.SetEventSink(sink)
+		log.Printf("✅ Publishing events to Kafka topic %s (acks=%s)", cfg.Kafka.Topic, cfg.Kafka.Acks)
+	}
+
+	// Push notifications for users with no live connection
+	var pushService *PushService
+	if len(cfg.PushSenders) > 0 {
+		pushService = NewPushService(globalUserStore, cfg.PushSenders, PushConfig{})
+		defer pushService.Stop()
+		server.SetNotifier(pushService)
+		log.Printf("✅ Push notifications enabled for %d service(s)", len(cfg.PushSenders))
+	}
+
+	// Notification templates that services render through the publish API
+	globalTemplates = NewTemplateRegistry()
+	for _, t := range cfg.Templates {
+		if err := globalTemplates.Register(t); err != nil {
+			return fmt.Errorf("invalid notification template: %w", err)
+		}
+	}
+	if len(cfg.Templates) > 0 {
+		log.Printf("✅ %d notification template(s) registered", len(cfg.Templates))
+	}
+
+	// Sample a share of inbound messages for production debugging
+	if cfg.Audit != nil {
+		server.SetAuditSampler(cfg.Audit)
+	}
+
+	// Start message processing goroutine
+	go server.ProcessMessages()
+
+	// Setup HTTP routes
+	RegisterRoutes(mux, server, cfg.Routes)
+	adminKeys := cfg.Routes.AdminAPIKeys
+	setupAlertRoutes(mux, globalAlerts, adminKeys)
+	setupPushRoutes(mux, server, pushService)
+	if cfg.Audit != nil {
+		setupAuditRoutes(mux, cfg.Audit, adminKeys)
+	}
+
+	// Admin endpoints require admin API keys
+	if len(adminKeys) > 0 && encryptedStore != nil {
+		setupEncryptionAdminRoutes(mux, encryptedStore, adminKeys)
+	}
+
+	// Rate limits, origins, max connections and retention can change at
+	// runtime: from the config file on SIGHUP, or through the admin API
+	reloader := NewConfigReloader(server, janitor, cfg.ConfigFile)
+	if cfg.ConfigFile != "" {
+		if err := reloader.ReloadFile(); err != nil {
+			return fmt.Errorf("invalid config file: %w", err)
+		}
+		defer reloader.WatchSignals()()
+		log.Println("✅ Config reload on SIGHUP enabled")
+	}
+	if len(adminKeys) > 0 {
+		setupConfigAdminRoutes(mux, reloader, adminKeys)
+		setupModerationAdminRoutes(mux, server, adminKeys)
+		setupChannelRoleAdminRoutes(mux, server, adminKeys)
+		setupChannelSettingsAdminRoutes(mux, server, adminKeys)
+		setupProfileAdminRoutes(mux, server, adminKeys)
+		setupBotAdminRoutes(mux, server, adminKeys)
+		setupDrainAdminRoutes(mux, server, adminKeys)
+		setupClusterAdminRoutes(mux, server, adminKeys)
+		setupIPAdminRoutes(mux, server, adminKeys)
+		setupErasureAdminRoutes(mux, server, adminKeys)
+	}
+
+	// Optional gRPC gateway for backend services, authenticated with the
+	// publish API keys
+	if cfg.GRPCAddr != "" {
+		if len(cfg.Routes.PublishAPIKeys) == 0 {
+			log.Printf("The gRPC gateway has no publish API keys; every gRPC call will be refused")
+		}
+		go func() {
+			log.Printf("gRPC gateway starting on %s", cfg.GRPCAddr)
+			if err := server.ListenAndServeGRPC(cfg.GRPCAddr, cfg.Routes.PublishAPIKeys); err != nil {
+				log.Printf("gRPC gateway error: %v", err)
+			}
+		}()
+	}
+
+	// Start HTTP server with CORS wrapper
+	addr := cfg.Addr
+	if addr == "" {
+		addr = ":8080"
+	}
+	log.Printf("WebSocket server starting on http://localhost%s", addr)
+	if err := http.ListenAndServe(addr, server.CORSHandler(mux)); err != nil {
+		return fmt.Errorf("serve: %w", err)
+	}
+	return nil
+}
+
+// CORSHandler wraps a handler with the CORS headers for the server's allowed
+// origins, and answers preflight requests itself
+func (s *Server) CORSHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Set CORS headers
+		s.setCORSOrigin(w, r)
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
+		w.Header().Set("Access-Control-Max-Age", "86400")
+
+		// Handle preflight requests
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// Continue to the actual handler
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RegisterDefaultHandlers registers the built-in message handlers. They act
+// on the server through package state, so it becomes the one they serve;
+// give them stores with UseHandlerStores.
+func RegisterDefaultHandlers(server *Server) {
+	globalServer = server
+	server.RegisterHandler(MessageTypeChat, ChatHandler)
+	server.RegisterHandler(MessageTypeChatGroup, GroupChatHandler)
+	server.RegisterHandler(MessageTypeChatPrivate, PrivateChatHandler)
+	server.RegisterHandler(MessageTypeNotification, NotificationHandler)
+	server.RegisterHandler(MessageTypeAlert, AlertHandler)
+	server.RegisterHandler(MessageTypeEvent, EventHandler)
+	server.RegisterHandler(MessageTypeUserJoined, UserJoinedHandler)
+	server.RegisterHandler(MessageTypeUserLeft, UserLeftHandler)
+	server.RegisterHandler(MessageTypeTyping, TypingHandler)
+	server.RegisterHandler(MessageTypePresence, PresenceHandler)
+	server.RegisterHandler(MessageTypeAck, AckHandler)
+	server.RegisterHandler(MessageTypeMessageDelete, DeleteMessageHandler)
+	server.RegisterHandler(MessageTypeForward, ForwardHandler)
+	server.RegisterHandler(MessageTypeChannelRole, ChannelRoleHandler)
+	server.RegisterHandler(MessageTypeChannelKick, KickHandler)
+	server.RegisterHandler(MessageTypeChannelUpdate, ChannelUpdateHandler)
+	server.RegisterHandler(MessageTypeMessagePin, PinHandler)
+	server.RegisterHandler(MessageTypeMessageUnpin, PinHandler)
+	server.RegisterHandler(MessageTypeChannelReplay, ChannelReplayHandler)
+	server.RegisterHandler(MessageTypeChannelCreate, ChannelCreateHandler)
+	server.RegisterHandler(MessageTypeChannelInvite, ChannelInviteHandler)
+	server.RegisterHandler(MessageTypeInviteAccept, InviteResponseHandler)
+	server.RegisterHandler(MessageTypeInviteDecline, InviteResponseHandler)
+	server.RegisterHandler(MessageTypeConversationRead, ConversationReadHandler)
+	server.RegisterHandler(MessageTypeChannelRead, ChannelReadHandler)
+	server.RegisterHandler(MessageTypeChannelMute, ChannelMuteHandler)
+	server.RegisterHandler(MessageTypeSubscribeFilter, SubscribeFilterHandler)
+	server.RegisterHandler(MessageTypeResume, ResumeHandler)
+	server.RegisterHandler(MessageTypePing, PingHandler)
+	server.RegisterHandler(MessageTypeConversationCreate, ConversationCreateHandler)
+	server.RegisterHandler(MessageTypeConversationAdd, ConversationMemberHandler)
+	server.RegisterHandler(MessageTypeConversationRemove, ConversationMemberHandler)
+	server.RegisterHandler(MessageTypeUserGet, UserGetHandler)
+	server.RegisterHandler(MessageTypeUserUpdate, UserUpdateHandler)
+	server.RegisterHandler(MessageTypeHistoryRequest, HistoryRequestHandler)
+	server.RegisterHandler(MessageTypeSearch, SearchHandler)
+	server.RegisterHandler(MessageTypeAlertAck, AlertAckHandler)
+}
+
+// RouteOptions configures the routes RegisterRoutes adds
+type RouteOptions struct {
+	AdminAPIKeys   []string // Unlock the admin views of history, search and the other APIs
+	PublishAPIKeys []string // Let backend services use the publish API
+	SocketIO       bool     // Serve the Socket.IO compatibility endpoint
+	DemoClient     bool     // Serve the embedded demo clients at /
+}
+
+// RegisterRoutes adds the WebSocket endpoint, the other transports and the
+// REST APIs on mux. The admin APIs that need the standalone server's
+// janitor, reloader or push service are added by RunStandalone only.
+func RegisterRoutes(mux *http.ServeMux, server *Server, opts RouteOptions) {
+	// WebSocket endpoint
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		// Set CORS headers for WebSocket
+		server.setCORSOrigin(w, r)
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		// Handle preflight requests
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// Authenticate the user ID, or generate an anonymous one
+		userID, ok := connectUserID(w, r)
+		if !ok {
+			return
+		}
+
+		// Generate connection ID
+		connID := "conn_" + uuid.New().String()[:12]
+
+		// Handle the connection
+		if err := server.HandleConnection(w, r, connID, userID); err != nil {
+			log.Printf("Connection error: %v", err)
+		}
+	})
+
+	// All message storage and retrieval now handled client-side with IndexedDB
+	// Server only handles WebSocket connections and real-time messaging
+	// These API routes allow the frontend to persist data to PostgreSQL
+
+	// Initialize database schema
+	mux.HandleFunc("/api/db/init", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if globalDB != nil {
+			if err := globalDB.Migrate(); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to initialize schema: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"status": "initialized"}`)
+		} else {
+			http.Error(w, "Database not available", http.StatusServiceUnavailable)
+		}
+	})
+
+	// Database health check
+	mux.HandleFunc("/api/db/health", func(w http.ResponseWriter, r *http.Request) {
+		if globalStore != nil {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"status": "connected"}`)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"status": "disconnected"}`)
+		}
+	})
+
+	// Server-Sent Events fallback transport
+	setupSSERoutes(mux, server)
+
+	// Optional Socket.IO compatibility endpoint
+	if opts.SocketIO {
+		setupSocketIORoutes(mux, server)
+	}
+
+	// STOMP over WebSocket for messaging clients such as stomp.js
+	setupSTOMPRoutes(mux, server)
+
+	// GraphQL subscriptions (graphql-ws) for Apollo clients
+	setupGraphQLRoutes(mux, server)
+
+	// Message history routes backed by the unified MessageStore, and the
+	// combined message search for admin and moderation tooling
+	setupHistoryRoutes(mux, opts.AdminAPIKeys)
+	setupSearchRoutes(mux, opts.AdminAPIKeys)
+
+	// Attachment uploads
+	setupUploadRoutes(mux, server)
+
+	// User data exports
+	setupExportRoutes(mux)
+
+	// Publish API for trusted backend services and bots
+	setupPublishRoutes(mux, server, opts.PublishAPIKeys)
+
+	// Per-user session listing and remote logout
+	setupSessionRoutes(mux, server)
+
+	// Notification center
+	setupNotificationRoutes(mux)
+
+	// Channel roles and pins
+	setupChannelRoutes(mux)
+	setupInviteRoutes(mux)
+	setupConversationRoutes(mux)
+	setupReadStateRoutes(mux)
+	setupSyncRoutes(mux)
+	setupMentionRoutes(mux)
+	setupProfileRoutes(mux)
+	setupUsernameRoutes(mux, server)
+
+	// Server counters
+	setupMetricsRoutes(mux, server)
+
+	// Saturation signals for autoscalers
+	setupScalingRoutes(mux, server)
+
+	// Kubernetes liveness and readiness probes
+	setupHealthRoutes(mux, server)
+
+	// Proof-of-work challenges for connects from busy IPs
+	setupChallengeRoutes(mux, server)
+
+	// Health check
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		conns := server.GetConnections()
+		fmt.Fprintf(w, `{"status": "ok", "active_connections": %d}`, len(conns))
+	})
+
+	// Serve the embedded demo clients unless disabled for production
+	if opts.DemoClient {
+		setupDemoRoutes(mux)
+	}
+}
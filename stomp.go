@@ -0,0 +1,498 @@
+package wssocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// TransportSTOMP identifies connections speaking STOMP over WebSocket
+const TransportSTOMP = "stomp"
+
+// STOMP destination prefixes mapped onto server routing
+const (
+	stompTopicPrefix = "/topic/" // /topic/<channel> -> channel broadcast
+	stompUserPrefix  = "/user/"  // /user/<userID> -> direct message; subscribe to /user/queue/... for your own
+)
+
+// stompFrame is a single STOMP 1.2 frame
+type stompFrame struct {
+	Command string
+	Headers map[string]string
+	Body    []byte
+}
+
+// parseStompFrame decodes a frame from a WebSocket message
+func parseStompFrame(data []byte) (*stompFrame, error) {
+	data = bytes.TrimLeft(data, "\r\n")
+	if len(data) == 0 {
+		return nil, nil // heart-beat
+	}
+	data = bytes.TrimSuffix(data, []byte{0})
+
+	headerEnd := bytes.Index(data, []byte("\n\n"))
+	sepLen := 2
+	if crlf := bytes.Index(data, []byte("\r\n\r\n")); crlf >= 0 && (headerEnd < 0 || crlf < headerEnd) {
+		headerEnd, sepLen = crlf, 4
+	}
+
+	var head []byte
+	frame := &stompFrame{Headers: make(map[string]string)}
+	if headerEnd < 0 {
+		head = data
+	} else {
+		head = data[:headerEnd]
+		frame.Body = data[headerEnd+sepLen:]
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(head), "\r\n", "\n"), "\n")
+	frame.Command = strings.TrimSpace(lines[0])
+	if frame.Command == "" {
+		return nil, fmt.Errorf("missing STOMP command")
+	}
+
+	for _, line := range lines[1:] {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("malformed STOMP header: %q", line)
+		}
+		// Repeated headers: the first occurrence wins
+		key = stompUnescape(key)
+		if _, exists := frame.Headers[key]; !exists {
+			frame.Headers[key] = stompUnescape(value)
+		}
+	}
+	return frame, nil
+}
+
+// encode serializes the frame for sending
+func (f *stompFrame) encode() []byte {
+	var b bytes.Buffer
+	b.WriteString(f.Command)
+	b.WriteByte('\n')
+	for key, value := range f.Headers {
+		b.WriteString(stompEscape(key))
+		b.WriteByte(':')
+		b.WriteString(stompEscape(value))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	b.Write(f.Body)
+	b.WriteByte(0)
+	return b.Bytes()
+}
+
+var (
+	stompEscaper   = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+	stompUnescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+)
+
+func stompEscape(s string) string   { return stompEscaper.Replace(s) }
+func stompUnescape(s string) string { return stompUnescaper.Replace(s) }
+
+// stompSubscription is a client subscription to a destination
+type stompSubscription struct {
+	ID          string
+	Destination string
+	AckMode     string
+}
+
+// stompSession holds per-connection STOMP state
+type stompSession struct {
+	server        *Server
+	conn          *Connection
+	ws            *websocket.Conn
+	writeMu       sync.Mutex
+	mu            sync.RWMutex
+	connected     bool
+	subscriptions map[string]*stompSubscription
+	heartbeat     time.Duration
+}
+
+// HandleSTOMP serves STOMP 1.2 over a WebSocket. Topics map onto channels,
+// /user/ destinations onto direct messages, and ACK frames onto ack messages.
+func (s *Server) HandleSTOMP(w http.ResponseWriter, r *http.Request, connID, userID string) error {
+	upgrader := s.upgrader
+	upgrader.Subprotocols = []string{"v12.stomp", "v11.stomp", "v10.stomp"}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return fmt.Errorf("upgrade error: %w", err)
+	}
+
+	conn := newConnection(connID, userID, TransportSTOMP)
+	conn.Client = clientInfoFromRequest(r, connID)
+	if err := s.registerConnection(conn, ws); err != nil {
+		ws.Close()
+		return err
+	}
+
+	sess := &stompSession{
+		server:        s,
+		conn:          conn,
+		ws:            ws,
+		subscriptions: make(map[string]*stompSubscription),
+	}
+
+	go sess.readLoop()
+	go sess.writeLoop()
+
+	return nil
+}
+
+// send writes a frame to the client
+func (sess *stompSession) send(frame *stompFrame) error {
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	sess.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return sess.ws.WriteMessage(websocket.TextMessage, frame.encode())
+}
+
+// sendError reports a protocol error; STOMP requires closing afterwards
+func (sess *stompSession) sendError(message string, cause *stompFrame) {
+	frame := &stompFrame{
+		Command: "ERROR",
+		Headers: map[string]string{"message": message, "content-type": "text/plain"},
+	}
+	if cause != nil {
+		if receipt, ok := cause.Headers["receipt"]; ok {
+			frame.Headers["receipt-id"] = receipt
+		}
+	}
+	sess.send(frame)
+}
+
+// readLoop handles client frames
+func (sess *stompSession) readLoop() {
+	s := sess.server
+	defer func() {
+		s.removeConnection(sess.conn.ID)
+		sess.ws.Close()
+	}()
+
+	sess.ws.SetReadLimit(s.config.MaxMessageSize)
+	sess.ws.SetReadDeadline(time.Now().Add(s.config.PongWait))
+	sess.ws.SetPongHandler(func(string) error {
+		sess.ws.SetReadDeadline(time.Now().Add(s.config.PongWait))
+		return nil
+	})
+
+	for {
+		_, data, err := sess.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("stomp error: %v", err)
+			}
+			return
+		}
+		sess.ws.SetReadDeadline(time.Now().Add(s.config.PongWait))
+		sess.conn.Touch()
+
+		frame, err := parseStompFrame(data)
+		if err != nil {
+			sess.sendError(err.Error(), nil)
+			return
+		}
+		if frame == nil {
+			continue
+		}
+
+		if !sess.handleFrame(frame) {
+			return
+		}
+	}
+}
+
+// writeLoop delivers queued messages as MESSAGE frames and sends heart-beats
+func (sess *stompSession) writeLoop() {
+	s := sess.server
+	ticker := time.NewTicker(s.config.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			sess.mu.RLock()
+			heartbeat := sess.heartbeat
+			sess.mu.RUnlock()
+
+			sess.writeMu.Lock()
+			sess.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			err := sess.ws.WriteMessage(websocket.PingMessage, []byte{})
+			if err == nil && heartbeat > 0 {
+				err = sess.ws.WriteMessage(websocket.TextMessage, []byte("\n"))
+			}
+			sess.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		case msg := <-sess.conn.outChan:
+			if msg == nil {
+				return
+			}
+			if s.dropExpired(msg) {
+				continue
+			}
+			if err := sess.deliver(msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// deliver sends a message to every subscription whose destination matches it
+func (sess *stompSession) deliver(msg *Message) error {
+	var destination string
+	if msg.Channel != "" {
+		destination = stompTopicPrefix + msg.Channel
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil
+	}
+
+	sess.mu.RLock()
+	matches := make([]*stompSubscription, 0)
+	for _, sub := range sess.subscriptions {
+		if destination != "" && MatchChannel(sub.Destination, destination) {
+			matches = append(matches, sub)
+		} else if destination == "" && strings.HasPrefix(sub.Destination, stompUserPrefix) {
+			matches = append(matches, sub)
+		}
+	}
+	sess.mu.RUnlock()
+
+	for _, sub := range matches {
+		dest := destination
+		if dest == "" {
+			dest = sub.Destination
+		}
+		frame := &stompFrame{
+			Command: "MESSAGE",
+			Headers: map[string]string{
+				"subscription":   sub.ID,
+				"message-id":     msg.ID,
+				"destination":    dest,
+				"content-type":   "application/json",
+				"message-type":   string(msg.Type),
+				"content-length": fmt.Sprint(len(body)),
+			},
+			Body: body,
+		}
+		if sub.AckMode != "auto" {
+			frame.Headers["ack"] = msg.ID
+		}
+		if err := sess.send(frame); err != nil {
+			sess.server.deliveryFailed(sess.conn, msg, err)
+			return err
+		}
+	}
+
+	// Messages no subscription asked for are filtered, not failed
+	if len(matches) > 0 {
+		sess.server.delivered(sess.conn, msg)
+	}
+	return nil
+}
+
+// handleFrame processes a client frame; it returns false when the session should end
+func (sess *stompSession) handleFrame(frame *stompFrame) bool {
+	sess.mu.RLock()
+	connected := sess.connected
+	sess.mu.RUnlock()
+
+	if !connected && frame.Command != "CONNECT" && frame.Command != "STOMP" {
+		sess.sendError("not connected", frame)
+		return false
+	}
+
+	var err error
+	switch frame.Command {
+	case "CONNECT", "STOMP":
+		sess.handleConnect(frame)
+	case "SUBSCRIBE":
+		err = sess.handleSubscribe(frame)
+	case "UNSUBSCRIBE":
+		err = sess.handleUnsubscribe(frame)
+	case "SEND":
+		err = sess.handleSend(frame)
+	case "ACK", "NACK":
+		err = sess.handleAck(frame)
+	case "BEGIN", "COMMIT", "ABORT":
+		err = fmt.Errorf("transactions are not supported")
+	case "DISCONNECT":
+		sess.sendReceipt(frame)
+		return false
+	default:
+		err = fmt.Errorf("unknown command: %s", frame.Command)
+	}
+
+	if err != nil {
+		sess.sendError(err.Error(), frame)
+		return false
+	}
+
+	sess.sendReceipt(frame)
+	return true
+}
+
+// sendReceipt answers a frame's receipt header, if any
+func (sess *stompSession) sendReceipt(frame *stompFrame) {
+	if receipt, ok := frame.Headers["receipt"]; ok && frame.Command != "CONNECT" && frame.Command != "STOMP" {
+		sess.send(&stompFrame{Command: "RECEIPT", Headers: map[string]string{"receipt-id": receipt}})
+	}
+}
+
+// handleConnect negotiates the session
+func (sess *stompSession) handleConnect(frame *stompFrame) {
+	// Heart-beat header is "cx,cy": cy is how often the client wants to hear from us
+	var cx, cy int
+	fmt.Sscanf(frame.Headers["heart-beat"], "%d,%d", &cx, &cy)
+	ping := sess.server.config.PingInterval
+
+	sess.mu.Lock()
+	sess.connected = true
+	if cy > 0 {
+		sess.heartbeat = ping
+	}
+	sess.mu.Unlock()
+
+	sess.send(&stompFrame{
+		Command: "CONNECTED",
+		Headers: map[string]string{
+			"version":    "1.2",
+			"server":     "go-ws",
+			"session":    sess.conn.ID,
+			"user-name":  sess.conn.UserID,
+			"heart-beat": fmt.Sprintf("%d,0", ping.Milliseconds()),
+		},
+	})
+}
+
+// handleSubscribe registers a subscription and joins the mapped channel
+func (sess *stompSession) handleSubscribe(frame *stompFrame) error {
+	id := frame.Headers["id"]
+	destination := frame.Headers["destination"]
+	if id == "" || destination == "" {
+		return fmt.Errorf("SUBSCRIBE requires id and destination headers")
+	}
+
+	ackMode := frame.Headers["ack"]
+	if ackMode == "" {
+		ackMode = "auto"
+	}
+
+	if strings.HasPrefix(destination, stompTopicPrefix) {
+		channel := strings.TrimPrefix(destination, stompTopicPrefix)
+		if err := sess.server.SubscribeToChannel(sess.conn.ID, channel); err != nil {
+			return err
+		}
+	} else if !strings.HasPrefix(destination, stompUserPrefix) {
+		return fmt.Errorf("unsupported destination: %s", destination)
+	}
+
+	sess.mu.Lock()
+	sess.subscriptions[id] = &stompSubscription{ID: id, Destination: destination, AckMode: ackMode}
+	sess.mu.Unlock()
+	return nil
+}
+
+// handleUnsubscribe drops a subscription and leaves the channel if nothing else needs it
+func (sess *stompSession) handleUnsubscribe(frame *stompFrame) error {
+	id := frame.Headers["id"]
+
+	sess.mu.Lock()
+	sub, exists := sess.subscriptions[id]
+	delete(sess.subscriptions, id)
+	stillNeeded := false
+	if exists {
+		for _, other := range sess.subscriptions {
+			if other.Destination == sub.Destination {
+				stillNeeded = true
+				break
+			}
+		}
+	}
+	sess.mu.Unlock()
+
+	if !exists {
+		return fmt.Errorf("unknown subscription: %s", id)
+	}
+	if !stillNeeded && strings.HasPrefix(sub.Destination, stompTopicPrefix) {
+		return sess.server.UnsubscribeFromChannel(sess.conn.ID, strings.TrimPrefix(sub.Destination, stompTopicPrefix))
+	}
+	return nil
+}
+
+// handleSend turns a SEND frame into a Message and feeds it to the pipeline.
+// A JSON object body is used as the payload; anything else becomes payload.content.
+func (sess *stompSession) handleSend(frame *stompFrame) error {
+	destination := frame.Headers["destination"]
+
+	msg := &Message{Metadata: map[string]interface{}{"stomp_destination": destination}}
+	switch {
+	case strings.HasPrefix(destination, stompTopicPrefix):
+		msg.Channel = strings.TrimPrefix(destination, stompTopicPrefix)
+		msg.Type = MessageTypeChatGroup
+	case strings.HasPrefix(destination, stompUserPrefix):
+		msg.Recipient = strings.TrimPrefix(destination, stompUserPrefix)
+		msg.Type = MessageTypeChatPrivate
+	default:
+		return fmt.Errorf("unsupported destination: %s", destination)
+	}
+
+	if msgType := frame.Headers["message-type"]; msgType != "" {
+		msg.Type = MessageType(msgType)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(frame.Body, &payload); err == nil {
+		msg.Payload = payload
+	} else {
+		msg.Payload = map[string]interface{}{"content": string(frame.Body)}
+	}
+
+	return sess.server.acceptMessage(sess.conn, msg)
+}
+
+// handleAck maps ACK/NACK frames onto ack messages
+func (sess *stompSession) handleAck(frame *stompFrame) error {
+	id := frame.Headers["id"]
+	if id == "" {
+		return fmt.Errorf("%s requires an id header", frame.Command)
+	}
+
+	return sess.server.acceptMessage(sess.conn, &Message{
+		Type: MessageTypeAck,
+		Payload: map[string]interface{}{
+			"message_id": id,
+			"accepted":   frame.Command == "ACK",
+		},
+	})
+}
+
+// setupSTOMPRoutes registers the STOMP over WebSocket endpoint
+func setupSTOMPRoutes(mux *http.ServeMux, server *Server) {
+	mux.HandleFunc("/stomp", func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := connectUserID(w, r)
+		if !ok {
+			return
+		}
+		connID := "conn_" + uuid.New().String()[:12]
+
+		if err := server.HandleSTOMP(w, r, connID, userID); err != nil {
+			log.Printf("STOMP connection error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,811 @@
+package wssocket
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Default and maximum page sizes for history queries
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+// Page describes a window into a message history, counted back from the newest
+// message. Channel, DM and user history page with Before; Offset is only used
+// by FindMessages, whose sort order is not fixed.
+type Page struct {
+	Limit  int
+	Offset int
+	Before *Cursor // Return messages older than this position; nil starts at the newest
+}
+
+// Cursor is a position in a history, ordered by timestamp then ID
+type Cursor struct {
+	Timestamp int64
+	ID        string
+}
+
+// CursorFor returns the cursor positioned at msg
+func CursorFor(msg *Message) *Cursor {
+	return &Cursor{Timestamp: msg.Timestamp, ID: msg.ID}
+}
+
+// String encodes the cursor as an opaque token for clients
+func (c *Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.Timestamp, 10) + ":" + c.ID))
+}
+
+// ParseCursor decodes a token produced by Cursor.String
+func ParseCursor(token string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	ts, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	timestamp, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &Cursor{Timestamp: timestamp, ID: id}, nil
+}
+
+// after reports whether msg sorts at or after the cursor
+func (c *Cursor) after(msg *Message) bool {
+	if msg.Timestamp != c.Timestamp {
+		return msg.Timestamp > c.Timestamp
+	}
+	return msg.ID >= c.ID
+}
+
+// normalize clamps the page to sane bounds
+func (p Page) normalize() Page {
+	if p.Limit <= 0 {
+		p.Limit = DefaultPageLimit
+	}
+	if p.Limit > MaxPageLimit {
+		p.Limit = MaxPageLimit
+	}
+	if p.Offset < 0 {
+		p.Offset = 0
+	}
+	return p
+}
+
+// MessageStore is the unified persistence interface for message history.
+// Results are always returned oldest first.
+type MessageStore interface {
+	SaveMessage(msg *Message) error
+	SaveMessages(msgs []*Message) ([]SaveStatus, error)
+	// GetMessage returns a message by ID, or ErrMessageNotFound
+	GetMessage(id string) (*Message, error)
+	GetChannelMessages(channel string, page Page) ([]*Message, error)
+	GetDMMessages(user1, user2 string, page Page) ([]*Message, error)
+	GetUserMessages(userID string, page Page) ([]*Message, error)
+	GetMessageCount(channel string) (int, error)
+	DeleteMessage(id string) error
+	ClearChannel(channel string) error
+	DeleteMessagesBefore(channel string, before int64) (int, error)
+	DeleteExpiredMessages(now int64) (map[string]int, error)
+	FindMessages(q MessageQuery) ([]*Message, error)
+	SearchMessages(q SearchQuery) ([]*SearchResult, error)
+	GetChannelStats(channel string) ([]ChannelStats, error)
+}
+
+// ChannelStats summarizes storage usage for a channel
+type ChannelStats struct {
+	Channel         string `json:"channel"`
+	MessageCount    int    `json:"count"`
+	PayloadBytes    int64  `json:"payload_bytes"`
+	OldestTimestamp int64  `json:"oldest_timestamp"`
+	NewestTimestamp int64  `json:"newest_timestamp"`
+}
+
+// storedSize approximates the bytes a message occupies in storage
+func storedSize(msg *Message) int64 {
+	size := int64(len(messageContent(msg)))
+	if len(msg.Metadata) > 0 {
+		if data, err := json.Marshal(msg.Metadata); err == nil {
+			size += int64(len(data))
+		}
+	}
+	return size
+}
+
+// MessageQuery combines filters for searching stored messages.
+// Empty fields are ignored; Since and Until are inclusive unix timestamps.
+type MessageQuery struct {
+	Sender    string
+	Recipient string
+	Channel   string
+	Type      string
+	Since     int64
+	Until     int64
+	Metadata  map[string]string // metadata key -> exact string value
+	SortBy    string            // timestamp, sender, channel or type
+	SortDesc  bool
+	Page      Page
+}
+
+// SearchQuery is a full-text search over message content. Text accepts
+// web-search syntax: quoted phrases, "or" and -excluded words.
+type SearchQuery struct {
+	Text    string
+	Channel string // Only this channel
+	User    string // Only messages sent or received by this user
+	Since   int64  // Inclusive unix timestamp
+	Until   int64  // Inclusive unix timestamp
+	Limit   int
+}
+
+// ErrMessageNotFound is returned when no message has the requested ID
+var ErrMessageNotFound = errors.New("message not found")
+
+// ErrSearchUnsupported is returned by stores that can't search message content
+var ErrSearchUnsupported = errors.New("full-text search is not available for encrypted messages")
+
+// SearchResult is a matching message with its relevance, higher is better
+type SearchResult struct {
+	Message *Message `json:"-"`
+	Rank    float64  `json:"rank"`
+}
+
+// MarshalJSON flattens the message into the history shape next to its rank
+func (r *SearchResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		*HistoryMessage
+		Rank float64 `json:"rank"`
+	}{NewHistoryMessage(r.Message), r.Rank})
+}
+
+// Validate checks the search and clamps its limit
+func (q *SearchQuery) Validate() error {
+	if strings.TrimSpace(q.Text) == "" {
+		return fmt.Errorf("search text is required")
+	}
+	if q.Since > 0 && q.Until > 0 && q.Since > q.Until {
+		return fmt.Errorf("since must not be after until")
+	}
+	q.Limit = Page{Limit: q.Limit}.normalize().Limit
+	return nil
+}
+
+// sortableMessageFields lists the fields MessageQuery.SortBy accepts
+var sortableMessageFields = map[string]bool{
+	"timestamp": true,
+	"sender":    true,
+	"channel":   true,
+	"type":      true,
+}
+
+// Validate checks the query for unsupported options
+func (q *MessageQuery) Validate() error {
+	if q.SortBy == "" {
+		q.SortBy = "timestamp"
+	}
+	if !sortableMessageFields[q.SortBy] {
+		return fmt.Errorf("unsupported sort field: %s", q.SortBy)
+	}
+	if q.Since > 0 && q.Until > 0 && q.Since > q.Until {
+		return fmt.Errorf("since must not be after until")
+	}
+	q.Page = q.Page.normalize()
+	return nil
+}
+
+// Matches reports whether a message satisfies the query filters
+func (q *MessageQuery) Matches(msg *Message) bool {
+	if q.Sender != "" && msg.Sender != q.Sender {
+		return false
+	}
+	if q.Recipient != "" && msg.Recipient != q.Recipient {
+		return false
+	}
+	if q.Channel != "" && msg.Channel != q.Channel {
+		return false
+	}
+	if q.Type != "" && string(msg.Type) != q.Type {
+		return false
+	}
+	if q.Since > 0 && msg.Timestamp < q.Since {
+		return false
+	}
+	if q.Until > 0 && msg.Timestamp > q.Until {
+		return false
+	}
+	for key, want := range q.Metadata {
+		if fmt.Sprint(msg.Metadata[key]) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// messageSortKey returns the string key used to sort by a field
+func messageSortKey(msg *Message, field string) string {
+	switch field {
+	case "sender":
+		return msg.Sender
+	case "channel":
+		return msg.Channel
+	case "type":
+		return string(msg.Type)
+	}
+	return ""
+}
+
+// SaveStatus is the outcome of persisting a single message
+type SaveStatus string
+
+const (
+	SaveStatusSaved     SaveStatus = "saved"
+	SaveStatusDuplicate SaveStatus = "duplicate"
+	SaveStatusInvalid   SaveStatus = "invalid"
+	SaveStatusQueued    SaveStatus = "queued" // Held in memory until the database is reachable
+)
+
+// Global message store used by the history API and handlers
+var globalStore MessageStore
+
+// HistoryMessage is the flat wire model used by the REST history API
+type HistoryMessage struct {
+	ID        string                 `json:"id"`
+	Sender    string                 `json:"sender"`
+	Channel   string                 `json:"channel"`
+	Content   string                 `json:"content"`
+	Type      string                 `json:"type"`
+	Timestamp int64                  `json:"timestamp"`
+	Recipient *string                `json:"recipient"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	Payload   map[string]interface{} `json:"payload,omitempty"` // Set when the payload holds more than Content
+}
+
+// ToMessage converts the wire model into a Message
+func (h *HistoryMessage) ToMessage() *Message {
+	msg := &Message{
+		ID:        h.ID,
+		Type:      MessageType(h.Type),
+		Sender:    h.Sender,
+		Channel:   h.Channel,
+		Timestamp: h.Timestamp,
+		Payload:   h.Payload,
+		Metadata:  h.Metadata,
+	}
+	if msg.Payload == nil {
+		msg.Payload = map[string]interface{}{"content": h.Content}
+	}
+	if msg.Type == "" {
+		msg.Type = MessageTypeChat
+	}
+	if h.Recipient != nil {
+		msg.Recipient = *h.Recipient
+	}
+	return msg
+}
+
+// NewHistoryMessage converts a Message into the wire model
+func NewHistoryMessage(msg *Message) *HistoryMessage {
+	h := &HistoryMessage{
+		ID:        msg.ID,
+		Sender:    msg.Sender,
+		Channel:   msg.Channel,
+		Content:   messageContent(msg),
+		Type:      string(msg.Type),
+		Timestamp: msg.Timestamp,
+		Metadata:  msg.Metadata,
+		Payload:   structuredPayload(msg),
+	}
+	if msg.Recipient != "" {
+		recipient := msg.Recipient
+		h.Recipient = &recipient
+	}
+	return h
+}
+
+// NewHistoryMessages converts a list of Messages into wire models
+func NewHistoryMessages(msgs []*Message) []*HistoryMessage {
+	out := make([]*HistoryMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		out = append(out, NewHistoryMessage(msg))
+	}
+	return out
+}
+
+// messageContent extracts the text content stored for a message
+func messageContent(msg *Message) string {
+	if msg.Payload == nil {
+		return ""
+	}
+	if content, ok := msg.Payload["content"].(string); ok {
+		return content
+	}
+	if text, ok := msg.Payload["text"].(string); ok {
+		return text
+	}
+	data, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// messageText returns a message's text, from its payload's content or text
+// field; unlike messageContent it is empty for payloads without one
+func messageText(msg *Message) string {
+	if content, ok := msg.Payload["content"].(string); ok {
+		return content
+	}
+	text, _ := msg.Payload["text"].(string)
+	return text
+}
+
+// structuredPayload returns a message's payload when it holds more than its
+// text, or nil when {"content": text} already says everything
+func structuredPayload(msg *Message) map[string]interface{} {
+	if len(msg.Payload) == 0 {
+		return nil
+	}
+	if _, ok := msg.Payload["content"].(string); ok && len(msg.Payload) == 1 {
+		return nil
+	}
+	return msg.Payload
+}
+
+// validateStoredMessage checks the fields every persisted message needs
+func validateStoredMessage(msg *Message) error {
+	if msg.ID == "" {
+		return fmt.Errorf("message id is required")
+	}
+	if msg.Sender == "" {
+		return fmt.Errorf("message sender is required")
+	}
+	if msg.Channel == "" {
+		return fmt.Errorf("message channel is required")
+	}
+	return nil
+}
+
+// InMemoryMessageStore is a MessageStore kept entirely in memory
+type InMemoryMessageStore struct {
+	mu       sync.RWMutex
+	messages []*Message
+	index    map[string]int
+	readAt   map[string]map[string]int64 // conversation -> user -> last read
+	groups   map[string]*Conversation    // group conversations by ID
+
+	channelRead map[string]map[string]*ChannelReadState // user -> channel -> marker
+}
+
+// NewInMemoryMessageStore creates an empty in-memory store
+func NewInMemoryMessageStore() *InMemoryMessageStore {
+	return &InMemoryMessageStore{
+		messages: make([]*Message, 0),
+		index:    make(map[string]int),
+		readAt:   make(map[string]map[string]int64),
+		groups:   make(map[string]*Conversation),
+
+		channelRead: make(map[string]map[string]*ChannelReadState),
+	}
+}
+
+// SaveMessage stores a message, ignoring duplicates by ID
+func (s *InMemoryMessageStore) SaveMessage(msg *Message) error {
+	if err := validateStoredMessage(msg); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insert(msg)
+	return nil
+}
+
+// SaveMessages stores multiple messages atomically and reports a status per message
+func (s *InMemoryMessageStore) SaveMessages(msgs []*Message) ([]SaveStatus, error) {
+	for i, msg := range msgs {
+		if err := validateStoredMessage(msg); err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]SaveStatus, len(msgs))
+	for i, msg := range msgs {
+		if s.insert(msg) {
+			statuses[i] = SaveStatusSaved
+		} else {
+			statuses[i] = SaveStatusDuplicate
+		}
+	}
+	return statuses, nil
+}
+
+// insert adds a message keeping timestamp then ID order; caller must hold the lock
+func (s *InMemoryMessageStore) insert(msg *Message) bool {
+	if _, exists := s.index[msg.ID]; exists {
+		return false
+	}
+
+	pos := sort.Search(len(s.messages), func(i int) bool {
+		other := s.messages[i]
+		return other.Timestamp > msg.Timestamp || (other.Timestamp == msg.Timestamp && other.ID > msg.ID)
+	})
+	s.messages = append(s.messages, nil)
+	copy(s.messages[pos+1:], s.messages[pos:])
+	s.messages[pos] = msg
+	s.reindex()
+	return true
+}
+
+// reindex rebuilds the ID index; caller must hold the lock
+func (s *InMemoryMessageStore) reindex() {
+	s.index = make(map[string]int, len(s.messages))
+	for i, msg := range s.messages {
+		s.index[msg.ID] = i
+	}
+}
+
+// filterPage returns the requested page of messages matching fn, oldest first
+func (s *InMemoryMessageStore) filterPage(page Page, fn func(*Message) bool) []*Message {
+	page = page.normalize()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*Message, 0)
+	for i := len(s.messages) - 1; i >= 0 && len(result) < page.Limit; i-- {
+		msg := s.messages[i]
+		if page.Before != nil && page.Before.after(msg) {
+			continue
+		}
+		if fn(msg) {
+			result = append(result, msg)
+		}
+	}
+
+	reverseMessages(result)
+	return result
+}
+
+// GetMessage returns a message by ID
+func (s *InMemoryMessageStore) GetMessage(id string) (*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pos, exists := s.index[id]
+	if !exists {
+		return nil, ErrMessageNotFound
+	}
+	return s.messages[pos], nil
+}
+
+// GetChannelMessages returns a page of messages for a channel
+func (s *InMemoryMessageStore) GetChannelMessages(channel string, page Page) ([]*Message, error) {
+	return s.filterPage(page, func(msg *Message) bool {
+		return msg.Channel == channel
+	}), nil
+}
+
+// GetDMMessages returns a page of direct messages between two users
+func (s *InMemoryMessageStore) GetDMMessages(user1, user2 string, page Page) ([]*Message, error) {
+	return s.filterPage(page, func(msg *Message) bool {
+		return (msg.Sender == user1 && msg.Recipient == user2) ||
+			(msg.Sender == user2 && msg.Recipient == user1)
+	}), nil
+}
+
+// GetUserMessages returns a page of messages sent or received by a user
+func (s *InMemoryMessageStore) GetUserMessages(userID string, page Page) ([]*Message, error) {
+	return s.filterPage(page, func(msg *Message) bool {
+		return msg.Sender == userID || msg.Recipient == userID
+	}), nil
+}
+
+// GetMessageCount returns the number of messages in a channel
+func (s *InMemoryMessageStore) GetMessageCount(channel string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, msg := range s.messages {
+		if msg.Channel == channel {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// DeleteMessage removes a message by ID
+func (s *InMemoryMessageStore) DeleteMessage(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pos, exists := s.index[id]
+	if !exists {
+		return nil
+	}
+	s.messages = append(s.messages[:pos], s.messages[pos+1:]...)
+	s.reindex()
+	return nil
+}
+
+// ClearChannel removes all messages in a channel
+func (s *InMemoryMessageStore) ClearChannel(channel string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.messages[:0]
+	for _, msg := range s.messages {
+		if msg.Channel != channel {
+			kept = append(kept, msg)
+		}
+	}
+	s.messages = kept
+	s.reindex()
+	return nil
+}
+
+// DeleteMessagesBefore removes a channel's messages with timestamps before a unix time
+func (s *InMemoryMessageStore) DeleteMessagesBefore(channel string, before int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.messages[:0]
+	for _, msg := range s.messages {
+		if msg.Channel != channel || msg.Timestamp >= before {
+			kept = append(kept, msg)
+		}
+	}
+	deleted := len(s.messages) - len(kept)
+	clear(s.messages[len(kept):])
+	s.messages = kept
+	s.reindex()
+	return deleted, nil
+}
+
+// DeleteExpiredMessages removes messages whose ttl ran out before now, in
+// unix milliseconds, and returns how many were removed per channel
+func (s *InMemoryMessageStore) DeleteExpiredMessages(now int64) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := make(map[string]int)
+	kept := s.messages[:0]
+	for _, msg := range s.messages {
+		if at, ok := messageExpiry(msg); ok && at.UnixMilli() <= now {
+			deleted[msg.Channel]++
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	clear(s.messages[len(kept):])
+	s.messages = kept
+	s.reindex()
+	return deleted, nil
+}
+
+// searchWords splits text into lowercase words
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-'
+	})
+}
+
+// SearchMessages matches every search word (words prefixed with "-" must be
+// absent) and ranks by how often the words occur relative to message length
+func (s *InMemoryMessageStore) SearchMessages(q SearchQuery) ([]*SearchResult, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	var include, exclude []string
+	for _, word := range searchWords(q.Text) {
+		if excluded, ok := strings.CutPrefix(word, "-"); ok {
+			exclude = append(exclude, excluded)
+		} else if word != "or" {
+			include = append(include, word)
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := make([]*SearchResult, 0)
+	for _, msg := range s.messages {
+		if (q.Channel != "" && msg.Channel != q.Channel) ||
+			(q.User != "" && msg.Sender != q.User && msg.Recipient != q.User) ||
+			(q.Since > 0 && msg.Timestamp < q.Since) ||
+			(q.Until > 0 && msg.Timestamp > q.Until) {
+			continue
+		}
+
+		counts := make(map[string]int)
+		words := searchWords(messageContent(msg))
+		for _, word := range words {
+			counts[word]++
+		}
+
+		hits := 0
+		for _, word := range include {
+			if counts[word] == 0 {
+				hits = -1
+				break
+			}
+			hits += counts[word]
+		}
+		for _, word := range exclude {
+			if counts[word] > 0 {
+				hits = -1
+			}
+		}
+		if hits <= 0 {
+			continue
+		}
+		results = append(results, &SearchResult{Message: msg, Rank: float64(hits) / float64(len(words))})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].Message.Timestamp > results[j].Message.Timestamp
+	})
+	if len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results, nil
+}
+
+// FindMessages returns messages matching the query in the requested order
+func (s *InMemoryMessageStore) FindMessages(q MessageQuery) ([]*Message, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	matched := make([]*Message, 0)
+	for _, msg := range s.messages {
+		if q.Matches(msg) {
+			matched = append(matched, msg)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if q.SortBy != "timestamp" {
+			ka, kb := messageSortKey(a, q.SortBy), messageSortKey(b, q.SortBy)
+			if ka != kb {
+				return (ka < kb) != q.SortDesc
+			}
+		}
+		if a.Timestamp != b.Timestamp {
+			return (a.Timestamp < b.Timestamp) != q.SortDesc
+		}
+		return (a.ID < b.ID) != q.SortDesc
+	})
+
+	if q.Page.Offset >= len(matched) {
+		return []*Message{}, nil
+	}
+	end := q.Page.Offset + q.Page.Limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	return matched[q.Page.Offset:end], nil
+}
+
+// GetChannelStats returns storage usage for a channel, or all channels when channel is empty
+func (s *InMemoryMessageStore) GetChannelStats(channel string) ([]ChannelStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byChannel := make(map[string]*ChannelStats)
+	for _, msg := range s.messages {
+		if channel != "" && msg.Channel != channel {
+			continue
+		}
+		st, exists := byChannel[msg.Channel]
+		if !exists {
+			st = &ChannelStats{Channel: msg.Channel, OldestTimestamp: msg.Timestamp}
+			byChannel[msg.Channel] = st
+		}
+		st.MessageCount++
+		st.PayloadBytes += storedSize(msg)
+		if msg.Timestamp < st.OldestTimestamp {
+			st.OldestTimestamp = msg.Timestamp
+		}
+		if msg.Timestamp > st.NewestTimestamp {
+			st.NewestTimestamp = msg.Timestamp
+		}
+	}
+
+	stats := make([]ChannelStats, 0, len(byChannel))
+	for _, st := range byChannel {
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Channel < stats[j].Channel
+	})
+	return stats, nil
+}
+
+// reverseMessages reverses a slice of messages in place
+func reverseMessages(msgs []*Message) {
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+}
+
+// ContentKeyUsage counts stored messages per channel and encryption key
+func (s *InMemoryMessageStore) ContentKeyUsage() ([]ContentKeyUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	type usageKey struct{ channel, keyID string }
+	counts := make(map[usageKey]int)
+	for _, msg := range s.messages {
+		counts[usageKey{msg.Channel, contentKeyID(messageContent(msg))}]++
+	}
+
+	usage := make([]ContentKeyUsage, 0, len(counts))
+	for k, n := range counts {
+		usage = append(usage, ContentKeyUsage{Channel: k.channel, KeyID: k.keyID, Count: n})
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Channel != usage[j].Channel {
+			return usage[i].Channel < usage[j].Channel
+		}
+		return usage[i].KeyID < usage[j].KeyID
+	})
+	return usage, nil
+}
+
+// ScanMessages returns stored messages with IDs after afterID, ordered by ID
+func (s *InMemoryMessageStore) ScanMessages(afterID string, limit int) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matched := make([]*Message, 0)
+	for _, msg := range s.messages {
+		if msg.ID > afterID {
+			matched = append(matched, msg)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID < matched[j].ID })
+	if limit > 0 && len(matched) > limit {
+		matched = matched[:limit]
+	}
+	return matched, nil
+}
+
+// UpdateContent replaces a message's content if it hasn't changed since it was read
+func (s *InMemoryMessageStore) UpdateContent(id, oldContent, newContent string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, exists := s.index[id]
+	if !exists || messageContent(s.messages[i]) != oldContent {
+		return nil
+	}
+	updated := *s.messages[i]
+	updated.Payload = make(map[string]interface{}, len(updated.Payload)+1)
+	for k, v := range s.messages[i].Payload {
+		updated.Payload[k] = v
+	}
+	updated.Payload["content"] = newContent
+	s.messages[i] = &updated
+	return nil
+}
@@ -0,0 +1,151 @@
+package wssocket
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// syncEventMessageSent is the sync event for a direct message sent from
+// another device
+const syncEventMessageSent = "message:sent"
+
+// syncDevices tells a user's other connections about a change made on conn.
+// The payload names the change as event, along with its details.
+func (s *Server) syncDevices(conn *Connection, event, channel string, details map[string]interface{}) {
+	s.mu.RLock()
+	others := make([]string, 0)
+	for connID, other := range s.connections {
+		if other.UserID == conn.UserID && connID != conn.ID {
+			others = append(others, connID)
+		}
+	}
+	s.mu.RUnlock()
+	if len(others) == 0 {
+		return
+	}
+
+	payload := make(map[string]interface{}, len(details)+2)
+	for k, v := range details {
+		payload[k] = v
+	}
+	payload["event"] = event
+	payload["from"] = conn.ID
+	for _, connID := range others {
+		s.SendToConnection(connID, &Message{
+			ID:        generateMessageID(),
+			Type:      MessageTypeSync,
+			Sender:    "system",
+			Recipient: conn.UserID,
+			Channel:   channel,
+			Timestamp: s.now().Unix(),
+			Payload:   payload,
+		})
+	}
+}
+
+// syncSent tells the sender's other devices about a direct message they
+// sent. A note to self already reaches them.
+func (s *Server) syncSent(conn *Connection, msg *Message) {
+	if msg.Recipient == conn.UserID {
+		return
+	}
+	s.syncDevices(conn, syncEventMessageSent, "", map[string]interface{}{
+		"message": map[string]interface{}{
+			"id":        msg.ID,
+			"type":      string(msg.Type),
+			"recipient": msg.Recipient,
+			"timestamp": msg.Timestamp,
+			"payload":   msg.Payload,
+		},
+	})
+}
+
+// ChannelMuteHandler mutes or unmutes notifications from a channel for the
+// sender. The payload's muted defaults to true.
+func ChannelMuteHandler(conn *Connection, msg *Message) error {
+	if msg.Channel == "" {
+		return fmt.Errorf("channel is required")
+	}
+	if globalUserStore == nil {
+		return fmt.Errorf("user store not available")
+	}
+	muted := true
+	if v, ok := msg.Payload["muted"].(bool); ok {
+		muted = v
+	}
+	if err := globalUserStore.SetChannelMuted(conn.UserID, msg.Channel, muted); err != nil {
+		return fmt.Errorf("mute %s: %w", msg.Channel, err)
+	}
+
+	details := map[string]interface{}{"channel": msg.Channel, "muted": muted}
+	globalServer.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeChannelMute,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Channel:   msg.Channel,
+		Timestamp: globalServer.now().Unix(),
+		Payload:   details,
+	})
+	globalServer.syncDevices(conn, string(MessageTypeChannelMute), msg.Channel, details)
+	return nil
+}
+
+// SetChannelMuted records whether a user muted a channel
+func (s *InMemoryUserStore) SetChannelMuted(userID, channel string, muted bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !muted {
+		delete(s.mutedChannels[userID], channel)
+		return nil
+	}
+	if s.mutedChannels[userID] == nil {
+		s.mutedChannels[userID] = make(map[string]bool)
+	}
+	s.mutedChannels[userID][channel] = true
+	return nil
+}
+
+// MutedChannels returns the channels a user muted
+func (s *InMemoryUserStore) MutedChannels(userID string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	channels := make([]string, 0, len(s.mutedChannels[userID]))
+	for channel := range s.mutedChannels[userID] {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels, nil
+}
+
+// setupSyncRoutes registers the listing of a user's muted channels
+func setupSyncRoutes(mux *http.ServeMux) {
+	// GET lists the channels ?user_id= muted
+	mux.HandleFunc("/api/users/mutes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if globalUserStore == nil {
+			http.Error(w, "User store not available", http.StatusServiceUnavailable)
+			return
+		}
+		userID, ok := requestUser(w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+
+		channels, err := globalUserStore.MutedChannels(userID)
+		if err != nil {
+			log.Printf("Error listing muted channels of %s: %v", userID, err)
+			http.Error(w, "Failed to list muted channels", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"channels": channels,
+			"count":    len(channels),
+		})
+	})
+}
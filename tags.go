@@ -0,0 +1,91 @@
+package wssocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrInvalidTag is returned for a tag key that is empty or contains "="
+var ErrInvalidTag = errors.New("invalid tag")
+
+// SetTag tags the connection, e.g. SetTag("platform", "ios"), so
+// BroadcastToTag can reach it. Setting a key again replaces its value.
+func (c *Connection) SetTag(key, value string) error {
+	if key == "" || strings.Contains(key, "=") {
+		return fmt.Errorf("%w: %q", ErrInvalidTag, key)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tags == nil {
+		c.tags = make(map[string]string)
+	}
+	c.tags[key] = value
+	return nil
+}
+
+// Tag returns the value of one of the connection's tags
+func (c *Connection) Tag(key string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	value, ok := c.tags[key]
+	return value, ok
+}
+
+// RemoveTag removes a tag from the connection
+func (c *Connection) RemoveTag(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tags, key)
+}
+
+// Tags returns a snapshot of the connection's tags
+func (c *Connection) Tags() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	tags := make(map[string]string, len(c.tags))
+	for key, value := range c.tags {
+		tags[key] = value
+	}
+	return tags
+}
+
+// HasTag reports whether the connection matches a tag: "key=value" needs that
+// value, a bare "key" matches any value
+func (c *Connection) HasTag(tag string) bool {
+	key, want, exact := strings.Cut(tag, "=")
+	value, ok := c.Tag(key)
+	return ok && (!exact || value == want)
+}
+
+// BroadcastToTag sends a message to every connection on this node that
+// matches tag, such as "platform=ios" or "region", and returns how many it
+// was sent to. Connection filters still apply.
+func (s *Server) BroadcastToTag(tag string, msg *Message) (int, error) {
+	if key, _, _ := strings.Cut(tag, "="); key == "" {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+	}
+
+	s.mu.RLock()
+	connIDs := make([]string, 0)
+	for connID, conn := range s.connections {
+		if conn.HasTag(tag) && conn.wants(msg, "") {
+			connIDs = append(connIDs, connID)
+		}
+	}
+	s.mu.RUnlock()
+
+	_, span := tracer.Start(traceContext(context.Background(), msg), "ws.broadcast", trace.WithAttributes(
+		attribute.String("ws.tag", tag), attribute.Int("ws.recipients", len(connIDs))))
+	defer span.End()
+
+	out := withSpan(forFanout(msg, len(connIDs)), span.SpanContext())
+	for _, connID := range connIDs {
+		s.SendToConnection(connID, out)
+	}
+	return len(connIDs), nil
+}
@@ -0,0 +1,224 @@
+package wssocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// NotificationTemplate is a named payload that services fill in with params.
+// String values may use text/template syntax such as "{{.order_id}}".
+type NotificationTemplate struct {
+	Name    string                            `json:"name"`
+	Type    MessageType                       `json:"type,omitempty"` // Defaults to notification
+	Payload map[string]interface{}            `json:"payload"`
+	Locales map[string]map[string]interface{} `json:"locales,omitempty"` // Locale -> payload fields overriding the default
+}
+
+// compiledTemplate holds a template's parsed payloads
+type compiledTemplate struct {
+	msgType MessageType
+	payload map[string]interface{}
+	locales map[string]map[string]interface{}
+}
+
+// TemplateRegistry renders registered notification templates
+type TemplateRegistry struct {
+	mu        sync.RWMutex
+	templates map[string]*compiledTemplate
+}
+
+// globalTemplates holds the notification templates available to the publish API (set during init)
+var globalTemplates *TemplateRegistry
+
+// NewTemplateRegistry creates an empty template registry
+func NewTemplateRegistry() *TemplateRegistry {
+	return &TemplateRegistry{templates: make(map[string]*compiledTemplate)}
+}
+
+// LoadTemplatesFile reads a JSON array of notification templates
+func LoadTemplatesFile(path string) ([]*NotificationTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read templates file: %w", err)
+	}
+	var templates []*NotificationTemplate
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return nil, fmt.Errorf("parse templates file: %w", err)
+	}
+	return templates, nil
+}
+
+// Register adds or replaces a template, parsing every variant up front so
+// mistakes surface at registration rather than at send time
+func (r *TemplateRegistry) Register(t *NotificationTemplate) error {
+	if t.Name == "" {
+		return fmt.Errorf("template name is required")
+	}
+	compiled := &compiledTemplate{msgType: t.Type, locales: make(map[string]map[string]interface{})}
+	if compiled.msgType == "" {
+		compiled.msgType = MessageTypeNotification
+	}
+
+	var err error
+	if compiled.payload, err = compilePayload(t.Name, t.Payload); err != nil {
+		return err
+	}
+	for locale, payload := range t.Locales {
+		if compiled.locales[strings.ToLower(locale)], err = compilePayload(t.Name+"/"+locale, payload); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.templates[t.Name] = compiled
+	return nil
+}
+
+// Names lists the registered templates
+func (r *TemplateRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.templates))
+	for name := range r.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render fills in a template for a locale. Locales fall back from "pt-br" to
+// "pt" to the default payload, and a locale's fields override the default's.
+// It returns the message type, the payload and the locale used ("" for the default).
+func (r *TemplateRegistry) Render(name, locale string, params map[string]interface{}) (MessageType, map[string]interface{}, string, error) {
+	r.mu.RLock()
+	compiled, exists := r.templates[name]
+	r.mu.RUnlock()
+	if !exists {
+		return "", nil, "", fmt.Errorf("template not found: %s", name)
+	}
+
+	payload, err := renderValue(compiled.payload, params)
+	if err != nil {
+		return "", nil, "", fmt.Errorf("render %s: %w", name, err)
+	}
+	rendered := payload.(map[string]interface{})
+
+	used := ""
+	for _, candidate := range localeFallbacks(locale) {
+		override, ok := compiled.locales[candidate]
+		if !ok {
+			continue
+		}
+		localized, err := renderValue(override, params)
+		if err != nil {
+			return "", nil, "", fmt.Errorf("render %s/%s: %w", name, candidate, err)
+		}
+		for k, v := range localized.(map[string]interface{}) {
+			rendered[k] = v
+		}
+		used = candidate
+		break
+	}
+	return compiled.msgType, rendered, used, nil
+}
+
+// localeFallbacks lists the locales to try for a requested locale, most specific first
+func localeFallbacks(locale string) []string {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if locale == "" {
+		return nil
+	}
+	fallbacks := []string{locale}
+	if lang, _, ok := strings.Cut(locale, "-"); ok {
+		fallbacks = append(fallbacks, lang)
+	}
+	return fallbacks
+}
+
+// compilePayload parses every templated string in a payload
+func compilePayload(name string, payload map[string]interface{}) (map[string]interface{}, error) {
+	compiled, err := compileValue(name, payload)
+	if err != nil {
+		return nil, err
+	}
+	if compiled == nil {
+		return map[string]interface{}{}, nil
+	}
+	return compiled.(map[string]interface{}), nil
+}
+
+// compileValue replaces templated strings with parsed templates, recursively
+func compileValue(name string, v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		if !strings.Contains(val, "{{") {
+			return val, nil
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(val)
+		if err != nil {
+			return nil, fmt.Errorf("parse template %s: %w", name, err)
+		}
+		return tmpl, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			compiled, err := compileValue(name, item)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = compiled
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			compiled, err := compileValue(name, item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = compiled
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// renderValue executes compiled templates with params, returning a fresh copy
+func renderValue(v interface{}, params map[string]interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case *template.Template:
+		var buf bytes.Buffer
+		if err := val.Execute(&buf, params); err != nil {
+			return nil, err
+		}
+		return buf.String(), nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			rendered, err := renderValue(item, params)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = rendered
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			rendered, err := renderValue(item, params)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,125 @@
+package wssocket
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records message pipeline spans with the global TracerProvider, so
+// they go nowhere until one is installed
+var tracer = otel.Tracer("go-ws")
+
+// tracePropagator carries trace context in Message.Metadata as W3C
+// "traceparent" and "tracestate" entries
+var tracePropagator = propagation.TraceContext{}
+
+// metadataCarrier adapts Message.Metadata for trace propagation
+type metadataCarrier struct {
+	msg *Message
+}
+
+func (c metadataCarrier) Get(key string) string {
+	v, _ := c.msg.Metadata[key].(string)
+	return v
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	if c.msg.Metadata == nil {
+		c.msg.Metadata = make(map[string]interface{})
+	}
+	c.msg.Metadata[key] = value
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Metadata))
+	for k := range c.msg.Metadata {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// InjectTrace writes ctx's trace context into msg.Metadata so the message
+// continues the trace wherever it goes next
+func InjectTrace(ctx context.Context, msg *Message) {
+	tracePropagator.Inject(ctx, metadataCarrier{msg})
+}
+
+// ExtractTrace returns ctx with the trace context carried in msg.Metadata, if any
+func ExtractTrace(ctx context.Context, msg *Message) context.Context {
+	return tracePropagator.Extract(ctx, metadataCarrier{msg})
+}
+
+// traceContext returns a context parented on the span msg was last handled in
+func traceContext(ctx context.Context, msg *Message) context.Context {
+	if msg.span.IsValid() {
+		return trace.ContextWithSpanContext(ctx, msg.span)
+	}
+	return ExtractTrace(ctx, msg)
+}
+
+// withSpan returns msg to send with sc as the parent of its write spans. The
+// copy keeps msg's prepared frame, so fan-out still encodes once.
+func withSpan(msg *Message, sc trace.SpanContext) *Message {
+	if !sc.IsValid() || msg.span.Equal(sc) {
+		return msg
+	}
+	traced := *msg
+	traced.span = sc
+	return &traced
+}
+
+// messageAttributes describes msg on a span
+func messageAttributes(msg *Message) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("messaging.system", "websocket"),
+		attribute.String("messaging.message.id", msg.ID),
+		attribute.String("ws.message.type", string(msg.Type)),
+		attribute.String("ws.sender", msg.Sender),
+	}
+	if msg.Channel != "" {
+		attrs = append(attrs, attribute.String("ws.channel", msg.Channel))
+	}
+	if msg.Recipient != "" {
+		attrs = append(attrs, attribute.String("ws.recipient", msg.Recipient))
+	}
+	return attrs
+}
+
+// connectionAttributes describes conn on a span
+func connectionAttributes(conn *Connection) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("ws.connection.id", conn.ID),
+		attribute.String("ws.user.id", conn.UserID),
+		attribute.String("ws.transport", conn.Transport),
+	}
+}
+
+// endSpan records err, if any, on span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// EnableOTLPTracing installs a global TracerProvider that exports spans over
+// OTLP/HTTP, configured by the standard OTEL_EXPORTER_OTLP_* and
+// OTEL_SERVICE_NAME environment variables. Call shutdown to flush on exit.
+func EnableOTLPTracing(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
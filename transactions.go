@@ -0,0 +1,44 @@
+package wssocket
+
+import "context"
+
+// Tx is the stores a transaction writes through. Everything done with it
+// commits or rolls back together.
+type Tx interface {
+	MessageStore
+	ChannelStore
+}
+
+// Transactor is a store that can make several writes atomic
+type Transactor interface {
+	// WithTx runs fn in a transaction, committed if fn returns nil and
+	// rolled back otherwise
+	WithTx(ctx context.Context, fn func(tx Tx) error) error
+}
+
+// txStores pairs the stores a Tx is made of
+type txStores struct {
+	MessageStore
+	ChannelStore
+}
+
+// WithTx runs fn against the message and channel stores in one transaction
+// when the channel store supports them. Messages saved through the Tx are
+// encrypted like any others. Without transactions, as with the in-memory
+// stores, fn's steps apply one by one, and a message store kept apart from
+// the channel store is never part of the transaction.
+func (s *Server) WithTx(ctx context.Context, fn func(tx Tx) error) error {
+	transactor, ok := globalChannelStore.(Transactor)
+	if !ok {
+		return fn(txStores{MessageStore: globalStore, ChannelStore: globalChannelStore})
+	}
+	return transactor.WithTx(ctx, func(tx Tx) error {
+		var messages MessageStore = tx
+		if encrypted, ok := globalStore.(*EncryptedStore); ok && interface{}(encrypted.inner) == interface{}(globalChannelStore) {
+			messages = encrypted.within(tx)
+		} else if interface{}(globalStore) != interface{}(globalChannelStore) {
+			messages = globalStore
+		}
+		return fn(txStores{MessageStore: messages, ChannelStore: tx})
+	})
+}
@@ -0,0 +1,446 @@
+package wssocket
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TransportMode decides how WebSocket connections are served
+type TransportMode string
+
+const (
+	TransportModeGoroutine TransportMode = "goroutine" // A reader and a writer goroutine per connection (the default)
+	TransportModeEpoll     TransportMode = "epoll"     // Shared poller goroutines; idle connections hold none (Linux only)
+)
+
+// ParseTransportMode parses a transport mode name; empty means goroutine
+func ParseTransportMode(s string) (TransportMode, error) {
+	switch mode := TransportMode(strings.TrimSpace(s)); mode {
+	case "":
+		return TransportModeGoroutine, nil
+	case TransportModeGoroutine, TransportModeEpoll:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown transport mode: %s", s)
+	}
+}
+
+// WebSocket frame opcode for a continuation of a fragmented message
+const continuationFrame = 0
+
+var (
+	errFrameTooBig  = errors.New("message exceeds read limit")
+	errBadFrame     = errors.New("malformed frame")
+	errUnmaskedData = errors.New("client frame is not masked")
+)
+
+// wsFrame is one WebSocket frame read from a client
+type wsFrame struct {
+	fin     bool
+	op      int
+	payload []byte // Unmasked in place
+}
+
+// parseFrame reads the frame at the start of b. size is 0 when b doesn't
+// hold a whole frame yet.
+func parseFrame(b []byte, limit int) (f wsFrame, size int, err error) {
+	if len(b) < 2 {
+		return f, 0, nil
+	}
+	if b[0]&0x70 != 0 {
+		return f, 0, errBadFrame // Reserved bits; extensions aren't negotiated in epoll mode
+	}
+	if b[1]&0x80 == 0 {
+		return f, 0, errUnmaskedData
+	}
+	f.fin = b[0]&0x80 != 0
+	f.op = int(b[0] & 0x0f)
+
+	length := uint64(b[1] & 0x7f)
+	pos := 2
+	switch length {
+	case 126:
+		if len(b) < 4 {
+			return f, 0, nil
+		}
+		length, pos = uint64(binary.BigEndian.Uint16(b[2:4])), 4
+	case 127:
+		if len(b) < 10 {
+			return f, 0, nil
+		}
+		length, pos = binary.BigEndian.Uint64(b[2:10]), 10
+	}
+	if f.op >= websocket.CloseMessage && (length > 125 || !f.fin) {
+		return f, 0, errBadFrame
+	}
+	if length > uint64(limit) {
+		return f, 0, errFrameTooBig
+	}
+	if len(b) < pos+4+int(length) {
+		return f, 0, nil
+	}
+
+	mask := b[pos : pos+4]
+	f.payload = b[pos+4 : pos+4+int(length)]
+	for i := range f.payload {
+		f.payload[i] ^= mask[i&3]
+	}
+	return f, pos + 4 + int(length), nil
+}
+
+// epollConn is a connection served by the epoll transport
+type epollConn struct {
+	conn    *Connection
+	ws      *websocket.Conn
+	rc      syscall.RawConn
+	token   uint64
+	batched bool
+
+	// Only touched by the poller worker handling the connection's readiness
+	pending    []byte // Start of a frame that hasn't fully arrived
+	message    []byte // Fragments of a message that hasn't finished
+	fragmented bool
+	closing    bool // Sent a message over the limit; the rest is discarded until the close
+
+	writing atomic.Bool // A writer goroutine is draining outChan
+}
+
+// epollTransport serves WebSocket connections from a poller goroutine and a
+// few workers instead of a reader and a writer goroutine per connection. A
+// writer goroutine only runs while a connection has messages queued.
+type epollTransport struct {
+	server *Server
+	poller *netPoller
+	ready  chan uint64
+
+	mu        sync.RWMutex
+	conns     map[uint64]*epollConn
+	nextToken uint64
+}
+
+// epollTransport returns the server's epoll transport, starting it on first use
+func (s *Server) epollTransport() (*epollTransport, error) {
+	s.epollOnce.Do(func() {
+		poller, err := newNetPoller()
+		if err != nil {
+			s.epollErr = err
+			return
+		}
+		t := &epollTransport{
+			server: s,
+			poller: poller,
+			ready:  make(chan uint64, 1024),
+			conns:  make(map[uint64]*epollConn),
+		}
+		for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+			go t.work()
+		}
+		go poller.wait(func(token uint64) { t.ready <- token }, s.done)
+		go t.keepalive()
+		s.epoll = t
+	})
+	return s.epoll, s.epollErr
+}
+
+// newEpollConn prepares conn to be served by the epoll transport. It must run
+// before the connection is registered so sends never see a half-set writer.
+func (s *Server) newEpollConn(conn *Connection, ws *websocket.Conn) (*epollConn, error) {
+	t, err := s.epollTransport()
+	if err != nil {
+		return nil, err
+	}
+	sc, ok := ws.NetConn().(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("connection does not expose a socket (TLS?)")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("raw connection: %w", err)
+	}
+
+	ec := &epollConn{conn: conn, ws: ws, rc: rc, batched: wantsBatches(ws)}
+	conn.wakeWriter = func() { t.wake(ec) }
+	return ec, nil
+}
+
+// add starts polling a registered connection. It stops, and the socket is
+// closed, once the connection's context ends.
+func (t *epollTransport) add(ec *epollConn) error {
+	t.mu.Lock()
+	t.nextToken++
+	ec.token = t.nextToken
+	t.conns[ec.token] = ec
+	t.mu.Unlock()
+
+	var addErr error
+	if err := ec.rc.Control(func(fd uintptr) {
+		addErr = t.poller.add(int(fd), ec.token)
+	}); err != nil {
+		addErr = err
+	}
+	if addErr != nil {
+		t.remove(ec)
+		return fmt.Errorf("epoll add: %w", addErr)
+	}
+
+	context.AfterFunc(ec.conn.Context(), func() { t.remove(ec) })
+	return nil
+}
+
+// remove stops polling a connection and closes it
+func (t *epollTransport) remove(ec *epollConn) {
+	t.mu.Lock()
+	delete(t.conns, ec.token)
+	t.mu.Unlock()
+
+	// Control holds the fd open, so this never touches a reused descriptor
+	ec.rc.Control(func(fd uintptr) {
+		t.poller.remove(int(fd))
+	})
+	t.server.removeConnection(ec.conn.ID)
+	ec.ws.Close()
+}
+
+// work reads from connections as they become readable
+func (t *epollTransport) work() {
+	buf := make([]byte, 32*1024)
+	for {
+		select {
+		case token := <-t.ready:
+			t.mu.RLock()
+			ec := t.conns[token]
+			t.mu.RUnlock()
+			if ec == nil {
+				continue
+			}
+			if !t.read(ec, buf) {
+				t.server.removeConnection(ec.conn.ID)
+				continue
+			}
+
+			var rearmErr error
+			if err := ec.rc.Control(func(fd uintptr) {
+				rearmErr = t.poller.rearm(int(fd), token)
+			}); err != nil || rearmErr != nil {
+				t.server.removeConnection(ec.conn.ID)
+			}
+		case <-t.server.done:
+			return
+		}
+	}
+}
+
+// read handles whatever the client has sent so far. It returns false once
+// the connection should be closed.
+func (t *epollTransport) read(ec *epollConn, buf []byte) bool {
+	var n int
+	var readErr error
+	if err := ec.rc.Read(func(fd uintptr) bool {
+		n, readErr = readFD(fd, buf)
+		return true // Never wait; the poller says when there's more
+	}); err != nil {
+		return false
+	}
+	if readErr == syscall.EAGAIN || readErr == syscall.EINTR {
+		return true
+	}
+	if readErr != nil || n == 0 {
+		return false
+	}
+
+	if ec.closing {
+		return true
+	}
+
+	data := buf[:n]
+	if len(ec.pending) > 0 {
+		data = append(ec.pending, data...)
+	}
+	for {
+		frame, size, err := parseFrame(data, int(t.server.config.MaxMessageSize))
+		if err == errFrameTooBig {
+			t.rejectTooLarge(ec)
+			return true
+		}
+		if err != nil {
+			t.closeWithError(ec, err)
+			return false
+		}
+		if size == 0 {
+			break
+		}
+		if !t.handleFrame(ec, frame) {
+			return false
+		}
+		if ec.closing {
+			return true
+		}
+		data = data[size:]
+	}
+
+	if len(data) > 0 {
+		ec.pending = append([]byte(nil), data...)
+	} else {
+		ec.pending = nil
+	}
+	return true
+}
+
+// handleFrame acts on one frame. It returns false once the connection should be closed.
+func (t *epollTransport) handleFrame(ec *epollConn, f wsFrame) bool {
+	switch f.op {
+	case websocket.TextMessage, websocket.BinaryMessage:
+		if ec.fragmented {
+			t.closeWithError(ec, errBadFrame)
+			return false
+		}
+		if !f.fin {
+			ec.fragmented = true
+			ec.message = append([]byte(nil), f.payload...)
+			return true
+		}
+		return t.deliver(ec, f.payload)
+	case continuationFrame:
+		if !ec.fragmented {
+			t.closeWithError(ec, errBadFrame)
+			return false
+		}
+		if int64(len(ec.message)+len(f.payload)) > t.server.config.MaxMessageSize {
+			t.rejectTooLarge(ec)
+			return true
+		}
+		ec.message = append(ec.message, f.payload...)
+		if !f.fin {
+			return true
+		}
+		data := ec.message
+		ec.fragmented, ec.message = false, nil
+		return t.deliver(ec, data)
+	case websocket.PingMessage:
+		ec.conn.Touch()
+		ec.ws.WriteControl(websocket.PongMessage, f.payload, time.Now().Add(10*time.Second))
+		return true
+	case websocket.PongMessage:
+		ec.conn.ping.ponged(time.Now())
+		ec.conn.Touch()
+		return true
+	case websocket.CloseMessage:
+		closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		ec.ws.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		return false
+	default:
+		t.closeWithError(ec, errBadFrame)
+		return false
+	}
+}
+
+// deliver decodes a complete message and queues it for processing
+func (t *epollTransport) deliver(ec *epollConn, data []byte) bool {
+	msg, err := t.server.decodeIncoming(ec.conn, data)
+	if err != nil {
+		return false
+	}
+	if err := t.server.acceptMessage(ec.conn, msg); err != nil {
+		log.Printf("%v", err)
+	}
+	return true
+}
+
+// rejectTooLarge tells the client its message was over the size limit and
+// discards whatever it sends until the writer has closed the connection
+func (t *epollTransport) rejectTooLarge(ec *epollConn) {
+	ec.closing = true
+	ec.pending, ec.message, ec.fragmented = nil, nil, false
+	t.server.rejectTooLarge(ec.conn)
+}
+
+// closeWithError tells the client why its connection is being closed
+func (t *epollTransport) closeWithError(ec *epollConn, err error) {
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseProtocolError, err.Error())
+	ec.ws.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+}
+
+// wake starts a writer for a connection that has messages queued, unless one is running
+func (t *epollTransport) wake(ec *epollConn) {
+	if ec.writing.CompareAndSwap(false, true) {
+		go t.flush(ec)
+	}
+}
+
+// flush writes queued messages until the outbound queue is empty, then exits
+func (t *epollTransport) flush(ec *epollConn) {
+	for {
+		for empty := false; !empty; {
+			select {
+			case msg := <-ec.conn.outChan:
+				if !t.server.writeQueued(ec.conn, ec.ws, msg, ec.batched) {
+					// Leave writing set so no writer starts on a dead connection
+					t.server.removeConnection(ec.conn.ID)
+					return
+				}
+			default:
+				empty = true
+			}
+		}
+
+		// A message queued after the last check but before writing was cleared
+		// saw a writer running and didn't start one, so look again
+		ec.writing.Store(false)
+		if len(ec.conn.outChan) == 0 || !ec.writing.CompareAndSwap(false, true) {
+			return
+		}
+	}
+}
+
+// keepalive pings polled connections that are due and closes those that
+// stopped answering. With adaptive pings it polls at the shortest interval.
+func (t *epollTransport) keepalive() {
+	s := t.server
+	tick := s.config.PingInterval
+	if s.config.AdaptivePing.Enabled {
+		tick = s.config.AdaptivePing.MinInterval
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		t.mu.RLock()
+		conns := make([]*epollConn, 0, len(t.conns))
+		for _, ec := range t.conns {
+			conns = append(conns, ec)
+		}
+		t.mu.RUnlock()
+
+		now := time.Now()
+		for _, ec := range conns {
+			if now.Sub(ec.conn.LastSeen()) > ec.conn.ping.current()+s.pongTimeout() {
+				s.removeConnection(ec.conn.ID)
+				continue
+			}
+			if !ec.conn.ping.due(now, tick/2) {
+				continue
+			}
+			s.pingSent(ec.conn)
+			if err := ec.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				s.removeConnection(ec.conn.ID)
+			}
+		}
+	}
+}
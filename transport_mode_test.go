@@ -0,0 +1,82 @@
+package wssocket
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// clientFrame encodes a frame as a client sends it, masked unless told otherwise
+func clientFrame(fin bool, op int, payload []byte, masked bool) []byte {
+	b0 := byte(op)
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0, 0}
+	switch n := len(payload); {
+	case n < 126:
+		frame[1] = byte(n)
+	case n <= 0xffff:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if !masked {
+		return append(frame, payload...)
+	}
+	frame[1] |= 0x80
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, c := range payload {
+		frame = append(frame, c^mask[i&3])
+	}
+	return frame
+}
+
+func TestParseFrame(t *testing.T) {
+	hello := clientFrame(true, websocket.TextMessage, []byte("hello"), true)
+	long := clientFrame(true, websocket.BinaryMessage, make([]byte, 300), true)
+	huge := append([]byte{0x82, 0xff}, binary.BigEndian.AppendUint64(nil, 1<<63)...)
+
+	tests := []struct {
+		name        string
+		data        []byte
+		limit       int
+		wantSize    int
+		wantErr     error
+		wantPayload string
+	}{
+		{"whole frame", hello, 100, len(hello), nil, "hello"},
+		{"frame followed by more", append(hello, 0x81), 100, len(hello), nil, "hello"},
+		{"one byte", hello[:1], 100, 0, nil, ""},
+		{"header without mask key", hello[:4], 100, 0, nil, ""},
+		{"partial payload", hello[:len(hello)-1], 100, 0, nil, ""},
+		{"partial 16-bit length", long[:3], 1000, 0, nil, ""},
+		{"16-bit length", long, 1000, len(long), nil, string(make([]byte, 300))},
+		{"partial 64-bit length", huge[:9], 1000, 0, nil, ""},
+		{"16-bit length over the limit", long[:4], 100, 0, errFrameTooBig, ""},
+		{"64-bit length over the limit", huge, 1000, 0, errFrameTooBig, ""},
+		{"unmasked", clientFrame(true, websocket.TextMessage, []byte("hi"), false), 100, 0, errUnmaskedData, ""},
+		{"reserved bits", append([]byte{0xc1}, hello[1:]...), 100, 0, errBadFrame, ""},
+		{"long control frame", clientFrame(true, websocket.PingMessage, make([]byte, 126), true), 1000, 0, errBadFrame, ""},
+		{"fragmented control frame", clientFrame(false, websocket.PingMessage, nil, true), 100, 0, errBadFrame, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append([]byte(nil), tt.data...)
+			frame, size, err := parseFrame(data, tt.limit)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if size != tt.wantSize {
+				t.Fatalf("size = %d, want %d", size, tt.wantSize)
+			}
+			if size > 0 && string(frame.payload) != tt.wantPayload {
+				t.Fatalf("payload = %q, want %q", frame.payload, tt.wantPayload)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package wssocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidPayload is returned when a payload doesn't decode into a typed
+// handler's payload type or fails its validation
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// Validator is implemented by payload types that check themselves once decoded
+type Validator interface {
+	Validate() error
+}
+
+// RegisterTypedHandler registers a handler that receives the message payload
+// decoded into T, e.g. a struct with json tags. If T or *T implements
+// Validator, Validate runs before the handler. A payload that fails either
+// step is refused with an invalid_payload error and the handler isn't called.
+func RegisterTypedHandler[T any](s *Server, msgType MessageType, fn func(*Connection, T) error) {
+	s.RegisterHandler(msgType, func(conn *Connection, msg *Message) error {
+		payload, err := decodePayload[T](msg.Payload)
+		if err != nil {
+			return s.rejectPayload(conn, msg, err)
+		}
+		return fn(conn, payload)
+	})
+}
+
+// decodePayload converts a message payload into T and validates it
+func decodePayload[T any](raw map[string]interface{}) (T, error) {
+	var payload T
+	if raw == nil {
+		raw = map[string]interface{}{}
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return payload, err
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return payload, err
+	}
+	if v, ok := any(&payload).(Validator); ok {
+		return payload, v.Validate()
+	}
+	if v, ok := any(payload).(Validator); ok {
+		return payload, v.Validate()
+	}
+	return payload, nil
+}
+
+// rejectPayload tells the sender their payload was refused
+func (s *Server) rejectPayload(conn *Connection, msg *Message, err error) error {
+	s.SendToConnection(conn.ID, &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeError,
+		Sender:    "system",
+		Recipient: conn.UserID,
+		Timestamp: s.now().Unix(),
+		Payload: map[string]interface{}{
+			"code":       "invalid_payload",
+			"error":      err.Error(),
+			"message_id": msg.ID,
+		},
+	})
+	return fmt.Errorf("%w for %s: %v", ErrInvalidPayload, msg.Type, err)
+}
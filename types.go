@@ -1,7 +1,12 @@
-package main
+package wssocket
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // MessageType defines the type of message being sent
@@ -22,14 +27,106 @@ const (
 	MessageTypeCustomEvent MessageType = "event:custom"
 
 	// System messages
-	MessageTypeUserJoined MessageType = "system:user_joined"
-	MessageTypeUserLeft   MessageType = "system:user_left"
-	MessageTypeTyping     MessageType = "system:typing"
-	MessageTypePresence   MessageType = "system:presence"
+	MessageTypeUserJoined    MessageType = "system:user_joined"
+	MessageTypeUserLeft      MessageType = "system:user_left"
+	MessageTypeTyping        MessageType = "system:typing"
+	MessageTypePresence      MessageType = "system:presence"
 	MessageTypeMessageDelete MessageType = "message:delete"
+	MessageTypeForward       MessageType = "message:forward"
+	MessageTypeScheduled     MessageType = "message:scheduled" // Confirms a message with a future deliver_at is held
+
+	// Marks a conversation read up to a timestamp
+	MessageTypeConversationRead MessageType = "conversation:read"
+
+	// Moves the sender's read marker in a channel
+	MessageTypeChannelRead MessageType = "channel:read"
+
+	// Mutes a channel's notifications for the sender
+	MessageTypeChannelMute MessageType = "channel:mute"
+
+	// Tells a user's other devices about a change made on one of them
+	MessageTypeSync MessageType = "sync"
+
+	// Tells a user someone mentioned them with @username
+	MessageTypeMention MessageType = "mention"
+
+	// Sets or clears a server-side filter on the messages the sender receives
+	MessageTypeSubscribeFilter MessageType = "subscribe:filter"
+
+	// Replays the channel messages missed since the sequence numbers a
+	// client last saw, then continues live
+	MessageTypeResume MessageType = "resume"
+
+	// Application-level heartbeat: the server answers a ping with a pong
+	// echoing the client's timestamp next to its own
+	MessageTypePing MessageType = "ping"
+	MessageTypePong MessageType = "pong"
+
+	// Sent to every client when the server starts draining: reconnect, to
+	// the url in the payload if one is given
+	MessageTypeReconnectTo MessageType = "reconnect_to"
+
+	// Profile lookup and changes to the sender's own profile
+	MessageTypeUserGet    MessageType = "user:get"
+	MessageTypeUserUpdate MessageType = "user:update"
+
+	// Group conversations: create one, add or remove a participant
+	MessageTypeConversationCreate MessageType = "conversation:create"
+	MessageTypeConversationAdd    MessageType = "conversation:add"
+	MessageTypeConversationRemove MessageType = "conversation:remove"
+
+	// Channel moderation; only moderators and owners may send these
+	MessageTypeChannelRole  MessageType = "channel:role"
+	MessageTypeChannelKick  MessageType = "channel:kick"
+	MessageTypeMessagePin   MessageType = "message:pin"
+	MessageTypeMessageUnpin MessageType = "message:unpin"
+
+	// channel:update changes a channel's topic, description or settings;
+	// channel:settings is sent to the channel when they change
+	MessageTypeChannelUpdate   MessageType = "channel:update"
+	MessageTypeChannelSettings MessageType = "channel:settings"
+
+	// Sent to a user refused by a full channel, and again when a place opens
+	MessageTypeChannelWaitlist MessageType = "channel:waitlist"
+
+	// Subscription confirmations sent whenever a connection joins or leaves a channel
+	MessageTypeChannelSubscribed   MessageType = "channel:subscribed"
+	MessageTypeChannelUnsubscribed MessageType = "channel:unsubscribed"
+
+	// Ephemeral channels: channel:create declares one and is echoed back
+	// with its expiry; channel:closed is sent when its TTL elapses
+	MessageTypeChannelCreate MessageType = "channel:create"
+	MessageTypeChannelClosed MessageType = "channel:closed"
+
+	// Invitations to private channels. A moderator sends channel:invite; the
+	// invitee's answer is passed on to the inviter.
+	MessageTypeChannelInvite MessageType = "channel:invite"
+	MessageTypeInviteAccept  MessageType = "channel:invite_accept"
+	MessageTypeInviteDecline MessageType = "channel:invite_decline"
+
+	// Progress of a data export the user asked for, sent as it runs and
+	// when it is ready to download or failed
+	MessageTypeExportProgress MessageType = "export:progress"
+
+	// Attachment types
+	MessageTypeAttachmentUploaded MessageType = "attachment:uploaded"
 
 	// Acknowledgment
 	MessageTypeAck MessageType = "ack"
+
+	// History paging over the socket
+	MessageTypeHistoryRequest  MessageType = "history:request"
+	MessageTypeHistoryResponse MessageType = "history:response"
+
+	// Full-text search over the socket
+	MessageTypeSearch        MessageType = "search"
+	MessageTypeSearchResults MessageType = "search:results"
+
+	// Moderation actions, published to a channel's moderators
+	MessageTypeModerationAction MessageType = "moderation:action"
+
+	// Errors reported back to the sender
+	MessageTypeError MessageType = "error"
 )
 
 // Message represents a websocket message structure
@@ -42,25 +139,151 @@ type Message struct {
 	Payload   map[string]interface{} `json:"payload"`
 	Timestamp int64                  `json:"timestamp"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	ctx       context.Context
+	frame     *preparedFrame    // Shared encoded frame when fanned out to many connections
+	span      trace.SpanContext // Span the message was last handled in; parents its write spans
+
+	closeFrame []byte // Close frame the writer sends right after this message, ending the connection
 }
 
-// Connection represents a client websocket connection
+// Context returns the context for the handler processing the message. It is
+// derived from the connection context and cancelled when the handler's
+// execution timeout expires.
+func (m *Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// Supported connection transports
+const (
+	TransportWebSocket = "websocket"
+	TransportSSE       = "sse"
+)
+
+// Connection represents a client connection over any supported transport
 type Connection struct {
 	ID        string
 	UserID    string
-	Channels  map[string]bool
-	ExtraData map[string]interface{}
+	Transport string
+	Client    ClientInfo // Set once at connect
 	CreatedAt time.Time
-	LastSeen  time.Time
 	outChan   chan *Message
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	// Set in epoll mode, where no writer goroutine waits on outChan
+	wakeWriter func()
+
+	protocol *ProtocolTranslation // Legacy envelope translation; nil for the current protocol
+	binary   bool                 // Negotiated BinarySubprotocol; frames are protobuf, not JSON
+
+	// Set for messages from the publish API, which channel posting rules don't apply to
+	trusted bool
+
+	// Mutated by the read goroutine while others read them; use the accessors
+	mu        sync.RWMutex
+	channels  map[string]bool
+	extraData map[string]interface{} // See Set and Get
+	tags      map[string]string      // See SetTag
+	filters   map[string]*Filter     // Channel or pattern -> filter; "" for every message
+	overflow  *overflowQueue         // Created once the connection falls behind
+	lastSeen  atomic.Int64           // unix nanoseconds
+	dropped   atomic.Uint64
+	bucket    tokenBucket // Inbound rate limit allowance
+	ping      pingState   // Keepalive interval and round trip time
+
+	latency latencyStats // Round trip time and clock skew from application pings
+}
+
+// LastSeen returns when the connection last showed activity
+func (c *Connection) LastSeen() time.Time {
+	return time.Unix(0, c.lastSeen.Load())
+}
+
+// notifyWriter makes sure something will write what was just queued on outChan
+func (c *Connection) notifyWriter() {
+	if c.wakeWriter != nil {
+		c.wakeWriter()
+	}
+}
+
+// Touch records activity on the connection
+func (c *Connection) Touch() {
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+// Channels returns a snapshot of the channels the connection is subscribed to
+func (c *Connection) Channels() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	channels := make([]string, 0, len(c.channels))
+	for ch := range c.channels {
+		channels = append(channels, ch)
+	}
+	return channels
+}
+
+// InChannel reports whether the connection is subscribed to a channel
+func (c *Connection) InChannel(channel string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.channels[channel]
+}
+
+// Follows reports whether the connection receives a channel's broadcasts,
+// directly or through a pattern subscription
+func (c *Connection) Follows(channel string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.channels[channel] {
+		return true
+	}
+	for subscribed := range c.channels {
+		if IsChannelPattern(subscribed) && MatchChannel(subscribed, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// addChannel records a channel subscription
+func (c *Connection) addChannel(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.channels[channel] = true
+}
+
+// removeChannel drops a channel subscription
+func (c *Connection) removeChannel(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.channels, channel)
+}
+
+// Context returns a context that is cancelled when the connection closes.
+// Handlers and hooks should pass it to long-running work (DB calls, external
+// APIs) so that work stops once the client goes away.
+func (c *Connection) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
 }
 
 // ConnectionInfo holds metadata about active connections
 type ConnectionInfo struct {
-	ID       string
-	UserID   string
-	Status   string
-	Channels []string
+	ID        string
+	UserID    string
+	Status    string
+	Transport string
+	Channels  []string
+	Tags      map[string]string
+	Dropped   uint64 // Messages dropped because the client fell behind
+
+	RTT       time.Duration // Smoothed round trip time; 0 until measured
+	ClockSkew time.Duration // Client clock minus server clock; 0 until the client pings
 }
 
 // Event represents a system or custom event
@@ -82,9 +305,47 @@ type Handler func(*Connection, *Message) error
 
 // ServerConfig holds configuration for the websocket server
 type ServerConfig struct {
-	ReadBufferSize  int
-	WriteBufferSize int
-	MaxConnections  int
-	PingInterval    time.Duration
-	PongWait        time.Duration
+	ReadBufferSize    int
+	WriteBufferSize   int
+	MaxConnections    int
+	PingInterval      time.Duration
+	PongWait          time.Duration
+	EnableCompression bool          // Negotiate permessage-deflate with clients that support it
+	MaxMessageSize    int64         // Largest inbound message in bytes; 0 uses DefaultMaxMessageSize
+	HandlerTimeout    time.Duration // Default max handler execution time; 0 means no limit
+	ChannelPolicy     ChannelPolicy // Default GC policy for empty channels; zero destroys them immediately
+
+	AdaptivePing AdaptivePingConfig // Per-connection ping intervals for WebSocket connections; off by default
+
+	ChannelReplayBuffer int        // Recent messages kept per channel for replay; 0 uses the default, negative disables
+	AckStages           []AckStage // Stages acknowledged to the sender by default; none when empty
+	MessageWorkers      int        // Goroutines processing inbound messages; 0 uses GOMAXPROCS
+
+	MessagePriorities map[MessageType]MessagePriority // Overrides DefaultMessagePriorities; unlisted types are normal
+
+	SlowConsumerPolicy SlowConsumerPolicy // What to do when a connection's outbound queue is full; drop by default
+	SpoolDir           string             // Where the spool policy buffers; defaults to the OS temp dir
+	SpoolLimit         int                // Messages a connection may have buffered before it is disconnected; 0 uses the default
+
+	BatchWindow time.Duration // How long to gather messages into one frame for clients that negotiate batching
+	BatchSize   int           // Most messages per batched frame
+
+	TransportMode TransportMode // How WebSocket connections are served; goroutine by default
+	NodeID        string        // Names this node in shared stores; defaults to the hostname
+	Clock         Clock         // Time source for timestamps and channel timers; the system clock by default
+
+	Health HealthConfig // Limits enforced by /healthz and /readyz
+
+	RateLimit      RateLimit // Per-connection inbound message limit; none by default
+	BotRateLimit   RateLimit // Per-bot inbound message limit across all its connections; none by default
+	AllowedOrigins []string  // Browser origins allowed to connect; empty allows all
+
+	DrainRate float64 // Connections closed per second while draining; 0 uses DefaultDrainRate
+
+	MaxConnectionsPerIP int       // Open WebSocket connections allowed per client IP; 0 means no limit
+	IPConnectRate       RateLimit // New WebSocket connections per client IP; none by default
+	TrustForwardedFor   bool      // Take the client IP from X-Forwarded-For; only safe behind a proxy that sets it
+
+	Challenge ChallengeConfig // Proof of work or a CAPTCHA for connects from busy IPs; off by default
+	Reconnect ReconnectConfig // Retry hints for refused clients and reconnect storm detection
 }
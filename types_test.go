@@ -0,0 +1,114 @@
+package wssocket
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestConnectionAccessorsConcurrent runs every locked Connection accessor
+// from several goroutines at once; run it with -race
+func TestConnectionAccessorsConcurrent(t *testing.T) {
+	conn := newConnection("conn_race", "alice", "websocket")
+	filter, err := ParseFilter("sender == 'ops-bot'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{Type: MessageTypeChatGroup, Sender: "bob", Channel: "ch0"}
+
+	const workers, rounds = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				channel := fmt.Sprintf("ch%d", i%4)
+				conn.addChannel(channel)
+				conn.addChannel("orders.*")
+				conn.Set("count", i)
+				conn.Set(fmt.Sprintf("worker%d", w), "x")
+				conn.SetFilter(channel, filter)
+				conn.SetTag("region", fmt.Sprintf("r%d", w))
+				conn.Touch()
+				if i%3 == 0 {
+					conn.removeChannel(channel)
+					conn.Delete("count")
+					conn.SetFilter(channel, nil)
+					conn.RemoveTag("region")
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				conn.Channels()
+				conn.InChannel("ch1")
+				conn.Follows("orders.eu")
+				conn.Get("count")
+				conn.GetString("worker0")
+				conn.GetInt("count")
+				conn.Filters()
+				conn.wants(msg, "ch0")
+				conn.Tags()
+				conn.Tag("region")
+				conn.HasTag("region=r1")
+				conn.LastSeen()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !conn.InChannel("orders.*") || !conn.Follows("orders.eu") {
+		t.Fatalf("pattern subscription lost: %v", conn.Channels())
+	}
+	for w := 0; w < workers; w++ {
+		if v, ok := conn.GetString(fmt.Sprintf("worker%d", w)); !ok || v != "x" {
+			t.Fatalf("worker%d value lost", w)
+		}
+	}
+}
+
+// TestServerConnectionsConcurrent reads connection snapshots while the
+// connections' channels and data change
+func TestServerConnectionsConcurrent(t *testing.T) {
+	server := NewServer(ServerConfig{MaxConnections: 100})
+	t.Cleanup(server.Stop)
+	conns := make([]*Connection, 4)
+	for i := range conns {
+		conns[i] = newConnection(fmt.Sprintf("conn_%d", i), fmt.Sprintf("user%d", i), "websocket")
+		if err := server.registerConnection(conns[i], nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *Connection) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				channel := fmt.Sprintf("room%d", i%3)
+				server.SubscribeToChannel(conn.ID, channel)
+				conn.SetTag("i", fmt.Sprint(i))
+				server.UnsubscribeFromChannel(conn.ID, channel)
+			}
+		}(conn)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			for _, info := range server.GetConnections() {
+				_ = info.Channels
+				_ = info.Tags
+			}
+			server.GetActiveUsersInChannel("room0")
+		}
+	}()
+	wg.Wait()
+
+	if got := len(server.GetConnections()); got != len(conns) {
+		t.Fatalf("connections = %d, want %d", got, len(conns))
+	}
+}
@@ -0,0 +1,315 @@
+package wssocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadConfig holds limits for the attachment upload endpoint
+type UploadConfig struct {
+	MaxUploadSize int64    // Maximum accepted file size in bytes
+	AllowedTypes  []string // Allowed MIME types; entries like "image/*" match a whole family
+}
+
+// Attachment describes an uploaded file that messages can reference
+type Attachment struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"owner"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AttachmentRegistry tracks uploaded attachments and their blob storage.
+// Each attachment's metadata is kept next to its blob, as <id>.json, so it
+// survives restarts and is shared by every node using the same blob store.
+// The map caches what has been loaded.
+type AttachmentRegistry struct {
+	mu          sync.RWMutex
+	attachments map[string]*Attachment
+	blobs       BlobStore
+	config      UploadConfig
+}
+
+// Global attachment registry (nil when uploads are disabled)
+var globalAttachments *AttachmentRegistry
+
+// NewAttachmentRegistry creates a registry backed by the given blob store
+func NewAttachmentRegistry(blobs BlobStore, config UploadConfig) *AttachmentRegistry {
+	if config.MaxUploadSize == 0 {
+		config.MaxUploadSize = 10 << 20
+	}
+	return &AttachmentRegistry{
+		attachments: make(map[string]*Attachment),
+		blobs:       blobs,
+		config:      config,
+	}
+}
+
+// attachmentMetaKey is the blob key of an attachment's metadata
+func attachmentMetaKey(id string) string {
+	return id + ".json"
+}
+
+// Get returns an attachment by ID, loading its metadata from the blob store
+// if it isn't cached
+func (a *AttachmentRegistry) Get(id string) (*Attachment, bool) {
+	a.mu.RLock()
+	att, exists := a.attachments[id]
+	a.mu.RUnlock()
+	if exists {
+		return att, true
+	}
+	if !strings.HasPrefix(id, "att_") {
+		return nil, false
+	}
+
+	att, err := a.loadMeta(id)
+	if err != nil {
+		if !errors.Is(err, ErrBlobNotFound) {
+			log.Printf("Error loading attachment %s: %v", id, err)
+		}
+		return nil, false
+	}
+	a.mu.Lock()
+	a.attachments[id] = att
+	a.mu.Unlock()
+	return att, true
+}
+
+// loadMeta reads an attachment's metadata from the blob store
+func (a *AttachmentRegistry) loadMeta(id string) (*Attachment, error) {
+	body, err := a.blobs.Get(attachmentMetaKey(id))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var att Attachment
+	if err := json.NewDecoder(body).Decode(&att); err != nil {
+		return nil, fmt.Errorf("decode metadata: %w", err)
+	}
+	if att.ID != id {
+		return nil, fmt.Errorf("metadata is for %s", att.ID)
+	}
+	return &att, nil
+}
+
+// typeAllowed reports whether a MIME type passes the allow-list
+func (a *AttachmentRegistry) typeAllowed(contentType string) bool {
+	if len(a.config.AllowedTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range a.config.AllowedTypes {
+		if allowed == mediaType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Store validates and saves an uploaded file
+func (a *AttachmentRegistry) Store(owner, filename, contentType string, size int64, r io.Reader) (*Attachment, error) {
+	if size > a.config.MaxUploadSize {
+		return nil, fmt.Errorf("file exceeds maximum size of %d bytes", a.config.MaxUploadSize)
+	}
+	if !a.typeAllowed(contentType) {
+		return nil, fmt.Errorf("content type not allowed: %s", contentType)
+	}
+
+	att := &Attachment{
+		ID:          "att_" + uuid.New().String(),
+		Owner:       owner,
+		Filename:    filepath.Base(filename),
+		ContentType: contentType,
+		Size:        size,
+		CreatedAt:   time.Now(),
+	}
+
+	if err := a.blobs.Put(att.ID, io.LimitReader(r, a.config.MaxUploadSize), size, contentType); err != nil {
+		return nil, fmt.Errorf("store blob: %w", err)
+	}
+	meta, err := json.Marshal(att)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.blobs.Put(attachmentMetaKey(att.ID), bytes.NewReader(meta), int64(len(meta)), "application/json"); err != nil {
+		a.blobs.Delete(att.ID)
+		return nil, fmt.Errorf("store metadata: %w", err)
+	}
+
+	a.mu.Lock()
+	a.attachments[att.ID] = att
+	a.mu.Unlock()
+
+	return att, nil
+}
+
+// attachmentIDs extracts attachment references from a message payload.
+// References may be plain ID strings or objects with an "id" field.
+func attachmentIDs(msg *Message) ([]string, error) {
+	raw, exists := msg.Payload["attachments"]
+	if !exists || raw == nil {
+		return nil, nil
+	}
+
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("attachments must be a list")
+	}
+
+	ids := make([]string, 0, len(list))
+	for _, item := range list {
+		switch v := item.(type) {
+		case string:
+			ids = append(ids, v)
+		case map[string]interface{}:
+			id, ok := v["id"].(string)
+			if !ok || id == "" {
+				return nil, fmt.Errorf("attachment reference is missing an id")
+			}
+			ids = append(ids, id)
+		default:
+			return nil, fmt.Errorf("invalid attachment reference")
+		}
+	}
+	return ids, nil
+}
+
+// ValidateAttachments checks that every attachment referenced by a message
+// exists and was uploaded by the sender
+func ValidateAttachments(msg *Message) error {
+	ids, err := attachmentIDs(msg)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	if globalAttachments == nil {
+		return fmt.Errorf("attachments are not enabled")
+	}
+
+	for _, id := range ids {
+		att, exists := globalAttachments.Get(id)
+		if !exists {
+			return fmt.Errorf("unknown attachment: %s", id)
+		}
+		if att.Owner != msg.Sender {
+			return fmt.Errorf("attachment %s does not belong to %s", id, msg.Sender)
+		}
+	}
+	return nil
+}
+
+// setupUploadRoutes registers the attachment upload and download endpoints
+func setupUploadRoutes(mux *http.ServeMux, server *Server) {
+	// Upload an attachment
+	mux.HandleFunc("/api/uploads", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if globalAttachments == nil {
+			http.Error(w, "Uploads not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		limit := globalAttachments.config.MaxUploadSize
+		r.Body = http.MaxBytesReader(w, r.Body, limit+1<<20)
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, "Invalid or oversized upload", http.StatusRequestEntityTooLarge)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		// The uploader owns the attachment, so it must be who they claim
+		userID, ok := requestUser(w, r, r.FormValue("user_id"))
+		if !ok {
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		contentType := header.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		att, err := globalAttachments.Store(userID, header.Filename, contentType, header.Size, file)
+		if err != nil {
+			log.Printf("Upload rejected for %s: %v", userID, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Let the uploader's connected clients know the attachment is ready
+		server.sendToUser(userID, &Message{
+			ID:        generateMessageID(),
+			Type:      MessageTypeAttachmentUploaded,
+			Sender:    "system",
+			Recipient: userID,
+			Timestamp: time.Now().Unix(),
+			Payload: map[string]interface{}{
+				"attachment": att,
+			},
+		})
+
+		writeJSON(w, http.StatusCreated, att)
+	})
+
+	// Download an attachment
+	mux.HandleFunc("/api/uploads/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if globalAttachments == nil {
+			http.Error(w, "Uploads not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		id := r.URL.Path[len("/api/uploads/"):]
+		att, exists := globalAttachments.Get(id)
+		if !exists {
+			http.Error(w, "attachment not found", http.StatusNotFound)
+			return
+		}
+
+		body, err := globalAttachments.blobs.Get(att.ID)
+		if err != nil {
+			log.Printf("Error reading attachment %s: %v", att.ID, err)
+			http.Error(w, "Failed to read attachment", http.StatusInternalServerError)
+			return
+		}
+		defer body.Close()
+
+		w.Header().Set("Content-Type", att.ContentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": att.Filename}))
+		io.Copy(w, body)
+	})
+}
@@ -0,0 +1,34 @@
+package wssocket
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAttachmentMetadataSurvivesRestart(t *testing.T) {
+	blobs, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := NewAttachmentRegistry(blobs, UploadConfig{})
+	att, err := before.Store("alice", "notes.txt", "text/plain", 5, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	// A fresh registry over the same blobs, as after a restart or on another node
+	after := NewAttachmentRegistry(blobs, UploadConfig{})
+	got, ok := after.Get(att.ID)
+	if !ok {
+		t.Fatalf("attachment %s not found after restart", att.ID)
+	}
+	if got.Owner != "alice" || got.Filename != "notes.txt" || got.ContentType != "text/plain" || got.Size != 5 {
+		t.Fatalf("metadata = %+v, want the stored attachment %+v", got, att)
+	}
+
+	for _, id := range []string{"att_missing", att.ID + ".json", "../" + att.ID} {
+		if _, ok := after.Get(id); ok {
+			t.Errorf("Get(%q) found an attachment", id)
+		}
+	}
+}
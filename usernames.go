@@ -0,0 +1,327 @@
+package wssocket
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Username claim errors
+var (
+	ErrUsernameTaken = errors.New("username is already taken")
+	ErrUserClaimed   = errors.New("user is already registered")
+	ErrUnauthorized  = errors.New("user_id does not belong to the auth token")
+)
+
+// usernamePattern is what a username looks like once lowercased
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{2,31}$`)
+
+// maxUsernameSuggestions is how many free alternatives a collision offers
+const maxUsernameSuggestions = 3
+
+// NormalizeUsername lowercases a username and checks it is 3 to 32 letters,
+// digits, dots, dashes or underscores, starting with a letter or digit
+func NormalizeUsername(username string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(username))
+	if !usernamePattern.MatchString(normalized) {
+		return "", fmt.Errorf("invalid username %q: use 3 to 32 letters, digits, '.', '-' or '_', starting with a letter or digit", username)
+	}
+	return normalized, nil
+}
+
+// hashUserToken is how auth tokens are stored; the token itself never is
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// userTokenFromRequest reads an auth token from ?token= or a bearer header
+func userTokenFromRequest(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// RegisterUser claims a username for a user and returns the auth token that
+// proves ownership from now on. An empty userID registers a new user. The
+// token is only returned here.
+func (s *Server) RegisterUser(userID, username string) (*UserProfile, string, error) {
+	if globalUserStore == nil {
+		return nil, "", fmt.Errorf("user store not available")
+	}
+	normalized, err := NormalizeUsername(username)
+	if err != nil {
+		return nil, "", err
+	}
+	if userID == "" {
+		userID = "user_" + uuid.New().String()
+	}
+	if s.IsBot(userID) || userID == ErasedUserID {
+		// A bot authenticates with its API key; a user token must not stand in for it
+		return nil, "", ErrUserClaimed
+	}
+
+	token := newResumeToken()
+	if err := globalUserStore.ClaimUsername(userID, normalized, hashUserToken(token)); err != nil {
+		return nil, "", err
+	}
+	p, err := s.UserProfile(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	log.Printf("User %s registered as %s", userID, normalized)
+	return p, token, nil
+}
+
+// usernameSuggestions returns free variants of a taken username
+func usernameSuggestions(username string) []string {
+	suggestions := make([]string, 0, maxUsernameSuggestions)
+	base := username
+	if len(base) > 29 {
+		base = base[:29]
+	}
+	for i := 1; i < 100 && len(suggestions) < maxUsernameSuggestions; i++ {
+		candidate := base + strconv.Itoa(i)
+		owner, err := globalUserStore.UserIDForUsername(candidate)
+		if err != nil {
+			log.Printf("Error checking username %s: %v", candidate, err)
+			break
+		}
+		if owner == "" {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	return suggestions
+}
+
+// authenticateUser decides who a connect request is for. A presented token
+// names its user, and any user_id sent with it must match. Without a token,
+// registered user IDs are refused and others connect anonymously as before.
+func authenticateUser(userID, token string) (string, error) {
+	if globalUserStore == nil {
+		return userID, nil
+	}
+	if token != "" {
+		owner, err := globalUserStore.UserIDForTokenHash(hashUserToken(token))
+		if err != nil {
+			return "", fmt.Errorf("look up token: %w", err)
+		}
+		if owner == "" || (userID != "" && userID != owner) {
+			return "", ErrUnauthorized
+		}
+		return owner, nil
+	}
+	if userID == "" {
+		return "", nil
+	}
+	if globalServer != nil && globalServer.IsBot(userID) {
+		return "", fmt.Errorf("%s is a bot and needs its API key: %w", userID, ErrUnauthorized)
+	}
+	hash, err := globalUserStore.UserTokenHash(userID)
+	if err != nil {
+		return "", fmt.Errorf("look up %s: %w", userID, err)
+	}
+	if hash != "" {
+		return "", fmt.Errorf("%s is registered and needs its token: %w", userID, ErrUnauthorized)
+	}
+	return userID, nil
+}
+
+// requestUserID resolves who a request comes from: a bot by its API key,
+// otherwise the user authenticateUser names. It is "" for an anonymous
+// request without a user_id. s may be nil when bots aren't in use.
+func (s *Server) requestUserID(r *http.Request) (string, error) {
+	if s != nil {
+		if bot, ok := s.botFromRequest(r); ok {
+			if userID := r.URL.Query().Get("user_id"); userID != "" && userID != bot.ID {
+				return "", ErrUnauthorized
+			}
+			return bot.ID, nil
+		}
+	}
+	return authenticateUser(r.URL.Query().Get("user_id"), userTokenFromRequest(r))
+}
+
+// connectUserID resolves the user of a connect request, generating an
+// anonymous ID when there is none. A bot's API key connects as the bot. On
+// failure it has already replied.
+func connectUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, err := globalServer.requestUserID(r)
+	if errors.Is(err, ErrUnauthorized) {
+		http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+		return "", false
+	}
+	if err != nil {
+		log.Printf("Error authenticating connection: %v", err)
+		http.Error(w, "Failed to authenticate", http.StatusInternalServerError)
+		return "", false
+	}
+	if userID == "" {
+		userID = "user_" + uuid.New().String()[:8]
+	}
+	return userID, true
+}
+
+// requestUser resolves whose private data a REST request is about. A
+// registered user must present their token. On failure it has already
+// replied.
+func requestUser(w http.ResponseWriter, r *http.Request, userID string) (string, bool) {
+	userID, err := authenticateUser(userID, userTokenFromRequest(r))
+	if errors.Is(err, ErrUnauthorized) {
+		http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+		return "", false
+	}
+	if err != nil {
+		log.Printf("Error authenticating request: %v", err)
+		http.Error(w, "Failed to authenticate", http.StatusInternalServerError)
+		return "", false
+	}
+	if userID == "" {
+		http.Error(w, "user_id parameter required", http.StatusBadRequest)
+		return "", false
+	}
+	return userID, true
+}
+
+// ClaimUsername records a username and token hash for a user without one
+func (s *InMemoryUserStore) ClaimUsername(userID, username, tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, taken := s.usernames[username]; taken {
+		return ErrUsernameTaken
+	}
+	p, exists := s.profiles[userID]
+	if _, bot := s.bots[userID]; bot || s.erased[userID] || (exists && p.Username != "") {
+		return ErrUserClaimed
+	}
+	if !exists {
+		p = defaultUserProfile(userID)
+		s.profiles[userID] = p
+	}
+	p.Username = username
+	s.usernames[username] = userID
+	s.tokens[tokenHash] = userID
+	s.tokenHashes[userID] = tokenHash
+	return nil
+}
+
+// UserTokenHash returns a registered user's token hash, "" when unclaimed
+func (s *InMemoryUserStore) UserTokenHash(userID string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tokenHashes[userID], nil
+}
+
+// UserIDForTokenHash returns the user a token was issued to, "" when none
+func (s *InMemoryUserStore) UserIDForTokenHash(tokenHash string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tokens[tokenHash], nil
+}
+
+// UserIDForUsername returns who holds a username, "" when it is free
+func (s *InMemoryUserStore) UserIDForUsername(username string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.usernames[username], nil
+}
+
+// setupUsernameRoutes registers username registration and lookup
+func setupUsernameRoutes(mux *http.ServeMux, s *Server) {
+	// POST claims a username, for user_id or a new user, and returns the
+	// token to connect with
+	mux.HandleFunc("/api/users/register", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if globalUserStore == nil {
+			http.Error(w, "User store not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		var req struct {
+			Username string `json:"username"`
+			UserID   string `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		username, err := NormalizeUsername(req.Username)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		p, token, err := s.RegisterUser(req.UserID, username)
+		switch {
+		case errors.Is(err, ErrUsernameTaken):
+			writeJSON(w, http.StatusConflict, map[string]interface{}{
+				"error":       ErrUsernameTaken.Error(),
+				"suggestions": usernameSuggestions(username),
+			})
+			return
+		case errors.Is(err, ErrUserClaimed):
+			http.Error(w, ErrUserClaimed.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			log.Printf("Error registering %s: %v", username, err)
+			http.Error(w, "Failed to register", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
+			"user_id":  p.UserID,
+			"username": p.Username,
+			"token":    token,
+		})
+	})
+
+	// GET returns the profile of whoever holds ?username=
+	mux.HandleFunc("/api/users/lookup", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if globalUserStore == nil {
+			http.Error(w, "User store not available", http.StatusServiceUnavailable)
+			return
+		}
+		username, err := NormalizeUsername(r.URL.Query().Get("username"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		userID, err := globalUserStore.UserIDForUsername(username)
+		if err != nil {
+			log.Printf("Error looking up %s: %v", username, err)
+			http.Error(w, "Failed to look up username", http.StatusInternalServerError)
+			return
+		}
+		if userID == "" {
+			http.Error(w, "Username not found", http.StatusNotFound)
+			return
+		}
+		p, err := s.UserProfile(userID)
+		if err != nil {
+			log.Printf("Error loading profile: %v", err)
+			http.Error(w, "Failed to load profile", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	})
+}
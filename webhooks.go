@@ -0,0 +1,288 @@
+package wssocket
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook is an external endpoint that receives selected messages
+type Webhook struct {
+	ID       string        `json:"id"`
+	URL      string        `json:"url"`
+	Secret   string        `json:"secret"`             // HMAC-SHA256 key for X-Webhook-Signature
+	Types    []MessageType `json:"types,omitempty"`    // Message types to deliver; empty means all
+	Channels []string      `json:"channels,omitempty"` // Channels to deliver; empty means all
+}
+
+// matches reports whether a message passes the webhook's filters
+func (h *Webhook) matches(msg *Message) bool {
+	if len(h.Types) > 0 {
+		found := false
+		for _, t := range h.Types {
+			if t == msg.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(h.Channels) > 0 {
+		found := false
+		for _, ch := range h.Channels {
+			if ch == msg.Channel {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// WebhookConfig controls delivery behaviour
+type WebhookConfig struct {
+	Workers     int           // Concurrent deliveries
+	QueueSize   int           // Pending deliveries before new ones are dead-lettered
+	MaxAttempts int           // Attempts per delivery, including the first
+	Backoff     time.Duration // Delay before the first retry; doubles each attempt
+	Timeout     time.Duration // Per-request timeout
+}
+
+// WebhookDelivery is one message bound for one webhook
+type WebhookDelivery struct {
+	ID        string   `json:"id"`
+	WebhookID string   `json:"webhook_id"`
+	Webhook   *Webhook `json:"-"` // Excluded so dead letters never serialize the secret
+	Message   *Message `json:"message"`
+	Attempts  int      `json:"attempts"`
+}
+
+// WebhookDispatcher POSTs messages to registered webhooks with HMAC
+// signatures, retrying transient failures and dead-lettering the rest
+type WebhookDispatcher struct {
+	mu         sync.RWMutex
+	hooks      map[string]*Webhook
+	config     WebhookConfig
+	client     *http.Client
+	queue      chan *WebhookDelivery
+	done       chan struct{}
+	wg         sync.WaitGroup
+	deadLetter func(*WebhookDelivery, error)
+}
+
+// NewWebhookDispatcher creates a dispatcher and starts its workers
+func NewWebhookDispatcher(config WebhookConfig) *WebhookDispatcher {
+	if config.Workers == 0 {
+		config.Workers = 4
+	}
+	if config.QueueSize == 0 {
+		config.QueueSize = 1000
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 5
+	}
+	if config.Backoff == 0 {
+		config.Backoff = time.Second
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	d := &WebhookDispatcher{
+		hooks:  make(map[string]*Webhook),
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		queue:  make(chan *WebhookDelivery, config.QueueSize),
+		done:   make(chan struct{}),
+	}
+	for i := 0; i < config.Workers; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+	return d
+}
+
+// LoadWebhooksFile reads a JSON array of webhooks
+func LoadWebhooksFile(path string) ([]*Webhook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read webhooks file: %w", err)
+	}
+	var hooks []*Webhook
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("parse webhooks file: %w", err)
+	}
+	return hooks, nil
+}
+
+// Add registers a webhook, assigning an ID when it has none
+func (d *WebhookDispatcher) Add(hook *Webhook) error {
+	if hook.URL == "" {
+		return fmt.Errorf("webhook url is required")
+	}
+	if hook.ID == "" {
+		hook.ID = "wh_" + uuid.New().String()[:8]
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks[hook.ID] = hook
+	return nil
+}
+
+// Remove unregisters a webhook
+func (d *WebhookDispatcher) Remove(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.hooks, id)
+}
+
+// OnDeadLetter sets a callback for deliveries that exhausted their retries.
+// Failures are always logged; the callback lets applications persist them.
+func (d *WebhookDispatcher) OnDeadLetter(fn func(*WebhookDelivery, error)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deadLetter = fn
+}
+
+// Dispatch queues a message for every matching webhook without blocking
+func (d *WebhookDispatcher) Dispatch(msg *Message) {
+	d.mu.RLock()
+	matched := make([]*Webhook, 0)
+	for _, hook := range d.hooks {
+		if hook.matches(msg) {
+			matched = append(matched, hook)
+		}
+	}
+	d.mu.RUnlock()
+
+	for _, hook := range matched {
+		delivery := &WebhookDelivery{
+			ID:        "whd_" + uuid.New().String(),
+			WebhookID: hook.ID,
+			Webhook:   hook,
+			Message:   msg,
+		}
+		select {
+		case d.queue <- delivery:
+		default:
+			d.fail(delivery, fmt.Errorf("webhook queue full"))
+		}
+	}
+}
+
+// Stop stops the workers; queued deliveries are dropped
+func (d *WebhookDispatcher) Stop() {
+	close(d.done)
+	d.wg.Wait()
+}
+
+// worker delivers queued messages until the dispatcher stops
+func (d *WebhookDispatcher) worker() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.done:
+			return
+		case delivery := <-d.queue:
+			d.deliver(delivery)
+		}
+	}
+}
+
+// deliver attempts a delivery with exponential backoff between retries
+func (d *WebhookDispatcher) deliver(delivery *WebhookDelivery) {
+	body, err := json.Marshal(delivery.Message)
+	if err != nil {
+		d.fail(delivery, fmt.Errorf("encode message: %w", err))
+		return
+	}
+
+	backoff := d.config.Backoff
+	for {
+		delivery.Attempts++
+		retry, err := d.post(delivery, body)
+		if err == nil {
+			return
+		}
+		if !retry || delivery.Attempts >= d.config.MaxAttempts {
+			d.fail(delivery, err)
+			return
+		}
+
+		select {
+		case <-d.done:
+			d.fail(delivery, fmt.Errorf("dispatcher stopped: %w", err))
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends one signed request and reports whether a failure is retryable
+func (d *WebhookDispatcher) post(delivery *WebhookDelivery, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("build request: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", delivery.ID)
+	req.Header.Set("X-Webhook-Event", string(delivery.Message.Type))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if delivery.Webhook.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(delivery.Webhook.Secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
+
+// fail logs a dead-lettered delivery and hands it to the callback
+func (d *WebhookDispatcher) fail(delivery *WebhookDelivery, err error) {
+	log.Printf("webhook dead letter: delivery=%s webhook=%s message=%s attempts=%d: %v",
+		delivery.ID, delivery.WebhookID, delivery.Message.ID, delivery.Attempts, err)
+
+	d.mu.RLock()
+	fn := d.deadLetter
+	d.mu.RUnlock()
+	if fn != nil {
+		fn(delivery, err)
+	}
+}
+
+// signWebhook computes the hex HMAC-SHA256 of "<timestamp>.<body>". Receivers
+// should recompute it and reject stale timestamps to prevent replays.
+func signWebhook(secret, timestamp string, body []byte) string {
+	return hex.EncodeToString(hmacSHA256([]byte(secret), timestamp+"."+string(body)))
+}
@@ -0,0 +1,224 @@
+package wssocket
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// messageQueueSize is the total inbound queue capacity shared by all workers
+const messageQueueSize = 10000
+
+// MessagePriority orders the inbound messages waiting for a worker
+type MessagePriority int
+
+// Priority levels, highest first
+const (
+	PriorityHigh MessagePriority = iota
+	PriorityNormal
+	PriorityLow
+
+	priorityLevels = 3
+)
+
+// DefaultMessagePriorities puts alerts and acks ahead of chat traffic and
+// typing indicators behind it. Unlisted types are normal.
+var DefaultMessagePriorities = map[MessageType]MessagePriority{
+	MessageTypeAlert:  PriorityHigh,
+	MessageTypeAck:    PriorityHigh,
+	MessageTypeTyping: PriorityLow,
+}
+
+// String returns the priority's name
+func (p MessagePriority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	default:
+		return "normal"
+	}
+}
+
+// ParseMessagePriority parses high, normal or low
+func ParseMessagePriority(s string) (MessagePriority, error) {
+	switch strings.TrimSpace(s) {
+	case "high":
+		return PriorityHigh, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "low":
+		return PriorityLow, nil
+	default:
+		return PriorityNormal, fmt.Errorf("unknown message priority: %s", s)
+	}
+}
+
+// ParseMessagePriorities parses "type=priority" entries separated by commas,
+// e.g. "alert=high,chat:group=normal,system:typing=low"
+func ParseMessagePriorities(s string) (map[MessageType]MessagePriority, error) {
+	priorities := make(map[MessageType]MessagePriority)
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		msgType, level, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("priority entry must be type=priority: %s", entry)
+		}
+		priority, err := ParseMessagePriority(level)
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority for %s: %w", msgType, err)
+		}
+		priorities[MessageType(msgType)] = priority
+	}
+	return priorities, nil
+}
+
+// newPriorities merges configured priorities over the defaults
+func newPriorities(configured map[MessageType]MessagePriority) map[MessageType]MessagePriority {
+	priorities := make(map[MessageType]MessagePriority, len(DefaultMessagePriorities)+len(configured))
+	for msgType, priority := range DefaultMessagePriorities {
+		priorities[msgType] = priority
+	}
+	for msgType, priority := range configured {
+		priorities[msgType] = priority
+	}
+	return priorities
+}
+
+// SetMessagePriority sets the queue priority for a message type
+func (s *Server) SetMessagePriority(msgType MessageType, priority MessagePriority) error {
+	if priority < PriorityHigh || priority > PriorityLow {
+		return fmt.Errorf("unknown message priority: %d", priority)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.priorities[msgType] = priority
+	return nil
+}
+
+// priorityFor returns a message type's priority; the caller holds s.mu
+func (s *Server) priorityFor(msgType MessageType) MessagePriority {
+	if priority, exists := s.priorities[msgType]; exists {
+		return priority
+	}
+	return PriorityNormal
+}
+
+// workerQueue is one worker's inbound queue, with a channel per priority.
+// Workers always take from the highest non-empty level, so a connection's
+// messages keep their order within a priority but not across them.
+type workerQueue struct {
+	levels [priorityLevels]chan *internalMessage
+}
+
+// newWorkerQueues creates one inbound queue per worker
+func newWorkerQueues(workers int) []*workerQueue {
+	size := max(messageQueueSize/workers, 100)
+	queues := make([]*workerQueue, workers)
+	for i := range queues {
+		q := &workerQueue{}
+		for level := range q.levels {
+			q.levels[level] = make(chan *internalMessage, size)
+		}
+		queues[i] = q
+	}
+	return queues
+}
+
+// push queues a message at its priority, waiting while that level is full
+func (q *workerQueue) push(inMsg *internalMessage, priority MessagePriority) {
+	q.levels[priority] <- inMsg
+}
+
+// next waits for the highest-priority message; ok is false once done closes
+func (q *workerQueue) next(done <-chan struct{}) (inMsg *internalMessage, ok bool) {
+	for _, level := range q.levels {
+		select {
+		case inMsg := <-level:
+			return inMsg, true
+		default:
+		}
+	}
+	select {
+	case <-done:
+		return nil, false
+	case inMsg := <-q.levels[PriorityHigh]:
+		return inMsg, true
+	case inMsg := <-q.levels[PriorityNormal]:
+		return inMsg, true
+	case inMsg := <-q.levels[PriorityLow]:
+		return inMsg, true
+	}
+}
+
+// depth returns how many messages are waiting at a priority
+func (q *workerQueue) depth(priority MessagePriority) int {
+	return len(q.levels[priority])
+}
+
+// len returns how many messages are waiting at any priority
+func (q *workerQueue) len() int {
+	n := 0
+	for _, level := range q.levels {
+		n += len(level)
+	}
+	return n
+}
+
+// capacity returns how many messages one priority level holds
+func (q *workerQueue) capacity() int {
+	return cap(q.levels[PriorityNormal])
+}
+
+// queueFor picks a connection's worker queue. A connection always hashes to
+// the same worker, so its messages are processed in the order they arrived.
+func (s *Server) queueFor(connID string) *workerQueue {
+	h := fnv.New32a()
+	h.Write([]byte(connID))
+	return s.messageQueues[h.Sum32()%uint32(len(s.messageQueues))]
+}
+
+// runWorker processes one worker queue, highest priority first, until the
+// server stops
+func (s *Server) runWorker(queue *workerQueue) {
+	for {
+		inMsg, ok := queue.next(s.done)
+		if !ok {
+			return
+		}
+		startedAt := time.Now()
+		s.metrics.observeQueueWait(startedAt.Sub(inMsg.queuedAt))
+		route, err := s.processMessage(inMsg.conn, inMsg.msg)
+		s.metrics.workerBusy.Add(int64(time.Since(startedAt)))
+		s.ackProcessed(inMsg.conn, inMsg.msg, err)
+		if inMsg.audit != nil {
+			inMsg.audit.record(inMsg.conn, inMsg.msg, auditOutcome(route, err), route, err, inMsg.queuedAt, startedAt)
+		}
+	}
+}
+
+// queueDepthByPriority returns how many messages wait at each priority
+func (s *Server) queueDepthByPriority() map[string]int {
+	depths := make(map[string]int, priorityLevels)
+	for priority := PriorityHigh; priority <= PriorityLow; priority++ {
+		depth := 0
+		for _, queue := range s.messageQueues {
+			depth += queue.depth(priority)
+		}
+		depths[priority.String()] = depth
+	}
+	return depths
+}
+
+// queueDepth returns how many messages are waiting across all workers
+func (s *Server) queueDepth() int {
+	depth := 0
+	for _, queue := range s.messageQueues {
+		depth += queue.len()
+	}
+	return depth
+}
@@ -0,0 +1,78 @@
+package wssockettest_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	ws "github.com/Sirtheprogrammer/go-ws-socket"
+	"github.com/Sirtheprogrammer/go-ws-socket/wssockettest"
+)
+
+func TestGroupChat(t *testing.T) {
+	stack := wssockettest.NewTestStack(t, wssockettest.TestStackOptions{})
+	alice := stack.Connect("alice", nil)
+	bob := stack.Connect("bob", url.Values{"device": {"phone"}})
+	carol := stack.Connect("carol", nil)
+	alice.Join("general")
+	bob.Join("general")
+
+	alice.Send(&ws.Message{Type: ws.MessageTypeChatGroup, Channel: "general", Payload: map[string]interface{}{"text": "hi"}})
+	msg := bob.Expect(ws.MessageTypeChatGroup)
+	if msg.Sender != "alice" || msg.Payload["text"] != "hi" {
+		t.Fatalf("got %s: %v", msg.Sender, msg.Payload)
+	}
+	carol.ExpectNone(ws.MessageTypeChatGroup, 100*time.Millisecond)
+}
+
+func TestPrivateChat(t *testing.T) {
+	stack := wssockettest.NewTestStack(t, wssockettest.TestStackOptions{})
+	alice := stack.Connect("alice", nil)
+	bob := stack.Connect("bob", nil)
+	carol := stack.Connect("carol", nil)
+
+	alice.Send(&ws.Message{Type: ws.MessageTypeChatPrivate, Recipient: "bob", Payload: map[string]interface{}{"text": "psst"}})
+	if msg := bob.Expect(ws.MessageTypeChatPrivate); msg.Payload["text"] != "psst" {
+		t.Fatalf("got %v", msg.Payload)
+	}
+	carol.ExpectNone(ws.MessageTypeChatPrivate, 100*time.Millisecond)
+}
+
+func TestTypedHandler(t *testing.T) {
+	type vote struct {
+		Option string `json:"option"`
+	}
+	stack := wssockettest.NewTestStack(t, wssockettest.TestStackOptions{})
+	votes := make(chan string, 1)
+	ws.RegisterTypedHandler(stack.Server, "poll:vote", func(conn *ws.Connection, v vote) error {
+		votes <- v.Option
+		return nil
+	})
+
+	alice := stack.Connect("alice", nil)
+	alice.Send(&ws.Message{Type: "poll:vote", Payload: map[string]interface{}{"option": "b"}})
+	select {
+	case option := <-votes:
+		if option != "b" {
+			t.Fatalf("got option %q", option)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler not called")
+	}
+
+	alice.Send(&ws.Message{Type: "poll:vote", Payload: map[string]interface{}{"option": 3}})
+	if msg := alice.Expect(ws.MessageTypeError); msg.Payload["code"] != "invalid_payload" {
+		t.Fatalf("got %v", msg.Payload)
+	}
+}
+
+func TestServerFull(t *testing.T) {
+	stack := wssockettest.NewTestStack(t, wssockettest.TestStackOptions{Config: ws.ServerConfig{MaxConnections: 1}})
+	stack.Connect("alice", nil)
+	bob := stack.Connect("bob", nil)
+
+	if msg := bob.Expect(ws.MessageTypeError); msg.Payload["code"] != "server_full" {
+		t.Fatalf("got %v", msg.Payload)
+	}
+	bob.ExpectClose(1013)
+}